@@ -0,0 +1,85 @@
+package checks
+
+import (
+	"strings"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// KvCommandSequence returns the ordered list of commands which a specific
+// client has sent to the mock during this check.
+func (t *T) KvCommandSequence(source mock.KvClient) []memd.CmdCode {
+	clientAddr := source.RemoteAddr().String()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var cmds []memd.CmdCode
+	for _, pak := range t.packets {
+		if pak.WasSent || pak.SrcAddr != clientAddr {
+			continue
+		}
+		if pak.Data.Magic != memd.CmdMagicReq {
+			continue
+		}
+
+		cmds = append(cmds, pak.Data.Command)
+	}
+
+	return cmds
+}
+
+// AssertKvCommandSequence checks that the commands sent by a specific client
+// were exactly those which are specified, in the specified order, and nothing
+// else.
+func (t *T) AssertKvCommandSequence(source mock.KvClient, expected ...memd.CmdCode) bool {
+	actual := t.KvCommandSequence(source)
+
+	if len(actual) != len(expected) || !hasCmdSequencePrefix(actual, expected) {
+		t.Errorf("Client %s sent commands [%s], but expected exactly [%s]",
+			source.RemoteAddr(), formatCmdSequence(actual), formatCmdSequence(expected))
+		return false
+	}
+
+	return true
+}
+
+// AssertKvCommandSequencePrefix checks that the first commands sent by a
+// specific client were exactly those which are specified, in the specified
+// order.  The client may have sent further commands after these, such as the
+// operations which follow a bootstrap.
+func (t *T) AssertKvCommandSequencePrefix(source mock.KvClient, expected ...memd.CmdCode) bool {
+	actual := t.KvCommandSequence(source)
+
+	if !hasCmdSequencePrefix(actual, expected) {
+		t.Errorf("Client %s sent commands [%s], but expected them to begin with [%s]",
+			source.RemoteAddr(), formatCmdSequence(actual), formatCmdSequence(expected))
+		return false
+	}
+
+	return true
+}
+
+// hasCmdSequencePrefix returns whether a sequence of commands begins with the
+// commands of another.
+func hasCmdSequencePrefix(cmds, prefix []memd.CmdCode) bool {
+	if len(cmds) < len(prefix) {
+		return false
+	}
+
+	for cmdIdx, cmd := range prefix {
+		if cmds[cmdIdx] != cmd {
+			return false
+		}
+	}
+	return true
+}
+
+func formatCmdSequence(cmds []memd.CmdCode) string {
+	cmdNames := make([]string, len(cmds))
+	for cmdIdx, cmd := range cmds {
+		cmdNames[cmdIdx] = cmd.Name()
+	}
+	return strings.Join(cmdNames, ", ")
+}
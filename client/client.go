@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"time"
 )
 
@@ -182,11 +183,36 @@ type CreateClusterResult struct {
 	ManagementAddrs []string
 }
 
+// CreateClusterOptions specifies additional options for creating a cluster.
+type CreateClusterOptions struct {
+	// Deterministic makes the vbucket UUIDs, and therefore the mutation
+	// tokens, of the cluster identical between runs.
+	Deterministic bool
+
+	// Edition is the edition of the server to emulate, either enterprise or
+	// community.  The enterprise edition is used if this is blank.
+	Edition string
+
+	// Version is the version of the server to emulate, such as 7.2, which
+	// determines the features which the cluster supports.  The latest version
+	// is used if this is blank.
+	Version string
+}
+
 // CreateCluster instantiates a new CAVES test cluster.
 func (c *Client) CreateCluster(clusterID string) (*CreateClusterResult, error) {
+	return c.CreateClusterWithOptions(clusterID, CreateClusterOptions{})
+}
+
+// CreateClusterWithOptions instantiates a new CAVES test cluster using the
+// specified options.
+func (c *Client) CreateClusterWithOptions(clusterID string, opts CreateClusterOptions) (*CreateClusterResult, error) {
 	resp, err := c.roundTripCommand(map[string]interface{}{
-		"type": "createcluster",
-		"id":   clusterID,
+		"type":          "createcluster",
+		"id":            clusterID,
+		"deterministic": opts.Deterministic,
+		"edition":       opts.Edition,
+		"version":       opts.Version,
 	})
 	if err != nil {
 		return nil, err
@@ -304,3 +330,1503 @@ func (c *Client) AddBucketCluster(clusterID string, name, typ string, replicas u
 	})
 	return err
 }
+
+// SetServerGroupCluster moves a node of a specific cluster into a server group.
+func (c *Client) SetServerGroupCluster(clusterID string, nodeIdx int, group string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "setservergroup",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+		"group":    group,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// AddNodeCluster adds a node running a specific set of services to a specific
+// cluster, returning the index of the new node.  The node runs every service
+// if none are listed, and nodes without kv never hold any vbuckets.
+func (c *Client) AddNodeCluster(clusterID string, services []string, group string) (int, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "addnode",
+		"cluster":  clusterID,
+		"services": services,
+		"group":    group,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, errors.New(errStr)
+	}
+
+	nodeIdx, ok := resp["node_idx"].(float64)
+	if !ok {
+		return 0, errors.New("invalid add node response")
+	}
+	return int(nodeIdx), nil
+}
+
+// FailoverNodeCluster fails over a node of a specific cluster, promoting
+// replicas in its place.  Writes not yet replicated to them are lost.
+func (c *Client) FailoverNodeCluster(clusterID string, nodeIdx int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "failovernode",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// RebalanceCluster rebalances a specific cluster, returning any failed over
+// nodes to it and spreading the vbuckets across all of its kv nodes.
+func (c *Client) RebalanceCluster(clusterID string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "rebalance",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// EvacuateNodeCluster moves every vbucket of a bucket off of a node of a
+// specific cluster, leaving the bucket open on the node without any vbuckets.
+func (c *Client) EvacuateNodeCluster(clusterID, bucketName string, nodeIdx int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "evacuatenode",
+		"cluster":  clusterID,
+		"bucket":   bucketName,
+		"node_idx": nodeIdx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// PauseNodeCluster stops a node of a specific cluster from processing any
+// requests, which are held until ResumeNodeCluster is called.
+func (c *Client) PauseNodeCluster(clusterID string, nodeIdx int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "pausenode",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetHLCDriftCluster makes the hybrid logical clock of a bucket in a specific
+// cluster drift from the cluster time, skewing the CAS of its mutations.
+func (c *Client) SetHLCDriftCluster(clusterID, bucketName string, drift time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "sethlcdrift",
+		"cluster":  clusterID,
+		"bucket":   bucketName,
+		"drift_ms": drift.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetSASLMechanismsCluster makes the kv service of a specific cluster offer
+// and accept only the given SASL mechanisms, such as PLAIN or SCRAM-SHA512,
+// regardless of the version of the server which it emulates.  Passing no
+// mechanisms restores those of the emulated version.
+func (c *Client) SetSASLMechanismsCluster(clusterID string, mechs []string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setsaslmechs",
+		"cluster":    clusterID,
+		"mechanisms": mechs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetDocumentLimitsCluster makes the sub-document operations of a bucket in a
+// specific cluster reject documents nested deeper than maxDepth, allowing the
+// depth limit errors to be triggered without genuinely huge documents.  strict
+// also rejects full document writes which are nested too deeply.
+func (c *Client) SetDocumentLimitsCluster(clusterID, bucketName string, maxDepth int, strict bool) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":      "setdocumentlimits",
+		"cluster":   clusterID,
+		"bucket":    bucketName,
+		"max_depth": maxDepth,
+		"strict":    strict,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetClockSkewCluster skews the clock of a node of a specific cluster, so the
+// mutations which it performs are given a CAS from the past or the future.
+func (c *Client) SetClockSkewCluster(clusterID string, nodeIdx int, skew time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "setclockskew",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+		"skew_ms":  skew.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetMemoryPressureCluster makes a node of a specific cluster simulate using a
+// percentage of the memory quota of its buckets.  Raising it above the high
+// watermark temporarily fails a growing fraction of writes, until at 100 all
+// writes fail as out of memory.  Lowering it restores normal operation.
+func (c *Client) SetMemoryPressureCluster(clusterID string, nodeIdx int, percent int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "setmemorypressure",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+		"percent":  percent,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetManifestStaggerCluster makes the nodes of a specific cluster adopt changes
+// to the collection manifest of a bucket one after another, each lagging the
+// previous node by the stagger.
+func (c *Client) SetManifestStaggerCluster(clusterID, bucketName string, stagger time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setmanifeststagger",
+		"cluster":    clusterID,
+		"bucket":     bucketName,
+		"stagger_ms": stagger.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetRangeScanIdleTimeoutCluster sets how long range scans against a bucket of
+// a specific cluster may go without being continued before they are cancelled.
+func (c *Client) SetRangeScanIdleTimeoutCluster(clusterID, bucketName string, timeout time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setrangescanidletimeout",
+		"cluster":    clusterID,
+		"bucket":     bucketName,
+		"timeout_ms": timeout.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+
+	return nil
+}
+
+// Warning is a single warning which is injected into, or was recorded
+// against, an otherwise successful operation.  Query warnings carry a code,
+// while subdoc warnings carry the key of the document.
+type Warning struct {
+	Code int
+	Key  string
+	Msg  string
+}
+
+// SetQueryWarningsCluster makes every successful query made to a specific
+// cluster return a set of warnings alongside its results.  Passing no
+// warnings stops any from being returned.
+func (c *Client) SetQueryWarningsCluster(clusterID string, warnings []Warning) error {
+	jsonWarnings := make([]map[string]interface{}, 0, len(warnings))
+	for _, warning := range warnings {
+		jsonWarnings = append(jsonWarnings, map[string]interface{}{
+			"code": warning.Code,
+			"msg":  warning.Msg,
+		})
+	}
+
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "setquerywarnings",
+		"cluster":  clusterID,
+		"warnings": jsonWarnings,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetSubDocWarningCluster makes a specific cluster record a note whenever a
+// subdoc operation against a document with a specific key succeeds.  The kv
+// protocol cannot carry warnings, so the notes can only be retrieved with
+// SubDocWarningsCluster.  Passing an empty message stops any being recorded.
+func (c *Client) SetSubDocWarningCluster(clusterID, key, msg string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setsubdocwarning",
+		"cluster": clusterID,
+		"key":     key,
+		"msg":     msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SubDocWarningsCluster returns the notes which a specific cluster has
+// recorded against successful subdoc operations, in the order they completed.
+func (c *Client) SubDocWarningsCluster(clusterID string) ([]Warning, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getsubdocwarnings",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return nil, errors.New(errStr)
+	}
+
+	jsonWarnings, ok := resp["warnings"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid subdoc warnings response")
+	}
+
+	warnings := make([]Warning, 0, len(jsonWarnings))
+	for _, jsonWarning := range jsonWarnings {
+		warningMap, ok := jsonWarning.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid subdoc warnings response")
+		}
+
+		key, _ := warningMap["key"].(string)
+		msg, _ := warningMap["msg"].(string)
+		warnings = append(warnings, Warning{
+			Key: key,
+			Msg: msg,
+		})
+	}
+	return warnings, nil
+}
+
+// NmvbStats describes how a single kv client has reacted to the
+// NOT_MY_VBUCKET errors which it has been sent.  ConfigFetches only counts the
+// config fetches which followed an error, and FetchRate is the number of those
+// fetches made for each error.
+type NmvbStats struct {
+	Client          string
+	NotMyVbuckets   uint64
+	EmbeddedConfigs uint64
+	ConfigFetches   uint64
+	FetchRate       float64
+}
+
+// SetNmvbConfigOncePerRevCluster makes a specific cluster embed its config
+// into the NOT_MY_VBUCKET errors sent to each client only once per revision,
+// even if the client has not negotiated deduplication.
+func (c *Client) SetNmvbConfigOncePerRevCluster(clusterID string, enabled bool) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setnmvbconfigonce",
+		"cluster": clusterID,
+		"enabled": enabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// NmvbStatsCluster returns the statistics of every kv client which a specific
+// cluster has sent a NOT_MY_VBUCKET error to.
+func (c *Client) NmvbStatsCluster(clusterID string) ([]NmvbStats, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getnmvbstats",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return nil, errors.New(errStr)
+	}
+
+	jsonClients, ok := resp["clients"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid nmvb stats response")
+	}
+
+	stats := make([]NmvbStats, 0, len(jsonClients))
+	for _, jsonClient := range jsonClients {
+		clientMap, ok := jsonClient.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid nmvb stats response")
+		}
+
+		client, _ := clientMap["client"].(string)
+		nmvbs, _ := clientMap["nmvbs"].(float64)
+		embeddedConfigs, _ := clientMap["embedded_configs"].(float64)
+		configFetches, _ := clientMap["config_fetches"].(float64)
+		fetchRate, _ := clientMap["fetch_rate"].(float64)
+		stats = append(stats, NmvbStats{
+			Client:          client,
+			NotMyVbuckets:   uint64(nmvbs),
+			EmbeddedConfigs: uint64(embeddedConfigs),
+			ConfigFetches:   uint64(configFetches),
+			FetchRate:       fetchRate,
+		})
+	}
+	return stats, nil
+}
+
+// Task is a task which is reported by the /pools/default/tasks endpoint of a
+// cluster without being simulated by it, such as an XDCR replication.
+type Task struct {
+	ID       string
+	Type     string
+	Subtype  string
+	Status   string
+	Bucket   string
+	Progress int
+}
+
+// SetTaskCluster makes a specific cluster report a task alongside the tasks
+// which it simulates itself.  Setting a task with the id of an existing task
+// updates it, and a rebalance task replaces the rebalance which is reported
+// when no graceful failover is running.
+func (c *Client) SetTaskCluster(clusterID string, task Task) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":      "settask",
+		"cluster":   clusterID,
+		"id":        task.ID,
+		"task_type": task.Type,
+		"subtype":   task.Subtype,
+		"status":    task.Status,
+		"bucket":    task.Bucket,
+		"progress":  task.Progress,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// RemoveTaskCluster stops a specific cluster reporting a task which was set
+// using SetTaskCluster.
+func (c *Client) RemoveTaskCluster(clusterID, taskID string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "removetask",
+		"cluster": clusterID,
+		"id":      taskID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetAuthStaleCluster marks the authentication of the kv connections to a
+// specific cluster as stale, so that their data operations fail with an auth
+// stale status until they authenticate again.  An empty user or client
+// address matches every connection, otherwise only the connections of that
+// user, or with that remote address, are marked.  It returns the number of
+// connections which were marked.
+func (c *Client) SetAuthStaleCluster(clusterID, user, clientAddr string) (int, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":        "setauthstale",
+		"cluster":     clusterID,
+		"user":        user,
+		"client_addr": clientAddr,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, errors.New(errStr)
+	}
+
+	count, ok := resp["count"].(float64)
+	if !ok {
+		return 0, errors.New("invalid auth stale response")
+	}
+	return int(count), nil
+}
+
+// AuditEvent is a single event which was written to the audit log of a
+// cluster.  The timestamp is formatted as RFC3339.
+type AuditEvent struct {
+	ID        int
+	Name      string
+	Timestamp string
+	User      string
+	Remote    string
+	Fields    map[string]string
+}
+
+// AuditEventsCluster returns the events which have been written to the audit
+// log of a specific cluster while auditing was enabled through its
+// /settings/audit endpoint, in the order they were recorded.
+func (c *Client) AuditEventsCluster(clusterID string) ([]AuditEvent, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getauditevents",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return nil, errors.New(errStr)
+	}
+
+	jsonEvents, ok := resp["events"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid audit events response")
+	}
+
+	events := make([]AuditEvent, 0, len(jsonEvents))
+	for _, jsonEvent := range jsonEvents {
+		eventMap, ok := jsonEvent.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid audit events response")
+		}
+
+		id, _ := eventMap["id"].(float64)
+		name, _ := eventMap["name"].(string)
+		timestamp, _ := eventMap["timestamp"].(string)
+		user, _ := eventMap["user"].(string)
+		remote, _ := eventMap["remote"].(string)
+
+		fields := make(map[string]string)
+		if fieldsMap, ok := eventMap["fields"].(map[string]interface{}); ok {
+			for field, value := range fieldsMap {
+				fields[field], _ = value.(string)
+			}
+		}
+
+		events = append(events, AuditEvent{
+			ID:        int(id),
+			Name:      name,
+			Timestamp: timestamp,
+			User:      user,
+			Remote:    remote,
+			Fields:    fields,
+		})
+	}
+	return events, nil
+}
+
+// SetQueryIndexStateCluster registers a GSI index on a bucket of a specific
+// cluster in a particular state, either "building" or "ready".  Queries which
+// name an index which is building fail with a transient index not ready error
+// until its state is set to ready.
+func (c *Client) SetQueryIndexStateCluster(clusterID, bucketName, indexName, state string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setqueryindexstate",
+		"cluster": clusterID,
+		"bucket":  bucketName,
+		"index":   indexName,
+		"state":   state,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// TLSConnection describes the parameters negotiated by the TLS handshake of
+// a single connection to a cluster.  Version and CipherSuite hold the values
+// of the tls.VersionTLS and tls.TLS_ constants.
+type TLSConnection struct {
+	Service            string
+	LocalAddr          string
+	RemoteAddr         string
+	Version            uint16
+	CipherSuite        uint16
+	ServerName         string
+	NegotiatedProtocol string
+}
+
+// TLSConnectionsCluster returns the parameters negotiated by every TLS
+// connection which has been made to a specific cluster, in the order their
+// handshakes completed.
+func (c *Client) TLSConnectionsCluster(clusterID string) ([]TLSConnection, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "gettlsconnections",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return nil, errors.New(errStr)
+	}
+
+	jsonConns, ok := resp["connections"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid tls connections response")
+	}
+
+	conns := make([]TLSConnection, 0, len(jsonConns))
+	for _, jsonConn := range jsonConns {
+		connMap, ok := jsonConn.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid tls connections response")
+		}
+
+		service, _ := connMap["service"].(string)
+		localAddr, _ := connMap["local_addr"].(string)
+		remoteAddr, _ := connMap["remote_addr"].(string)
+		version, _ := connMap["version"].(float64)
+		cipherSuite, _ := connMap["cipher_suite"].(float64)
+		serverName, _ := connMap["server_name"].(string)
+		negotiatedProtocol, _ := connMap["alpn"].(string)
+
+		conns = append(conns, TLSConnection{
+			Service:            service,
+			LocalAddr:          localAddr,
+			RemoteAddr:         remoteAddr,
+			Version:            uint16(version),
+			CipherSuite:        uint16(cipherSuite),
+			ServerName:         serverName,
+			NegotiatedProtocol: negotiatedProtocol,
+		})
+	}
+	return conns, nil
+}
+
+// SetKvConnectionLimitCluster sets a soft limit on the number of kv
+// connections which each node of a specific cluster serves for either a
+// bucket or a user.  Connections beyond the limit stay open, but their
+// operations fail as busy, with the error map advertising how to retry them.
+// A limit of zero removes the limit.
+func (c *Client) SetKvConnectionLimitCluster(clusterID, bucketName, user string, limit int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setkvconnlimit",
+		"cluster": clusterID,
+		"bucket":  bucketName,
+		"user":    user,
+		"limit":   limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// KvConnectionLimitStats is the number of operations which were rejected
+// because the connection limit of a bucket or user was exceeded.
+type KvConnectionLimitStats struct {
+	Bucket   string
+	User     string
+	Rejected uint64
+}
+
+// KvConnectionLimitStatsCluster returns the number of operations which a
+// specific cluster has rejected for each connection limit which was exceeded.
+func (c *Client) KvConnectionLimitStatsCluster(clusterID string) ([]KvConnectionLimitStats, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getkvconnlimitstats",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return nil, errors.New(errStr)
+	}
+
+	jsonLimits, ok := resp["limits"].([]interface{})
+	if !ok {
+		return nil, errors.New("invalid kv conn limit stats response")
+	}
+
+	stats := make([]KvConnectionLimitStats, 0, len(jsonLimits))
+	for _, jsonLimit := range jsonLimits {
+		limitMap, ok := jsonLimit.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid kv conn limit stats response")
+		}
+
+		bucketName, _ := limitMap["bucket"].(string)
+		user, _ := limitMap["user"].(string)
+		rejected, _ := limitMap["rejected"].(float64)
+
+		stats = append(stats, KvConnectionLimitStats{
+			Bucket:   bucketName,
+			User:     user,
+			Rejected: uint64(rejected),
+		})
+	}
+	return stats, nil
+}
+
+// ResponseLeaksCluster returns the number of kv responses which a specific
+// cluster wrote to a connection that never sent their request, which should
+// always be zero, along with the number of responses it discarded because the
+// connection their request arrived on had closed.  Together they verify that
+// responses to requests made before a reconnect are never delivered on the
+// new connection.
+func (c *Client) ResponseLeaksCluster(clusterID string) (uint64, uint64, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getresponseleaks",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, 0, errors.New(errStr)
+	}
+
+	leaked, ok := resp["leaked"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid response leaks response")
+	}
+	dropped, ok := resp["dropped"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid response leaks response")
+	}
+	return uint64(leaked), uint64(dropped), nil
+}
+
+// SetSyncWriteDurationCluster sets how long durable writes against a bucket of
+// a specific cluster stay pending before they commit.  Other writes to a key
+// are rejected with sync write in progress while it has a pending write.
+func (c *Client) SetSyncWriteDurationCluster(clusterID, bucketName string, duration time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":        "setsyncwriteduration",
+		"cluster":     clusterID,
+		"bucket":      bucketName,
+		"duration_ms": duration.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+
+	return nil
+}
+
+// ChaosMode configures the randomized faults which are injected into the
+// requests made against a cluster.  Each probability applies to a single
+// request and is scaled by the intensity, which is treated as 1 if it is
+// zero.  The same seed always yields the same chaos for the same sequence of
+// requests.
+type ChaosMode struct {
+	Seed                   int64
+	Intensity              float64
+	LatencyProbability     float64
+	LatencyP50             time.Duration
+	LatencyP99             time.Duration
+	LatencyP999            time.Duration
+	ErrorProbability       float64
+	DisconnectProbability  float64
+	ConfigChurnProbability float64
+}
+
+// SetChaosModeCluster enables chaos mode on a specific cluster, replacing any
+// chaos which was already enabled.
+func (c *Client) SetChaosModeCluster(clusterID string, chaos ChaosMode) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":                     "setchaosmode",
+		"cluster":                  clusterID,
+		"enabled":                  true,
+		"seed":                     chaos.Seed,
+		"intensity":                chaos.Intensity,
+		"latency_probability":      chaos.LatencyProbability,
+		"latency_p50_ms":           chaos.LatencyP50.Milliseconds(),
+		"latency_p99_ms":           chaos.LatencyP99.Milliseconds(),
+		"latency_p999_ms":          chaos.LatencyP999.Milliseconds(),
+		"error_probability":        chaos.ErrorProbability,
+		"disconnect_probability":   chaos.DisconnectProbability,
+		"config_churn_probability": chaos.ConfigChurnProbability,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// DisableChaosModeCluster stops injecting chaos into a specific cluster.
+func (c *Client) DisableChaosModeCluster(clusterID string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setchaosmode",
+		"cluster": clusterID,
+		"enabled": false,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// ResumeNodeCluster releases the requests held by a paused node of a specific
+// cluster, and allows it to continue processing requests.
+func (c *Client) ResumeNodeCluster(clusterID string, nodeIdx int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "resumenode",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetClusterCapabilitiesCluster replaces the capabilities advertised in the
+// configs of a specific cluster.  Passing nil restores the defaults.
+func (c *Client) SetClusterCapabilitiesCluster(clusterID string, caps map[string][]string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":         "setclustercaps",
+		"cluster":      clusterID,
+		"capabilities": caps,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// ProtocolVersion returns the version of the command protocol spoken by CAVES.
+func (c *Client) ProtocolVersion() (int, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type": "getversion",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, errors.New(errStr)
+	}
+
+	version, ok := resp["version"].(float64)
+	if !ok {
+		return 0, errors.New("invalid version response")
+	}
+	return int(version), nil
+}
+
+// SeedDocumentsCluster stores a set of JSON documents, keyed by their document
+// keys, into a collection of a bucket of a specific cluster.  Empty scope and
+// collection names refer to the default collection.
+func (c *Client) SeedDocumentsCluster(clusterID, bucket, scope, collection string,
+	docs map[string]json.RawMessage) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "seeddocs",
+		"cluster":    clusterID,
+		"bucket":     bucket,
+		"scope":      scope,
+		"collection": collection,
+		"docs":       docs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// BumpConfigRevCluster increases the config revision of a specific cluster
+// without changing anything else, forcing clients to pick up a "new" config.
+func (c *Client) BumpConfigRevCluster(clusterID string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "bumpconfigrev",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetKvOrphanTimeoutCluster makes a specific cluster count the kv responses
+// which it sends for requests that have been outstanding for longer than the
+// timeout as orphaned.  The timeout should match the one used by the client,
+// and a zero timeout stops responses being counted.
+func (c *Client) SetKvOrphanTimeoutCluster(clusterID string, timeout time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setkvorphantimeout",
+		"cluster":    clusterID,
+		"timeout_ms": timeout.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// OrphanedResponsesCluster returns the number of kv responses which a specific
+// cluster has counted as orphaned, for comparison with the orphaned responses
+// which the client reported.
+func (c *Client) OrphanedResponsesCluster(clusterID string) (uint64, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "getorphanedresponses",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, errors.New(errStr)
+	}
+
+	count, ok := resp["count"].(float64)
+	if !ok {
+		return 0, errors.New("invalid orphaned responses response")
+	}
+	return uint64(count), nil
+}
+
+// SetConfigScenarioCluster makes a specific cluster generate its configs in
+// the shape of a named scenario which is known to have broken SDKs, such as
+// "missingkvport", "zeroreplicas", "reorderedserverlist" or "ipv6hostnames".
+// Passing an empty scenario restores normal configs.
+func (c *Client) SetConfigScenarioCluster(clusterID, scenario string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "setconfigscenario",
+		"cluster":  clusterID,
+		"scenario": scenario,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// AddTrustedCACluster makes a specific cluster trust client certificates
+// signed by a PEM encoded CA certificate.
+func (c *Client) AddTrustedCACluster(clusterID string, certPem []byte) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "addtrustedca",
+		"cluster": clusterID,
+		"cert":    string(certPem),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetKvHangCluster makes requests for a kv command on a node of a specific
+// cluster hang instead of being processed.  The action is either noresponse,
+// to never respond, or close, to close the connection once the timeout has
+// elapsed.  Passing an empty action removes the hang.
+func (c *Client) SetKvHangCluster(clusterID string, nodeIdx int, command uint8, action string,
+	timeout time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setkvhang",
+		"cluster":    clusterID,
+		"node_idx":   nodeIdx,
+		"command":    command,
+		"action":     action,
+		"timeout_ms": timeout.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// KvTraceResponse is a single response of a captured kv packet trace.
+type KvTraceResponse struct {
+	Command  uint8
+	Status   uint16
+	Datatype uint8
+	Cas      uint64
+	Key      []byte
+	Extras   []byte
+	Value    []byte
+
+	// Delay is how long to wait after the request arrives before sending
+	// the response.
+	Delay time.Duration
+}
+
+// ReplayKvTraceCluster makes the next connection to the kv service of a node
+// of a specific cluster answer its requests with the responses of a captured
+// trace, in order, regardless of what is requested.  Requests which arrive
+// once the trace is exhausted are processed normally.  Passing an empty trace
+// cancels a trace which has not yet been picked up by a connection.
+func (c *Client) ReplayKvTraceCluster(clusterID string, nodeIdx int, trace []KvTraceResponse) error {
+	responses := make([]map[string]interface{}, 0, len(trace))
+	for _, resp := range trace {
+		responses = append(responses, map[string]interface{}{
+			"command":      resp.Command,
+			"status":       resp.Status,
+			"datatype":     resp.Datatype,
+			"casHex":       strconv.FormatUint(resp.Cas, 16),
+			"keyBase64":    resp.Key,
+			"extrasBase64": resp.Extras,
+			"valueBase64":  resp.Value,
+			"delay_ms":     resp.Delay.Milliseconds(),
+		})
+	}
+
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":      "replaykvtrace",
+		"cluster":   clusterID,
+		"node_idx":  nodeIdx,
+		"responses": responses,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// ChangeNodeAddressCluster makes a node of a specific cluster advertise a new
+// hostname and move its services to new ports, breaking any existing
+// connections, as if the node had been restarted with a new IP.
+func (c *Client) ChangeNodeAddressCluster(clusterID string, nodeIdx int, hostname string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":     "changenodeaddress",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+		"hostname": hostname,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetThrottleWarningCluster marks a user as approaching the throttling limits
+// of a bucket in a specific cluster, so that clients which negotiated
+// non-blocking throttling are told their requests would be throttled.  An
+// empty user applies to every user of the bucket.
+func (c *Client) SetThrottleWarningCluster(clusterID, bucketName, user string, enabled bool) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setthrottlewarning",
+		"cluster": clusterID,
+		"bucket":  bucketName,
+		"user":    user,
+		"enabled": enabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetKvLatencyCluster makes requests for a kv command on a node of a specific
+// cluster wait for a latency sampled from the given percentiles.  Passing all
+// zero percentiles removes the latency.  A non-nil seed reseeds the random
+// source of the node so that the sampled latencies are reproducible.
+func (c *Client) SetKvLatencyCluster(clusterID string, nodeIdx int, command uint8,
+	p50, p99, p999 time.Duration, seed *int64) error {
+	req := map[string]interface{}{
+		"type":     "setkvlatency",
+		"cluster":  clusterID,
+		"node_idx": nodeIdx,
+		"command":  command,
+		"p50_ms":   p50.Milliseconds(),
+		"p99_ms":   p99.Milliseconds(),
+		"p999_ms":  p999.Milliseconds(),
+	}
+	if seed != nil {
+		req["seed"] = *seed
+	}
+
+	resp, err := c.roundTripCommand(req)
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetHTTPBusyCluster makes the next count requests to an endpoint of a service
+// of a specific cluster fail with a 503 and a Retry-After header.  Service is
+// one of mgmt, query, analytics, search or views, and an empty method matches
+// requests with any method.
+func (c *Client) SetHTTPBusyCluster(clusterID, service, method, path string, count int,
+	retryAfter time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":            "sethttpbusy",
+		"cluster":         clusterID,
+		"service":         service,
+		"method":          method,
+		"path":            path,
+		"count":           count,
+		"retry_after_sec": int(retryAfter / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetQueryRowHookCluster makes the next query to a specific cluster return
+// rows generated rows, and perform an action on the cluster once afterRows of
+// them have been received, while the rest are still to be streamed.  Action is
+// either failover, which fails over the node at nodeIdx, or bumpconfigrev.
+func (c *Client) SetQueryRowHookCluster(clusterID string, rows, afterRows int, action string, nodeIdx int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "setqueryrowhook",
+		"cluster":    clusterID,
+		"rows":       rows,
+		"after_rows": afterRows,
+		"action":     action,
+		"node_idx":   nodeIdx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetCompactionStepsCluster makes compactions of a bucket of a specific cluster
+// take a number of steps to complete, each driven by StepCompactionCluster.
+// Zero steps causes compactions to complete immediately.
+func (c *Client) SetCompactionStepsCluster(clusterID, bucket string, steps int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setcompactionsteps",
+		"cluster": clusterID,
+		"bucket":  bucket,
+		"steps":   steps,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// StepCompactionCluster advances the running compaction of a bucket of a
+// specific cluster by a single step.
+func (c *Client) StepCompactionCluster(clusterID, bucket string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "stepcompaction",
+		"cluster": clusterID,
+		"bucket":  bucket,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetPauseStepsCluster makes pausing and resuming a bucket of a specific
+// cluster take a number of steps to complete, each driven by StepPauseCluster.
+// Zero steps causes them to complete immediately.
+func (c *Client) SetPauseStepsCluster(clusterID, bucket string, steps int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setpausesteps",
+		"cluster": clusterID,
+		"bucket":  bucket,
+		"steps":   steps,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// StepPauseCluster advances the running pause or resume of a bucket of a
+// specific cluster by a single step.
+func (c *Client) StepPauseCluster(clusterID, bucket string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "steppause",
+		"cluster": clusterID,
+		"bucket":  bucket,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetFailoverStepsCluster makes graceful failovers of a specific cluster take
+// a number of steps to drain the node, each driven by StepFailoverCluster.
+// Zero steps causes graceful failovers to complete immediately.
+func (c *Client) SetFailoverStepsCluster(clusterID string, steps int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setfailoversteps",
+		"cluster": clusterID,
+		"steps":   steps,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetMaxBucketCountCluster makes the bucket management endpoints of a specific
+// cluster refuse to create more than a number of buckets, failing with the
+// same error as the server does once the limit is reached.
+func (c *Client) SetMaxBucketCountCluster(clusterID string, count int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setmaxbucketcount",
+		"cluster": clusterID,
+		"count":   count,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetNodeMemoryCluster makes every node of a specific cluster report having a
+// specific amount of RAM, in megabytes, which the service memory quotas are
+// validated against.  A size of zero restores the default.
+func (c *Client) SetNodeMemoryCluster(clusterID string, memoryMB uint64) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":      "setnodememory",
+		"cluster":   clusterID,
+		"memory_mb": memoryMB,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// StepFailoverCluster advances the running graceful failover of a specific
+// cluster by a single step.
+func (c *Client) StepFailoverCluster(clusterID string) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "stepfailover",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// DiscardMutationsCluster removes all mutations above a seqno from a vbucket
+// of a specific cluster and starts a new failover log entry, so that DCP
+// consumers beyond that point are told to rollback.
+func (c *Client) DiscardMutationsCluster(clusterID, bucket string, vbucket uint, seqNo uint64) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "discardmutations",
+		"cluster": clusterID,
+		"bucket":  bucket,
+		"vbucket": vbucket,
+		"seqno":   seqNo,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetReplicaLagCluster makes a replica of a vbucket of a specific cluster lag
+// behind the master by a number of seqnos and a latency, so that replica reads
+// and observes against it see correspondingly older data.  Replicas are
+// numbered from 0.
+func (c *Client) SetReplicaLagCluster(clusterID, bucket string, vbucket, replicaIdx uint, lagSeqNos uint64,
+	latency time.Duration) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":        "setreplicalag",
+		"cluster":     clusterID,
+		"bucket":      bucket,
+		"vbucket":     vbucket,
+		"replica_idx": replicaIdx,
+		"lag_seqnos":  lagSeqNos,
+		"lag_ms":      latency.Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// MarkMutationUnreplicatedCluster marks a mutation of a document in a specific
+// cluster as never having reached any replica, so that it is lost when a
+// failover promotes a replica.  A seqno of zero marks the latest mutation of
+// the document.  Returns the seqno of the mutation which was marked.
+func (c *Client) MarkMutationUnreplicatedCluster(clusterID, bucket, scope, collection, key string,
+	seqNo uint64) (uint64, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "markunreplicated",
+		"cluster":    clusterID,
+		"bucket":     bucket,
+		"scope":      scope,
+		"collection": collection,
+		"key":        key,
+		"seqno":      seqNo,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return 0, errors.New(errStr)
+	}
+
+	markedSeqNo, ok := resp["seqno"].(float64)
+	if !ok {
+		return 0, errors.New("invalid mark unreplicated response")
+	}
+	return uint64(markedSeqNo), nil
+}
+
+// ResetReplicaLagCluster restores the default lag of a replica of a vbucket
+// of a specific cluster.
+func (c *Client) ResetReplicaLagCluster(clusterID, bucket string, vbucket, replicaIdx uint) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":        "setreplicalag",
+		"cluster":     clusterID,
+		"bucket":      bucket,
+		"vbucket":     vbucket,
+		"replica_idx": replicaIdx,
+		"reset":       true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// SetVbucketMapCluster places the copies of each vbucket of a bucket on
+// specific nodes of a specific cluster.  Each vbucket lists the node indexes of
+// its master and replicas, using -1 for a copy which no node holds.
+func (c *Client) SetVbucketMapCluster(clusterID, bucket string, vbMap [][]int) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "setvbmap",
+		"cluster": clusterID,
+		"bucket":  bucket,
+		"vbmap":   vbMap,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
+
+// CorruptDocumentCluster overwrites the raw stored value and datatype of a
+// document in a specific cluster, bypassing all validation.
+func (c *Client) CorruptDocumentCluster(clusterID, bucket, scope, collection, key string,
+	value []byte, datatype uint8) error {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":       "corruptdoc",
+		"cluster":    clusterID,
+		"bucket":     bucket,
+		"scope":      scope,
+		"collection": collection,
+		"key":        key,
+		"value":      value,
+		"datatype":   datatype,
+	})
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := resp["error"].(string); ok && errStr != "" {
+		return errors.New(errStr)
+	}
+	return nil
+}
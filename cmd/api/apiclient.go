@@ -55,7 +55,7 @@ func ConnectAsServer(opts ConnectAsServerOptions) (*Client, error) {
 		closeCh: make(chan struct{}, 1),
 	}
 
-	err = cli.writePacket(&CmdHello{})
+	err = cli.writePacket(&CmdHello{Version: ProtocolVersion})
 	if err != nil {
 		return nil, err
 	}
@@ -68,16 +68,18 @@ func ConnectAsServer(opts ConnectAsServerOptions) (*Client, error) {
 	return cli, nil
 }
 
-func (c *Client) readPacket() (interface{}, error) {
+func (c *Client) readPacket() ([]byte, error) {
 	pktBytes, err := c.reader.ReadSlice(0)
 	if err != nil {
 		return nil, err
 	}
 
-	pktBytes = pktBytes[:len(pktBytes)-1]
+	return pktBytes[:len(pktBytes)-1], nil
+}
 
+func (c *Client) decodePacket(pktBytes []byte) (interface{}, error) {
 	var pkt cmdDecoder
-	err = json.Unmarshal(pktBytes, &pkt)
+	err := json.Unmarshal(pktBytes, &pkt)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +107,7 @@ func (c *Client) start() error {
 	go func() {
 
 		for {
-			pkt, err := c.readPacket()
+			pktBytes, err := c.readPacket()
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					break
@@ -115,11 +117,19 @@ func (c *Client) start() error {
 				break
 			}
 
-			resCmd := c.handler(pkt)
-
-			if resCmd == nil {
-				log.Printf("handler returned no response, disconnecting client")
-				break
+			// Commands which cannot be decoded or handled are replied to with
+			// an error, so that test runners can tell what went wrong.
+			var resCmd interface{}
+			pkt, err := c.decodePacket(pktBytes)
+			if err != nil {
+				log.Printf("failed to decode request: %s", err)
+				resCmd = &CmdError{Error: err.Error()}
+			} else {
+				resCmd = c.handler(pkt)
+				if resCmd == nil {
+					log.Printf("handler returned no response to %T", pkt)
+					resCmd = &CmdError{Error: "unsupported command"}
+				}
 			}
 
 			err = c.writePacket(resCmd)
@@ -7,13 +7,49 @@ import (
 	"reflect"
 )
 
+// ProtocolVersion is the version of the command protocol.  It is increased
+// whenever a change is made which existing test runners need to be aware of,
+// including whenever commands are added.  The package documentation lists the
+// commands which each version added.
+const ProtocolVersion = 2
+
 // CmdHello represents a hello to the server
 type CmdHello struct {
+	Version int `json:"version,omitempty"`
+}
+
+// CmdGetVersion requests the version of the command protocol.
+type CmdGetVersion struct {
+}
+
+// CmdVersion represents the reply to a get version request.
+type CmdVersion struct {
+	Version int `json:"version"`
+}
+
+// CmdError is sent in reply to any command which could not be decoded or is
+// not supported.
+type CmdError struct {
+	Error string `json:"error"`
 }
 
 // CmdCreateCluster requests a new mock cluster be created.
 type CmdCreateCluster struct {
 	ClusterID string `json:"id"`
+
+	// Deterministic makes the vbucket UUIDs of the cluster predictable, so
+	// that the mutation tokens returned by the cluster are reproducible, and
+	// makes the documents picked by random key requests reproducible too.
+	Deterministic bool `json:"deterministic,omitempty"`
+
+	// Edition is the edition of the server to emulate, either enterprise or
+	// community.  The enterprise edition is used if this is blank.
+	Edition string `json:"edition,omitempty"`
+
+	// Version is the version of the server to emulate, such as 7.2, which
+	// determines the features which the cluster supports.  The latest version
+	// is used if this is blank.
+	Version string `json:"version,omitempty"`
 }
 
 // CmdCreatedCluster represents the reply to a create cluster request.
@@ -92,22 +128,1009 @@ type CmdAddBucket struct {
 type CmdAddedBucket struct {
 }
 
+// CmdAddNode requests a new node be added to a cluster.  Services lists the
+// services which the node runs (kv, mgmt, views, query, search and analytics),
+// every node must run mgmt, and the node runs all of them if this is empty.
+// Nodes without kv never hold any vbuckets.
+type CmdAddNode struct {
+	ClusterID   string   `json:"cluster"`
+	Services    []string `json:"services,omitempty"`
+	ServerGroup string   `json:"group,omitempty"`
+}
+
+// CmdAddedNode represents the reply to an add node request.
+type CmdAddedNode struct {
+	NodeIdx int    `json:"node_idx"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CmdSetServerGroup requests a node be moved into a specific server group.
+type CmdSetServerGroup struct {
+	ClusterID   string `json:"cluster"`
+	NodeIdx     int    `json:"node_idx"`
+	ServerGroup string `json:"group"`
+}
+
+// CmdServerGroupSet represents the reply to a set server group request.
+type CmdServerGroupSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdFailoverNode requests a node be failed over.
+type CmdFailoverNode struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+}
+
+// CmdNodeFailedOver represents the reply to a failover node request.
+type CmdNodeFailedOver struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdRebalance requests a cluster be rebalanced, returning any failed over
+// nodes to it and spreading the vbuckets across all of its kv nodes.
+type CmdRebalance struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdRebalanced represents the reply to a rebalance request.
+type CmdRebalanced struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdEvacuateNode requests that every vbucket of a bucket be moved off of a
+// node, which keeps the bucket open while owning no vbuckets, as happens
+// part way through a rebalance.
+type CmdEvacuateNode struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	NodeIdx    int    `json:"node_idx"`
+}
+
+// CmdNodeEvacuated represents the reply to an evacuate node request.
+type CmdNodeEvacuated struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdCorruptDocument requests the stored value of a document be overwritten.
+type CmdCorruptDocument struct {
+	ClusterID      string `json:"cluster"`
+	BucketName     string `json:"bucket"`
+	ScopeName      string `json:"scope"`
+	CollectionName string `json:"collection"`
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	Datatype       uint8  `json:"datatype"`
+}
+
+// CmdCorruptedDocument represents the reply to a corrupt document request.
+type CmdCorruptedDocument struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetClusterCapabilities requests the capabilities advertised by a cluster
+// be replaced.
+type CmdSetClusterCapabilities struct {
+	ClusterID    string              `json:"cluster"`
+	Capabilities map[string][]string `json:"capabilities"`
+}
+
+// CmdClusterCapabilitiesSet represents the reply to a set cluster
+// capabilities request.
+type CmdClusterCapabilitiesSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdDiscardMutations requests that all mutations above a seqno be removed
+// from a vbucket, simulating an unclean failover.
+type CmdDiscardMutations struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	Vbucket    uint   `json:"vbucket"`
+	SeqNo      uint64 `json:"seqno"`
+}
+
+// CmdDiscardedMutations represents the reply to a discard mutations request.
+type CmdDiscardedMutations struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetReplicaLag requests that a replica of a vbucket lag behind the master
+// by a number of seqnos and a latency.  Replicas are numbered from 0, and
+// resetting the lag restores the default latency of the replica.
+type CmdSetReplicaLag struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	Vbucket    uint   `json:"vbucket"`
+	ReplicaIdx uint   `json:"replica_idx"`
+	LagSeqNos  uint64 `json:"lag_seqnos"`
+	LagMs      int    `json:"lag_ms"`
+	Reset      bool   `json:"reset,omitempty"`
+}
+
+// CmdReplicaLagSet represents the reply to a set replica lag request.
+type CmdReplicaLagSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdMarkMutationUnreplicated requests that a mutation of a document be marked
+// as never having reached any replica, so that it is lost if a replica is later
+// promoted by a failover.  A seqno of zero marks the latest mutation of the
+// document.
+type CmdMarkMutationUnreplicated struct {
+	ClusterID      string `json:"cluster"`
+	BucketName     string `json:"bucket"`
+	ScopeName      string `json:"scope"`
+	CollectionName string `json:"collection"`
+	Key            string `json:"key"`
+	SeqNo          uint64 `json:"seqno,omitempty"`
+}
+
+// CmdMutationUnreplicatedMarked represents the reply to a mark mutation
+// unreplicated request, including the seqno of the mutation which was marked.
+type CmdMutationUnreplicatedMarked struct {
+	SeqNo uint64 `json:"seqno"`
+	Error string `json:"error,omitempty"`
+}
+
+// CmdBumpConfigRev requests that the config revision of a cluster be increased
+// without any change to its topology, forcing clients to refresh.
+type CmdBumpConfigRev struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdConfigRevBumped represents the reply to a bump config rev request.
+type CmdConfigRevBumped struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetKvOrphanTimeout requests that kv responses sent for requests which
+// have been outstanding for longer than a timeout, which should match the
+// timeout used by the client, be counted as orphaned.  A zero timeout stops
+// them being counted.
+type CmdSetKvOrphanTimeout struct {
+	ClusterID string `json:"cluster"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+// CmdKvOrphanTimeoutSet represents the reply to a set kv orphan timeout request.
+type CmdKvOrphanTimeoutSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdGetOrphanedResponses requests the number of kv responses which a cluster
+// has counted as orphaned.
+type CmdGetOrphanedResponses struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdOrphanedResponses represents the reply to a get orphaned responses request.
+type CmdOrphanedResponses struct {
+	Count uint64 `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetConfigScenario requests that a cluster generate its configs in the
+// shape of a named config scenario.  An empty scenario restores normal configs.
+type CmdSetConfigScenario struct {
+	ClusterID string `json:"cluster"`
+	Scenario  string `json:"scenario"`
+}
+
+// CmdConfigScenarioSet represents the reply to a set config scenario request.
+type CmdConfigScenarioSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetKvLatency requests that a kv command on a node of a cluster be given
+// a latency distribution.  Leaving all of the percentiles at zero removes it.
+type CmdSetKvLatency struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+	Command   uint8  `json:"command"`
+	P50Ms     int    `json:"p50_ms"`
+	P99Ms     int    `json:"p99_ms"`
+	P999Ms    int    `json:"p999_ms"`
+	Seed      *int64 `json:"seed,omitempty"`
+}
+
+// CmdKvLatencySet represents the reply to a set kv latency request.
+type CmdKvLatencySet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdAddTrustedCA requests that a cluster trust client certificates signed by
+// a PEM encoded CA certificate.
+type CmdAddTrustedCA struct {
+	ClusterID string `json:"cluster"`
+	Cert      string `json:"cert"`
+}
+
+// CmdTrustedCAAdded represents the reply to an add trusted CA request.
+type CmdTrustedCAAdded struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetKvHang requests that a kv command on a node of a cluster hang instead
+// of being processed, either never responding or closing the connection after
+// a timeout.  Leaving the action empty removes the hang.
+type CmdSetKvHang struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+	Command   uint8  `json:"command"`
+	Action    string `json:"action,omitempty"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+// CmdKvHangSet represents the reply to a set kv hang request.
+type CmdKvHangSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdChangeNodeAddress requests that a node of a cluster change the address
+// it advertises and move its services to new ports, breaking any existing
+// connections, as if it had been restarted with a new IP.  IPv6 addresses may
+// be given with or without brackets, and may include a zone.
+type CmdChangeNodeAddress struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+	Hostname  string `json:"hostname"`
+}
+
+// CmdNodeAddressChanged represents the reply to a change node address request.
+type CmdNodeAddressChanged struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetThrottleWarning requests that a user be treated as approaching the
+// throttling limits of a bucket, so that clients which negotiated non-blocking
+// throttling are warned that their requests would be throttled.  An empty
+// user applies to every user of the bucket.
+type CmdSetThrottleWarning struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	User       string `json:"user,omitempty"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// CmdThrottleWarningSet represents the reply to a set throttle warning request.
+type CmdThrottleWarningSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetHTTPBusy requests that the next requests to an endpoint of a service
+// fail with a 503 and a Retry-After header.
+type CmdSetHTTPBusy struct {
+	ClusterID     string `json:"cluster"`
+	Service       string `json:"service"`
+	Method        string `json:"method,omitempty"`
+	Path          string `json:"path"`
+	Count         int    `json:"count"`
+	RetryAfterSec int    `json:"retry_after_sec"`
+}
+
+// CmdHTTPBusySet represents the reply to a set http busy request.
+type CmdHTTPBusySet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetQueryRowHook requests that the next query made to a cluster return a
+// number of generated rows, and that an action be performed on the cluster
+// once some of them have been received by the client, while the rest of the
+// response is still to be streamed.  The action is either failover, which
+// fails over a node, or bumpconfigrev.
+type CmdSetQueryRowHook struct {
+	ClusterID string `json:"cluster"`
+	Rows      int    `json:"rows"`
+	AfterRows int    `json:"after_rows"`
+	Action    string `json:"action"`
+	NodeIdx   int    `json:"node_idx,omitempty"`
+}
+
+// CmdQueryRowHookSet represents the reply to a set query row hook request.
+type CmdQueryRowHookSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetCompactionSteps requests that compactions of a bucket take a number of
+// steps to complete, which are then driven using CmdStepCompaction.
+type CmdSetCompactionSteps struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	Steps      int    `json:"steps"`
+}
+
+// CmdCompactionStepsSet represents the reply to a set compaction steps request.
+type CmdCompactionStepsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdStepCompaction requests that the running compaction of a bucket be
+// advanced by a single step.
+type CmdStepCompaction struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+}
+
+// CmdCompactionStepped represents the reply to a step compaction request.
+type CmdCompactionStepped struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetPauseSteps requests that pausing or resuming a bucket take a number
+// of steps to complete, which are then driven using CmdStepPause.
+type CmdSetPauseSteps struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	Steps      int    `json:"steps"`
+}
+
+// CmdPauseStepsSet represents the reply to a set pause steps request.
+type CmdPauseStepsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdStepPause requests that the running pause or resume of a bucket be
+// advanced by a single step.
+type CmdStepPause struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+}
+
+// CmdPauseStepped represents the reply to a step pause request.
+type CmdPauseStepped struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSeedDocuments requests that a set of JSON documents be stored into a
+// collection of a bucket, overwriting any existing documents with the same keys.
+type CmdSeedDocuments struct {
+	ClusterID      string                     `json:"cluster"`
+	BucketName     string                     `json:"bucket"`
+	ScopeName      string                     `json:"scope"`
+	CollectionName string                     `json:"collection"`
+	Documents      map[string]json.RawMessage `json:"docs"`
+}
+
+// CmdSeededDocuments represents the reply to a seed documents request.
+type CmdSeededDocuments struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetVbucketMap requests that the copies of each vbucket of a bucket be
+// placed on specific nodes.  Each vbucket lists the node indexes of its master
+// and replicas, with -1 for a copy which is not held by any node.
+type CmdSetVbucketMap struct {
+	ClusterID  string  `json:"cluster"`
+	BucketName string  `json:"bucket"`
+	VbMap      [][]int `json:"vbmap"`
+}
+
+// CmdVbucketMapSet represents the reply to a set vbucket map request.
+type CmdVbucketMapSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetHLCDrift requests that the hybrid logical clock of a bucket, from
+// which the CAS of its mutations is generated, drift from the cluster time.
+type CmdSetHLCDrift struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	DriftMs    int64  `json:"drift_ms"`
+}
+
+// CmdHLCDriftSet represents the reply to a set hlc drift request.
+type CmdHLCDriftSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetClockSkew requests that the clock of a node be skewed from the cluster
+// time, so that the CAS of the mutations which it performs are skewed too.
+// The CAS of a vbucket never goes backwards, so mutations on other nodes are
+// ordered after any CAS from the future which a skewed node has assigned.
+type CmdSetClockSkew struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+	SkewMs    int64  `json:"skew_ms"`
+}
+
+// CmdClockSkewSet represents the reply to a set clock skew request.
+type CmdClockSkewSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetMemoryPressure requests that a node simulate a percentage of the memory
+// quota of its buckets being in use.  Above the high watermark a growing
+// fraction of writes temporarily fail, and at 100 all writes fail as out of
+// memory.
+type CmdSetMemoryPressure struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+	Percent   int    `json:"percent"`
+}
+
+// CmdMemoryPressureSet represents the reply to a set memory pressure request.
+type CmdMemoryPressureSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetManifestStagger requests that the nodes of a cluster adopt changes to
+// the collection manifest of a bucket one after another, each lagging the
+// previous node by the stagger.
+type CmdSetManifestStagger struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	StaggerMs  int64  `json:"stagger_ms"`
+}
+
+// CmdManifestStaggerSet represents the reply to a set manifest stagger request.
+type CmdManifestStaggerSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetRangeScanIdleTimeout requests that range scans against a bucket which
+// are not continued within the timeout be cancelled.  A timeout of zero
+// restores the default.
+type CmdSetRangeScanIdleTimeout struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	TimeoutMs  int64  `json:"timeout_ms"`
+}
+
+// CmdRangeScanIdleTimeoutSet represents the reply to a set range scan idle
+// timeout request.
+type CmdRangeScanIdleTimeoutSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdPauseNode requests that a node stop processing requests, holding them
+// until the node is resumed with CmdResumeNode.
+type CmdPauseNode struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+}
+
+// CmdNodePaused represents the reply to a pause node request.
+type CmdNodePaused struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdResumeNode requests that a paused node process the requests which it
+// has been holding, and continue processing requests as normal.
+type CmdResumeNode struct {
+	ClusterID string `json:"cluster"`
+	NodeIdx   int    `json:"node_idx"`
+}
+
+// CmdNodeResumed represents the reply to a resume node request.
+type CmdNodeResumed struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetFailoverSteps requests that graceful failovers of a cluster take a
+// number of steps to drain the node, which are then driven using
+// CmdStepFailover.
+type CmdSetFailoverSteps struct {
+	ClusterID string `json:"cluster"`
+	Steps     int    `json:"steps"`
+}
+
+// CmdFailoverStepsSet represents the reply to a set failover steps request.
+type CmdFailoverStepsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdStepFailover requests that the running graceful failover of a cluster be
+// advanced by a single step.
+type CmdStepFailover struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdFailoverStepped represents the reply to a step failover request.
+type CmdFailoverStepped struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetMaxBucketCount requests that a cluster refuse to create buckets once
+// it has a number of buckets.
+type CmdSetMaxBucketCount struct {
+	ClusterID string `json:"cluster"`
+	Count     int    `json:"count"`
+}
+
+// CmdMaxBucketCountSet represents the reply to a set max bucket count request.
+type CmdMaxBucketCountSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetNodeMemory requests that every node of a cluster report having a
+// specific amount of RAM, which the service memory quotas are validated
+// against.  A size of zero restores the default.
+type CmdSetNodeMemory struct {
+	ClusterID string `json:"cluster"`
+	MemoryMB  uint64 `json:"memory_mb"`
+}
+
+// CmdNodeMemorySet represents the reply to a set node memory request.
+type CmdNodeMemorySet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetSASLMechanisms requests that the kv service of a cluster offer and
+// accept only specific SASL mechanisms.  Leaving the mechanisms empty restores
+// those of the version of the server which the cluster emulates.
+type CmdSetSASLMechanisms struct {
+	ClusterID  string   `json:"cluster"`
+	Mechanisms []string `json:"mechanisms"`
+}
+
+// CmdSASLMechanismsSet represents the reply to a set SASL mechanisms request.
+type CmdSASLMechanismsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetDocumentLimits requests that the sub-document operations of a bucket
+// reject documents which are, or would become, nested deeper than a maximum
+// depth.  In strict mode, full document writes are rejected too.
+type CmdSetDocumentLimits struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	MaxDepth   int    `json:"max_depth"`
+	Strict     bool   `json:"strict"`
+}
+
+// CmdDocumentLimitsSet represents the reply to a set document limits request.
+type CmdDocumentLimitsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// ReplayKvResponse is a single response of a captured kv packet trace.  Its
+// fields are named the same as those of the packets recorded in test reports,
+// so that responses which were sent by a server can be replayed as captured.
+type ReplayKvResponse struct {
+	Command  uint8  `json:"command"`
+	Status   uint16 `json:"status"`
+	Datatype uint8  `json:"datatype"`
+	CasHex   string `json:"casHex,omitempty"`
+	Key      []byte `json:"keyBase64,omitempty"`
+	Extras   []byte `json:"extrasBase64,omitempty"`
+	Value    []byte `json:"valueBase64,omitempty"`
+	DelayMs  int    `json:"delay_ms"`
+}
+
+// CmdReplayKvTrace requests that the next connection to the kv service of a
+// node of a cluster answer its requests with a captured trace of responses,
+// in order, regardless of what is requested.  An empty trace cancels a trace
+// which has not yet been picked up by a connection.
+type CmdReplayKvTrace struct {
+	ClusterID string             `json:"cluster"`
+	NodeIdx   int                `json:"node_idx"`
+	Responses []ReplayKvResponse `json:"responses"`
+}
+
+// CmdKvTraceReplayed represents the reply to a replay kv trace request.
+type CmdKvTraceReplayed struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Warning is a single warning which is injected into, or was recorded
+// against, an otherwise successful operation.
+type Warning struct {
+	Code int    `json:"code,omitempty"`
+	Key  string `json:"key,omitempty"`
+	Msg  string `json:"msg"`
+}
+
+// CmdSetQueryWarnings requests that every successful query made to a cluster
+// return a set of warnings alongside its results.  Empty warnings stop any
+// from being returned.
+type CmdSetQueryWarnings struct {
+	ClusterID string    `json:"cluster"`
+	Warnings  []Warning `json:"warnings"`
+}
+
+// CmdQueryWarningsSet represents the reply to a set query warnings request.
+type CmdQueryWarningsSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetSubDocWarning requests that a note be recorded whenever a subdoc
+// operation against a document with a specific key succeeds.  An empty
+// message stops any note being recorded for the key.
+type CmdSetSubDocWarning struct {
+	ClusterID string `json:"cluster"`
+	Key       string `json:"key"`
+	Msg       string `json:"msg"`
+}
+
+// CmdSubDocWarningSet represents the reply to a set subdoc warning request.
+type CmdSubDocWarningSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdGetSubDocWarnings requests the notes which have been recorded against
+// successful subdoc operations made to a cluster.
+type CmdGetSubDocWarnings struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdSubDocWarnings represents the reply to a get subdoc warnings request.
+type CmdSubDocWarnings struct {
+	Warnings []Warning `json:"warnings"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// CmdSetNmvbConfigOncePerRev requests that a cluster embed its config into
+// the NOT_MY_VBUCKET errors sent to each client only once per revision, even
+// if the client has not negotiated deduplication.
+type CmdSetNmvbConfigOncePerRev struct {
+	ClusterID string `json:"cluster"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// CmdNmvbConfigOncePerRevSet represents the reply to a set nmvb config once
+// per rev request.
+type CmdNmvbConfigOncePerRevSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// NmvbStats describes how a single kv client has reacted to the
+// NOT_MY_VBUCKET errors which it has been sent.
+type NmvbStats struct {
+	Client          string  `json:"client"`
+	NotMyVbuckets   uint64  `json:"nmvbs"`
+	EmbeddedConfigs uint64  `json:"embedded_configs"`
+	ConfigFetches   uint64  `json:"config_fetches"`
+	FetchRate       float64 `json:"fetch_rate"`
+}
+
+// CmdGetNmvbStats requests the statistics of every client which a cluster
+// has sent a NOT_MY_VBUCKET error to.
+type CmdGetNmvbStats struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdNmvbStats represents the reply to a get nmvb stats request.
+type CmdNmvbStats struct {
+	Clients []NmvbStats `json:"clients"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// CmdSetTask requests that a task be reported by /pools/default/tasks on a
+// cluster, alongside the tasks which it simulates itself.  Setting a task
+// with the id of an existing task updates it.
+type CmdSetTask struct {
+	ClusterID  string `json:"cluster"`
+	TaskID     string `json:"id"`
+	Type       string `json:"task_type"`
+	Subtype    string `json:"subtype"`
+	Status     string `json:"status"`
+	BucketName string `json:"bucket"`
+	Progress   int    `json:"progress"`
+}
+
+// CmdTaskSet represents the reply to a set task request.
+type CmdTaskSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdRemoveTask requests that a task which was set on a cluster no longer be
+// reported.
+type CmdRemoveTask struct {
+	ClusterID string `json:"cluster"`
+	TaskID    string `json:"id"`
+}
+
+// CmdTaskRemoved represents the reply to a remove task request.
+type CmdTaskRemoved struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetAuthStale requests that the authentication of the kv connections to
+// a cluster be marked as stale, so that their data operations fail with an
+// auth stale status until they authenticate again.  The connections can be
+// limited to those of a user, or to the one with a specific remote address.
+type CmdSetAuthStale struct {
+	ClusterID  string `json:"cluster"`
+	User       string `json:"user,omitempty"`
+	ClientAddr string `json:"client_addr,omitempty"`
+}
+
+// CmdAuthStaleSet represents the reply to a set auth stale request.
+type CmdAuthStaleSet struct {
+	Count int    `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// AuditEvent is a single event which was written to the audit log of a
+// cluster.
+type AuditEvent struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Timestamp string            `json:"timestamp"`
+	User      string            `json:"user,omitempty"`
+	Remote    string            `json:"remote,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// CmdGetAuditEvents requests the events which have been written to the audit
+// log of a cluster while auditing was enabled.
+type CmdGetAuditEvents struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdAuditEvents represents the reply to a get audit events request.
+type CmdAuditEvents struct {
+	Events []AuditEvent `json:"events"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// CmdSetQueryIndexState requests that a GSI index be registered on a cluster
+// in a particular state, either building or ready.  Queries which reference
+// an index which is building fail with a transient error until it is ready.
+type CmdSetQueryIndexState struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	IndexName  string `json:"index"`
+	State      string `json:"state"`
+}
+
+// CmdQueryIndexStateSet represents the reply to a set query index state
+// request.
+type CmdQueryIndexStateSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// TLSConnection describes the parameters negotiated by the TLS handshake of
+// a single connection to a cluster.  The version and cipher suite use the
+// values assigned to them by the TLS specifications.
+type TLSConnection struct {
+	Service            string `json:"service"`
+	LocalAddr          string `json:"local_addr"`
+	RemoteAddr         string `json:"remote_addr"`
+	Version            uint16 `json:"version"`
+	CipherSuite        uint16 `json:"cipher_suite"`
+	ServerName         string `json:"server_name,omitempty"`
+	NegotiatedProtocol string `json:"alpn,omitempty"`
+}
+
+// CmdGetTLSConnections requests the parameters negotiated by the TLS
+// connections which have been made to a cluster.
+type CmdGetTLSConnections struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdTLSConnections represents the reply to a get tls connections request.
+type CmdTLSConnections struct {
+	Connections []TLSConnection `json:"connections"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// CmdSetKvConnLimit requests a soft limit on the number of kv connections
+// which each node of a cluster serves for either a bucket or a user.  The
+// operations of connections beyond the limit fail as busy.  A limit of zero
+// removes the limit.
+type CmdSetKvConnLimit struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket,omitempty"`
+	User       string `json:"user,omitempty"`
+	Limit      int    `json:"limit"`
+}
+
+// CmdKvConnLimitSet represents the reply to a set kv conn limit request.
+type CmdKvConnLimitSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// KvConnLimitStats is the number of operations which were rejected because
+// the connection limit of a bucket or user was exceeded.
+type KvConnLimitStats struct {
+	BucketName string `json:"bucket,omitempty"`
+	User       string `json:"user,omitempty"`
+	Rejected   uint64 `json:"rejected"`
+}
+
+// CmdGetKvConnLimitStats requests the number of operations which a cluster
+// has rejected for each connection limit which was exceeded.
+type CmdGetKvConnLimitStats struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdKvConnLimitStats represents the reply to a get kv conn limit stats
+// request.
+type CmdKvConnLimitStats struct {
+	Limits []KvConnLimitStats `json:"limits"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// CmdGetResponseLeaks requests the number of kv responses which a cluster has
+// discarded because their connection had closed, and the number which leaked
+// to a connection that never sent their request.
+type CmdGetResponseLeaks struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdResponseLeaks represents the reply to a get response leaks request.
+type CmdResponseLeaks struct {
+	Leaked  uint64 `json:"leaked"`
+	Dropped uint64 `json:"dropped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CmdSetSyncWriteDuration requests that durable writes against a bucket stay
+// pending for the duration before they commit.  Other writes to a key with a
+// pending durable write are rejected.  A duration of zero commits durable
+// writes immediately.
+type CmdSetSyncWriteDuration struct {
+	ClusterID  string `json:"cluster"`
+	BucketName string `json:"bucket"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// CmdSyncWriteDurationSet represents the reply to a set sync write duration
+// request.
+type CmdSyncWriteDurationSet struct {
+	Error string `json:"error,omitempty"`
+}
+
+// CmdSetChaosMode requests that randomized faults be injected into the
+// requests made against a cluster: latency, temporary failures, dropped
+// connections and config changes.  Each probability applies to a single
+// request and is scaled by the intensity, which is treated as 1 if it is
+// zero.  The same seed always yields the same chaos for the same sequence of
+// requests.  Disabling chaos mode stops all of its faults.
+type CmdSetChaosMode struct {
+	ClusterID              string  `json:"cluster"`
+	Enabled                bool    `json:"enabled"`
+	Seed                   int64   `json:"seed"`
+	Intensity              float64 `json:"intensity"`
+	LatencyProbability     float64 `json:"latency_probability"`
+	LatencyP50Ms           int     `json:"latency_p50_ms"`
+	LatencyP99Ms           int     `json:"latency_p99_ms"`
+	LatencyP999Ms          int     `json:"latency_p999_ms"`
+	ErrorProbability       float64 `json:"error_probability"`
+	DisconnectProbability  float64 `json:"disconnect_probability"`
+	ConfigChurnProbability float64 `json:"config_churn_probability"`
+}
+
+// CmdChaosModeSet represents the reply to a set chaos mode request.
+type CmdChaosModeSet struct {
+	Error string `json:"error,omitempty"`
+}
+
 var cmdsMap = map[string]reflect.Type{
-	"hello":          reflect.TypeOf(CmdHello{}),
-	"createcluster":  reflect.TypeOf(CmdCreateCluster{}),
-	"createdcluster": reflect.TypeOf(CmdCreatedCluster{}),
-	"starttesting":   reflect.TypeOf(CmdStartTesting{}),
-	"startedtesting": reflect.TypeOf(CmdStartedTesting{}),
-	"endtesting":     reflect.TypeOf(CmdEndTesting{}),
-	"endedtesting":   reflect.TypeOf(CmdEndedTesting{}),
-	"starttest":      reflect.TypeOf(CmdStartTest{}),
-	"startedtest":    reflect.TypeOf(CmdStartedTest{}),
-	"endtest":        reflect.TypeOf(CmdEndTest{}),
-	"endedtest":      reflect.TypeOf(CmdEndedTest{}),
-	"timetravel":     reflect.TypeOf(CmdTimeTravel{}),
-	"timetravelled":  reflect.TypeOf(CmdTimeTravelled{}),
-	"addbucket":      reflect.TypeOf(CmdAddBucket{}),
-	"addedbucket":    reflect.TypeOf(CmdAddedBucket{}),
+	"hello":                   reflect.TypeOf(CmdHello{}),
+	"getversion":              reflect.TypeOf(CmdGetVersion{}),
+	"version":                 reflect.TypeOf(CmdVersion{}),
+	"error":                   reflect.TypeOf(CmdError{}),
+	"seeddocs":                reflect.TypeOf(CmdSeedDocuments{}),
+	"seededdocs":              reflect.TypeOf(CmdSeededDocuments{}),
+	"createcluster":           reflect.TypeOf(CmdCreateCluster{}),
+	"createdcluster":          reflect.TypeOf(CmdCreatedCluster{}),
+	"starttesting":            reflect.TypeOf(CmdStartTesting{}),
+	"startedtesting":          reflect.TypeOf(CmdStartedTesting{}),
+	"endtesting":              reflect.TypeOf(CmdEndTesting{}),
+	"endedtesting":            reflect.TypeOf(CmdEndedTesting{}),
+	"starttest":               reflect.TypeOf(CmdStartTest{}),
+	"startedtest":             reflect.TypeOf(CmdStartedTest{}),
+	"endtest":                 reflect.TypeOf(CmdEndTest{}),
+	"endedtest":               reflect.TypeOf(CmdEndedTest{}),
+	"timetravel":              reflect.TypeOf(CmdTimeTravel{}),
+	"timetravelled":           reflect.TypeOf(CmdTimeTravelled{}),
+	"addbucket":               reflect.TypeOf(CmdAddBucket{}),
+	"addedbucket":             reflect.TypeOf(CmdAddedBucket{}),
+	"addnode":                 reflect.TypeOf(CmdAddNode{}),
+	"addednode":               reflect.TypeOf(CmdAddedNode{}),
+	"setservergroup":          reflect.TypeOf(CmdSetServerGroup{}),
+	"servergroupset":          reflect.TypeOf(CmdServerGroupSet{}),
+	"failovernode":            reflect.TypeOf(CmdFailoverNode{}),
+	"nodefailedover":          reflect.TypeOf(CmdNodeFailedOver{}),
+	"rebalance":               reflect.TypeOf(CmdRebalance{}),
+	"rebalanced":              reflect.TypeOf(CmdRebalanced{}),
+	"evacuatenode":            reflect.TypeOf(CmdEvacuateNode{}),
+	"nodeevacuated":           reflect.TypeOf(CmdNodeEvacuated{}),
+	"corruptdoc":              reflect.TypeOf(CmdCorruptDocument{}),
+	"corrupteddoc":            reflect.TypeOf(CmdCorruptedDocument{}),
+	"setclustercaps":          reflect.TypeOf(CmdSetClusterCapabilities{}),
+	"clustercapsset":          reflect.TypeOf(CmdClusterCapabilitiesSet{}),
+	"discardmutations":        reflect.TypeOf(CmdDiscardMutations{}),
+	"setreplicalag":           reflect.TypeOf(CmdSetReplicaLag{}),
+	"replicalagset":           reflect.TypeOf(CmdReplicaLagSet{}),
+	"markunreplicated":        reflect.TypeOf(CmdMarkMutationUnreplicated{}),
+	"unreplicatedmarked":      reflect.TypeOf(CmdMutationUnreplicatedMarked{}),
+	"discardedmutations":      reflect.TypeOf(CmdDiscardedMutations{}),
+	"bumpconfigrev":           reflect.TypeOf(CmdBumpConfigRev{}),
+	"configrevbumped":         reflect.TypeOf(CmdConfigRevBumped{}),
+	"setconfigscenario":       reflect.TypeOf(CmdSetConfigScenario{}),
+	"configscenarioset":       reflect.TypeOf(CmdConfigScenarioSet{}),
+	"setkvlatency":            reflect.TypeOf(CmdSetKvLatency{}),
+	"kvlatencyset":            reflect.TypeOf(CmdKvLatencySet{}),
+	"addtrustedca":            reflect.TypeOf(CmdAddTrustedCA{}),
+	"trustedcaadded":          reflect.TypeOf(CmdTrustedCAAdded{}),
+	"setkvorphantimeout":      reflect.TypeOf(CmdSetKvOrphanTimeout{}),
+	"kvorphantimeoutset":      reflect.TypeOf(CmdKvOrphanTimeoutSet{}),
+	"getorphanedresponses":    reflect.TypeOf(CmdGetOrphanedResponses{}),
+	"orphanedresponses":       reflect.TypeOf(CmdOrphanedResponses{}),
+	"setkvhang":               reflect.TypeOf(CmdSetKvHang{}),
+	"kvhangset":               reflect.TypeOf(CmdKvHangSet{}),
+	"changenodeaddress":       reflect.TypeOf(CmdChangeNodeAddress{}),
+	"nodeaddresschanged":      reflect.TypeOf(CmdNodeAddressChanged{}),
+	"setthrottlewarning":      reflect.TypeOf(CmdSetThrottleWarning{}),
+	"throttlewarningset":      reflect.TypeOf(CmdThrottleWarningSet{}),
+	"sethttpbusy":             reflect.TypeOf(CmdSetHTTPBusy{}),
+	"httpbusyset":             reflect.TypeOf(CmdHTTPBusySet{}),
+	"setqueryrowhook":         reflect.TypeOf(CmdSetQueryRowHook{}),
+	"queryrowhookset":         reflect.TypeOf(CmdQueryRowHookSet{}),
+	"setcompactionsteps":      reflect.TypeOf(CmdSetCompactionSteps{}),
+	"compactionstepsset":      reflect.TypeOf(CmdCompactionStepsSet{}),
+	"stepcompaction":          reflect.TypeOf(CmdStepCompaction{}),
+	"compactionstepped":       reflect.TypeOf(CmdCompactionStepped{}),
+	"setvbmap":                reflect.TypeOf(CmdSetVbucketMap{}),
+	"vbmapset":                reflect.TypeOf(CmdVbucketMapSet{}),
+	"pausenode":               reflect.TypeOf(CmdPauseNode{}),
+	"nodepaused":              reflect.TypeOf(CmdNodePaused{}),
+	"resumenode":              reflect.TypeOf(CmdResumeNode{}),
+	"noderesumed":             reflect.TypeOf(CmdNodeResumed{}),
+	"setfailoversteps":        reflect.TypeOf(CmdSetFailoverSteps{}),
+	"failoverstepsset":        reflect.TypeOf(CmdFailoverStepsSet{}),
+	"stepfailover":            reflect.TypeOf(CmdStepFailover{}),
+	"failoverstepped":         reflect.TypeOf(CmdFailoverStepped{}),
+	"sethlcdrift":             reflect.TypeOf(CmdSetHLCDrift{}),
+	"hlcdriftset":             reflect.TypeOf(CmdHLCDriftSet{}),
+	"setclockskew":            reflect.TypeOf(CmdSetClockSkew{}),
+	"clockskewset":            reflect.TypeOf(CmdClockSkewSet{}),
+	"setmemorypressure":       reflect.TypeOf(CmdSetMemoryPressure{}),
+	"memorypressureset":       reflect.TypeOf(CmdMemoryPressureSet{}),
+	"setpausesteps":           reflect.TypeOf(CmdSetPauseSteps{}),
+	"pausestepsset":           reflect.TypeOf(CmdPauseStepsSet{}),
+	"steppause":               reflect.TypeOf(CmdStepPause{}),
+	"pausestepped":            reflect.TypeOf(CmdPauseStepped{}),
+	"setmanifeststagger":      reflect.TypeOf(CmdSetManifestStagger{}),
+	"manifeststaggerset":      reflect.TypeOf(CmdManifestStaggerSet{}),
+	"setmaxbucketcount":       reflect.TypeOf(CmdSetMaxBucketCount{}),
+	"maxbucketcountset":       reflect.TypeOf(CmdMaxBucketCountSet{}),
+	"setnodememory":           reflect.TypeOf(CmdSetNodeMemory{}),
+	"nodememoryset":           reflect.TypeOf(CmdNodeMemorySet{}),
+	"setdocumentlimits":       reflect.TypeOf(CmdSetDocumentLimits{}),
+	"documentlimitsset":       reflect.TypeOf(CmdDocumentLimitsSet{}),
+	"setsaslmechs":            reflect.TypeOf(CmdSetSASLMechanisms{}),
+	"saslmechsset":            reflect.TypeOf(CmdSASLMechanismsSet{}),
+	"replaykvtrace":           reflect.TypeOf(CmdReplayKvTrace{}),
+	"kvtracereplayed":         reflect.TypeOf(CmdKvTraceReplayed{}),
+	"setrangescanidletimeout": reflect.TypeOf(CmdSetRangeScanIdleTimeout{}),
+	"rangescanidletimeoutset": reflect.TypeOf(CmdRangeScanIdleTimeoutSet{}),
+	"setquerywarnings":        reflect.TypeOf(CmdSetQueryWarnings{}),
+	"querywarningsset":        reflect.TypeOf(CmdQueryWarningsSet{}),
+	"setsubdocwarning":        reflect.TypeOf(CmdSetSubDocWarning{}),
+	"subdocwarningset":        reflect.TypeOf(CmdSubDocWarningSet{}),
+	"getsubdocwarnings":       reflect.TypeOf(CmdGetSubDocWarnings{}),
+	"subdocwarnings":          reflect.TypeOf(CmdSubDocWarnings{}),
+	"setnmvbconfigonce":       reflect.TypeOf(CmdSetNmvbConfigOncePerRev{}),
+	"nmvbconfigonceset":       reflect.TypeOf(CmdNmvbConfigOncePerRevSet{}),
+	"getnmvbstats":            reflect.TypeOf(CmdGetNmvbStats{}),
+	"nmvbstats":               reflect.TypeOf(CmdNmvbStats{}),
+	"settask":                 reflect.TypeOf(CmdSetTask{}),
+	"taskset":                 reflect.TypeOf(CmdTaskSet{}),
+	"removetask":              reflect.TypeOf(CmdRemoveTask{}),
+	"taskremoved":             reflect.TypeOf(CmdTaskRemoved{}),
+	"setauthstale":            reflect.TypeOf(CmdSetAuthStale{}),
+	"authstaleset":            reflect.TypeOf(CmdAuthStaleSet{}),
+	"getauditevents":          reflect.TypeOf(CmdGetAuditEvents{}),
+	"auditevents":             reflect.TypeOf(CmdAuditEvents{}),
+	"setqueryindexstate":      reflect.TypeOf(CmdSetQueryIndexState{}),
+	"queryindexstateset":      reflect.TypeOf(CmdQueryIndexStateSet{}),
+	"gettlsconnections":       reflect.TypeOf(CmdGetTLSConnections{}),
+	"tlsconnections":          reflect.TypeOf(CmdTLSConnections{}),
+	"setkvconnlimit":          reflect.TypeOf(CmdSetKvConnLimit{}),
+	"kvconnlimitset":          reflect.TypeOf(CmdKvConnLimitSet{}),
+	"getkvconnlimitstats":     reflect.TypeOf(CmdGetKvConnLimitStats{}),
+	"kvconnlimitstats":        reflect.TypeOf(CmdKvConnLimitStats{}),
+	"getresponseleaks":        reflect.TypeOf(CmdGetResponseLeaks{}),
+	"responseleaks":           reflect.TypeOf(CmdResponseLeaks{}),
+	"setsyncwriteduration":    reflect.TypeOf(CmdSetSyncWriteDuration{}),
+	"syncwritedurationset":    reflect.TypeOf(CmdSyncWriteDurationSet{}),
+	"setchaosmode":            reflect.TypeOf(CmdSetChaosMode{}),
+	"chaosmodeset":            reflect.TypeOf(CmdChaosModeSet{}),
 }
 
 // EncodeCommandPacket encodes a packet from a structure to bytes bytes.
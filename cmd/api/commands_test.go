@@ -29,3 +29,17 @@ func TestConnStrCommands(t *testing.T) {
 
 	assert.Equal(t, testObj, decodedObj)
 }
+
+func TestErrorCommand(t *testing.T) {
+	_, err := DecodeCommandPacket([]byte(`{"type":"notacommand"}`))
+	if err == nil {
+		t.Fatalf("expected unknown command to fail decoding")
+	}
+
+	encodedBytes, err := EncodeCommandPacket(&CmdError{Error: err.Error()})
+	if err != nil {
+		t.Fatalf("failed to encode bytes: %s", err)
+	}
+
+	assert.Equal(t, []byte(`{"type":"error","error":"unsupported packet type"}`), encodedBytes)
+}
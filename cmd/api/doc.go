@@ -0,0 +1,54 @@
+/*
+Package api implements the command protocol which is used by SDK test runners
+to drive CAVES from outside of Go.
+
+Each command is a JSON object terminated by a single NUL byte.  The type field
+of the object names the command, and the remaining fields are its arguments,
+as described by the Cmd types of this package.  Every command receives exactly
+one reply, whose type names the result of the command (for instance
+createcluster is replied to with createdcluster).  Replies which can fail carry
+an error field describing the failure, which is empty on success.  Commands
+which cannot be decoded, or which are not supported, are replied to with an
+error command.
+
+Test runners should check the protocol version, using getversion, before
+relying on any command which was added after the first version.  Version 2
+added addnode, addtrustedca, changenodeaddress, evacuatenode, getauditevents,
+getkvconnlimitstats, getnmvbstats, getorphanedresponses, getresponseleaks,
+getsubdocwarnings, gettlsconnections, markunreplicated, pausenode, rebalance,
+removetask, replaykvtrace, resumenode, setauthstale, setchaosmode,
+setclockskew, setdocumentlimits, setfailoversteps, sethlcdrift,
+setkvconnlimit, setkvhang, setkvorphantimeout, setmanifeststagger,
+setmaxbucketcount, setmemorypressure, setnmvbconfigonce, setnodememory,
+setpausesteps, setqueryindexstate, setqueryrowhook, setquerywarnings,
+setrangescanidletimeout, setreplicalag, setsaslmechs, setsubdocwarning,
+setsyncwriteduration, settask, setthrottlewarning, setvbmap, stepfailover and
+steppause.
+
+Commands are available to create clusters (createcluster), seed documents
+(seeddocs), limit the number of buckets (setmaxbucketcount), the memory of the
+nodes (setnodememory) and the nesting of documents (setdocumentlimits),
+restrict the SASL mechanisms (setsaslmechs), trust client certificate
+authorities (addtrustedca), manipulate the topology (addnode, failovernode,
+rebalance, evacuatenode, setservergroup, bumpconfigrev, setconfigscenario,
+setvbmap, setmanifeststagger, changenodeaddress) and inject faults
+(setkvlatency, setkvhang, sethttpbusy, setthrottlewarning, discardmutations,
+setreplicalag, markunreplicated, corruptdoc, pausenode, resumenode,
+sethlcdrift, setclockskew, setmemorypressure, setqueryrowhook), replay
+captured kv packet traces (replaykvtrace), count orphaned kv responses
+(setkvorphantimeout, getorphanedresponses), expire abandoned range scans
+(setrangescanidletimeout), inject warnings into successful operations
+(setquerywarnings, setsubdocwarning, getsubdocwarnings), track config fetches
+during NOT_MY_VBUCKET storms (setnmvbconfigonce, getnmvbstats), report tasks
+which are not simulated (settask, removetask), force kv connections to
+reauthenticate (setauthstale), retrieve audited events (getauditevents), set
+the build state of query indexes (setqueryindexstate), retrieve the parameters
+negotiated by TLS connections (gettlsconnections), set soft limits on kv
+connections (setkvconnlimit) and retrieve the operations they rejected
+(getkvconnlimitstats), check that no kv responses leaked across reconnects
+(getresponseleaks), hold durable writes pending so that concurrent writes see
+them in progress (setsyncwriteduration), enable reproducible randomized faults
+(setchaosmode), as well as to run the test suite itself (starttesting,
+starttest, endtest, endtesting).
+*/
+package api
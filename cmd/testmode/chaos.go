@@ -0,0 +1,266 @@
+package testmode
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// chaosRetryAfter is how long http requests which chaos mode fails as busy
+// ask to be retried after.
+const chaosRetryAfter = time.Second
+
+// chaosSettings configures the faults which chaos mode injects.  Each
+// probability is the chance of its fault being injected into a single
+// request, and is scaled by the intensity.
+type chaosSettings struct {
+	Seed                   int64
+	Intensity              float64
+	LatencyProbability     float64
+	Latency                mock.LatencyDistribution
+	ErrorProbability       float64
+	DisconnectProbability  float64
+	ConfigChurnProbability float64
+}
+
+// validate checks that the probabilities and intensity of the settings are in
+// range, and that the latency distribution is ordered.
+func (s chaosSettings) validate() error {
+	if s.Intensity < 0 {
+		return errors.New("chaos intensity must be non-negative")
+	}
+
+	probabilities := []float64{
+		s.LatencyProbability,
+		s.ErrorProbability,
+		s.DisconnectProbability,
+		s.ConfigChurnProbability,
+	}
+	for _, probability := range probabilities {
+		if probability < 0 || probability > 1 {
+			return errors.New("chaos probabilities must be between 0 and 1")
+		}
+	}
+
+	return s.Latency.Validate()
+}
+
+// chaosFaults are the faults which chaos mode injects into a single kv
+// request.
+type chaosFaults struct {
+	latency     time.Duration
+	fail        bool
+	disconnect  bool
+	configChurn bool
+}
+
+// chance draws from a random source, returning whether a fault with the given
+// probability should be injected.
+func (s chaosSettings) chance(rng *rand.Rand, probability float64) bool {
+	return rng.Float64() < probability*s.Intensity
+}
+
+// rollKvFaults decides which faults to inject into a kv request.  Every roll
+// is made for every request, even if an earlier roll means it will not be
+// used, so that each request consumes the same amount of randomness.
+func (s chaosSettings) rollKvFaults(rng *rand.Rand) chaosFaults {
+	var faults chaosFaults
+	addLatency := s.chance(rng, s.LatencyProbability)
+	latency := s.Latency.Sample(rng)
+	if addLatency {
+		faults.latency = latency
+	}
+	faults.fail = s.chance(rng, s.ErrorProbability)
+	faults.disconnect = s.chance(rng, s.DisconnectProbability)
+	faults.configChurn = s.chance(rng, s.ConfigChurnProbability)
+	return faults
+}
+
+// kvRequestFaults translates the faults into those which the kv service
+// injects itself, so that chaos behaves exactly like the latency, errors and
+// disconnects which can be configured individually.
+func (f chaosFaults) kvRequestFaults() mock.KvRequestFaults {
+	var faults mock.KvRequestFaults
+	faults.Latency = f.latency
+	if f.disconnect {
+		faults.Hang = &mock.KvCommandHang{Action: mock.KvHangActionClose}
+	}
+	if f.fail {
+		faults.Status = memd.StatusTmpFail
+	}
+	return faults
+}
+
+// chaosKvConn is the chaos state of a single kv connection.
+type chaosKvConn struct {
+	lock       sync.Mutex
+	controller *chaosController
+	rng        *rand.Rand
+}
+
+// chaosController injects randomized faults into the requests made against a
+// cluster, composing the latency, error injection, disconnects and config
+// changes which can otherwise be injected individually.
+//
+// Every kv connection, and every request to each http endpoint, draws from
+// its own random source derived from the seed.  This keeps the chaos seen by
+// each of them reproducible no matter how their requests interleave.
+type chaosController struct {
+	cluster  mock.Cluster
+	settings chaosSettings
+
+	lock       sync.Mutex
+	numKvConns int
+	httpCounts map[string]int
+
+	mgmtHooks      mock.MgmtHookManager
+	queryHooks     mock.QueryHookManager
+	analyticsHooks mock.AnalyticsHookManager
+	searchHooks    mock.SearchHookManager
+	viewHooks      mock.ViewHookManager
+}
+
+// newChaosController starts injecting faults into the requests made against
+// a cluster.
+func newChaosController(cluster mock.Cluster, settings chaosSettings) *chaosController {
+	c := &chaosController{
+		cluster:        cluster,
+		settings:       settings,
+		httpCounts:     make(map[string]int),
+		mgmtHooks:      cluster.MgmtHooks().Child(),
+		queryHooks:     cluster.QueryHooks().Child(),
+		analyticsHooks: cluster.AnalyticsHooks().Child(),
+		searchHooks:    cluster.SearchHooks().Child(),
+		viewHooks:      cluster.ViewHooks().Child(),
+	}
+
+	cluster.SetKvFaultInjector(c.injectKvFaults)
+	c.mgmtHooks.Add(func(source mock.MgmtService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if c.rollHTTPError(req) {
+			return httpBusyResponse(chaosRetryAfter)
+		}
+		return next()
+	})
+	c.queryHooks.Add(func(source mock.QueryService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if c.rollHTTPError(req) {
+			return httpBusyResponse(chaosRetryAfter)
+		}
+		return next()
+	})
+	c.analyticsHooks.Add(func(source mock.AnalyticsService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if c.rollHTTPError(req) {
+			return httpBusyResponse(chaosRetryAfter)
+		}
+		return next()
+	})
+	c.searchHooks.Add(func(source mock.SearchService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if c.rollHTTPError(req) {
+			return httpBusyResponse(chaosRetryAfter)
+		}
+		return next()
+	})
+	c.viewHooks.Add(func(source mock.ViewService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if c.rollHTTPError(req) {
+			return httpBusyResponse(chaosRetryAfter)
+		}
+		return next()
+	})
+
+	return c
+}
+
+// Stop removes the fault injector and all of the hooks of the controller, so
+// that no more faults are injected.
+func (c *chaosController) Stop() {
+	c.cluster.SetKvFaultInjector(nil)
+	c.mgmtHooks.Destroy()
+	c.queryHooks.Destroy()
+	c.analyticsHooks.Destroy()
+	c.searchHooks.Destroy()
+	c.viewHooks.Destroy()
+}
+
+// newRand returns a random source derived from the seed and from the identity
+// of whatever is going to draw from it.
+func (c *chaosController) newRand(identity string) *rand.Rand {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(identity))
+	return rand.New(rand.NewSource(c.settings.Seed ^ int64(hash.Sum64())))
+}
+
+// newKvConnRand returns the random source of the next kv connection to make a
+// request, which is derived from the order connections are first seen in.
+func (c *chaosController) newKvConnRand() *rand.Rand {
+	c.lock.Lock()
+	c.numKvConns++
+	connIdx := c.numKvConns
+	c.lock.Unlock()
+
+	return c.newRand("kv/" + strconv.Itoa(connIdx))
+}
+
+// rollKvFaults decides which faults to inject into the next request of a kv
+// connection.
+func (c *chaosController) rollKvFaults(conn *chaosKvConn) chaosFaults {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	// The state of connections which outlive a previous controller is
+	// started over, as is the random source of connections it never saw.
+	if conn.controller != c {
+		conn.controller = c
+		conn.rng = c.newKvConnRand()
+	}
+
+	return c.settings.rollKvFaults(conn.rng)
+}
+
+// rollHTTPError decides whether to fail an http request as busy.  Each request
+// to an endpoint draws from a random source of its own, derived from how many
+// requests have been made to that endpoint before it.
+func (c *chaosController) rollHTTPError(req *mock.HTTPRequest) bool {
+	endpoint := req.Method + " " + req.URL.Path
+
+	c.lock.Lock()
+	c.httpCounts[endpoint]++
+	reqIdx := c.httpCounts[endpoint]
+	c.lock.Unlock()
+
+	rng := c.newRand("http/" + endpoint + "/" + strconv.Itoa(reqIdx))
+	return c.settings.chance(rng, c.settings.ErrorProbability)
+}
+
+// isChaosExemptCommand returns whether a command is part of setting up a
+// connection, which a real server would never fail temporarily.
+func isChaosExemptCommand(cmd memd.CmdCode) bool {
+	switch cmd {
+	case memd.CmdHello, memd.CmdSASLListMechs, memd.CmdSASLAuth, memd.CmdSASLStep,
+		memd.CmdSelectBucket, memd.CmdGetErrorMap:
+		return true
+	}
+	return false
+}
+
+// injectKvFaults decides which faults the kv service should inject into a
+// request.  Config churn is not something the kv service injects, so it is
+// applied here directly.
+func (c *chaosController) injectKvFaults(source mock.KvClient, pak *memd.Packet) mock.KvRequestFaults {
+	if pak.Magic != memd.CmdMagicReq || isChaosExemptCommand(pak.Command) {
+		return mock.KvRequestFaults{}
+	}
+
+	var conn *chaosKvConn
+	source.GetContext(&conn)
+
+	faults := c.rollKvFaults(conn)
+	if faults.configChurn {
+		c.cluster.BumpConfigRev()
+	}
+	return faults.kvRequestFaults()
+}
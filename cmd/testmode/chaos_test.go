@@ -0,0 +1,81 @@
+package testmode
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func testChaosController(seed int64) *chaosController {
+	return &chaosController{
+		settings: chaosSettings{
+			Seed:                   seed,
+			Intensity:              1,
+			LatencyProbability:     0.3,
+			Latency:                mock.LatencyDistribution{P50: time.Millisecond, P99: 5 * time.Millisecond, P999: 10 * time.Millisecond},
+			ErrorProbability:       0.3,
+			DisconnectProbability:  0.1,
+			ConfigChurnProbability: 0.1,
+		},
+		httpCounts: make(map[string]int),
+	}
+}
+
+func TestChaosSeedReproducible(t *testing.T) {
+	const numRequests = 100
+
+	// Two connections whose requests are processed one after the other.
+	sequential := testChaosController(42)
+	seqConnA, seqConnB := &chaosKvConn{}, &chaosKvConn{}
+	var seqFaultsA, seqFaultsB []chaosFaults
+	for i := 0; i < numRequests; i++ {
+		seqFaultsA = append(seqFaultsA, sequential.rollKvFaults(seqConnA))
+	}
+	for i := 0; i < numRequests; i++ {
+		seqFaultsB = append(seqFaultsB, sequential.rollKvFaults(seqConnB))
+	}
+
+	// The same connections, with their requests interleaved.
+	interleaved := testChaosController(42)
+	intConnA, intConnB := &chaosKvConn{}, &chaosKvConn{}
+	var intFaultsA, intFaultsB []chaosFaults
+	intFaultsA = append(intFaultsA, interleaved.rollKvFaults(intConnA))
+	for i := 0; i < numRequests; i++ {
+		intFaultsB = append(intFaultsB, interleaved.rollKvFaults(intConnB))
+		if i < numRequests-1 {
+			intFaultsA = append(intFaultsA, interleaved.rollKvFaults(intConnA))
+		}
+	}
+
+	assert.Equal(t, seqFaultsA, intFaultsA)
+	assert.Equal(t, seqFaultsB, intFaultsB)
+	assert.NotEqual(t, seqFaultsA, seqFaultsB)
+
+	other := testChaosController(43)
+	otherConn := &chaosKvConn{}
+	var otherFaults []chaosFaults
+	for i := 0; i < numRequests; i++ {
+		otherFaults = append(otherFaults, other.rollKvFaults(otherConn))
+	}
+	assert.NotEqual(t, seqFaultsA, otherFaults)
+
+	// Http requests are reproducible per endpoint, whatever else is requested
+	// in between.
+	reqA := &mock.HTTPRequest{Method: "GET", URL: &url.URL{Path: "/pools/default"}}
+	reqB := &mock.HTTPRequest{Method: "POST", URL: &url.URL{Path: "/query/service"}}
+
+	var seqErrors, intErrors []bool
+	for i := 0; i < numRequests; i++ {
+		seqErrors = append(seqErrors, sequential.rollHTTPError(reqA))
+	}
+	for i := 0; i < numRequests; i++ {
+		interleaved.rollHTTPError(reqB)
+		intErrors = append(intErrors, interleaved.rollHTTPError(reqA))
+	}
+	assert.Equal(t, seqErrors, intErrors)
+	assert.Contains(t, seqErrors, true)
+	assert.Contains(t, seqErrors, false)
+}
@@ -1,31 +1,54 @@
 package testmode
 
 import (
+	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/cmd/api"
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
 	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
 	"github.com/couchbaselabs/gocaves/mock/mockimpl"
 )
 
 type namedCluster struct {
 	Name string
 	Mock mock.Cluster
+
+	chaos *chaosController
 }
 
 type clusterManager struct {
 	Clusters []*namedCluster
 }
 
-func (m *clusterManager) NewCluster(clusterID string) (*namedCluster, error) {
-	mock, err := mockimpl.NewDefaultCluster()
+func (m *clusterManager) NewCluster(clusterID string, deterministic bool, edition, version string) (*namedCluster, error) {
+	clusterEdition := mock.ClusterEdition(edition)
+	if clusterEdition != "" && clusterEdition != mock.ClusterEditionEnterprise &&
+		clusterEdition != mock.ClusterEditionCommunity {
+		return nil, errors.New("invalid edition")
+	}
+
+	clusterVersion := mock.ClusterVersion(version)
+	if clusterVersion != "" && !clusterVersion.IsValid() {
+		return nil, errors.New("invalid version")
+	}
+
+	cluster, err := mockimpl.NewDefaultClusterWithOptions(mock.NewClusterOptions{
+		DeterministicVbUUIDs: deterministic,
+		Edition:              clusterEdition,
+		Version:              clusterVersion,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	ncluster := &namedCluster{
 		Name: clusterID,
-		Mock: mock,
+		Mock: cluster,
 	}
 	m.Clusters = append(m.Clusters, ncluster)
 
@@ -84,3 +107,1086 @@ func (m *clusterManager) AddBucket(clusterID, name, typ string, replicas uint) e
 	})
 	return err
 }
+
+// serviceTypesByName maps the service names used by the harness to services.
+var serviceTypesByName = map[string]mock.ServiceType{
+	"kv":        mock.ServiceTypeKeyValue,
+	"mgmt":      mock.ServiceTypeMgmt,
+	"views":     mock.ServiceTypeViews,
+	"query":     mock.ServiceTypeQuery,
+	"search":    mock.ServiceTypeSearch,
+	"analytics": mock.ServiceTypeAnalytics,
+}
+
+func (m *clusterManager) AddNode(clusterID string, serviceNames []string, group string) (int, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return 0, errors.New("invalid cluster id")
+	}
+
+	var services []mock.ServiceType
+	for _, name := range serviceNames {
+		service, ok := serviceTypesByName[name]
+		if !ok {
+			return 0, errors.New("invalid service")
+		}
+		services = append(services, service)
+	}
+
+	_, err := ncluster.Mock.AddNode(mock.NewNodeOptions{
+		Services:    services,
+		ServerGroup: group,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ncluster.Mock.Nodes()) - 1, nil
+}
+
+func (m *clusterManager) SetServerGroup(clusterID string, nodeIdx int, group string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	return ncluster.Mock.SetNodeServerGroup(nodes[nodeIdx].ID(), group)
+}
+
+func (m *clusterManager) FailoverNode(clusterID string, nodeIdx int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	return ncluster.Mock.FailoverNode(nodes[nodeIdx].ID())
+}
+
+func (m *clusterManager) Rebalance(clusterID string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	return ncluster.Mock.Rebalance()
+}
+
+func (m *clusterManager) EvacuateNode(clusterID, bucketName string, nodeIdx int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	return bucket.EvacuateNode(nodes[nodeIdx].ID())
+}
+
+func (m *clusterManager) SetHLCDrift(clusterID, bucketName string, drift time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	bucket.Store().SetHLCDrift(drift)
+	return nil
+}
+
+func (m *clusterManager) SetSASLMechanisms(clusterID string, mechs []string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	for _, mech := range mechs {
+		switch mech {
+		case "PLAIN", "SCRAM-SHA1", "SCRAM-SHA256", "SCRAM-SHA512":
+		default:
+			return errors.New("invalid sasl mechanism")
+		}
+	}
+
+	// No mechanisms restores the defaults of the emulated version.
+	if len(mechs) == 0 {
+		mechs = nil
+	}
+
+	ncluster.Mock.SetSASLMechanisms(mechs)
+	return nil
+}
+
+func (m *clusterManager) SetDocumentLimits(clusterID, bucketName string, maxDepth int, strict bool) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if maxDepth < 0 {
+		return errors.New("invalid max depth")
+	}
+
+	bucket.SetDocumentLimits(mock.DocumentLimits{
+		MaxDepth:   maxDepth,
+		StrictJSON: strict,
+	})
+	return nil
+}
+
+func (m *clusterManager) SetClockSkew(clusterID string, nodeIdx int, skew time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	nodes[nodeIdx].SetClockSkew(skew)
+	return nil
+}
+
+func (m *clusterManager) SetMemoryPressure(clusterID string, nodeIdx int, percent int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	if percent < 0 || percent > 100 {
+		return errors.New("invalid memory pressure")
+	}
+
+	nodes[nodeIdx].SetMemoryPressure(percent)
+	return nil
+}
+
+func (m *clusterManager) SetManifestStagger(clusterID, bucketName string, stagger time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	bucket.SetManifestStagger(stagger)
+	return nil
+}
+
+func (m *clusterManager) SetRangeScanIdleTimeout(clusterID, bucketName string, timeout time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	bucket.RangeScans().SetIdleTimeout(timeout)
+	return nil
+}
+
+func (m *clusterManager) SetQueryWarnings(clusterID string, warnings []api.Warning) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	var queryWarnings []mock.QueryWarning
+	for _, warning := range warnings {
+		queryWarnings = append(queryWarnings, mock.QueryWarning{
+			Code: warning.Code,
+			Msg:  warning.Msg,
+		})
+	}
+
+	ncluster.Mock.Warnings().SetQueryWarnings(queryWarnings)
+	return nil
+}
+
+func (m *clusterManager) SetSubDocWarning(clusterID, key, msg string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	ncluster.Mock.Warnings().SetSubDocWarning(key, msg)
+	return nil
+}
+
+func (m *clusterManager) SubDocWarnings(clusterID string) ([]api.Warning, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	warnings := make([]api.Warning, 0)
+	for _, warning := range ncluster.Mock.Warnings().SubDocWarnings() {
+		warnings = append(warnings, api.Warning{
+			Key: warning.Key,
+			Msg: warning.Msg,
+		})
+	}
+	return warnings, nil
+}
+
+func (m *clusterManager) SetNmvbConfigOncePerRev(clusterID string, enabled bool) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	ncluster.Mock.NotMyVbuckets().SetConfigOncePerRev(enabled)
+	return nil
+}
+
+func (m *clusterManager) NmvbStats(clusterID string) ([]api.NmvbStats, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	stats := make([]api.NmvbStats, 0)
+	for _, clientStats := range ncluster.Mock.NotMyVbuckets().Stats() {
+		stats = append(stats, api.NmvbStats{
+			Client:          clientStats.Client,
+			NotMyVbuckets:   clientStats.NotMyVbuckets,
+			EmbeddedConfigs: clientStats.EmbeddedConfigs,
+			ConfigFetches:   clientStats.ConfigFetches,
+			FetchRate:       clientStats.FetchRate(),
+		})
+	}
+	return stats, nil
+}
+
+func (m *clusterManager) SetTask(clusterID, taskID, taskType, subtype, status, bucketName string,
+	progress int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if bucketName != "" && ncluster.Mock.GetBucket(bucketName) == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	return ncluster.Mock.Tasks().SetTask(mock.Task{
+		ID:       taskID,
+		Type:     taskType,
+		Subtype:  subtype,
+		Status:   status,
+		Bucket:   bucketName,
+		Progress: progress,
+	})
+}
+
+func (m *clusterManager) RemoveTask(clusterID, taskID string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if !ncluster.Mock.Tasks().RemoveTask(taskID) {
+		return errors.New("invalid task id")
+	}
+	return nil
+}
+
+func (m *clusterManager) SetAuthStale(clusterID, userName, clientAddr string) (int, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return 0, errors.New("invalid cluster id")
+	}
+
+	count := 0
+	for _, node := range ncluster.Mock.Nodes() {
+		kvService := node.KvService()
+		if kvService == nil {
+			continue
+		}
+
+		for _, client := range kvService.GetAllClients() {
+			// Only connections which have authenticated can have stale auth.
+			if client.AuthenticatedUserName() == "" {
+				continue
+			}
+			if userName != "" && client.AuthenticatedUserName() != userName {
+				continue
+			}
+			if clientAddr != "" && client.RemoteAddr().String() != clientAddr {
+				continue
+			}
+
+			client.MarkAuthStale()
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *clusterManager) AuditEvents(clusterID string) ([]api.AuditEvent, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	events := make([]api.AuditEvent, 0)
+	for _, event := range ncluster.Mock.AuditLog().Events() {
+		events = append(events, api.AuditEvent{
+			ID:        int(event.ID),
+			Name:      event.ID.Name(),
+			Timestamp: event.Timestamp.UTC().Format(time.RFC3339Nano),
+			User:      event.User,
+			Remote:    event.Remote,
+			Fields:    event.Fields,
+		})
+	}
+	return events, nil
+}
+
+func (m *clusterManager) SetQueryIndexState(clusterID, bucketName, indexName, state string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if ncluster.Mock.GetBucket(bucketName) == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	return ncluster.Mock.QueryIndexes().SetIndexState(bucketName, indexName, mock.QueryIndexState(state))
+}
+
+func (m *clusterManager) TLSConnections(clusterID string) ([]api.TLSConnection, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	conns := make([]api.TLSConnection, 0)
+	for _, conn := range ncluster.Mock.TLSConnections().Connections() {
+		serviceName := ""
+		for name, service := range serviceTypesByName {
+			if service == conn.Service {
+				serviceName = name
+			}
+		}
+
+		conns = append(conns, api.TLSConnection{
+			Service:            serviceName,
+			LocalAddr:          conn.LocalAddr,
+			RemoteAddr:         conn.RemoteAddr,
+			Version:            conn.Version,
+			CipherSuite:        conn.CipherSuite,
+			ServerName:         conn.ServerName,
+			NegotiatedProtocol: conn.NegotiatedProtocol,
+		})
+	}
+	return conns, nil
+}
+
+func (m *clusterManager) SetKvConnLimit(clusterID, bucketName, userName string, limit int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if (bucketName == "") == (userName == "") {
+		return errors.New("exactly one of bucket and user must be specified")
+	}
+
+	limits := ncluster.Mock.KvConnectionLimits()
+	if bucketName != "" {
+		if ncluster.Mock.GetBucket(bucketName) == nil {
+			return errors.New("invalid bucket name")
+		}
+		return limits.SetBucketLimit(bucketName, limit)
+	}
+	return limits.SetUserLimit(userName, limit)
+}
+
+func (m *clusterManager) KvConnLimitStats(clusterID string) ([]api.KvConnLimitStats, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	stats := make([]api.KvConnLimitStats, 0)
+	for _, limitStats := range ncluster.Mock.KvConnectionLimits().Stats() {
+		stats = append(stats, api.KvConnLimitStats{
+			BucketName: limitStats.Bucket,
+			User:       limitStats.User,
+			Rejected:   limitStats.Rejected,
+		})
+	}
+	return stats, nil
+}
+
+func (m *clusterManager) ResponseLeaks(clusterID string) (uint64, uint64, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return 0, 0, errors.New("invalid cluster id")
+	}
+
+	counters := ncluster.Mock.RequestCounters()
+	return counters.LeakedKvResponses(), counters.DroppedKvResponses(), nil
+}
+
+func (m *clusterManager) SetSyncWriteDuration(clusterID, bucketName string, duration time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	bucket.SyncWrites().SetCommitDuration(duration)
+	return nil
+}
+
+// SetChaosMode replaces the chaos injected into a cluster, with nil settings
+// disabling chaos mode.
+func (m *clusterManager) SetChaosMode(clusterID string, settings *chaosSettings) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if settings != nil {
+		if settings.Intensity == 0 {
+			settings.Intensity = 1
+		}
+		if err := settings.validate(); err != nil {
+			return err
+		}
+	}
+
+	if ncluster.chaos != nil {
+		ncluster.chaos.Stop()
+		ncluster.chaos = nil
+	}
+
+	if settings != nil {
+		ncluster.chaos = newChaosController(ncluster.Mock, *settings)
+	}
+	return nil
+}
+
+func (m *clusterManager) PauseNode(clusterID string, nodeIdx int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	nodes[nodeIdx].Pause()
+	return nil
+}
+
+func (m *clusterManager) ResumeNode(clusterID string, nodeIdx int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	nodes[nodeIdx].Resume()
+	return nil
+}
+
+func (m *clusterManager) SetKvLatency(clusterID string, nodeIdx int, cmd uint8,
+	p50, p99, p999 time.Duration, seed *int64) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	kvService := nodes[nodeIdx].KvService()
+	if kvService == nil {
+		return errors.New("node has no kv service")
+	}
+
+	if seed != nil {
+		kvService.SetLatencySeed(*seed)
+	}
+
+	// An empty distribution removes the latency from the command.
+	dist := mock.LatencyDistribution{P50: p50, P99: p99, P999: p999}
+	if dist == (mock.LatencyDistribution{}) {
+		return kvService.SetCommandLatency(memd.CmdCode(cmd), nil)
+	}
+
+	return kvService.SetCommandLatency(memd.CmdCode(cmd), &dist)
+}
+
+func (m *clusterManager) AddTrustedCA(clusterID string, certPem []byte) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	return ncluster.Mock.AddTrustedCA(certPem)
+}
+
+func (m *clusterManager) SetKvHang(clusterID string, nodeIdx int, cmd uint8, action string,
+	timeout time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	kvService := nodes[nodeIdx].KvService()
+	if kvService == nil {
+		return errors.New("node has no kv service")
+	}
+
+	// An empty action removes the hang from the command.
+	if action == "" {
+		return kvService.SetCommandHang(memd.CmdCode(cmd), nil)
+	}
+
+	return kvService.SetCommandHang(memd.CmdCode(cmd), &mock.KvCommandHang{
+		Action:  mock.KvHangAction(action),
+		Timeout: timeout,
+	})
+}
+
+func (m *clusterManager) ReplayKvTrace(clusterID string, nodeIdx int, responses []api.ReplayKvResponse) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	kvService := nodes[nodeIdx].KvService()
+	if kvService == nil {
+		return errors.New("node has no kv service")
+	}
+
+	var trace []mock.KvTraceResponse
+	for _, resp := range responses {
+		var cas uint64
+		if resp.CasHex != "" {
+			parsedCas, err := strconv.ParseUint(resp.CasHex, 16, 64)
+			if err != nil {
+				return errors.New("invalid response cas")
+			}
+			cas = parsedCas
+		}
+
+		if resp.DelayMs < 0 {
+			return errors.New("invalid response delay")
+		}
+
+		trace = append(trace, mock.KvTraceResponse{
+			Command:  memd.CmdCode(resp.Command),
+			Status:   memd.StatusCode(resp.Status),
+			Datatype: resp.Datatype,
+			Cas:      cas,
+			Key:      resp.Key,
+			Extras:   resp.Extras,
+			Value:    resp.Value,
+			Delay:    time.Duration(resp.DelayMs) * time.Millisecond,
+		})
+	}
+
+	kvService.ReplayTrace(trace)
+	return nil
+}
+
+func (m *clusterManager) ChangeNodeAddress(clusterID string, nodeIdx int, hostname string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	if nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return errors.New("invalid node index")
+	}
+
+	return nodes[nodeIdx].ChangeAddress(hostname)
+}
+
+func (m *clusterManager) SetThrottleWarning(clusterID, bucketName, user string, enabled bool) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	bucket.SetThrottleWarning(user, enabled)
+	return nil
+}
+
+// SetHTTPBusy makes the next count requests to an endpoint of a service fail
+// with a 503 and a Retry-After header.  An empty method matches any method.
+func (m *clusterManager) SetHTTPBusy(clusterID, service, method, path string, count int,
+	retryAfter time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if count <= 0 {
+		return errors.New("invalid request count")
+	}
+
+	return addHTTPBusyRule(ncluster.Mock, service, &httpBusyRule{
+		method:     method,
+		parser:     pathparse.NewParser(path),
+		remaining:  count,
+		retryAfter: retryAfter,
+	})
+}
+
+func (m *clusterManager) SetQueryRowHook(clusterID string, rows, afterRows int, action string, nodeIdx int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if afterRows < 0 || afterRows >= rows {
+		return errors.New("invalid row count")
+	}
+
+	var actionFn func() error
+	switch action {
+	case "failover":
+		nodes := ncluster.Mock.Nodes()
+		if nodeIdx < 0 || nodeIdx >= len(nodes) {
+			return errors.New("invalid node index")
+		}
+
+		nodeID := nodes[nodeIdx].ID()
+		actionFn = func() error {
+			return ncluster.Mock.FailoverNode(nodeID)
+		}
+	case "bumpconfigrev":
+		actionFn = func() error {
+			ncluster.Mock.BumpConfigRev()
+			return nil
+		}
+	default:
+		return errors.New("invalid action")
+	}
+
+	ncluster.Mock.SetQueryResultProvider(&queryRowHook{
+		cluster:   ncluster.Mock,
+		numRows:   rows,
+		afterRows: afterRows,
+		action:    actionFn,
+	})
+	return nil
+}
+
+func (m *clusterManager) SetClusterCapabilities(clusterID string, caps map[string][]string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	// Not specifying any capabilities restores the defaults.
+	if caps == nil {
+		ncluster.Mock.SetClusterCapabilities(mock.DefaultClusterCapabilities(ncluster.Mock.Version()))
+		return nil
+	}
+
+	ncluster.Mock.SetClusterCapabilities(caps)
+	return nil
+}
+
+func (m *clusterManager) BumpConfigRev(clusterID string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	ncluster.Mock.BumpConfigRev()
+	return nil
+}
+
+func (m *clusterManager) SetKvOrphanTimeout(clusterID string, timeout time.Duration) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if timeout < 0 {
+		return errors.New("invalid orphan timeout")
+	}
+
+	ncluster.Mock.SetKvOrphanTimeout(timeout)
+	return nil
+}
+
+func (m *clusterManager) OrphanedResponses(clusterID string) (uint64, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return 0, errors.New("invalid cluster id")
+	}
+
+	return ncluster.Mock.RequestCounters().OrphanedKvResponses(), nil
+}
+
+func (m *clusterManager) SetConfigScenario(clusterID, scenarioName string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	scenario, err := mock.ParseConfigScenario(scenarioName)
+	if err != nil {
+		return err
+	}
+
+	ncluster.Mock.SetConfigScenario(scenario)
+	return nil
+}
+
+func (m *clusterManager) DiscardMutations(clusterID, bucketName string, vbIdx uint, seqNo uint64) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	return bucket.Store().DiscardMutationsAfter(vbIdx, seqNo)
+}
+
+func (m *clusterManager) SetReplicaLag(clusterID, bucketName string, vbIdx, replicaIdx uint, lagSeqNos uint64,
+	latency time.Duration, reset bool) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if replicaIdx >= bucket.NumReplicas() {
+		return errors.New("invalid replica index")
+	}
+
+	var lag *mockdb.ReplicaLag
+	if !reset {
+		lag = &mockdb.ReplicaLag{
+			SeqNos:  lagSeqNos,
+			Latency: latency,
+		}
+	}
+
+	// The store numbers the copies of a vbucket with the master first.
+	return bucket.Store().SetReplicaLag(vbIdx, replicaIdx+1, lag)
+}
+
+func (m *clusterManager) MarkMutationUnreplicated(clusterID, bucketName, scopeName, collectionName, key string,
+	seqNo uint64) (uint64, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return 0, errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return 0, errors.New("invalid bucket name")
+	}
+
+	if scopeName == "" {
+		scopeName = "_default"
+	}
+	if collectionName == "" {
+		collectionName = "_default"
+	}
+
+	_, collectionID, err := bucket.CollectionManifest().GetByName(scopeName, collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	store := bucket.Store()
+	vbID := store.VbucketForKey([]byte(key))
+	return store.MarkMutationUnreplicated(vbID, uint(collectionID), []byte(key), seqNo)
+}
+
+func (m *clusterManager) SetVbucketMap(clusterID, bucketName string, vbMap [][]int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	nodes := ncluster.Mock.Nodes()
+	nodeVbMap := make([][]string, len(vbMap))
+	for vbIdx, vb := range vbMap {
+		nodeVbMap[vbIdx] = make([]string, len(vb))
+		for repIdx, nodeIdx := range vb {
+			if nodeIdx == -1 {
+				continue
+			}
+			if nodeIdx < 0 || nodeIdx >= len(nodes) {
+				return errors.New("invalid node index")
+			}
+			nodeVbMap[vbIdx][repIdx] = nodes[nodeIdx].ID()
+		}
+	}
+
+	return bucket.SetVbMap(nodeVbMap)
+}
+
+func (m *clusterManager) SetCompactionSteps(clusterID, bucketName string, steps int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if steps < 0 {
+		return errors.New("invalid compaction steps")
+	}
+
+	bucket.SetCompactionSteps(steps)
+	return nil
+}
+
+func (m *clusterManager) StepCompaction(clusterID, bucketName string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	return bucket.StepCompaction()
+}
+
+func (m *clusterManager) SetPauseSteps(clusterID, bucketName string, steps int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if steps < 0 {
+		return errors.New("invalid pause steps")
+	}
+
+	bucket.SetPauseSteps(steps)
+	return nil
+}
+
+func (m *clusterManager) StepPause(clusterID, bucketName string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	return bucket.StepPause()
+}
+
+func (m *clusterManager) SetFailoverSteps(clusterID string, steps int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if steps < 0 {
+		return errors.New("invalid failover steps")
+	}
+
+	ncluster.Mock.SetGracefulFailoverSteps(steps)
+	return nil
+}
+
+func (m *clusterManager) SetMaxBucketCount(clusterID string, count int) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	if count < 0 {
+		return errors.New("invalid max bucket count")
+	}
+
+	ncluster.Mock.SetMaxBucketCount(count)
+	return nil
+}
+
+func (m *clusterManager) SetNodeMemory(clusterID string, memoryMB uint64) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	ncluster.Mock.SetNodeMemoryTotal(memoryMB * 1024 * 1024)
+	return nil
+}
+
+func (m *clusterManager) StepFailover(clusterID string) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	return ncluster.Mock.StepGracefulFailover()
+}
+
+func (m *clusterManager) SeedDocuments(clusterID, bucketName, scopeName, collectionName string,
+	docs map[string]json.RawMessage) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if scopeName == "" {
+		scopeName = "_default"
+	}
+	if collectionName == "" {
+		collectionName = "_default"
+	}
+
+	_, collectionID, err := bucket.CollectionManifest().GetByName(scopeName, collectionName)
+	if err != nil {
+		return err
+	}
+
+	seedDocs := make([]*mockdb.Document, 0, len(docs))
+	for key, value := range docs {
+		seedDocs = append(seedDocs, &mockdb.Document{
+			CollectionID: uint(collectionID),
+			Key:          []byte(key),
+			Value:        value,
+			Datatype:     uint8(memd.DatatypeFlagJSON),
+		})
+	}
+
+	_, err = bucket.Store().BulkLoad(seedDocs, mockdb.BulkLoadOptions{HashKeys: true})
+	return err
+}
+
+func (m *clusterManager) CorruptDocument(clusterID, bucketName, scopeName, collectionName, key string,
+	value []byte, datatype uint8) error {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return errors.New("invalid cluster id")
+	}
+
+	bucket := ncluster.Mock.GetBucket(bucketName)
+	if bucket == nil {
+		return errors.New("invalid bucket name")
+	}
+
+	if scopeName == "" {
+		scopeName = "_default"
+	}
+	if collectionName == "" {
+		collectionName = "_default"
+	}
+
+	_, collectionID, err := bucket.CollectionManifest().GetByName(scopeName, collectionName)
+	if err != nil {
+		return err
+	}
+
+	store := bucket.Store()
+	vbID := store.VbucketForKey([]byte(key))
+	return store.CorruptDocument(vbID, uint(collectionID), []byte(key), value, datatype)
+}
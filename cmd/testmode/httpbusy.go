@@ -0,0 +1,123 @@
+package testmode
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// httpBusyRule makes the requests matching an endpoint fail with a 503 and a
+// Retry-After header, until it has been applied a set number of times.  Once
+// exhausted, its hook is removed from the service it was added to.
+type httpBusyRule struct {
+	lock       sync.Mutex
+	method     string
+	parser     *pathparse.Parser
+	remaining  int
+	retryAfter time.Duration
+	destroy    func()
+}
+
+// apply returns the busy response for a request, or nil if the request does
+// not match or the rule has been exhausted.
+func (r *httpBusyRule) apply(req *mock.HTTPRequest) *mock.HTTPResponse {
+	if r.method != "" && req.Method != r.method {
+		return nil
+	}
+	if !r.parser.Match(req.URL.Path) {
+		return nil
+	}
+
+	r.lock.Lock()
+	if r.remaining <= 0 {
+		r.lock.Unlock()
+		return nil
+	}
+	r.remaining--
+	exhausted := r.remaining == 0
+	r.lock.Unlock()
+
+	if exhausted && r.destroy != nil {
+		r.destroy()
+	}
+
+	return httpBusyResponse(r.retryAfter)
+}
+
+// httpBusyResponse returns a 503 response which asks for the request to be
+// retried after a delay.
+func httpBusyResponse(retryAfter time.Duration) *mock.HTTPResponse {
+	// Retry-After is specified in whole seconds, so we round up.
+	retryAfterSecs := int((retryAfter + time.Second - 1) / time.Second)
+
+	header := make(http.Header)
+	header.Set("Retry-After", strconv.Itoa(retryAfterSecs))
+	return &mock.HTTPResponse{
+		StatusCode: 503,
+		Header:     header,
+		Body:       bytes.NewReader([]byte("Service Unavailable")),
+	}
+}
+
+// addHTTPBusyRule hooks a busy rule into the requests of a specific service.
+// Each rule is added to its own child hook manager so that it can be removed
+// on its own once exhausted.
+func addHTTPBusyRule(cluster mock.Cluster, service string, rule *httpBusyRule) error {
+	switch service {
+	case "mgmt":
+		hooks := cluster.MgmtHooks().Child()
+		rule.destroy = hooks.Destroy
+		hooks.Add(func(source mock.MgmtService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+			if resp := rule.apply(req); resp != nil {
+				return resp
+			}
+			return next()
+		})
+	case "query":
+		hooks := cluster.QueryHooks().Child()
+		rule.destroy = hooks.Destroy
+		hooks.Add(func(source mock.QueryService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+			if resp := rule.apply(req); resp != nil {
+				return resp
+			}
+			return next()
+		})
+	case "analytics":
+		hooks := cluster.AnalyticsHooks().Child()
+		rule.destroy = hooks.Destroy
+		hooks.Add(func(source mock.AnalyticsService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+			if resp := rule.apply(req); resp != nil {
+				return resp
+			}
+			return next()
+		})
+	case "search":
+		hooks := cluster.SearchHooks().Child()
+		rule.destroy = hooks.Destroy
+		hooks.Add(func(source mock.SearchService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+			if resp := rule.apply(req); resp != nil {
+				return resp
+			}
+			return next()
+		})
+	case "views":
+		hooks := cluster.ViewHooks().Child()
+		rule.destroy = hooks.Destroy
+		hooks.Add(func(source mock.ViewService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+			if resp := rule.apply(req); resp != nil {
+				return resp
+			}
+			return next()
+		})
+	default:
+		return errors.New("invalid service")
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package testmode
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPBusyRuleExhausted(t *testing.T) {
+	cluster, err := mockimpl.NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	mgmt := cluster.Nodes()[0].MgmtService()
+	poolsURL := "http://" + net.JoinHostPort(mgmt.Hostname(), strconv.Itoa(mgmt.ListenPort())) + "/pools"
+
+	err = addHTTPBusyRule(cluster, "mgmt", &httpBusyRule{
+		method:     "GET",
+		parser:     pathparse.NewParser("/pools"),
+		remaining:  2,
+		retryAfter: 1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to add busy rule: %v", err)
+	}
+
+	getPools := func() *http.Response {
+		req, err := http.NewRequest("GET", poolsURL, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.SetBasicAuth("Administrator", "password")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := getPools()
+		assert.Equal(t, 503, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	}
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 200, getPools().StatusCode)
+	}
+}
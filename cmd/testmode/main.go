@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/couchbaselabs/gocaves/cmd/api"
+	"github.com/couchbaselabs/gocaves/mock"
 )
 
 // Main wraps the linkmode cmd
@@ -78,8 +79,11 @@ func (m *Main) maybeSendReport(report *jsonRunReport) {
 
 func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 	switch pktTyped := pkt.(type) {
+	case *api.CmdGetVersion:
+		return &api.CmdVersion{Version: api.ProtocolVersion}
 	case *api.CmdCreateCluster:
-		cluster, err := m.clusterMgr.NewCluster(pktTyped.ClusterID)
+		cluster, err := m.clusterMgr.NewCluster(pktTyped.ClusterID, pktTyped.Deterministic,
+			pktTyped.Edition, pktTyped.Version)
 		if err != nil {
 			log.Printf("failed to create cluster: %s", err)
 			return &api.CmdCreatedCluster{}
@@ -158,6 +162,499 @@ func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 		}
 
 		return &api.CmdAddedBucket{}
+	case *api.CmdAddNode:
+		nodeIdx, err := m.clusterMgr.AddNode(pktTyped.ClusterID, pktTyped.Services, pktTyped.ServerGroup)
+		if err != nil {
+			log.Printf("failed to add node: %s", err)
+			return &api.CmdAddedNode{Error: err.Error()}
+		}
+
+		return &api.CmdAddedNode{NodeIdx: nodeIdx}
+	case *api.CmdSetServerGroup:
+		err := m.clusterMgr.SetServerGroup(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.ServerGroup)
+		if err != nil {
+			log.Printf("failed to set server group: %s", err)
+			return &api.CmdServerGroupSet{Error: err.Error()}
+		}
+
+		return &api.CmdServerGroupSet{}
+	case *api.CmdFailoverNode:
+		err := m.clusterMgr.FailoverNode(pktTyped.ClusterID, pktTyped.NodeIdx)
+		if err != nil {
+			log.Printf("failed to failover node: %s", err)
+			return &api.CmdNodeFailedOver{Error: err.Error()}
+		}
+
+		return &api.CmdNodeFailedOver{}
+	case *api.CmdRebalance:
+		err := m.clusterMgr.Rebalance(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to rebalance: %s", err)
+			return &api.CmdRebalanced{Error: err.Error()}
+		}
+
+		return &api.CmdRebalanced{}
+	case *api.CmdEvacuateNode:
+		err := m.clusterMgr.EvacuateNode(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.NodeIdx)
+		if err != nil {
+			log.Printf("failed to evacuate node: %s", err)
+			return &api.CmdNodeEvacuated{Error: err.Error()}
+		}
+
+		return &api.CmdNodeEvacuated{}
+	case *api.CmdPauseNode:
+		err := m.clusterMgr.PauseNode(pktTyped.ClusterID, pktTyped.NodeIdx)
+		if err != nil {
+			log.Printf("failed to pause node: %s", err)
+			return &api.CmdNodePaused{Error: err.Error()}
+		}
+
+		return &api.CmdNodePaused{}
+	case *api.CmdResumeNode:
+		err := m.clusterMgr.ResumeNode(pktTyped.ClusterID, pktTyped.NodeIdx)
+		if err != nil {
+			log.Printf("failed to resume node: %s", err)
+			return &api.CmdNodeResumed{Error: err.Error()}
+		}
+
+		return &api.CmdNodeResumed{}
+	case *api.CmdCorruptDocument:
+		err := m.clusterMgr.CorruptDocument(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.ScopeName,
+			pktTyped.CollectionName, pktTyped.Key, pktTyped.Value, pktTyped.Datatype)
+		if err != nil {
+			log.Printf("failed to corrupt document: %s", err)
+			return &api.CmdCorruptedDocument{Error: err.Error()}
+		}
+
+		return &api.CmdCorruptedDocument{}
+	case *api.CmdSetClusterCapabilities:
+		err := m.clusterMgr.SetClusterCapabilities(pktTyped.ClusterID, pktTyped.Capabilities)
+		if err != nil {
+			log.Printf("failed to set cluster capabilities: %s", err)
+			return &api.CmdClusterCapabilitiesSet{Error: err.Error()}
+		}
+
+		return &api.CmdClusterCapabilitiesSet{}
+	case *api.CmdDiscardMutations:
+		err := m.clusterMgr.DiscardMutations(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.Vbucket, pktTyped.SeqNo)
+		if err != nil {
+			log.Printf("failed to discard mutations: %s", err)
+			return &api.CmdDiscardedMutations{Error: err.Error()}
+		}
+
+		return &api.CmdDiscardedMutations{}
+	case *api.CmdSetReplicaLag:
+		err := m.clusterMgr.SetReplicaLag(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.Vbucket,
+			pktTyped.ReplicaIdx, pktTyped.LagSeqNos, time.Duration(pktTyped.LagMs)*time.Millisecond, pktTyped.Reset)
+		if err != nil {
+			log.Printf("failed to set replica lag: %s", err)
+			return &api.CmdReplicaLagSet{Error: err.Error()}
+		}
+
+		return &api.CmdReplicaLagSet{}
+	case *api.CmdMarkMutationUnreplicated:
+		seqNo, err := m.clusterMgr.MarkMutationUnreplicated(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.ScopeName,
+			pktTyped.CollectionName, pktTyped.Key, pktTyped.SeqNo)
+		if err != nil {
+			log.Printf("failed to mark mutation unreplicated: %s", err)
+			return &api.CmdMutationUnreplicatedMarked{Error: err.Error()}
+		}
+
+		return &api.CmdMutationUnreplicatedMarked{SeqNo: seqNo}
+	case *api.CmdSetVbucketMap:
+		err := m.clusterMgr.SetVbucketMap(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.VbMap)
+		if err != nil {
+			log.Printf("failed to set vbucket map: %s", err)
+			return &api.CmdVbucketMapSet{Error: err.Error()}
+		}
+
+		return &api.CmdVbucketMapSet{}
+	case *api.CmdSetHLCDrift:
+		err := m.clusterMgr.SetHLCDrift(pktTyped.ClusterID, pktTyped.BucketName,
+			time.Duration(pktTyped.DriftMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set hlc drift: %s", err)
+			return &api.CmdHLCDriftSet{Error: err.Error()}
+		}
+
+		return &api.CmdHLCDriftSet{}
+	case *api.CmdSetSASLMechanisms:
+		err := m.clusterMgr.SetSASLMechanisms(pktTyped.ClusterID, pktTyped.Mechanisms)
+		if err != nil {
+			log.Printf("failed to set sasl mechanisms: %s", err)
+			return &api.CmdSASLMechanismsSet{Error: err.Error()}
+		}
+
+		return &api.CmdSASLMechanismsSet{}
+	case *api.CmdSetDocumentLimits:
+		err := m.clusterMgr.SetDocumentLimits(pktTyped.ClusterID, pktTyped.BucketName,
+			pktTyped.MaxDepth, pktTyped.Strict)
+		if err != nil {
+			log.Printf("failed to set document limits: %s", err)
+			return &api.CmdDocumentLimitsSet{Error: err.Error()}
+		}
+
+		return &api.CmdDocumentLimitsSet{}
+	case *api.CmdSetClockSkew:
+		err := m.clusterMgr.SetClockSkew(pktTyped.ClusterID, pktTyped.NodeIdx,
+			time.Duration(pktTyped.SkewMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set clock skew: %s", err)
+			return &api.CmdClockSkewSet{Error: err.Error()}
+		}
+
+		return &api.CmdClockSkewSet{}
+	case *api.CmdSetMemoryPressure:
+		err := m.clusterMgr.SetMemoryPressure(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.Percent)
+		if err != nil {
+			log.Printf("failed to set memory pressure: %s", err)
+			return &api.CmdMemoryPressureSet{Error: err.Error()}
+		}
+
+		return &api.CmdMemoryPressureSet{}
+	case *api.CmdSetManifestStagger:
+		err := m.clusterMgr.SetManifestStagger(pktTyped.ClusterID, pktTyped.BucketName,
+			time.Duration(pktTyped.StaggerMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set manifest stagger: %s", err)
+			return &api.CmdManifestStaggerSet{Error: err.Error()}
+		}
+
+		return &api.CmdManifestStaggerSet{}
+	case *api.CmdSetRangeScanIdleTimeout:
+		err := m.clusterMgr.SetRangeScanIdleTimeout(pktTyped.ClusterID, pktTyped.BucketName,
+			time.Duration(pktTyped.TimeoutMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set range scan idle timeout: %s", err)
+			return &api.CmdRangeScanIdleTimeoutSet{Error: err.Error()}
+		}
+
+		return &api.CmdRangeScanIdleTimeoutSet{}
+	case *api.CmdSetQueryWarnings:
+		err := m.clusterMgr.SetQueryWarnings(pktTyped.ClusterID, pktTyped.Warnings)
+		if err != nil {
+			log.Printf("failed to set query warnings: %s", err)
+			return &api.CmdQueryWarningsSet{Error: err.Error()}
+		}
+
+		return &api.CmdQueryWarningsSet{}
+	case *api.CmdSetSubDocWarning:
+		err := m.clusterMgr.SetSubDocWarning(pktTyped.ClusterID, pktTyped.Key, pktTyped.Msg)
+		if err != nil {
+			log.Printf("failed to set subdoc warning: %s", err)
+			return &api.CmdSubDocWarningSet{Error: err.Error()}
+		}
+
+		return &api.CmdSubDocWarningSet{}
+	case *api.CmdGetSubDocWarnings:
+		warnings, err := m.clusterMgr.SubDocWarnings(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get subdoc warnings: %s", err)
+			return &api.CmdSubDocWarnings{Error: err.Error()}
+		}
+
+		return &api.CmdSubDocWarnings{Warnings: warnings}
+	case *api.CmdSetNmvbConfigOncePerRev:
+		err := m.clusterMgr.SetNmvbConfigOncePerRev(pktTyped.ClusterID, pktTyped.Enabled)
+		if err != nil {
+			log.Printf("failed to set nmvb config once per rev: %s", err)
+			return &api.CmdNmvbConfigOncePerRevSet{Error: err.Error()}
+		}
+
+		return &api.CmdNmvbConfigOncePerRevSet{}
+	case *api.CmdGetNmvbStats:
+		stats, err := m.clusterMgr.NmvbStats(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get nmvb stats: %s", err)
+			return &api.CmdNmvbStats{Error: err.Error()}
+		}
+
+		return &api.CmdNmvbStats{Clients: stats}
+	case *api.CmdSetTask:
+		err := m.clusterMgr.SetTask(pktTyped.ClusterID, pktTyped.TaskID, pktTyped.Type, pktTyped.Subtype,
+			pktTyped.Status, pktTyped.BucketName, pktTyped.Progress)
+		if err != nil {
+			log.Printf("failed to set task: %s", err)
+			return &api.CmdTaskSet{Error: err.Error()}
+		}
+
+		return &api.CmdTaskSet{}
+	case *api.CmdRemoveTask:
+		err := m.clusterMgr.RemoveTask(pktTyped.ClusterID, pktTyped.TaskID)
+		if err != nil {
+			log.Printf("failed to remove task: %s", err)
+			return &api.CmdTaskRemoved{Error: err.Error()}
+		}
+
+		return &api.CmdTaskRemoved{}
+	case *api.CmdSetAuthStale:
+		count, err := m.clusterMgr.SetAuthStale(pktTyped.ClusterID, pktTyped.User, pktTyped.ClientAddr)
+		if err != nil {
+			log.Printf("failed to set auth stale: %s", err)
+			return &api.CmdAuthStaleSet{Error: err.Error()}
+		}
+
+		return &api.CmdAuthStaleSet{Count: count}
+	case *api.CmdGetAuditEvents:
+		events, err := m.clusterMgr.AuditEvents(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get audit events: %s", err)
+			return &api.CmdAuditEvents{Error: err.Error()}
+		}
+
+		return &api.CmdAuditEvents{Events: events}
+	case *api.CmdSetQueryIndexState:
+		err := m.clusterMgr.SetQueryIndexState(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.IndexName,
+			pktTyped.State)
+		if err != nil {
+			log.Printf("failed to set query index state: %s", err)
+			return &api.CmdQueryIndexStateSet{Error: err.Error()}
+		}
+
+		return &api.CmdQueryIndexStateSet{}
+	case *api.CmdGetTLSConnections:
+		conns, err := m.clusterMgr.TLSConnections(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get tls connections: %s", err)
+			return &api.CmdTLSConnections{Error: err.Error()}
+		}
+
+		return &api.CmdTLSConnections{Connections: conns}
+	case *api.CmdSetKvConnLimit:
+		err := m.clusterMgr.SetKvConnLimit(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.User, pktTyped.Limit)
+		if err != nil {
+			log.Printf("failed to set kv conn limit: %s", err)
+			return &api.CmdKvConnLimitSet{Error: err.Error()}
+		}
+
+		return &api.CmdKvConnLimitSet{}
+	case *api.CmdGetKvConnLimitStats:
+		stats, err := m.clusterMgr.KvConnLimitStats(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get kv conn limit stats: %s", err)
+			return &api.CmdKvConnLimitStats{Error: err.Error()}
+		}
+
+		return &api.CmdKvConnLimitStats{Limits: stats}
+	case *api.CmdGetResponseLeaks:
+		leaked, dropped, err := m.clusterMgr.ResponseLeaks(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get response leaks: %s", err)
+			return &api.CmdResponseLeaks{Error: err.Error()}
+		}
+
+		return &api.CmdResponseLeaks{Leaked: leaked, Dropped: dropped}
+	case *api.CmdSetSyncWriteDuration:
+		err := m.clusterMgr.SetSyncWriteDuration(pktTyped.ClusterID, pktTyped.BucketName,
+			time.Duration(pktTyped.DurationMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set sync write duration: %s", err)
+			return &api.CmdSyncWriteDurationSet{Error: err.Error()}
+		}
+
+		return &api.CmdSyncWriteDurationSet{}
+	case *api.CmdSetChaosMode:
+		var settings *chaosSettings
+		if pktTyped.Enabled {
+			settings = &chaosSettings{
+				Seed:               pktTyped.Seed,
+				Intensity:          pktTyped.Intensity,
+				LatencyProbability: pktTyped.LatencyProbability,
+				Latency: mock.LatencyDistribution{
+					P50:  time.Duration(pktTyped.LatencyP50Ms) * time.Millisecond,
+					P99:  time.Duration(pktTyped.LatencyP99Ms) * time.Millisecond,
+					P999: time.Duration(pktTyped.LatencyP999Ms) * time.Millisecond,
+				},
+				ErrorProbability:       pktTyped.ErrorProbability,
+				DisconnectProbability:  pktTyped.DisconnectProbability,
+				ConfigChurnProbability: pktTyped.ConfigChurnProbability,
+			}
+		}
+
+		err := m.clusterMgr.SetChaosMode(pktTyped.ClusterID, settings)
+		if err != nil {
+			log.Printf("failed to set chaos mode: %s", err)
+			return &api.CmdChaosModeSet{Error: err.Error()}
+		}
+
+		return &api.CmdChaosModeSet{}
+	case *api.CmdSeedDocuments:
+		err := m.clusterMgr.SeedDocuments(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.ScopeName,
+			pktTyped.CollectionName, pktTyped.Documents)
+		if err != nil {
+			log.Printf("failed to seed documents: %s", err)
+			return &api.CmdSeededDocuments{Error: err.Error()}
+		}
+
+		return &api.CmdSeededDocuments{}
+	case *api.CmdBumpConfigRev:
+		err := m.clusterMgr.BumpConfigRev(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to bump config rev: %s", err)
+			return &api.CmdConfigRevBumped{Error: err.Error()}
+		}
+
+		return &api.CmdConfigRevBumped{}
+	case *api.CmdSetKvOrphanTimeout:
+		err := m.clusterMgr.SetKvOrphanTimeout(pktTyped.ClusterID, time.Duration(pktTyped.TimeoutMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set kv orphan timeout: %s", err)
+			return &api.CmdKvOrphanTimeoutSet{Error: err.Error()}
+		}
+
+		return &api.CmdKvOrphanTimeoutSet{}
+	case *api.CmdGetOrphanedResponses:
+		count, err := m.clusterMgr.OrphanedResponses(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to get orphaned responses: %s", err)
+			return &api.CmdOrphanedResponses{Error: err.Error()}
+		}
+
+		return &api.CmdOrphanedResponses{Count: count}
+	case *api.CmdSetConfigScenario:
+		err := m.clusterMgr.SetConfigScenario(pktTyped.ClusterID, pktTyped.Scenario)
+		if err != nil {
+			log.Printf("failed to set config scenario: %s", err)
+			return &api.CmdConfigScenarioSet{Error: err.Error()}
+		}
+
+		return &api.CmdConfigScenarioSet{}
+	case *api.CmdSetKvLatency:
+		err := m.clusterMgr.SetKvLatency(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.Command,
+			time.Duration(pktTyped.P50Ms)*time.Millisecond,
+			time.Duration(pktTyped.P99Ms)*time.Millisecond,
+			time.Duration(pktTyped.P999Ms)*time.Millisecond,
+			pktTyped.Seed)
+		if err != nil {
+			log.Printf("failed to set kv latency: %s", err)
+			return &api.CmdKvLatencySet{Error: err.Error()}
+		}
+
+		return &api.CmdKvLatencySet{}
+	case *api.CmdAddTrustedCA:
+		err := m.clusterMgr.AddTrustedCA(pktTyped.ClusterID, []byte(pktTyped.Cert))
+		if err != nil {
+			log.Printf("failed to add trusted ca: %s", err)
+			return &api.CmdTrustedCAAdded{Error: err.Error()}
+		}
+
+		return &api.CmdTrustedCAAdded{}
+	case *api.CmdSetKvHang:
+		err := m.clusterMgr.SetKvHang(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.Command, pktTyped.Action,
+			time.Duration(pktTyped.TimeoutMs)*time.Millisecond)
+		if err != nil {
+			log.Printf("failed to set kv hang: %s", err)
+			return &api.CmdKvHangSet{Error: err.Error()}
+		}
+
+		return &api.CmdKvHangSet{}
+	case *api.CmdReplayKvTrace:
+		err := m.clusterMgr.ReplayKvTrace(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.Responses)
+		if err != nil {
+			log.Printf("failed to replay kv trace: %s", err)
+			return &api.CmdKvTraceReplayed{Error: err.Error()}
+		}
+
+		return &api.CmdKvTraceReplayed{}
+	case *api.CmdChangeNodeAddress:
+		err := m.clusterMgr.ChangeNodeAddress(pktTyped.ClusterID, pktTyped.NodeIdx, pktTyped.Hostname)
+		if err != nil {
+			log.Printf("failed to change node address: %s", err)
+			return &api.CmdNodeAddressChanged{Error: err.Error()}
+		}
+
+		return &api.CmdNodeAddressChanged{}
+	case *api.CmdSetThrottleWarning:
+		err := m.clusterMgr.SetThrottleWarning(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.User,
+			pktTyped.Enabled)
+		if err != nil {
+			log.Printf("failed to set throttle warning: %s", err)
+			return &api.CmdThrottleWarningSet{Error: err.Error()}
+		}
+
+		return &api.CmdThrottleWarningSet{}
+	case *api.CmdSetHTTPBusy:
+		err := m.clusterMgr.SetHTTPBusy(pktTyped.ClusterID, pktTyped.Service, pktTyped.Method, pktTyped.Path,
+			pktTyped.Count, time.Duration(pktTyped.RetryAfterSec)*time.Second)
+		if err != nil {
+			log.Printf("failed to set http busy: %s", err)
+			return &api.CmdHTTPBusySet{Error: err.Error()}
+		}
+
+		return &api.CmdHTTPBusySet{}
+	case *api.CmdSetQueryRowHook:
+		err := m.clusterMgr.SetQueryRowHook(pktTyped.ClusterID, pktTyped.Rows, pktTyped.AfterRows,
+			pktTyped.Action, pktTyped.NodeIdx)
+		if err != nil {
+			log.Printf("failed to set query row hook: %s", err)
+			return &api.CmdQueryRowHookSet{Error: err.Error()}
+		}
+
+		return &api.CmdQueryRowHookSet{}
+	case *api.CmdSetCompactionSteps:
+		err := m.clusterMgr.SetCompactionSteps(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.Steps)
+		if err != nil {
+			log.Printf("failed to set compaction steps: %s", err)
+			return &api.CmdCompactionStepsSet{Error: err.Error()}
+		}
+
+		return &api.CmdCompactionStepsSet{}
+	case *api.CmdStepCompaction:
+		err := m.clusterMgr.StepCompaction(pktTyped.ClusterID, pktTyped.BucketName)
+		if err != nil {
+			log.Printf("failed to step compaction: %s", err)
+			return &api.CmdCompactionStepped{Error: err.Error()}
+		}
+
+		return &api.CmdCompactionStepped{}
+	case *api.CmdSetPauseSteps:
+		err := m.clusterMgr.SetPauseSteps(pktTyped.ClusterID, pktTyped.BucketName, pktTyped.Steps)
+		if err != nil {
+			log.Printf("failed to set pause steps: %s", err)
+			return &api.CmdPauseStepsSet{Error: err.Error()}
+		}
+
+		return &api.CmdPauseStepsSet{}
+	case *api.CmdStepPause:
+		err := m.clusterMgr.StepPause(pktTyped.ClusterID, pktTyped.BucketName)
+		if err != nil {
+			log.Printf("failed to step pause: %s", err)
+			return &api.CmdPauseStepped{Error: err.Error()}
+		}
+
+		return &api.CmdPauseStepped{}
+	case *api.CmdSetFailoverSteps:
+		err := m.clusterMgr.SetFailoverSteps(pktTyped.ClusterID, pktTyped.Steps)
+		if err != nil {
+			log.Printf("failed to set failover steps: %s", err)
+			return &api.CmdFailoverStepsSet{Error: err.Error()}
+		}
+
+		return &api.CmdFailoverStepsSet{}
+	case *api.CmdSetMaxBucketCount:
+		err := m.clusterMgr.SetMaxBucketCount(pktTyped.ClusterID, pktTyped.Count)
+		if err != nil {
+			log.Printf("failed to set max bucket count: %s", err)
+			return &api.CmdMaxBucketCountSet{Error: err.Error()}
+		}
+
+		return &api.CmdMaxBucketCountSet{}
+	case *api.CmdSetNodeMemory:
+		err := m.clusterMgr.SetNodeMemory(pktTyped.ClusterID, pktTyped.MemoryMB)
+		if err != nil {
+			log.Printf("failed to set node memory: %s", err)
+			return &api.CmdNodeMemorySet{Error: err.Error()}
+		}
+
+		return &api.CmdNodeMemorySet{}
+	case *api.CmdStepFailover:
+		err := m.clusterMgr.StepFailover(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to step failover: %s", err)
+			return &api.CmdFailoverStepped{Error: err.Error()}
+		}
+
+		return &api.CmdFailoverStepped{}
 	}
 
 	return nil
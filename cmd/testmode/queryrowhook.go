@@ -0,0 +1,50 @@
+package testmode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// queryRowHook is a query result provider which makes the next query return a
+// number of generated rows, and performs an action on the cluster part way
+// through streaming them.  Once it has fired, it removes itself.
+type queryRowHook struct {
+	lock      sync.Mutex
+	cluster   mock.Cluster
+	numRows   int
+	afterRows int
+	action    func() error
+	fired     bool
+}
+
+// ExecuteQuery returns the generated rows, each of which holds its index.
+func (h *queryRowHook) ExecuteQuery(req *mock.QueryRequest) ([]json.RawMessage, error) {
+	rows := make([]json.RawMessage, h.numRows)
+	for rowIdx := range rows {
+		rows[rowIdx] = json.RawMessage(fmt.Sprintf(`{"row":%d}`, rowIdx))
+	}
+	return rows, nil
+}
+
+// RowHook returns the action of the hook for the first request only.
+func (h *queryRowHook) RowHook(req *mock.QueryRequest) (int, func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.fired {
+		return 0, nil
+	}
+	h.fired = true
+
+	return h.afterRows, func() {
+		h.cluster.SetQueryResultProvider(nil)
+
+		if err := h.action(); err != nil {
+			log.Printf("failed to perform query row hook action: %s", err)
+		}
+	}
+}
@@ -281,6 +281,7 @@ func (s *scramServer) saltPassword(password []byte) error {
 // ScramServer is a server implementation of SCRAM auth with a slightly improved interface.
 type ScramServer struct {
 	srv      *scramServer
+	mech     string
 	username string
 	password string
 }
@@ -298,6 +299,7 @@ func (s *ScramServer) Start(in []byte, hashFn string) ([]byte, error) {
 	}
 
 	s.srv = srv
+	s.mech = hashFn
 	s.username = username
 	return srv.Out(), nil
 }
@@ -310,13 +312,20 @@ func (s *ScramServer) Step(in []byte) ([]byte, error) {
 
 	// We blindly call Step1 here since other steps aren't possible to achieve
 	// since we clear out the srv after step 1 completes.
-	err := s.srv.Step1(in)
+	srv := s.srv
+	s.srv = nil
+
+	err := srv.Step1(in)
 	if err != nil {
-		s.srv = nil
 		return nil, err
 	}
 
-	return s.srv.Out(), nil
+	return srv.Out(), nil
+}
+
+// Mechanism returns the mechanism which the SCRAM process was started with.
+func (s *ScramServer) Mechanism() string {
+	return s.mech
 }
 
 // Username returns the password which was produced by SCRAM.
@@ -41,3 +41,79 @@ func TestScram(t *testing.T) {
 			"was: %s", string(out))
 	}
 }
+
+// TestScramSha256 drives a full SCRAM-SHA256 exchange using the test vectors
+// from RFC 7677.
+func TestScramSha256(t *testing.T) {
+	salt, err := b64.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	if err != nil {
+		t.Fatalf("Failed to decode salt: %v", err)
+	}
+
+	srvr, err := newScramServerWithSaltAndNonce("SCRAM-SHA256", string(salt), "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0")
+	if err != nil {
+		t.Fatalf("Failed to create scram auth: %v", err)
+	}
+
+	err = srvr.SetPassword([]byte("pencil"))
+	if err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	u, err := srvr.Start([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+	if err != nil {
+		t.Fatalf("Failed to start scram auth: %v", err)
+	}
+
+	if u != "user" {
+		t.Fatalf("Username should have been user but was: %s", u)
+	}
+
+	expectedServerFirst := "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	out := srvr.Out()
+	if string(out) != expectedServerFirst {
+		t.Fatalf("Output from start should have been %s was: %s", expectedServerFirst, string(out))
+	}
+
+	err = srvr.Step1([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+	if err != nil {
+		t.Fatalf("Failed to step scram auth: %v", err)
+	}
+
+	expectedServerFinal := "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+	out = srvr.Out()
+	if string(out) != expectedServerFinal {
+		t.Fatalf("Output from step should have been %s was: %s", expectedServerFinal, string(out))
+	}
+}
+
+func TestScramStepAfterCompletion(t *testing.T) {
+	var srvr ScramServer
+
+	out, err := srvr.Start([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"), "SCRAM-SHA256")
+	if err != nil {
+		t.Fatalf("Failed to start scram auth: %v", err)
+	}
+	if len(out) == 0 {
+		t.Fatalf("Start should have produced a server-first message")
+	}
+	if srvr.Mechanism() != "SCRAM-SHA256" {
+		t.Fatalf("Mechanism should have been SCRAM-SHA256 but was: %s", srvr.Mechanism())
+	}
+
+	err = srvr.SetPassword("pencil")
+	if err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	_, err = srvr.Step([]byte("c=biws,r=invalid,p=invalid"))
+	if err == nil {
+		t.Fatalf("Step with an invalid proof should have failed")
+	}
+
+	_, err = srvr.Step([]byte("c=biws,r=invalid,p=invalid"))
+	if err == nil {
+		t.Fatalf("Step after the exchange has finished should have failed")
+	}
+}
@@ -0,0 +1,164 @@
+package mock
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditEventID identifies a type of audit event.  The ids match those which
+// the server uses for the equivalent events.
+type AuditEventID int
+
+// The following lists the audit events which the mock can generate.
+const (
+	AuditEventCreateBucket        = AuditEventID(8201)
+	AuditEventModifyBucket        = AuditEventID(8202)
+	AuditEventDeleteBucket        = AuditEventID(8203)
+	AuditEventFlushBucket         = AuditEventID(8204)
+	AuditEventModifyAuditSettings = AuditEventID(8220)
+	AuditEventSetUser             = AuditEventID(8232)
+	AuditEventDeleteUser          = AuditEventID(8233)
+	AuditEventKvAuthFailed        = AuditEventID(20485)
+	AuditEventKvAuthSucceeded     = AuditEventID(20490)
+)
+
+var auditEventNames = map[AuditEventID]string{
+	AuditEventCreateBucket:        "create bucket",
+	AuditEventModifyBucket:        "modify bucket",
+	AuditEventDeleteBucket:        "delete bucket",
+	AuditEventFlushBucket:         "flush bucket",
+	AuditEventModifyAuditSettings: "modify audit settings",
+	AuditEventSetUser:             "set user",
+	AuditEventDeleteUser:          "delete user",
+	AuditEventKvAuthFailed:        "authentication failed",
+	AuditEventKvAuthSucceeded:     "authentication succeeded",
+}
+
+// Name returns the name which the server gives to the audit event.
+func (id AuditEventID) Name() string {
+	return auditEventNames[id]
+}
+
+// AuditEvent represents a single event which was written to the audit log.
+type AuditEvent struct {
+	ID        AuditEventID
+	Timestamp time.Time
+
+	// User is the user who performed the audited operation.  Failed
+	// authentications record the user which was attempted.
+	User string
+
+	// Remote is the address of the client which performed the operation,
+	// where it is known.
+	Remote string
+
+	// Fields holds any details which are specific to the type of event, such
+	// as the name of the bucket which was created.
+	Fields map[string]string
+}
+
+// AuditSettings represents the audit settings of a cluster.
+type AuditSettings struct {
+	Enabled        bool
+	LogPath        string
+	RotateInterval time.Duration
+	RotateSize     uint64
+
+	// DisabledEvents lists the events which are not written to the log.
+	DisabledEvents []AuditEventID
+
+	// DisabledUsers lists the users whose events are not written to the log.
+	DisabledUsers []string
+}
+
+// DefaultAuditSettings returns the audit settings of a new cluster, which
+// has auditing disabled.
+func DefaultAuditSettings() AuditSettings {
+	return AuditSettings{
+		LogPath:        "/opt/couchbase/var/lib/couchbase/logs",
+		RotateInterval: 24 * time.Hour,
+		RotateSize:     20 * 1024 * 1024,
+	}
+}
+
+func (s AuditSettings) copy() AuditSettings {
+	s.DisabledEvents = append([]AuditEventID(nil), s.DisabledEvents...)
+	s.DisabledUsers = append([]string(nil), s.DisabledUsers...)
+	return s
+}
+
+func (s AuditSettings) isFiltered(event AuditEvent) bool {
+	for _, id := range s.DisabledEvents {
+		if id == event.ID {
+			return true
+		}
+	}
+	for _, user := range s.DisabledUsers {
+		if user == event.User {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLog holds the audit settings of a cluster, along with the events which
+// were written to the audit log while auditing was enabled.
+type AuditLog struct {
+	lock     sync.Mutex
+	settings AuditSettings
+	events   []AuditEvent
+}
+
+// NewAuditLog creates a new audit log with the default settings.
+func NewAuditLog() *AuditLog {
+	return &AuditLog{
+		settings: DefaultAuditSettings(),
+	}
+}
+
+// Settings returns the audit settings.
+func (l *AuditLog) Settings() AuditSettings {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.settings.copy()
+}
+
+// SetSettings changes the audit settings, which apply to any events recorded
+// after the change.
+func (l *AuditLog) SetSettings(settings AuditSettings) {
+	l.lock.Lock()
+	l.settings = settings.copy()
+	l.lock.Unlock()
+}
+
+// Record writes an event to the audit log, unless auditing is disabled or
+// the event has been filtered out.
+func (l *AuditLog) Record(event AuditEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.settings.Enabled || l.settings.isFiltered(event) {
+		return
+	}
+	l.events = append(l.events, event)
+}
+
+// Events returns the events which have been written to the audit log, in the
+// order they were recorded.
+func (l *AuditLog) Events() []AuditEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	events := make([]AuditEvent, len(l.events))
+	copy(events, l.events)
+	return events
+}
+
+// Reset forgets all of the events which have been written to the audit log.
+// The audit settings are unchanged.
+func (l *AuditLog) Reset() {
+	l.lock.Lock()
+	l.events = nil
+	l.lock.Unlock()
+}
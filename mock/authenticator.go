@@ -0,0 +1,20 @@
+package mock
+
+import "github.com/couchbaselabs/gocaves/mock/mockauth"
+
+// Authenticator makes the authentication and authorization decisions for a
+// cluster.  Tests can provide their own to inject arbitrary auth behaviour
+// without needing to manipulate the users of the cluster.
+type Authenticator interface {
+	// Authenticate checks whether the credentials provided by a client are valid.
+	Authenticate(username, password string) bool
+
+	// Authorize checks whether an authenticated user has a specific permission
+	// against a resource.  Empty resource names indicate the permission is not
+	// scoped to that level.
+	Authorize(username string, permission mockauth.Permission, bucket, scope, collection string) bool
+
+	// Roles returns the roles held by an authenticated user, including those
+	// it holds through its groups.
+	Roles(username string) []*mockauth.UserRole
+}
@@ -1,6 +1,11 @@
 package mock
 
-import "github.com/couchbaselabs/gocaves/mock/mockdb"
+import (
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
 
 // BucketType specifies the type of bucket
 type BucketType uint
@@ -56,8 +61,87 @@ const (
 	CompressionModeActive CompressionMode = "active"
 )
 
+// BucketPauseState specifies whether a bucket is paused.
+type BucketPauseState string
+
+const (
+	// BucketPauseStateRunning indicates that a bucket is not paused.
+	BucketPauseStateRunning BucketPauseState = "running"
+
+	// BucketPauseStatePausing indicates that a bucket is being paused.
+	BucketPauseStatePausing BucketPauseState = "pausing"
+
+	// BucketPauseStatePaused indicates that a bucket is paused.
+	BucketPauseStatePaused BucketPauseState = "paused"
+
+	// BucketPauseStateResuming indicates that a bucket is being resumed.
+	BucketPauseStateResuming BucketPauseState = "resuming"
+)
+
+// EvictionPolicy specifies what is evicted from memory for a bucket.
+type EvictionPolicy string
+
+const (
+	// EvictionPolicyValueOnly specifies that only values are evicted, with the
+	// metadata of every document remaining resident.
+	EvictionPolicyValueOnly EvictionPolicy = "valueOnly"
+
+	// EvictionPolicyFullEviction specifies that metadata is evicted along
+	// with values.
+	EvictionPolicyFullEviction EvictionPolicy = "fullEviction"
+
+	// EvictionPolicyNoEviction specifies that nothing is evicted, this is only
+	// valid for ephemeral buckets.
+	EvictionPolicyNoEviction EvictionPolicy = "noEviction"
+
+	// EvictionPolicyNruEviction specifies that the least recently used
+	// documents are removed entirely, this is only valid for ephemeral buckets.
+	EvictionPolicyNruEviction EvictionPolicy = "nruEviction"
+)
+
+// StorageBackend specifies how the documents of a bucket are stored on disk.
+type StorageBackend string
+
+const (
+	// StorageBackendCouchstore specifies the original couchstore backend.
+	StorageBackendCouchstore StorageBackend = "couchstore"
+
+	// StorageBackendMagma specifies the magma backend, which is designed for
+	// larger datasets and supports retaining the history of collections.
+	StorageBackendMagma StorageBackend = "magma"
+)
+
+// ThrottleLimitUnlimited indicates that a throttle limit is not applied.
+const ThrottleLimitUnlimited = ^uint64(0)
+
+// ThrottleProperties specifies the number of units per second which a bucket
+// is guaranteed, and the number which it can never exceed.
+type ThrottleProperties struct {
+	Reserved  uint64
+	HardLimit uint64
+}
+
+// DefaultMaxDocumentDepth is the deepest which JSON documents can be nested
+// unless a bucket is configured otherwise.
+const DefaultMaxDocumentDepth = 32
+
+// DocumentLimits specifies the limits which a bucket places on the nesting of
+// the JSON documents which it stores.
+type DocumentLimits struct {
+	// MaxDepth is the deepest which a JSON document can be nested, or zero for
+	// no limit.  Sub-document operations against documents which are nested
+	// any deeper, or which would make them so, are rejected.
+	MaxDepth int
+
+	// StrictJSON additionally rejects full document writes of JSON which is
+	// nested deeper than MaxDepth, which are otherwise stored as-is.
+	StrictJSON bool
+}
+
 // NewBucketOptions allows you to specify initial options for a new bucket
 type NewBucketOptions struct {
+	// UUID specifies the uuid of the bucket, one is generated if it is blank.
+	UUID                string
 	Name                string
 	Type                BucketType
 	NumReplicas         uint
@@ -65,6 +149,8 @@ type NewBucketOptions struct {
 	RamQuota            uint64
 	ReplicaIndexEnabled bool
 	CompressionMode     CompressionMode
+	EvictionPolicy      EvictionPolicy
+	StorageBackend      StorageBackend
 }
 
 // UpdateBucketOptions allows you to specify options for updating a bucket
@@ -74,6 +160,7 @@ type UpdateBucketOptions struct {
 	RamQuota            uint64
 	ReplicaIndexEnabled bool
 	CompressionMode     CompressionMode
+	EvictionPolicy      EvictionPolicy
 }
 
 // Bucket represents an instance of a bucket.
@@ -90,12 +177,28 @@ type Bucket interface {
 	// NumReplicas returns the number of configured replicas for this bucket
 	NumReplicas() uint
 
+	// Cluster returns the cluster this bucket belongs to.
+	Cluster() Cluster
+
 	// ConfigRev returns the current configuration revision for this bucket.
 	ConfigRev() uint
 
 	// CollectionManifest returns the collection manifest of this bucket.
 	CollectionManifest() *CollectionManifest
 
+	// ManifestStagger returns how long each node waits after the previous one
+	// before adopting a change to the collection manifest.
+	ManifestStagger() time.Duration
+
+	// SetManifestStagger sets how long each node waits after the previous one
+	// before adopting a change to the collection manifest.  The first node
+	// always adopts changes immediately.
+	SetManifestStagger(stagger time.Duration)
+
+	// NodeManifestTime returns the point in time which a node's view of the
+	// collection manifest reflects, for use with GetManifestAt.
+	NodeManifestTime(node ClusterNode) time.Time
+
 	// Store returns the data-store for this bucket.
 	Store() *mockdb.Bucket
 
@@ -104,6 +207,22 @@ type Bucket interface {
 	// be very explicit such that vbNode = (vbId % numNode), and replicas are just ++.
 	UpdateVbMap(nodeList []string)
 
+	// SetVbMap explicitly assigns the nodes holding each copy of each vbucket.
+	// The map must contain an entry for every vbucket, each of which lists the
+	// IDs of the master and replica nodes, with an empty ID for missing copies.
+	SetVbMap(vbMap [][]string) error
+
+	// EvacuateNode moves every copy of every vbucket held by a node onto the
+	// other active kv nodes, as happens part way through a rebalance.  The
+	// bucket remains open on the node, so it stays in the server list of the
+	// bucket config while owning no vbuckets.
+	EvacuateNode(nodeID string) error
+
+	// FailoverNode removes a node from the vbmap, promoting the first available
+	// replica of any vbucket the node was the master for.  Any mutations which
+	// had not yet been replicated to the promoted replica are lost.
+	FailoverNode(nodeID string)
+
 	// GetVbServerInfo returns the vb nodes, then the vb map, then the ordered list of all nodes
 	GetVbServerInfo(reqNode ClusterNode) ([]ClusterNode, [][]int, []ClusterNode)
 
@@ -111,6 +230,15 @@ type Bucket interface {
 	// A response of -1 means it does not own any replicas for that vbucket.
 	VbucketOwnership(node ClusterNode) []int
 
+	// DcpStreams returns the registry of open DCP streams for this bucket.
+	DcpStreams() *DcpStreamRegistry
+
+	// RangeScans returns the registry of open range scans for this bucket.
+	RangeScans() *RangeScanRegistry
+
+	// SyncWrites returns the registry of pending durable writes for this bucket.
+	SyncWrites() *SyncWriteRegistry
+
 	// ViewIndexManager returns the view index manager for this bucket.
 	ViewIndexManager() ViewIndexManager
 
@@ -132,4 +260,87 @@ type Bucket interface {
 
 	// CompressionMode returns the compression mode used by this bucket.
 	CompressionMode() CompressionMode
+
+	// EvictionPolicy returns the eviction policy used by this bucket.
+	EvictionPolicy() EvictionPolicy
+
+	// StorageBackend returns the storage backend used by this bucket.
+	StorageBackend() StorageBackend
+
+	// ThrottleProperties returns the throttling limits of this bucket.
+	ThrottleProperties() ThrottleProperties
+
+	// SetThrottleProperties changes the throttling limits of this bucket.
+	SetThrottleProperties(props ThrottleProperties)
+
+	// ThrottleWarning returns whether a user is approaching the throttling
+	// limits of this bucket, so that their requests would be throttled.
+	ThrottleWarning(userName string) bool
+
+	// SetThrottleWarning marks a user as approaching the throttling limits of
+	// this bucket.  An empty user name applies to every user of the bucket.
+	SetThrottleWarning(userName string, warn bool)
+
+	// DataLimitStatus returns the status which mutations against this bucket
+	// fail with because a data limit was exceeded, or success if none was.
+	DataLimitStatus() memd.StatusCode
+
+	// SetDataLimitStatus marks a data limit of this bucket as exceeded.
+	SetDataLimitStatus(status memd.StatusCode)
+
+	// DocumentLimits returns the limits on the nesting of the documents which
+	// this bucket stores.
+	DocumentLimits() DocumentLimits
+
+	// SetDocumentLimits changes the limits on the nesting of the documents
+	// which this bucket stores.
+	SetDocumentLimits(limits DocumentLimits)
+
+	// EngineParam returns the value of an engine parameter which has been
+	// set on this bucket, and whether it has been set at all.
+	EngineParam(name string) (string, bool)
+
+	// SetEngineParam sets the value of an engine parameter of this bucket.
+	SetEngineParam(name, value string)
+
+	// StartCompaction begins a compaction of this bucket, which purges its
+	// tombstones once it completes.
+	StartCompaction() error
+
+	// CompactionProgress returns the percentage progress of the running
+	// compaction, and whether a compaction is running at all.
+	CompactionProgress() (int, bool)
+
+	// CancelCompaction stops the running compaction before it completes, so
+	// that no tombstones are purged.
+	CancelCompaction() error
+
+	// SetCompactionSteps sets the number of steps which compactions of this
+	// bucket take to complete.  Zero causes compactions to complete immediately.
+	SetCompactionSteps(steps int)
+
+	// StepCompaction advances the running compaction by a single step.
+	StepCompaction() error
+
+	// PauseState returns whether this bucket is paused, or is being paused
+	// or resumed.
+	PauseState() BucketPauseState
+
+	// PauseProgress returns the percentage progress of the running pause or
+	// resume, and whether one is running at all.
+	PauseProgress() (int, bool)
+
+	// StartPause begins pausing this bucket.  Once paused, kv operations
+	// against the bucket fail until it is resumed.
+	StartPause() error
+
+	// StartResume begins resuming this bucket from being paused.
+	StartResume() error
+
+	// SetPauseSteps sets the number of steps which pausing or resuming this
+	// bucket takes to complete.  Zero causes them to complete immediately.
+	SetPauseSteps(steps int)
+
+	// StepPause advances the running pause or resume by a single step.
+	StepPause() error
 }
@@ -0,0 +1,93 @@
+package mock
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// ClientCertAuthState specifies whether clients may authenticate using
+// certificates.
+type ClientCertAuthState string
+
+// The following lists the possible client certificate authentication states.
+const (
+	// ClientCertAuthStateDisable indicates that client certificates are not
+	// requested, and clients must authenticate with credentials.
+	ClientCertAuthStateDisable = ClientCertAuthState("disable")
+
+	// ClientCertAuthStateEnable indicates that client certificates are
+	// accepted, but clients may still authenticate with credentials instead.
+	ClientCertAuthStateEnable = ClientCertAuthState("enable")
+
+	// ClientCertAuthStateMandatory indicates that TLS connections must present
+	// a valid client certificate which maps to a user.
+	ClientCertAuthStateMandatory = ClientCertAuthState("mandatory")
+)
+
+// The following lists the certificate fields which a user name can be taken
+// from.
+const (
+	ClientCertAuthPathSubjectCN  = "subject.cn"
+	ClientCertAuthPathSANURI     = "san.uri"
+	ClientCertAuthPathSANDNSName = "san.dnsname"
+	ClientCertAuthPathSANEmail   = "san.email"
+)
+
+// ClientCertAuthPrefix describes how a user name is extracted from a field of
+// a client certificate.
+type ClientCertAuthPrefix struct {
+	// Path is the certificate field which the user name is taken from.
+	Path string
+
+	// Prefix must begin the field value, and is removed from the user name.
+	Prefix string
+
+	// Delimiter lists characters which end the user name if they appear in
+	// the field value after the prefix.
+	Delimiter string
+}
+
+// ClientCertAuthSettings represents the cluster-wide settings for
+// authenticating clients by certificate.
+type ClientCertAuthSettings struct {
+	State    ClientCertAuthState
+	Prefixes []ClientCertAuthPrefix
+}
+
+// UserName returns the name of the user which a client certificate maps to,
+// using the first prefix which matches, or an empty string if none do.
+func (s ClientCertAuthSettings) UserName(cert *x509.Certificate) string {
+	for _, prefix := range s.Prefixes {
+		var values []string
+		switch prefix.Path {
+		case ClientCertAuthPathSubjectCN:
+			values = []string{cert.Subject.CommonName}
+		case ClientCertAuthPathSANURI:
+			for _, uri := range cert.URIs {
+				values = append(values, uri.String())
+			}
+		case ClientCertAuthPathSANDNSName:
+			values = cert.DNSNames
+		case ClientCertAuthPathSANEmail:
+			values = cert.EmailAddresses
+		}
+
+		for _, value := range values {
+			if !strings.HasPrefix(value, prefix.Prefix) {
+				continue
+			}
+
+			userName := strings.TrimPrefix(value, prefix.Prefix)
+			if prefix.Delimiter != "" {
+				if delimIdx := strings.IndexAny(userName, prefix.Delimiter); delimIdx >= 0 {
+					userName = userName[:delimIdx]
+				}
+			}
+			if userName != "" {
+				return userName
+			}
+		}
+	}
+
+	return ""
+}
@@ -1,18 +1,206 @@
 package mock
 
 import (
+	"errors"
 	"time"
 
 	"github.com/couchbaselabs/gocaves/mock/mocktime"
 )
 
+// ClusterCapabilities represents the capabilities which a cluster advertises
+// for each service, keyed by the name of the service.
+type ClusterCapabilities map[string][]string
+
+// clusterCapabilitiesByVersion lists the capabilities which were added to
+// each service in each version of the server.  A cluster advertises the
+// capabilities of its own version and of every version before it.
+var clusterCapabilitiesByVersion = []struct {
+	version ClusterVersion
+	caps    ClusterCapabilities
+}{
+	{ClusterVersion65, ClusterCapabilities{
+		"n1ql": []string{
+			"enhancedPreparedStatements",
+		},
+	}},
+	{ClusterVersion70, ClusterCapabilities{
+		"n1ql": []string{
+			"costBasedOptimizer",
+			"indexAdvisor",
+			"javaScriptFunctions",
+			"inlineFunctions",
+		},
+	}},
+}
+
+// DefaultClusterCapabilities returns the capabilities advertised by a
+// specific version of the server.
+func DefaultClusterCapabilities(version ClusterVersion) ClusterCapabilities {
+	caps := make(ClusterCapabilities)
+	for _, added := range clusterCapabilitiesByVersion {
+		if !version.AtLeast(added.version) {
+			continue
+		}
+		for service, serviceCaps := range added.caps {
+			caps[service] = append(caps[service], serviceCaps...)
+		}
+	}
+	return caps
+}
+
+// DefaultMaxBucketCount is the number of buckets which a cluster allows to be
+// created unless it is configured otherwise.
+const DefaultMaxBucketCount = 30
+
+// ErrTooManyBuckets is returned when adding a bucket to a cluster which
+// already holds its maximum number of buckets.
+var ErrTooManyBuckets = errors.New("too many buckets")
+
+// AnalyticsSettings represents the cluster-wide settings of the analytics service.
+type AnalyticsSettings struct {
+	// NumReplicas is the number of replicas which analytics keeps of its data.
+	NumReplicas int
+}
+
+// DefaultNodeMemoryTotal is the amount of RAM, in bytes, which each node
+// reports having unless the cluster is configured otherwise.
+const DefaultNodeMemoryTotal = 49093763072
+
+// MemoryQuotas represents the memory quota, in megabytes, which each node
+// reserves for each of the services.
+type MemoryQuotas struct {
+	Data      uint64
+	Index     uint64
+	Search    uint64
+	Analytics uint64
+	Eventing  uint64
+}
+
+// Total returns the sum of the quotas of all the services.
+func (q MemoryQuotas) Total() uint64 {
+	return q.Data + q.Index + q.Search + q.Analytics + q.Eventing
+}
+
+// DefaultMemoryQuotas returns the memory quotas which a cluster starts with.
+func DefaultMemoryQuotas() MemoryQuotas {
+	return MemoryQuotas{
+		Data:      1024,
+		Index:     512,
+		Search:    512,
+		Analytics: 1024,
+		Eventing:  256,
+	}
+}
+
+// SecuritySettings represents the cluster-wide security settings.
+type SecuritySettings struct {
+	// TLSMinVersion is the lowest TLS version which the TLS listeners accept,
+	// specified as one of the tls.VersionTLS constants.
+	TLSMinVersion uint16
+
+	// CipherSuites is the list of cipher suites which the TLS listeners accept,
+	// or nil to accept the default suites.  Note that TLS 1.3 suites are not
+	// configurable and are always accepted.
+	CipherSuites []uint16
+
+	// ClusterEncryptionLevel is the level of node-to-node encryption, which is
+	// one of control, all or strict.
+	ClusterEncryptionLevel string
+}
+
+// ClusterEdition specifies which edition of the server a cluster emulates.
+type ClusterEdition string
+
+const (
+	// ClusterEditionEnterprise emulates the enterprise edition of the server.
+	ClusterEditionEnterprise ClusterEdition = "enterprise"
+
+	// ClusterEditionCommunity emulates the community edition of the server,
+	// which lacks the enterprise only services such as analytics.
+	ClusterEditionCommunity ClusterEdition = "community"
+)
+
+// ClusterVersion specifies which version of the server a cluster emulates,
+// which determines the features which the cluster supports.
+type ClusterVersion string
+
+// These are the versions of the server which a cluster can emulate.
+const (
+	ClusterVersion50 ClusterVersion = "5.0"
+	ClusterVersion65 ClusterVersion = "6.5"
+	ClusterVersion66 ClusterVersion = "6.6"
+	ClusterVersion70 ClusterVersion = "7.0"
+	ClusterVersion71 ClusterVersion = "7.1"
+	ClusterVersion72 ClusterVersion = "7.2"
+	ClusterVersion76 ClusterVersion = "7.6"
+)
+
+// ClusterVersions lists the versions of the server which a cluster can
+// emulate, from oldest to newest.
+var ClusterVersions = []ClusterVersion{
+	ClusterVersion50,
+	ClusterVersion65,
+	ClusterVersion66,
+	ClusterVersion70,
+	ClusterVersion71,
+	ClusterVersion72,
+	ClusterVersion76,
+}
+
+// LatestClusterVersion is the newest version of the server which a cluster
+// can emulate.
+const LatestClusterVersion = ClusterVersion76
+
+// IsValid returns whether this is one of the versions in ClusterVersions.
+func (v ClusterVersion) IsValid() bool {
+	return v.index() >= 0
+}
+
+// AtLeast returns whether this version is the same as, or newer than, another.
+func (v ClusterVersion) AtLeast(other ClusterVersion) bool {
+	return v.index() >= other.index()
+}
+
+func (v ClusterVersion) index() int {
+	for idx, version := range ClusterVersions {
+		if version == v {
+			return idx
+		}
+	}
+	return -1
+}
+
 // NewClusterOptions allows the specification of initial options for a new cluster.
 type NewClusterOptions struct {
+	// UUID specifies the uuid of the cluster, one is generated if it is blank.
+	UUID           string
 	Chrono         *mocktime.Chrono
 	NumVbuckets    uint
 	InitialNode    NewNodeOptions
 	ReplicaLatency time.Duration
 	PersistLatency time.Duration
+
+	// ClusterCapabilities specifies the capabilities advertised by the
+	// cluster, the defaults of its version are used if this is nil.
+	ClusterCapabilities ClusterCapabilities
+
+	// Authenticator specifies how clients are authenticated and authorized,
+	// the users of the cluster are used if this is nil.
+	Authenticator Authenticator
+
+	// DeterministicVbUUIDs makes the vbucket UUIDs of all buckets predictable
+	// (see mockdb.DeterministicVbUUID) so that tests can know in advance the
+	// mutation tokens which their writes will produce.  It also makes the
+	// documents picked by random key requests the same on every run.
+	DeterministicVbUUIDs bool
+
+	// Edition specifies the edition of the server which the cluster emulates,
+	// the enterprise edition is used if this is blank.
+	Edition ClusterEdition
+
+	// Version specifies the version of the server which the cluster emulates,
+	// the latest version is used if this is blank.
+	Version ClusterVersion
 }
 
 // Cluster represents an instance of a mock cluster
@@ -26,7 +214,8 @@ type Cluster interface {
 	// AddNode will add a new node to a cluster.
 	AddNode(opts NewNodeOptions) (ClusterNode, error)
 
-	// AddBucket will add a new bucket to a cluster.
+	// AddBucket will add a new bucket to a cluster.  ErrTooManyBuckets is
+	// returned if the cluster already holds its maximum number of buckets.
 	AddBucket(opts NewBucketOptions) (Bucket, error)
 
 	// DeleteBucket will remove a bucket from a cluster.
@@ -35,6 +224,129 @@ type Cluster interface {
 	// Nodes returns a list of all the nodes in this cluster.
 	Nodes() []ClusterNode
 
+	// SetNodeServerGroup moves a node into a specific server group.
+	SetNodeServerGroup(nodeID, group string) error
+
+	// FailoverNode fails over a node in all buckets, promoting replicas to
+	// take over any vbuckets which the node was the master for.
+	FailoverNode(nodeID string) error
+
+	// Rebalance returns any failed over nodes to the cluster, as if they had
+	// been fully recovered, and spreads the vbuckets of every bucket evenly
+	// across all of the kv nodes again.
+	Rebalance() error
+
+	// IsBalanced returns whether the vbuckets of every bucket are spread across
+	// all of the active kv nodes, with no failed over nodes awaiting a
+	// rebalance and no graceful failover running.
+	IsBalanced() bool
+
+	// StartGracefulFailover begins draining a node, which is then failed over
+	// once draining completes.  While draining, the node continues to serve
+	// requests but rejects new durable writes.
+	StartGracefulFailover(nodeID string) error
+
+	// GracefulFailoverProgress returns the node being drained by the running
+	// graceful failover, its percentage progress, and whether one is running.
+	GracefulFailoverProgress() (string, int, bool)
+
+	// SetGracefulFailoverSteps sets the number of steps which graceful
+	// failovers take to drain a node.  Zero fails the node over immediately.
+	SetGracefulFailoverSteps(steps int)
+
+	// StepGracefulFailover advances the running graceful failover by a step.
+	StepGracefulFailover() error
+
+	// ClusterCapabilities returns the capabilities advertised by the cluster.
+	ClusterCapabilities() ClusterCapabilities
+
+	// SetClusterCapabilities changes the capabilities advertised by the cluster.
+	SetClusterCapabilities(caps ClusterCapabilities)
+
+	// BumpConfigRev increases the revision of the configs of the cluster and
+	// all of its buckets without changing anything else, and pushes them to
+	// any clients which are watching for new configs.
+	BumpConfigRev()
+
+	// ConfigScenario returns the config scenario which is currently active.
+	ConfigScenario() ConfigScenario
+
+	// SetConfigScenario changes the shape in which configs are generated.
+	SetConfigScenario(scenario ConfigScenario)
+
+	// AnalyticsSettings returns the settings of the analytics service.
+	AnalyticsSettings() AnalyticsSettings
+
+	// SetAnalyticsSettings changes the settings of the analytics service.
+	SetAnalyticsSettings(settings AnalyticsSettings)
+
+	// MemoryQuotas returns the memory quotas of the services of the cluster.
+	MemoryQuotas() MemoryQuotas
+
+	// SetMemoryQuotas changes the memory quotas of the services of the cluster.
+	SetMemoryQuotas(quotas MemoryQuotas)
+
+	// NodeMemoryTotal returns the amount of RAM, in bytes, which each node
+	// reports having, and which the memory quotas are validated against.
+	NodeMemoryTotal() uint64
+
+	// SetNodeMemoryTotal changes the amount of RAM which each node reports
+	// having.  Zero restores the default.
+	SetNodeMemoryTotal(memoryTotal uint64)
+
+	// Edition returns the edition of the server which this cluster emulates.
+	Edition() ClusterEdition
+
+	// Version returns the version of the server which this cluster emulates.
+	Version() ClusterVersion
+
+	// SecuritySettings returns the security settings of the cluster.
+	SecuritySettings() SecuritySettings
+
+	// SetSecuritySettings changes the security settings of the cluster, which
+	// apply to any TLS connections made after the change.
+	SetSecuritySettings(settings SecuritySettings)
+
+	// ClientCertAuthSettings returns the client certificate authentication
+	// settings of the cluster.
+	ClientCertAuthSettings() ClientCertAuthSettings
+
+	// SetClientCertAuthSettings changes the client certificate authentication
+	// settings of the cluster, which apply to any TLS connections made after
+	// the change.
+	SetClientCertAuthSettings(settings ClientCertAuthSettings)
+
+	// CertificatePEM returns the PEM encoded certificate which the TLS
+	// listeners of the cluster present.
+	CertificatePEM() []byte
+
+	// AddTrustedCA adds a PEM encoded CA certificate which client certificates
+	// can be signed by.
+	AddTrustedCA(certPem []byte) error
+
+	// QueryResultProvider returns the provider of query results, if any.
+	QueryResultProvider() QueryResultProvider
+
+	// SetQueryResultProvider changes the provider of query results.  Passing
+	// nil causes every query to return no rows.
+	SetQueryResultProvider(provider QueryResultProvider)
+
+	// MaxBucketCount returns the maximum number of buckets which the cluster
+	// can hold.
+	MaxBucketCount() int
+
+	// SetMaxBucketCount changes the maximum number of buckets which the
+	// cluster can hold.  Existing buckets are kept even if there are more.
+	SetMaxBucketCount(count int)
+
+	// SASLMechanisms returns the SASL mechanisms which the kv service offers
+	// and accepts, or nil if those of the emulated version are used.
+	SASLMechanisms() []string
+
+	// SetSASLMechanisms overrides the SASL mechanisms which the kv service
+	// offers and accepts.  Passing nil restores those of the emulated version.
+	SetSASLMechanisms(mechs []string)
+
 	// GetBucket will return a specific bucket from the cluster.
 	GetBucket(name string) Bucket
 
@@ -53,15 +365,88 @@ type Cluster interface {
 	// KvOutHooks returns the hook manager for outgoing kv packets.
 	KvOutHooks() KvHookManager
 
+	// SetKvFaultInjector makes every kv service of the cluster inject the
+	// faults decided by an injector into its requests.  Passing nil stops
+	// injecting faults.
+	SetKvFaultInjector(injector KvFaultInjector)
+
 	// MgmtHooks returns the hook manager for management requests.
 	MgmtHooks() MgmtHookManager
 
+	// AnalyticsHooks returns the hook manager for analytics requests.
+	AnalyticsHooks() AnalyticsHookManager
+
+	// QueryHooks returns the hook manager for query requests.
+	QueryHooks() QueryHookManager
+
+	// SearchHooks returns the hook manager for search requests.
+	SearchHooks() SearchHookManager
+
+	// ViewHooks returns the hook manager for view requests.
+	ViewHooks() ViewHookManager
+
 	// Chrono returns the chrono object in use by the cluster.
 	Chrono() *mocktime.Chrono
 
 	// Users returns the user service for the cluster.
 	Users() UserManager
 
+	// RequestCounters returns the counters of the requests which have been
+	// handled by each of the services of the cluster.
+	RequestCounters() *RequestCounters
+
+	// ReplicaReads returns the recorder of the replica reads which have been
+	// served by the nodes of the cluster.
+	ReplicaReads() *ReplicaReadRecorder
+
+	// QueryRequests returns the recorder of the requests which have been made
+	// to the query service.
+	QueryRequests() *QueryRequestRecorder
+
+	// Warnings returns the warnings which have been injected into the
+	// responses of otherwise successful operations.
+	Warnings() *WarningInjector
+
+	// NotMyVbuckets returns the tracker of the NOT_MY_VBUCKET errors which
+	// have been sent to kv clients and the config fetches they triggered.
+	NotMyVbuckets() *NotMyVbucketTracker
+
+	// Tasks returns the registry of the tasks which tests have registered to
+	// be reported alongside the tasks the cluster simulates itself.
+	Tasks() *TaskRegistry
+
+	// AuditLog returns the audit settings of the cluster, along with the
+	// events which were audited while auditing was enabled.
+	AuditLog() *AuditLog
+
+	// QueryIndexes returns the registry of the GSI indexes of the cluster and
+	// the state of their builds.
+	QueryIndexes() *QueryIndexRegistry
+
+	// TLSConnections returns the recorder of the parameters negotiated by the
+	// TLS handshakes of the connections to the cluster.
+	TLSConnections() *TLSConnectionRecorder
+
+	// KvConnectionLimits returns the soft limits on the number of kv
+	// connections which each node serves for a bucket or user.
+	KvConnectionLimits() *KvConnectionLimits
+
+	// KvOrphanTimeout returns how long a kv request can be outstanding before
+	// its response is counted as orphaned.  Zero disables the counting.
+	KvOrphanTimeout() time.Duration
+
+	// SetKvOrphanTimeout sets how long a kv request can be outstanding before
+	// its response is counted as orphaned, which should match the timeout the
+	// client applies to its operations.  Zero disables the counting.
+	SetKvOrphanTimeout(timeout time.Duration)
+
+	// Authenticator returns the authenticator in use by the cluster.
+	Authenticator() Authenticator
+
+	// SetAuthenticator changes the authenticator in use by the cluster.  Passing
+	// nil restores the default, which uses the users of the cluster.
+	SetAuthenticator(auth Authenticator)
+
 	// AddConfigWatcher adds a watcher for any configs that come in.
 	AddConfigWatcher(ConfigWatcher)
 
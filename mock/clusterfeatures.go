@@ -7,4 +7,21 @@ type ClusterNodeFeature string
 const (
 	ClusterNodeFeatureDurations = "durations"
 	ClusterNodeFeatureTLS       = "tls"
+
+	// ClusterNodeFeatureConfigOnly enables 7.6 style bucketless connections,
+	// where data operations sent before a bucket is selected fail with a
+	// config-only status rather than a no-bucket status.
+	ClusterNodeFeatureConfigOnly = "configonly"
+
+	// ClusterNodeFeatureCORS enables answering CORS preflight requests on all
+	// of the HTTP services, allowing them to be used directly by browsers.
+	ClusterNodeFeatureCORS = "cors"
+
+	// ClusterNodeFeatureDiagEval enables the /diag/eval endpoint, which allows
+	// test directives to manipulate the cluster over HTTP.
+	ClusterNodeFeatureDiagEval = "diageval"
+
+	// ClusterNodeFeatureExternalNetwork makes the node reachable through an
+	// external network, which is advertised as its alternate addresses.
+	ClusterNodeFeatureExternalNetwork = "externalnetwork"
 )
@@ -1,9 +1,67 @@
 package mock
 
+import (
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+)
+
+// MemoryHighWatermark is the percentage of its memory quota above which a
+// bucket begins evicting items to make room for new writes.
+const MemoryHighWatermark = 85
+
+// ClusterMembership represents the state of the membership of a node within
+// its cluster.
+type ClusterMembership string
+
+// The following lists the possible cluster memberships.
+const (
+	// ClusterMembershipActive indicates that the node is a full member of the
+	// cluster.
+	ClusterMembershipActive = ClusterMembership("active")
+
+	// ClusterMembershipInactiveFailed indicates that the node has been failed
+	// over, and remains so until the cluster is next rebalanced.
+	ClusterMembershipInactiveFailed = ClusterMembership("inactiveFailed")
+)
+
 // NewNodeOptions allows the specification of initial options for a new node.
 type NewNodeOptions struct {
-	Features []ClusterNodeFeature
-	Services []ServiceType
+	// UUID specifies the uuid of the node, one is generated if it is blank.
+	UUID        string
+	Features    []ClusterNodeFeature
+	Services    []ServiceType
+	ServerGroup string
+
+	// Hostname is the address which the node advertises, defaulting to
+	// 127.0.0.1.  IPv6 addresses may be given with or without brackets, and
+	// may include a zone.  Services listen on all interfaces, so any address
+	// of the local machine can be advertised.
+	Hostname string
+
+	// KvIdleTimeout specifies how long a kv connection can go without
+	// sending any packets before the node closes it.  Zero disables this.
+	KvIdleTimeout time.Duration
+
+	// KvWriteFragmentSize splits every kv write into separate writes of at
+	// most this many bytes, so responses arrive across many TCP segments at
+	// unusual boundaries.  Zero disables this.
+	KvWriteFragmentSize int
+
+	// KvWriteBufferSize enables coalescing of the packets written to each kv
+	// connection, so that responses written in quick succession are sent in
+	// fewer TCP writes.  Once this many bytes are waiting they are written
+	// without waiting for KvWriteFlushInterval.  Zero disables coalescing.
+	KvWriteBufferSize int
+
+	// KvWriteFlushInterval is how long coalesced kv responses can wait for
+	// further responses before being written.  Zero only coalesces the
+	// responses written while a previous write was in progress.
+	KvWriteFlushInterval time.Duration
+
+	// KvLatencySeed seeds the random source used to sample the latencies
+	// configured for kv commands.
+	KvLatencySeed int64
 }
 
 // ClusterNode specifies a node within a cluster instance.
@@ -11,6 +69,9 @@ type ClusterNode interface {
 	// ID returns the uuid of this node.
 	ID() string
 
+	// HasFeature indicates whether this node has a specific feature enabled.
+	HasFeature(feature ClusterNodeFeature) bool
+
 	// Cluster returns the Cluster this node is part of.
 	Cluster() Cluster
 
@@ -37,4 +98,51 @@ type ClusterNode interface {
 
 	// HostName returns the address for this node.
 	Hostname() string
+
+	// ServerGroup returns the name of the server group this node belongs to.
+	ServerGroup() string
+
+	// Membership returns the state of the membership of this node within its
+	// cluster.
+	Membership() ClusterMembership
+
+	// ChangeAddress changes the hostname which this node advertises and moves
+	// each of its services to new ports, breaking any existing connections,
+	// as if the node had been restarted with a new IP.  The listeners accept
+	// connections on every interface, so any loopback address can be used.
+	ChangeAddress(hostname string) error
+
+	// ClockSkew returns how far the clock of this node is skewed from the
+	// cluster time.  The CAS of the mutations which it performs is generated
+	// from its skewed clock.
+	ClockSkew() time.Duration
+
+	// SetClockSkew sets how far the clock of this node is skewed from the
+	// cluster time.
+	SetClockSkew(skew time.Duration)
+
+	// MemoryPressure returns the percentage of the memory quota of its buckets
+	// which this node is simulating is in use.
+	MemoryPressure() int
+
+	// SetMemoryPressure sets the percentage of the memory quota of its buckets
+	// which this node simulates is in use.  Above MemoryHighWatermark a growing
+	// fraction of writes temporarily fail while items are evicted, and at 100
+	// all writes fail as out of memory.
+	SetMemoryPressure(percent int)
+
+	// MemoryPressureStatus returns the status which the next write to this
+	// node fails with because of memory pressure, or success if it does not.
+	// Buckets which cannot evict any items fail as out of memory as soon as
+	// the high watermark is passed.
+	MemoryPressureStatus(canEvict bool) memd.StatusCode
+
+	// Pause stops this node from processing any further requests, which are
+	// held until the node is resumed.  Requests already being processed are
+	// unaffected.
+	Pause()
+
+	// Resume allows a paused node to continue processing requests, starting
+	// with any which were held while it was paused.
+	Resume()
 }
@@ -3,6 +3,9 @@ package mock
 import (
 	"errors"
 	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
 )
 
 // CollectionManifest represents one version of a collection manifest
@@ -11,12 +14,28 @@ type CollectionManifest struct {
 	Scopes      map[uint32]*collectionManifestScopeEntry
 	Collections map[uint32]*collectionManifestCollectionEntry
 	lock        sync.Mutex
+
+	// history holds every version of the manifest in the order they were
+	// created, so that nodes which lag behind can serve older versions.
+	history []collectionManifestSnapshot
+
+	// chrono is the clock of the cluster, which each version is stamped with
+	// when it is created.
+	chrono *mocktime.Chrono
+}
+
+type collectionManifestSnapshot struct {
+	Rev     uint64
+	Created time.Time
+	Scopes  []CollectionManifestScope
 }
 
-// NewCollectionManifest creates a new collection manifest.
-func NewCollectionManifest() *CollectionManifest {
-	return &CollectionManifest{
-		Rev: 0,
+// NewCollectionManifest creates a new collection manifest, whose versions are
+// timed by the clock of a cluster.
+func NewCollectionManifest(chrono *mocktime.Chrono) *CollectionManifest {
+	m := &CollectionManifest{
+		chrono: chrono,
+		Rev:    0,
 		Scopes: map[uint32]*collectionManifestScopeEntry{
 			0: {
 				Name: "_default",
@@ -30,6 +49,8 @@ func NewCollectionManifest() *CollectionManifest {
 			},
 		},
 	}
+	m.recordLocked()
+	return m
 }
 
 type collectionManifestScopeEntry struct {
@@ -42,6 +63,7 @@ type collectionManifestCollectionEntry struct {
 	UID      uint32
 	ScopeUID uint32
 	MaxTTL   uint32
+	History  bool
 }
 
 // CollectionManifestScope represents a scope in a collection manifest.
@@ -56,6 +78,10 @@ type CollectionManifestCollection struct {
 	Name   string
 	UID    uint32
 	MaxTTL uint32
+
+	// History specifies whether the history of changes to the collection is
+	// retained, which is only possible in magma buckets.
+	History bool
 }
 
 // GetByID returns the scope name and collection name for a particular ID.  It
@@ -100,7 +126,7 @@ func (m *CollectionManifest) GetByName(scope, collection string) (uint64, uint32
 }
 
 // AddCollection adds a new collection to the manifest.
-func (m *CollectionManifest) AddCollection(scope, collection string, maxTTL uint32) (uint64, error) {
+func (m *CollectionManifest) AddCollection(scope, collection string, maxTTL uint32, history bool) (uint64, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	for _, scop := range m.Scopes {
@@ -118,9 +144,11 @@ func (m *CollectionManifest) AddCollection(scope, collection string, maxTTL uint
 				UID:      uid,
 				ScopeUID: scop.UID,
 				MaxTTL:   maxTTL,
+				History:  history,
 			}
 
 			m.Collections[uid] = newEntry
+			m.recordLocked()
 			return m.Rev, nil
 		}
 	}
@@ -146,6 +174,7 @@ func (m *CollectionManifest) AddScope(scope string) (uint64, error) {
 	}
 
 	m.Scopes[uid] = newEntry
+	m.recordLocked()
 	return m.Rev, nil
 }
 
@@ -159,6 +188,7 @@ func (m *CollectionManifest) DropCollection(scope, collection string) (uint64, e
 				if col != nil && col.ScopeUID == scop.UID && col.Name == collection {
 					m.Rev++
 					m.Collections[col.UID] = nil
+					m.recordLocked()
 					return m.Rev, nil
 				}
 			}
@@ -184,6 +214,7 @@ func (m *CollectionManifest) DropScope(scope string) (uint64, error) {
 				}
 			}
 
+			m.recordLocked()
 			return m.Rev, nil
 		}
 	}
@@ -199,6 +230,70 @@ func (m *CollectionManifest) GetManifest() (uint64, []CollectionManifestScope) {
 	uid := m.Rev
 	m.lock.Unlock()
 
+	return uid, buildManifestScopes(scopes, collections)
+}
+
+// GetManifestAt gets the manifest as it was at a particular point in time,
+// represented in the same way as GetManifest.
+func (m *CollectionManifest) GetManifestAt(at time.Time) (uint64, []CollectionManifestScope) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := m.snapshotAtLocked(at)
+	return snapshot.Rev, snapshot.Scopes
+}
+
+// GetByNameAt retrieves a collection uid by scope and collection name, as the
+// manifest was at a particular point in time.
+func (m *CollectionManifest) GetByNameAt(at time.Time, scope, collection string) (uint64, uint32, error) {
+	rev, scopes := m.GetManifestAt(at)
+	for _, scop := range scopes {
+		if scop.Name == scope {
+			for _, col := range scop.Collections {
+				if col.Name == collection {
+					return rev, col.UID, nil
+				}
+			}
+
+			return 0, 0, ErrCollectionNotFound
+		}
+	}
+
+	return 0, 0, ErrScopeNotFound
+}
+
+func (m *CollectionManifest) snapshotAtLocked(at time.Time) collectionManifestSnapshot {
+	for i := len(m.history) - 1; i > 0; i-- {
+		if !m.history[i].Created.After(at) {
+			return m.history[i]
+		}
+	}
+
+	// Anything older than the manifest itself sees its initial version.
+	return m.history[0]
+}
+
+func (m *CollectionManifest) recordLocked() {
+	// The entry maps are copied as they are modified in place, but the
+	// entries within them are never changed once added.
+	scopes := make(map[uint32]*collectionManifestScopeEntry, len(m.Scopes))
+	for uid, scop := range m.Scopes {
+		scopes[uid] = scop
+	}
+	collections := make(map[uint32]*collectionManifestCollectionEntry, len(m.Collections))
+	for uid, col := range m.Collections {
+		collections[uid] = col
+	}
+
+	m.history = append(m.history, collectionManifestSnapshot{
+		Rev:     m.Rev,
+		Created: m.chrono.Now(),
+		Scopes:  buildManifestScopes(scopes, collections),
+	})
+}
+
+func buildManifestScopes(scopes map[uint32]*collectionManifestScopeEntry,
+	collections map[uint32]*collectionManifestCollectionEntry) []CollectionManifestScope {
 	collectionsByScope := make(map[uint32][]CollectionManifestCollection)
 	for _, col := range collections {
 		if col != nil {
@@ -206,9 +301,10 @@ func (m *CollectionManifest) GetManifest() (uint64, []CollectionManifestScope) {
 				collectionsByScope[col.ScopeUID] = []CollectionManifestCollection{}
 			}
 			collectionsByScope[col.ScopeUID] = append(collectionsByScope[col.ScopeUID], CollectionManifestCollection{
-				Name:   col.Name,
-				UID:    col.UID,
-				MaxTTL: col.MaxTTL,
+				Name:    col.Name,
+				UID:     col.UID,
+				MaxTTL:  col.MaxTTL,
+				History: col.History,
 			})
 		}
 	}
@@ -227,7 +323,7 @@ func (m *CollectionManifest) GetManifest() (uint64, []CollectionManifestScope) {
 		}
 	}
 
-	return uid, retScopes
+	return retScopes
 }
 
 // A few errors that can be produced by collection manifest utilities.
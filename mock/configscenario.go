@@ -0,0 +1,40 @@
+package mock
+
+import "errors"
+
+// ConfigScenario represents a named config shape which is known to have
+// caused problems for SDKs in the past.  Activating a scenario on a cluster
+// causes its configs to be generated in that shape.
+type ConfigScenario string
+
+const (
+	// ConfigScenarioNone generates configs normally.
+	ConfigScenarioNone = ConfigScenario("")
+
+	// ConfigScenarioMissingKvPort includes the hostname of the last node in
+	// the cluster, but leaves out its kv ports.
+	ConfigScenarioMissingKvPort = ConfigScenario("missingkvport")
+
+	// ConfigScenarioZeroReplicas advertises no replicas in the vbucket server
+	// map, regardless of the number configured for the bucket.
+	ConfigScenarioZeroReplicas = ConfigScenario("zeroreplicas")
+
+	// ConfigScenarioReorderedServerList orders the vbucket server list
+	// differently from nodesExt, remapping the vbucket map to match.
+	ConfigScenarioReorderedServerList = ConfigScenario("reorderedserverlist")
+
+	// ConfigScenarioIPv6Hostnames reports the hostnames of all nodes as the
+	// IPv6 loopback address.
+	ConfigScenarioIPv6Hostnames = ConfigScenario("ipv6hostnames")
+)
+
+// ParseConfigScenario returns the config scenario with the specified name.
+func ParseConfigScenario(name string) (ConfigScenario, error) {
+	switch scenario := ConfigScenario(name); scenario {
+	case ConfigScenarioNone, ConfigScenarioMissingKvPort, ConfigScenarioZeroReplicas,
+		ConfigScenarioReorderedServerList, ConfigScenarioIPv6Hostnames:
+		return scenario, nil
+	}
+
+	return ConfigScenarioNone, errors.New("unknown config scenario")
+}
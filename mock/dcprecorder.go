@@ -0,0 +1,148 @@
+package mock
+
+import (
+	"sync"
+)
+
+// DcpMessageType identifies the kind of a recorded DCP message.
+type DcpMessageType int
+
+const (
+	// DcpMessageSnapshotMarker indicates a snapshot marker.
+	DcpMessageSnapshotMarker = DcpMessageType(1)
+
+	// DcpMessageMutation indicates a mutation.
+	DcpMessageMutation = DcpMessageType(2)
+
+	// DcpMessageDeletion indicates a deletion.
+	DcpMessageDeletion = DcpMessageType(3)
+
+	// DcpMessageExpiration indicates an expiration.
+	DcpMessageExpiration = DcpMessageType(4)
+
+	// DcpMessageSystemEvent indicates a system event, such as the creation
+	// or deletion of a collection.
+	DcpMessageSystemEvent = DcpMessageType(5)
+
+	// DcpMessageStreamEnd indicates the end of a stream.
+	DcpMessageStreamEnd = DcpMessageType(6)
+)
+
+// String returns a readable name for the message type.
+func (t DcpMessageType) String() string {
+	switch t {
+	case DcpMessageSnapshotMarker:
+		return "snapshot-marker"
+	case DcpMessageMutation:
+		return "mutation"
+	case DcpMessageDeletion:
+		return "deletion"
+	case DcpMessageExpiration:
+		return "expiration"
+	case DcpMessageSystemEvent:
+		return "system-event"
+	case DcpMessageStreamEnd:
+		return "stream-end"
+	}
+	return "unknown"
+}
+
+// DcpMessage represents a single message which was sent on a DCP stream.
+type DcpMessage struct {
+	Type           DcpMessageType
+	ConnectionName string
+	VbID           uint16
+	StreamID       uint16
+
+	// SeqNo is the seqno of a mutation, deletion, expiration or system event.
+	SeqNo        uint64
+	CollectionID uint32
+	Key          []byte
+
+	// These are the boundaries and type of a snapshot marker.
+	SnapStartSeqNo uint64
+	SnapEndSeqNo   uint64
+	SnapshotType   uint32
+
+	// EventID is the id of a system event.
+	EventID uint32
+
+	// StreamEndStatus is the reason given by a stream end.
+	StreamEndStatus uint32
+}
+
+// DcpRecorder captures the DCP messages sent on the streams of a bucket
+// from the point at which recording was started.
+type DcpRecorder struct {
+	lock     sync.Mutex
+	registry *DcpStreamRegistry
+	messages []DcpMessage
+}
+
+// Messages returns every message which has been recorded, in the order that
+// they were sent.
+func (r *DcpRecorder) Messages() []DcpMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]DcpMessage(nil), r.messages...)
+}
+
+// StreamMessages returns the recorded messages which were sent on a specific
+// stream, in the order that they were sent.
+func (r *DcpRecorder) StreamMessages(connectionName string, vbID, streamID uint16) []DcpMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var messages []DcpMessage
+	for _, msg := range r.messages {
+		if msg.ConnectionName == connectionName && msg.VbID == vbID && msg.StreamID == streamID {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// Stop ends recording.  Messages which were already recorded remain available.
+func (r *DcpRecorder) Stop() {
+	r.registry.lock.Lock()
+	defer r.registry.lock.Unlock()
+
+	for recorderIdx, recorder := range r.registry.recorders {
+		if recorder == r {
+			r.registry.recorders = append(r.registry.recorders[:recorderIdx], r.registry.recorders[recorderIdx+1:]...)
+			break
+		}
+	}
+}
+
+func (r *DcpRecorder) record(msg DcpMessage) {
+	r.lock.Lock()
+	r.messages = append(r.messages, msg)
+	r.lock.Unlock()
+}
+
+// StartRecording begins capturing every message sent on the streams of this
+// registry, until the returned recorder is stopped.
+func (r *DcpStreamRegistry) StartRecording() *DcpRecorder {
+	recorder := &DcpRecorder{
+		registry: r,
+	}
+
+	r.lock.Lock()
+	r.recorders = append(r.recorders, recorder)
+	r.lock.Unlock()
+
+	return recorder
+}
+
+// Record passes a message which was sent on a stream to all active recorders.
+func (r *DcpStreamRegistry) Record(msg DcpMessage) {
+	r.lock.Lock()
+	recorders := append([]*DcpRecorder(nil), r.recorders...)
+	r.lock.Unlock()
+
+	for _, recorder := range recorders {
+		recorder.record(msg)
+	}
+}
@@ -0,0 +1,91 @@
+package mock
+
+import (
+	"sort"
+	"sync"
+)
+
+// DcpStreamState represents the state of a single open DCP stream at the
+// point in time that it was retrieved.
+type DcpStreamState struct {
+	Client         KvClient
+	ConnectionName string
+	VbID           uint16
+	StreamID       uint16
+	StartSeqNo     uint64
+	EndSeqNo       uint64
+
+	// SentSeqNo is the seqno of the last mutation delivered on the stream.
+	SentSeqNo uint64
+
+	// SnapStartSeqNo and SnapEndSeqNo are the boundaries of the last snapshot
+	// marker which was sent on the stream.
+	SnapStartSeqNo uint64
+	SnapEndSeqNo   uint64
+
+	// SentBytes and AckedBytes are the number of bytes which have been sent
+	// on the streams connection, and the number the consumer has acknowledged
+	// using buffer acknowledgements.  These are shared by all streams on the
+	// same connection.
+	SentBytes  uint64
+	AckedBytes uint64
+}
+
+// DcpStreamRegistry tracks all of the open DCP streams against a bucket.
+type DcpStreamRegistry struct {
+	lock      sync.Mutex
+	streams   map[interface{}]func() DcpStreamState
+	recorders []*DcpRecorder
+}
+
+// NewDcpStreamRegistry creates a new, empty, DCP stream registry.
+func NewDcpStreamRegistry() *DcpStreamRegistry {
+	return &DcpStreamRegistry{
+		streams: make(map[interface{}]func() DcpStreamState),
+	}
+}
+
+// Add registers an open stream.  The state function is invoked whenever the
+// state of the stream is requested.
+func (r *DcpStreamRegistry) Add(handle interface{}, stateFn func() DcpStreamState) {
+	r.lock.Lock()
+	r.streams[handle] = stateFn
+	r.lock.Unlock()
+}
+
+// Remove unregisters a previously added stream.
+func (r *DcpStreamRegistry) Remove(handle interface{}) {
+	r.lock.Lock()
+	delete(r.streams, handle)
+	r.lock.Unlock()
+}
+
+// Streams returns the state of all of the open streams for a vbucket, ordered
+// by connection name and then stream id.
+func (r *DcpStreamRegistry) Streams(vbID uint16) []DcpStreamState {
+	r.lock.Lock()
+	stateFns := make([]func() DcpStreamState, 0, len(r.streams))
+	for _, stateFn := range r.streams {
+		stateFns = append(stateFns, stateFn)
+	}
+	r.lock.Unlock()
+
+	// The state functions are invoked without our lock held, as they need to
+	// take locks of their own.
+	var states []DcpStreamState
+	for _, stateFn := range stateFns {
+		state := stateFn()
+		if state.VbID == vbID {
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].ConnectionName != states[j].ConnectionName {
+			return states[i].ConnectionName < states[j].ConnectionName
+		}
+		return states[i].StreamID < states[j].StreamID
+	})
+
+	return states
+}
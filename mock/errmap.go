@@ -51,6 +51,20 @@ func (errMap *ErrorMap) Extend(key string, err ErrorMapError) *ErrorMap {
 	return errMap
 }
 
+// Clone returns a copy of this error map, which can be extended without
+// affecting the original.
+func (errMap *ErrorMap) Clone() *ErrorMap {
+	clone := &ErrorMap{
+		Version:  errMap.Version,
+		Revision: errMap.Revision,
+		Errors:   make(map[string]ErrorMapError, len(errMap.Errors)),
+	}
+	for key, err := range errMap.Errors {
+		clone.Errors[key] = err
+	}
+	return clone
+}
+
 // Marshal marshalls the error map to JSON.
 func (errMap *ErrorMap) Marshal() ([]byte, error) {
 	return json.Marshal(errMap)
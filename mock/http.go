@@ -3,10 +3,13 @@ package mock
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 // HTTPRequest encapsulates an HTTP request.
@@ -19,6 +22,13 @@ type HTTPRequest struct {
 	Form    url.Values
 	Context context.Context
 	Flusher http.Flusher
+
+	// Host is the host which the client addressed the request to.
+	Host string
+
+	// PeerCertificates are the certificates presented by the client, if it
+	// connected over TLS.
+	PeerCertificates []*x509.Certificate
 }
 
 // PeekBody will return the full body and swap the reader with a
@@ -29,6 +39,27 @@ func (r *HTTPRequest) PeekBody() []byte {
 	return data
 }
 
+// BasicAuth returns the username and password sent in the Authorization
+// header of the request, if it uses Basic authentication.
+func (r *HTTPRequest) BasicAuth() (username, password string, ok bool) {
+	split := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(split) != 2 || split[0] != "Basic" {
+		return "", "", false
+	}
+
+	p, err := base64.StdEncoding.DecodeString(split[1])
+	if err != nil {
+		return "", "", false
+	}
+
+	userpassword := strings.SplitN(string(p), ":", 2)
+	if len(userpassword) != 2 {
+		return "", "", false
+	}
+
+	return userpassword[0], userpassword[1], true
+}
+
 // HTTPResponse encapsulates an HTTP response.
 type HTTPResponse struct {
 	StatusCode int
@@ -31,6 +31,14 @@ type KvClient interface {
 	// AuthenticatedUserName gets the name of the user who is authenticated.
 	AuthenticatedUserName() string
 
+	// MarkAuthStale marks the authentication of this client as stale, so that
+	// its data operations fail until it authenticates again.
+	MarkAuthStale()
+
+	// IsAuthStale returns whether this client must authenticate again before
+	// its data operations are processed.
+	IsAuthStale() bool
+
 	// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 	CheckAuthenticated(permission mockauth.Permission, collectionID uint32) bool
 
@@ -49,9 +57,15 @@ type KvClient interface {
 	// HasFeature indicates whether or not this client supports a feature.
 	HasFeature(feature memd.HelloFeature) bool
 
+	// GetContext gets arbitrary context associated with this client.
+	GetContext(valuePtr interface{})
+
 	// WritePacket tries to write data to the underlying connection.
 	WritePacket(pak *memd.Packet) error
 
+	// CloseNotify returns a channel which is closed once this client has disconnected.
+	CloseNotify() <-chan struct{}
+
 	// Close attempts to close the connection.
 	Close() error
 }
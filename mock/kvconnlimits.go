@@ -0,0 +1,277 @@
+package mock
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// DefaultKvConnectionLimitRetry is the retry strategy which the error map
+// advertises for busy responses while any connection limit is set.
+var DefaultKvConnectionLimitRetry = ErrorMapRetry{
+	Strategy:    "constant",
+	Interval:    100,
+	After:       100,
+	MaxDuration: 2000,
+}
+
+// KvConnectionLimitStats describes the operations which were rejected because
+// a connection limit of a bucket or user was exceeded.
+type KvConnectionLimitStats struct {
+	// Bucket and User identify the limit which was exceeded.  Only one of
+	// them is set.
+	Bucket string
+	User   string
+
+	// Rejected is the number of operations which were rejected as busy.
+	Rejected uint64
+}
+
+type kvConnectionLimitKey struct {
+	bucket string
+	user   string
+}
+
+// kvConnectionServedKey identifies the connections which a node serves for a
+// bucket or a user.
+type kvConnectionServedKey struct {
+	nodeID string
+	key    kvConnectionLimitKey
+}
+
+// kvConnectionAdmission records the bucket and user which a connection is
+// counted against on its node.  Either is left empty while the connection is
+// beyond its limit.
+type kvConnectionAdmission struct {
+	nodeID string
+	bucket kvConnectionLimitKey
+	user   kvConnectionLimitKey
+}
+
+// KvConnectionLimits holds the soft limits on the number of kv connections
+// which each node of a cluster serves for a bucket or a user.  Connections
+// beyond a limit remain open, but their operations are rejected as busy, with
+// the error map advising clients how to retry them.
+//
+// Connections are admitted under a limit by their first operation after
+// selecting a bucket or authenticating, and stay admitted until they close.
+// The longest admitted connections therefore continue to be served, and
+// lowering a limit only affects connections which have yet to be admitted.
+type KvConnectionLimits struct {
+	lock       sync.Mutex
+	limits     map[kvConnectionLimitKey]int
+	retry      ErrorMapRetry
+	rejections map[kvConnectionLimitKey]uint64
+	served     map[kvConnectionServedKey]int
+	admissions map[KvClient]kvConnectionAdmission
+}
+
+// NewKvConnectionLimits creates a new set of connection limits, with no
+// limits set.
+func NewKvConnectionLimits() *KvConnectionLimits {
+	return &KvConnectionLimits{
+		limits:     make(map[kvConnectionLimitKey]int),
+		retry:      DefaultKvConnectionLimitRetry,
+		rejections: make(map[kvConnectionLimitKey]uint64),
+		served:     make(map[kvConnectionServedKey]int),
+		admissions: make(map[KvClient]kvConnectionAdmission),
+	}
+}
+
+// SetBucketLimit sets the number of connections to a bucket which each node
+// serves.  A limit of zero removes the limit.
+func (l *KvConnectionLimits) SetBucketLimit(bucket string, limit int) error {
+	if bucket == "" {
+		return errors.New("limit must have a bucket")
+	}
+	return l.setLimit(kvConnectionLimitKey{bucket: bucket}, limit)
+}
+
+// SetUserLimit sets the number of connections of a user which each node
+// serves.  A limit of zero removes the limit.
+func (l *KvConnectionLimits) SetUserLimit(user string, limit int) error {
+	if user == "" {
+		return errors.New("limit must have a user")
+	}
+	return l.setLimit(kvConnectionLimitKey{user: user}, limit)
+}
+
+func (l *KvConnectionLimits) setLimit(key kvConnectionLimitKey, limit int) error {
+	if limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if limit == 0 {
+		delete(l.limits, key)
+	} else {
+		l.limits[key] = limit
+	}
+	return nil
+}
+
+// BucketLimit returns the connection limit of a bucket, or zero if it has
+// none.
+func (l *KvConnectionLimits) BucketLimit(bucket string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.limits[kvConnectionLimitKey{bucket: bucket}]
+}
+
+// UserLimit returns the connection limit of a user, or zero if they have
+// none.
+func (l *KvConnectionLimits) UserLimit(user string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.limits[kvConnectionLimitKey{user: user}]
+}
+
+// HasLimits returns whether any connection limit is set.
+func (l *KvConnectionLimits) HasLimits() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return len(l.limits) > 0
+}
+
+// Retry returns the retry strategy advertised for busy responses.
+func (l *KvConnectionLimits) Retry() ErrorMapRetry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.retry
+}
+
+// SetRetry sets the retry strategy advertised for busy responses.  Clients
+// only see the change once they fetch the error map again.
+func (l *KvConnectionLimits) SetRetry(retry ErrorMapRetry) {
+	l.lock.Lock()
+	l.retry = retry
+	l.lock.Unlock()
+}
+
+// Admit counts a connection against the limits of a bucket and user on its
+// node, returning false if either limit has been reached, in which case the
+// rejection is recorded.  The user may be empty if the connection has not
+// authenticated.
+func (l *KvConnectionLimits) Admit(client KvClient, bucket, user string) bool {
+	nodeID := client.Source().Node().ID()
+	bucketKey := kvConnectionLimitKey{bucket: bucket}
+	var userKey kvConnectionLimitKey
+	if user != "" {
+		userKey = kvConnectionLimitKey{user: user}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	admission := l.admissions[client]
+	admission.nodeID = nodeID
+
+	bucketAdmitted := l.admitLocked(nodeID, admission.bucket, bucketKey)
+	admission.bucket = kvConnectionLimitKey{}
+	if bucketAdmitted {
+		admission.bucket = bucketKey
+	}
+
+	userAdmitted := l.admitLocked(nodeID, admission.user, userKey)
+	admission.user = kvConnectionLimitKey{}
+	if userAdmitted {
+		admission.user = userKey
+	}
+
+	l.admissions[client] = admission
+
+	if !bucketAdmitted {
+		l.rejections[bucketKey]++
+		return false
+	}
+	if !userAdmitted {
+		l.rejections[userKey]++
+		return false
+	}
+	return true
+}
+
+// Release stops counting a connection which has closed against any limits.
+func (l *KvConnectionLimits) Release(client KvClient) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	admission, ok := l.admissions[client]
+	if !ok {
+		return
+	}
+
+	l.admitLocked(admission.nodeID, admission.bucket, kvConnectionLimitKey{})
+	l.admitLocked(admission.nodeID, admission.user, kvConnectionLimitKey{})
+	delete(l.admissions, client)
+}
+
+// admitLocked moves a connection from being counted under one limit on its
+// node to another, returning false if the new limit has been reached.  An
+// empty key is never counted.
+// NOTE: This must be called with the lock of the limits held.
+func (l *KvConnectionLimits) admitLocked(nodeID string, from, to kvConnectionLimitKey) bool {
+	if from == to {
+		return true
+	}
+
+	if from != (kvConnectionLimitKey{}) {
+		fromServedKey := kvConnectionServedKey{nodeID: nodeID, key: from}
+		l.served[fromServedKey]--
+		if l.served[fromServedKey] <= 0 {
+			delete(l.served, fromServedKey)
+		}
+	}
+
+	if to == (kvConnectionLimitKey{}) {
+		return true
+	}
+
+	toServedKey := kvConnectionServedKey{nodeID: nodeID, key: to}
+	if limit := l.limits[to]; limit > 0 && l.served[toServedKey] >= limit {
+		return false
+	}
+	l.served[toServedKey]++
+	return true
+}
+
+// Stats returns the number of operations rejected for each limit which has
+// been exceeded, with the bucket limits ordered before the user limits.
+func (l *KvConnectionLimits) Stats() []KvConnectionLimitStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	stats := make([]KvConnectionLimitStats, 0, len(l.rejections))
+	for key, rejected := range l.rejections {
+		stats = append(stats, KvConnectionLimitStats{
+			Bucket:   key.bucket,
+			User:     key.user,
+			Rejected: rejected,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if (stats[i].Bucket == "") != (stats[j].Bucket == "") {
+			return stats[i].Bucket != ""
+		}
+		return stats[i].Bucket+stats[i].User < stats[j].Bucket+stats[j].User
+	})
+	return stats
+}
+
+// Reset removes all of the limits and forgets the rejected operations.  The
+// advertised retry strategy returns to the default.  Open connections remain
+// counted against the limits they were admitted under.
+func (l *KvConnectionLimits) Reset() {
+	l.lock.Lock()
+	l.limits = make(map[kvConnectionLimitKey]int)
+	l.retry = DefaultKvConnectionLimitRetry
+	l.rejections = make(map[kvConnectionLimitKey]uint64)
+	l.lock.Unlock()
+}
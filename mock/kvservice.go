@@ -1,5 +1,83 @@
 package mock
 
+import (
+	"errors"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+)
+
+// KvHangAction specifies what a node does with a request for a command which
+// has been made to hang.
+type KvHangAction string
+
+// The following lists the possible hang actions.
+const (
+	// KvHangActionNoResponse indicates that the request is never responded to.
+	KvHangActionNoResponse = KvHangAction("noresponse")
+
+	// KvHangActionClose indicates that the connection is closed once the
+	// timeout has elapsed, without responding to the request.
+	KvHangActionClose = KvHangAction("close")
+)
+
+// KvCommandHang describes how a node hangs on requests for a command, the way
+// a real server abandons an operation which is taking too long internally.
+type KvCommandHang struct {
+	Action KvHangAction
+
+	// Timeout is how long to wait before closing the connection, and is only
+	// used by KvHangActionClose.
+	Timeout time.Duration
+}
+
+// Validate checks that the hang can be applied.
+func (h KvCommandHang) Validate() error {
+	switch h.Action {
+	case KvHangActionNoResponse:
+	case KvHangActionClose:
+		if h.Timeout < 0 {
+			return errors.New("hang timeout cannot be negative")
+		}
+	default:
+		return errors.New("invalid hang action")
+	}
+	return nil
+}
+
+// KvRequestFaults describes the faults injected into a single kv request, on
+// top of any latency or hang configured for its command.
+type KvRequestFaults struct {
+	// Latency is added to the latency sampled for the command.
+	Latency time.Duration
+
+	// Hang makes the request hang instead of being processed, in place of any
+	// hang configured for the command.
+	Hang *KvCommandHang
+
+	// Status fails the request with a status instead of processing it, unless
+	// it is StatusSuccess.
+	Status memd.StatusCode
+}
+
+// KvFaultInjector decides which faults to inject into a single kv request.
+type KvFaultInjector func(source KvClient, pak *memd.Packet) KvRequestFaults
+
+// KvTraceResponse is a single server response of a captured kv packet trace.
+type KvTraceResponse struct {
+	Command  memd.CmdCode
+	Status   memd.StatusCode
+	Datatype uint8
+	Cas      uint64
+	Key      []byte
+	Extras   []byte
+	Value    []byte
+
+	// Delay is how long to wait after the request arrives before sending
+	// the response.
+	Delay time.Duration
+}
+
 // KvService represents an instance of the kv service.
 type KvService interface {
 	// Node returns the ClusterNode which owns this service.
@@ -17,6 +95,28 @@ type KvService interface {
 	// GetAllClients returns a list of all the clients connected to this service.
 	GetAllClients() []KvClient
 
+	// SetCommandLatency makes requests for a specific command wait for a
+	// latency sampled from a distribution before being processed.  Passing
+	// nil removes any latency previously configured for the command.
+	SetCommandLatency(cmd memd.CmdCode, dist *LatencyDistribution) error
+
+	// SetLatencySeed reseeds the random source used to sample latencies, so
+	// that the sequence of latencies is reproducible.
+	SetLatencySeed(seed int64)
+
+	// SetCommandHang makes requests for a specific command hang instead of
+	// being processed.  Passing nil removes any hang previously configured
+	// for the command.
+	SetCommandHang(cmd memd.CmdCode, hang *KvCommandHang) error
+
+	// ReplayTrace makes the next connection to this service answer its
+	// requests with the responses of a captured trace, in order, regardless
+	// of what is requested.  Each response carries the opaque of the request
+	// it answers, and requests which arrive once the trace is exhausted are
+	// processed normally.  Passing nil cancels a trace which has not yet
+	// been picked up by a connection.
+	ReplayTrace(trace []KvTraceResponse)
+
 	// Close will shut down this service once it is no longer needed.
 	Close() error
 }
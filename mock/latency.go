@@ -0,0 +1,51 @@
+package mock
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// LatencyDistribution describes the latency of an operation by its
+// percentiles, from which the latency of individual requests is sampled.
+type LatencyDistribution struct {
+	P50  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+}
+
+// Validate checks that the percentiles of the distribution are ordered.
+func (d LatencyDistribution) Validate() error {
+	if d.P50 < 0 || d.P99 < d.P50 || d.P999 < d.P99 {
+		return errors.New("latency percentiles must be non-negative and increasing")
+	}
+	return nil
+}
+
+// Sample returns a latency drawn from the distribution, interpolating
+// linearly between its percentiles.
+func (d LatencyDistribution) Sample(rng *rand.Rand) time.Duration {
+	points := []struct {
+		quantile float64
+		latency  time.Duration
+	}{
+		{0, 0},
+		{0.5, d.P50},
+		{0.99, d.P99},
+		{0.999, d.P999},
+		{1, d.P999},
+	}
+
+	quantile := rng.Float64()
+	for i := 1; i < len(points); i++ {
+		lower, upper := points[i-1], points[i]
+		if quantile > upper.quantile {
+			continue
+		}
+
+		frac := (quantile - lower.quantile) / (upper.quantile - lower.quantile)
+		return lower.latency + time.Duration(frac*float64(upper.latency-lower.latency))
+	}
+
+	return d.P999
+}
@@ -32,6 +32,26 @@ func (r *UserRole) anyCollection() bool {
 	return r.CollectionName == "" || r.CollectionName == "*"
 }
 
+// coversResource checks whether this role applies to a specific resource.  A
+// bucket can be selected with access to any scope or collection within it, so
+// roles limited to a scope or collection still cover selecting their bucket.
+func (r *UserRole) coversResource(permission Permission, bucket, scope, collection string) bool {
+	if r.BucketName != bucket && !r.anyBucket() {
+		return false
+	}
+	if permission == PermissionSelect {
+		return true
+	}
+	if r.ScopeName != scope && !r.anyScope() {
+		return false
+	}
+	if r.CollectionName != collection && !r.anyCollection() {
+		return false
+	}
+
+	return true
+}
+
 // Group represents a group that a user may be part of.
 type Group struct {
 	Name  string
@@ -51,13 +71,7 @@ type User struct {
 func (u *User) HasPermission(permission Permission, bucket, scope, collection string) bool {
 	for _, r := range u.Roles {
 		// Check that we have access to the resources first.
-		if r.BucketName != bucket && !r.anyBucket() {
-			continue
-		}
-		if r.ScopeName != scope && !r.anyScope() {
-			continue
-		}
-		if r.CollectionName != collection && !r.anyCollection() {
+		if !r.coversResource(permission, bucket, scope, collection) {
 			continue
 		}
 
@@ -78,13 +92,7 @@ func (u *User) HasPermission(permission Permission, bucket, scope, collection st
 	for _, g := range u.Groups {
 		for _, r := range g.Roles {
 			// Check that we have access to the resources first.
-			if r.BucketName != bucket && !r.anyBucket() {
-				continue
-			}
-			if r.ScopeName != scope && !r.anyScope() {
-				continue
-			}
-			if r.CollectionName != collection && !r.anyCollection() {
+			if !r.coversResource(permission, bucket, scope, collection) {
 				continue
 			}
 
@@ -249,6 +257,40 @@ func (e *Engine) GetUser(username string) *User {
 	return nil
 }
 
+// Authenticate checks that a user exists and that the password matches.
+func (e *Engine) Authenticate(username, password string) bool {
+	user := e.GetUser(username)
+	if user == nil {
+		return false
+	}
+
+	return user.Password == password
+}
+
+// Authorize checks whether a user has a specific permission based on its roles.
+func (e *Engine) Authorize(username string, permission Permission, bucket, scope, collection string) bool {
+	user := e.GetUser(username)
+	if user == nil {
+		return false
+	}
+
+	return user.HasPermission(permission, bucket, scope, collection)
+}
+
+// Roles returns the roles of a user, followed by those of each of its groups.
+func (e *Engine) Roles(username string) []*UserRole {
+	user := e.GetUser(username)
+	if user == nil {
+		return nil
+	}
+
+	roles := append([]*UserRole{}, user.Roles...)
+	for _, g := range user.Groups {
+		roles = append(roles, g.Roles...)
+	}
+	return roles
+}
+
 // GetAllUsers returns a list of all registered users.
 func (e *Engine) GetAllUsers() []*User {
 	return e.users
@@ -287,4 +329,9 @@ var roleToPermissions = map[string][]Permission{
 		PermissionClusterRead, PermissionClusterManage},
 	"security_admin": {PermissionUserRead, PermissionUserManage, PermissionClusterRead},
 	"bucket_admin":   {PermissionReplicationTarget, PermissionReplicationManage, PermissionClusterRead, PermissionBucketManage},
+	"bucket_full_access": {PermissionDataRead, PermissionDataWrite, PermissionDCPRead, PermissionViewsRead, PermissionStatsRead,
+		PermissionSelect},
+	"data_reader":     {PermissionDataRead, PermissionSelect},
+	"data_writer":     {PermissionDataWrite, PermissionSelect},
+	"data_dcp_reader": {PermissionDataRead, PermissionDCPRead, PermissionSelect},
 }
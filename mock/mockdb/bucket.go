@@ -1,8 +1,12 @@
 package mockdb
 
 import (
+	"encoding/json"
 	"errors"
+	"hash/crc32"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbaselabs/gocaves/mock/mocktime"
@@ -12,6 +16,14 @@ import (
 type Bucket struct {
 	chrono   *mocktime.Chrono
 	vbuckets []*Vbucket
+
+	// hlcDrift is the offset, in nanoseconds, of the hybrid logical clock of
+	// the bucket from the time of its chrono.
+	hlcDrift int64
+
+	// randomRng is the random source used to pick random documents.
+	randomLock sync.Mutex
+	randomRng  *rand.Rand
 }
 
 // NewBucketOptions specifies the configuration for a new Bucket store.
@@ -21,6 +33,14 @@ type NewBucketOptions struct {
 	NumVbuckets    uint
 	ReplicaLatency time.Duration
 	PersistLatency time.Duration
+
+	// DeterministicVbUUIDs causes vbucket UUIDs to be generated using
+	// DeterministicVbUUID rather than being unique to each vbucket.
+	DeterministicVbUUIDs bool
+
+	// RandomSeed seeds the random source used to pick random documents, so
+	// that buckets with the same seed and contents pick the same documents.
+	RandomSeed int64
 }
 
 // NewBucket will create a new Bucket store.
@@ -32,9 +52,11 @@ func NewBucket(opts NewBucketOptions) (*Bucket, error) {
 	vbuckets := make([]*Vbucket, opts.NumVbuckets)
 	for vbIdx := range vbuckets {
 		vbucket, err := newVbucket(newVbucketOptions{
-			Chrono:         opts.Chrono,
-			ReplicaLatency: opts.ReplicaLatency,
-			PersistLatency: opts.PersistLatency,
+			Chrono:             opts.Chrono,
+			ReplicaLatency:     opts.ReplicaLatency,
+			PersistLatency:     opts.PersistLatency,
+			VbIdx:              uint(vbIdx),
+			DeterministicUUIDs: opts.DeterministicVbUUIDs,
 		})
 		if err != nil {
 			return nil, err
@@ -44,8 +66,9 @@ func NewBucket(opts NewBucketOptions) (*Bucket, error) {
 	}
 
 	bucket := &Bucket{
-		chrono:   opts.Chrono,
-		vbuckets: vbuckets,
+		chrono:    opts.Chrono,
+		vbuckets:  vbuckets,
+		randomRng: rand.New(rand.NewSource(opts.RandomSeed)),
 	}
 
 	return bucket, nil
@@ -56,6 +79,24 @@ func (b *Bucket) Chrono() *mocktime.Chrono {
 	return b.chrono
 }
 
+// HLC returns the current time of the hybrid logical clock which is used to
+// generate the CAS of mutations, including any configured drift.
+func (b *Bucket) HLC() time.Time {
+	return b.chrono.Now().Add(b.HLCDrift())
+}
+
+// HLCDrift returns how far the hybrid logical clock has drifted from the time
+// of the chrono.
+func (b *Bucket) HLCDrift() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.hlcDrift))
+}
+
+// SetHLCDrift sets how far the hybrid logical clock drifts from the time of
+// the chrono, simulating clock skew on the server.
+func (b *Bucket) SetHLCDrift(drift time.Duration) {
+	atomic.StoreInt64(&b.hlcDrift, int64(drift))
+}
+
 // GetAll fetches all documents from a particular replica.
 func (b *Bucket) GetAll(repIdx, collectionID uint) ([]*Document, error) {
 	var alldocs []*Document
@@ -83,22 +124,38 @@ func (b *Bucket) Get(repIdx, vbIdx uint, collectionID uint, key []byte) (*Docume
 
 // GetRandom fetches a random document from a particular replica and vbucket index.
 func (b *Bucket) GetRandom(repIdx, collectionID uint) (*Document, error) {
-	max := len(b.vbuckets)
-	start := uint(rand.Intn(max))
+	vbIdxs := make([]uint, len(b.vbuckets))
+	for vbIdx := range vbIdxs {
+		vbIdxs[vbIdx] = uint(vbIdx)
+	}
+
+	return b.GetRandomWithin(vbIdxs, repIdx, collectionID)
+}
+
+// GetRandomWithin fetches a random document from a particular replica of one
+// of the specified vbuckets.
+func (b *Bucket) GetRandomWithin(vbIdxs []uint, repIdx, collectionID uint) (*Document, error) {
+	max := len(vbIdxs)
+	if max == 0 {
+		return nil, ErrDocNotFound
+	}
+
+	b.randomLock.Lock()
+	defer b.randomLock.Unlock()
+
+	start := b.randomRng.Intn(max)
 	curr := start
 	for {
-		vbucket := b.GetVbucket(curr)
-		if vbucket == nil {
-			continue
-		}
-
-		found := vbucket.GetRandom(repIdx, collectionID)
-		if found != nil {
-			return found, nil
+		vbucket := b.GetVbucket(vbIdxs[curr])
+		if vbucket != nil {
+			found := vbucket.GetRandom(repIdx, collectionID, b.randomRng)
+			if found != nil {
+				return found, nil
+			}
 		}
 
 		curr++
-		if curr == uint(max) {
+		if curr == max {
 			curr = 0
 		}
 
@@ -140,6 +197,141 @@ func (b *Bucket) Update(vbID, collectionID uint, key []byte, fn UpdateFunc) (*Do
 	return doc, nil
 }
 
+// UpdateAtSeqNo is identical to Update, except that the update is only made if
+// it will be assigned a specific seqno, failing with ErrSeqNoMismatch otherwise.
+// This allows values which depend on the seqno to be generated ahead of time.
+func (b *Bucket) UpdateAtSeqNo(vbID, collectionID uint, key []byte, seqNo uint64, fn UpdateFunc) (*Document, error) {
+	vbucket := b.GetVbucket(vbID)
+	if vbucket == nil {
+		return nil, errors.New("invalid vbucket")
+	}
+
+	doc, err := vbucket.updateAtSeqNo(collectionID, key, seqNo, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// MaxValueSize is the largest value which can be stored in a document.
+const MaxValueSize = 20 * 1024 * 1024
+
+// BulkLoadOptions specifies options for a BulkLoad operation.
+type BulkLoadOptions struct {
+	// HashKeys causes the vbucket of each document to be calculated from its
+	// key, rather than using the VbID specified on the document.
+	HashKeys bool
+}
+
+// BulkLoad stores a list of documents into the master replica of their vbuckets
+// in a single pass, overwriting any existing documents with the same keys.  Each
+// vbucket is locked only once, which makes this much faster than issuing each
+// mutation individually when loading large amounts of test data.
+func (b *Bucket) BulkLoad(docs []*Document, opts BulkLoadOptions) ([]*Document, error) {
+	vbDocs := make(map[uint][]*Document)
+	for _, doc := range docs {
+		vbID := doc.VbID
+		if opts.HashKeys {
+			vbID = b.VbucketForKey(doc.Key)
+		}
+
+		if b.GetVbucket(vbID) == nil {
+			return nil, errors.New("invalid vbucket")
+		}
+		if len(doc.Value) > MaxValueSize {
+			return nil, ErrValueTooBig
+		}
+
+		newDoc := copyDocument(doc)
+		newDoc.VbID = vbID
+		vbDocs[vbID] = append(vbDocs[vbID], newDoc)
+	}
+
+	docsOut := make([]*Document, 0, len(docs))
+	for vbID, docs := range vbDocs {
+		docsOut = append(docsOut, b.GetVbucket(vbID).bulkPush(docs, b.HLC())...)
+	}
+
+	return docsOut, nil
+}
+
+// VbucketForKey returns the vbucket which a particular key maps to, using the
+// same hashing as the real server.
+func (b *Bucket) VbucketForKey(key []byte) uint {
+	crc := crc32.ChecksumIEEE(key)
+	return uint((crc>>16)&0x7fff) % uint(len(b.vbuckets))
+}
+
+// CorruptDocument directly overwrites the stored value and datatype of a
+// document, bypassing all validation.  This allows tests to store data which
+// the SDK will be unable to decode.
+func (b *Bucket) CorruptDocument(vbID, collectionID uint, key []byte, value []byte, datatype uint8) error {
+	vbucket := b.GetVbucket(vbID)
+	if vbucket == nil {
+		return errors.New("invalid vbucket")
+	}
+
+	return vbucket.corrupt(collectionID, key, value, datatype)
+}
+
+// SetXattr directly stores the value of an xattr on a document, generating a
+// new mutation of the document.  If the document does not exist, it is created
+// as a tombstone holding only the xattr, the same as a subdoc mutation using
+// create-as-deleted would.  This allows tests to arrange documents with system
+// xattrs or staged transaction metadata as fixtures.
+func (b *Bucket) SetXattr(collectionID uint, key []byte, xattrName string, value []byte) (*Document, error) {
+	if xattrName == "" {
+		return nil, errors.New("xattr name must be specified")
+	}
+	if !json.Valid(value) {
+		return nil, errors.New("xattr value must be valid JSON")
+	}
+
+	vbID := b.VbucketForKey(key)
+	return b.Update(vbID, collectionID, key, func(doc *Document) (*Document, error) {
+		var newDoc *Document
+		if doc != nil {
+			newDoc = copyDocument(doc)
+		} else {
+			newDoc = &Document{
+				VbID:         vbID,
+				CollectionID: collectionID,
+				Key:          key,
+				Xattrs:       make(map[string][]byte),
+				IsDeleted:    true,
+			}
+		}
+
+		newDoc.Xattrs[xattrName] = append([]byte{}, value...)
+		newDoc.Cas = GenerateNewCas(b.HLC())
+		return newDoc, nil
+	})
+}
+
+// EvictDocument evicts a document from memory, optionally including its
+// metadata.  Returns whether the document had already been evicted.
+func (b *Bucket) EvictDocument(vbIdx, collectionID uint, key []byte, evictMeta bool) (bool, error) {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return false, errors.New("invalid vbucket")
+	}
+
+	return vbucket.evict(collectionID, key, evictMeta)
+}
+
+// FetchDocument brings an evicted document back into memory.  When withValue
+// is not set, only its metadata is fetched.
+func (b *Bucket) FetchDocument(vbIdx, collectionID uint, key []byte, withValue bool) error {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return errors.New("invalid vbucket")
+	}
+
+	vbucket.fetch(collectionID, key, withValue)
+	return nil
+}
+
 // Remove removes a document from the master replica of a vbucket.
 func (b *Bucket) Remove(vbIdx uint, key []byte) (*Document, error) {
 	// Removing a document is explicitly not supported.  See Vbucket::remove
@@ -149,7 +341,7 @@ func (b *Bucket) Remove(vbIdx uint, key []byte) (*Document, error) {
 // GetVbucket will return the Vbucket object for a particular replica and
 // vbucket index within this particular bucket store.
 func (b *Bucket) GetVbucket(vbIdx uint) *Vbucket {
-	if vbIdx > uint(len(b.vbuckets)) {
+	if vbIdx >= uint(len(b.vbuckets)) {
 		return nil
 	}
 
@@ -162,6 +354,22 @@ func (b *Bucket) Compact() error {
 	return errors.New("not supported")
 }
 
+// PurgeTombstones removes the tombstones from all of the vbuckets within this
+// bucket, advancing their purge seqnos.
+func (b *Bucket) PurgeTombstones() {
+	for _, vbucket := range b.vbuckets {
+		vbucket.PurgeTombstones()
+	}
+}
+
+// ForcePurgeTombstones removes the tombstones from all of the vbuckets within
+// this bucket, advancing their purge seqnos to their high seqnos.
+func (b *Bucket) ForcePurgeTombstones() {
+	for _, vbucket := range b.vbuckets {
+		vbucket.ForcePurgeTombstones()
+	}
+}
+
 // BucketSnapshot represents a snapshot of the bucket at a point in time.  This
 // can later be used to rollback the bucket to this point in time.
 type BucketSnapshot struct {
@@ -181,6 +389,54 @@ func (b *Bucket) Snapshot() *BucketSnapshot {
 	}
 }
 
+// PromoteReplica simulates a failover of a single vbucket, making the
+// specified replicas view of the data the authoritative one.  Any mutations
+// which had not yet been replicated to it are lost.
+func (b *Bucket) PromoteReplica(vbIdx, repIdx uint) error {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return errors.New("invalid vbucket")
+	}
+
+	vbucket.promote(repIdx)
+	return nil
+}
+
+// DiscardMutationsAfter removes all mutations above a seqno from a vbucket,
+// adding a new entry to its failover log at that seqno.
+func (b *Bucket) DiscardMutationsAfter(vbIdx uint, seqNo uint64) error {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return errors.New("invalid vbucket")
+	}
+
+	return vbucket.discardAfter(seqNo)
+}
+
+// MarkMutationUnreplicated marks a mutation of a document as never having
+// reached the replicas of its vbucket, so that it is lost if a replica is
+// later promoted.  A seqno of zero marks the latest mutation of the document.
+// Returns the seqno of the mutation which was marked.
+func (b *Bucket) MarkMutationUnreplicated(vbIdx, collectionID uint, key []byte, seqNo uint64) (uint64, error) {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return 0, errors.New("invalid vbucket")
+	}
+
+	return vbucket.MarkUnreplicated(collectionID, key, seqNo)
+}
+
+// SetReplicaLag specifies how far a replica of a vbucket lags behind the
+// master.  Passing a nil lag restores the default lag of the replica.
+func (b *Bucket) SetReplicaLag(vbIdx, repIdx uint, lag *ReplicaLag) error {
+	vbucket := b.GetVbucket(vbIdx)
+	if vbucket == nil {
+		return errors.New("invalid vbucket")
+	}
+
+	return vbucket.SetReplicaLag(repIdx, lag)
+}
+
 // Rollback will rollback the bucket to a previously snapshotted state.
 func (b *Bucket) Rollback(snap *BucketSnapshot) error {
 	// Rollback all the vbuckets
@@ -194,6 +450,16 @@ func (b *Bucket) Rollback(snap *BucketSnapshot) error {
 	return nil
 }
 
+// MemUsed returns an approximation of the memory used by the documents in all
+// of the vbuckets of the bucket.
+func (b *Bucket) MemUsed() uint64 {
+	var memUsed uint64
+	for _, vbucket := range b.vbuckets {
+		memUsed += vbucket.MemUsed()
+	}
+	return memUsed
+}
+
 func (b *Bucket) Flush() {
 	for _, vbucket := range b.vbuckets {
 		vbucket.Flush()
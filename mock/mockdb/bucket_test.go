@@ -1,6 +1,7 @@
 package mockdb
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -90,3 +91,699 @@ func TestReplication(t *testing.T) {
 		t.Fatalf("second replica cas was not retreived correctly")
 	}
 }
+
+func TestReplicaLag(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    2,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	if err := bucket.SetReplicaLag(3, 1, &ReplicaLag{SeqNos: 1}); err != nil {
+		t.Fatalf("failed to set first replica lag: %v", err)
+	}
+	if err := bucket.SetReplicaLag(3, 2, &ReplicaLag{SeqNos: 2}); err != nil {
+		t.Fatalf("failed to set second replica lag: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := bucket.Update(3, 0, []byte("test"), func(doc *Document) (*Document, error) {
+			return &Document{
+				VbID:  3,
+				Key:   []byte("test"),
+				Value: []byte(fmt.Sprintf("value-%d", i)),
+				Cas:   GenerateNewCas(chrono.Now()),
+			}, nil
+		})
+		if err != nil {
+			t.Fatalf("failed to update document: %v", err)
+		}
+	}
+
+	// Only the seqno lag applies, so time passing changes nothing.
+	chrono.TimeTravel(time.Second)
+
+	for repIdx, expected := range []string{"value-2", "value-1", "value-0"} {
+		doc, err := bucket.Get(uint(repIdx), 3, 0, []byte("test"))
+		if err != nil {
+			t.Fatalf("failed to get document from copy %d: %v", repIdx, err)
+		}
+		if string(doc.Value) != expected {
+			t.Fatalf("copy %d returned %s instead of %s", repIdx, doc.Value, expected)
+		}
+
+		metaState := bucket.GetVbucket(3).CurrentMetaState(uint(repIdx))
+		if metaState.CurrentSeqNo != doc.SeqNo {
+			t.Fatalf("copy %d reported seqno %d instead of %d", repIdx, metaState.CurrentSeqNo, doc.SeqNo)
+		}
+	}
+
+	if err := bucket.SetReplicaLag(3, 2, nil); err != nil {
+		t.Fatalf("failed to reset second replica lag: %v", err)
+	}
+
+	doc, err := bucket.Get(2, 3, 0, []byte("test"))
+	if err != nil {
+		t.Fatalf("failed to get document from second replica: %v", err)
+	}
+	if string(doc.Value) != "value-2" {
+		t.Fatalf("second replica returned %s after its lag was reset", doc.Value)
+	}
+}
+
+func TestPromoteReplica(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	oldDoc, err := bucket.Insert(&Document{
+		VbID:  3,
+		Key:   []byte("replicated"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	chrono.TimeTravel(100 * time.Millisecond)
+
+	_, err = bucket.Insert(&Document{
+		VbID:  3,
+		Key:   []byte("unreplicated"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	err = bucket.PromoteReplica(3, 1)
+	if err != nil {
+		t.Fatalf("failed to promote replica: %v", err)
+	}
+
+	getDoc, err := bucket.Get(0, 3, 0, []byte("replicated"))
+	if err != nil {
+		t.Fatalf("replicated document should have survived promotion: %v", err)
+	}
+	if getDoc.Cas != oldDoc.Cas {
+		t.Fatalf("replicated document cas was not retreived correctly")
+	}
+
+	_, err = bucket.Get(0, 3, 0, []byte("unreplicated"))
+	if err != ErrDocNotFound {
+		t.Fatalf("unreplicated document should have been lost in promotion")
+	}
+
+	vbucket := bucket.GetVbucket(3)
+	if vbucket.MaxSeqNo() != oldDoc.SeqNo {
+		t.Fatalf("max seqno should have reverted to %d, was %d", oldDoc.SeqNo, vbucket.MaxSeqNo())
+	}
+
+	failoverLog := vbucket.FailoverLog()
+	if len(failoverLog) != 2 || failoverLog[1].SeqNo != oldDoc.SeqNo {
+		t.Fatalf("promotion should have started a new history branch: %+v", failoverLog)
+	}
+}
+
+func TestMarkMutationUnreplicated(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	oldDoc, err := bucket.Insert(&Document{
+		VbID:  3,
+		Key:   []byte("lost"),
+		Value: []byte("old value"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	newDoc, err := bucket.Update(3, 0, []byte("lost"), func(doc *Document) (*Document, error) {
+		doc.Value = []byte("new value")
+		return doc, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update document: %v", err)
+	}
+
+	otherDoc, err := bucket.Insert(&Document{
+		VbID:  3,
+		Key:   []byte("kept"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	// Without the mark, every mutation would have reached the replica by now.
+	chrono.TimeTravel(100 * time.Millisecond)
+
+	seqNo, err := bucket.MarkMutationUnreplicated(3, 0, []byte("lost"), 0)
+	if err != nil {
+		t.Fatalf("failed to mark mutation: %v", err)
+	}
+	if seqNo != newDoc.SeqNo {
+		t.Fatalf("expected the latest mutation %d to be marked, was %d", newDoc.SeqNo, seqNo)
+	}
+
+	_, err = bucket.MarkMutationUnreplicated(3, 0, []byte("kept"), newDoc.SeqNo)
+	if err != ErrDocNotFound {
+		t.Fatalf("marking a seqno of a different document should fail")
+	}
+
+	repDoc, err := bucket.Get(1, 3, 0, []byte("lost"))
+	if err != nil {
+		t.Fatalf("failed to get document from replica: %v", err)
+	}
+	if repDoc.Cas != oldDoc.Cas {
+		t.Fatalf("replica should only have the old revision of the document")
+	}
+
+	err = bucket.PromoteReplica(3, 1)
+	if err != nil {
+		t.Fatalf("failed to promote replica: %v", err)
+	}
+
+	getDoc, err := bucket.Get(0, 3, 0, []byte("lost"))
+	if err != nil {
+		t.Fatalf("old revision should have survived promotion: %v", err)
+	}
+	if string(getDoc.Value) != "old value" {
+		t.Fatalf("unreplicated mutation should have been lost in promotion")
+	}
+
+	_, err = bucket.Get(0, 3, 0, []byte("kept"))
+	if err != nil {
+		t.Fatalf("replicated document should have survived promotion: %v", err)
+	}
+
+	vbucket := bucket.GetVbucket(3)
+	if vbucket.MaxSeqNo() != otherDoc.SeqNo {
+		t.Fatalf("max seqno should have remained %d, was %d", otherDoc.SeqNo, vbucket.MaxSeqNo())
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    64,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	var docs []*Document
+	for i := 0; i < 1000; i++ {
+		docs = append(docs, &Document{
+			Key:   []byte(fmt.Sprintf("doc-%d", i)),
+			Value: []byte(`{"hello":"world"}`),
+		})
+	}
+
+	loadedDocs, err := bucket.BulkLoad(docs, BulkLoadOptions{
+		HashKeys: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to bulk load documents: %v", err)
+	}
+	if len(loadedDocs) != len(docs) {
+		t.Fatalf("expected %d loaded documents, got %d", len(docs), len(loadedDocs))
+	}
+
+	for _, loadedDoc := range loadedDocs {
+		if loadedDoc.Cas == 0 || loadedDoc.SeqNo == 0 {
+			t.Fatalf("cas and seqno were not assigned correctly")
+		}
+		if loadedDoc.VbID != bucket.VbucketForKey(loadedDoc.Key) {
+			t.Fatalf("document was not placed in the hashed vbucket")
+		}
+
+		getDoc, err := bucket.Get(0, loadedDoc.VbID, 0, loadedDoc.Key)
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		if getDoc.Cas != loadedDoc.Cas {
+			t.Fatalf("get cas was not retreived correctly")
+		}
+	}
+}
+
+func TestEvictDocument(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	_, err = bucket.Insert(&Document{
+		VbID:  1,
+		Key:   []byte("evicted"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	_, err = bucket.EvictDocument(1, 0, []byte("evicted"), false)
+	if err != ErrDocDirty {
+		t.Fatalf("expected unpersisted document to be dirty, got %v", err)
+	}
+
+	chrono.TimeTravel(100 * time.Millisecond)
+
+	alreadyEvicted, err := bucket.EvictDocument(1, 0, []byte("evicted"), false)
+	if err != nil || alreadyEvicted {
+		t.Fatalf("failed to evict document: %v, %t", err, alreadyEvicted)
+	}
+
+	// Fully evicting a document whose value alone was evicted evicts its metadata.
+	alreadyEvicted, err = bucket.EvictDocument(1, 0, []byte("evicted"), true)
+	if err != nil || alreadyEvicted {
+		t.Fatalf("failed to fully evict document: %v, %t", err, alreadyEvicted)
+	}
+
+	alreadyEvicted, err = bucket.EvictDocument(1, 0, []byte("evicted"), true)
+	if err != nil || !alreadyEvicted {
+		t.Fatalf("expected document to already be evicted: %v, %t", err, alreadyEvicted)
+	}
+
+	doc, err := bucket.Get(0, 1, 0, []byte("evicted"))
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+	if !doc.IsValueEvicted || !doc.IsMetaEvicted {
+		t.Fatalf("expected document to be fully evicted")
+	}
+
+	err = bucket.FetchDocument(1, 0, []byte("evicted"), false)
+	if err != nil {
+		t.Fatalf("failed to fetch document metadata: %v", err)
+	}
+
+	doc, err = bucket.Get(0, 1, 0, []byte("evicted"))
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+	if !doc.IsValueEvicted || doc.IsMetaEvicted {
+		t.Fatalf("expected only the document metadata to be resident")
+	}
+
+	fetches, metaFetches := bucket.GetVbucket(1).BgFetchStats()
+	if fetches != 0 || metaFetches != 1 {
+		t.Fatalf("unexpected fetch counts: %d, %d", fetches, metaFetches)
+	}
+
+	_, err = bucket.EvictDocument(1, 0, []byte("missing"), false)
+	if err != ErrDocNotFound {
+		t.Fatalf("expected missing document to not be found, got %v", err)
+	}
+}
+
+func TestMemUsed(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	// Each document counts its key and its value, or just its key once the
+	// value has been evicted.
+	insDoc, err := bucket.Insert(&Document{
+		VbID:  1,
+		Key:   []byte("key"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+	_, err = bucket.Insert(&Document{
+		VbID:  2,
+		Key:   []byte("other"),
+		Value: []byte("value"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+	if memUsed := bucket.MemUsed(); memUsed != 24 {
+		t.Fatalf("unexpected memory used after insert: %d", memUsed)
+	}
+
+	// Only the latest revision of a document counts.
+	_, err = bucket.Update(1, 0, []byte("key"), func(doc *Document) (*Document, error) {
+		doc.Value = []byte("hi")
+		return doc, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update document: %v", err)
+	}
+	if memUsed := bucket.MemUsed(); memUsed != 15 {
+		t.Fatalf("unexpected memory used after update: %d", memUsed)
+	}
+
+	chrono.TimeTravel(100 * time.Millisecond)
+
+	_, err = bucket.EvictDocument(1, 0, []byte("key"), false)
+	if err != nil {
+		t.Fatalf("failed to evict document: %v", err)
+	}
+	if memUsed := bucket.MemUsed(); memUsed != 13 {
+		t.Fatalf("unexpected memory used after eviction: %d", memUsed)
+	}
+
+	err = bucket.FetchDocument(1, 0, []byte("key"), true)
+	if err != nil {
+		t.Fatalf("failed to fetch document: %v", err)
+	}
+	if memUsed := bucket.MemUsed(); memUsed != 15 {
+		t.Fatalf("unexpected memory used after fetch: %d", memUsed)
+	}
+
+	// Discarding the update brings back the revision before it.
+	err = bucket.DiscardMutationsAfter(1, insDoc.SeqNo)
+	if err != nil {
+		t.Fatalf("failed to discard mutations: %v", err)
+	}
+	if memUsed := bucket.MemUsed(); memUsed != 24 {
+		t.Fatalf("unexpected memory used after discarding mutations: %d", memUsed)
+	}
+
+	bucket.Flush()
+	if memUsed := bucket.MemUsed(); memUsed != 0 {
+		t.Fatalf("unexpected memory used after flush: %d", memUsed)
+	}
+}
+
+func TestPurgeTombstones(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	for _, key := range []string{"deleted", "live"} {
+		_, err = bucket.Insert(&Document{
+			VbID:  2,
+			Key:   []byte(key),
+			Value: []byte("hello world"),
+			Cas:   GenerateNewCas(chrono.Now()),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+	}
+
+	delDoc, err := bucket.Update(2, 0, []byte("deleted"), func(doc *Document) (*Document, error) {
+		doc.IsDeleted = true
+		doc.Value = nil
+		return doc, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	bucket.PurgeTombstones()
+
+	vbucket := bucket.GetVbucket(2)
+	if vbucket.PurgeSeqNo() != delDoc.SeqNo {
+		t.Fatalf("expected purge seqno to be %d, was %d", delDoc.SeqNo, vbucket.PurgeSeqNo())
+	}
+
+	if _, err := bucket.Get(0, 2, 0, []byte("deleted")); err != ErrDocNotFound {
+		t.Fatalf("expected purged tombstone to be missing, got %v", err)
+	}
+	if _, err := bucket.Get(0, 2, 0, []byte("live")); err != nil {
+		t.Fatalf("failed to get live document: %v", err)
+	}
+}
+
+func TestForcePurgeTombstones(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:      chrono,
+		NumReplicas: 0,
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	for _, key := range []string{"deleted", "live"} {
+		_, err = bucket.Insert(&Document{
+			VbID:  1,
+			Key:   []byte(key),
+			Value: []byte("hello world"),
+			Cas:   GenerateNewCas(chrono.Now()),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+	}
+
+	_, err = bucket.Update(1, 0, []byte("deleted"), func(doc *Document) (*Document, error) {
+		doc.IsDeleted = true
+		doc.Value = nil
+		return doc, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	liveDoc, err := bucket.Update(1, 0, []byte("live"), func(doc *Document) (*Document, error) {
+		doc.Value = []byte("updated")
+		return doc, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update document: %v", err)
+	}
+
+	bucket.ForcePurgeTombstones()
+
+	vbucket := bucket.GetVbucket(1)
+	if vbucket.PurgeSeqNo() != liveDoc.SeqNo {
+		t.Fatalf("expected purge seqno to be %d, was %d", liveDoc.SeqNo, vbucket.PurgeSeqNo())
+	}
+
+	if _, err := bucket.Get(0, 1, 0, []byte("deleted")); err != ErrDocNotFound {
+		t.Fatalf("expected purged tombstone to be missing, got %v", err)
+	}
+	if _, err := bucket.Get(0, 1, 0, []byte("live")); err != nil {
+		t.Fatalf("failed to get live document: %v", err)
+	}
+
+	// Empty vbuckets have nothing to purge.
+	if bucket.GetVbucket(0).PurgeSeqNo() != 0 {
+		t.Fatalf("expected empty vbucket to have no purge seqno")
+	}
+}
+
+func TestDeterministicVbUUIDs(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:               chrono,
+		NumReplicas:          1,
+		NumVbuckets:          4,
+		DeterministicVbUUIDs: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	failoverLog := bucket.GetVbucket(2).FailoverLog()
+	if failoverLog[len(failoverLog)-1].VbUUID != DeterministicVbUUID(2, 0) {
+		t.Fatalf("initial vbuuid was not deterministic")
+	}
+
+	bucket.Flush()
+
+	failoverLog = bucket.GetVbucket(2).FailoverLog()
+	if failoverLog[len(failoverLog)-1].VbUUID != DeterministicVbUUID(2, 1) {
+		t.Fatalf("vbuuid after flush was not deterministic")
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	pickRandomKeys := func(seed int64) []string {
+		chrono := &mocktime.Chrono{}
+		bucket, err := NewBucket(NewBucketOptions{
+			Chrono:      chrono,
+			NumReplicas: 1,
+			NumVbuckets: 4,
+			RandomSeed:  seed,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+
+		for docIdx := 0; docIdx < 20; docIdx++ {
+			_, err := bucket.Insert(&Document{
+				VbID:  uint(docIdx % 4),
+				Key:   []byte(fmt.Sprintf("test-%d", docIdx)),
+				Value: []byte("hello world"),
+				Cas:   GenerateNewCas(chrono.Now()),
+			})
+			if err != nil {
+				t.Fatalf("failed to insert document: %v", err)
+			}
+		}
+
+		var keys []string
+		for pickIdx := 0; pickIdx < 10; pickIdx++ {
+			doc, err := bucket.GetRandom(0, 0)
+			if err != nil {
+				t.Fatalf("failed to get random document: %v", err)
+			}
+			keys = append(keys, string(doc.Key))
+		}
+		return keys
+	}
+
+	keys := pickRandomKeys(7)
+	sameKeys := pickRandomKeys(7)
+	for pickIdx := range keys {
+		if keys[pickIdx] != sameKeys[pickIdx] {
+			t.Fatalf("random documents differed with the same seed: %v and %v", keys, sameKeys)
+		}
+	}
+}
+
+func TestSetXattr(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	key := []byte("test")
+	vbID := bucket.VbucketForKey(key)
+
+	_, err = bucket.SetXattr(0, key, "txn", []byte(`{"id":"abc"}`))
+	if err != nil {
+		t.Fatalf("failed to set xattr on missing document: %v", err)
+	}
+
+	doc, err := bucket.Get(0, vbID, 0, key)
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+	if !doc.IsDeleted {
+		t.Fatalf("missing document was not created as a tombstone")
+	}
+	if string(doc.Xattrs["txn"]) != `{"id":"abc"}` {
+		t.Fatalf("xattr was not stored correctly")
+	}
+
+	_, err = bucket.SetXattr(0, key, "txn", []byte(`{"id":`))
+	if err == nil {
+		t.Fatalf("invalid xattr value was not rejected")
+	}
+}
+
+func TestCorruptDocument(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:      chrono,
+		NumReplicas: 1,
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	key := []byte("test")
+	vbID := bucket.VbucketForKey(key)
+
+	err = bucket.CorruptDocument(vbID, 0, key, []byte("garbage"), 0x01)
+	if err != ErrDocNotFound {
+		t.Fatalf("corrupting a missing document did not fail: %v", err)
+	}
+
+	insDoc, err := bucket.Insert(&Document{
+		VbID:  vbID,
+		Key:   key,
+		Value: []byte(`{"x":1}`),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	// A snappy-flagged value which is not snappy compressed must be returned
+	// exactly as it was stored.
+	corruptValue := []byte(`{"x":`)
+	err = bucket.CorruptDocument(vbID, 0, key, corruptValue, 0x03)
+	if err != nil {
+		t.Fatalf("failed to corrupt document: %v", err)
+	}
+
+	doc, err := bucket.Get(0, vbID, 0, key)
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+	if string(doc.Value) != string(corruptValue) {
+		t.Fatalf("corrupted value was not returned raw: %q", doc.Value)
+	}
+	if doc.Datatype != 0x03 {
+		t.Fatalf("corrupted datatype was not returned unchanged: %x", doc.Datatype)
+	}
+	if doc.Cas != insDoc.Cas || doc.SeqNo != insDoc.SeqNo {
+		t.Fatalf("corrupting a document generated a new mutation")
+	}
+}
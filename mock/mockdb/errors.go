@@ -10,3 +10,11 @@ var ErrDocNotFound = errors.New("document not found")
 
 // ErrValueTooBig is thrown when a document was set with a value that is too large.
 var ErrValueTooBig = errors.New("document value too large")
+
+// ErrDocDirty is thrown when a document cannot be evicted as it has not yet
+// been persisted.
+var ErrDocDirty = errors.New("document has not been persisted")
+
+// ErrSeqNoMismatch is thrown when a mutation would not have been assigned the
+// seqno which was expected for it.
+var ErrSeqNoMismatch = errors.New("mutation seqno mismatch")
@@ -0,0 +1,41 @@
+package mockdb
+
+import (
+	"time"
+)
+
+// SystemEventType identifies the kind of change which a system event records.
+type SystemEventType int
+
+const (
+	// SystemEventCollectionCreate records the creation of a collection.
+	SystemEventCollectionCreate = SystemEventType(1)
+
+	// SystemEventCollectionDrop records the dropping of a collection.
+	SystemEventCollectionDrop = SystemEventType(2)
+)
+
+// SystemEvent represents a change to the collections of a bucket.  Each one
+// is assigned a seqno in every vbucket, the same way that a mutation is, so
+// that DCP consumers see it in order with the mutations around it.
+type SystemEvent struct {
+	Type         SystemEventType
+	ManifestUID  uint64
+	ScopeID      uint32
+	CollectionID uint32
+
+	// Name and MaxTTL are only used when a collection is created.
+	Name   string
+	MaxTTL uint32
+
+	VbUUID       uint64
+	SeqNo        uint64
+	ModifiedTime time.Time
+}
+
+// PushSystemEvent records a system event in every vbucket of the bucket.
+func (b *Bucket) PushSystemEvent(event *SystemEvent) {
+	for _, vbucket := range b.vbuckets {
+		vbucket.pushSystemEvent(event)
+	}
+}
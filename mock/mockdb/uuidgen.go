@@ -17,6 +17,17 @@ func GenerateNewCas(now time.Time) uint64 {
 	return clockTime | logicalTime | mockTime
 }
 
+// nextLogicalCas returns the CAS which follows another when the clock has not
+// moved past it, by advancing only the logical counter.
+func nextLogicalCas(cas uint64) uint64 {
+	return (cas&^0xFF + 0x100) | 0x00000000000000CA
+}
+
+// CasToTime returns the time of the hybrid logical clock encoded in a CAS.
+func CasToTime(cas uint64) time.Time {
+	return time.Unix(0, int64(cas&0xFFFFFFFFFFFF0000))
+}
+
 // This ends with AF to make it easier to see its a VbUUID.
 var globalVbUUIDIncr uint64 = 1
 
@@ -24,3 +35,10 @@ func generateNewVbUUID() uint64 {
 	newVal := atomic.AddUint64(&globalVbUUIDIncr, 1)
 	return newVal<<8 | 0xAF
 }
+
+// DeterministicVbUUID returns the vbucket UUID which is used for a specific
+// generation of a vbucket when deterministic UUIDs are enabled.  The first
+// generation is 0, and each failover or flush of the vbucket begins a new one.
+func DeterministicVbUUID(vbIdx uint, generation uint64) uint64 {
+	return uint64(vbIdx)<<40 | (generation&0xFFFFFFFF)<<8 | 0xAF
+}
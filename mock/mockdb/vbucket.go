@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,6 +31,12 @@ type Document struct {
 	SeqNo        uint64
 	ModifiedTime time.Time
 	RevID        uint64
+
+	// IsValueEvicted and IsMetaEvicted indicate that the value, or the whole
+	// document, has been evicted from memory.  These only apply to the latest
+	// revision of a document and are never carried into a new mutation.
+	IsValueEvicted bool
+	IsMetaEvicted  bool
 }
 
 func copyDocument(src *Document) *Document {
@@ -49,6 +56,8 @@ func copyDocument(src *Document) *Document {
 	dst.SeqNo = src.SeqNo
 	dst.ModifiedTime = src.ModifiedTime
 	dst.RevID = src.RevID
+	dst.IsValueEvicted = src.IsValueEvicted
+	dst.IsMetaEvicted = src.IsMetaEvicted
 
 	dst.Value = append([]byte{}, src.Value...)
 
@@ -66,37 +75,118 @@ type VbRevData struct {
 	SeqNo  uint64
 }
 
+// ReplicaLag specifies how far a replica of a vbucket lags behind the master.
+type ReplicaLag struct {
+	// SeqNos is the number of the most recent mutations which have not yet
+	// reached the replica.
+	SeqNos uint64
+
+	// Latency is how long it takes a mutation to reach the replica.
+	Latency time.Duration
+}
+
 // Vbucket represents a single Vbucket worth of documents
 type Vbucket struct {
 	chrono         *mocktime.Chrono
 	lock           sync.Mutex
 	documents      []*Document
+	events         []*SystemEvent
 	maxSeqNo       uint64
 	replicaLatency time.Duration
 	persistLatency time.Duration
 	revData        []VbRevData
+
+	// mutationCh is closed, and then replaced, whenever the vbucket changes.
+	mutationCh chan struct{}
+
+	// replicaLags holds the lag of any replica which has been configured
+	// explicitly, keyed by replica index.  Other replicas lag behind the
+	// previous one by the replica latency.
+	replicaLags map[uint]ReplicaLag
+
+	// unreplicatedSeqNos holds the seqnos of mutations which have been marked
+	// as never reaching any replica, regardless of the replica lag.  They are
+	// lost if a replica is promoted.
+	unreplicatedSeqNos map[uint64]struct{}
+
+	// replicaAckSeqNo is the highest seqno which a replica has explicitly
+	// acknowledged as persisted, rather than relying on the latency timers.
+	replicaAckSeqNo uint64
+
+	// checkpointID is the id of the currently open checkpoint.  We do not
+	// implement checkpoints, but we track the id so that it can be reported.
+	checkpointID uint64
+
+	// bgFetches and bgMetaFetches count the number of times that a value, or
+	// just the metadata, of an evicted document has been fetched from disk.
+	bgFetches     uint64
+	bgMetaFetches uint64
+
+	// purgeSeqNo is the highest seqno of any tombstone which has been purged.
+	purgeSeqNo uint64
+
+	// memUsed is the memory used by the latest revision of every document, as
+	// reported by MemUsed, and docMemUsed holds the share of it of each
+	// document.  They are kept up to date as the vbucket changes, so that the
+	// memory used can be checked on every write.
+	memUsed    uint64
+	docMemUsed map[vbDocKey]uint64
+
+	// maxCas is the highest CAS which has been assigned in the vbucket.  The
+	// CAS of the vbucket never goes backwards, so if a node with a skewed clock
+	// assigns a CAS from the future, later mutations are ordered after it.
+	maxCas uint64
+
+	// With deterministic UUIDs, each UUID is derived from the index of the
+	// vbucket and the number of UUIDs which it has previously generated.
+	vbIdx              uint
+	deterministicUUIDs bool
+	uuidGeneration     uint64
+}
+
+// vbDocKey identifies a document within a vbucket, across its revisions.
+type vbDocKey struct {
+	collectionID uint
+	key          string
 }
 
 type newVbucketOptions struct {
-	Chrono         *mocktime.Chrono
-	ReplicaLatency time.Duration
-	PersistLatency time.Duration
+	Chrono             *mocktime.Chrono
+	ReplicaLatency     time.Duration
+	PersistLatency     time.Duration
+	VbIdx              uint
+	DeterministicUUIDs bool
 }
 
 func newVbucket(opts newVbucketOptions) (*Vbucket, error) {
-	revData := []VbRevData{
+	vbucket := &Vbucket{
+		chrono:             opts.Chrono,
+		replicaLatency:     opts.ReplicaLatency,
+		persistLatency:     opts.PersistLatency,
+		checkpointID:       1,
+		vbIdx:              opts.VbIdx,
+		deterministicUUIDs: opts.DeterministicUUIDs,
+	}
+
+	vbucket.revData = []VbRevData{
 		{
-			VbUUID: generateNewVbUUID(),
+			VbUUID: vbucket.newUUIDLocked(),
 			SeqNo:  0,
 		},
 	}
 
-	return &Vbucket{
-		chrono:         opts.Chrono,
-		replicaLatency: opts.ReplicaLatency,
-		persistLatency: opts.PersistLatency,
-		revData:        revData,
-	}, nil
+	return vbucket, nil
+}
+
+// newUUIDLocked generates the UUID for a new entry of the revision history.
+func (s *Vbucket) newUUIDLocked() uint64 {
+	if !s.deterministicUUIDs {
+		return generateNewVbUUID()
+	}
+
+	vbUUID := DeterministicVbUUID(s.vbIdx, s.uuidGeneration)
+	s.uuidGeneration++
+	return vbUUID
 }
 
 func (s *Vbucket) maxSeqNoLocked() uint64 {
@@ -113,6 +203,99 @@ func (s *Vbucket) currentUUIDLocked() uint64 {
 	return curRevData.VbUUID
 }
 
+// replicaHorizonLocked returns the time before which, and the seqno up to
+// which, mutations have reached the replica with the specified index.
+func (s *Vbucket) replicaHorizonLocked(repIdx uint) (time.Time, uint64) {
+	curTime := s.chrono.Now()
+
+	lag, ok := s.replicaLags[repIdx]
+	if !ok || repIdx == 0 {
+		return curTime.Add(-time.Duration(repIdx) * s.replicaLatency), s.maxSeqNo
+	}
+
+	var visibleSeqNo uint64
+	if s.maxSeqNo > lag.SeqNos {
+		visibleSeqNo = s.maxSeqNo - lag.SeqNos
+	}
+
+	return curTime.Add(-lag.Latency), visibleSeqNo
+}
+
+// SetReplicaLag specifies how far a replica of this vbucket lags behind the
+// master, replacing the default lag based on the replica latency.  Passing a
+// nil lag restores the default.
+func (s *Vbucket) SetReplicaLag(repIdx uint, lag *ReplicaLag) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if repIdx == 0 {
+		return errors.New("the master cannot lag behind itself")
+	}
+
+	if lag == nil {
+		delete(s.replicaLags, repIdx)
+		return nil
+	}
+
+	if s.replicaLags == nil {
+		s.replicaLags = make(map[uint]ReplicaLag)
+	}
+	s.replicaLags[repIdx] = *lag
+	return nil
+}
+
+// isOnReplicaLocked returns whether a mutation has reached a replica with the
+// specified horizon.
+func (s *Vbucket) isOnReplicaLocked(doc *Document, repVisibleTime time.Time, repVisibleSeqNo uint64) bool {
+	if _, ok := s.unreplicatedSeqNos[doc.SeqNo]; ok {
+		return false
+	}
+
+	return doc.ModifiedTime.Before(repVisibleTime) && doc.SeqNo <= repVisibleSeqNo
+}
+
+// MarkUnreplicated marks a mutation as not having reached any replica, so that
+// it is not visible on the replicas and is lost if one of them is promoted.  A
+// seqno of zero marks the latest mutation of the specified document.  Returns
+// the seqno of the mutation which was marked.
+func (s *Vbucket) MarkUnreplicated(collectionID uint, key []byte, seqNo uint64) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var foundDoc *Document
+	if seqNo == 0 {
+		foundDoc = s.findLatestDocLocked(collectionID, key)
+	} else {
+		for _, doc := range s.documents {
+			if doc.SeqNo == seqNo {
+				foundDoc = doc
+				break
+			}
+		}
+	}
+	if foundDoc == nil || foundDoc.CollectionID != collectionID || !bytes.Equal(foundDoc.Key, key) {
+		return 0, ErrDocNotFound
+	}
+
+	if s.unreplicatedSeqNos == nil {
+		s.unreplicatedSeqNos = make(map[uint64]struct{})
+	}
+	s.unreplicatedSeqNos[foundDoc.SeqNo] = struct{}{}
+	return foundDoc.SeqNo, nil
+}
+
+// lowestUnreplicatedSeqNoLocked returns the lowest seqno which is marked as
+// not having been replicated, or zero if there are none.
+func (s *Vbucket) lowestUnreplicatedSeqNoLocked() uint64 {
+	var lowestSeqNo uint64
+	for seqNo := range s.unreplicatedSeqNos {
+		if lowestSeqNo == 0 || seqNo < lowestSeqNo {
+			lowestSeqNo = seqNo
+		}
+	}
+	return lowestSeqNo
+}
+
 func (s *Vbucket) hasDocExpired(doc *Document) bool {
 	// TODO(brett19): Need to emit a delete mutation when a document expires.
 	return !doc.Expiry.IsZero() && !s.chrono.Now().Before(doc.Expiry)
@@ -126,12 +309,11 @@ func (s *Vbucket) findDocLocked(repIdx, collectionID uint, key []byte) *Document
 	// document with the contents we want in it.
 
 	// Calculate when replica becomes visible
-	repLatency := time.Duration(repIdx) * s.replicaLatency
-	repVisibleTime := s.chrono.Now().Add(-repLatency)
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
 
 	var foundDoc *Document
 	for _, doc := range s.documents {
-		if repIdx > 0 && !doc.ModifiedTime.Before(repVisibleTime) {
+		if repIdx > 0 && !s.isOnReplicaLocked(doc, repVisibleTime, repVisibleSeqNo) {
 			continue
 		}
 
@@ -166,12 +348,78 @@ func (s *Vbucket) pushDocMutationLocked(doc *Document) *Document {
 	newDoc.SeqNo = s.nextSeqNoLocked()
 	newDoc.ModifiedTime = s.chrono.Now()
 	newDoc.RevID++
+	newDoc.IsValueEvicted = false
+	newDoc.IsMetaEvicted = false
+
+	if newDoc.Cas <= s.maxCas {
+		newDoc.Cas = nextLogicalCas(s.maxCas)
+	}
+	s.maxCas = newDoc.Cas
 
 	s.documents = append(s.documents, newDoc)
+	s.accountMemUsedLocked(newDoc)
+	s.notifyMutationLocked()
 
 	return copyDocument(newDoc)
 }
 
+// pushSystemEvent assigns the next seqno of the vbucket to a system event.
+func (s *Vbucket) pushSystemEvent(event *SystemEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	newEvent := *event
+	newEvent.VbUUID = s.currentUUIDLocked()
+	newEvent.SeqNo = s.nextSeqNoLocked()
+	newEvent.ModifiedTime = s.chrono.Now()
+
+	s.events = append(s.events, &newEvent)
+	s.notifyMutationLocked()
+}
+
+func (s *Vbucket) notifyMutationLocked() {
+	if s.mutationCh != nil {
+		close(s.mutationCh)
+		s.mutationCh = nil
+	}
+}
+
+// MutationNotify returns a channel which will be closed the next time that
+// the contents of this vbucket change.
+func (s *Vbucket) MutationNotify() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.mutationCh == nil {
+		s.mutationCh = make(chan struct{})
+	}
+	return s.mutationCh
+}
+
+// FailoverLog returns the revision history of this vbucket, oldest first.
+func (s *Vbucket) FailoverLog() []VbRevData {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]VbRevData{}, s.revData...)
+}
+
+// MaxSeqNo returns the highest seqno which has been assigned in this vbucket.
+func (s *Vbucket) MaxSeqNo() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.maxSeqNoLocked()
+}
+
+// MaxCas returns the highest CAS which has been assigned in this vbucket.
+func (s *Vbucket) MaxCas() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.maxCas
+}
+
 // VbMetaState holds some information about the meta-state of a vbucket.
 type VbMetaState struct {
 	VbUUID       uint64
@@ -185,18 +433,17 @@ func (s *Vbucket) CurrentMetaState(repIdx uint) VbMetaState {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	curTime := s.chrono.Now()
-
-	repLatency := time.Duration(repIdx) * s.replicaLatency
-	repVisibleTime := curTime.Add(-repLatency)
-
-	prsLatency := repLatency + s.persistLatency
-	prsVisibleTime := curTime.Add(-prsLatency)
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
+	prsVisibleTime := repVisibleTime.Add(-s.persistLatency)
 
 	var currentSeqNo uint64
 	var persistSeqNo uint64
 
 	for _, doc := range s.documents {
+		if doc.SeqNo > repVisibleSeqNo {
+			continue
+		}
+
 		if !doc.ModifiedTime.After(repVisibleTime) {
 			if doc.SeqNo > currentSeqNo {
 				currentSeqNo = doc.SeqNo
@@ -210,6 +457,27 @@ func (s *Vbucket) CurrentMetaState(repIdx uint) VbMetaState {
 		}
 	}
 
+	// Replicas which have acknowledged a seqno have necessarily also received it.
+	if repIdx > 0 {
+		if s.replicaAckSeqNo > currentSeqNo {
+			currentSeqNo = s.replicaAckSeqNo
+		}
+		if s.replicaAckSeqNo > persistSeqNo {
+			persistSeqNo = s.replicaAckSeqNo
+		}
+
+		// Mutations are replicated in order, so a replica which is missing a
+		// mutation cannot report having received anything beyond it.
+		if lowestSeqNo := s.lowestUnreplicatedSeqNoLocked(); lowestSeqNo > 0 {
+			if currentSeqNo >= lowestSeqNo {
+				currentSeqNo = lowestSeqNo - 1
+			}
+			if persistSeqNo >= lowestSeqNo {
+				persistSeqNo = lowestSeqNo - 1
+			}
+		}
+	}
+
 	return VbMetaState{
 		VbUUID:       s.currentUUIDLocked(),
 		CurrentSeqNo: currentSeqNo,
@@ -217,18 +485,61 @@ func (s *Vbucket) CurrentMetaState(repIdx uint) VbMetaState {
 	}
 }
 
+// AcknowledgeSeqNo records that a replica has persisted all mutations up to
+// and including the specified seqno.
+func (s *Vbucket) AcknowledgeSeqNo(seqNo uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if seqNo > s.maxSeqNoLocked() {
+		return errors.New("cannot acknowledge a seqno beyond the vbuckets max seqno")
+	}
+
+	if seqNo > s.replicaAckSeqNo {
+		s.replicaAckSeqNo = seqNo
+	}
+
+	return nil
+}
+
+// ReplicaAckSeqNo returns the highest seqno which a replica has acknowledged.
+func (s *Vbucket) ReplicaAckSeqNo() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.replicaAckSeqNo
+}
+
+// CreateCheckpoint closes the currently open checkpoint and opens a new one,
+// returning the id of the newly opened checkpoint.
+func (s *Vbucket) CreateCheckpoint() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.checkpointID++
+	return s.checkpointID
+}
+
+// OpenCheckpointID returns the id of the currently open checkpoint.  The last
+// closed checkpoint is always the one immediately before it.
+func (s *Vbucket) OpenCheckpointID() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.checkpointID
+}
+
 // GetAll returns all documents in the vbucket.
 func (s *Vbucket) GetAll(repIdx, collectionID uint) ([]*Document, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	// Calculate when replica becomes visible
-	repLatency := time.Duration(repIdx) * s.replicaLatency
-	repVisibleTime := s.chrono.Now().Add(-repLatency)
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
 
 	var docs []*Document
 	for _, doc := range s.documents {
-		if repIdx > 0 && !doc.ModifiedTime.Before(repVisibleTime) {
+		if repIdx > 0 && !s.isOnReplicaLocked(doc, repVisibleTime, repVisibleSeqNo) {
 			continue
 		}
 
@@ -264,63 +575,82 @@ func (s *Vbucket) Get(repIdx, collectionID uint, key []byte) (*Document, error)
 	return foundDoc, nil
 }
 
-// GetRandom returns a random, not deleted or expired, document in the vbucket
-func (s *Vbucket) GetRandom(repIdx, collectionID uint) *Document {
+// GetRandom returns a random, not deleted or expired, document in the vbucket,
+// picked using the specified random source.
+func (s *Vbucket) GetRandom(repIdx, collectionID uint, rng *rand.Rand) *Document {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if len(s.documents) == 0 {
-		return nil
-	}
-
 	// Calculate when replica becomes visible
-	repLatency := time.Duration(repIdx) * s.replicaLatency
-	repVisibleTime := s.chrono.Now().Add(-repLatency)
-
-	var foundDoc *Document
-	start := rand.Intn(len(s.documents))
-	curr := start
-	for {
-		doc := s.documents[curr]
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
 
-		if !doc.ModifiedTime.Before(repVisibleTime) {
+	// Find the latest revision of every document in the collection, since the
+	// mutation list also contains old revisions and tombstones.
+	latestDocs := make(map[string]*Document)
+	for _, doc := range s.documents {
+		if repIdx > 0 && !s.isOnReplicaLocked(doc, repVisibleTime, repVisibleSeqNo) {
 			continue
 		}
 
 		if doc.CollectionID == collectionID {
-			foundDoc = doc
+			latestDocs[string(doc.Key)] = doc
 		}
+	}
 
-		if foundDoc != nil {
-			// Need to COW this.
-			foundDoc = copyDocument(foundDoc)
+	var liveDocs []*Document
+	for _, doc := range latestDocs {
+		if !doc.IsDeleted && !s.hasDocExpired(doc) {
+			liveDocs = append(liveDocs, doc)
+		}
+	}
 
-			if s.hasDocExpired(foundDoc) {
-				foundDoc = nil
-				continue
-			}
+	if len(liveDocs) == 0 {
+		return nil
+	}
 
-			// We also cheat and clean this up here...
-			if !s.chrono.Now().Before(foundDoc.LockExpiry) {
-				foundDoc.LockExpiry = time.Time{}
-			}
-		}
+	// Order the candidates so that the selection only depends on the source
+	// of randomness, rather than on map iteration order.
+	sort.Slice(liveDocs, func(i, j int) bool {
+		return liveDocs[i].SeqNo < liveDocs[j].SeqNo
+	})
 
-		if foundDoc != nil {
-			break
-		}
+	// Need to COW this.
+	foundDoc := copyDocument(liveDocs[rng.Intn(len(liveDocs))])
+
+	// We also cheat and clean this up here...
+	if !s.chrono.Now().Before(foundDoc.LockExpiry) {
+		foundDoc.LockExpiry = time.Time{}
+	}
+
+	return foundDoc
+}
+
+// ItemCount returns the number of live documents across all collections of
+// the vbucket, as visible to a particular replica.
+func (s *Vbucket) ItemCount(repIdx uint) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Calculate when replica becomes visible
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
 
-		curr++
-		if curr == len(s.documents) {
-			curr = 0
+	latestDocs := make(map[vbDocKey]*Document)
+	for _, doc := range s.documents {
+		if repIdx > 0 && !s.isOnReplicaLocked(doc, repVisibleTime, repVisibleSeqNo) {
+			continue
 		}
 
-		if curr == start {
-			break
+		latestDocs[vbDocKey{doc.CollectionID, string(doc.Key)}] = doc
+	}
+
+	var numItems uint64
+	for _, doc := range latestDocs {
+		if !doc.IsDeleted && !s.hasDocExpired(doc) {
+			numItems++
 		}
 	}
 
-	return foundDoc
+	return numItems
 }
 
 // GetAllWithin returns a list of all the mutations that have occurred
@@ -356,6 +686,24 @@ func (s *Vbucket) GetAllWithin(repIdx uint, startSeqNo, endSeqNo uint64) ([]*Doc
 	return docsOut, vbUUID, nil
 }
 
+// GetSystemEventsWithin returns a list of all the system events that have
+// occurred in a vbucket within the bounds of the sequence numbers passed, in
+// ascending seqno order.
+func (s *Vbucket) GetSystemEventsWithin(startSeqNo, endSeqNo uint64) []*SystemEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var eventsOut []*SystemEvent
+	for _, event := range s.events {
+		if event.SeqNo > startSeqNo && event.SeqNo <= endSeqNo {
+			eventOut := *event
+			eventsOut = append(eventsOut, &eventOut)
+		}
+	}
+
+	return eventsOut
+}
+
 // insert stores a document to the vbucket, failing if the specified
 // key already exists within the vbucket.
 func (s *Vbucket) insert(doc *Document) (*Document, error) {
@@ -374,9 +722,20 @@ type UpdateFunc func(*Document) (*Document, error)
 // update allows a document to be atomically operated upon in the vbucket.
 // NOTE: This must never be called on a replica vbucket.
 func (s *Vbucket) update(collectionID uint, key []byte, fn UpdateFunc) (*Document, error) {
+	return s.updateAtSeqNo(collectionID, key, 0, fn)
+}
+
+// updateAtSeqNo is identical to update, except that a non-zero seqNo requires
+// that the mutation is assigned exactly that seqno.
+// NOTE: This must never be called on a replica vbucket.
+func (s *Vbucket) updateAtSeqNo(collectionID uint, key []byte, seqNo uint64, fn UpdateFunc) (*Document, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	if seqNo != 0 && s.maxSeqNoLocked()+1 != seqNo {
+		return nil, ErrSeqNoMismatch
+	}
+
 	// Try to find the document as input to the functor.
 	foundDoc := s.findDocLocked(0, collectionID, key)
 
@@ -386,7 +745,7 @@ func (s *Vbucket) update(collectionID uint, key []byte, fn UpdateFunc) (*Documen
 		return nil, err
 	}
 
-	if len(newDoc.Value) > 20*1024*1024 {
+	if len(newDoc.Value) > MaxValueSize {
 		return nil, ErrValueTooBig
 	}
 
@@ -398,6 +757,175 @@ func (s *Vbucket) update(collectionID uint, key []byte, fn UpdateFunc) (*Documen
 	return s.pushDocMutationLocked(newDoc), nil
 }
 
+// corrupt overwrites the raw value and datatype of the latest revision of a
+// document in place.  No new mutation is generated and no validation of the
+// new value is performed.  This is intended only for testing.
+func (s *Vbucket) corrupt(collectionID uint, key []byte, value []byte, datatype uint8) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for docIdx := len(s.documents) - 1; docIdx >= 0; docIdx-- {
+		doc := s.documents[docIdx]
+		if doc.CollectionID == collectionID && bytes.Equal(doc.Key, key) {
+			if doc.IsDeleted {
+				return ErrDocNotFound
+			}
+
+			doc.Value = append([]byte{}, value...)
+			doc.Datatype = datatype
+			s.accountMemUsedLocked(doc)
+			return nil
+		}
+	}
+
+	return ErrDocNotFound
+}
+
+// findLatestDocLocked returns the latest revision of a document as stored in
+// the vbucket, rather than a copy of it.
+func (s *Vbucket) findLatestDocLocked(collectionID uint, key []byte) *Document {
+	for docIdx := len(s.documents) - 1; docIdx >= 0; docIdx-- {
+		doc := s.documents[docIdx]
+		if doc.CollectionID == collectionID && bytes.Equal(doc.Key, key) {
+			return doc
+		}
+	}
+
+	return nil
+}
+
+// evict marks the latest revision of a document as no longer being resident
+// in memory.  When evictMeta is set, the metadata is evicted along with the
+// value.  Returns whether the document was already evicted.
+// NOTE: This must never be called on a replica vbucket.
+func (s *Vbucket) evict(collectionID uint, key []byte, evictMeta bool) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	doc := s.findLatestDocLocked(collectionID, key)
+	if doc == nil || doc.IsDeleted || s.hasDocExpired(doc) {
+		return false, ErrDocNotFound
+	}
+
+	// Documents can only be evicted once they have been persisted to disk.
+	if doc.ModifiedTime.After(s.chrono.Now().Add(-s.persistLatency)) {
+		return false, ErrDocDirty
+	}
+
+	if doc.IsValueEvicted && (doc.IsMetaEvicted || !evictMeta) {
+		return true, nil
+	}
+
+	doc.IsValueEvicted = true
+	doc.IsMetaEvicted = evictMeta
+	s.accountMemUsedLocked(doc)
+	return false, nil
+}
+
+// fetch brings an evicted document back into memory, as would happen when an
+// operation needs data which is not resident.  When withValue is not set, only
+// the metadata of the document is fetched.
+// NOTE: This must never be called on a replica vbucket.
+func (s *Vbucket) fetch(collectionID uint, key []byte, withValue bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	doc := s.findLatestDocLocked(collectionID, key)
+	if doc == nil {
+		return
+	}
+
+	if withValue && doc.IsValueEvicted {
+		doc.IsValueEvicted = false
+		doc.IsMetaEvicted = false
+		s.bgFetches++
+	} else if doc.IsMetaEvicted {
+		doc.IsMetaEvicted = false
+		s.bgMetaFetches++
+	}
+	s.accountMemUsedLocked(doc)
+}
+
+// docMemUsed returns an approximation of the memory used by a revision of a
+// document, excluding anything which has been evicted.
+func docMemUsed(doc *Document) uint64 {
+	if doc.IsMetaEvicted {
+		return 0
+	}
+
+	memUsed := uint64(len(doc.Key))
+	if !doc.IsValueEvicted {
+		memUsed += uint64(len(doc.Value))
+		for xattrKey, xattrValue := range doc.Xattrs {
+			memUsed += uint64(len(xattrKey) + len(xattrValue))
+		}
+	}
+	return memUsed
+}
+
+// accountMemUsedLocked updates the memory used by the vbucket for a document
+// whose latest revision has been stored or changed in place.
+func (s *Vbucket) accountMemUsedLocked(doc *Document) {
+	if s.docMemUsed == nil {
+		s.docMemUsed = make(map[vbDocKey]uint64)
+	}
+
+	docKey := vbDocKey{doc.CollectionID, string(doc.Key)}
+	s.memUsed -= s.docMemUsed[docKey]
+
+	memUsed := docMemUsed(doc)
+	if memUsed > 0 {
+		s.docMemUsed[docKey] = memUsed
+	} else {
+		delete(s.docMemUsed, docKey)
+	}
+	s.memUsed += memUsed
+}
+
+// recountMemUsedLocked recalculates the memory used by the vbucket from all of
+// its documents, after revisions have been removed from it.
+func (s *Vbucket) recountMemUsedLocked() {
+	s.memUsed = 0
+	s.docMemUsed = nil
+	for _, doc := range s.documents {
+		s.accountMemUsedLocked(doc)
+	}
+}
+
+// MemUsed returns an approximation of the memory used by the latest revision
+// of each document in the vbucket, excluding anything which has been evicted.
+func (s *Vbucket) MemUsed() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.memUsed
+}
+
+// BgFetchStats returns the number of value fetches, and then the number of
+// metadata only fetches, which have been needed for evicted documents.
+func (s *Vbucket) BgFetchStats() (uint64, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.bgFetches, s.bgMetaFetches
+}
+
+// bulkPush stores a list of documents to the vbucket under a single lock,
+// assigning each one a new CAS, generated from the HLC time now, and seqno.
+// NOTE: This must never be called on a replica vbucket.
+func (s *Vbucket) bulkPush(docs []*Document, now time.Time) []*Document {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	docsOut := make([]*Document, len(docs))
+	for docIdx, doc := range docs {
+		doc.Cas = GenerateNewCas(now)
+		docsOut[docIdx] = s.pushDocMutationLocked(doc)
+	}
+
+	return docsOut
+}
+
 // Compact will compact all of the mutations within a vbucket such that no two
 // sequence numbers exist which are for the same document key.
 func (s *Vbucket) Compact() error {
@@ -410,6 +938,66 @@ func (s *Vbucket) Compact() error {
 	return errors.New("not supported")
 }
 
+// PurgeTombstones removes every document whose latest revision is a tombstone,
+// along with all of its earlier revisions, and returns the new purge seqno.
+// Unlike Compact, no live revision is ever merged into another, so rollbacks
+// only lose deletions below the purge seqno.
+func (s *Vbucket) PurgeTombstones() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.purgeTombstonesLocked()
+	return s.purgeSeqNo
+}
+
+// ForcePurgeTombstones removes every tombstone like PurgeTombstones, but also
+// advances the purge seqno to the high seqno of the vbucket, as if every
+// deletion up to that point had been purged.  It returns the new purge seqno.
+func (s *Vbucket) ForcePurgeTombstones() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.purgeTombstonesLocked()
+	if s.maxSeqNo > s.purgeSeqNo {
+		s.purgeSeqNo = s.maxSeqNo
+	}
+
+	// Streams are woken so that they notice the purge seqno has moved.
+	s.notifyMutationLocked()
+
+	return s.purgeSeqNo
+}
+
+func (s *Vbucket) purgeTombstonesLocked() {
+	latestDocs := make(map[vbDocKey]*Document)
+	for _, doc := range s.documents {
+		latestDocs[vbDocKey{doc.CollectionID, string(doc.Key)}] = doc
+	}
+
+	newDocuments := make([]*Document, 0, len(s.documents))
+	for _, doc := range s.documents {
+		latestDoc := latestDocs[vbDocKey{doc.CollectionID, string(doc.Key)}]
+		if !latestDoc.IsDeleted {
+			newDocuments = append(newDocuments, doc)
+			continue
+		}
+
+		if latestDoc.SeqNo > s.purgeSeqNo {
+			s.purgeSeqNo = latestDoc.SeqNo
+		}
+	}
+	s.documents = newDocuments
+	s.recountMemUsedLocked()
+}
+
+// PurgeSeqNo returns the highest seqno of any tombstone which has been purged.
+func (s *Vbucket) PurgeSeqNo() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.purgeSeqNo
+}
+
 type vbucketSnapshot struct {
 	VbUUID uint64
 	SeqNo  uint64
@@ -465,6 +1053,40 @@ func (s *Vbucket) isSeqNoInHistoryLocked(vbUUID, seqNo uint64) bool {
 	return true
 }
 
+// trimAfterLocked removes all mutations with a seqno above the specified one,
+// leaving that seqno as the max seqno of the vbucket.  The history is left for
+// the caller to extend.
+// NOTE: This must be called with the lock of the vbucket held.
+func (s *Vbucket) trimAfterLocked(seqNo uint64) {
+	newMutations := make([]*Document, 0, len(s.documents))
+	for _, mutation := range s.documents {
+		if mutation.SeqNo <= seqNo {
+			newMutations = append(newMutations, mutation)
+		}
+	}
+
+	s.documents = newMutations
+	s.recountMemUsedLocked()
+
+	newEvents := make([]*SystemEvent, 0, len(s.events))
+	for _, event := range s.events {
+		if event.SeqNo <= seqNo {
+			newEvents = append(newEvents, event)
+		}
+	}
+	s.events = newEvents
+
+	s.maxSeqNo = seqNo
+	for unreplicatedSeqNo := range s.unreplicatedSeqNos {
+		if unreplicatedSeqNo > s.maxSeqNo {
+			delete(s.unreplicatedSeqNos, unreplicatedSeqNo)
+		}
+	}
+	if s.replicaAckSeqNo > s.maxSeqNo {
+		s.replicaAckSeqNo = s.maxSeqNo
+	}
+}
+
 // rollback will rollback this vbucket to a specific seqno (including that seqno).
 // It will additionally rollback the history for this vbucket to match.
 func (s *Vbucket) rollback(snap *vbucketSnapshot) error {
@@ -475,20 +1097,86 @@ func (s *Vbucket) rollback(snap *vbucketSnapshot) error {
 		return errors.New("snapshot is no longer valid")
 	}
 
+	s.trimAfterLocked(snap.SeqNo)
+
+	s.revData = append(s.revData, VbRevData{
+		VbUUID: 0,
+		SeqNo:  s.maxSeqNo,
+	})
+	s.notifyMutationLocked()
+
+	return nil
+}
+
+// promote discards all mutations which have not yet reached the specified
+// replica, as would happen if that replica was promoted to be the master for
+// this vbucket during a failover.  A new entry is added to the history.
+func (s *Vbucket) promote(repIdx uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	repVisibleTime, repVisibleSeqNo := s.replicaHorizonLocked(repIdx)
+
+	var maxSeqNo uint64
 	newMutations := make([]*Document, 0, len(s.documents))
 	for _, mutation := range s.documents {
-		if mutation.SeqNo <= snap.SeqNo {
+		if _, ok := s.unreplicatedSeqNos[mutation.SeqNo]; ok {
+			continue
+		}
+
+		// Acknowledged mutations are known to have reached the replica.
+		if s.isOnReplicaLocked(mutation, repVisibleTime, repVisibleSeqNo) ||
+			mutation.SeqNo <= s.replicaAckSeqNo {
 			newMutations = append(newMutations, mutation)
+			maxSeqNo = mutation.SeqNo
+		}
+	}
+
+	newEvents := make([]*SystemEvent, 0, len(s.events))
+	for _, event := range s.events {
+		if (event.ModifiedTime.Before(repVisibleTime) && event.SeqNo <= repVisibleSeqNo) ||
+			event.SeqNo <= s.replicaAckSeqNo {
+			newEvents = append(newEvents, event)
+			if event.SeqNo > maxSeqNo {
+				maxSeqNo = event.SeqNo
+			}
 		}
 	}
 
 	s.documents = newMutations
-	s.maxSeqNo = snap.SeqNo
+	s.events = newEvents
+	s.recountMemUsedLocked()
+	s.maxSeqNo = maxSeqNo
+	if s.replicaAckSeqNo > s.maxSeqNo {
+		s.replicaAckSeqNo = s.maxSeqNo
+	}
+	s.unreplicatedSeqNos = nil
 
 	s.revData = append(s.revData, VbRevData{
-		VbUUID: 0,
+		VbUUID: s.newUUIDLocked(),
+		SeqNo:  s.maxSeqNo,
+	})
+	s.notifyMutationLocked()
+}
+
+// discardAfter removes all mutations with a seqno above the specified one
+// and begins a new branch of the failover log from that point, as happens
+// when an unclean failover loses recent mutations.
+func (s *Vbucket) discardAfter(seqNo uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if seqNo > s.maxSeqNoLocked() {
+		return errors.New("cannot discard mutations beyond the vbuckets max seqno")
+	}
+
+	s.trimAfterLocked(seqNo)
+
+	s.revData = append(s.revData, VbRevData{
+		VbUUID: s.newUUIDLocked(),
 		SeqNo:  s.maxSeqNo,
 	})
+	s.notifyMutationLocked()
 
 	return nil
 }
@@ -500,11 +1188,18 @@ func (s *Vbucket) Flush() {
 	defer s.lock.Unlock()
 
 	s.documents = make([]*Document, 0)
+	s.events = nil
+	s.recountMemUsedLocked()
 	s.revData = []VbRevData{
 		{
-			VbUUID: generateNewVbUUID(),
+			VbUUID: s.newUUIDLocked(),
 			SeqNo: 0,
 		},
 	}
 	s.maxSeqNo = 0
+	s.replicaAckSeqNo = 0
+	s.unreplicatedSeqNos = nil
+	s.purgeSeqNo = 0
+	s.checkpointID = 1
+	s.notifyMutationLocked()
 }
@@ -0,0 +1,65 @@
+package mockimpl
+
+import (
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+func TestListATREntries(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+	})
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name: "default",
+		Type: mock.BucketTypeCouchbase,
+	})
+
+	_, err := bucket.Store().SetXattr(0, []byte("_txn:atr-12-#4c"), "attempts", []byte(`{
+		"attempt-2": {"tid": "txn-2", "st": "ABORTED", "exp": 15000, "tst": "0x0000e1cbb7f21c16",
+			"rep": [{"bkt": "default", "scp": "_default", "col": "_default", "id": "replaced"}]},
+		"attempt-1": {"tid": "txn-1", "st": "COMMITTED", "exp": 15000, "tst": "0x0000e1cbb7f21c16",
+			"tsc": "0x0000f2cbb7f21c16",
+			"ins": [{"bkt": "default", "scp": "_default", "col": "_default", "id": "inserted"}]}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to store atr: %s", err)
+	}
+
+	// Only documents with the atr prefix are active transaction records.
+	_, err = bucket.Store().SetXattr(0, []byte("not-an-atr"), "attempts", []byte(`{"attempt-3": {}}`))
+	if err != nil {
+		t.Fatalf("failed to store document: %s", err)
+	}
+
+	entries, err := mock.ListATREntries(cluster)
+	if err != nil {
+		t.Fatalf("failed to list atr entries: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 atr entries, got %d", len(entries))
+	}
+
+	committed := entries[0]
+	if committed.AttemptID != "attempt-1" || committed.TransactionID != "txn-1" || committed.State != "COMMITTED" {
+		t.Fatalf("unexpected first entry: %+v", committed)
+	}
+	if committed.Bucket != "default" || committed.Scope != "_default" || committed.Collection != "_default" ||
+		committed.ATRKey != "_txn:atr-12-#4c" {
+		t.Fatalf("unexpected atr location: %+v", committed)
+	}
+	if committed.CommitTime != "0x0000f2cbb7f21c16" || committed.RollbackTime != "" {
+		t.Fatalf("unexpected timestamps: %+v", committed)
+	}
+	if len(committed.Inserts) != 1 || committed.Inserts[0].Key != "inserted" {
+		t.Fatalf("unexpected staged inserts: %+v", committed.Inserts)
+	}
+
+	aborted := entries[1]
+	if aborted.AttemptID != "attempt-2" || aborted.State != "ABORTED" {
+		t.Fatalf("unexpected second entry: %+v", aborted)
+	}
+	if len(aborted.Replaces) != 1 || aborted.Replaces[0].Key != "replaced" || len(aborted.Inserts) != 0 {
+		t.Fatalf("unexpected staged mutations: %+v", aborted)
+	}
+}
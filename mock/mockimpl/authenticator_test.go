@@ -0,0 +1,79 @@
+package mockimpl
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFlakyAuthenticator rejects every third authentication, deferring to
+// another authenticator for everything else.
+type testFlakyAuthenticator struct {
+	mock.Authenticator
+
+	lock     sync.Mutex
+	attempts int
+}
+
+func (a *testFlakyAuthenticator) Authenticate(username, password string) bool {
+	a.lock.Lock()
+	a.attempts++
+	attempt := a.attempts
+	a.lock.Unlock()
+
+	if attempt%3 == 0 {
+		return false
+	}
+	return a.Authenticator.Authenticate(username, password)
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	roles := cluster.Authenticator().Roles("Administrator")
+	if assert.Len(t, roles, 1) {
+		assert.Equal(t, &mockauth.UserRole{Name: "admin"}, roles[0])
+	}
+	assert.Empty(t, cluster.Authenticator().Roles("missing"))
+
+	cluster.SetAuthenticator(&testFlakyAuthenticator{Authenticator: cluster.Authenticator()})
+
+	saslAuth := func() memd.StatusCode {
+		conn := dialTestKv(t, node)
+		defer conn.Close()
+
+		return conn.roundTrip(&memd.Packet{
+			Command: memd.CmdSASLAuth,
+			Key:     []byte("PLAIN"),
+			Value:   []byte("\x00Administrator\x00password"),
+		}).Status
+	}
+
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+	assert.Equal(t, memd.StatusAuthError, saslAuth())
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+
+	// Restoring the default authenticator can race with clients which are
+	// authenticating.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cluster.SetAuthenticator(nil)
+	}()
+	saslAuth()
+	wg.Wait()
+
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+	assert.Equal(t, memd.StatusSuccess, saslAuth())
+}
@@ -1,13 +1,18 @@
 package mockimpl
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
 
 	"github.com/couchbaselabs/gocaves/mock/mockmr"
 
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockdb"
-	"github.com/google/uuid"
 )
 
 // bucketInst represents an instance of a bucketInst
@@ -19,22 +24,51 @@ type bucketInst struct {
 	numReplicas         uint
 	numVbuckets         uint
 	store               *mockdb.Bucket
-	configRev           uint
+	configRev           uint // guarded by the configRevLock of the cluster
 	flushEnabled        bool
 	ramQuota            uint64
 	replicaIndexEnabled bool
 	compressionMode     mock.CompressionMode
+	evictionPolicy      mock.EvictionPolicy
+	storageBackend      mock.StorageBackend
+	throttleProps       mock.ThrottleProperties
+	dataLimitStatus     memd.StatusCode
+	docLimits           mock.DocumentLimits
+
+	// engineParams holds the engine parameters set through SET_PARAM, keyed
+	// by their name.
+	engineParams *sync.Map
+
+	// throttleWarnings holds the users which are approaching the throttling
+	// limits, with the empty user name standing for every user.
+	throttleWarnings *sync.Map
+
+	compaction *bucketCompaction
+	pause      *bucketPause
 
 	// vbMap is an array for each vbucket, containing an array for
 	// each replica, containing the UUID of the node responsible.
 	// If a ClusterNode is removed, then it will still be in this map
 	// until a rebalance.  We do not keep ClusterNode pointers here
 	// directly so we can avoid needing to have a cyclical dependancy.
-	vbMap [][]string
+	// vbMapLock protects both vbMap and evacuatedNodes.
+	vbMapLock sync.Mutex
+	vbMap     [][]string
 
 	collManifest *mock.CollectionManifest
 
+	manifestStaggerLock sync.Mutex
+	manifestStagger     time.Duration
+
 	viewEngine *mockmr.Engine
+
+	dcpStreams *mock.DcpStreamRegistry
+
+	// evacuatedNodes are the nodes which the bucket is open on, but which
+	// have had all of their vbuckets moved elsewhere.
+	evacuatedNodes []string
+	rangeScans     *mock.RangeScanRegistry
+	syncWrites     *mock.SyncWriteRegistry
 }
 
 func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, error) {
@@ -45,34 +79,77 @@ func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, er
 		replicas = 0 // This should already be set to 0 by the caller but let's force it.
 	}
 
+	if opts.EvictionPolicy == "" {
+		if opts.Type == mock.BucketTypeEphemeral {
+			opts.EvictionPolicy = mock.EvictionPolicyNoEviction
+		} else {
+			opts.EvictionPolicy = mock.EvictionPolicyValueOnly
+		}
+	}
+
+	if opts.StorageBackend == "" {
+		opts.StorageBackend = mock.StorageBackendCouchstore
+	}
+
+	if opts.UUID == "" {
+		opts.UUID = newUUID()
+	}
+
+	// Random documents are picked the same way on every run of a deterministic
+	// cluster, so that random keys are reproducible along with mutation tokens.
+	randomSeed := time.Now().UnixNano()
+	if parent.deterministic {
+		randomSeed = 0
+	}
+
 	// We currently always use a single replica here.  We use this 1 replica for all
 	// replicas that are needed, and it is potentially unused if the buckets replica
 	// count is 0.
 	bucketStore, err := mockdb.NewBucket(mockdb.NewBucketOptions{
-		Chrono:         parent.chrono,
-		NumReplicas:    1,
-		NumVbuckets:    vbuckets,
-		ReplicaLatency: parent.replicaLatency,
-		PersistLatency: parent.persistLatency,
+		Chrono:               parent.chrono,
+		NumReplicas:          1,
+		NumVbuckets:          vbuckets,
+		ReplicaLatency:       parent.replicaLatency,
+		PersistLatency:       parent.persistLatency,
+		DeterministicVbUUIDs: parent.deterministic,
+		RandomSeed:           randomSeed,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	bucket := &bucketInst{
-		id:                  uuid.New().String(),
+		id:                  opts.UUID,
 		cluster:             parent,
 		name:                opts.Name,
 		bucketType:          opts.Type,
 		numReplicas:         replicas,
 		numVbuckets:         vbuckets,
 		store:               bucketStore,
-		collManifest:        mock.NewCollectionManifest(),
+		collManifest:        mock.NewCollectionManifest(parent.chrono),
 		viewEngine:          mockmr.NewEngine(),
+		dcpStreams:          mock.NewDcpStreamRegistry(),
+		rangeScans:          mock.NewRangeScanRegistry(parent.chrono),
+		syncWrites:          mock.NewSyncWriteRegistry(),
 		replicaIndexEnabled: opts.ReplicaIndexEnabled,
 		flushEnabled:        opts.FlushEnabled,
+		engineParams:        &sync.Map{},
+		throttleWarnings:    &sync.Map{},
+		compaction:          &bucketCompaction{},
 		ramQuota:            opts.RamQuota,
 		compressionMode:     opts.CompressionMode,
+		evictionPolicy:      opts.EvictionPolicy,
+		storageBackend:      opts.StorageBackend,
+		docLimits: mock.DocumentLimits{
+			MaxDepth: mock.DefaultMaxDocumentDepth,
+		},
+		throttleProps: mock.ThrottleProperties{
+			Reserved:  mock.ThrottleLimitUnlimited,
+			HardLimit: mock.ThrottleLimitUnlimited,
+		},
+		pause: &bucketPause{
+			state: mock.BucketPauseStateRunning,
+		},
 	}
 
 	// Initially set up the vbucket map with nothing in it.
@@ -83,37 +160,79 @@ func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, er
 }
 
 // ID returns the uuid of this bucket.
-func (b bucketInst) ID() string {
+func (b *bucketInst) ID() string {
 	return b.id
 }
 
 // Name returns the name of this bucket
-func (b bucketInst) Name() string {
+func (b *bucketInst) Name() string {
 	return b.name
 }
 
 // BucketType returns the type of bucket this is.
-func (b bucketInst) BucketType() mock.BucketType {
+func (b *bucketInst) BucketType() mock.BucketType {
 	return b.bucketType
 }
 
 // NumReplicas returns the number of configured replicas for this bucket
-func (b bucketInst) NumReplicas() uint {
+func (b *bucketInst) NumReplicas() uint {
 	return b.numReplicas
 }
 
+// Cluster returns the cluster this bucket belongs to.
+func (b *bucketInst) Cluster() mock.Cluster {
+	return b.cluster
+}
+
 // ConfigRev returns the current configuration revision for this bucket.
-func (b bucketInst) ConfigRev() uint {
+func (b *bucketInst) ConfigRev() uint {
+	b.cluster.configRevLock.Lock()
+	defer b.cluster.configRevLock.Unlock()
 	return b.configRev
 }
 
 // CollectionManifest returns the collection manifest of this bucket.
-func (b bucketInst) CollectionManifest() *mock.CollectionManifest {
+func (b *bucketInst) CollectionManifest() *mock.CollectionManifest {
 	return b.collManifest
 }
 
+// ManifestStagger returns how long each node lags behind the previous one in
+// adopting collection manifest changes.
+func (b *bucketInst) ManifestStagger() time.Duration {
+	b.manifestStaggerLock.Lock()
+	defer b.manifestStaggerLock.Unlock()
+
+	return b.manifestStagger
+}
+
+// SetManifestStagger sets how long each node lags behind the previous one in
+// adopting collection manifest changes.
+func (b *bucketInst) SetManifestStagger(stagger time.Duration) {
+	b.manifestStaggerLock.Lock()
+	b.manifestStagger = stagger
+	b.manifestStaggerLock.Unlock()
+}
+
+// NodeManifestTime returns the point in time which a node's view of the
+// collection manifest reflects.
+func (b *bucketInst) NodeManifestTime(node mock.ClusterNode) time.Time {
+	now := b.cluster.chrono.Now()
+	if node == nil {
+		return now
+	}
+
+	// Nodes adopt manifest changes in the order they were added to the cluster.
+	stagger := b.ManifestStagger()
+	for nodeIdx, clusterNode := range b.cluster.nodes {
+		if clusterNode.ID() == node.ID() {
+			return now.Add(-time.Duration(nodeIdx) * stagger)
+		}
+	}
+	return now
+}
+
 // Store returns the data-store for this bucket.
-func (b bucketInst) Store() *mockdb.Bucket {
+func (b *bucketInst) Store() *mockdb.Bucket {
 	return b.store
 }
 
@@ -145,13 +264,235 @@ func (b *bucketInst) UpdateVbMap(nodeList []string) {
 		}
 	}
 
+	b.vbMapLock.Lock()
+	b.vbMap = newVbMap
+	b.evacuatedNodes = nil
+	b.vbMapLock.Unlock()
+
+	b.updateConfig()
+}
+
+// ownsVbucketsOnAll returns whether each of the nodes which are passed in holds
+// a copy of at least one vbucket.  Buckets with too few vbuckets to spread
+// across all of the nodes trivially do.
+func (b *bucketInst) ownsVbucketsOnAll(nodeList []string) bool {
+	if int(b.numVbuckets*(b.numReplicas+1)) < len(nodeList) {
+		return true
+	}
+
+	b.vbMapLock.Lock()
+	defer b.vbMapLock.Unlock()
+
+	owned := make(map[string]bool)
+	for _, vb := range b.vbMap {
+		for _, nodeID := range vb {
+			owned[nodeID] = true
+		}
+	}
+
+	for _, nodeID := range nodeList {
+		if !owned[nodeID] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetVbMap explicitly assigns the nodes holding each copy of each vbucket.
+func (b *bucketInst) SetVbMap(vbMap [][]string) error {
+	if uint(len(vbMap)) != b.numVbuckets {
+		return fmt.Errorf("expected %d vbuckets in vbmap, got %d", b.numVbuckets, len(vbMap))
+	}
+
+	numDataCopies := b.numReplicas + 1
+	newVbMap := make([][]string, len(vbMap))
+	for vbIdx, vb := range vbMap {
+		if uint(len(vb)) != numDataCopies {
+			return fmt.Errorf("expected %d copies of vbucket %d, got %d", numDataCopies, vbIdx, len(vb))
+		}
+
+		seenNodes := make(map[string]struct{})
+		for _, nodeID := range vb {
+			if nodeID == "" {
+				continue
+			}
+
+			isKvNode := false
+			for _, node := range b.cluster.nodes {
+				if node.ID() == nodeID && node.KvService() != nil {
+					isKvNode = true
+				}
+			}
+			if !isKvNode {
+				return fmt.Errorf("invalid kv node %s for vbucket %d", nodeID, vbIdx)
+			}
+
+			if _, ok := seenNodes[nodeID]; ok {
+				return fmt.Errorf("node %s holds multiple copies of vbucket %d", nodeID, vbIdx)
+			}
+			seenNodes[nodeID] = struct{}{}
+		}
+
+		newVbMap[vbIdx] = append([]string{}, vb...)
+	}
+
+	b.vbMapLock.Lock()
 	b.vbMap = newVbMap
+	b.evacuatedNodes = nil
+	b.vbMapLock.Unlock()
 
 	b.updateConfig()
+
+	// The vbmap is part of the cluster config, so config watchers need to be
+	// notified of the change.
+	b.cluster.updateConfig()
+	return nil
+}
+
+// FailoverNode removes a node from the vbmap, promoting the first available
+// replica of any vbucket the node was the master for.
+func (b *bucketInst) FailoverNode(nodeID string) {
+	b.vbMapLock.Lock()
+	for vbIdx, vb := range b.vbMap {
+		newVb := make([]string, 0, len(vb))
+		for repIdx, repNodeID := range vb {
+			if repNodeID == nodeID {
+				continue
+			}
+
+			if len(newVb) == 0 && repIdx > 0 && repNodeID != "" {
+				// This replica is becoming the master, so its copy of the data
+				// becomes the authoritative one.
+				err := b.store.PromoteReplica(uint(vbIdx), uint(repIdx))
+				if err != nil {
+					log.Printf("failed to promote replica %d of vbucket %d: %s", repIdx, vbIdx, err)
+				}
+			}
+
+			newVb = append(newVb, repNodeID)
+		}
+		for len(newVb) < len(vb) {
+			newVb = append(newVb, "")
+		}
+
+		b.vbMap[vbIdx] = newVb
+	}
+
+	b.removeEvacuatedNodeLocked(nodeID)
+	b.vbMapLock.Unlock()
+
+	b.updateConfig()
+}
+
+// EvacuateNode moves every copy of every vbucket held by a node onto the other
+// active kv nodes, leaving the bucket open on the node without any vbuckets.
+func (b *bucketInst) EvacuateNode(nodeID string) error {
+	if b.bucketType == mock.BucketTypeMemcached {
+		return errors.New("memcached buckets do not have vbuckets")
+	}
+
+	var otherNodeIDs []string
+	isKvNode := false
+	for _, node := range b.cluster.nodes {
+		if node.KvService() == nil {
+			continue
+		}
+		if node.ID() == nodeID {
+			isKvNode = true
+		} else if node.Membership() == mock.ClusterMembershipActive {
+			otherNodeIDs = append(otherNodeIDs, node.ID())
+		}
+	}
+	if !isKvNode {
+		return fmt.Errorf("invalid kv node %s", nodeID)
+	}
+	if len(otherNodeIDs) == 0 {
+		return errors.New("no other active kv node is able to take the vbuckets")
+	}
+
+	b.vbMapLock.Lock()
+	err := b.evacuateNodeVbucketsLocked(nodeID, otherNodeIDs)
+	b.vbMapLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	b.updateConfig()
+	b.cluster.updateConfig()
+	return nil
+}
+
+// evacuateNodeVbucketsLocked moves every copy of every vbucket held by a node
+// onto some other nodes, and marks the node as evacuated.
+// NOTE: This must be called with the lock of the vbmap held.
+func (b *bucketInst) evacuateNodeVbucketsLocked(nodeID string, otherNodeIDs []string) error {
+	newVbMap := make([][]string, len(b.vbMap))
+	for vbIdx, vb := range b.vbMap {
+		newVb := append([]string{}, vb...)
+		for repIdx, repNodeID := range newVb {
+			if repNodeID != nodeID {
+				continue
+			}
+
+			// Copies are spread over the other nodes, starting from a different
+			// node for each vbucket, skipping any which already hold a copy.
+			newVb[repIdx] = ""
+			for offset := range otherNodeIDs {
+				candidateID := otherNodeIDs[(vbIdx+offset)%len(otherNodeIDs)]
+				if !stringSliceContains(newVb, candidateID) {
+					newVb[repIdx] = candidateID
+					break
+				}
+			}
+
+			if repIdx == 0 && newVb[repIdx] == "" {
+				// When every other node already holds a replica, the first of
+				// them takes over as the master instead.
+				promoteIdx := -1
+				for candidateIdx := 1; candidateIdx < len(newVb); candidateIdx++ {
+					if newVb[candidateIdx] != "" {
+						promoteIdx = candidateIdx
+						break
+					}
+				}
+				if promoteIdx < 0 {
+					return fmt.Errorf("no other node is able to take vbucket %d", vbIdx)
+				}
+
+				if err := b.store.PromoteReplica(uint(vbIdx), uint(promoteIdx)); err != nil {
+					return err
+				}
+				newVb[0] = newVb[promoteIdx]
+				newVb[promoteIdx] = ""
+			}
+		}
+
+		newVbMap[vbIdx] = newVb
+	}
+
+	b.vbMap = newVbMap
+	if !stringSliceContains(b.evacuatedNodes, nodeID) {
+		b.evacuatedNodes = append(b.evacuatedNodes, nodeID)
+	}
+	return nil
+}
+
+// removeEvacuatedNodeLocked stops treating a node as evacuated.
+// NOTE: This must be called with the lock of the vbmap held.
+func (b *bucketInst) removeEvacuatedNodeLocked(nodeID string) {
+	var evacuatedNodes []string
+	for _, evacuatedID := range b.evacuatedNodes {
+		if evacuatedID != nodeID {
+			evacuatedNodes = append(evacuatedNodes, evacuatedID)
+		}
+	}
+	b.evacuatedNodes = evacuatedNodes
 }
 
 func (b *bucketInst) updateConfig() {
+	b.cluster.configRevLock.Lock()
 	b.configRev++
+	b.cluster.configRevLock.Unlock()
 }
 
 // GetVbServerInfo returns the vb nodes, then the vb map, then the ordered list of all nodes
@@ -160,6 +501,9 @@ func (b *bucketInst) GetVbServerInfo(reqNode mock.ClusterNode) ([]mock.ClusterNo
 
 	var nodeList uniqueClusterNodeList
 
+	b.vbMapLock.Lock()
+	defer b.vbMapLock.Unlock()
+
 	idxdVbMap := make([][]int, len(b.vbMap))
 	for vbIdx, repMap := range b.vbMap {
 		idxdVbMap[vbIdx] = make([]int, len(repMap))
@@ -168,6 +512,12 @@ func (b *bucketInst) GetVbServerInfo(reqNode mock.ClusterNode) ([]mock.ClusterNo
 		}
 	}
 
+	// Evacuated nodes still have the bucket open, so they remain part of the
+	// KV server list even though the vbmap no longer references them.
+	for _, nodeID := range b.evacuatedNodes {
+		nodeList.GetByID(allNodes, nodeID)
+	}
+
 	// Grab the KV server list before we add the remaining nodes.
 	kvNodes := []mock.ClusterNode(nodeList)
 
@@ -193,6 +543,9 @@ func (b *bucketInst) VbucketOwnership(node mock.ClusterNode) []int {
 		return -1
 	}
 
+	b.vbMapLock.Lock()
+	defer b.vbMapLock.Unlock()
+
 	vbOwnership := make([]int, len(b.vbMap))
 	for vbIdx, vb := range b.vbMap {
 		vbOwnership[vbIdx] = getRepIdx(vb)
@@ -200,6 +553,18 @@ func (b *bucketInst) VbucketOwnership(node mock.ClusterNode) []int {
 	return vbOwnership
 }
 
+func (b *bucketInst) DcpStreams() *mock.DcpStreamRegistry {
+	return b.dcpStreams
+}
+
+func (b *bucketInst) RangeScans() *mock.RangeScanRegistry {
+	return b.rangeScans
+}
+
+func (b *bucketInst) SyncWrites() *mock.SyncWriteRegistry {
+	return b.syncWrites
+}
+
 func (b *bucketInst) ViewIndexManager() mock.ViewIndexManager {
 	return b.viewEngine
 }
@@ -224,13 +589,85 @@ func (b *bucketInst) CompressionMode() mock.CompressionMode {
 	return b.compressionMode
 }
 
+func (b *bucketInst) EvictionPolicy() mock.EvictionPolicy {
+	return b.evictionPolicy
+}
+
+func (b *bucketInst) StorageBackend() mock.StorageBackend {
+	return b.storageBackend
+}
+
+func (b *bucketInst) ThrottleProperties() mock.ThrottleProperties {
+	return b.throttleProps
+}
+
+func (b *bucketInst) SetThrottleProperties(props mock.ThrottleProperties) {
+	b.throttleProps = props
+}
+
+func (b *bucketInst) ThrottleWarning(userName string) bool {
+	if _, ok := b.throttleWarnings.Load(""); ok {
+		return true
+	}
+	_, ok := b.throttleWarnings.Load(userName)
+	return ok
+}
+
+func (b *bucketInst) SetThrottleWarning(userName string, warn bool) {
+	if warn {
+		b.throttleWarnings.Store(userName, struct{}{})
+	} else {
+		b.throttleWarnings.Delete(userName)
+	}
+}
+
+func (b *bucketInst) DataLimitStatus() memd.StatusCode {
+	return b.dataLimitStatus
+}
+
+func (b *bucketInst) SetDataLimitStatus(status memd.StatusCode) {
+	b.dataLimitStatus = status
+}
+
+func (b *bucketInst) DocumentLimits() mock.DocumentLimits {
+	return b.docLimits
+}
+
+func (b *bucketInst) SetDocumentLimits(limits mock.DocumentLimits) {
+	b.docLimits = limits
+}
+
+func (b *bucketInst) EngineParam(name string) (string, bool) {
+	value, ok := b.engineParams.Load(name)
+	if !ok {
+		return "", false
+	}
+	return value.(string), true
+}
+
+func (b *bucketInst) SetEngineParam(name, value string) {
+	b.engineParams.Store(name, value)
+}
+
 func (b *bucketInst) Update(opts mock.UpdateBucketOptions) error {
 	b.ramQuota = opts.RamQuota
 	b.flushEnabled = opts.FlushEnabled
 	b.replicaIndexEnabled = opts.ReplicaIndexEnabled
 	b.numReplicas = opts.NumReplicas
+	if opts.EvictionPolicy != "" {
+		b.evictionPolicy = opts.EvictionPolicy
+	}
 
 	// TODO: When the store actually does something with num replicas we should probably update it here.
 
 	return nil
 }
+
+func stringSliceContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
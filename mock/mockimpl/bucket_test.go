@@ -0,0 +1,70 @@
+package mockimpl
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetVbMap(t *testing.T) {
+	cluster, err := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	if _, err := cluster.AddNode(mock.NewNodeOptions{}); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	bucket, err := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to add bucket: %v", err)
+	}
+	nodes := cluster.Nodes()
+	nodeA, nodeB := nodes[0].ID(), nodes[1].ID()
+
+	// Invalid vbmaps are rejected, leaving the existing vbmap in place.
+	ownership := bucket.VbucketOwnership(nodes[0])
+	for _, vbMap := range [][][]string{
+		{{nodeA, nodeB}, {nodeB, nodeA}, {nodeA, nodeB}},
+		{{nodeA, nodeB}, {nodeB, nodeA}, {nodeA, nodeB}, {nodeB}},
+		{{nodeA, nodeB}, {nodeB, nodeA}, {nodeA, nodeB}, {nodeB, nodeB}},
+		{{nodeA, nodeB}, {nodeB, nodeA}, {nodeA, nodeB}, {nodeB, "missing"}},
+	} {
+		assert.Error(t, bucket.SetVbMap(vbMap), "%v", vbMap)
+	}
+	assert.Equal(t, ownership, bucket.VbucketOwnership(nodes[0]))
+
+	// The vbmap can be read while it is being replaced.
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			bucket.VbucketOwnership(nodes[0])
+			bucket.GetVbServerInfo(nil)
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		err := bucket.SetVbMap([][]string{{nodeA, nodeB}, {nodeA, ""}, {nodeB, nodeA}, {nodeB, ""}})
+		assert.NoError(t, err)
+	}
+	close(stopCh)
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 0, 1, -1}, bucket.VbucketOwnership(nodes[0]))
+	assert.Equal(t, []int{1, -1, 0, 0}, bucket.VbucketOwnership(nodes[1]))
+}
@@ -0,0 +1,69 @@
+package mockimpl
+
+import "errors"
+
+// bucketCompaction tracks the compaction of a bucket, which progresses in
+// steps so that tooling which waits on compaction can be tested.
+type bucketCompaction struct {
+	steppedTask
+}
+
+func (b *bucketInst) StartCompaction() error {
+	b.compaction.lock.Lock()
+	if b.compaction.running {
+		b.compaction.lock.Unlock()
+		return errors.New("compaction already running")
+	}
+
+	if b.compaction.startLocked() {
+		b.compaction.lock.Unlock()
+		return nil
+	}
+	b.compaction.lock.Unlock()
+
+	b.store.PurgeTombstones()
+	return nil
+}
+
+func (b *bucketInst) CompactionProgress() (int, bool) {
+	b.compaction.lock.Lock()
+	defer b.compaction.lock.Unlock()
+
+	if !b.compaction.running {
+		return 0, false
+	}
+	return b.compaction.progressLocked(), true
+}
+
+func (b *bucketInst) CancelCompaction() error {
+	b.compaction.lock.Lock()
+	defer b.compaction.lock.Unlock()
+
+	// A cancelled compaction never purges any tombstones.
+	if !b.compaction.cancelLocked() {
+		return errors.New("no compaction running")
+	}
+	return nil
+}
+
+func (b *bucketInst) SetCompactionSteps(steps int) {
+	b.compaction.setSteps(steps)
+}
+
+func (b *bucketInst) StepCompaction() error {
+	b.compaction.lock.Lock()
+	if !b.compaction.running {
+		b.compaction.lock.Unlock()
+		return errors.New("no compaction running")
+	}
+
+	if !b.compaction.stepLocked() {
+		b.compaction.lock.Unlock()
+		return nil
+	}
+	b.compaction.lock.Unlock()
+
+	// Tombstones are only purged once the compaction has completed.
+	b.store.PurgeTombstones()
+	return nil
+}
@@ -0,0 +1,87 @@
+package mockimpl
+
+import (
+	"errors"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// bucketPause tracks the pausing and resuming of a bucket.  Like compactions,
+// each transition progresses in steps, and the bucket is in the pausing or
+// resuming state while a transition runs.
+type bucketPause struct {
+	steppedTask
+	state mock.BucketPauseState
+}
+
+func (b *bucketInst) PauseState() mock.BucketPauseState {
+	b.pause.lock.Lock()
+	defer b.pause.lock.Unlock()
+
+	return b.pause.state
+}
+
+func (b *bucketInst) PauseProgress() (int, bool) {
+	b.pause.lock.Lock()
+	defer b.pause.lock.Unlock()
+
+	if b.pause.state != mock.BucketPauseStatePausing && b.pause.state != mock.BucketPauseStateResuming {
+		return 0, false
+	}
+	return b.pause.progressLocked(), true
+}
+
+func (b *bucketInst) StartPause() error {
+	return b.startPauseTransition(mock.BucketPauseStateRunning, mock.BucketPauseStatePausing, mock.BucketPauseStatePaused)
+}
+
+func (b *bucketInst) StartResume() error {
+	return b.startPauseTransition(mock.BucketPauseStatePaused, mock.BucketPauseStateResuming, mock.BucketPauseStateRunning)
+}
+
+func (b *bucketInst) startPauseTransition(fromState, transitionState, toState mock.BucketPauseState) error {
+	b.pause.lock.Lock()
+	if b.pause.state != fromState {
+		state := b.pause.state
+		b.pause.lock.Unlock()
+		return errors.New("bucket is " + string(state))
+	}
+
+	if b.pause.startLocked() {
+		b.pause.state = transitionState
+	} else {
+		b.pause.state = toState
+	}
+	b.pause.lock.Unlock()
+
+	b.updateConfig()
+	return nil
+}
+
+func (b *bucketInst) SetPauseSteps(steps int) {
+	b.pause.setSteps(steps)
+}
+
+func (b *bucketInst) StepPause() error {
+	b.pause.lock.Lock()
+	var toState mock.BucketPauseState
+	switch b.pause.state {
+	case mock.BucketPauseStatePausing:
+		toState = mock.BucketPauseStatePaused
+	case mock.BucketPauseStateResuming:
+		toState = mock.BucketPauseStateRunning
+	default:
+		b.pause.lock.Unlock()
+		return errors.New("no pause or resume running")
+	}
+
+	if !b.pause.stepLocked() {
+		b.pause.lock.Unlock()
+		return nil
+	}
+	b.pause.state = toState
+	b.pause.lock.Unlock()
+
+	b.updateConfig()
+	return nil
+}
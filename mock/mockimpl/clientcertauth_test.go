@@ -0,0 +1,227 @@
+package mockimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// testClientCerts holds a CA along with certificates it has issued to clients.
+type testClientCerts struct {
+	t      *testing.T
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPem  []byte
+}
+
+func newTestClientCerts(t *testing.T) *testClientCerts {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	return &testClientCerts{
+		t:      t,
+		caCert: caCert,
+		caKey:  caKey,
+		caPem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+	}
+}
+
+// issue creates a client certificate with a specific subject common name.
+func (c *testClientCerts) issue(commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		c.t.Fatalf("failed to generate client key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.caCert, &key.PublicKey, c.caKey)
+	if err != nil {
+		c.t.Fatalf("failed to create client certificate: %v", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// testClientCertTLSConfig returns a TLS config which presents a client
+// certificate, if one is given.
+func testClientCertTLSConfig(cert *tls.Certificate) *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config
+}
+
+// testCertMgmtStatus sends a request over TLS to the mgmt service of a node,
+// optionally with the credentials of the Administrator, and returns its status
+// code, or 0 if the request failed outright.
+func testCertMgmtStatus(node mock.ClusterNode, cert *tls.Certificate, withCreds bool) int {
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: testClientCertTLSConfig(cert)},
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://127.0.0.1:%d/settings/audit", node.MgmtService().ListenPortTLS()), nil)
+	if err != nil {
+		return 0
+	}
+	if withCreds {
+		req.SetBasicAuth("Administrator", "password")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// testCertKvSelectBucket connects over TLS to the kv service of a node and
+// selects a bucket without authenticating with credentials.  It returns the
+// status of the response, or false if the connection was closed instead.
+func testCertKvSelectBucket(t *testing.T, node mock.ClusterNode, cert *tls.Certificate) (memd.StatusCode, bool) {
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", node.KvService().ListenPortTLS()), testClientCertTLSConfig(cert))
+	if err != nil {
+		return 0, false
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &testKvConn{
+		t:     t,
+		conn:  conn,
+		mconn: memd.NewConn(conn),
+	}
+	defer c.Close()
+
+	c.send(&memd.Packet{
+		Command: memd.CmdSelectBucket,
+		Key:     []byte("default"),
+	})
+	resp, _, err := c.mconn.ReadPacket()
+	if err != nil {
+		return 0, false
+	}
+	return resp.Status, true
+}
+
+func TestClientCertAuth(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	certs := newTestClientCerts(t)
+	if err := cluster.AddTrustedCA(certs.caPem); err != nil {
+		t.Fatalf("failed to add trusted CA: %v", err)
+	}
+	mappedCert := certs.issue("user-Administrator")
+	unmappedCert := certs.issue("Administrator")
+
+	prefixes := []mock.ClientCertAuthPrefix{
+		{Path: mock.ClientCertAuthPathSubjectCN, Prefix: "user-"},
+	}
+
+	t.Run("disable", func(t *testing.T) {
+		cluster.SetClientCertAuthSettings(mock.ClientCertAuthSettings{
+			State:    mock.ClientCertAuthStateDisable,
+			Prefixes: prefixes,
+		})
+
+		// Certificates are ignored, so credentials are always needed.
+		assert.Equal(t, 401, testCertMgmtStatus(node, &mappedCert, false))
+		assert.Equal(t, 200, testCertMgmtStatus(node, &mappedCert, true))
+		assert.Equal(t, 200, testCertMgmtStatus(node, nil, true))
+
+		status, ok := testCertKvSelectBucket(t, node, &mappedCert)
+		assert.True(t, ok)
+		assert.NotEqual(t, memd.StatusSuccess, status)
+	})
+
+	t.Run("enable", func(t *testing.T) {
+		cluster.SetClientCertAuthSettings(mock.ClientCertAuthSettings{
+			State:    mock.ClientCertAuthStateEnable,
+			Prefixes: prefixes,
+		})
+
+		// A mapped certificate authenticates its user, while anything else
+		// falls back to credentials.
+		assert.Equal(t, 200, testCertMgmtStatus(node, &mappedCert, false))
+		assert.Equal(t, 401, testCertMgmtStatus(node, &unmappedCert, false))
+		assert.Equal(t, 200, testCertMgmtStatus(node, &unmappedCert, true))
+		assert.Equal(t, 200, testCertMgmtStatus(node, nil, true))
+
+		status, ok := testCertKvSelectBucket(t, node, &mappedCert)
+		assert.True(t, ok)
+		assert.Equal(t, memd.StatusSuccess, status)
+
+		status, ok = testCertKvSelectBucket(t, node, &unmappedCert)
+		assert.True(t, ok)
+		assert.NotEqual(t, memd.StatusSuccess, status)
+	})
+
+	t.Run("mandatory", func(t *testing.T) {
+		cluster.SetClientCertAuthSettings(mock.ClientCertAuthSettings{
+			State:    mock.ClientCertAuthStateMandatory,
+			Prefixes: prefixes,
+		})
+
+		// Only a certificate which maps to a user is accepted, regardless of
+		// any credentials.
+		assert.Equal(t, 200, testCertMgmtStatus(node, &mappedCert, false))
+		assert.Equal(t, 401, testCertMgmtStatus(node, &unmappedCert, true))
+		assert.Equal(t, 0, testCertMgmtStatus(node, nil, true))
+
+		status, ok := testCertKvSelectBucket(t, node, &mappedCert)
+		assert.True(t, ok)
+		assert.Equal(t, memd.StatusSuccess, status)
+
+		_, ok = testCertKvSelectBucket(t, node, &unmappedCert)
+		assert.False(t, ok)
+		_, ok = testCertKvSelectBucket(t, node, nil)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,64 @@
+package mockimpl
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxBucketCount(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+
+	// The default cluster already has two buckets, so only two more fit, no
+	// matter how many are created at once.
+	cluster.SetMaxBucketCount(4)
+	assert.Equal(t, 4, cluster.MaxBucketCount())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for bucketIdx := range errs {
+		wg.Add(1)
+		go func(bucketIdx int) {
+			defer wg.Done()
+			_, errs[bucketIdx] = cluster.AddBucket(mock.NewBucketOptions{
+				Name: fmt.Sprintf("bucket-%d", bucketIdx),
+				Type: mock.BucketTypeCouchbase,
+			})
+		}(bucketIdx)
+	}
+	wg.Wait()
+
+	numAdded := 0
+	for _, err := range errs {
+		if err == nil {
+			numAdded++
+		} else {
+			assert.Equal(t, mock.ErrTooManyBuckets, err)
+		}
+	}
+	assert.Equal(t, 2, numAdded)
+	assert.Len(t, cluster.GetAllBuckets(), 4)
+
+	// The management endpoint reports the limit.
+	status, body := doTestHTTP(t, "POST", mgmtURL+"/pools/default/buckets", url.Values{
+		"name":       {"rejected"},
+		"bucketType": {"couchbase"},
+		"ramQuotaMB": {"100"},
+	})
+	assert.Equal(t, 400, status)
+	assert.Contains(t, string(body), "Cannot create more than 4 buckets")
+	assert.Nil(t, cluster.GetBucket("rejected"))
+
+	// Lowering the limit keeps the existing buckets.
+	cluster.SetMaxBucketCount(1)
+	assert.Len(t, cluster.GetAllBuckets(), 4)
+}
@@ -0,0 +1,72 @@
+package mockimpl
+
+import "errors"
+
+// clusterGracefulFailover tracks the graceful failover of a node.  The node is
+// drained in steps, so that tooling and SDK behaviour during the drain can be
+// tested.  The node is only failed over once draining has completed.
+type clusterGracefulFailover struct {
+	steppedTask
+
+	// nodeID is the node which the running failover is draining.
+	nodeID string
+}
+
+func (c *clusterInst) StartGracefulFailover(nodeID string) error {
+	found := false
+	for _, node := range c.nodes {
+		if node.ID() == nodeID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("node not found")
+	}
+
+	c.gracefulFailover.lock.Lock()
+	if c.gracefulFailover.running {
+		c.gracefulFailover.lock.Unlock()
+		return errors.New("graceful failover already running")
+	}
+
+	if c.gracefulFailover.startLocked() {
+		c.gracefulFailover.nodeID = nodeID
+		c.gracefulFailover.lock.Unlock()
+		return nil
+	}
+	c.gracefulFailover.lock.Unlock()
+
+	return c.FailoverNode(nodeID)
+}
+
+func (c *clusterInst) GracefulFailoverProgress() (string, int, bool) {
+	c.gracefulFailover.lock.Lock()
+	defer c.gracefulFailover.lock.Unlock()
+
+	if !c.gracefulFailover.running {
+		return "", 0, false
+	}
+	return c.gracefulFailover.nodeID, c.gracefulFailover.progressLocked(), true
+}
+
+func (c *clusterInst) SetGracefulFailoverSteps(steps int) {
+	c.gracefulFailover.setSteps(steps)
+}
+
+func (c *clusterInst) StepGracefulFailover() error {
+	c.gracefulFailover.lock.Lock()
+	if !c.gracefulFailover.running {
+		c.gracefulFailover.lock.Unlock()
+		return errors.New("no graceful failover running")
+	}
+
+	if !c.gracefulFailover.stepLocked() {
+		c.gracefulFailover.lock.Unlock()
+		return nil
+	}
+	nodeID := c.gracefulFailover.nodeID
+	c.gracefulFailover.lock.Unlock()
+
+	// The node only leaves the vbucket map once it has been fully drained.
+	return c.FailoverNode(nodeID)
+}
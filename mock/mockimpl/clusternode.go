@@ -1,10 +1,15 @@
 package mockimpl
 
 import (
+	"errors"
 	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/couchbaselabs/gocaves/mock"
-	"github.com/google/uuid"
 )
 
 // clusterNodeInst specifies a node within a cluster instance.
@@ -13,7 +18,22 @@ type clusterNodeInst struct {
 	enabledFeatures []mock.ClusterNodeFeature
 	id              string
 	errMap          *mock.ErrorMap
-	hostname        string
+	serverGroup     string
+	membership      mock.ClusterMembership
+
+	// hostname is the address which this node advertises, which can be
+	// changed while clients are reading it.
+	hostnameLock sync.Mutex
+	hostname     string
+
+	pauseLock sync.Mutex
+	pausedCh  chan struct{}
+
+	// clockSkew is the skew of the clock of this node, in nanoseconds.  It is
+	// accessed atomically.
+	clockSkew int64
+
+	memPressure nodeMemoryPressure
 
 	kvService        *kvService
 	mgmtService      *mgmtService
@@ -37,11 +57,34 @@ func newClusterNode(parent *clusterInst, opts mock.NewNodeOptions) (*clusterNode
 		return nil, err
 	}
 
+	// Every node runs the cluster manager, which the configs are generated
+	// from, even when it runs none of the other services.
+	if !serviceTypeListContains(opts.Services, mock.ServiceTypeMgmt) {
+		return nil, errors.New("nodes must run the mgmt service")
+	}
+
+	if opts.UUID == "" {
+		opts.UUID = newUUID()
+	}
+	if opts.ServerGroup == "" {
+		opts.ServerGroup = "Group 1"
+	}
+	if opts.Hostname == "" {
+		opts.Hostname = "127.0.0.1"
+	}
+
+	hostname, err := normalizeHostname(opts.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
 	node := &clusterNodeInst{
-		id:              uuid.New().String(),
+		id:              opts.UUID,
 		enabledFeatures: opts.Features,
 		cluster:         parent,
-		hostname:        "127.0.0.1",
+		hostname:        hostname,
+		serverGroup:     opts.ServerGroup,
+		membership:      mock.ClusterMembershipActive,
 	}
 
 	node.errMap, err = mock.NewErrorMap()
@@ -52,7 +95,13 @@ func newClusterNode(parent *clusterInst, opts mock.NewNodeOptions) (*clusterNode
 	}
 
 	if serviceTypeListContains(opts.Services, mock.ServiceTypeKeyValue) {
-		kvService, err := newKvService(node, newKvServiceOptions{})
+		kvService, err := newKvService(node, newKvServiceOptions{
+			IdleTimeout:        opts.KvIdleTimeout,
+			WriteFragmentSize:  opts.KvWriteFragmentSize,
+			WriteBufferSize:    opts.KvWriteBufferSize,
+			WriteFlushInterval: opts.KvWriteFlushInterval,
+			LatencySeed:        opts.KvLatencySeed,
+		})
 		if err != nil {
 			log.Printf("cluster node failed to start kv service: %s", err)
 			node.cleanup()
@@ -106,7 +155,9 @@ func newClusterNode(parent *clusterInst, opts mock.NewNodeOptions) (*clusterNode
 		node.searchService = searchService
 	}
 
-	if serviceTypeListContains(opts.Services, mock.ServiceTypeAnalytics) {
+	// Analytics is only available in the enterprise edition.
+	if serviceTypeListContains(opts.Services, mock.ServiceTypeAnalytics) &&
+		parent.edition != mock.ClusterEditionCommunity {
 		analyticsService, err := newAnalyticsService(node, newAnalyticsServiceOptions{})
 		if err != nil {
 			log.Printf("cluster node failed to start analytics service: %s", err)
@@ -189,11 +240,145 @@ func (n *clusterNodeInst) ErrorMap() *mock.ErrorMap {
 	return n.errMap
 }
 
+// normalizeHostname validates a hostname which a node is to advertise, removing
+// the brackets from IPv6 addresses so that they can be added back wherever the
+// hostname is joined with a port.
+func normalizeHostname(hostname string) (string, error) {
+	if strings.HasPrefix(hostname, "[") && strings.HasSuffix(hostname, "]") {
+		hostname = hostname[1 : len(hostname)-1]
+	}
+
+	if hostname == "" || strings.ContainsAny(hostname, "[]/ ") {
+		return "", errors.New("invalid hostname")
+	}
+
+	// Anything with a colon must be an IPv6 address, as a port is not allowed.
+	if strings.Contains(hostname, ":") {
+		addr := hostname
+		if zoneIdx := strings.IndexByte(addr, '%'); zoneIdx >= 0 {
+			if zoneIdx == len(addr)-1 {
+				return "", errors.New("invalid hostname")
+			}
+			addr = addr[:zoneIdx]
+		}
+
+		if net.ParseIP(addr) == nil {
+			return "", errors.New("invalid hostname")
+		}
+	}
+
+	return hostname, nil
+}
+
 func (n *clusterNodeInst) Hostname() string {
+	n.hostnameLock.Lock()
+	defer n.hostnameLock.Unlock()
+
 	return n.hostname
 }
 
+// ChangeAddress changes the hostname which this node advertises and moves
+// each of its services to new ports.
+func (n *clusterNodeInst) ChangeAddress(hostname string) error {
+	hostname, err := normalizeHostname(hostname)
+	if err != nil {
+		return err
+	}
+
+	n.hostnameLock.Lock()
+	n.hostname = hostname
+	n.hostnameLock.Unlock()
+
+	// The services are checked individually as nil pointers would not
+	// compare equal to nil once stored in an interface.
+	var rebindErr error
+	rebind := func(rebindFn func() error) {
+		if err := rebindFn(); err != nil && rebindErr == nil {
+			rebindErr = err
+		}
+	}
+	if n.kvService != nil {
+		rebind(n.kvService.rebind)
+	}
+	if n.mgmtService != nil {
+		rebind(n.mgmtService.rebind)
+	}
+	if n.viewService != nil {
+		rebind(n.viewService.rebind)
+	}
+	if n.queryService != nil {
+		rebind(n.queryService.rebind)
+	}
+	if n.searchService != nil {
+		rebind(n.searchService.rebind)
+	}
+	if n.analyticsService != nil {
+		rebind(n.analyticsService.rebind)
+	}
+
+	// Clients only learn of the new address through a newer config.
+	n.cluster.BumpConfigRev()
+	return rebindErr
+}
+
+// ServerGroup returns the name of the server group this node belongs to.
+func (n *clusterNodeInst) ServerGroup() string {
+	return n.serverGroup
+}
+
+// Membership returns the state of the membership of this node within its
+// cluster.
+func (n *clusterNodeInst) Membership() mock.ClusterMembership {
+	return n.membership
+}
+
+// ClockSkew returns how far the clock of this node is skewed from the cluster
+// time.
+func (n *clusterNodeInst) ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.clockSkew))
+}
+
+// SetClockSkew sets how far the clock of this node is skewed from the cluster
+// time, which skews the CAS of the mutations which it performs.
+func (n *clusterNodeInst) SetClockSkew(skew time.Duration) {
+	atomic.StoreInt64(&n.clockSkew, int64(skew))
+}
+
+// Pause stops this node from processing any further requests until Resume is
+// called.
+func (n *clusterNodeInst) Pause() {
+	n.pauseLock.Lock()
+	if n.pausedCh == nil {
+		n.pausedCh = make(chan struct{})
+	}
+	n.pauseLock.Unlock()
+}
+
+// Resume releases all of the requests held while this node was paused.
+func (n *clusterNodeInst) Resume() {
+	n.pauseLock.Lock()
+	if n.pausedCh != nil {
+		close(n.pausedCh)
+		n.pausedCh = nil
+	}
+	n.pauseLock.Unlock()
+}
+
+// waitIfPaused blocks the processing of a request while this node is paused.
+func (n *clusterNodeInst) waitIfPaused() {
+	n.pauseLock.Lock()
+	pausedCh := n.pausedCh
+	n.pauseLock.Unlock()
+
+	if pausedCh != nil {
+		<-pausedCh
+	}
+}
+
 func (n *clusterNodeInst) cleanup() {
+	// Nothing should be left waiting on a node which is being shut down.
+	n.Resume()
+
 	if n.kvService != nil {
 		n.kvService.Close()
 		n.kvService = nil
@@ -0,0 +1,50 @@
+package mockimpl
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeAddress(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	oldKvPort := node.KvService().ListenPort()
+	oldMgmtPort := node.MgmtService().ListenPort()
+	oldRev := cluster.ConfigRev()
+
+	// Clients may read the address of the node while it changes.
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for i := 0; i < 100; i++ {
+			_ = node.KvService().Hostname()
+		}
+	}()
+
+	err = node.ChangeAddress("localhost")
+	<-doneCh
+	if err != nil {
+		t.Fatalf("failed to change address: %v", err)
+	}
+
+	assert.Equal(t, "localhost", node.Hostname())
+	assert.NotEqual(t, oldKvPort, node.KvService().ListenPort())
+	assert.NotEqual(t, oldMgmtPort, node.MgmtService().ListenPort())
+	assert.Greater(t, cluster.ConfigRev(), oldRev)
+
+	// The services are only reachable at their new ports.
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(oldKvPort)))
+	assert.Error(t, err)
+	conn := dialTestKv(t, node)
+	conn.Close()
+
+	err = node.ChangeAddress("not a hostname")
+	assert.Error(t, err)
+	assert.Equal(t, "localhost", node.Hostname())
+}
@@ -0,0 +1,60 @@
+package mockimpl
+
+import (
+	"sync"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// nodeMemoryPressure simulates how much of the memory quota of its buckets a
+// node is using.  Temporary failures are spread evenly across writes, so that
+// exactly the expected fraction of them fail rather than a random sample.
+type nodeMemoryPressure struct {
+	lock    sync.Mutex
+	percent int
+
+	// tmpFailCredit accumulates the percentage of each write which should
+	// fail, a write is failed each time it reaches 100.
+	tmpFailCredit int
+}
+
+// MemoryPressure returns the percentage of the memory quota of its buckets
+// which this node is simulating is in use.
+func (n *clusterNodeInst) MemoryPressure() int {
+	n.memPressure.lock.Lock()
+	defer n.memPressure.lock.Unlock()
+	return n.memPressure.percent
+}
+
+// SetMemoryPressure sets the percentage of the memory quota of its buckets
+// which this node simulates is in use.
+func (n *clusterNodeInst) SetMemoryPressure(percent int) {
+	n.memPressure.lock.Lock()
+	n.memPressure.percent = percent
+	n.memPressure.tmpFailCredit = 0
+	n.memPressure.lock.Unlock()
+}
+
+// MemoryPressureStatus returns the status which the next write to this node
+// fails with because of memory pressure.  Between the high watermark and the
+// quota, the fraction of writes which temporarily fail grows from none to all.
+func (n *clusterNodeInst) MemoryPressureStatus(canEvict bool) memd.StatusCode {
+	n.memPressure.lock.Lock()
+	defer n.memPressure.lock.Unlock()
+
+	percent := n.memPressure.percent
+	if percent <= mock.MemoryHighWatermark {
+		return memd.StatusSuccess
+	} else if percent >= 100 || !canEvict {
+		return memd.StatusOutOfMemory
+	}
+
+	n.memPressure.tmpFailCredit += (percent - mock.MemoryHighWatermark) * 100 / (100 - mock.MemoryHighWatermark)
+	if n.memPressure.tmpFailCredit >= 100 {
+		n.memPressure.tmpFailCredit -= 100
+		return memd.StatusTmpFail
+	}
+
+	return memd.StatusSuccess
+}
@@ -0,0 +1,471 @@
+package mockimpl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDcp opens a producer DCP connection on a kv connection, applying a
+// set of DCP_CONTROL options to it.
+func openTestDcp(t *testing.T, conn *testKvConn, controls map[string]string) {
+	openTestDcpWithFlags(t, conn, memd.DcpOpenFlagProducer, controls)
+}
+
+// openTestDcpWithFlags opens a DCP connection with specific DCP_OPEN flags,
+// then applies some DCP_CONTROL options to it.
+func openTestDcpWithFlags(t *testing.T, conn *testKvConn, flags memd.DcpOpenFlag, controls map[string]string) {
+	openExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(openExtras[4:], uint32(flags))
+	resp := conn.roundTrip(&memd.Packet{
+		Command: memd.CmdDcpOpenConnection,
+		Key:     []byte("test"),
+		Extras:  openExtras,
+	})
+	if resp.Status != memd.StatusSuccess {
+		t.Fatalf("failed to open dcp: %v", resp.Status)
+	}
+
+	for key, value := range controls {
+		resp = conn.roundTrip(&memd.Packet{
+			Command: memd.CmdDcpControl,
+			Key:     []byte(key),
+			Value:   []byte(value),
+		})
+		if resp.Status != memd.StatusSuccess {
+			t.Fatalf("failed to set dcp control %s: %v", key, resp.Status)
+		}
+	}
+}
+
+// testStreamReqPacket builds a request for a stream of a vbucket from a
+// seqno, whose snapshot is the seqno itself.
+func testStreamReqPacket(vbID uint16, vbUUID, startSeqNo, endSeqNo uint64) *memd.Packet {
+	extras := make([]byte, 48)
+	binary.BigEndian.PutUint64(extras[8:], startSeqNo)
+	binary.BigEndian.PutUint64(extras[16:], endSeqNo)
+	binary.BigEndian.PutUint64(extras[24:], vbUUID)
+	binary.BigEndian.PutUint64(extras[32:], startSeqNo)
+	binary.BigEndian.PutUint64(extras[40:], startSeqNo)
+	return &memd.Packet{
+		Command: memd.CmdDcpStreamReq,
+		Vbucket: vbID,
+		Extras:  extras,
+	}
+}
+
+// readUntilIdle reads every packet sent by the server until it has sent
+// nothing for a while.
+func (c *testKvConn) readUntilIdle(idle time.Duration) []*memd.Packet {
+	var paks []*memd.Packet
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(idle))
+		pak, _, err := c.mconn.ReadPacket()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			_ = c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			return paks
+		} else if err != nil {
+			c.t.Fatalf("failed to read packet: %v", err)
+		}
+		paks = append(paks, pak)
+	}
+}
+
+// testLoadVbucket stores a number of documents to a vbucket, whose keys begin
+// with a prefix.
+func testLoadVbucket(t *testing.T, bucket mock.Bucket, vbID uint16, prefix string, numDocs int) {
+	for docIdx := 0; docIdx < numDocs; docIdx++ {
+		_, err := bucket.Store().Insert(&mockdb.Document{
+			VbID:  uint(vbID),
+			Key:   []byte(fmt.Sprintf("%s-%d", prefix, docIdx)),
+			Value: []byte(`{"x":1}`),
+			Cas:   mockdb.GenerateNewCas(bucket.Store().Chrono().Now()),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+	}
+}
+
+func TestDcpCloseStreamEndsAtSnapshotBoundary(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	testLoadVbucket(t, bucket, vbID, "key", 200)
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+	openTestDcp(t, conn, map[string]string{
+		"send_stream_end_on_client_close_stream": "true",
+	})
+
+	// The stream is closed while its disk snapshot is still being sent.
+	streamOpaque := conn.send(testStreamReqPacket(vbID, 0, 0, math.MaxUint64))
+	closeOpaque := conn.send(&memd.Packet{Command: memd.CmdDcpCloseStream, Vbucket: vbID})
+
+	var pendingItems int
+	var streamEnds []*memd.Packet
+	for _, pak := range conn.readUntilIdle(200 * time.Millisecond) {
+		if pak.Magic == memd.CmdMagicRes {
+			if pak.Opaque != streamOpaque && pak.Opaque != closeOpaque {
+				t.Fatalf("unexpected response to opaque %d", pak.Opaque)
+			}
+			assert.Equal(t, memd.StatusSuccess, pak.Status)
+			continue
+		}
+
+		if pak.Opaque != streamOpaque {
+			t.Fatalf("unexpected stream packet for opaque %d", pak.Opaque)
+		}
+		if len(streamEnds) > 0 {
+			t.Fatalf("stream was sent %s after its stream end", pak.Command.Name())
+		}
+
+		switch pak.Command {
+		case memd.CmdDcpSnapshotMarker:
+			assert.Equal(t, 0, pendingItems)
+			startSeqNo := binary.BigEndian.Uint64(pak.Extras[0:])
+			endSeqNo := binary.BigEndian.Uint64(pak.Extras[8:])
+			pendingItems = int(endSeqNo - startSeqNo + 1)
+		case memd.CmdDcpMutation:
+			pendingItems--
+		case memd.CmdDcpStreamEnd:
+			streamEnds = append(streamEnds, pak)
+		default:
+			t.Fatalf("unexpected stream packet %s", pak.Command.Name())
+		}
+	}
+
+	// A snapshot which had begun is always finished before the stream ends.
+	assert.Equal(t, 0, pendingItems)
+	if len(streamEnds) != 1 {
+		t.Fatalf("expected a single stream end, got %d", len(streamEnds))
+	}
+	assert.Equal(t, memd.StreamEndClosed, memd.StreamEndStatus(binary.BigEndian.Uint32(streamEnds[0].Extras)))
+
+	// Later mutations are not streamed once the stream has ended.
+	testLoadVbucket(t, bucket, vbID, "later", 1)
+	assert.Empty(t, conn.readUntilIdle(100*time.Millisecond))
+}
+
+func TestDcpDeletedBucket(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	vbID := testActiveVbucket(t, cluster.GetBucket("default"), node)
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+	openTestDcp(t, conn, nil)
+
+	if err := cluster.DeleteBucket("default"); err != nil {
+		t.Fatalf("failed to delete bucket: %v", err)
+	}
+
+	// Requests on a connection whose bucket has gone report that there is no
+	// bucket, rather than that their arguments are invalid.
+	resp := conn.roundTrip(testStreamReqPacket(vbID, 0, 0, math.MaxUint64))
+	assert.Equal(t, memd.StatusNoBucket, resp.Status)
+
+	// Seqno acknowledgements have no command code in the client library.
+	resp = conn.roundTrip(&memd.Packet{
+		Command: memd.CmdCode(0x69),
+		Vbucket: vbID,
+		Extras:  make([]byte, 8),
+	})
+	assert.Equal(t, memd.StatusNoBucket, resp.Status)
+}
+
+func TestDcpRollbackAfterDiscard(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	testLoadVbucket(t, bucket, vbID, "key", 10)
+
+	vbucket := bucket.Store().GetVbucket(uint(vbID))
+	oldVbUUID := vbucket.FailoverLog()[0].VbUUID
+	oldMaxSeqNo := vbucket.MaxSeqNo()
+
+	if err := bucket.Store().DiscardMutationsAfter(uint(vbID), oldMaxSeqNo-5); err != nil {
+		t.Fatalf("failed to discard mutations: %v", err)
+	}
+	assert.Equal(t, oldMaxSeqNo-5, vbucket.MaxSeqNo())
+	failoverLog := vbucket.FailoverLog()
+	assert.Len(t, failoverLog, 2)
+	assert.NotEqual(t, oldVbUUID, failoverLog[len(failoverLog)-1].VbUUID)
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+	openTestDcp(t, conn, nil)
+
+	// A consumer which had seen the discarded mutations must rollback to
+	// where the old branch of the history ended.
+	resp := conn.roundTrip(testStreamReqPacket(vbID, oldVbUUID, oldMaxSeqNo, math.MaxUint64))
+	assert.Equal(t, memd.StatusRollback, resp.Status)
+	if assert.Len(t, resp.Value, 8) {
+		assert.Equal(t, oldMaxSeqNo-5, binary.BigEndian.Uint64(resp.Value))
+	}
+
+	// A consumer which had not got that far can stream on without one.
+	resp = conn.roundTrip(testStreamReqPacket(vbID, oldVbUUID, oldMaxSeqNo-5, math.MaxUint64))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
+
+func TestDcpDeletedUserXattrs(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+
+	_, err = bucket.Store().Insert(&mockdb.Document{
+		VbID:      uint(vbID),
+		Key:       []byte("tombstone"),
+		IsDeleted: true,
+		Xattrs: map[string][]byte{
+			"_sys": []byte(`{"s":1}`),
+			"user": []byte(`{"u":1}`),
+		},
+		Cas: mockdb.GenerateNewCas(bucket.Store().Chrono().Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert tombstone: %v", err)
+	}
+
+	streamDeletion := func(flags memd.DcpOpenFlag) *memd.Packet {
+		conn := dialTestKvBucket(t, node, "default")
+		defer conn.Close()
+		openTestDcpWithFlags(t, conn, flags, nil)
+
+		conn.send(testStreamReqPacket(vbID, 0, 0, math.MaxUint64))
+		for _, pak := range conn.readUntilIdle(200 * time.Millisecond) {
+			if pak.Command == memd.CmdDcpDeletion {
+				return pak
+			}
+		}
+		t.Fatalf("tombstone was not streamed")
+		return nil
+	}
+
+	// Tombstones only carry their system xattrs by default.
+	pak := streamDeletion(memd.DcpOpenFlagProducer | memd.DcpOpenFlagIncludeXattrs)
+	assert.Contains(t, string(pak.Value), "_sys")
+	assert.NotContains(t, string(pak.Value), "user")
+
+	pak = streamDeletion(memd.DcpOpenFlagProducer | memd.DcpOpenFlagIncludeXattrs | memd.DcpOpenFlag(0x100))
+	assert.Contains(t, string(pak.Value), "_sys")
+	assert.Contains(t, string(pak.Value), "user")
+
+	// The bit below it requests point in time recovery, which we do not support.
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+	openExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(openExtras[4:], uint32(memd.DcpOpenFlagProducer|memd.DcpOpenFlag(0x80)))
+	resp := conn.roundTrip(&memd.Packet{
+		Command: memd.CmdDcpOpenConnection,
+		Key:     []byte("test"),
+		Extras:  openExtras,
+	})
+	assert.Equal(t, memd.StatusInvalidArgs, resp.Status)
+}
+
+func TestDcpNoops(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+
+	openTestDcp(t, conn, nil)
+
+	for _, control := range []struct{ key, value string }{
+		{"set_noop_interval", "0"},
+		{"enable_noop", "maybe"},
+	} {
+		resp := conn.roundTrip(&memd.Packet{
+			Command: memd.CmdDcpControl,
+			Key:     []byte(control.key),
+			Value:   []byte(control.value),
+		})
+		assert.Equal(t, memd.StatusInvalidArgs, resp.Status, control.key)
+	}
+
+	// Nothing is sent until noops are enabled.
+	resp := conn.roundTrip(&memd.Packet{
+		Command: memd.CmdDcpControl,
+		Key:     []byte("set_noop_interval"),
+		Value:   []byte("1"),
+	})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	assert.Empty(t, conn.readUntilIdle(1500*time.Millisecond))
+
+	resp = conn.roundTrip(&memd.Packet{
+		Command: memd.CmdDcpControl,
+		Key:     []byte("enable_noop"),
+		Value:   []byte("true"),
+	})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+
+	noop := conn.read()
+	assert.Equal(t, memd.CmdMagicReq, noop.Magic)
+	assert.Equal(t, memd.CmdDcpNoop, noop.Command)
+
+	// Responding to a noop does not draw any response in return.
+	if err := conn.mconn.WritePacket(&memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: memd.CmdDcpNoop,
+		Opaque:  noop.Opaque,
+	}); err != nil {
+		t.Fatalf("failed to write noop response: %v", err)
+	}
+	noop = conn.read()
+	assert.Equal(t, memd.CmdDcpNoop, noop.Command)
+}
+
+func TestDcpExpirations(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+
+	now := bucket.Store().Chrono().Now()
+	_, err = bucket.Store().Insert(&mockdb.Document{
+		VbID:   uint(vbID),
+		Key:    []byte("expired"),
+		Value:  []byte(`{"x":1}`),
+		Expiry: now.Add(-time.Second),
+		Cas:    mockdb.GenerateNewCas(now),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	streamExpired := func(controls map[string]string) *memd.Packet {
+		conn := dialTestKvBucket(t, node, "default")
+		defer conn.Close()
+		openTestDcp(t, conn, controls)
+
+		conn.send(testStreamReqPacket(vbID, 0, 0, math.MaxUint64))
+		for _, pak := range conn.readUntilIdle(200 * time.Millisecond) {
+			if string(pak.Key) == "expired" {
+				return pak
+			}
+		}
+		t.Fatalf("expired document was not streamed")
+		return nil
+	}
+
+	// Without the expiry opcode, expirations are sent as deletions.
+	pak := streamExpired(nil)
+	assert.Equal(t, memd.CmdDcpDeletion, pak.Command)
+	assert.Empty(t, pak.Value)
+
+	pak = streamExpired(map[string]string{"enable_expiry_opcode": "true"})
+	assert.Equal(t, memd.CmdDcpExpiration, pak.Command)
+	assert.Empty(t, pak.Value)
+	if assert.Len(t, pak.Extras, 20) {
+		assert.Equal(t, uint32(now.Add(-time.Second).Unix()), binary.BigEndian.Uint32(pak.Extras[16:]))
+	}
+}
+
+func TestDcpSystemEvents(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+	collectionsURL := mgmtURL + "/pools/default/buckets/default/scopes/_default/collections"
+
+	for _, name := range []string{"streamed", "filtered"} {
+		status, _ := doTestHTTP(t, "POST", collectionsURL, url.Values{"name": {name}})
+		assert.Equal(t, 200, status)
+	}
+	_, streamedID, err := bucket.CollectionManifest().GetByName("_default", "streamed")
+	if err != nil {
+		t.Fatalf("failed to find collection: %v", err)
+	}
+
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureCollections, memd.FeatureAltRequests)
+	defer conn.Close()
+	openTestDcp(t, conn, map[string]string{"enable_stream_id": "true"})
+
+	streamReq := testStreamReqPacket(vbID, 0, 0, math.MaxUint64)
+	streamReq.Value = []byte(fmt.Sprintf(`{"collections":["%x"],"sid":7}`, streamedID))
+	resp := conn.roundTrip(streamReq)
+	if resp.Status != memd.StatusSuccess {
+		t.Fatalf("failed to open stream: %v", resp.Status)
+	}
+
+	// Only the events of the collections within the filter are sent.
+	readEvents := func() []*memd.Packet {
+		var events []*memd.Packet
+		for _, pak := range conn.readUntilIdle(200 * time.Millisecond) {
+			if pak.Command == memd.CmdDcpEvent {
+				events = append(events, pak)
+			}
+		}
+		return events
+	}
+	checkEvent := func(pak *memd.Packet, eventID memd.StreamEventCode) {
+		if assert.NotNil(t, pak.StreamIDFrame) {
+			assert.Equal(t, uint16(7), pak.StreamIDFrame.StreamID)
+		}
+		if assert.Len(t, pak.Extras, 13) {
+			assert.Equal(t, uint32(eventID), binary.BigEndian.Uint32(pak.Extras[8:]))
+		}
+		if assert.Len(t, pak.Value, 16) {
+			assert.Equal(t, streamedID, binary.BigEndian.Uint32(pak.Value[12:]))
+		}
+	}
+
+	events := readEvents()
+	if assert.Len(t, events, 1) {
+		checkEvent(events[0], memd.StreamEventCollectionCreate)
+		assert.Equal(t, "streamed", string(events[0].Key))
+	}
+
+	for _, name := range []string{"filtered", "streamed"} {
+		status, _ := doTestHTTP(t, "DELETE", collectionsURL+"/"+name, nil)
+		assert.Equal(t, 200, status)
+	}
+
+	events = readEvents()
+	if assert.Len(t, events, 1) {
+		checkEvent(events[0], memd.StreamEventCollectionDelete)
+		assert.Empty(t, events[0].Key)
+
+		// The event takes its own seqno, after the drop of the other collection.
+		vbucket := bucket.Store().GetVbucket(uint(vbID))
+		assert.Equal(t, vbucket.MaxSeqNo(), binary.BigEndian.Uint64(events[0].Extras[0:]))
+	}
+}
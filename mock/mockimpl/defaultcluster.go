@@ -7,9 +7,13 @@ import (
 
 // NewDefaultCluster returns a new cluster configured with some defaults.
 func NewDefaultCluster() (mock.Cluster, error) {
-	cluster, err := NewCluster(mock.NewClusterOptions{
-		InitialNode: mock.NewNodeOptions{},
-	})
+	return NewDefaultClusterWithOptions(mock.NewClusterOptions{})
+}
+
+// NewDefaultClusterWithOptions returns a new cluster configured with the same
+// defaults as NewDefaultCluster, using the specified cluster options.
+func NewDefaultClusterWithOptions(opts mock.NewClusterOptions) (mock.Cluster, error) {
+	cluster, err := NewCluster(opts)
 	if err != nil {
 		return nil, err
 	}
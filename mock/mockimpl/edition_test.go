@@ -0,0 +1,148 @@
+package mockimpl
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/svcimpls"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommunityEditionFeatures(t *testing.T) {
+	enterprise, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	community, err := NewDefaultClusterWithOptions(mock.NewClusterOptions{
+		Edition: mock.ClusterEditionCommunity,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+
+	assert.Contains(t, svcimpls.HelloFeaturesForCluster(enterprise), memd.FeatureSyncReplication)
+	assert.NotContains(t, svcimpls.HelloFeaturesForCluster(community), memd.FeatureSyncReplication)
+
+	var config struct {
+		ProdVersion         string              `json:"prodVersion"`
+		BucketCapabilities  []string            `json:"bucketCapabilities"`
+		ClusterCapabilities map[string][]string `json:"clusterCapabilities"`
+	}
+
+	bucket := enterprise.GetBucket("default")
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, enterprise.Nodes()[0]), &config); err != nil {
+		t.Fatalf("failed to unmarshal bucket configuration: %s", err)
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseClusterConfig(enterprise, nil), &config); err != nil {
+		t.Fatalf("failed to unmarshal cluster configuration: %s", err)
+	}
+	assert.True(t, strings.HasSuffix(config.ProdVersion, "-enterprise"), config.ProdVersion)
+	assert.Contains(t, config.BucketCapabilities, "durableWrite")
+	assert.Contains(t, config.ClusterCapabilities["n1ql"], "costBasedOptimizer")
+
+	bucket = community.GetBucket("default")
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, community.Nodes()[0]), &config); err != nil {
+		t.Fatalf("failed to unmarshal bucket configuration: %s", err)
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseClusterConfig(community, nil), &config); err != nil {
+		t.Fatalf("failed to unmarshal cluster configuration: %s", err)
+	}
+	assert.True(t, strings.HasSuffix(config.ProdVersion, "-community"), config.ProdVersion)
+	assert.NotContains(t, config.BucketCapabilities, "durableWrite")
+	assert.NotContains(t, config.ClusterCapabilities["n1ql"], "costBasedOptimizer")
+	assert.NotContains(t, config.ClusterCapabilities["n1ql"], "indexAdvisor")
+	assert.Contains(t, config.ClusterCapabilities["n1ql"], "enhancedPreparedStatements")
+
+	// Synchronous replication is never negotiated, so durable writes are
+	// rejected as using an unknown frame.
+	node := community.Nodes()[0]
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureXerror)
+	defer conn.Close()
+
+	featuresBuf := make([]byte, 4)
+	binary.BigEndian.PutUint16(featuresBuf[0:], uint16(memd.FeatureAltRequests))
+	binary.BigEndian.PutUint16(featuresBuf[2:], uint16(memd.FeatureSyncReplication))
+	resp := conn.roundTrip(&memd.Packet{
+		Command: memd.CmdHello,
+		Key:     []byte("test"),
+		Value:   featuresBuf,
+	})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	assert.Equal(t, []byte{0, byte(memd.FeatureAltRequests)}, resp.Value)
+
+	// The client side is made to send the frame regardless.
+	conn.mconn.EnableFeature(memd.FeatureSyncReplication)
+	vbID := testActiveVbucket(t, community.GetBucket("default"), node)
+	resp = conn.roundTrip(testSetPacket(vbID, "key", true))
+	assert.Equal(t, memd.StatusCode(0x80), resp.Status)
+}
+
+func TestVersionCapabilities(t *testing.T) {
+	testCases := []struct {
+		version         mock.ClusterVersion
+		bucketCaps      []string
+		noBucketCaps    []string
+		clusterN1qlCaps []string
+	}{
+		{
+			version:      mock.ClusterVersion50,
+			noBucketCaps: []string{"durableWrite", "tombstonedUserXAttrs", "collections"},
+		},
+		{
+			version:         mock.ClusterVersion65,
+			bucketCaps:      []string{"durableWrite"},
+			noBucketCaps:    []string{"tombstonedUserXAttrs", "collections"},
+			clusterN1qlCaps: []string{"enhancedPreparedStatements"},
+		},
+		{
+			version:         mock.ClusterVersion66,
+			bucketCaps:      []string{"durableWrite", "tombstonedUserXAttrs"},
+			noBucketCaps:    []string{"collections"},
+			clusterN1qlCaps: []string{"enhancedPreparedStatements"},
+		},
+		{
+			version:    mock.ClusterVersion70,
+			bucketCaps: []string{"durableWrite", "tombstonedUserXAttrs", "collections"},
+			clusterN1qlCaps: []string{
+				"enhancedPreparedStatements",
+				"costBasedOptimizer",
+				"indexAdvisor",
+				"javaScriptFunctions",
+				"inlineFunctions",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.version), func(t *testing.T) {
+			cluster, err := NewDefaultClusterWithOptions(mock.NewClusterOptions{
+				Version: testCase.version,
+			})
+			if err != nil {
+				t.Fatalf("failed to create cluster: %v", err)
+			}
+
+			var config struct {
+				BucketCapabilities  []string            `json:"bucketCapabilities"`
+				ClusterCapabilities map[string][]string `json:"clusterCapabilities"`
+			}
+			bucket := cluster.GetBucket("default")
+			if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, cluster.Nodes()[0]), &config); err != nil {
+				t.Fatalf("failed to unmarshal bucket configuration: %s", err)
+			}
+
+			for _, capability := range testCase.bucketCaps {
+				assert.Contains(t, config.BucketCapabilities, capability)
+			}
+			for _, capability := range testCase.noBucketCaps {
+				assert.NotContains(t, config.BucketCapabilities, capability)
+			}
+			assert.Contains(t, config.BucketCapabilities, "xattr")
+			assert.Equal(t, testCase.clusterN1qlCaps, config.ClusterCapabilities["n1ql"])
+		})
+	}
+}
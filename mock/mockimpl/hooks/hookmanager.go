@@ -1,8 +1,12 @@
 package hooks
 
-// KvHookManager implements a tree of hooks which can handle a KV packet.
+import "sync"
+
+// KvHookManager implements a tree of hooks which can handle a KV packet.  Hooks
+// can be added and removed while the chain is being invoked.
 type hookManager struct {
 	parent       *hookManager
+	lock         sync.Mutex
 	destroyHooks []*func()
 	hooks        []interface{}
 }
@@ -16,14 +20,20 @@ func (m *hookManager) Child() hookManager {
 
 // Add adds a new hook at the end of the processing chain.
 func (m *hookManager) Add(fn interface{}) {
+	m.lock.Lock()
 	m.hooks = append(m.hooks, fn)
+	m.lock.Unlock()
+
 	if m.parent != nil {
 		m.parent.Add(fn)
 	}
 }
 
 func (m *hookManager) pushDestroyer(fnPtr *func()) {
+	m.lock.Lock()
 	m.destroyHooks = append(m.destroyHooks, fnPtr)
+	m.lock.Unlock()
+
 	if m.parent != nil {
 		m.parent.pushDestroyer(fnPtr)
 	}
@@ -36,6 +46,7 @@ func (m *hookManager) PushDestroyer(fn func()) {
 
 // remove will remote a specific hook pointer from this manager.
 func (m *hookManager) remove(fn interface{}) {
+	m.lock.Lock()
 	newHooks := make([]interface{}, 0)
 	for _, hook := range m.hooks {
 		if hook != fn {
@@ -44,6 +55,7 @@ func (m *hookManager) remove(fn interface{}) {
 	}
 
 	m.hooks = newHooks
+	m.lock.Unlock()
 
 	if m.parent != nil {
 		m.parent.remove(fn)
@@ -52,6 +64,7 @@ func (m *hookManager) remove(fn interface{}) {
 
 // removeDestroyer will remote a specified destroyer from this manager.
 func (m *hookManager) removeDestroyer(fn *func()) {
+	m.lock.Lock()
 	newDestroyHooks := make([]*func(), 0)
 	for _, hook := range m.destroyHooks {
 		if hook != fn {
@@ -60,6 +73,7 @@ func (m *hookManager) removeDestroyer(fn *func()) {
 	}
 
 	m.destroyHooks = newDestroyHooks
+	m.lock.Unlock()
 
 	if m.parent != nil {
 		m.parent.removeDestroyer(fn)
@@ -68,29 +82,35 @@ func (m *hookManager) removeDestroyer(fn *func()) {
 
 // Destroy removes all hooks that were added to this manager.
 func (m *hookManager) Destroy() {
+	m.lock.Lock()
+	hooks := m.hooks
+	destroyHooks := m.destroyHooks
+	m.hooks = nil
+	m.destroyHooks = nil
+	m.lock.Unlock()
+
 	if m.parent != nil {
-		for _, hook := range m.hooks {
+		for _, hook := range hooks {
 			m.parent.remove(hook)
 		}
 
-		for _, hook := range m.destroyHooks {
+		for _, hook := range destroyHooks {
 			m.parent.parent.removeDestroyer(hook)
 		}
 	}
 
-	for _, fn := range m.destroyHooks {
+	for _, fn := range destroyHooks {
 		(*fn)()
 	}
-
-	m.hooks = nil
-	m.destroyHooks = nil
 }
 
 // Invoke will invoke this hook chain.  It starts at the most recently
 // registered hook and works it's way to the oldest hook.
 func (m *hookManager) Invoke(fn func(interface{}, func() interface{}) interface{}) interface{} {
+	m.lock.Lock()
 	hookChain := make([]interface{}, len(m.hooks))
 	copy(hookChain, m.hooks)
+	m.lock.Unlock()
 
 	curHookIdx := len(hookChain)
 	var nextHook func() interface{}
@@ -24,12 +24,16 @@ func (c *fakeKvClient) Source() mock.KvService                    { return nil }
 func (c *fakeKvClient) ScramServer() *scramserver.ScramServer     { return nil }
 func (c *fakeKvClient) SetAuthenticatedUserName(userName string)  {}
 func (c *fakeKvClient) AuthenticatedUserName() string             { return "" }
+func (c *fakeKvClient) MarkAuthStale()                            {}
+func (c *fakeKvClient) IsAuthStale() bool                         { return false }
 func (c *fakeKvClient) SetSelectedBucketName(bucketName string)   {}
 func (c *fakeKvClient) SelectedBucketName() string                { return "" }
 func (c *fakeKvClient) SelectedBucket() mock.Bucket               { return nil }
 func (c *fakeKvClient) SetFeatures(features []memd.HelloFeature)  {}
 func (c *fakeKvClient) HasFeature(feature memd.HelloFeature) bool { return false }
+func (c *fakeKvClient) GetContext(valuePtr interface{})           {}
 func (c *fakeKvClient) WritePacket(pak *memd.Packet) error        { return nil }
+func (c *fakeKvClient) CloseNotify() <-chan struct{}              { return nil }
 func (c *fakeKvClient) Close() error                              { return nil }
 func (c *fakeKvClient) CheckAuthenticated(permission mockauth.Permission, collectionID uint32) bool {
 	return true
@@ -93,3 +93,28 @@ func TestMgmtHooksBasic(t *testing.T) {
 		t.Fatalf("invoke returned wrong value")
 	}
 }
+
+func TestMgmtHooksChildDestroy(t *testing.T) {
+	fakeSource := mock.MgmtService(&fakeMgmtService{})
+	fakeRequest := &mock.HTTPRequest{}
+	fakeResponse := &mock.HTTPResponse{}
+	fallbackResponse := &mock.HTTPResponse{}
+
+	var hooks MgmtHookManager
+	hooks.Add(func(source mock.MgmtService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		return fallbackResponse
+	})
+	child := hooks.Child()
+	child.Add(func(source mock.MgmtService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		// A hook can remove itself while it is being invoked.
+		child.Destroy()
+		return fakeResponse
+	})
+
+	if res := hooks.Invoke(fakeSource, fakeRequest); res != fakeResponse {
+		t.Fatalf("child hook was not invoked")
+	}
+	if res := hooks.Invoke(fakeSource, fakeRequest); res != fallbackResponse {
+		t.Fatalf("child hook was not removed")
+	}
+}
@@ -0,0 +1,150 @@
+package mockimpl
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// testKvConn is a raw memcached connection to the kv service of a node, for
+// tests which need to control exactly which packets are sent.
+type testKvConn struct {
+	t      *testing.T
+	conn   net.Conn
+	mconn  *memd.Conn
+	opaque uint32
+}
+
+// dialTestKv connects to the kv service of a node, negotiating the features
+// which are requested.
+func dialTestKv(t *testing.T, node mock.ClusterNode, features ...memd.HelloFeature) *testKvConn {
+	kvService := node.KvService()
+	conn, err := net.Dial("tcp", net.JoinHostPort(kvService.Hostname(), strconv.Itoa(kvService.ListenPort())))
+	if err != nil {
+		t.Fatalf("failed to dial kv: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &testKvConn{
+		t:     t,
+		conn:  conn,
+		mconn: memd.NewConn(conn),
+	}
+
+	featuresBuf := make([]byte, len(features)*2)
+	for featureIdx, feature := range features {
+		binary.BigEndian.PutUint16(featuresBuf[featureIdx*2:], uint16(feature))
+	}
+	resp := c.roundTrip(&memd.Packet{
+		Command: memd.CmdHello,
+		Key:     []byte("test"),
+		Value:   featuresBuf,
+	})
+	if resp.Status != memd.StatusSuccess {
+		t.Fatalf("failed to hello: %v", resp.Status)
+	}
+	for _, feature := range features {
+		c.mconn.EnableFeature(feature)
+	}
+
+	return c
+}
+
+// dialTestKvBucket connects to the kv service of a node as the Administrator
+// and selects a bucket.
+func dialTestKvBucket(t *testing.T, node mock.ClusterNode, bucketName string, features ...memd.HelloFeature) *testKvConn {
+	c := dialTestKv(t, node, features...)
+	c.selectBucket(bucketName)
+	return c
+}
+
+// dialTestKvTLSBucket connects to the kv service of a node over TLS as the
+// Administrator and selects a bucket.
+func dialTestKvTLSBucket(t *testing.T, node mock.ClusterNode, bucketName string) *testKvConn {
+	kvService := node.KvService()
+	conn, err := tls.Dial("tcp", net.JoinHostPort(kvService.Hostname(), strconv.Itoa(kvService.ListenPortTLS())),
+		&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("failed to dial kv: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &testKvConn{
+		t:     t,
+		conn:  conn,
+		mconn: memd.NewConn(conn),
+	}
+	c.selectBucket(bucketName)
+	return c
+}
+
+// selectBucket authenticates as the Administrator and selects a bucket.
+func (c *testKvConn) selectBucket(bucketName string) {
+	resp := c.roundTrip(&memd.Packet{
+		Command: memd.CmdSASLAuth,
+		Key:     []byte("PLAIN"),
+		Value:   []byte("\x00Administrator\x00password"),
+	})
+	if resp.Status != memd.StatusSuccess {
+		c.t.Fatalf("failed to authenticate: %v", resp.Status)
+	}
+
+	resp = c.roundTrip(&memd.Packet{
+		Command: memd.CmdSelectBucket,
+		Key:     []byte(bucketName),
+	})
+	if resp.Status != memd.StatusSuccess {
+		c.t.Fatalf("failed to select bucket: %v", resp.Status)
+	}
+}
+
+// send writes a request, assigning it the next opaque, which is returned.
+func (c *testKvConn) send(pak *memd.Packet) uint32 {
+	c.opaque++
+	pak.Magic = memd.CmdMagicReq
+	pak.Opaque = c.opaque
+	if err := c.mconn.WritePacket(pak); err != nil {
+		c.t.Fatalf("failed to write packet: %v", err)
+	}
+	return pak.Opaque
+}
+
+// read reads the next packet sent by the server.
+func (c *testKvConn) read() *memd.Packet {
+	pak, _, err := c.mconn.ReadPacket()
+	if err != nil {
+		c.t.Fatalf("failed to read packet: %v", err)
+	}
+	return pak
+}
+
+// roundTrip sends a request and reads the response to it.
+func (c *testKvConn) roundTrip(pak *memd.Packet) *memd.Packet {
+	opaque := c.send(pak)
+	resp := c.read()
+	if resp.Opaque != opaque {
+		c.t.Fatalf("expected response to opaque %d but got %d", opaque, resp.Opaque)
+	}
+	return resp
+}
+
+func (c *testKvConn) Close() {
+	_ = c.conn.Close()
+}
+
+// testActiveVbucket returns a vbucket whose active copy is held by a node.
+func testActiveVbucket(t *testing.T, bucket mock.Bucket, node mock.ClusterNode) uint16 {
+	for vbIdx, repIdx := range bucket.VbucketOwnership(node) {
+		if repIdx == 0 {
+			return uint16(vbIdx)
+		}
+	}
+	t.Fatalf("node holds no active vbuckets")
+	return 0
+}
@@ -0,0 +1,82 @@
+package mockimpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvConnectionLimits(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	vbID := testActiveVbucket(t, cluster.GetBucket("default"), node)
+	limits := cluster.KvConnectionLimits()
+
+	get := func(conn *testKvConn) memd.StatusCode {
+		return conn.roundTrip(&memd.Packet{
+			Command: memd.CmdGet,
+			Vbucket: vbID,
+			Key:     []byte("missing"),
+		}).Status
+	}
+
+	if err := limits.SetBucketLimit("default", 1); err != nil {
+		t.Fatalf("failed to set bucket limit: %v", err)
+	}
+
+	// Connections are served in the order they were admitted, regardless of
+	// whether they use TLS.
+	tlsConn := dialTestKvTLSBucket(t, node, "default")
+	defer tlsConn.Close()
+	assert.Equal(t, memd.StatusKeyNotFound, get(tlsConn))
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+	assert.Equal(t, memd.StatusBusy, get(conn))
+	assert.Equal(t, memd.StatusKeyNotFound, get(tlsConn))
+
+	// Limits apply to each node separately.
+	otherConn := dialTestKvBucket(t, cluster.Nodes()[1], "default")
+	defer otherConn.Close()
+	otherVbID := testActiveVbucket(t, cluster.GetBucket("default"), cluster.Nodes()[1])
+	resp := otherConn.roundTrip(&memd.Packet{
+		Command: memd.CmdGet,
+		Vbucket: otherVbID,
+		Key:     []byte("missing"),
+	})
+	assert.Equal(t, memd.StatusKeyNotFound, resp.Status)
+
+	// Once the admitted connection closes, the next one is served.  The
+	// close is noticed asynchronously to the client closing it.
+	tlsConn.Close()
+	status := get(conn)
+	for i := 0; i < 100 && status == memd.StatusBusy; i++ {
+		time.Sleep(10 * time.Millisecond)
+		status = get(conn)
+	}
+	assert.Equal(t, memd.StatusKeyNotFound, status)
+
+	// User limits are counted separately from bucket limits.
+	if err := limits.SetBucketLimit("default", 0); err != nil {
+		t.Fatalf("failed to remove bucket limit: %v", err)
+	}
+	if err := limits.SetUserLimit("Administrator", 1); err != nil {
+		t.Fatalf("failed to set user limit: %v", err)
+	}
+	memdConn := dialTestKvBucket(t, node, "memd")
+	defer memdConn.Close()
+	assert.Equal(t, memd.StatusBusy, get(memdConn))
+
+	stats := limits.Stats()
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "default", stats[0].Bucket)
+		assert.True(t, stats[0].Rejected >= 1)
+		assert.Equal(t, mock.KvConnectionLimitStats{User: "Administrator", Rejected: 1}, stats[1])
+	}
+}
@@ -0,0 +1,86 @@
+package mockimpl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvFaultInjector(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	var lock sync.Mutex
+	var faults mock.KvRequestFaults
+	cluster.SetKvFaultInjector(func(source mock.KvClient, pak *memd.Packet) mock.KvRequestFaults {
+		if pak.Command != memd.CmdNoop {
+			return mock.KvRequestFaults{}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		return faults
+	})
+	setFaults := func(f mock.KvRequestFaults) {
+		lock.Lock()
+		faults = f
+		lock.Unlock()
+	}
+
+	conn := dialTestKv(t, node)
+	defer conn.Close()
+
+	setFaults(mock.KvRequestFaults{Latency: 100 * time.Millisecond})
+	start := time.Now()
+	resp := conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	setFaults(mock.KvRequestFaults{Status: memd.StatusTmpFail})
+	resp = conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, memd.StatusTmpFail, resp.Status)
+
+	setFaults(mock.KvRequestFaults{Hang: &mock.KvCommandHang{Action: mock.KvHangActionClose}})
+	conn.send(&memd.Packet{Command: memd.CmdNoop})
+	_, _, err = conn.mconn.ReadPacket()
+	assert.Error(t, err)
+
+	// Without an injector, requests are processed as normal again.
+	cluster.SetKvFaultInjector(nil)
+	conn = dialTestKv(t, node)
+	defer conn.Close()
+	resp = conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
+
+func TestBumpConfigRevConcurrent(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	bucket := cluster.GetBucket("default")
+	clusterRev := cluster.ConfigRev()
+	bucketRev := bucket.ConfigRev()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				cluster.BumpConfigRev()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, clusterRev+100, cluster.ConfigRev())
+	assert.Equal(t, bucketRev+100, bucket.ConfigRev())
+}
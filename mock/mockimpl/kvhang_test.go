@@ -0,0 +1,82 @@
+package mockimpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvCommandHangNoResponse(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	err = node.KvService().SetCommandHang(memd.CmdGetClusterConfig, &mock.KvCommandHang{
+		Action: mock.KvHangActionNoResponse,
+	})
+	if err != nil {
+		t.Fatalf("failed to set command hang: %v", err)
+	}
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+
+	// The hung request is never responded to, but does not hold up the
+	// requests which follow it.
+	conn.send(&memd.Packet{Command: memd.CmdGetClusterConfig})
+	for i := 0; i < 3; i++ {
+		resp := conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+		assert.Equal(t, memd.StatusSuccess, resp.Status)
+	}
+
+	// Removing the hang lets the command be processed again.
+	if err := node.KvService().SetCommandHang(memd.CmdGetClusterConfig, nil); err != nil {
+		t.Fatalf("failed to remove command hang: %v", err)
+	}
+	resp := conn.roundTrip(&memd.Packet{Command: memd.CmdGetClusterConfig})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
+
+func TestKvCommandHangClose(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	err = node.KvService().SetCommandHang(memd.CmdGetClusterConfig, &mock.KvCommandHang{
+		Action:  mock.KvHangActionClose,
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to set command hang: %v", err)
+	}
+
+	conn := dialTestKvBucket(t, node, "default")
+	defer conn.Close()
+
+	// Requests sent while waiting for the timeout are still processed.
+	start := time.Now()
+	conn.send(&memd.Packet{Command: memd.CmdGetClusterConfig})
+	resp := conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+
+	// The connection is then closed without responding to the hung request.
+	_, _, err = conn.mconn.ReadPacket()
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	assert.Error(t, node.KvService().SetCommandHang(memd.CmdGetClusterConfig, &mock.KvCommandHang{
+		Action:  mock.KvHangActionClose,
+		Timeout: -time.Second,
+	}))
+	assert.Error(t, node.KvService().SetCommandHang(memd.CmdGetClusterConfig, &mock.KvCommandHang{
+		Action: mock.KvHangAction("invalid"),
+	}))
+}
@@ -0,0 +1,34 @@
+package mockimpl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvKeyLength(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	vbID := testActiveVbucket(t, cluster.GetBucket("default"), node)
+
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureCollections)
+	defer conn.Close()
+
+	resp := conn.roundTrip(testSetPacket(vbID, strings.Repeat("k", 250), false))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	resp = conn.roundTrip(testSetPacket(vbID, strings.Repeat("k", 251), false))
+	assert.Equal(t, memd.StatusInvalidArgs, resp.Status)
+
+	// Keys which are not document keys are not limited in length.
+	resp = conn.roundTrip(&memd.Packet{
+		Command: memd.CmdCollectionsGetID,
+		Key:     []byte(strings.Repeat("k", 300)),
+		Value:   []byte("_default._default"),
+	})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
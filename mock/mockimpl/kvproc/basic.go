@@ -4,23 +4,40 @@ import (
 	"time"
 
 	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
 )
 
 // Engine represents a specific engine.
 type Engine struct {
-	db          *mockdb.Bucket
-	vbOwnership []int
+	db           *mockdb.Bucket
+	vbOwnership  []int
+	fullEviction bool
+	clockSkew    time.Duration
+	maxDocDepth  int
+	strictJSON   bool
 }
 
 // New creates a new crudproc engine using a mockdb and a list of what replicas
-// are owned by this particular engine.
-func New(db *mockdb.Bucket, vbOwnership []int) *Engine {
+// are owned by this particular engine.  fullEviction specifies whether document
+// metadata is evicted along with values, and clockSkew is how far the clock of
+// the node running the engine is skewed from the cluster time.
+func New(db *mockdb.Bucket, vbOwnership []int, fullEviction bool, clockSkew time.Duration) *Engine {
 	return &Engine{
-		db:          db,
-		vbOwnership: vbOwnership,
+		db:           db,
+		vbOwnership:  vbOwnership,
+		fullEviction: fullEviction,
+		clockSkew:    clockSkew,
 	}
 }
 
+// SetDocumentLimits sets the deepest which JSON documents can be nested by the
+// operations of the engine, zero disables the limit.  strictJSON extends the
+// limit to full document writes, rather than just sub-document mutations.
+func (e *Engine) SetDocumentLimits(maxDocDepth int, strictJSON bool) {
+	e.maxDocDepth = maxDocDepth
+	e.strictJSON = strictJSON
+}
+
 func (e *Engine) findReplicaIdx(vbIdx uint) int {
 	if vbIdx >= uint(len(e.vbOwnership)) {
 		return -1
@@ -51,19 +68,28 @@ func (e *Engine) docIsLocked(doc *mockdb.Document) bool {
 }
 
 func (e *Engine) parseExpiry(expiry uint32) time.Time {
+	return ParseExpiry(e.db.Chrono(), expiry)
+}
+
+// ParseExpiry converts an expiry, as specified by a client, into the point in
+// time at which a document expires.  Expiries longer than 30 days are treated
+// as unix timestamps rather than relative to now.
+func ParseExpiry(chrono *mocktime.Chrono, expiry uint32) time.Time {
 	if expiry == 0 {
 		return time.Time{}
 	}
 
 	// TODO(brett19): Check if this is the right edge for expiry.
 	if expiry > 30*24*60*60 {
-		return time.Unix(int64(expiry), 0).Add(e.db.Chrono().TimeShift())
+		return time.Unix(int64(expiry), 0).Add(chrono.TimeShift())
 	}
 
 	expiryDura := time.Duration(expiry) * time.Second
-	return e.db.Chrono().Now().Add(expiryDura)
+	return chrono.Now().Add(expiryDura)
 }
 
+// HLC returns the time of the hybrid logical clock of the node running the
+// engine, which the CAS of new mutations is generated from.
 func (e *Engine) HLC() time.Time {
-	return e.db.Chrono().Now()
+	return e.db.HLC().Add(e.clockSkew)
 }
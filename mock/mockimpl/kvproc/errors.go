@@ -14,6 +14,8 @@ var (
 	ErrLocked                 = errors.New("locked")
 	ErrNotLocked              = errors.New("not locked")
 	ErrInvalidArgument        = errors.New("invalid packet")
+	ErrVbUUIDNotEqual         = errors.New("vbucket uuid not equal")
+	ErrSeqNoNotReached        = errors.New("seqno not reached")
 	ErrSdToManyTries          = errors.New("subdocument too many attempts")
 	ErrSdNotJSON              = errors.New("subdocument not json")
 	ErrSdPathInvalid          = errors.New("subdocument path invalid")
@@ -27,6 +29,10 @@ var (
 	ErrSdInvalidXattr         = errors.New("there is something wrong with the syntax of the provided XATTR")
 	ErrSdCannotModifyVattr    = errors.New("xattr cannot modify virtual attribute")
 	ErrSdXattrInvalidKeyCombo = errors.New("invalid xattr key combination")
+	ErrSdXattrInvalidOrder    = errors.New("xattr specs must precede body specs")
+	ErrSdXattrUnknownVattr    = errors.New("unknown virtual xattr")
+	ErrSdDocTooDeep           = errors.New("subdocument doc too deep")
+	ErrSdValueTooDeep         = errors.New("subdocument value too deep")
 )
 
 type SubdocMutateError struct {
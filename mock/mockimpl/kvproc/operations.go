@@ -1,6 +1,7 @@
 package kvproc
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
@@ -42,6 +43,14 @@ func (e *Engine) Get(opts GetOptions) (*GetResult, error) {
 		return nil, ErrDocNotFound
 	}
 
+	if doc.IsValueEvicted {
+		// The value needs to be fetched back into memory to be returned.
+		err := e.db.FetchDocument(opts.Vbucket, opts.CollectionID, opts.Key, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if e.docIsLocked(doc) {
 		// If the doc is locked, we return -1 as the CAS instead.
 		doc.Cas = 0xFFFFFFFFFFFFFFFF
@@ -86,6 +95,15 @@ func (e *Engine) GetMeta(opts GetMetaOptions) (*GetMetaResult, error) {
 		return nil, err
 	}
 
+	if e.fullEviction && doc.IsMetaEvicted {
+		// With value eviction the metadata is always resident, but here it
+		// needs to be fetched back into memory first.
+		err := e.db.FetchDocument(opts.Vbucket, opts.CollectionID, opts.Key, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if e.docIsLocked(doc) {
 		// If the doc is locked, we return -1 as the CAS instead.
 		doc.Cas = 0xFFFFFFFFFFFFFFFF
@@ -102,6 +120,39 @@ func (e *Engine) GetMeta(opts GetMetaOptions) (*GetMetaResult, error) {
 	}, nil
 }
 
+// EvictOptions specifies options for an EVICT_KEY operation.
+type EvictOptions struct {
+	Vbucket      uint
+	CollectionID uint
+	Key          []byte
+}
+
+// EvictResult contains the results of an EVICT_KEY operation.
+type EvictResult struct {
+	AlreadyEvicted bool
+}
+
+// Evict performs an EVICT_KEY operation.  With full eviction the metadata of
+// the document is evicted along with its value.
+func (e *Engine) Evict(opts EvictOptions) (*EvictResult, error) {
+	if err := e.confirmIsMaster(opts.Vbucket); err != nil {
+		return nil, err
+	}
+
+	alreadyEvicted, err := e.db.EvictDocument(opts.Vbucket, opts.CollectionID, opts.Key, e.fullEviction)
+	if err == mockdb.ErrDocNotFound {
+		return nil, ErrDocNotFound
+	} else if err == mockdb.ErrDocDirty {
+		return nil, ErrDocExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &EvictResult{
+		AlreadyEvicted: alreadyEvicted,
+	}, nil
+}
+
 // GetRandomOptions specifies options for a GET_RANDOM operation.
 type GetRandomOptions struct {
 	CollectionID uint
@@ -118,7 +169,15 @@ type GetRandomResult struct {
 
 // GetRandom performs a GET_RANDOM operation.
 func (e *Engine) GetRandom(opts GetRandomOptions) (*GetRandomResult, error) {
-	doc, err := e.db.GetRandom(0, opts.CollectionID)
+	// Random documents are only ever selected from our active vbuckets.
+	var vbIdxs []uint
+	for vbIdx, repIdx := range e.vbOwnership {
+		if repIdx == 0 {
+			vbIdxs = append(vbIdxs, uint(vbIdx))
+		}
+	}
+
+	doc, err := e.db.GetRandomWithin(vbIdxs, 0, opts.CollectionID)
 	if err == mockdb.ErrDocNotFound {
 		return nil, ErrDocNotFound
 	} else if err != nil {
@@ -190,6 +249,16 @@ func (e *Engine) Add(opts StoreOptions) (*StoreResult, error) {
 		return nil, err
 	}
 
+	// An ADD can never be performed against an existing document, so a CAS
+	// makes no sense here.
+	if opts.Cas != 0 {
+		return nil, ErrInvalidArgument
+	}
+
+	if e.strictJSON && e.docTooDeep(opts.Value) {
+		return nil, ErrSdDocTooDeep
+	}
+
 	doc := &mockdb.Document{
 		VbID:         opts.Vbucket,
 		CollectionID: opts.CollectionID,
@@ -225,6 +294,10 @@ func (e *Engine) Set(opts StoreOptions) (*StoreResult, error) {
 		return nil, err
 	}
 
+	if e.strictJSON && e.docTooDeep(opts.Value) {
+		return nil, ErrSdDocTooDeep
+	}
+
 	doc := &mockdb.Document{
 		VbID:         opts.Vbucket,
 		CollectionID: opts.CollectionID,
@@ -292,6 +365,10 @@ func (e *Engine) Replace(opts StoreOptions) (*StoreResult, error) {
 		return nil, err
 	}
 
+	if e.strictJSON && e.docTooDeep(opts.Value) {
+		return nil, ErrSdDocTooDeep
+	}
+
 	doc := &mockdb.Document{
 		VbID:         opts.Vbucket,
 		CollectionID: opts.CollectionID,
@@ -350,9 +427,11 @@ type DeleteOptions struct {
 
 // DeleteResult contains the results of a DELETE operation.
 type DeleteResult struct {
-	Cas    uint64
-	VbUUID uint64
-	SeqNo  uint64
+	Cas     uint64
+	VbUUID  uint64
+	SeqNo   uint64
+	Flags   uint32
+	ExpTime time.Time
 }
 
 // Delete performs an DELETE operation.
@@ -403,9 +482,11 @@ func (e *Engine) Delete(opts DeleteOptions) (*DeleteResult, error) {
 	}
 
 	return &DeleteResult{
-		Cas:    newDoc.Cas,
-		VbUUID: newDoc.VbUUID,
-		SeqNo:  newDoc.SeqNo,
+		Cas:     newDoc.Cas,
+		VbUUID:  newDoc.VbUUID,
+		SeqNo:   newDoc.SeqNo,
+		Flags:   newDoc.Flags,
+		ExpTime: newDoc.Expiry,
 	}, nil
 }
 
@@ -834,6 +915,10 @@ func (e *Engine) MultiLookup(opts MultiLookupOptions) (*MultiLookupResult, error
 		return nil, err
 	}
 
+	if err := validateSubDocOpFlags(opts.Ops); err != nil {
+		return nil, err
+	}
+
 	doc, err := e.db.Get(0, opts.Vbucket, opts.CollectionID, opts.Key)
 	if err == mockdb.ErrDocNotFound || (doc.IsDeleted && !opts.AccessDeleted) {
 		return nil, ErrDocNotFound
@@ -850,6 +935,18 @@ func (e *Engine) MultiLookup(opts MultiLookupOptions) (*MultiLookupResult, error
 		return nil, err
 	}
 
+	if doc.IsDeleted {
+		// A tombstone has no body, so only its xattrs can be looked up.  The
+		// full document is still fetchable, it is simply empty.
+		for opIdx, op := range opts.Ops {
+			if !op.IsXattrPath && op.Op != memd.SubDocOpGetDoc {
+				sdRes[opIdx] = &SubDocResult{
+					Err: ErrSdPathNotFound,
+				}
+			}
+		}
+	}
+
 	return &MultiLookupResult{
 		Cas:       doc.Cas,
 		Ops:       sdRes,
@@ -885,6 +982,10 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 		return nil, err
 	}
 
+	if err := validateSubDocOpFlags(opts.Ops); err != nil {
+		return nil, err
+	}
+
 	// Some doc options imply path options.
 	if opts.CreateIfMissing || opts.CreateOnly {
 		for opIdx := range opts.Ops {
@@ -892,6 +993,15 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 		}
 	}
 
+	// The seqno of a mutation is only assigned once it is stored, so to expand
+	// it into a macro we predict the seqno, and retry if it turns out wrong.
+	usesSeqNoMacro := false
+	for _, op := range opts.Ops {
+		if op.ExpandMacros && bytes.Equal(op.Value, seqnoMacro) {
+			usesSeqNoMacro = true
+		}
+	}
+
 	for attemptIdx := 0; attemptIdx < 10; attemptIdx++ {
 		mdoc := &mockdb.Document{
 			VbID:         opts.Vbucket,
@@ -942,9 +1052,8 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 
 		// Check for cas mismatch before we do any work. We'll effectively be doing this check again during the update
 		// too so if anyone performs a mutation during that time then we'll catch it there too.
-		// If doc is deleted, and we're only updating the tombstoned xattr then no cas mismatch is performed, so we can
-		// continue
-		if opts.Cas != 0 && doc.Cas != opts.Cas && !(doc.IsDeleted && !xattrUpdateOnly) {
+		// Tombstones are checked the same way, as transactions rely on the cas when resurrecting them.
+		if opts.Cas != 0 && doc != nil && doc.Cas != opts.Cas {
 			return nil, ErrCasMismatch
 		}
 
@@ -969,6 +1078,22 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 			return nil, ErrDocNotFound
 		}
 
+		if doc.IsDeleted && !xattrUpdateOnly {
+			// Writing the body of a tombstone brings the document back to life
+			// with the new body, which is how a transaction commits the
+			// replacement of a document that it had staged against a tombstone.
+			doc.IsDeleted = false
+			doc.Expiry = time.Time{}
+
+			// A tombstone has normally had its body stripped, which would
+			// leave the ops with no root to apply their paths to.  They are
+			// instead applied against the same empty root as they would be
+			// for a document which they create.
+			if len(doc.Value) == 0 {
+				doc.Value = mdoc.Value
+			}
+		}
+
 		if e.docIsLocked(doc) {
 			return nil, ErrLocked
 		}
@@ -976,14 +1101,17 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 		newMetaDoc := &mockdb.Document{
 			Cas: mockdb.GenerateNewCas(e.HLC()),
 		}
+		if usesSeqNoMacro {
+			newMetaDoc.SeqNo = e.db.GetVbucket(opts.Vbucket).MaxSeqNo() + 1
+		}
 
 		sdRes, err := e.executeSdOps(doc, newMetaDoc, opts.Ops, false)
 		if err != nil {
 			return nil, err
 		}
 
-		newDoc, err := e.db.Update(
-			doc.VbID, doc.CollectionID, doc.Key,
+		newDoc, err := e.db.UpdateAtSeqNo(
+			doc.VbID, doc.CollectionID, doc.Key, newMetaDoc.SeqNo,
 			func(idoc *mockdb.Document) (*mockdb.Document, error) {
 				if idoc == nil {
 					// Check if our source document existed or not
@@ -1013,7 +1141,7 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 				idoc.Datatype = uint8(memd.DatatypeFlagJSON)
 				return idoc, nil
 			})
-		if err == ErrCasMismatch {
+		if err == ErrCasMismatch || err == mockdb.ErrSeqNoMismatch {
 			continue
 		} else if err == mockdb.ErrValueTooBig {
 			return nil, ErrValueTooBig
@@ -1032,6 +1160,60 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 	return nil, ErrSdToManyTries
 }
 
+// ObserveOptions specifies options for observing a single key with OBSERVE.
+type ObserveOptions struct {
+	Vbucket      uint
+	CollectionID uint
+	Key          []byte
+}
+
+// ObserveResult contains the results of observing a single key with OBSERVE.
+type ObserveResult struct {
+	KeyState memd.KeyState
+	Cas      uint64
+}
+
+// Observe performs an OBSERVE operation for a single key, which can be made
+// against either the master or a replica of the vbucket.
+func (e *Engine) Observe(opts ObserveOptions) (*ObserveResult, error) {
+	repIdx := e.findReplicaIdx(opts.Vbucket)
+	if repIdx == -1 {
+		return nil, ErrNotMyVbucket
+	}
+
+	doc, err := e.db.Get(uint(repIdx), opts.Vbucket, opts.CollectionID, opts.Key)
+	if err == mockdb.ErrDocNotFound {
+		// The key has never been present, as opposed to having been deleted.
+		return &ObserveResult{
+			KeyState: memd.KeyStateNotFound,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	metaState := e.db.GetVbucket(opts.Vbucket).CurrentMetaState(uint(repIdx))
+	isPersisted := doc.SeqNo <= metaState.PersistSeqNo
+
+	result := &ObserveResult{
+		Cas: doc.Cas,
+	}
+	if doc.IsDeleted {
+		// A tombstone is reported as deleted while the deletion is still dirty,
+		// once it has been persisted the key is simply not found.
+		if isPersisted {
+			result.KeyState = memd.KeyStateNotFound
+		} else {
+			result.KeyState = memd.KeyStateDeleted
+		}
+	} else if isPersisted {
+		result.KeyState = memd.KeyStatePersisted
+	} else {
+		result.KeyState = memd.KeyStateNotPersisted
+	}
+
+	return result, nil
+}
+
 // ObserveSeqNoOptions specifies options for an OBSERVE_SEQNO operation.
 type ObserveSeqNoOptions struct {
 	Vbucket uint
@@ -1043,6 +1225,13 @@ type ObserveSeqNoResult struct {
 	VbUUID       uint64
 	CurrentSeqNo uint64
 	PersistSeqNo uint64
+
+	// DidFailover indicates that the requested vbuuid is no longer the
+	// current one, in which case OldVbUUID and LastSeqNo describe the point
+	// at which the requested vbuuid's history ended.
+	DidFailover bool
+	OldVbUUID   uint64
+	LastSeqNo   uint64
 }
 
 // ObserveSeqNo performs an OBSERVE_SEQNO operation.
@@ -1052,11 +1241,32 @@ func (e *Engine) ObserveSeqNo(opts ObserveSeqNoOptions) (*ObserveSeqNoResult, er
 		return nil, ErrNotMyVbucket
 	}
 
-	metaState := e.db.GetVbucket(opts.Vbucket).CurrentMetaState(uint(repIdx))
+	vbucket := e.db.GetVbucket(opts.Vbucket)
+	metaState := vbucket.CurrentMetaState(uint(repIdx))
 
-	return &ObserveSeqNoResult{
+	result := &ObserveSeqNoResult{
 		VbUUID:       metaState.VbUUID,
 		CurrentSeqNo: metaState.CurrentSeqNo,
 		PersistSeqNo: metaState.PersistSeqNo,
-	}, nil
+	}
+
+	if opts.VbUUID != metaState.VbUUID {
+		failoverLog := vbucket.FailoverLog()
+		for histIdx := len(failoverLog) - 2; histIdx >= 0; histIdx-- {
+			if failoverLog[histIdx].VbUUID == opts.VbUUID {
+				result.DidFailover = true
+				result.OldVbUUID = opts.VbUUID
+				result.LastSeqNo = failoverLog[histIdx+1].SeqNo
+				break
+			}
+		}
+
+		// A vbuuid which never appeared in the failover log cannot be
+		// observed, as there is no way to know where its history ended.
+		if !result.DidFailover {
+			return nil, ErrDocNotFound
+		}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,673 @@
+package kvproc
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEngine creates an engine which is active for every vbucket of a new
+// bucket, returning the bucket and the chrono it uses alongside it.
+func newTestEngine(t *testing.T) (*Engine, *mockdb.Bucket, *mocktime.Chrono) {
+	chrono := &mocktime.Chrono{}
+	db, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	return New(db, []int{0, 0, 0, 0}, false, 0), db, chrono
+}
+
+func TestStoreStatusMatrix(t *testing.T) {
+	const (
+		docMissing = iota
+		docExists
+		docDeleted
+	)
+
+	const (
+		casNone = iota
+		casMatch
+		casMismatch
+	)
+
+	testCases := []struct {
+		name     string
+		op       string
+		docState int
+		casMode  int
+		err      error
+	}{
+		{"AddMissing", "add", docMissing, casNone, nil},
+		{"AddExists", "add", docExists, casNone, ErrDocExists},
+		{"AddDeleted", "add", docDeleted, casNone, nil},
+		{"AddWithCas", "add", docExists, casMatch, ErrInvalidArgument},
+		{"SetMissing", "set", docMissing, casNone, nil},
+		{"SetExists", "set", docExists, casNone, nil},
+		{"SetDeleted", "set", docDeleted, casNone, nil},
+		{"SetMissingWithCas", "set", docMissing, casMismatch, ErrDocNotFound},
+		{"SetDeletedWithCas", "set", docDeleted, casMismatch, ErrDocNotFound},
+		{"SetExistsCasMatch", "set", docExists, casMatch, nil},
+		{"SetExistsCasMismatch", "set", docExists, casMismatch, ErrCasMismatch},
+		{"ReplaceMissing", "replace", docMissing, casNone, ErrDocNotFound},
+		{"ReplaceExists", "replace", docExists, casNone, nil},
+		{"ReplaceDeleted", "replace", docDeleted, casNone, ErrDocNotFound},
+		{"ReplaceMissingWithCas", "replace", docMissing, casMismatch, ErrDocNotFound},
+		{"ReplaceExistsCasMatch", "replace", docExists, casMatch, nil},
+		{"ReplaceExistsCasMismatch", "replace", docExists, casMismatch, ErrCasMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, _, _ := newTestEngine(t)
+			key := []byte("test")
+
+			var existingCas uint64
+			if tc.docState != docMissing {
+				res, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{}`)})
+				assert.NoError(t, err)
+				existingCas = res.Cas
+
+				if tc.docState == docDeleted {
+					_, err := engine.Delete(DeleteOptions{Vbucket: 1, Key: key})
+					assert.NoError(t, err)
+				}
+			}
+
+			var cas uint64
+			switch tc.casMode {
+			case casMatch:
+				cas = existingCas
+			case casMismatch:
+				cas = existingCas + 1
+			}
+
+			opts := StoreOptions{Vbucket: 1, Key: key, Cas: cas, Value: []byte(`{"new":true}`)}
+			var err error
+			switch tc.op {
+			case "add":
+				_, err = engine.Add(opts)
+			case "set":
+				_, err = engine.Set(opts)
+			case "replace":
+				_, err = engine.Replace(opts)
+			}
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestMultiLookupXattrOrdering(t *testing.T) {
+	engine, _, _ := newTestEngine(t)
+	key := []byte("test")
+
+	_, err := engine.MultiMutate(MultiMutateOptions{
+		Vbucket:         1,
+		Key:             key,
+		CreateIfMissing: true,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpDictSet, Path: "txn.id", Value: []byte(`"abc"`), CreatePath: true, IsXattrPath: true},
+			{Op: memd.SubDocOpSetDoc, Value: []byte(`{"x":1}`)},
+		},
+	})
+	assert.NoError(t, err)
+
+	res, err := engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpGet, Path: "txn", IsXattrPath: true},
+			{Op: memd.SubDocOpGet, Path: "x"},
+			{Op: memd.SubDocOpGetDoc},
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, res.Ops, 3) {
+		assert.NoError(t, res.Ops[0].Err)
+		assert.JSONEq(t, `{"id":"abc"}`, string(res.Ops[0].Value))
+		assert.NoError(t, res.Ops[1].Err)
+		assert.Equal(t, "1", string(res.Ops[1].Value))
+		assert.NoError(t, res.Ops[2].Err)
+		assert.JSONEq(t, `{"x":1}`, string(res.Ops[2].Value))
+	}
+
+	_, err = engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpGet, Path: "x"},
+			{Op: memd.SubDocOpGet, Path: "txn", IsXattrPath: true},
+		},
+	})
+	assert.Equal(t, ErrSdXattrInvalidOrder, err)
+}
+
+func TestMultiLookupAccessDeleted(t *testing.T) {
+	engine, _, _ := newTestEngine(t)
+	key := []byte("test")
+
+	_, err := engine.MultiMutate(MultiMutateOptions{
+		Vbucket:         1,
+		Key:             key,
+		CreateIfMissing: true,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpDictSet, Path: "_txn.id", Value: []byte(`"abc"`), CreatePath: true, IsXattrPath: true},
+			{Op: memd.SubDocOpSetDoc, Value: []byte(`{"x":1}`)},
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = engine.Delete(DeleteOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+
+	ops := []*SubDocOp{
+		{Op: memd.SubDocOpGet, Path: "_txn", IsXattrPath: true},
+		{Op: memd.SubDocOpGet, Path: "x"},
+	}
+
+	_, err = engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops:     ops,
+	})
+	assert.Equal(t, ErrDocNotFound, err)
+
+	res, err := engine.MultiLookup(MultiLookupOptions{
+		Vbucket:       1,
+		Key:           key,
+		Ops:           ops,
+		AccessDeleted: true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, res.IsDeleted)
+	if assert.Len(t, res.Ops, 2) {
+		assert.NoError(t, res.Ops[0].Err)
+		assert.JSONEq(t, `{"id":"abc"}`, string(res.Ops[0].Value))
+		assert.Equal(t, ErrSdPathNotFound, res.Ops[1].Err)
+	}
+}
+
+func TestMultiMutateFlagValidation(t *testing.T) {
+	testCases := []struct {
+		name string
+		op   SubDocOp
+		err  error
+	}{
+		{"BodyExpandMacros", SubDocOp{Op: memd.SubDocOpDictSet, Path: "x", Value: []byte(`"${Mutation.CAS}"`), ExpandMacros: true}, ErrSdInvalidFlagCombo},
+		{"XattrExpandMacros", SubDocOp{Op: memd.SubDocOpDictSet, Path: "txn.cas", Value: []byte(`"${Mutation.CAS}"`), CreatePath: true, IsXattrPath: true, ExpandMacros: true}, nil},
+		{"CounterExpandMacros", SubDocOp{Op: memd.SubDocOpCounter, Path: "txn.count", Value: []byte(`1`), IsXattrPath: true, ExpandMacros: true}, ErrInvalidArgument},
+		{"DeleteCreatePath", SubDocOp{Op: memd.SubDocOpDelete, Path: "x", CreatePath: true}, ErrInvalidArgument},
+		{"SetDocXattr", SubDocOp{Op: memd.SubDocOpSetDoc, Value: []byte(`{}`), IsXattrPath: true}, ErrInvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, _, _ := newTestEngine(t)
+			key := []byte("test")
+
+			_, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"x":1}`)})
+			assert.NoError(t, err)
+
+			op := tc.op
+			_, err = engine.MultiMutate(MultiMutateOptions{
+				Vbucket: 1,
+				Key:     key,
+				Ops:     []*SubDocOp{&op},
+			})
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestMultiMutateCreatePath(t *testing.T) {
+	testCases := []struct {
+		name string
+		op   SubDocOp
+		doc  string
+		err  error
+	}{
+		{"DictSetNested", SubDocOp{Op: memd.SubDocOpDictSet, Path: "a.b.c", Value: []byte(`1`), CreatePath: true}, `{"x":1,"o":{"k":2},"a":{"b":{"c":1}}}`, nil},
+		{"DictSetNestedNoFlag", SubDocOp{Op: memd.SubDocOpDictSet, Path: "a.b.c", Value: []byte(`1`)}, "", ErrSdPathNotFound},
+		{"DictSetLastNoFlag", SubDocOp{Op: memd.SubDocOpDictSet, Path: "y", Value: []byte(`1`)}, `{"x":1,"o":{"k":2},"y":1}`, nil},
+		{"DictSetPartial", SubDocOp{Op: memd.SubDocOpDictSet, Path: "o.p.q", Value: []byte(`1`), CreatePath: true}, `{"x":1,"o":{"k":2,"p":{"q":1}}}`, nil},
+		{"DictSetPartialNoFlag", SubDocOp{Op: memd.SubDocOpDictSet, Path: "o.p.q", Value: []byte(`1`)}, "", ErrSdPathNotFound},
+		{"DictSetThroughScalar", SubDocOp{Op: memd.SubDocOpDictSet, Path: "x.b", Value: []byte(`1`), CreatePath: true}, "", ErrSdPathMismatch},
+		{"DictSetThroughArrayIndex", SubDocOp{Op: memd.SubDocOpDictSet, Path: "a[0].b", Value: []byte(`1`), CreatePath: true}, "", ErrSdPathNotFound},
+		{"DictAddNested", SubDocOp{Op: memd.SubDocOpDictAdd, Path: "a.b", Value: []byte(`1`), CreatePath: true}, `{"x":1,"o":{"k":2},"a":{"b":1}}`, nil},
+		{"DictAddNestedNoFlag", SubDocOp{Op: memd.SubDocOpDictAdd, Path: "a.b", Value: []byte(`1`)}, "", ErrSdPathNotFound},
+		{"PushLastNested", SubDocOp{Op: memd.SubDocOpArrayPushLast, Path: "a.b", Value: []byte(`1`), CreatePath: true}, `{"x":1,"o":{"k":2},"a":{"b":[1]}}`, nil},
+		{"PushLastNestedNoFlag", SubDocOp{Op: memd.SubDocOpArrayPushLast, Path: "a.b", Value: []byte(`1`)}, "", ErrSdPathNotFound},
+		{"AddUniqueNested", SubDocOp{Op: memd.SubDocOpArrayAddUnique, Path: "a.b", Value: []byte(`1`), CreatePath: true}, `{"x":1,"o":{"k":2},"a":{"b":[1]}}`, nil},
+		{"AddUniqueNestedNoFlag", SubDocOp{Op: memd.SubDocOpArrayAddUnique, Path: "a.b", Value: []byte(`1`)}, "", ErrSdPathNotFound},
+		{"CounterNested", SubDocOp{Op: memd.SubDocOpCounter, Path: "a.b", Value: []byte(`5`), CreatePath: true}, `{"x":1,"o":{"k":2},"a":{"b":5}}`, nil},
+		{"CounterNestedNoFlag", SubDocOp{Op: memd.SubDocOpCounter, Path: "a.b", Value: []byte(`5`)}, "", ErrSdPathNotFound},
+		{"CounterLastNoFlag", SubDocOp{Op: memd.SubDocOpCounter, Path: "y", Value: []byte(`5`)}, `{"x":1,"o":{"k":2},"y":5}`, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, _, _ := newTestEngine(t)
+			key := []byte("test")
+
+			_, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"x":1,"o":{"k":2},"o":{"k":2}}`)})
+			assert.NoError(t, err)
+
+			op := tc.op
+			_, err = engine.MultiMutate(MultiMutateOptions{
+				Vbucket: 1,
+				Key:     key,
+				Ops:     []*SubDocOp{&op},
+			})
+			if tc.err != nil {
+				assert.Equal(t, SubdocMutateError{tc.err}, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			res, err := engine.Get(GetOptions{Vbucket: 1, Key: key})
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.doc, string(res.Value))
+		})
+	}
+}
+
+func TestHLCDriftLastModified(t *testing.T) {
+	engine, db, chrono := newTestEngine(t)
+	key := []byte("test")
+
+	drift := 2 * time.Hour
+	db.SetHLCDrift(drift)
+
+	res, err := engine.Set(StoreOptions{
+		Vbucket: 1,
+		Key:     key,
+		Value:   []byte(`{"x":1}`),
+	})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, chrono.Now().Add(drift), mockdb.CasToTime(res.Cas), time.Minute)
+
+	lookupRes, err := engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpGet, Path: "$document.last_modified", IsXattrPath: true},
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, lookupRes.Ops, 1) {
+		assert.NoError(t, lookupRes.Ops[0].Err)
+		assert.Equal(t, `"`+strconv.FormatInt(mockdb.CasToTime(res.Cas).Unix(), 10)+`"`, string(lookupRes.Ops[0].Value))
+	}
+}
+
+func TestClockSkewCas(t *testing.T) {
+	engine, db, _ := newTestEngine(t)
+	skewedEngine := New(db, []int{0, 0, 0, 0}, false, 24*time.Hour)
+	key := []byte("test")
+
+	skewedRes, err := skewedEngine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"x":1}`)})
+	assert.NoError(t, err)
+	assert.True(t, mockdb.CasToTime(skewedRes.Cas).After(time.Now().Add(23*time.Hour)))
+
+	// Later mutations must be ordered after the CAS from the future, even
+	// though they are performed by a node whose clock is correct.
+	res, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"x":2}`)})
+	assert.NoError(t, err)
+	assert.Greater(t, res.Cas, skewedRes.Cas)
+
+	metaRes, err := engine.GetMeta(GetMetaOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, res.Cas, metaRes.Cas)
+
+	lookupRes, err := engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpGet, Path: "$vbucket.HLC.mode", IsXattrPath: true},
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, lookupRes.Ops, 1) {
+		assert.Equal(t, `"logical"`, string(lookupRes.Ops[0].Value))
+	}
+}
+
+func TestDocumentDepthLimits(t *testing.T) {
+	engine, _, _ := newTestEngine(t)
+	engine.SetDocumentLimits(3, false)
+	key := []byte("test")
+
+	// Full document writes are only limited in strict mode.
+	_, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"a":{"b":{"c":{"d":"[{"}}}}`)})
+	assert.NoError(t, err)
+
+	res, err := engine.MultiLookup(MultiLookupOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpGet, Path: "a"},
+		},
+	})
+	if assert.NoError(t, err) && assert.Len(t, res.Ops, 1) {
+		assert.Equal(t, ErrSdDocTooDeep, res.Ops[0].Err)
+	}
+
+	_, err = engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"a":{"b":"[{"}}`)})
+	assert.NoError(t, err)
+
+	_, err = engine.MultiMutate(MultiMutateOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpDictSet, Path: "a.c", Value: []byte(`[1]`)},
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = engine.MultiMutate(MultiMutateOptions{
+		Vbucket: 1,
+		Key:     key,
+		Ops: []*SubDocOp{
+			{Op: memd.SubDocOpDictSet, Path: "a.d", Value: []byte(`[[1]]`)},
+		},
+	})
+	assert.Equal(t, SubdocMutateError{ErrSdValueTooDeep}, err)
+
+	engine.SetDocumentLimits(3, true)
+	_, err = engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`[[[[1]]]]`)})
+	assert.Equal(t, ErrSdDocTooDeep, err)
+	_, err = engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`[[[1]]]`)})
+	assert.NoError(t, err)
+}
+
+func TestRangeScan(t *testing.T) {
+	engine, _, _ := newTestEngine(t)
+	for _, key := range []string{"d", "b", "a", "c", "e"} {
+		_, err := engine.Set(StoreOptions{Vbucket: 1, Key: []byte(key), Value: []byte(`{}`)})
+		assert.NoError(t, err)
+	}
+
+	// Rewritten documents must only be returned once, and deleted ones not at all.
+	_, err := engine.Set(StoreOptions{Vbucket: 1, Key: []byte("b"), Value: []byte(`{"x":1}`)})
+	assert.NoError(t, err)
+	_, err = engine.Delete(DeleteOptions{Vbucket: 1, Key: []byte("c")})
+	assert.NoError(t, err)
+
+	scanKeys := func(opts RangeScanOptions) []string {
+		res, err := engine.RangeScan(opts)
+		assert.NoError(t, err)
+
+		var keys []string
+		for _, item := range res.Items {
+			keys = append(keys, string(item.Key))
+		}
+		return keys
+	}
+
+	assert.Equal(t, []string{"a", "b", "d", "e"}, scanKeys(RangeScanOptions{Vbucket: 1}))
+	assert.Equal(t, []string{"b", "d"}, scanKeys(RangeScanOptions{Vbucket: 1, StartKey: []byte("b"), EndKey: []byte("d")}))
+	assert.Equal(t, []string{"d"}, scanKeys(RangeScanOptions{
+		Vbucket:        1,
+		StartKey:       []byte("b"),
+		EndKey:         []byte("e"),
+		ExclusiveStart: true,
+		ExclusiveEnd:   true,
+	}))
+
+	_, err = engine.RangeScan(RangeScanOptions{Vbucket: 1, StartKey: []byte("f")})
+	assert.Equal(t, ErrDocNotFound, err)
+
+	_, err = engine.RangeScan(RangeScanOptions{Vbucket: 4})
+	assert.Equal(t, ErrNotMyVbucket, err)
+}
+
+func TestRangeScanSnapshotRequirements(t *testing.T) {
+	engine, db, _ := newTestEngine(t)
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := engine.Set(StoreOptions{Vbucket: 1, Key: []byte(key), Value: []byte(`{}`)})
+		assert.NoError(t, err)
+	}
+
+	vbucket := db.GetVbucket(1)
+	oldVbUUID := vbucket.CurrentMetaState(0).VbUUID
+	maxSeqNo := vbucket.MaxSeqNo()
+
+	scan := func(vbUUID, seqNo uint64) error {
+		_, err := engine.RangeScan(RangeScanOptions{
+			Vbucket: 1,
+			SnapshotRequirements: &RangeScanSnapshotRequirements{
+				VbUUID: vbUUID,
+				SeqNo:  seqNo,
+			},
+		})
+		return err
+	}
+
+	assert.NoError(t, scan(oldVbUUID, maxSeqNo))
+	assert.Equal(t, ErrSeqNoNotReached, scan(oldVbUUID, maxSeqNo+1))
+
+	// Discarding mutations begins a new branch of the history, so scans of
+	// the old one are refused.
+	assert.NoError(t, db.DiscardMutationsAfter(1, maxSeqNo-1))
+	assert.Equal(t, ErrVbUUIDNotEqual, scan(oldVbUUID, maxSeqNo-1))
+	assert.NoError(t, scan(vbucket.CurrentMetaState(0).VbUUID, maxSeqNo-1))
+}
+
+func TestSubDocGetCount(t *testing.T) {
+	engine, _, _ := newTestEngine(t)
+	key := []byte("test")
+
+	_, err := engine.Set(StoreOptions{
+		Vbucket: 1,
+		Key:     key,
+		Value:   []byte(`{"arr":[1,[2,3],{"a":4}],"obj":{"a":1,"b":{"c":2}},"empty":[],"num":5,"str":"x","nul":null}`),
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		path  string
+		value string
+		err   error
+	}{
+		{"", "6", nil},
+		{"arr", "3", nil},
+		{"arr[1]", "2", nil},
+		{"arr[-1]", "1", nil},
+		{"obj", "2", nil},
+		{"obj.b", "1", nil},
+		{"empty", "0", nil},
+		{"num", "", ErrSdPathMismatch},
+		{"str", "", ErrSdPathMismatch},
+		{"nul", "", ErrSdPathMismatch},
+		{"arr[0]", "", ErrSdPathMismatch},
+		{"missing", "", ErrSdPathNotFound},
+		{"obj.missing", "", ErrSdPathNotFound},
+		{"arr[5]", "", ErrSdPathNotFound},
+		{"num.a", "", ErrSdPathMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			res, err := engine.MultiLookup(MultiLookupOptions{
+				Vbucket: 1,
+				Key:     key,
+				Ops: []*SubDocOp{
+					{Op: memd.SubDocOpGetCount, Path: tc.path},
+				},
+			})
+			assert.NoError(t, err)
+			if assert.Len(t, res.Ops, 1) {
+				assert.Equal(t, tc.err, res.Ops[0].Err)
+				assert.Equal(t, tc.value, string(res.Ops[0].Value))
+			}
+		})
+	}
+}
+
+func TestObserveSeqNoFailover(t *testing.T) {
+	engine, db, _ := newTestEngine(t)
+	for i := 0; i < 3; i++ {
+		_, err := engine.Set(StoreOptions{Vbucket: 1, Key: []byte("key" + strconv.Itoa(i)), Value: []byte(`{}`)})
+		assert.NoError(t, err)
+	}
+
+	oldUUID := db.GetVbucket(1).FailoverLog()[0].VbUUID
+
+	res, err := engine.ObserveSeqNo(ObserveSeqNoOptions{Vbucket: 1, VbUUID: oldUUID})
+	assert.NoError(t, err)
+	assert.False(t, res.DidFailover)
+	assert.Equal(t, uint64(3), res.CurrentSeqNo)
+
+	// Discarding mutations starts a new branch of history at the given seqno.
+	assert.NoError(t, db.DiscardMutationsAfter(1, 2))
+
+	res, err = engine.ObserveSeqNo(ObserveSeqNoOptions{Vbucket: 1, VbUUID: oldUUID})
+	assert.NoError(t, err)
+	assert.True(t, res.DidFailover)
+	assert.NotEqual(t, oldUUID, res.VbUUID)
+	assert.Equal(t, oldUUID, res.OldVbUUID)
+	assert.Equal(t, uint64(2), res.LastSeqNo)
+
+	_, err = engine.ObserveSeqNo(ObserveSeqNoOptions{Vbucket: 1, VbUUID: oldUUID + 1})
+	assert.Equal(t, ErrDocNotFound, err)
+}
+
+func TestObserveTombstone(t *testing.T) {
+	engine, _, chrono := newTestEngine(t)
+	key := []byte("test")
+
+	res, err := engine.Observe(ObserveOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, memd.KeyStateNotFound, res.KeyState)
+
+	_, err = engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{}`)})
+	assert.NoError(t, err)
+
+	res, err = engine.Observe(ObserveOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, memd.KeyStateNotPersisted, res.KeyState)
+
+	chrono.TimeTravel(time.Second)
+
+	res, err = engine.Observe(ObserveOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, memd.KeyStatePersisted, res.KeyState)
+
+	delRes, err := engine.Delete(DeleteOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+
+	// A dirty tombstone is reported as deleted, along with its cas.
+	res, err = engine.Observe(ObserveOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, memd.KeyStateDeleted, res.KeyState)
+	assert.Equal(t, delRes.Cas, res.Cas)
+
+	chrono.TimeTravel(time.Second)
+
+	// Once persisted the tombstone is indistinguishable from a missing key.
+	res, err = engine.Observe(ObserveOptions{Vbucket: 1, Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, memd.KeyStateNotFound, res.KeyState)
+}
+
+func TestMultiMutateResurrectTombstone(t *testing.T) {
+	testCases := []struct {
+		name    string
+		ops     []*SubDocOp
+		badCas  bool
+		err     error
+		deleted bool
+		value   string
+	}{
+		{
+			name: "WithBody",
+			ops: []*SubDocOp{
+				{Op: memd.SubDocOpDictSet, Path: "txn", Value: []byte(`{"id":1}`), IsXattrPath: true},
+				{Op: memd.SubDocOpSetDoc, Value: []byte(`{"x":2}`)},
+			},
+			value: `{"x":2}`,
+		},
+		{
+			name: "WithoutBody",
+			ops: []*SubDocOp{
+				{Op: memd.SubDocOpDictSet, Path: "txn", Value: []byte(`{"id":1}`), IsXattrPath: true},
+				{Op: memd.SubDocOpDictSet, Path: "x", Value: []byte(`2`)},
+			},
+			value: `{"x":2}`,
+		},
+		{
+			name: "XattrsOnly",
+			ops: []*SubDocOp{
+				{Op: memd.SubDocOpDictSet, Path: "txn", Value: []byte(`{"id":1}`), IsXattrPath: true},
+			},
+			deleted: true,
+		},
+		{
+			name: "CasMismatch",
+			ops: []*SubDocOp{
+				{Op: memd.SubDocOpSetDoc, Value: []byte(`{"x":2}`)},
+			},
+			badCas:  true,
+			err:     ErrCasMismatch,
+			deleted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, _, _ := newTestEngine(t)
+			key := []byte("test")
+
+			_, err := engine.Set(StoreOptions{Vbucket: 1, Key: key, Value: []byte(`{"x":1}`)})
+			assert.NoError(t, err)
+			delRes, err := engine.Delete(DeleteOptions{Vbucket: 1, Key: key})
+			assert.NoError(t, err)
+
+			cas := delRes.Cas
+			if tc.badCas {
+				cas++
+			}
+
+			_, err = engine.MultiMutate(MultiMutateOptions{
+				Vbucket:       1,
+				Key:           key,
+				AccessDeleted: true,
+				Cas:           cas,
+				Ops:           tc.ops,
+			})
+			assert.Equal(t, tc.err, err)
+
+			getRes, err := engine.Get(GetOptions{Vbucket: 1, Key: key})
+			if tc.deleted {
+				assert.Equal(t, ErrDocNotFound, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, tc.value, string(getRes.Value))
+			}
+
+			// The xattrs written alongside the body survive the resurrection.
+			lookupRes, err := engine.MultiLookup(MultiLookupOptions{
+				Vbucket: 1,
+				Key:     key,
+				Ops: []*SubDocOp{
+					{Op: memd.SubDocOpGet, Path: "txn", IsXattrPath: true},
+				},
+			})
+			if assert.NoError(t, err) && assert.Len(t, lookupRes.Ops, 1) {
+				assert.JSONEq(t, `{"id":1}`, string(lookupRes.Ops[0].Value))
+			}
+		})
+	}
+}
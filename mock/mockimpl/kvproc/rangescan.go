@@ -0,0 +1,129 @@
+package kvproc
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// RangeScanOptions specifies options for a RANGE_SCAN_CREATE operation.  An
+// empty end key leaves the end of the range unbounded.
+type RangeScanOptions struct {
+	Vbucket        uint
+	CollectionID   uint
+	StartKey       []byte
+	EndKey         []byte
+	ExclusiveStart bool
+	ExclusiveEnd   bool
+
+	// SnapshotRequirements optionally requires that the scan is of a
+	// particular branch of the history of the vbucket, which has reached a
+	// particular seqno.
+	SnapshotRequirements *RangeScanSnapshotRequirements
+}
+
+// RangeScanSnapshotRequirements specifies the state which a vbucket must be in
+// for a range scan of it to be created.
+type RangeScanSnapshotRequirements struct {
+	VbUUID uint64
+	SeqNo  uint64
+}
+
+// RangeScanItem represents a single document which was matched by a range scan.
+type RangeScanItem struct {
+	Key      []byte
+	Value    []byte
+	Flags    uint32
+	ExpTime  time.Time
+	SeqNo    uint64
+	Cas      uint64
+	Datatype uint8
+}
+
+// RangeScanResult contains the results of a RANGE_SCAN_CREATE operation.
+type RangeScanResult struct {
+	Items []RangeScanItem
+}
+
+func (opts RangeScanOptions) inRange(key []byte) bool {
+	startCmp := bytes.Compare(key, opts.StartKey)
+	if startCmp < 0 || startCmp == 0 && opts.ExclusiveStart {
+		return false
+	}
+
+	if len(opts.EndKey) > 0 {
+		endCmp := bytes.Compare(key, opts.EndKey)
+		if endCmp > 0 || endCmp == 0 && opts.ExclusiveEnd {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RangeScan performs a RANGE_SCAN_CREATE operation, taking a snapshot of all
+// the live documents within the range, ordered by key.
+func (e *Engine) RangeScan(opts RangeScanOptions) (*RangeScanResult, error) {
+	if err := e.confirmIsMaster(opts.Vbucket); err != nil {
+		return nil, err
+	}
+
+	vbucket := e.db.GetVbucket(opts.Vbucket)
+
+	// A failover since the client learned of the vbucket uuid means that the
+	// mutations it expects to see may have been lost.
+	if reqs := opts.SnapshotRequirements; reqs != nil {
+		metaState := vbucket.CurrentMetaState(0)
+		if metaState.VbUUID != reqs.VbUUID {
+			return nil, ErrVbUUIDNotEqual
+		}
+		if metaState.CurrentSeqNo < reqs.SeqNo {
+			return nil, ErrSeqNoNotReached
+		}
+	}
+
+	docs, err := vbucket.GetAll(0, opts.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every revision of each document is returned, in the order they were
+	// written, so the last one seen for each key is the current one.
+	latestDocs := make(map[string]*mockdb.Document)
+	for _, doc := range docs {
+		latestDocs[string(doc.Key)] = doc
+	}
+
+	var items []RangeScanItem
+	for _, doc := range latestDocs {
+		if doc.IsDeleted || !opts.inRange(doc.Key) {
+			continue
+		}
+
+		items = append(items, RangeScanItem{
+			Key:      doc.Key,
+			Value:    doc.Value,
+			Flags:    doc.Flags,
+			ExpTime:  doc.Expiry,
+			SeqNo:    doc.SeqNo,
+			Cas:      doc.Cas,
+			Datatype: doc.Datatype,
+		})
+	}
+
+	// Creating a scan over an empty range fails, rather than returning a scan
+	// which immediately completes.
+	if len(items) == 0 {
+		return nil, ErrDocNotFound
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+
+	return &RangeScanResult{
+		Items: items,
+	}, nil
+}
@@ -19,6 +19,76 @@ import (
 
 const subdocMultiMaxPaths = 16
 
+// virtualXattrs is the registry of the virtual xattrs which we support, each
+// mapped to the function which generates its document.  Any other xattr which
+// begins with a $ is an unknown virtual xattr.
+var virtualXattrs = map[string]func(e *Engine, doc *mockdb.Document) *mockdb.Document{
+	"$document": func(e *Engine, doc *mockdb.Document) *mockdb.Document {
+		return e.createVattrDoc(doc)
+	},
+	"$vbucket": func(e *Engine, doc *mockdb.Document) *mockdb.Document {
+		return e.createVbucketDoc(doc)
+	},
+}
+
+// subdocOpFlags describes which of the path flags a subdoc op accepts.
+type subdocOpFlags struct {
+	xattrPath    bool
+	createPath   bool
+	expandMacros bool
+}
+
+// subdocValidOpFlags is the registry of the path flags which are valid for
+// each subdoc op.  Full document ops never accept any path flags.
+var subdocValidOpFlags = map[memd.SubDocOpType]subdocOpFlags{
+	memd.SubDocOpGet:            {xattrPath: true},
+	memd.SubDocOpExists:         {xattrPath: true},
+	memd.SubDocOpGetCount:       {xattrPath: true},
+	memd.SubDocOpGetDoc:         {},
+	memd.SubDocOpDictAdd:        {xattrPath: true, createPath: true, expandMacros: true},
+	memd.SubDocOpDictSet:        {xattrPath: true, createPath: true, expandMacros: true},
+	memd.SubDocOpDelete:         {xattrPath: true},
+	memd.SubDocOpReplace:        {xattrPath: true, expandMacros: true},
+	memd.SubDocOpArrayPushLast:  {xattrPath: true, createPath: true, expandMacros: true},
+	memd.SubDocOpArrayPushFirst: {xattrPath: true, createPath: true, expandMacros: true},
+	memd.SubDocOpArrayInsert:    {xattrPath: true, expandMacros: true},
+	memd.SubDocOpArrayAddUnique: {xattrPath: true, createPath: true, expandMacros: true},
+	memd.SubDocOpCounter:        {xattrPath: true, createPath: true},
+	memd.SubDocOpSetDoc:         {},
+	memd.SubDocOpDeleteDoc:      {},
+}
+
+// validateSubDocOpFlags checks that the path flags of each op are valid for
+// it, as specified by the client.  Macros can only be expanded within xattrs,
+// which is reported as an invalid flag combination, any other flag which the
+// op does not accept is an invalid argument.
+func validateSubDocOpFlags(ops []*SubDocOp) error {
+	for _, op := range ops {
+		if op.ExpandMacros && !op.IsXattrPath {
+			return ErrSdInvalidFlagCombo
+		}
+
+		validFlags, ok := subdocValidOpFlags[op.Op]
+		if !ok {
+			// Unknown ops are rejected when they are executed.
+			continue
+		}
+
+		if (op.IsXattrPath && !validFlags.xattrPath) ||
+			(op.CreatePath && !validFlags.createPath) ||
+			(op.ExpandMacros && !validFlags.expandMacros) {
+			return ErrInvalidArgument
+		}
+	}
+
+	return nil
+}
+
+// isVirtualXattr returns whether an xattr key is that of a virtual xattr.
+func isVirtualXattr(key string) bool {
+	return strings.HasPrefix(key, "$")
+}
+
 var (
 	crc32cMacro = []byte("\"${Mutation.value_crc32c}\"")
 	seqnoMacro  = []byte("\"${Mutation.seqno}\"")
@@ -56,6 +126,28 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 		return nil, ErrSdBadCombo
 	}
 
+	// The server requires that all xattr specs come before any body specs.
+	seenBodyOp := false
+	for _, op := range ops {
+		if !op.IsXattrPath {
+			seenBodyOp = true
+			continue
+		} else if seenBodyOp {
+			return nil, ErrSdXattrInvalidOrder
+		}
+
+		// Invalid paths are reported against the individual op below.
+		pathComps, err := ParseSubDocPath(op.Path)
+		if err != nil {
+			continue
+		}
+
+		key := pathComps[0].Path
+		if _, ok := virtualXattrs[key]; isVirtualXattr(key) && !ok {
+			return nil, ErrSdXattrUnknownVattr
+		}
+	}
+
 	opReses := make([]*SubDocResult, len(ops))
 
 	reorderedOps := subdocReorder(ops)
@@ -71,7 +163,7 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 			}
 
 			path := pathComps[0].Path
-			if path != "$document" && path != "$vbucket" {
+			if !isVirtualXattr(path) {
 				if _, ok := seenXattrRoots[path]; !ok {
 					seenXattrRoots[path] = struct{}{}
 				}
@@ -80,6 +172,11 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 				}
 			}
 
+			// Macros are expanded into a copy of the op, so that they can be
+			// expanded again if the mutation needs to be retried.
+			expandedOp := *op
+			op = &expandedOp
+
 			opDoc, err = e.createXattrDoc(doc, newMeta, op)
 			if err != nil {
 				opReses[reorderedOps.indexes[opIdx]] = &SubDocResult{
@@ -89,9 +186,6 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 				continue
 			}
 		} else {
-			if op.ExpandMacros {
-				return nil, ErrSdInvalidFlagCombo
-			}
 			opDoc = doc
 		}
 		base := baseSubDocExecutor{
@@ -109,9 +203,6 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 				baseSubDocExecutor: base,
 			}
 		case memd.SubDocOpGetCount:
-			if op.ExpandMacros {
-				return nil, ErrInvalidArgument
-			}
 			executor = SubDocGetCountExecutor{
 				baseSubDocExecutor: base,
 			}
@@ -171,9 +262,28 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 			return nil, ErrInternal
 		}
 
-		opRes, err := executor.Execute(op)
-		if err != nil {
-			return nil, err
+		var opRes *SubDocResult
+		if !op.IsXattrPath && e.docTooDeep(opDoc.Value) {
+			opRes = &SubDocResult{
+				Value: nil,
+				Err:   ErrSdDocTooDeep,
+			}
+		} else {
+			res, err := executor.Execute(op)
+			if err != nil {
+				return nil, err
+			}
+			opRes = res
+
+			// The mutation is applied to our copy of the document, which is
+			// discarded if it made the document too deep.
+			if opRes != nil && opRes.Err == nil && !op.IsXattrPath &&
+				subdocOpIsMutation(op) && e.docTooDeep(opDoc.Value) {
+				opRes = &SubDocResult{
+					Value: nil,
+					Err:   ErrSdValueTooDeep,
+				}
+			}
 		}
 
 		if !continueOnOpError && opRes.Err != nil {
@@ -188,7 +298,7 @@ func (e *Engine) executeSdOps(doc, newMeta *mockdb.Document, ops []*SubDocOp, co
 			pathComps, err := ParseSubDocPath(op.Path)
 			if err != nil {
 				// It'd be very strange to actually get here.
-				opReses[opIdx] = &SubDocResult{
+				opReses[reorderedOps.indexes[opIdx]] = &SubDocResult{
 					Value: nil,
 					Err:   err,
 				}
@@ -236,18 +346,16 @@ func (e *Engine) createXattrDoc(doc, metaDoc *mockdb.Document, op *SubDocOp) (*m
 
 	key := pathComps[0].Path
 
-	if key == "$document" {
-		if subdocOpIsMutation(op) {
-			return nil, ErrSdCannotModifyVattr
+	if isVirtualXattr(key) {
+		createFn, ok := virtualXattrs[key]
+		if !ok {
+			return nil, ErrSdXattrUnknownVattr
 		}
-
-		return e.createVattrDoc(doc), nil
-	} else if key == "$vbucket" {
 		if subdocOpIsMutation(op) {
 			return nil, ErrSdCannotModifyVattr
 		}
 
-		return e.createVbucketDoc(), nil
+		return createFn(e, doc), nil
 	}
 
 	xattr, ok := doc.Xattrs[key]
@@ -285,7 +393,7 @@ func (e *Engine) createMacroValue(opValue []byte, doc, metaDoc *mockdb.Document)
 			val = []byte(fmt.Sprintf("\"0x%x\"", crc32.Checksum(doc.Value, table)))
 		}
 	} else if bytes.Equal(opValue, seqnoMacro) {
-		return nil, ErrUnknownXattrMacro
+		val = []byte(fmt.Sprintf("\"0x%016x\"", metaDoc.SeqNo))
 	} else if bytes.HasPrefix(opValue, []byte(`"${$document`)) && bytes.HasSuffix(opValue, []byte(`}"`)) {
 		vattr := e.createVattrDoc(doc)
 		if bytes.Equal(opValue, []byte(`"${$document}"`)) {
@@ -317,8 +425,19 @@ func (e *Engine) createMacroValue(opValue []byte, doc, metaDoc *mockdb.Document)
 	return val, nil
 }
 
-func (e *Engine) createVbucketDoc() *mockdb.Document {
-	v := []byte(fmt.Sprintf(`{"$vbucket":{"HLC":{"mode":"real","now":"%d"}}}`, e.HLC().Unix()))
+func (e *Engine) createVbucketDoc(doc *mockdb.Document) *mockdb.Document {
+	// Once the vbucket has seen a CAS from the future, the clock runs in the
+	// logical mode until real time catches up with it.
+	now := e.HLC()
+	mode := "real"
+	if vbucket := e.db.GetVbucket(doc.VbID); vbucket != nil {
+		if casTime := mockdb.CasToTime(vbucket.MaxCas()); casTime.After(now) {
+			now = casTime
+			mode = "logical"
+		}
+	}
+
+	v := []byte(fmt.Sprintf(`{"$vbucket":{"HLC":{"mode":"%s","now":"%d"}}}`, mode, now.Unix()))
 
 	return &mockdb.Document{
 		Value: v,
@@ -342,7 +461,7 @@ func (e *Engine) createVattrDoc(doc *mockdb.Document) *mockdb.Document {
 	v = append(v, fmt.Sprintf("\"datatype\":%s,", datatypeToString(doc.Datatype))...)
 	v = append(v, fmt.Sprintf("\"deleted\":%t,", doc.IsDeleted)...)
 	v = append(v, fmt.Sprintf("\"flags\":%d,", doc.Flags)...)
-	v = append(v, fmt.Sprintf("\"last_modified\":\"%d\",", doc.ModifiedTime.Unix())...)
+	v = append(v, fmt.Sprintf("\"last_modified\":\"%d\",", mockdb.CasToTime(doc.Cas).Unix())...)
 	v = append(v, fmt.Sprintf("\"seqno\":\"0x%016x\",", doc.SeqNo)...)
 	v = append(v, fmt.Sprintf("\"value_bytes\":%d,", len(doc.Value))...)
 	v = append(v, fmt.Sprintf("\"vbucket_uuid\":\"0x%016x\",", doc.VbUUID)...)
@@ -430,6 +549,49 @@ func validateXattrPath(op *SubDocOp) error {
 	return nil
 }
 
+// docTooDeep returns whether a JSON document is nested deeper than the engine
+// allows.  Values which are not JSON have no depth, so are never too deep.
+func (e *Engine) docTooDeep(value []byte) bool {
+	if e.maxDocDepth <= 0 || !json.Valid(value) {
+		return false
+	}
+
+	return jsonDepth(value) > e.maxDocDepth
+}
+
+// jsonDepth returns how deeply some valid JSON is nested, each object or array
+// adds a level, so scalars have a depth of 0.
+func jsonDepth(value []byte) int {
+	depth := 0
+	maxDepth := 0
+	inString := false
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		if inString {
+			if char == '\\' {
+				i++
+			} else if char == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch char {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+
+	return maxDepth
+}
+
 // SubDocExecutor is an executor for subdocument operations.
 type SubDocExecutor interface {
 	Execute(op *SubDocOp) (*SubDocResult, error)
@@ -751,7 +913,7 @@ func (e SubDocCounterExecutor) Execute(op *SubDocOp) (*SubDocResult, error) {
 		return e.itemErrorResult(err)
 	}
 
-	pathVal, err := docVal.GetByPath(op.Path, op.CreatePath, false)
+	pathVal, err := docVal.GetByPath(op.Path, op.CreatePath, true)
 	if err != nil {
 		return e.itemErrorResult(err)
 	}
@@ -762,10 +924,12 @@ func (e SubDocCounterExecutor) Execute(op *SubDocOp) (*SubDocResult, error) {
 		return e.itemErrorResult(err)
 	}
 
-	// TODO(brett19): Check the behaviour when the path doesn't exist.
-
+	// A counter which does not exist yet starts from zero, much like a dict
+	// upsert, its parents still need to exist unless MKDIR_P was specified.
 	val, err := pathVal.Get()
-	if err != nil {
+	if errors.Is(err, ErrSdPathNotFound) {
+		val = float64(0)
+	} else if err != nil {
 		return e.itemErrorResult(err)
 	}
 
@@ -992,8 +1156,12 @@ func (e SubDocArrayAddUniqueExecutor) Execute(op *SubDocOp) (*SubDocResult, erro
 		return e.itemErrorResult(err)
 	}
 
+	// The array is only missing here if MKDIR_P allowed the last component of
+	// the path to be created, in which case it is created empty.
 	val, err := pathVal.Get()
-	if err != nil {
+	if errors.Is(err, ErrSdPathNotFound) {
+		val = []interface{}{}
+	} else if err != nil {
 		return e.itemErrorResult(err)
 	}
 
@@ -1016,7 +1184,7 @@ func (e SubDocArrayAddUniqueExecutor) Execute(op *SubDocOp) (*SubDocResult, erro
 
 	arrVal = append(arrVal, valueObj)
 
-	err = pathVal.Replace(arrVal)
+	err = pathVal.Set(arrVal)
 	if err != nil {
 		return e.itemErrorResult(err)
 	}
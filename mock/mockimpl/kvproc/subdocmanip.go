@@ -33,6 +33,12 @@ func newSubDocManip(data []byte) (*subDocManip, error) {
 func (m *subDocManip) getByPathComp(comp *SubDocPathComponent, createPath bool) (*subDocManip, error) {
 	newRoot, err := m.Get()
 	if err == ErrSdPathNotFound && createPath {
+		// Only objects can be created along a path, there is no way to know
+		// what the other elements of a newly created array should be.
+		if comp.Path == "" {
+			return nil, ErrSdPathNotFound
+		}
+
 		newRoot = make(map[string]interface{})
 		err = m.Set(newRoot)
 		if err != nil {
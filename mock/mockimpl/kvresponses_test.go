@@ -0,0 +1,81 @@
+package mockimpl
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKvResponseTracking(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	cli := &kvClient{service: cluster.Nodes()[0].KvService().(*kvService)}
+
+	// Nothing has been requested yet, not even with the zero opaque.
+	assert.False(t, cli.completeRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: 0}))
+
+	cli.trackRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: 0})
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: 0}))
+	assert.False(t, cli.completeRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: 0}))
+
+	// Every stat is a response of its own, up until the terminating one.
+	cli.trackRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 7})
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 7, Key: []byte("a")}))
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 7, Key: []byte("b")}))
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 7}))
+	assert.False(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 7}))
+
+	// However many requests have been completed in between.
+	cli.trackRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 8})
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 8, Key: []byte("a")}))
+	for opaque := uint32(100); opaque < 2100; opaque++ {
+		cli.trackRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: opaque})
+		cli.completeRequest(&memd.Packet{Command: memd.CmdNoop, Opaque: opaque})
+	}
+	assert.True(t, cli.completeRequest(&memd.Packet{Command: memd.CmdStat, Opaque: 8}))
+}
+
+func TestKvResponsesAcrossReconnect(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	bucket.SyncWrites().SetCommitDuration(200 * time.Millisecond)
+	counters := cluster.RequestCounters()
+
+	// The durable write is only responded to once the connection it was
+	// requested on has dropped.
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication)
+	droppedOpaque := conn.send(testSetPacket(vbID, "key", true))
+	for !bucket.SyncWrites().IsPending(vbID, 0, []byte("key")) {
+		time.Sleep(time.Millisecond)
+	}
+	conn.Close()
+
+	// The reconnected client reuses the opaques of the old connection.
+	conn = dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication)
+	defer conn.Close()
+	retryOpaque := conn.send(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, droppedOpaque, retryOpaque)
+
+	resp := conn.read()
+	assert.Equal(t, memd.CmdNoop, resp.Command)
+	assert.Equal(t, retryOpaque, resp.Opaque)
+
+	_ = conn.conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+	_, _, err = conn.mconn.ReadPacket()
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected no further responses, got %v", err)
+	}
+
+	assert.Equal(t, uint64(1), counters.DroppedKvResponses())
+	assert.Equal(t, uint64(0), counters.LeakedKvResponses())
+}
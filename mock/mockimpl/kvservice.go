@@ -1,7 +1,14 @@
 package mockimpl
 
 import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"math/rand"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/contrib/scramserver"
@@ -19,6 +26,32 @@ type kvClient struct {
 	authenticatedUserName string
 	selectedBucketName    string
 	features              []memd.HelloFeature
+	closeCh               <-chan struct{}
+
+	// authStale is non-zero once the authentication of the client has been
+	// marked as stale, until the client authenticates again.
+	authStale uint32
+
+	// certAuthChecked is set once the client certificate of a TLS connection
+	// has been used to authenticate it.
+	certAuthChecked bool
+
+	// outstanding holds the requests which have not yet been fully responded
+	// to, keyed by their opaque, so that orphaned responses and responses
+	// which do not belong to the client can be identified.
+	outstandingLock sync.Mutex
+	outstanding     map[uint32]*kvOutstandingRequest
+
+	// replay holds the responses of a captured trace which have yet to be
+	// sent in place of processing requests.
+	replay []mock.KvTraceResponse
+}
+
+// kvOutstandingRequest is a request which a client has sent, and which has not
+// yet been sent its final response.
+type kvOutstandingRequest struct {
+	received  time.Time
+	responded bool
 }
 
 // LocalAddr returns the local address of this client.
@@ -47,6 +80,7 @@ func (c *kvClient) ScramServer() *scramserver.ScramServer {
 // SetAuthenticatedUserName sets the name of the user who is authenticated.
 func (c *kvClient) SetAuthenticatedUserName(userName string) {
 	c.authenticatedUserName = userName
+	atomic.StoreUint32(&c.authStale, 0)
 }
 
 // AuthenticatedUserName gets the name of the user who is authenticated.
@@ -54,10 +88,20 @@ func (c *kvClient) AuthenticatedUserName() string {
 	return c.authenticatedUserName
 }
 
+// MarkAuthStale marks the authentication of this client as stale.
+func (c *kvClient) MarkAuthStale() {
+	atomic.StoreUint32(&c.authStale, 1)
+}
+
+// IsAuthStale returns whether this client must authenticate again.
+func (c *kvClient) IsAuthStale() bool {
+	return atomic.LoadUint32(&c.authStale) != 0
+}
+
 // CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 func (c *kvClient) CheckAuthenticated(permission mockauth.Permission, collectionID uint32) bool {
-	user := c.service.Node().Cluster().Users().GetUser(c.AuthenticatedUserName())
-	if user == nil {
+	userName := c.AuthenticatedUserName()
+	if userName == "" {
 		return false
 	}
 
@@ -67,11 +111,7 @@ func (c *kvClient) CheckAuthenticated(permission mockauth.Permission, collection
 		scope, col = b.CollectionManifest().GetByID(collectionID)
 		bucket = b.Name()
 	}
-	if !user.HasPermission(permission, bucket, scope, col) {
-		return false
-	}
-
-	return true
+	return c.service.Node().Cluster().Authenticator().Authorize(userName, permission, bucket, scope, col)
 }
 
 // SetSelectedBucketName sets the currently selected bucket's name.
@@ -107,12 +147,95 @@ func (c *kvClient) Source() mock.KvService {
 	return c.service
 }
 
+// GetContext gets arbitrary context associated with this client.
+func (c *kvClient) GetContext(valuePtr interface{}) {
+	c.client.GetContext(valuePtr)
+}
+
 // WritePacket tries to write data to the underlying connection.
 func (c *kvClient) WritePacket(pak *memd.Packet) error {
+	if c.client == nil {
+		return errors.New("client is disconnected")
+	}
 	if !c.service.clusterNode.cluster.handleKvPacketOut(c, pak) {
 		return nil
 	}
-	return c.client.WritePacket(pak)
+	if pak.Magic == memd.CmdMagicRes {
+		// Responses are scoped to the connection their request arrived on,
+		// so those which are ready after it has closed are never delivered,
+		// even if the client has since reconnected.
+		select {
+		case <-c.closeCh:
+			c.service.clusterNode.cluster.requestCounts.CountDroppedKvResponse()
+			return errors.New("client is disconnected")
+		default:
+		}
+
+		if !c.completeRequest(pak) {
+			c.service.clusterNode.cluster.requestCounts.CountLeakedKvResponse()
+			return errors.New("response does not belong to this client")
+		}
+	}
+
+	err := c.client.WritePacket(pak)
+	if err != nil && pak.Magic == memd.CmdMagicRes {
+		c.service.clusterNode.cluster.requestCounts.CountDroppedKvResponse()
+	}
+	return err
+}
+
+// trackRequest records that a request has been received and is outstanding.
+func (c *kvClient) trackRequest(pak *memd.Packet) {
+	c.outstandingLock.Lock()
+	if c.outstanding == nil {
+		c.outstanding = make(map[uint32]*kvOutstandingRequest)
+	}
+	c.outstanding[pak.Opaque] = &kvOutstandingRequest{
+		received: time.Now(),
+	}
+	c.outstandingLock.Unlock()
+}
+
+// isFinalKvResponse returns whether a response is the last one which is sent
+// to its request.  Stats are sent as a response per stat, which ends with a
+// response without a key, every other command is sent a single response.
+func isFinalKvResponse(pak *memd.Packet) bool {
+	if pak.Command == memd.CmdStat && pak.Status == memd.StatusSuccess {
+		return len(pak.Key) == 0
+	}
+	return true
+}
+
+// completeRequest records that a request has been responded to, counting the
+// response as orphaned if the client would already have given up on it.  Only
+// the first response to a request counts.  It returns false if the client
+// has no outstanding request which is being responded to.
+func (c *kvClient) completeRequest(pak *memd.Packet) bool {
+	c.outstandingLock.Lock()
+	req, ok := c.outstanding[pak.Opaque]
+	if !ok {
+		c.outstandingLock.Unlock()
+		return false
+	}
+
+	isFirstResponse := !req.responded
+	req.responded = true
+	if isFinalKvResponse(pak) {
+		delete(c.outstanding, pak.Opaque)
+	}
+	c.outstandingLock.Unlock()
+
+	cluster := c.service.clusterNode.cluster
+	timeout := cluster.KvOrphanTimeout()
+	if isFirstResponse && timeout > 0 && time.Since(req.received) > timeout {
+		cluster.requestCounts.CountOrphanedKvResponse(pak.Command)
+	}
+	return true
+}
+
+// CloseNotify returns a channel which is closed once this client has disconnected.
+func (c *kvClient) CloseNotify() <-chan struct{} {
+	return c.closeCh
 }
 
 // Close attempts to close the connection.
@@ -123,18 +246,35 @@ func (c *kvClient) Close() error {
 // kvService represents an instance of the kv service.
 type kvService struct {
 	clusterNode *clusterNodeInst
-	server      *servers.MemdServer
-	tlsServer   *servers.MemdServer
+	serviceServers
+
+	latencyLock sync.Mutex
+	latencyRng  *rand.Rand
+	latencies   map[memd.CmdCode]mock.LatencyDistribution
+
+	hangLock sync.Mutex
+	hangs    map[memd.CmdCode]mock.KvCommandHang
+
+	replayLock   sync.Mutex
+	pendingTrace []mock.KvTraceResponse
 }
 
 // newKvServiceOptions enables the specification of default options for a new kv service.
 type newKvServiceOptions struct {
+	IdleTimeout        time.Duration
+	WriteFragmentSize  int
+	WriteBufferSize    int
+	WriteFlushInterval time.Duration
+	LatencySeed        int64
 }
 
 // newKvService instantiates a new instance of the kv service.
 func newKvService(parent *clusterNodeInst, opts newKvServiceOptions) (*kvService, error) {
 	svc := &kvService{
 		clusterNode: parent,
+		latencyRng:  rand.New(rand.NewSource(opts.LatencySeed)),
+		latencies:   make(map[memd.CmdCode]mock.LatencyDistribution),
+		hangs:       make(map[memd.CmdCode]mock.KvCommandHang),
 	}
 
 	srv, err := servers.NewMemdService(servers.NewMemdServerOptions{
@@ -143,6 +283,10 @@ func newKvService(parent *clusterNodeInst, opts newKvServiceOptions) (*kvService
 			LostClientHandler: svc.handleLostMemdClient,
 			PacketHandler:     svc.handleMemdPacket,
 		},
+		IdleTimeout:        opts.IdleTimeout,
+		WriteFragmentSize:  opts.WriteFragmentSize,
+		WriteBufferSize:    opts.WriteBufferSize,
+		WriteFlushInterval: opts.WriteFlushInterval,
 	})
 	if err != nil {
 		return nil, err
@@ -152,11 +296,16 @@ func newKvService(parent *clusterNodeInst, opts newKvServiceOptions) (*kvService
 	if parent.HasFeature(mock.ClusterNodeFeatureTLS) {
 		tlsSrv, err := servers.NewMemdService(servers.NewMemdServerOptions{
 			Handlers: servers.MemdServerHandlers{
-				NewClientHandler:  svc.handleNewTLSMemdClient,
-				LostClientHandler: svc.handleLostMemdClient,
-				PacketHandler:     svc.handleMemdPacket,
+				NewClientHandler:    svc.handleNewTLSMemdClient,
+				LostClientHandler:   svc.handleLostMemdClient,
+				PacketHandler:       svc.handleMemdPacket,
+				TLSHandshakeHandler: svc.handleMemdTLSHandshake,
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig:          parent.cluster.tlsConfig,
+			IdleTimeout:        opts.IdleTimeout,
+			WriteFragmentSize:  opts.WriteFragmentSize,
+			WriteBufferSize:    opts.WriteBufferSize,
+			WriteFlushInterval: opts.WriteFlushInterval,
 		})
 		if err != nil {
 			return nil, err
@@ -174,58 +323,167 @@ func (s *kvService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *kvService) Hostname() string {
-	return "127.0.0.1"
+	return s.clusterNode.Hostname()
 }
 
-// ListenPort returns the port this service is listening on.
-func (s *kvService) ListenPort() int {
-	if s.server == nil {
-		return -1
+// GetAllClients returns a list of all the clients connected to this service.
+func (s *kvService) GetAllClients() []mock.KvClient {
+	var allKvClients []mock.KvClient
+	for _, srv := range []serviceServer{s.server, s.tlsServer} {
+		memdSrv, ok := srv.(*servers.MemdServer)
+		if !ok {
+			continue
+		}
+
+		for _, client := range memdSrv.GetAllClients() {
+			allKvClients = append(allKvClients, s.getKvClient(client))
+		}
 	}
-	return s.server.ListenPort()
+
+	return allKvClients
 }
 
-// ListenPortTLS returns the TLS port this service is listening on.
-func (s *kvService) ListenPortTLS() int {
-	if s.tlsServer == nil {
-		return -1
+// SetCommandLatency makes requests for a specific command wait for a latency
+// sampled from a distribution before being processed.
+func (s *kvService) SetCommandLatency(cmd memd.CmdCode, dist *mock.LatencyDistribution) error {
+	s.latencyLock.Lock()
+	defer s.latencyLock.Unlock()
+
+	if dist == nil {
+		delete(s.latencies, cmd)
+		return nil
 	}
-	return s.tlsServer.ListenPort()
+
+	if err := dist.Validate(); err != nil {
+		return err
+	}
+
+	s.latencies[cmd] = *dist
+	return nil
 }
 
-// GetAllClients returns a list of all the clients connected to this service.
-func (s *kvService) GetAllClients() []mock.KvClient {
-	var allKvClients []mock.KvClient
+// SetLatencySeed reseeds the random source used to sample latencies.
+func (s *kvService) SetLatencySeed(seed int64) {
+	s.latencyLock.Lock()
+	s.latencyRng = rand.New(rand.NewSource(seed))
+	s.latencyLock.Unlock()
+}
 
-	if s.server != nil {
-		allClients := s.server.GetAllClients()
-		for _, client := range allClients {
-			kvCli := s.getKvClient(client)
-			allKvClients = append(allKvClients, kvCli)
-		}
+// sampleLatency returns how long a request for a command should wait before
+// being processed.
+func (s *kvService) sampleLatency(cmd memd.CmdCode) time.Duration {
+	s.latencyLock.Lock()
+	defer s.latencyLock.Unlock()
+
+	dist, ok := s.latencies[cmd]
+	if !ok {
+		return 0
 	}
 
-	if s.tlsServer != nil {
-		allClients := s.tlsServer.GetAllClients()
-		for _, client := range allClients {
-			kvCli := s.getKvClient(client)
-			allKvClients = append(allKvClients, kvCli)
-		}
+	return dist.Sample(s.latencyRng)
+}
+
+// SetCommandHang makes requests for a specific command hang instead of being
+// processed.
+func (s *kvService) SetCommandHang(cmd memd.CmdCode, hang *mock.KvCommandHang) error {
+	s.hangLock.Lock()
+	defer s.hangLock.Unlock()
+
+	if hang == nil {
+		delete(s.hangs, cmd)
+		return nil
 	}
 
-	return allKvClients
+	if err := hang.Validate(); err != nil {
+		return err
+	}
+
+	s.hangs[cmd] = *hang
+	return nil
 }
 
-// Close will shut down this service once it is no longer needed.
-func (s *kvService) Close() error {
-	var errOut error
-	if s.server != nil {
-		errOut = s.server.Close()
+// commandHang returns how requests for a command should hang, if at all.
+func (s *kvService) commandHang(cmd memd.CmdCode) (mock.KvCommandHang, bool) {
+	s.hangLock.Lock()
+	defer s.hangLock.Unlock()
+
+	hang, ok := s.hangs[cmd]
+	return hang, ok
+}
+
+// ReplayTrace makes the next connection to this service answer its requests
+// with the responses of a captured trace.
+func (s *kvService) ReplayTrace(trace []mock.KvTraceResponse) {
+	s.replayLock.Lock()
+	s.pendingTrace = trace
+	s.replayLock.Unlock()
+}
+
+// takeTrace hands the pending trace, if any, to a new connection.
+func (s *kvService) takeTrace() []mock.KvTraceResponse {
+	s.replayLock.Lock()
+	defer s.replayLock.Unlock()
+
+	trace := s.pendingTrace
+	s.pendingTrace = nil
+	return trace
+}
+
+// replayResponse answers a request with the next response of the trace being
+// replayed on a connection, returning false once the trace is exhausted.
+func (s *kvService) replayResponse(cli *kvClient, pak *memd.Packet) bool {
+	if len(cli.replay) == 0 {
+		return false
+	}
+
+	resp := cli.replay[0]
+	cli.replay = cli.replay[1:]
+
+	if resp.Delay > 0 {
+		time.Sleep(resp.Delay)
 	}
-	if s.tlsServer != nil {
-		errOut = s.tlsServer.Close()
+
+	err := cli.WritePacket(&memd.Packet{
+		Magic:    memd.CmdMagicRes,
+		Command:  resp.Command,
+		Datatype: resp.Datatype,
+		Status:   resp.Status,
+		Vbucket:  pak.Vbucket,
+		Opaque:   pak.Opaque,
+		Cas:      resp.Cas,
+		Key:      resp.Key,
+		Extras:   resp.Extras,
+		Value:    resp.Value,
+	})
+	if err != nil {
+		log.Printf("failed to write replayed kv response: %s", err)
 	}
-	return errOut
+	return true
+}
+
+// authenticateClientCert authenticates a TLS connection as the user its client
+// certificate maps to, returning false if the connection must be rejected.
+func (s *kvService) authenticateClientCert(cli *kvClient) bool {
+	settings := s.clusterNode.cluster.ClientCertAuthSettings()
+	if settings.State == mock.ClientCertAuthStateDisable {
+		return true
+	}
+
+	// Mandatory certificates are already enforced during the handshake.
+	peerCerts := cli.client.PeerCertificates()
+	if len(peerCerts) == 0 {
+		return true
+	}
+
+	userName := settings.UserName(peerCerts[0])
+	if userName == "" {
+		// Clients may still authenticate with credentials, unless
+		// certificates are mandatory.
+		return settings.State != mock.ClientCertAuthStateMandatory
+	}
+
+	cli.SetAuthenticatedUserName(userName)
+	return true
 }
 
 func (s *kvService) getKvClient(cli *servers.MemdClient) *kvClient {
@@ -239,6 +497,12 @@ func (s *kvService) handleNewMemdClient(cli *servers.MemdClient) {
 	kvCli.client = cli
 	kvCli.service = s
 	kvCli.isTLS = false
+	kvCli.closeCh = cli.CloseNotify()
+	kvCli.replay = s.takeTrace()
+}
+
+func (s *kvService) handleMemdTLSHandshake(cli *servers.MemdClient, state tls.ConnectionState) {
+	s.clusterNode.cluster.recordTLSHandshake(mock.ServiceTypeKeyValue, cli.LocalAddr(), cli.RemoteAddr(), state)
 }
 
 func (s *kvService) handleNewTLSMemdClient(cli *servers.MemdClient) {
@@ -246,11 +510,16 @@ func (s *kvService) handleNewTLSMemdClient(cli *servers.MemdClient) {
 	kvCli.client = cli
 	kvCli.service = s
 	kvCli.isTLS = true
+	kvCli.closeCh = cli.CloseNotify()
+	kvCli.replay = s.takeTrace()
 }
 
 func (s *kvService) handleLostMemdClient(cli *servers.MemdClient) {
+	// The client is otherwise left as it is, as requests which are still
+	// being processed may yet respond to it.  Those responses are dropped
+	// once they are written, as the connection has closed by then.
 	kvCli := s.getKvClient(cli)
-	kvCli.client = nil
+	s.clusterNode.cluster.KvConnectionLimits().Release(kvCli)
 }
 
 func (s *kvService) handleMemdPacket(cli *servers.MemdClient, pak *memd.Packet) {
@@ -259,5 +528,72 @@ func (s *kvService) handleMemdPacket(cli *servers.MemdClient, pak *memd.Packet)
 		return
 	}
 
+	// The handshake has completed by the time the first packet arrives, so
+	// the client certificate is checked then.
+	if kvCli.isTLS && !kvCli.certAuthChecked {
+		kvCli.certAuthChecked = true
+		if !s.authenticateClientCert(kvCli) {
+			if err := kvCli.Close(); err != nil {
+				log.Printf("failed to close kv connection without a mapped certificate: %s", err)
+			}
+			return
+		}
+	}
+
+	// This delays all further requests on the same connection as well, the
+	// same way a slow request would hold up a real connection.
+	if pak.Magic == memd.CmdMagicReq {
+		kvCli.trackRequest(pak)
+
+		// A replayed trace stands in for the server entirely, so none of
+		// the other simulated behaviours apply to it.
+		if s.replayResponse(kvCli, pak) {
+			return
+		}
+
+		s.clusterNode.waitIfPaused()
+
+		faults := s.clusterNode.cluster.kvRequestFaults(kvCli, pak)
+
+		if latency := s.sampleLatency(pak.Command) + faults.Latency; latency > 0 {
+			time.Sleep(latency)
+		}
+
+		// Unlike latency, a hung request is never processed at all.  The
+		// requests which follow it on the connection are still processed
+		// until the connection is closed, leaving the hung one orphaned.
+		hang, ok := s.commandHang(pak.Command)
+		if faults.Hang != nil {
+			hang, ok = *faults.Hang, true
+		}
+		if ok {
+			if hang.Action == mock.KvHangActionClose {
+				go func() {
+					select {
+					case <-time.After(hang.Timeout):
+						if err := kvCli.Close(); err != nil {
+							log.Printf("failed to close hung kv connection: %s", err)
+						}
+					case <-kvCli.closeCh:
+					}
+				}()
+			}
+			return
+		}
+
+		if faults.Status != memd.StatusSuccess {
+			err := kvCli.WritePacket(&memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+				Status:  faults.Status,
+			})
+			if err != nil {
+				log.Printf("failed to write injected kv error: %s", err)
+			}
+			return
+		}
+	}
+
 	s.clusterNode.cluster.handleKvPacketIn(kvCli, pak)
 }
@@ -0,0 +1,58 @@
+package mockimpl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestStagger(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	cluster, err := NewDefaultClusterWithOptions(mock.NewClusterOptions{
+		Chrono: chrono,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	_, err = cluster.AddNode(mock.NewNodeOptions{})
+	if err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	nodes := cluster.Nodes()
+	bucket := cluster.GetBucket("default")
+
+	// The stagger may be changed while nodes are reading it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			bucket.NodeManifestTime(nodes[1])
+		}
+	}()
+	bucket.SetManifestStagger(time.Minute)
+	wg.Wait()
+
+	manifest := bucket.CollectionManifest()
+	oldUID, _ := manifest.GetManifest()
+	newUID, err := manifest.AddScope("scope")
+	if err != nil {
+		t.Fatalf("failed to add scope: %v", err)
+	}
+
+	// Each node lags behind the previous one by the stagger, as measured by
+	// the clock of the cluster rather than the wall clock.
+	uid, _ := manifest.GetManifestAt(bucket.NodeManifestTime(nodes[0]))
+	assert.Equal(t, newUID, uid)
+	uid, _ = manifest.GetManifestAt(bucket.NodeManifestTime(nodes[1]))
+	assert.Equal(t, oldUID, uid)
+
+	chrono.TimeTravel(time.Minute)
+
+	uid, _ = manifest.GetManifestAt(bucket.NodeManifestTime(nodes[1]))
+	assert.Equal(t, newUID, uid)
+}
@@ -9,8 +9,7 @@ import (
 // queryService represents a Querys service running somewhere in the cluster.
 type queryService struct {
 	clusterNode *clusterNodeInst
-	server      *servers.HTTPServer
-	tlsServer   *servers.HTTPServer
+	serviceServers
 }
 
 type newQueryServiceOptions struct {
@@ -26,6 +25,7 @@ func newQueryService(parent *clusterNodeInst, opts newQueryServiceOptions) (*que
 		Handlers: servers.HTTPServerHandlers{
 			NewRequestHandler: svc.handleNewRequest,
 		},
+		EnableCORS: parent.HasFeature(mock.ClusterNodeFeatureCORS),
 	})
 	if err != nil {
 		return nil, err
@@ -36,9 +36,11 @@ func newQueryService(parent *clusterNodeInst, opts newQueryServiceOptions) (*que
 		tlsSrv, err := servers.NewHTTPServer(servers.NewHTTPServiceOptions{
 			Name: "query",
 			Handlers: servers.HTTPServerHandlers{
-				NewRequestHandler: svc.handleNewRequest,
+				NewRequestHandler:   svc.handleNewRequest,
+				TLSHandshakeHandler: parent.cluster.tlsHandshakeHandler(mock.ServiceTypeQuery),
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig:  parent.cluster.tlsConfig,
+			EnableCORS: parent.HasFeature(mock.ClusterNodeFeatureCORS),
 		})
 		if err != nil {
 			return nil, err
@@ -56,43 +58,16 @@ func (s *queryService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *queryService) Hostname() string {
-	return "127.0.0.1"
-}
-
-// ListenPort returns the port this service is listening on.
-func (s *queryService) ListenPort() int {
-	if s.server == nil {
-		return -1
-	}
-	return s.server.ListenPort()
-}
-
-// ListenPortTLS returns the TLS port this service is listening on.
-func (s *queryService) ListenPortTLS() int {
-	if s.tlsServer == nil {
-		return -1
-	}
-	return s.tlsServer.ListenPort()
+	return s.clusterNode.Hostname()
 }
 
 func (s *queryService) handleNewRequest(req *mock.HTTPRequest) *mock.HTTPResponse {
+	s.clusterNode.waitIfPaused()
 	return s.clusterNode.cluster.handleQueryRequest(s, req)
 }
 
-// Close will shut down this service once it is no longer needed.
-func (s *queryService) Close() error {
-	var errOut error
-	if s.server != nil {
-		errOut = s.server.Close()
-	}
-	if s.tlsServer != nil {
-		errOut = s.tlsServer.Close()
-	}
-	return errOut
-}
-
 // CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 func (s *queryService) CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string,
 	req *mock.HTTPRequest) bool {
-	return checkHTTPAuthenticated(permission, bucket, scope, collection, req, s.Node().Cluster().Users())
+	return checkHTTPAuthenticated(permission, bucket, scope, collection, req, s.Node().Cluster())
 }
@@ -0,0 +1,151 @@
+package servers
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// coalescingConn queues writes and sends them from a background writer, so
+// that packets written while a previous write is in progress are combined
+// into a single write, reducing the number of syscalls under high op rates.
+// Writes are always sent in the order they were queued.
+type coalescingConn struct {
+	net.Conn
+
+	// bufferSize is the number of queued bytes at which the writer stops
+	// waiting for the flush interval and writes immediately.
+	bufferSize int
+
+	// flushInterval is how long the writer waits for further writes to
+	// be queued before writing.  Zero only coalesces the writes which were
+	// queued while the previous write was in progress.
+	flushInterval time.Duration
+
+	lock    sync.Mutex
+	pending []byte
+	spare   []byte
+	err     error
+
+	// writeLock serializes writes to the underlying connection, so that
+	// explicit flushes cannot interleave with the background writer.
+	writeLock sync.Mutex
+
+	signalCh  chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func newCoalescingConn(conn net.Conn, bufferSize int, flushInterval time.Duration) *coalescingConn {
+	c := &coalescingConn{
+		Conn:          conn,
+		bufferSize:    bufferSize,
+		flushInterval: flushInterval,
+		signalCh:      make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+	}
+
+	go c.writeLoop()
+
+	return c
+}
+
+// Write queues data to be written by the background writer.  Errors from
+// previous writes are returned, as queued data cannot report its own.
+func (c *coalescingConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	if c.err != nil {
+		err := c.err
+		c.lock.Unlock()
+		return 0, err
+	}
+	c.pending = append(c.pending, b...)
+	c.lock.Unlock()
+
+	select {
+	case c.signalCh <- struct{}{}:
+	default:
+	}
+
+	return len(b), nil
+}
+
+func (c *coalescingConn) isFull() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.pending) >= c.bufferSize
+}
+
+func (c *coalescingConn) writeLoop() {
+	for {
+		select {
+		case <-c.signalCh:
+		case <-c.closeCh:
+			return
+		}
+
+		if c.flushInterval > 0 {
+			timer := time.NewTimer(c.flushInterval)
+		WaitLoop:
+			for !c.isFull() {
+				select {
+				case <-timer.C:
+					break WaitLoop
+				case <-c.signalCh:
+				case <-c.closeCh:
+					timer.Stop()
+					return
+				}
+			}
+			timer.Stop()
+		}
+
+		_ = c.Flush()
+	}
+}
+
+// Flush writes all of the queued data to the underlying connection.  A failed
+// write closes the connection, and fails all future writes.
+func (c *coalescingConn) Flush() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.lock.Lock()
+	if c.err != nil {
+		err := c.err
+		c.lock.Unlock()
+		return err
+	}
+	// Nothing is swapped out when there is nothing to write, as the pending
+	// buffer must never share its backing array with the spare one.
+	if len(c.pending) == 0 {
+		c.lock.Unlock()
+		return nil
+	}
+	buf := c.pending
+	c.pending = c.spare[:0]
+	c.lock.Unlock()
+
+	_, err := c.Conn.Write(buf)
+
+	c.lock.Lock()
+	if err != nil {
+		c.err = err
+	}
+	// The buffer can only be reused once the write has finished with it.
+	c.spare = buf
+	c.lock.Unlock()
+
+	if err != nil {
+		_ = c.Conn.Close()
+	}
+	return err
+}
+
+// Stop stops the background writer, discarding anything which is queued.
+func (c *coalescingConn) Stop() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+}
@@ -2,12 +2,14 @@ package servers
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"sync"
 
 	"github.com/couchbaselabs/gocaves/mock"
 )
@@ -15,6 +17,10 @@ import (
 // HTTPServerHandlers provides all the handlers for the http server
 type HTTPServerHandlers struct {
 	NewRequestHandler func(*mock.HTTPRequest) *mock.HTTPResponse
+
+	// TLSHandshakeHandler is invoked once a TLS connection has completed its
+	// handshake, with the parameters which were negotiated.  It is optional.
+	TLSHandshakeHandler func(net.Conn, tls.ConnectionState)
 }
 
 // HTTPServer is a generic implementation of an HTTP server used by
@@ -27,6 +33,7 @@ type HTTPServer struct {
 	handlers   HTTPServerHandlers
 	server     *http.Server
 	tlsConfig  *tls.Config
+	enableCORS bool
 }
 
 // NewHTTPServiceOptions enables the specification of default options for a new http server.
@@ -34,14 +41,19 @@ type NewHTTPServiceOptions struct {
 	Name      string
 	Handlers  HTTPServerHandlers
 	TLSConfig *tls.Config
+
+	// EnableCORS causes OPTIONS preflight requests to be answered directly by
+	// the server, and CORS headers to be included in all responses.
+	EnableCORS bool
 }
 
 // NewHTTPServer instantiates a new instance of the memd server.
 func NewHTTPServer(opts NewHTTPServiceOptions) (*HTTPServer, error) {
 	svc := &HTTPServer{
-		name:      opts.Name,
-		handlers:  opts.Handlers,
-		tlsConfig: opts.TLSConfig,
+		name:       opts.Name,
+		handlers:   opts.Handlers,
+		tlsConfig:  opts.TLSConfig,
+		enableCORS: opts.EnableCORS,
 	}
 
 	err := svc.start()
@@ -94,11 +106,14 @@ func (s *HTTPServer) start() error {
 	srv := &http.Server{
 		Handler: http.HandlerFunc(s.handleHTTP),
 	}
+	if s.tlsConfig != nil && s.handlers.TLSHandshakeHandler != nil {
+		srv.ConnState = s.newTLSConnStateHandler()
+	}
 	s.server = srv
 
 	log.Printf("starting listener for %s (http) server on port %d", s.serviceName(), s.listenPort)
 	go func() {
-		err := srv.Serve(s.listener)
+		err := srv.Serve(lsnr)
 		if err != nil {
 			log.Printf("listener for http `%s` failed to serve: %s", s.serviceName(), err)
 		}
@@ -107,6 +122,49 @@ func (s *HTTPServer) start() error {
 	return nil
 }
 
+// newTLSConnStateHandler returns a connection state hook which reports the
+// handshake of each TLS connection.  The handshake is complete by the time the
+// first request on a connection becomes active, so each connection is only
+// reported when it first becomes active.
+func (s *HTTPServer) newTLSConnStateHandler() func(net.Conn, http.ConnState) {
+	var lock sync.Mutex
+	activeConns := make(map[net.Conn]struct{})
+
+	return func(conn net.Conn, state http.ConnState) {
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return
+		}
+
+		lock.Lock()
+		_, seen := activeConns[conn]
+		switch state {
+		case http.StateActive:
+			activeConns[conn] = struct{}{}
+		case http.StateClosed, http.StateHijacked:
+			delete(activeConns, conn)
+		}
+		lock.Unlock()
+
+		if state != http.StateActive || seen {
+			return
+		}
+
+		s.handlers.TLSHandshakeHandler(conn, tlsConn.ConnectionState())
+	}
+}
+
+// Rebind drops all connections and starts listening again on a new port, as
+// if the server had been restarted.
+func (s *HTTPServer) Rebind() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	s.listenPort = 0
+	return s.start()
+}
+
 // Close will stop this HTTP server
 func (s *HTTPServer) Close() error {
 	if s.server == nil {
@@ -125,7 +183,49 @@ func (s *HTTPServer) Close() error {
 	return nil
 }
 
+// writeCORSHeaders writes the headers allowing the request origin to access
+// the response.  Preflight requests additionally have the method and headers
+// they asked for allowed.
+func (s *HTTPServer) writeCORSHeaders(w http.ResponseWriter, req *http.Request) {
+	// Browsers reject credentials for a wildcard origin, so they are only
+	// allowed when the origin of the request can be echoed back.
+	origin := req.Header.Get("Origin")
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.Header().Add("Vary", "Origin")
+
+	if req.Method == http.MethodOptions {
+		allowMethods := req.Header.Get("Access-Control-Request-Method")
+		if allowMethods == "" {
+			allowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+		}
+
+		allowHeaders := req.Header.Get("Access-Control-Request-Headers")
+		if allowHeaders == "" {
+			allowHeaders = "Authorization, Content-Type"
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+}
+
 func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.enableCORS {
+		s.writeCORSHeaders(w, req)
+
+		// Preflight requests never reach the handlers.
+		if req.Method == http.MethodOptions {
+			w.WriteHeader(204)
+			return
+		}
+	}
+
 	if err := req.ParseForm(); err != nil {
 		// If the content type isn't form then ParseForm will not error, to get here something
 		// is wrong with the request.
@@ -138,6 +238,11 @@ func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	var peerCerts []*x509.Certificate
+	if req.TLS != nil {
+		peerCerts = req.TLS.PeerCertificates
+	}
+
 	resp := s.handlers.NewRequestHandler(&mock.HTTPRequest{
 		IsTLS:   s.tlsConfig != nil,
 		Method:  req.Method,
@@ -147,6 +252,9 @@ func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
 		Form:    req.Form,
 		Context: req.Context(),
 		Flusher: flusher,
+		Host:    req.Host,
+
+		PeerCertificates: peerCerts,
 	})
 	if resp == nil {
 		// If nobody decides to answer the request, we write 501 Unsupported.
@@ -161,8 +269,27 @@ func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.WriteHeader(resp.StatusCode)
-	_, err := io.Copy(w, resp.Body)
+
+	var dst io.Writer = w
+	if resp.Streaming {
+		dst = &flushingWriter{w: w, flusher: flusher}
+	}
+
+	_, err := io.Copy(dst, resp.Body)
 	if err != nil {
 		log.Printf("failed to write http response: %s", err)
 	}
 }
+
+// flushingWriter flushes every write to the client immediately, so that the
+// parts of a streaming response are received as soon as they are written.
+type flushingWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (w *flushingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.flusher.Flush()
+	return n, err
+}
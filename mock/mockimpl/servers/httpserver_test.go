@@ -0,0 +1,64 @@
+package servers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPCORSHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, err := NewHTTPServer(NewHTTPServiceOptions{
+		Handlers: HTTPServerHandlers{
+			NewRequestHandler: func(req *mock.HTTPRequest) *mock.HTTPResponse {
+				return &mock.HTTPResponse{
+					StatusCode: 200,
+					Body:       bytes.NewReader([]byte("{}")),
+				}
+			},
+		},
+		EnableCORS: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start http server: %v", err)
+	}
+	defer svc.Close()
+
+	doRequest := func(method, origin string) *http.Response {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d/", svc.ListenPort()), nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Requests from an origin have it echoed back, along with credentials.
+	resp := doRequest("GET", "http://example.com")
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("http://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	// A wildcard origin never allows credentials.
+	resp = doRequest("GET", "")
+	assert.Equal("*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	// Preflight requests are answered without reaching the handler.
+	resp = doRequest("OPTIONS", "http://example.com")
+	assert.Equal(204, resp.StatusCode)
+	assert.Equal("http://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.NotEmpty(resp.Header.Get("Access-Control-Allow-Methods"))
+}
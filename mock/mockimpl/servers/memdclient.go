@@ -1,8 +1,13 @@
 package servers
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"log"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/contrib/ctxstore"
@@ -15,22 +20,77 @@ type MemdClient struct {
 	mconn    *memd.Conn
 	ctxStore ctxstore.Store
 
+	// altRequestsEnabled is set once the client has negotiated support for
+	// the alternate request magic.  It is accessed atomically.
+	altRequestsEnabled uint32
+
+	// writer coalesces the packets written to the client, and is nil unless
+	// write coalescing is enabled.
+	writer *coalescingConn
+
 	closeWaitCh chan struct{}
 }
 
+// fragmentingConn splits every write into separate writes of a maximum size,
+// so that packets are delivered across many TCP segments.
+type fragmentingConn struct {
+	net.Conn
+	fragmentSize int
+}
+
+func (c *fragmentingConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := written + c.fragmentSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// hasFrameExtras returns whether a packet was sent with any frame extras.
+func hasFrameExtras(pak *memd.Packet) bool {
+	return pak.BarrierFrame != nil ||
+		pak.DurabilityLevelFrame != nil ||
+		pak.DurabilityTimeoutFrame != nil ||
+		pak.StreamIDFrame != nil ||
+		pak.OpenTracingFrame != nil ||
+		pak.ServerDurationFrame != nil ||
+		len(pak.UnsupportedFrames) > 0
+}
+
 // NewMemdClient allows the creation of a new memd client
 func newMemdClient(parent *MemdServer, conn net.Conn) (*MemdClient, error) {
-	mconn := memd.NewConn(conn)
+	writeConn := conn
+	if parent.writeFragmentSize > 0 {
+		writeConn = &fragmentingConn{
+			Conn:         writeConn,
+			fragmentSize: parent.writeFragmentSize,
+		}
+	}
+
+	var writer *coalescingConn
+	if parent.writeBufferSize > 0 {
+		writer = newCoalescingConn(writeConn, parent.writeBufferSize, parent.writeFlushInterval)
+		writeConn = writer
+	}
 
 	cli := &MemdClient{
 		parent: parent,
 		conn:   conn,
-		mconn:  mconn,
-	}
-
-	err := cli.start()
-	if err != nil {
-		return nil, err
+		mconn:  memd.NewConn(writeConn),
+		writer: writer,
+		// The close channel exists from the start, so that it can be waited
+		// on as soon as the client has been reported as connected.
+		closeWaitCh: make(chan struct{}),
 	}
 
 	return cli, nil
@@ -46,6 +106,38 @@ func (c *MemdClient) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// PeerCertificates returns the certificates presented by the client, which
+// are only available on TLS connections once the handshake has completed.
+func (c *MemdClient) PeerCertificates() []*x509.Certificate {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return tlsConn.ConnectionState().PeerCertificates
+}
+
+// handshakeTLS completes the handshake of a TLS connection before anything is
+// read from it, so that the negotiated parameters are known upfront.  A failed
+// handshake closes the connection.
+func (c *MemdClient) handshakeTLS() {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+
+	if c.parent.idleTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.parent.idleTimeout))
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("tls handshake with memd client %s failed: %s", c.RemoteAddr(), err)
+		_ = c.conn.Close()
+		return
+	}
+
+	c.parent.handleClientTLSHandshake(c, tlsConn.ConnectionState())
+}
+
 // WritePacket writes a packet to the connection.
 func (c *MemdClient) WritePacket(pak *memd.Packet) error {
 	// In order to support various hello features, we detect when there is a hello response
@@ -57,6 +149,10 @@ func (c *MemdClient) WritePacket(pak *memd.Packet) error {
 			featureCodeID := binary.BigEndian.Uint16(pak.Value[featureIdx*2:])
 			featureCode := memd.HelloFeature(featureCodeID)
 			c.mconn.EnableFeature(featureCode)
+
+			if featureCode == memd.FeatureAltRequests {
+				atomic.StoreUint32(&c.altRequestsEnabled, 1)
+			}
 		}
 	}
 
@@ -66,18 +162,45 @@ func (c *MemdClient) WritePacket(pak *memd.Packet) error {
 }
 
 func (c *MemdClient) start() error {
-	c.closeWaitCh = make(chan struct{})
-
 	go func() {
+		c.handshakeTLS()
+
 		for {
+			// Connections which do not send anything within the idle timeout
+			// are closed by the server.
+			if c.parent.idleTimeout > 0 {
+				_ = c.conn.SetReadDeadline(time.Now().Add(c.parent.idleTimeout))
+			}
+
 			pak, _, err := c.mconn.ReadPacket()
 			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					c.parent.handleClientIdle(c)
+					c.flushWrites()
+					_ = c.conn.Close()
+				}
 				break
 			}
 
+			if pak.Magic == memd.CmdMagicReq && hasFrameExtras(pak) && atomic.LoadUint32(&c.altRequestsEnabled) == 0 {
+				// Frame extras can only be sent using the alternate request magic,
+				// which the client must first have negotiated through HELLO.
+				_ = c.WritePacket(&memd.Packet{
+					Magic:   memd.CmdMagicRes,
+					Command: pak.Command,
+					Opaque:  pak.Opaque,
+					Status:  memd.StatusInvalidArgs,
+				})
+				continue
+			}
+
 			c.parent.handleClientRequest(c, pak)
 		}
 
+		if c.writer != nil {
+			c.writer.Stop()
+		}
+
 		c.parent.handleClientDisconnect(c)
 
 		close(c.closeWaitCh)
@@ -86,9 +209,19 @@ func (c *MemdClient) start() error {
 	return nil
 }
 
+// flushWrites writes any packets which are still waiting to be coalesced, so
+// that they are not lost when the connection is closed.
+func (c *MemdClient) flushWrites() {
+	if c.writer != nil {
+		_ = c.writer.Flush()
+	}
+}
+
 // Close will forcefully disconnect a client
 func (c *MemdClient) Close() error {
-	// Close the underlying connection first
+	// Close the underlying connection first, once anything already written
+	// has actually been sent.
+	c.flushWrites()
 	err := c.conn.Close()
 
 	// Then wait for our reader thread to terminate
@@ -98,6 +231,11 @@ func (c *MemdClient) Close() error {
 	return err
 }
 
+// CloseNotify returns a channel which is closed once this client has disconnected.
+func (c *MemdClient) CloseNotify() <-chan struct{} {
+	return c.closeWaitCh
+}
+
 // GetContext gets arbitrary context associated with this client
 func (c *MemdClient) GetContext(valuePtr interface{}) {
 	c.ctxStore.Get(valuePtr)
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 )
@@ -15,6 +16,10 @@ type MemdServerHandlers struct {
 	NewClientHandler  func(*MemdClient)
 	LostClientHandler func(*MemdClient)
 	PacketHandler     func(*MemdClient, *memd.Packet)
+
+	// TLSHandshakeHandler is invoked once a TLS client has completed its
+	// handshake, with the parameters which were negotiated.  It is optional.
+	TLSHandshakeHandler func(*MemdClient, tls.ConnectionState)
 }
 
 // MemdServer represents an instance of the memd server.
@@ -26,6 +31,11 @@ type MemdServer struct {
 	handlers   MemdServerHandlers
 	tlsConfig  *tls.Config
 
+	idleTimeout        time.Duration
+	writeFragmentSize  int
+	writeBufferSize    int
+	writeFlushInterval time.Duration
+
 	clients []*MemdClient
 }
 
@@ -33,13 +43,36 @@ type MemdServer struct {
 type NewMemdServerOptions struct {
 	TLSConfig *tls.Config
 	Handlers  MemdServerHandlers
+
+	// IdleTimeout specifies how long a client can go without sending any
+	// packets before it is disconnected.  Zero disables the timeout.
+	IdleTimeout time.Duration
+
+	// WriteFragmentSize splits every write to a client into separate writes
+	// of at most this many bytes.  Zero disables this.
+	WriteFragmentSize int
+
+	// WriteBufferSize enables coalescing of the packets written to each
+	// client, so that packets written in quick succession are sent in fewer
+	// writes.  Once this many bytes are waiting they are written without
+	// waiting for the flush interval.  Zero disables coalescing.
+	WriteBufferSize int
+
+	// WriteFlushInterval is how long coalesced packets can wait for more
+	// packets before being written.  Zero only coalesces the packets which
+	// were written while a previous write was in progress.
+	WriteFlushInterval time.Duration
 }
 
 // NewMemdService instantiates a new instance of the memd server.
 func NewMemdService(opts NewMemdServerOptions) (*MemdServer, error) {
 	svc := &MemdServer{
-		handlers:  opts.Handlers,
-		tlsConfig: opts.TLSConfig,
+		handlers:           opts.Handlers,
+		tlsConfig:          opts.TLSConfig,
+		idleTimeout:        opts.IdleTimeout,
+		writeFragmentSize:  opts.WriteFragmentSize,
+		writeBufferSize:    opts.WriteBufferSize,
+		writeFlushInterval: opts.WriteFlushInterval,
 	}
 
 	err := svc.start()
@@ -79,6 +112,7 @@ func (s *MemdServer) start() error {
 	tcpAddr := addr.(*net.TCPAddr)
 	s.listenPort = tcpAddr.Port
 	s.localAddr = addr.String()
+	s.listener = lsnr
 
 	if s.tlsConfig != nil {
 		log.Printf("starting listener for kv (memd) TLS server on port %d", s.listenPort)
@@ -107,6 +141,14 @@ func (s *MemdServer) start() error {
 			s.lock.Unlock()
 
 			s.handlers.NewClientHandler(client)
+
+			// The client is only read from once its handler has set it up, so
+			// that its requests and disconnection are never handled first.
+			err = client.start()
+			if err != nil {
+				log.Printf("failed to start memd client: %s", err)
+				break
+			}
 		}
 	}()
 
@@ -127,6 +169,16 @@ func (s *MemdServer) handleClientRequest(client *MemdClient, pak *memd.Packet) {
 	s.handlers.PacketHandler(client, pak)
 }
 
+func (s *MemdServer) handleClientTLSHandshake(client *MemdClient, state tls.ConnectionState) {
+	if s.handlers.TLSHandshakeHandler != nil {
+		s.handlers.TLSHandshakeHandler(client, state)
+	}
+}
+
+func (s *MemdServer) handleClientIdle(client *MemdClient) {
+	log.Printf("closing memd client %s which was idle for %s", client.RemoteAddr(), s.idleTimeout)
+}
+
 func (s *MemdServer) handleClientDisconnect(client *MemdClient) {
 	s.handlers.LostClientHandler(client)
 
@@ -143,6 +195,17 @@ func (s *MemdServer) handleClientDisconnect(client *MemdClient) {
 	s.lock.Unlock()
 }
 
+// Rebind drops all clients and starts listening again on a new port, as if the
+// server had been restarted.
+func (s *MemdServer) Rebind() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	s.listenPort = 0
+	return s.start()
+}
+
 // Close causes this memd server to be forcefully stopped and all clients dropped.
 func (s *MemdServer) Close() error {
 	err := s.listener.Close()
@@ -2,6 +2,7 @@ package servers
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -101,3 +102,201 @@ func TestMemdBasic(t *testing.T) {
 	assert.Len(packetInvokes, 1)
 	lock.Unlock()
 }
+
+func TestMemdIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	var lostClientInvokes []*MemdClient
+	var lock sync.Mutex
+
+	svc, err := NewMemdService(NewMemdServerOptions{
+		Handlers: MemdServerHandlers{
+			NewClientHandler: func(cli *MemdClient) {},
+			LostClientHandler: func(cli *MemdClient) {
+				lock.Lock()
+				lostClientInvokes = append(lostClientInvokes, cli)
+				lock.Unlock()
+			},
+			PacketHandler: func(cli *MemdClient, pak *memd.Packet) {},
+		},
+		IdleTimeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to start memd server: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", svc.ListenPort()))
+	if err != nil {
+		t.Fatalf("failed to dial memd server: %v", err)
+	}
+	mconn := memd.NewConn(conn)
+
+	// Sending packets keeps the connection alive beyond the idle timeout.
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		err = mconn.WritePacket(&memd.Packet{
+			Magic:   memd.CmdMagicReq,
+			Command: memd.CmdNoop,
+			Opaque:  uint32(i),
+		})
+		if err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+
+	lock.Lock()
+	assert.Len(lostClientInvokes, 0)
+	lock.Unlock()
+
+	time.Sleep(400 * time.Millisecond)
+
+	lock.Lock()
+	assert.Len(lostClientInvokes, 1)
+	lock.Unlock()
+
+	_, _, err = mconn.ReadPacket()
+	assert.Error(err)
+}
+
+func startEchoMemdServer(t testing.TB, opts NewMemdServerOptions) *memd.Conn {
+	opts.Handlers = MemdServerHandlers{
+		NewClientHandler:  func(cli *MemdClient) {},
+		LostClientHandler: func(cli *MemdClient) {},
+		PacketHandler: func(cli *MemdClient, pak *memd.Packet) {
+			err := cli.WritePacket(&memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+			})
+			if err != nil {
+				t.Errorf("failed to write packet: %v", err)
+			}
+		},
+	}
+
+	svc, err := NewMemdService(opts)
+	if err != nil {
+		t.Fatalf("failed to start memd server: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", svc.ListenPort()))
+	if err != nil {
+		t.Fatalf("failed to dial memd server: %v", err)
+	}
+	return memd.NewConn(conn)
+}
+
+// roundTripBatch pipelines a batch of NOOPs and checks that every response
+// arrives, in the order the requests were sent.
+func roundTripBatch(t testing.TB, mconn *memd.Conn, batchSize int) {
+	for i := 0; i < batchSize; i++ {
+		err := mconn.WritePacket(&memd.Packet{
+			Magic:   memd.CmdMagicReq,
+			Command: memd.CmdNoop,
+			Opaque:  uint32(i),
+		})
+		if err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+
+	for i := 0; i < batchSize; i++ {
+		pak, _, err := mconn.ReadPacket()
+		if err != nil {
+			t.Fatalf("failed to read packet: %v", err)
+		}
+		if pak.Opaque != uint32(i) {
+			t.Fatalf("expected response %d but got %d", i, pak.Opaque)
+		}
+	}
+}
+
+func TestMemdWriteCoalescing(t *testing.T) {
+	mconn := startEchoMemdServer(t, NewMemdServerOptions{
+		WriteBufferSize:    4096,
+		WriteFlushInterval: 5 * time.Millisecond,
+	})
+	roundTripBatch(t, mconn, 500)
+
+	// Coalescing also applies on top of fragmented writes.
+	mconn = startEchoMemdServer(t, NewMemdServerOptions{
+		WriteFragmentSize: 7,
+		WriteBufferSize:   64,
+	})
+	roundTripBatch(t, mconn, 100)
+}
+
+func BenchmarkMemdBatchedOps(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts NewMemdServerOptions
+	}{
+		{"Uncoalesced", NewMemdServerOptions{}},
+		{"Coalesced", NewMemdServerOptions{WriteBufferSize: 16 * 1024}},
+		{"CoalescedInterval", NewMemdServerOptions{
+			WriteBufferSize:    16 * 1024,
+			WriteFlushInterval: 100 * time.Microsecond,
+		}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			mconn := startEchoMemdServer(b, bm.opts)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				roundTripBatch(b, mconn, 100)
+			}
+		})
+	}
+}
+
+func TestCoalescingConnConcurrentFlush(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	// The buffer size is never reached, so only explicit flushes write.
+	conn := newCoalescingConn(serverConn, 1<<30, time.Hour)
+	defer conn.Stop()
+
+	// Reads are only made once the test is ready for them, so that a flush
+	// is held sending its data until then.
+	read := func() []byte {
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(clientConn, buf); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		return buf
+	}
+
+	flushCh := make(chan error, 1)
+	flushAsync := func() {
+		go func() {
+			flushCh <- conn.Flush()
+		}()
+	}
+
+	_, _ = conn.Write([]byte("AAAAAAAA"))
+	flushAsync()
+	assert.Equal(t, []byte("AAAAAAAA"), read())
+	assert.NoError(t, <-flushCh)
+
+	// Flushing with nothing queued must not leave the queued and in-flight
+	// buffers sharing memory.
+	assert.NoError(t, conn.Flush())
+
+	// Writes queued while a flush is blocked sending the previous ones must
+	// not change what that flush sends.
+	_, _ = conn.Write([]byte("BBBBBBBB"))
+	flushAsync()
+	time.Sleep(10 * time.Millisecond)
+	_, _ = conn.Write([]byte("CCCCCCCC"))
+
+	assert.Equal(t, []byte("BBBBBBBB"), read())
+	assert.NoError(t, <-flushCh)
+
+	flushAsync()
+	assert.Equal(t, []byte("CCCCCCCC"), read())
+	assert.NoError(t, <-flushCh)
+}
@@ -0,0 +1,58 @@
+package mockimpl
+
+// serviceServer is a server which a service listens for requests on.
+type serviceServer interface {
+	ListenPort() int
+	Rebind() error
+	Close() error
+}
+
+// serviceServers holds the servers which a service listens on, without and
+// with TLS.  Either may be nil if the service does not listen that way.
+type serviceServers struct {
+	server    serviceServer
+	tlsServer serviceServer
+}
+
+// ListenPort returns the port this service is listening on.
+func (s *serviceServers) ListenPort() int {
+	if s.server == nil {
+		return -1
+	}
+	return s.server.ListenPort()
+}
+
+// ListenPortTLS returns the TLS port this service is listening on.
+func (s *serviceServers) ListenPortTLS() int {
+	if s.tlsServer == nil {
+		return -1
+	}
+	return s.tlsServer.ListenPort()
+}
+
+// rebind moves this service to new ports, dropping any existing connections.
+func (s *serviceServers) rebind() error {
+	if s.server != nil {
+		if err := s.server.Rebind(); err != nil {
+			return err
+		}
+	}
+	if s.tlsServer != nil {
+		if err := s.tlsServer.Rebind(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close will shut down this service once it is no longer needed.
+func (s *serviceServers) Close() error {
+	var errOut error
+	if s.server != nil {
+		errOut = s.server.Close()
+	}
+	if s.tlsServer != nil {
+		errOut = s.tlsServer.Close()
+	}
+	return errOut
+}
@@ -0,0 +1,71 @@
+package mockimpl
+
+import "sync"
+
+// steppedTask tracks a long running task which progresses in a number of steps
+// that are driven externally, so that tooling which waits on the task can be
+// tested.  A task which is started while no steps are configured does not run
+// in steps, and is instead completed straight away by its owner.
+type steppedTask struct {
+	lock    sync.Mutex
+	steps   int
+	running bool
+
+	// These track the progress of the running task, which is unaffected by
+	// any change to the number of steps while it runs.
+	stepsTotal int
+	stepsDone  int
+}
+
+// setSteps sets the number of steps which tasks started from now on take.
+func (t *steppedTask) setSteps(steps int) {
+	t.lock.Lock()
+	t.steps = steps
+	t.lock.Unlock()
+}
+
+// startLocked begins running the task in steps, returning false if no steps
+// are configured, in which case the task must be completed straight away.
+// NOTE: This must be called with the lock of the task held.
+func (t *steppedTask) startLocked() bool {
+	if t.steps <= 0 {
+		return false
+	}
+
+	t.running = true
+	t.stepsTotal = t.steps
+	t.stepsDone = 0
+	return true
+}
+
+// stepLocked advances the running task by a step, returning whether the task
+// has now completed.
+// NOTE: This must be called with the lock of the task held.
+func (t *steppedTask) stepLocked() bool {
+	t.stepsDone++
+	if t.stepsDone < t.stepsTotal {
+		return false
+	}
+
+	t.running = false
+	return true
+}
+
+// cancelLocked stops the running task without it completing, returning
+// whether there was a task running.
+// NOTE: This must be called with the lock of the task held.
+func (t *steppedTask) cancelLocked() bool {
+	if !t.running {
+		return false
+	}
+
+	t.running = false
+	return true
+}
+
+// progressLocked returns the percentage of the running task which has been
+// completed.
+// NOTE: This must be called with the lock of the task held.
+func (t *steppedTask) progressLocked() int {
+	return t.stepsDone * 100 / t.stepsTotal
+}
@@ -0,0 +1,118 @@
+package mockimpl
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSteppedCompaction(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	bucket := cluster.GetBucket("default")
+
+	// Without steps, a compaction completes as soon as it starts.
+	assert.NoError(t, bucket.StartCompaction())
+	_, running := bucket.CompactionProgress()
+	assert.False(t, running)
+	assert.Error(t, bucket.StepCompaction())
+
+	bucket.SetCompactionSteps(2)
+	assert.NoError(t, bucket.StartCompaction())
+	assert.Error(t, bucket.StartCompaction())
+
+	// Changing the steps does not affect the running compaction.
+	bucket.SetCompactionSteps(4)
+	progress, running := bucket.CompactionProgress()
+	assert.True(t, running)
+	assert.Equal(t, 0, progress)
+
+	assert.NoError(t, bucket.StepCompaction())
+	progress, running = bucket.CompactionProgress()
+	assert.True(t, running)
+	assert.Equal(t, 50, progress)
+
+	assert.NoError(t, bucket.StepCompaction())
+	_, running = bucket.CompactionProgress()
+	assert.False(t, running)
+}
+
+func TestSteppedPause(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	bucket := cluster.GetBucket("default")
+
+	bucket.SetPauseSteps(2)
+	assert.Error(t, bucket.StartResume())
+	assert.NoError(t, bucket.StartPause())
+	assert.Equal(t, mock.BucketPauseStatePausing, bucket.PauseState())
+	assert.Error(t, bucket.StartPause())
+
+	assert.NoError(t, bucket.StepPause())
+	progress, running := bucket.PauseProgress()
+	assert.True(t, running)
+	assert.Equal(t, 50, progress)
+
+	assert.NoError(t, bucket.StepPause())
+	assert.Equal(t, mock.BucketPauseStatePaused, bucket.PauseState())
+	_, running = bucket.PauseProgress()
+	assert.False(t, running)
+	assert.Error(t, bucket.StepPause())
+
+	// Without steps, resuming completes straight away.
+	bucket.SetPauseSteps(0)
+	assert.NoError(t, bucket.StartResume())
+	assert.Equal(t, mock.BucketPauseStateRunning, bucket.PauseState())
+}
+
+func TestCancelCompactionThroughTaskURI(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	bucket := cluster.GetBucket("default")
+	mgmtSvc := cluster.Nodes()[0].MgmtService()
+	mgmtURL := testServiceURL(mgmtSvc.Hostname(), mgmtSvc.ListenPort())
+
+	assert.Error(t, bucket.CancelCompaction())
+
+	bucket.SetCompactionSteps(2)
+	assert.NoError(t, bucket.StartCompaction())
+
+	// The compaction task advertises where it can be cancelled.
+	status, body := doTestHTTP(t, "GET", mgmtURL+"/pools/default/tasks", nil)
+	assert.Equal(t, 200, status)
+	var tasks []struct {
+		Type      string `json:"type"`
+		CancelURI string `json:"cancelURI"`
+	}
+	if err := json.Unmarshal(body, &tasks); err != nil {
+		t.Fatalf("failed to parse tasks %s: %v", body, err)
+	}
+	var cancelURI string
+	for _, task := range tasks {
+		if task.Type == "bucket_compaction" {
+			cancelURI = task.CancelURI
+		}
+	}
+	if cancelURI == "" {
+		t.Fatalf("compaction task was not reported in %s", body)
+	}
+
+	status, _ = doTestHTTP(t, "POST", mgmtURL+cancelURI, url.Values{})
+	assert.Equal(t, 200, status)
+	_, running := bucket.CompactionProgress()
+	assert.False(t, running)
+	assert.Error(t, bucket.StepCompaction())
+
+	// Cancelling again is not an error for the endpoint.
+	status, _ = doTestHTTP(t, "POST", mgmtURL+cancelURI, url.Values{})
+	assert.Equal(t, 200, status)
+}
@@ -0,0 +1,85 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+type analyticsImplConfig struct {
+}
+
+func (x *analyticsImplConfig) Register(h *hookHelper) {
+	h.RegisterAnalyticsHandler("GET", "/analytics/config/service", x.handleGetServiceConfig)
+	h.RegisterAnalyticsHandler("GET", "/analytics/cluster", x.handleGetClusterState)
+}
+
+type jsonAnalyticsNode struct {
+	NodeID   string `json:"nodeId"`
+	NodeName string `json:"nodeName"`
+	APIBase  string `json:"apiBase"`
+}
+
+type jsonAnalyticsClusterState struct {
+	State       string              `json:"state"`
+	CcNodeID    string              `json:"ccNodeId"`
+	NumReplicas int                 `json:"numReplicas"`
+	Nodes       []jsonAnalyticsNode `json:"nodes"`
+}
+
+// handleGetServiceConfig returns the service-wide configuration of analytics,
+// which reflects the settings managed through /settings/analytics.
+func (x *analyticsImplConfig) handleGetServiceConfig(source mock.AnalyticsService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionsAnalyticsManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	return analyticsSettingsResponse(source.Node().Cluster().AnalyticsSettings())
+}
+
+// handleGetClusterState returns the nodes which make up the analytics cluster,
+// which are all of the nodes of the cluster running the analytics service.
+// The first of them acts as the cluster controller.
+func (x *analyticsImplConfig) handleGetClusterState(source mock.AnalyticsService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionsAnalyticsManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	state := jsonAnalyticsClusterState{
+		State:       "ACTIVE",
+		NumReplicas: cluster.AnalyticsSettings().NumReplicas,
+		Nodes:       []jsonAnalyticsNode{},
+	}
+	for _, node := range cluster.Nodes() {
+		analyticsSvc := node.AnalyticsService()
+		if analyticsSvc == nil {
+			continue
+		}
+
+		nodeName := fmt.Sprintf("%s:%d", analyticsSvc.Hostname(), analyticsSvc.ListenPort())
+		if state.CcNodeID == "" {
+			state.CcNodeID = node.ID()
+		}
+		state.Nodes = append(state.Nodes, jsonAnalyticsNode{
+			NodeID:   node.ID(),
+			NodeName: nodeName,
+			APIBase:  "http://" + nodeName,
+		})
+	}
+
+	stateBytes, _ := json.Marshal(state)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(stateBytes),
+	}
+}
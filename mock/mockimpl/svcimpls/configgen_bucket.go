@@ -7,6 +7,76 @@ import (
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// bucketCapabilityVersions lists the version of the server which added each
+// bucket capability.  Capabilities which are not listed are available in
+// every version which a cluster can emulate.
+var bucketCapabilityVersions = map[string]mock.ClusterVersion{
+	"durableWrite":         mock.ClusterVersion65,
+	"tombstonedUserXAttrs": mock.ClusterVersion66,
+	"collections":          mock.ClusterVersion70,
+}
+
+// genBucketCapabilities returns the capabilities of a bucket, which depend on
+// the type of the bucket and on the version and edition of the server.
+func genBucketCapabilities(b mock.Bucket) []string {
+	cluster := b.Cluster()
+	isEnterprise := cluster.Edition() == mock.ClusterEditionEnterprise
+
+	typeCaps := genBucketTypeCapabilities(b)
+	caps := make([]string, 0, len(typeCaps))
+	for _, capability := range typeCaps {
+		if addedVersion, ok := bucketCapabilityVersions[capability]; ok && !cluster.Version().AtLeast(addedVersion) {
+			continue
+		}
+
+		// Durable writes are only available in the enterprise edition.
+		if capability == "durableWrite" && !isEnterprise {
+			continue
+		}
+
+		caps = append(caps, capability)
+	}
+	return caps
+}
+
+// genBucketTypeCapabilities returns the capabilities of a type of bucket.
+func genBucketTypeCapabilities(b mock.Bucket) []string {
+	switch b.BucketType() {
+	case mock.BucketTypeMemcached:
+		return []string{
+			"cbhello",
+			"nodesExt",
+		}
+	case mock.BucketTypeEphemeral:
+		return []string{
+			"collections",
+			"durableWrite",
+			"tombstonedUserXAttrs",
+			"dcp",
+			"cbhello",
+			"touch",
+			"cccp",
+			"xdcrCheckpointing",
+			"nodesExt",
+			"xattr",
+		}
+	}
+
+	return []string{
+		"collections",
+		"durableWrite",
+		"tombstonedUserXAttrs",
+		"couchapi",
+		"dcp",
+		"cbhello",
+		"touch",
+		"cccp",
+		"xdcrCheckpointing",
+		"nodesExt",
+		"xattr",
+	}
+}
+
 // GenBucketConfig returns the current config for a bucket.
 func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	kvNodes, vbMap, allNodes := b.GetVbServerInfo(reqNode)
@@ -17,16 +87,21 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 
 	config["bucketType"] = b.BucketType().Name()
 
-	if b.BucketType() != mock.BucketTypeMemcached {
-		config["collectionsManifestUid"] = fmt.Sprintf("%d", b.CollectionManifest().Rev)
+	switch b.BucketType() {
+	case mock.BucketTypeCouchbase:
+		config["collectionsManifestUid"] = genManifestUID(b, reqNode)
 		config["durabilityMinLevel"] = "none"
 
 		config["ddocs"] = map[string]interface{}{
 			"uri": fmt.Sprintf("/pools/default/%s/default/ddocs", b.Name()),
 		}
+	case mock.BucketTypeEphemeral:
+		// Ephemeral buckets do not support views, so have no design documents.
+		config["collectionsManifestUid"] = genManifestUID(b, reqNode)
+		config["durabilityMinLevel"] = "none"
 	}
-	config["evictionPolicy"] = "valueOnly"
-	config["storageBackend"] = "couchstore"
+	config["evictionPolicy"] = string(b.EvictionPolicy())
+	config["storageBackend"] = string(b.StorageBackend())
 	config["saslPassword"] = "f5461fdf070ba44b7f1ca2f18bd7bb28"
 	config["compressionMode"] = string(b.CompressionMode())
 	config["replicaIndex"] = b.ReplicaIndexEnabled()
@@ -38,6 +113,12 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	config["conflictResolutionType"] = "seqno"
 	config["maxTTL"] = 0
 
+	// Buckets are only reported with a pause state while they are paused, or
+	// being paused or resumed, matching servers without pause support.
+	if pauseState := b.PauseState(); pauseState != mock.BucketPauseStateRunning {
+		config["pauseState"] = string(pauseState)
+	}
+
 	config["localRandomKeyUri"] = fmt.Sprintf("/pools/default/buckets/%s/localRandomKey", b.Name())
 	config["uri"] = fmt.Sprintf("/pools/default/buckets/%s?bucket_uuid=%s", b.Name(), b.ID())
 	config["streamingUri"] = fmt.Sprintf("/pools/default/bucketsStreaming/%s?bucket_uuid=%s", b.Name(), b.ID())
@@ -59,19 +140,7 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	}
 
 	config["bucketCapabilitiesVer"] = ""
-	config["bucketCapabilities"] = []string{
-		"collections",
-		"durableWrite",
-		"tombstonedUserXAttrs",
-		"couchapi",
-		"dcp",
-		"cbhello",
-		"touch",
-		"cccp",
-		"xdcrCheckpointing",
-		"nodesExt",
-		"xattr",
-	}
+	config["bucketCapabilities"] = genBucketCapabilities(b)
 
 	controllers := map[string]interface{}{
 		"compactAll":    fmt.Sprintf("/pools/default/buckets/%s/controller/compactBucket", b.Name()),
@@ -90,8 +159,14 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		"nodeStatsListURI": fmt.Sprintf("/pools/default/buckets/%s/nodes", b.Name()),
 	}
 
+	// Only the nodes running the data service hold the bucket, so they are
+	// the only nodes which are listed.
 	nodesConfig := make([]interface{}, 0)
 	for _, server := range allNodes {
+		if server.KvService() == nil {
+			continue
+		}
+
 		nodeConfig := GenClusterNodeConfig(server, reqNode, b)
 		nodesConfig = append(nodesConfig, json.RawMessage(nodeConfig))
 	}
@@ -101,19 +176,7 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		config["nodeLocator"] = "ketama"
 	} else {
 		config["nodeLocator"] = "vbucket"
-		vbConfig := make(map[string]interface{})
-		vbConfig["hashAlgorithm"] = "CRC"
-		vbConfig["numReplicas"] = b.NumReplicas()
-		vbConfig["vBucketMap"] = vbMap
-
-		var vbServerList []interface{}
-		for _, node := range kvNodes {
-			address := fmt.Sprintf("%s:%d", node.KvService().Hostname(), node.KvService().ListenPort())
-			vbServerList = append(vbServerList, address)
-		}
-		vbConfig["serverList"] = vbServerList
-
-		config["vBucketServerMap"] = vbConfig
+		config["vBucketServerMap"] = genVbServerMap(b, kvNodes, vbMap)
 	}
 
 	configBytes, _ := json.Marshal(config)
@@ -122,6 +185,12 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 
 // GenTerseBucketConfig returns the current mini config for a bucket.
 func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
+	return genTerseBucketConfig(b, reqNode, networkDefault)
+}
+
+// genTerseBucketConfig returns the current mini config for a bucket, with the
+// nodes addressed as they are on a specific network.
+func genTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode, network string) []byte {
 	kvNodes, vbMap, allNodes := b.GetVbServerInfo(reqNode)
 
 	config := make(map[string]interface{})
@@ -130,52 +199,36 @@ func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	config["uuid"] = b.ID()
 
 	if b.BucketType() != mock.BucketTypeMemcached {
-		config["collectionsManifestUid"] = fmt.Sprintf("%d", b.CollectionManifest().Rev)
+		config["collectionsManifestUid"] = genManifestUID(b, reqNode)
 	}
 
 	config["uri"] = fmt.Sprintf("/pools/default/buckets/%s?bucket_uuid=%s", b.Name(), b.ID())
 	config["streamingUri"] = fmt.Sprintf("/pools/default/bucketsStreaming/%s?bucket_uuid=%s", b.Name(), b.ID())
 
-	if b.BucketType() != mock.BucketTypeMemcached {
+	// Only couchbase buckets support views, so only they have design documents.
+	if b.BucketType() == mock.BucketTypeCouchbase {
 		config["ddocs"] = map[string]interface{}{
 			"uri": fmt.Sprintf("/pools/default/%s/default/ddocs", b.Name()),
 		}
 	}
 
 	config["clusterCapabilitiesVer"] = []int{1, 0}
-
-	config["clusterCapabilities"] = map[string]interface{}{
-		"n1ql": []string{
-			"costBasedOptimizer",
-			"indexAdvisor",
-			"javaScriptFunctions",
-			"inlineFunctions",
-			"enhancedPreparedStatements",
-		},
-	}
+	config["clusterCapabilities"] = genClusterCapabilities(b.Cluster())
 
 	config["bucketCapabilitiesVer"] = ""
-	config["bucketCapabilities"] = []string{
-		"collections",
-		"durableWrite",
-		"tombstonedUserXAttrs",
-		"couchapi",
-		"dcp",
-		"cbhello",
-		"touch",
-		"cccp",
-		"xdcrCheckpointing",
-		"nodesExt",
-		"xattr",
-	}
+	config["bucketCapabilities"] = genBucketCapabilities(b)
 
 	nodesConfig := make([]interface{}, 0)
 	nodesExtConfig := make([]interface{}, 0)
+	// Nodes without the data service are only listed in nodesExt, where the
+	// services which they do run can be found.
 	for _, server := range allNodes {
-		nodeConfig := GenTerseClusterNodeConfig(server, reqNode, b)
-		nodesConfig = append(nodesConfig, json.RawMessage(nodeConfig))
+		if server.KvService() != nil {
+			nodeConfig := GenTerseClusterNodeConfig(server, reqNode, b)
+			nodesConfig = append(nodesConfig, json.RawMessage(nodeConfig))
+		}
 
-		nodeExtConfig := GenExtClusterNodeConfig(server, reqNode, b)
+		nodeExtConfig := genExtClusterNodeConfig(server, reqNode, b, network)
 		nodesExtConfig = append(nodesExtConfig, json.RawMessage(nodeExtConfig))
 	}
 	config["nodes"] = nodesConfig
@@ -185,22 +238,16 @@ func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		config["nodeLocator"] = "ketama"
 	} else {
 		config["nodeLocator"] = "vbucket"
-
-		vbConfig := make(map[string]interface{})
-		vbConfig["hashAlgorithm"] = "CRC"
-		vbConfig["numReplicas"] = b.NumReplicas()
-		vbConfig["vBucketMap"] = vbMap
-
-		var vbServerList []interface{}
-		for _, node := range kvNodes {
-			address := fmt.Sprintf("%s:%d", node.KvService().Hostname(), node.KvService().ListenPort())
-			vbServerList = append(vbServerList, address)
-		}
-		vbConfig["serverList"] = vbServerList
-
-		config["vBucketServerMap"] = vbConfig
+		config["vBucketServerMap"] = genVbServerMap(b, kvNodes, vbMap)
 	}
 
 	configBytes, _ := json.Marshal(config)
 	return configBytes
 }
+
+// genManifestUID returns the uid of the collection manifest which a node has
+// adopted, formatted as it appears in configs.
+func genManifestUID(b mock.Bucket, reqNode mock.ClusterNode) string {
+	uid, _ := b.CollectionManifest().GetManifestAt(b.NodeManifestTime(reqNode))
+	return fmt.Sprintf("%d", uid)
+}
@@ -2,10 +2,42 @@ package svcimpls
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// enterpriseClusterCapabilities lists the cluster capabilities which are only
+// available in the enterprise edition of the server.
+var enterpriseClusterCapabilities = map[string]bool{
+	"costBasedOptimizer": true,
+	"indexAdvisor":       true,
+}
+
+// genClusterCapabilities returns the clusterCapabilities section of a config.
+// The community edition never advertises the enterprise only capabilities,
+// whatever the cluster has been configured with.
+func genClusterCapabilities(c mock.Cluster) map[string]interface{} {
+	isEnterprise := c.Edition() == mock.ClusterEditionEnterprise
+
+	caps := make(map[string]interface{})
+	for service, serviceCaps := range c.ClusterCapabilities() {
+		if isEnterprise {
+			caps[service] = serviceCaps
+			continue
+		}
+
+		editionCaps := make([]string, 0, len(serviceCaps))
+		for _, capability := range serviceCaps {
+			if !enterpriseClusterCapabilities[capability] {
+				editionCaps = append(editionCaps, capability)
+			}
+		}
+		caps[service] = editionCaps
+	}
+	return caps
+}
+
 // GenClusterConfig returns the current config for this cluster.
 func GenClusterConfig(c mock.Cluster, reqNode mock.ClusterNode) []byte {
 	config := make(map[string]interface{})
@@ -25,34 +57,56 @@ func GenClusterConfig(c mock.Cluster, reqNode mock.ClusterNode) []byte {
 		"terseStreamingBucketsBase": "/pools/default/bs/",
 	}
 
+	config["serverGroupsUri"] = fmt.Sprintf("/pools/default/serverGroups?rev=%d", c.ConfigRev())
+
+	// Graceful failovers are reported as a kind of rebalance, the same way
+	// they are in the tasks.
+	config["balanced"] = c.IsBalanced()
+	config["rebalanceStatus"] = "none"
+	if _, _, running := c.GracefulFailoverProgress(); running {
+		config["rebalanceStatus"] = "running"
+	}
+
+	quotas := c.MemoryQuotas()
+	config["memoryQuota"] = quotas.Data
+	config["indexMemoryQuota"] = quotas.Index
+	config["ftsMemoryQuota"] = quotas.Search
+	config["cbasMemoryQuota"] = quotas.Analytics
+	config["eventingMemoryQuota"] = quotas.Eventing
+
+	config["clusterCapabilitiesVer"] = []int{1, 0}
+	config["clusterCapabilities"] = genClusterCapabilities(c)
+
 	configBytes, _ := json.Marshal(config)
 	return configBytes
 }
 
 // GenTerseClusterConfig returns the current mini config for this cluster.
 func GenTerseClusterConfig(c mock.Cluster, reqNode mock.ClusterNode) []byte {
+	return genTerseClusterConfig(c, reqNode, networkDefault)
+}
+
+// genTerseClusterConfig returns the current mini config for this cluster, with
+// the nodes addressed as they are on a specific network.
+func genTerseClusterConfig(c mock.Cluster, reqNode mock.ClusterNode, network string) []byte {
 	config := make(map[string]interface{})
 
 	config["rev"] = c.ConfigRev()
 
 	nodesConfig := make([]interface{}, 0)
 	for _, server := range c.Nodes() {
-		nodeConfig := GenExtClusterNodeConfig(server, reqNode, nil)
+		nodeConfig := genExtClusterNodeConfig(server, reqNode, nil, network)
 		nodesConfig = append(nodesConfig, json.RawMessage(nodeConfig))
 	}
 	config["nodesExt"] = nodesConfig
 
 	config["clusterCapabilitiesVer"] = []int{1, 0}
+	config["clusterCapabilities"] = genClusterCapabilities(c)
 
-	config["clusterCapabilities"] = map[string]interface{}{
-		"n1ql": []string{
-			"costBasedOptimizer",
-			"indexAdvisor",
-			"javaScriptFunctions",
-			"inlineFunctions",
-			"enhancedPreparedStatements",
-		},
-	}
+	// The product is included so that clients connecting without a bucket can
+	// detect the version and edition of the server.
+	config["prodName"] = "Couchbase Server"
+	config["prodVersion"] = genServerVersion(c)
 
 	configBytes, _ := json.Marshal(config)
 	return configBytes
@@ -7,6 +7,12 @@ import (
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// genOtpNode returns the erlang node name of a cluster node.  All of our nodes
+// share a hostname, so the node ID is used in its place to keep them unique.
+func genOtpNode(n mock.ClusterNode) string {
+	return fmt.Sprintf("ns_1@%s", n.ID())
+}
+
 // GenClusterNodeConfig returns the config data for a cluster node.
 func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucket mock.Bucket) []byte {
 	config := make(map[string]interface{})
@@ -14,34 +20,33 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 	if forBucket != nil {
 		// This is inexplicably URL encoded for god knows what reason
 		if n.ViewService() != nil && n.ViewService().ListenPort() > 0 {
-			config["couchApiBase"] = fmt.Sprintf("http://%s:%d/%s%%2B%s",
-				n.ViewService().Hostname(), n.ViewService().ListenPort(), forBucket.Name(), forBucket.ID())
+			config["couchApiBase"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPort()) +
+				fmt.Sprintf("/%s%%2B%s", forBucket.Name(), forBucket.ID())
 		}
 		if n.ViewService() != nil && n.ViewService().ListenPortTLS() > 0 {
-			config["couchApiBaseHTTPS"] = fmt.Sprintf("http://%s:%d/%s%%2B%s",
-				n.ViewService().Hostname(), n.ViewService().ListenPortTLS(), forBucket.Name(), forBucket.ID())
+			config["couchApiBaseHTTPS"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPortTLS()) +
+				fmt.Sprintf("/%s%%2B%s", forBucket.Name(), forBucket.ID())
 		}
 	} else {
 		if n.ViewService() != nil && n.ViewService().ListenPort() > 0 {
-			config["couchApiBase"] = fmt.Sprintf("http://%s:%d/",
-				n.ViewService().Hostname(), n.ViewService().ListenPort())
+			config["couchApiBase"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPort()) + "/"
 		}
 		if n.ViewService() != nil && n.ViewService().ListenPortTLS() > 0 {
-			config["couchApiBaseHTTPS"] = fmt.Sprintf("http://%s:%d/",
-				n.ViewService().Hostname(), n.ViewService().ListenPortTLS())
+			config["couchApiBaseHTTPS"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPortTLS()) + "/"
 		}
 	}
 
-	// TODO(brett19): Generate something reasonable for the otpNode field
-	config["otpNode"] = "ns_NOPE@cb.local"
+	config["otpNode"] = genOtpNode(n)
 	config["thisNode"] = n == reqNode
-	config["hostname"] = fmt.Sprintf("%s:%d", n.MgmtService().Hostname(), n.MgmtService().ListenPort())
-	config["configuredHostname"] = fmt.Sprintf("%s:%d", n.MgmtService().Hostname(), n.MgmtService().ListenPort())
+	config["hostname"] = genNodeHostPort(n, n.MgmtService().Hostname(), n.MgmtService().ListenPort())
+	config["configuredHostname"] = genNodeHostPort(n, n.MgmtService().Hostname(), n.MgmtService().ListenPort())
 	config["nodeUUID"] = n.ID()
 	config["recoveryType"] = "none"
 
 	if forBucket != nil {
 		config["replication"] = 0
+	} else {
+		config["serverGroup"] = n.ServerGroup()
 	}
 
 	servicePorts := map[string]interface{}{
@@ -56,7 +61,9 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 	if n.KvService() != nil {
 		servicesList = append(servicesList, "kv")
 
-		servicePorts["direct"] = n.KvService().ListenPort()
+		if !genOmitsKvPorts(n) {
+			servicePorts["direct"] = n.KvService().ListenPort()
+		}
 	}
 
 	if n.MgmtService() != nil {
@@ -90,16 +97,18 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 		},
 	}
 
-	config["clusterCompatibility"] = 458752
-	config["version"] = "7.0.0-3016-enterprise"
+	config["clusterCompatibility"] = genClusterCompatibility(n.Cluster())
+	config["version"] = genServerVersion(n.Cluster())
 	config["os"] = "x86_64-unknown-linux-gnu"
 	config["cpuCount"] = 24
 
-	config["clusterMembership"] = "active"
+	config["clusterMembership"] = string(n.Membership())
 	config["status"] = "healthy"
 	config["uptime"] = "383443"
-	config["memoryTotal"] = 49093763072
-	config["memoryFree"] = 44611338240
+	memoryTotal := n.Cluster().NodeMemoryTotal()
+	memoryFree := memoryTotal / 10 * 9
+	config["memoryTotal"] = memoryTotal
+	config["memoryFree"] = memoryFree
 	config["mcdMemoryReserved"] = 37455
 	config["mcdMemoryAllocated"] = 37455
 
@@ -108,9 +117,9 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 		"cpu_stolen_rate":      0.1260504201680672,
 		"swap_total":           2046816256,
 		"swap_used":            237715456,
-		"mem_total":            49093763072,
-		"mem_free":             44611338240,
-		"mem_limit":            49093763072,
+		"mem_total":            memoryTotal,
+		"mem_free":             memoryFree,
+		"mem_limit":            memoryTotal,
 		"cpu_cores_available":  24,
 		"allocstall":           0,
 	}
@@ -144,21 +153,20 @@ func GenTerseClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, for
 	if forBucket != nil {
 		// This is inexplicably URL encoded for god knows what reason
 		if n.ViewService() != nil && n.ViewService().ListenPort() > 0 {
-			config["couchApiBase"] = fmt.Sprintf("http://%s:%d/%s%%2B%s",
-				n.ViewService().Hostname(), n.ViewService().ListenPort(), forBucket.Name(), forBucket.ID())
+			config["couchApiBase"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPort()) +
+				fmt.Sprintf("/%s%%2B%s", forBucket.Name(), forBucket.ID())
 		}
 	} else {
 		if n.ViewService() != nil && n.ViewService().ListenPort() > 0 {
-			config["couchApiBase"] = fmt.Sprintf("http://%s:%d/",
-				n.ViewService().Hostname(), n.ViewService().ListenPort())
+			config["couchApiBase"] = genHTTPBaseURL(n.ViewService().Hostname(), n.ViewService().ListenPort()) + "/"
 		}
 	}
 
-	config["hostname"] = fmt.Sprintf("%s:%d", n.MgmtService().Hostname(), n.MgmtService().ListenPort())
+	config["hostname"] = genNodeHostPort(n, n.MgmtService().Hostname(), n.MgmtService().ListenPort())
 
 	servicePorts := map[string]interface{}{}
 
-	if n.KvService() != nil {
+	if n.KvService() != nil && !genOmitsKvPorts(n) {
 		servicePorts["direct"] = n.KvService().ListenPort()
 	}
 
@@ -170,6 +178,12 @@ func GenTerseClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, for
 
 // GenExtClusterNodeConfig returns the extended config data for a cluster node.
 func GenExtClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucket mock.Bucket) []byte {
+	return genExtClusterNodeConfig(n, reqNode, forBucket, networkDefault)
+}
+
+// genExtClusterNodeConfig returns the extended config data for a cluster node,
+// addressed as it is on a specific network.
+func genExtClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucket mock.Bucket, network string) []byte {
 	config := make(map[string]interface{})
 
 	servicePorts := map[string]interface{}{
@@ -184,10 +198,10 @@ func GenExtClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBu
 		"projector":          32767,
 	}
 
-	if n.KvService() != nil && n.KvService().ListenPort() > 0 {
+	if n.KvService() != nil && n.KvService().ListenPort() > 0 && !genOmitsKvPorts(n) {
 		servicePorts["kv"] = n.KvService().ListenPort()
 	}
-	if n.KvService() != nil && n.KvService().ListenPortTLS() > 0 {
+	if n.KvService() != nil && n.KvService().ListenPortTLS() > 0 && !genOmitsKvPorts(n) {
 		servicePorts["kvSSL"] = n.KvService().ListenPortTLS()
 	}
 
@@ -228,6 +242,33 @@ func GenExtClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBu
 
 	config["services"] = servicePorts
 	config["thisNode"] = n == reqNode
+	config["nodeUUID"] = n.ID()
+	config["serverGroup"] = n.ServerGroup()
+
+	// The external network shares the same listeners, and so the same ports.
+	if n.HasFeature(mock.ClusterNodeFeatureExternalNetwork) {
+		config["alternateAddresses"] = map[string]interface{}{
+			networkExternal: map[string]interface{}{
+				"hostname": externalNetworkHostname,
+				"ports":    servicePorts,
+			},
+		}
+
+		// Clients on the external network are given its addresses directly.
+		if network == networkExternal {
+			config["hostname"] = externalNetworkHostname
+		}
+	}
+
+	// Some scenarios need the node to explicitly specify its hostname.
+	switch n.Cluster().ConfigScenario() {
+	case mock.ConfigScenarioIPv6Hostnames:
+		config["hostname"] = genNodeHostname(n, "")
+	case mock.ConfigScenarioMissingKvPort:
+		if genOmitsKvPorts(n) {
+			config["hostname"] = n.MgmtService().Hostname()
+		}
+	}
 
 	configBytes, _ := json.Marshal(config)
 	return configBytes
@@ -2,28 +2,66 @@ package svcimpls
 
 import (
 	"encoding/json"
-	"github.com/couchbaselabs/gocaves/mock"
-	"github.com/google/uuid"
+	"fmt"
 	"strings"
+
+	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// serverBuildsByVersion maps each version of the server which a cluster can
+// emulate to the full version of the build which it reports, less the edition
+// which is appended to it.
+var serverBuildsByVersion = map[mock.ClusterVersion]string{
+	mock.ClusterVersion50: "5.0.1-5003",
+	mock.ClusterVersion65: "6.5.1-6299",
+	mock.ClusterVersion66: "6.6.0-7909",
+	mock.ClusterVersion70: "7.0.0-5302",
+	mock.ClusterVersion71: "7.1.0-2556",
+	mock.ClusterVersion72: "7.2.0-5325",
+	mock.ClusterVersion76: "7.6.0-2176",
+}
+
+// genServerVersion returns the full version of the server which a cluster
+// emulates, including its edition.
+func genServerVersion(c mock.Cluster) string {
+	return serverBuildsByVersion[c.Version()] + "-" + string(c.Edition())
+}
+
+// genClusterCompatibility returns the compatibility version of a cluster, which
+// encodes the major version of the server it emulates in the upper half and
+// the minor version in the lower half.
+func genClusterCompatibility(c mock.Cluster) int {
+	var major, minor int
+	_, _ = fmt.Sscanf(string(c.Version()), "%d.%d", &major, &minor)
+	return major<<16 | minor
+}
+
 // GenPoolsConfig returns the current config for the default pool.
 func GenPoolsConfig(c mock.Cluster) []byte {
 	config := make(map[string]interface{})
 
-	uuid := strings.Replace(uuid.New().String(), "-", "", -1)
+	uuid := strings.Replace(c.ID(), "-", "", -1)
 	config["uuid"] = uuid
-	config["isEnterprise"] = true
+	config["isEnterprise"] = c.Edition() == mock.ClusterEditionEnterprise
 	config["isAdminCreds"] = true
 	config["isROAdminCreds"] = false
-	config["allowedServices"] = []string{
-		"kv",
-		"n1ql",
-		"index",
-		"fts",
-		"cbas",
-		"eventing",
-		"backup",
+	if c.Edition() == mock.ClusterEditionEnterprise {
+		config["allowedServices"] = []string{
+			"kv",
+			"n1ql",
+			"index",
+			"fts",
+			"cbas",
+			"eventing",
+			"backup",
+		}
+	} else {
+		config["allowedServices"] = []string{
+			"kv",
+			"n1ql",
+			"index",
+			"fts",
+		}
 	}
 	config["isIPv6"] = false
 	config["isDeveloperPreview"] = false
@@ -39,9 +77,9 @@ func GenPoolsConfig(c mock.Cluster) []byte {
 		"maxParallelIndexers": "/settings/maxParallelIndexers?uuid=" + uuid,
 		"viewUpdateDaemon":    "/settings/viewUpdateDaemon?uuid=" + uuid,
 	}
-	config["implementationVersion"] = "7.0.0-3016-enterprise"
+	config["implementationVersion"] = genServerVersion(c)
 	config["componentsVersion"] = map[string]string{
-		"ns_server":  "7.0.0-3016-enterprise",
+		"ns_server":  genServerVersion(c),
 		"inets":      "7.1.3.3",
 		"os_mon":     "2.5.1.1",
 		"ale":        "0.0.0",
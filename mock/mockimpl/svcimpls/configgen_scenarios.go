@@ -0,0 +1,131 @@
+package svcimpls
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// These are the networks which a node can be reached through.
+const (
+	networkDefault  = "default"
+	networkExternal = "external"
+)
+
+// externalNetworkHostname is the address of the external network of nodes.
+// As our services listen on all interfaces, this is simply another loopback
+// address which clients on the external network connect to instead.
+const externalNetworkHostname = "127.0.0.2"
+
+// genClientNetwork returns the network a kv client is connected through,
+// based on the address which it connected to.
+func genClientNetwork(source mock.KvClient) string {
+	if !source.Source().Node().HasFeature(mock.ClusterNodeFeatureExternalNetwork) {
+		return networkDefault
+	}
+
+	if tcpAddr, ok := source.LocalAddr().(*net.TCPAddr); ok && tcpAddr.IP.Equal(net.ParseIP(externalNetworkHostname)) {
+		return networkExternal
+	}
+	return networkDefault
+}
+
+// genHTTPClientNetwork returns the network an http client is connected
+// through, based on the host which it addressed its request to.
+func genHTTPClientNetwork(node mock.ClusterNode, req *mock.HTTPRequest) string {
+	if !node.HasFeature(mock.ClusterNodeFeatureExternalNetwork) {
+		return networkDefault
+	}
+
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	if net.ParseIP(host).Equal(net.ParseIP(externalNetworkHostname)) {
+		return networkExternal
+	}
+	return networkDefault
+}
+
+// genNodeHostPort returns the host and port which a config reports for one
+// of the services of a node.
+func genNodeHostPort(n mock.ClusterNode, hostname string, port int) string {
+	return net.JoinHostPort(genNodeHostname(n, hostname), strconv.Itoa(port))
+}
+
+// genHTTPBaseURL returns the base URL of an http service, bracketing IPv6
+// addresses and escaping their zones.
+func genHTTPBaseURL(hostname string, port int) string {
+	return (&url.URL{Scheme: "http", Host: net.JoinHostPort(hostname, strconv.Itoa(port))}).String()
+}
+
+// genNodeHostname returns the hostname which a config reports for a node.
+func genNodeHostname(n mock.ClusterNode, hostname string) string {
+	if n.Cluster().ConfigScenario() == mock.ConfigScenarioIPv6Hostnames {
+		return "::1"
+	}
+	return hostname
+}
+
+// genOmitsKvPorts indicates whether the kv ports of a node are left out of
+// the configs for the active config scenario.
+func genOmitsKvPorts(n mock.ClusterNode) bool {
+	if n.Cluster().ConfigScenario() != mock.ConfigScenarioMissingKvPort {
+		return false
+	}
+
+	// We always keep the first node intact so clients are able to bootstrap.
+	nodes := n.Cluster().Nodes()
+	return len(nodes) > 1 && nodes[len(nodes)-1] == n
+}
+
+// genVbServerMap returns the vBucketServerMap section of a bucket config.
+func genVbServerMap(b mock.Bucket, kvNodes []mock.ClusterNode, vbMap [][]int) map[string]interface{} {
+	scenario := b.Cluster().ConfigScenario()
+	numReplicas := b.NumReplicas()
+
+	if scenario == mock.ConfigScenarioZeroReplicas {
+		numReplicas = 0
+
+		activeVbMap := make([][]int, len(vbMap))
+		for vbIdx, repMap := range vbMap {
+			activeVbMap[vbIdx] = repMap[:1]
+		}
+		vbMap = activeVbMap
+	}
+
+	if scenario == mock.ConfigScenarioReorderedServerList {
+		// Reverse the server list, and then remap the vbucket map to match it.
+		reversedNodes := make([]mock.ClusterNode, len(kvNodes))
+		for nodeIdx, node := range kvNodes {
+			reversedNodes[len(kvNodes)-1-nodeIdx] = node
+		}
+		kvNodes = reversedNodes
+
+		remappedVbMap := make([][]int, len(vbMap))
+		for vbIdx, repMap := range vbMap {
+			remappedVbMap[vbIdx] = make([]int, len(repMap))
+			for repIdx, nodeIdx := range repMap {
+				if nodeIdx >= 0 {
+					nodeIdx = len(kvNodes) - 1 - nodeIdx
+				}
+				remappedVbMap[vbIdx][repIdx] = nodeIdx
+			}
+		}
+		vbMap = remappedVbMap
+	}
+
+	var vbServerList []interface{}
+	for _, node := range kvNodes {
+		vbServerList = append(vbServerList, genNodeHostPort(node, node.KvService().Hostname(), node.KvService().ListenPort()))
+	}
+
+	return map[string]interface{}{
+		"hashAlgorithm": "CRC",
+		"numReplicas":   numReplicas,
+		"vBucketMap":    vbMap,
+		"serverList":    vbServerList,
+	}
+}
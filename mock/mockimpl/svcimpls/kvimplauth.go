@@ -11,6 +11,74 @@ import (
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// saslMechanismsByVersion lists the SASL mechanisms which were added in each
+// version of the server.  A cluster supports the mechanisms of its own version
+// and of every version before it.
+var saslMechanismsByVersion = []struct {
+	version mock.ClusterVersion
+	mechs   []string
+}{
+	{mock.ClusterVersion50, []string{
+		"PLAIN",
+		"SCRAM-SHA1",
+	}},
+	{mock.ClusterVersion65, []string{
+		"SCRAM-SHA256",
+		"SCRAM-SHA512",
+	}},
+}
+
+// SASLMechanismsForVersion returns the SASL mechanisms which a cluster
+// emulating a specific version of the server supports.
+func SASLMechanismsForVersion(version mock.ClusterVersion) []string {
+	var mechs []string
+	for _, added := range saslMechanismsByVersion {
+		if version.AtLeast(added.version) {
+			mechs = append(mechs, added.mechs...)
+		}
+	}
+	return mechs
+}
+
+// supportedSASLMechanisms returns the SASL mechanisms which a cluster offers,
+// which tests can override in place of those of its version.
+func supportedSASLMechanisms(cluster mock.Cluster) []string {
+	if mechs := cluster.SASLMechanisms(); mechs != nil {
+		return mechs
+	}
+	return SASLMechanismsForVersion(cluster.Version())
+}
+
+// isSASLMechanismSupported returns whether a cluster accepts a SASL mechanism.
+func isSASLMechanismSupported(cluster mock.Cluster, mech string) bool {
+	for _, supportedMech := range supportedSASLMechanisms(cluster) {
+		if supportedMech == mech {
+			return true
+		}
+	}
+	return false
+}
+
+// auditKvAuth records the outcome of a SASL authentication in the audit log
+// of the cluster.
+func auditKvAuth(source mock.KvClient, mech, userName string, succeeded bool) {
+	eventID := mock.AuditEventKvAuthFailed
+	if succeeded {
+		eventID = mock.AuditEventKvAuthSucceeded
+	}
+
+	cluster := source.Source().Node().Cluster()
+	cluster.AuditLog().Record(mock.AuditEvent{
+		ID:        eventID,
+		Timestamp: cluster.Chrono().Now(),
+		User:      userName,
+		Remote:    source.RemoteAddr().String(),
+		Fields: map[string]string{
+			"mechanism": mech,
+		},
+	})
+}
+
 type kvImplAuth struct {
 }
 
@@ -22,13 +90,7 @@ func (x *kvImplAuth) Register(h *hookHelper) {
 }
 
 func (x *kvImplAuth) handleSASLListMechsRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
-	// TODO(brett19): Implement actual auth mechanism configuration support.
-	supportedMechs := []string{
-		"PLAIN",
-		"SCRAM-SHA1",
-		"SCRAM-SHA256",
-		"SCRAM-SHA512",
-	}
+	supportedMechs := supportedSASLMechanisms(source.Source().Node().Cluster())
 
 	supportedBytes := []byte(strings.Join(supportedMechs, " "))
 
@@ -42,8 +104,8 @@ func (x *kvImplAuth) handleSASLListMechsRequest(source mock.KvClient, pak *memd.
 }
 
 func (x *kvImplAuth) handleAuthClient(source mock.KvClient, pak *memd.Packet, mech, username, password string, start time.Time) {
-	user := source.Source().Node().Cluster().Users().GetUser(username)
-	if user == nil {
+	if !source.Source().Node().Cluster().Authenticator().Authenticate(username, password) {
+		auditKvAuth(source, mech, username, false)
 		writePacketToSource(source, &memd.Packet{
 			Magic:   memd.CmdMagicRes,
 			Command: pak.Command,
@@ -54,6 +116,7 @@ func (x *kvImplAuth) handleAuthClient(source mock.KvClient, pak *memd.Packet, me
 	}
 
 	source.SetAuthenticatedUserName(username)
+	auditKvAuth(source, mech, username, true)
 
 	writePacketToSource(source, &memd.Packet{
 		Magic:   memd.CmdMagicRes,
@@ -66,6 +129,12 @@ func (x *kvImplAuth) handleAuthClient(source mock.KvClient, pak *memd.Packet, me
 func (x *kvImplAuth) handleSASLAuthRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	authMech := string(pak.Key)
 
+	// Mechanisms which are not offered are rejected the same way as those
+	// which are not known at all.
+	if !isSASLMechanismSupported(source.Source().Node().Cluster(), authMech) {
+		authMech = ""
+	}
+
 	switch authMech {
 	case "SCRAM-SHA512":
 		fallthrough
@@ -77,6 +146,7 @@ func (x *kvImplAuth) handleSASLAuthRequest(source mock.KvClient, pak *memd.Packe
 		if err != nil {
 			// SASL failure
 			// TODO(brett19): Provide better diagnostics here?
+			auditKvAuth(source, authMech, scram.Username(), false)
 			writePacketToSource(source, &memd.Packet{
 				Magic:   memd.CmdMagicRes,
 				Command: memd.CmdSASLAuth,
@@ -86,14 +156,20 @@ func (x *kvImplAuth) handleSASLAuthRequest(source mock.KvClient, pak *memd.Packe
 			return
 		}
 
+		user := source.Source().Node().Cluster().Users().GetUser(scram.Username())
 		if outBytes == nil {
-			// SASL already completed
-			x.handleAuthClient(source, pak, authMech, scram.Username(), scram.Password(), start)
+			// SASL already completed, which proves the client knows the stored
+			// password, but the authenticator still gets the final say.
+			var password string
+			if user != nil {
+				password = user.Password
+			}
+			x.handleAuthClient(source, pak, authMech, scram.Username(), password, start)
 			return
 		}
 
-		user := source.Source().Node().Cluster().Users().GetUser(scram.Username())
 		if user == nil {
+			auditKvAuth(source, authMech, scram.Username(), false)
 			writePacketToSource(source, &memd.Packet{
 				Magic:   memd.CmdMagicRes,
 				Command: pak.Command,
@@ -123,6 +199,7 @@ func (x *kvImplAuth) handleSASLAuthRequest(source mock.KvClient, pak *memd.Packe
 	}
 
 	// Unsupported mechanism!
+	auditKvAuth(source, string(pak.Key), "", false)
 	writePacketToSource(source, &memd.Packet{
 		Magic:   memd.CmdMagicRes,
 		Command: memd.CmdSASLAuth,
@@ -136,6 +213,10 @@ func (x *kvImplAuth) handleSASLStepRequest(source mock.KvClient, pak *memd.Packe
 
 	log.Printf("AUTH STEP: %+v, %s", authMech, pak.Value)
 
+	if !isSASLMechanismSupported(source.Source().Node().Cluster(), authMech) {
+		authMech = ""
+	}
+
 	switch authMech {
 	case "SCRAM-SHA512":
 		fallthrough
@@ -143,8 +224,8 @@ func (x *kvImplAuth) handleSASLStepRequest(source mock.KvClient, pak *memd.Packe
 		fallthrough
 	case "SCRAM-SHA1":
 		// These are all accepted
-	case "PLAIN":
-		// Unsupported mechanism!
+	default:
+		// PLAIN completes in a single step, anything else is unsupported.
 		writePacketToSource(source, &memd.Packet{
 			Magic:   memd.CmdMagicRes,
 			Command: memd.CmdSASLStep,
@@ -155,10 +236,24 @@ func (x *kvImplAuth) handleSASLStepRequest(source mock.KvClient, pak *memd.Packe
 	}
 
 	scram := source.ScramServer()
+	if scram.Mechanism() != authMech {
+		// The step must continue the same mechanism that the auth started.
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: memd.CmdSASLStep,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusAuthError,
+		}, start)
+		return
+	}
+
+	// The step is the final message of the exchange, so it either succeeds
+	// with the server signature, or fails.  It never continues.
 	outBytes, err := scram.Step(pak.Value)
 	if err != nil {
 		// SASL failure
 		// TODO(brett19): Provide better diagnostics here?
+		auditKvAuth(source, authMech, scram.Username(), false)
 		writePacketToSource(source, &memd.Packet{
 			Magic:   memd.CmdMagicRes,
 			Command: memd.CmdSASLStep,
@@ -169,6 +264,7 @@ func (x *kvImplAuth) handleSASLStepRequest(source mock.KvClient, pak *memd.Packe
 	}
 
 	source.SetAuthenticatedUserName(scram.Username())
+	auditKvAuth(source, authMech, scram.Username(), true)
 	writePacketToSource(source, &memd.Packet{
 		Magic:   memd.CmdMagicRes,
 		Command: memd.CmdSASLStep,
@@ -179,14 +275,15 @@ func (x *kvImplAuth) handleSASLStepRequest(source mock.KvClient, pak *memd.Packe
 }
 
 func (x *kvImplAuth) handleSelectBucketRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
-	if !source.CheckAuthenticated(mockauth.PermissionSelect, pak.CollectionID) {
-		// TODO(chvck): CheckAuthenticated needs to change, this could be actually be auth or access error depending on the user
-		// access levels.
+	// Selecting a bucket is checked against the bucket being selected, rather
+	// than any which is currently selected.
+	authenticator := source.Source().Node().Cluster().Authenticator()
+	if !authenticator.Authorize(source.AuthenticatedUserName(), mockauth.PermissionSelect, string(pak.Key), "", "") {
 		writePacketToSource(source, &memd.Packet{
 			Magic:   memd.CmdMagicRes,
-			Command: memd.CmdGetClusterConfig,
+			Command: pak.Command,
 			Opaque:  pak.Opaque,
-			Status:  memd.StatusAuthError,
+			Status:  memd.StatusAccessError,
 		}, start)
 		return
 	}
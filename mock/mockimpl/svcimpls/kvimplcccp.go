@@ -15,6 +15,8 @@ func (x *kvImplCccp) Register(h *hookHelper) {
 }
 
 func (x *kvImplCccp) handleGetClusterConfigReq(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	source.Source().Node().Cluster().NotMyVbuckets().RecordConfigFetch(source.RemoteAddr().String())
+
 	if !source.CheckAuthenticated(mockauth.PermissionSettings, pak.CollectionID) {
 		// TODO(chvck): CheckAuthenticated needs to change, this could be actually be auth or access error depending on the user
 		// access levels.
@@ -31,7 +33,7 @@ func (x *kvImplCccp) handleGetClusterConfigReq(source mock.KvClient, pak *memd.P
 	var configBytes []byte
 	if selectedBucket == nil {
 		// Send a global terse configuration
-		configBytes = GenTerseClusterConfig(source.Source().Node().Cluster(), source.Source().Node())
+		configBytes = genTerseClusterConfig(source.Source().Node().Cluster(), source.Source().Node(), genClientNetwork(source))
 	} else {
 		if selectedBucket.BucketType() == mock.BucketTypeMemcached {
 			writePacketToSource(source, &memd.Packet{
@@ -42,7 +44,7 @@ func (x *kvImplCccp) handleGetClusterConfigReq(source mock.KvClient, pak *memd.P
 			}, start)
 			return
 		}
-		configBytes = GenTerseBucketConfig(selectedBucket, source.Source().Node())
+		configBytes = genTerseBucketConfig(selectedBucket, source.Source().Node(), genClientNetwork(source))
 	}
 
 	writePacketToSource(source, &memd.Packet{
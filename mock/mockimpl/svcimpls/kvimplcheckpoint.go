@@ -0,0 +1,170 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+// These commands are not yet exposed by memd.
+const (
+	cmdCreateCheckpoint      = memd.CmdCode(0x96)
+	cmdLastClosedCheckpoint  = memd.CmdCode(0x97)
+	cmdCheckpointPersistence = memd.CmdCode(0xb1)
+)
+
+// kvImplCheckpoint implements the checkpoint admin commands used by backup and
+// replication tooling.  We do not implement checkpoints themselves, these only
+// track the checkpoint ids of each vbucket.
+type kvImplCheckpoint struct {
+}
+
+func (x *kvImplCheckpoint) Register(h *hookHelper) {
+	h.RegisterKvHandler(cmdCreateCheckpoint, x.handleCreateCheckpointRequest)
+	h.RegisterKvHandler(cmdLastClosedCheckpoint, x.handleLastClosedCheckpointRequest)
+	h.RegisterKvHandler(cmdCheckpointPersistence, x.handleCheckpointPersistenceRequest)
+}
+
+func (x *kvImplCheckpoint) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  status,
+	}, start)
+}
+
+// getActiveVbucket returns the vbucket targeted by a packet, writing an error
+// reply and returning nil if it is not active on this node.
+func (x *kvImplCheckpoint) getActiveVbucket(source mock.KvClient, pak *memd.Packet, start time.Time) *mockdb.Vbucket {
+	if !source.CheckAuthenticated(mockauth.PermissionReplicationManage, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return nil
+	}
+
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return nil
+	}
+
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+	if int(pak.Vbucket) >= len(vbOwnership) || vbOwnership[pak.Vbucket] != 0 {
+		x.writeStatusReply(source, pak, memd.StatusNotMyVBucket, start)
+		return nil
+	}
+
+	return selectedBucket.Store().GetVbucket(uint(pak.Vbucket))
+}
+
+func (x *kvImplCheckpoint) handleCreateCheckpointRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	vbucket := x.getActiveVbucket(source, pak, start)
+	if vbucket == nil {
+		return
+	}
+
+	checkpointID := vbucket.CreateCheckpoint()
+	persistSeqNo := vbucket.CurrentMetaState(0).PersistSeqNo
+
+	valueBuf := make([]byte, 16)
+	binary.BigEndian.PutUint64(valueBuf[0:], checkpointID)
+	binary.BigEndian.PutUint64(valueBuf[8:], persistSeqNo)
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  memd.StatusSuccess,
+		Value:   valueBuf,
+	}, start)
+}
+
+func (x *kvImplCheckpoint) handleLastClosedCheckpointRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	vbucket := x.getActiveVbucket(source, pak, start)
+	if vbucket == nil {
+		return
+	}
+
+	valueBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(valueBuf[0:], vbucket.OpenCheckpointID()-1)
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  memd.StatusSuccess,
+		Value:   valueBuf,
+	}, start)
+}
+
+func (x *kvImplCheckpoint) handleCheckpointPersistenceRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	vbucket := x.getActiveVbucket(source, pak, start)
+	if vbucket == nil {
+		return
+	}
+
+	if len(pak.Value) != 8 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	// Checkpoints are never actually persisted, so we consider any checkpoint
+	// which has been created to already be persisted.
+	checkpointID := binary.BigEndian.Uint64(pak.Value)
+	if checkpointID > vbucket.OpenCheckpointID() {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+// genCheckpointStats generates the stats for the checkpoint stat group, which
+// can optionally be limited to a single vbucket.
+func genCheckpointStats(source mock.KvClient, args []string) (map[string]string, error) {
+	selectedBucket := source.SelectedBucket()
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+
+	var vbIdxs []int
+	if len(args) > 0 {
+		vbIdx, err := strconv.Atoi(args[0])
+		if err != nil || vbIdx < 0 || vbIdx >= len(vbOwnership) {
+			return nil, kvproc.ErrInvalidArgument
+		}
+		if vbOwnership[vbIdx] == -1 {
+			return nil, kvproc.ErrNotMyVbucket
+		}
+
+		vbIdxs = append(vbIdxs, vbIdx)
+	} else {
+		for vbIdx, repIdx := range vbOwnership {
+			if repIdx != -1 {
+				vbIdxs = append(vbIdxs, vbIdx)
+			}
+		}
+	}
+
+	stats := make(map[string]string)
+	for _, vbIdx := range vbIdxs {
+		vbucket := selectedBucket.Store().GetVbucket(uint(vbIdx))
+		openCheckpointID := vbucket.OpenCheckpointID()
+
+		state := "active"
+		if vbOwnership[vbIdx] != 0 {
+			state = "replica"
+		}
+
+		stats[fmt.Sprintf("vb_%d:state", vbIdx)] = state
+		stats[fmt.Sprintf("vb_%d:open_checkpoint_id", vbIdx)] = strconv.FormatUint(openCheckpointID, 10)
+		stats[fmt.Sprintf("vb_%d:last_closed_checkpoint_id", vbIdx)] = strconv.FormatUint(openCheckpointID-1, 10)
+	}
+
+	return stats, nil
+}
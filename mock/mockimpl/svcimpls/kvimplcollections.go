@@ -13,8 +13,10 @@ import (
 
 func (x *kvImplCrud) handleManifestRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionBucketManage, start); proc != nil {
-		manifest := source.SelectedBucket().CollectionManifest()
-		uid, scopes := manifest.GetManifest()
+		// Each node serves the manifest it has adopted, which may lag behind
+		// other nodes when manifest changes are staggered.
+		bucket := source.SelectedBucket()
+		uid, scopes := bucket.CollectionManifest().GetManifestAt(bucket.NodeManifestTime(source.Source().Node()))
 
 		jsonMani := buildJSONManifest(uid, scopes)
 		b, err := json.Marshal(jsonMani)
@@ -41,10 +43,12 @@ func (x *kvImplCrud) handleGetCollectionIDRequest(source mock.KvClient, pak *mem
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
 		}
-		manifest := source.SelectedBucket().CollectionManifest()
-		uid, cid, err := manifest.GetByName(keyParts[0], keyParts[1])
+		bucket := source.SelectedBucket()
+		manifestTime := bucket.NodeManifestTime(source.Source().Node())
+		uid, cid, err := bucket.CollectionManifest().GetByNameAt(manifestTime, keyParts[0], keyParts[1])
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
+			return
 		}
 
 		extrasBuf := make([]byte, 12)
@@ -0,0 +1,33 @@
+package svcimpls
+
+import (
+	"fmt"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// kvConnLimitExceeded checks whether a client is beyond the connection limit
+// of its bucket or user on its node, recording the rejection if it is.
+func kvConnLimitExceeded(source mock.KvClient, bucket mock.Bucket) bool {
+	limits := source.Source().Node().Cluster().KvConnectionLimits()
+	return !limits.Admit(source, bucket.Name(), source.AuthenticatedUserName())
+}
+
+// genErrorMap returns the error map which a node serves.  While connection
+// limits are set, busy responses advertise how they should be retried.
+func genErrorMap(source mock.KvClient) *mock.ErrorMap {
+	errMap := source.Source().Node().ErrorMap()
+
+	limits := source.Source().Node().Cluster().KvConnectionLimits()
+	if !limits.HasLimits() {
+		return errMap
+	}
+
+	retry := limits.Retry()
+	busyKey := fmt.Sprintf("%x", uint16(memd.StatusBusy))
+	busyErr := errMap.Errors[busyKey]
+	busyErr.Attrs = []string{"temp", "auto-retry"}
+	busyErr.Retry = &retry
+	return errMap.Clone().Extend(busyKey, busyErr)
+}
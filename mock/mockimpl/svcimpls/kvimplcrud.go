@@ -7,6 +7,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
@@ -15,32 +16,67 @@ import (
 	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
 )
 
+// These commands and statuses are not yet exposed by memd.
+const (
+	cmdEvictKey   = memd.CmdCode(0x93)
+	cmdReturnMeta = memd.CmdCode(0xb2)
+
+	statusWouldThrottle = memd.StatusCode(0x0c)
+	statusConfigOnly    = memd.StatusCode(0x0d)
+	statusBucketPaused  = memd.StatusCode(0x50)
+
+	statusSubDocXattrInvalidOrder = memd.StatusCode(0xd4)
+)
+
+// The mutation types of a RETURN_META request.
+const (
+	returnMetaTypeSet = 1
+	returnMetaTypeAdd = 2
+	returnMetaTypeDel = 3
+)
+
+// The maximum length of a key.  With collections, keys are prefixed with their
+// leb128 encoded collection ID, and the limit covers the encoded key.  The
+// limit is one byte larger so that keys in the default collection can still
+// use the full 250 bytes.
+const (
+	maxKeyLength            = 250
+	maxCollectionsKeyLength = 251
+)
+
 type kvImplCrud struct {
 }
 
 func (x *kvImplCrud) Register(h *hookHelper) {
-	h.RegisterKvHandler(memd.CmdAdd, x.handleAddRequest)
-	h.RegisterKvHandler(memd.CmdSet, x.handleSetRequest)
-	h.RegisterKvHandler(memd.CmdReplace, x.handleReplaceRequest)
+	h.RegisterKvHandler(memd.CmdAdd, x.syncWrite(x.handleAddRequest))
+	h.RegisterKvHandler(memd.CmdSet, x.syncWrite(x.handleSetRequest))
+	h.RegisterKvHandler(memd.CmdReplace, x.syncWrite(x.handleReplaceRequest))
 	h.RegisterKvHandler(memd.CmdGet, x.handleGetRequest)
 	h.RegisterKvHandler(memd.CmdGetMeta, x.handleGetMetaRequest)
 	h.RegisterKvHandler(memd.CmdGetRandom, x.handleGetRandomRequest)
+	h.RegisterKvHandler(cmdEvictKey, x.handleEvictKeyRequest)
 	h.RegisterKvHandler(memd.CmdGetReplica, x.handleGetReplicaRequest)
-	h.RegisterKvHandler(memd.CmdDelete, x.handleDeleteRequest)
-	h.RegisterKvHandler(memd.CmdIncrement, x.handleIncrementRequest)
-	h.RegisterKvHandler(memd.CmdDecrement, x.handleDecrementRequest)
-	h.RegisterKvHandler(memd.CmdAppend, x.handleAppendRequest)
-	h.RegisterKvHandler(memd.CmdPrepend, x.handlePrependRequest)
+	h.RegisterKvHandler(memd.CmdDelete, x.syncWrite(x.handleDeleteRequest))
+	h.RegisterKvHandler(cmdReturnMeta, x.handleReturnMetaRequest)
+	h.RegisterKvHandler(memd.CmdIncrement, x.syncWrite(x.handleIncrementRequest))
+	h.RegisterKvHandler(memd.CmdDecrement, x.syncWrite(x.handleDecrementRequest))
+	h.RegisterKvHandler(memd.CmdAppend, x.syncWrite(x.handleAppendRequest))
+	h.RegisterKvHandler(memd.CmdPrepend, x.syncWrite(x.handlePrependRequest))
 	h.RegisterKvHandler(memd.CmdTouch, x.handleTouchRequest)
 	h.RegisterKvHandler(memd.CmdGAT, x.handleGATRequest)
 	h.RegisterKvHandler(memd.CmdGetLocked, x.handleGetLockedRequest)
 	h.RegisterKvHandler(memd.CmdUnlockKey, x.handleUnlockRequest)
 	h.RegisterKvHandler(memd.CmdSubDocMultiLookup, x.handleMultiLookupRequest)
-	h.RegisterKvHandler(memd.CmdSubDocMultiMutation, x.handleMultiMutateRequest)
+	h.RegisterKvHandler(memd.CmdSubDocMultiMutation, x.syncWrite(x.handleMultiMutateRequest))
+	h.RegisterKvHandler(memd.CmdSubDocGetCount, x.handleGetCountRequest)
+	h.RegisterKvHandler(memd.CmdObserve, x.handleObserve)
 	h.RegisterKvHandler(memd.CmdObserveSeqNo, x.handleObserveSeqNo)
 	h.RegisterKvHandler(memd.CmdCollectionsGetManifest, x.handleManifestRequest)
 	h.RegisterKvHandler(memd.CmdCollectionsGetID, x.handleGetCollectionIDRequest)
 	h.RegisterKvHandler(memd.CmdStat, x.handleStatsRequest)
+	h.RegisterKvHandler(cmdRangeScanCreate, x.handleRangeScanCreateRequest)
+	h.RegisterKvHandler(cmdRangeScanContinue, x.handleRangeScanContinueRequest)
+	h.RegisterKvHandler(cmdRangeScanCancel, x.handleRangeScanCancelRequest)
 }
 
 func (x *kvImplCrud) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
@@ -52,25 +88,248 @@ func (x *kvImplCrud) writeStatusReply(source mock.KvClient, pak *memd.Packet, st
 	}, start)
 }
 
+// nonDocumentKeyCommands are the commands whose key is not the key of a
+// document, such as a stat group or a collection path, and so is not limited
+// to the maximum key length.
+var nonDocumentKeyCommands = map[memd.CmdCode]bool{
+	memd.CmdStat:             true,
+	memd.CmdCollectionsGetID: true,
+}
+
+// keyIsTooLong checks whether a key exceeds the maximum key length, including
+// its collection ID prefix if the client is using collections.
+func (x *kvImplCrud) keyIsTooLong(source mock.KvClient, collectionID uint32, key []byte) bool {
+	if source.HasFeature(memd.FeatureCollections) {
+		return len(memd.AppendULEB128_32(nil, collectionID))+len(key) > maxCollectionsKeyLength
+	}
+	return len(key) > maxKeyLength
+}
+
 // makeProc either writes a reply to the network, or returns a non-nil Engine to use.
 func (x *kvImplCrud) makeProc(source mock.KvClient, pak *memd.Packet, permission mockauth.Permission, start time.Time) *kvproc.Engine {
+	sourceNode := source.Source().Node()
+
+	// Clients whose credentials have gone stale must authenticate again before
+	// the server will process any of their operations.
+	if source.IsAuthStale() {
+		x.writeStatusReply(source, pak, memd.StatusAuthStale, start)
+		return nil
+	}
+
+	// Over-length keys are rejected before anything else about the request.
+	if !nonDocumentKeyCommands[pak.Command] && x.keyIsTooLong(source, pak.CollectionID, pak.Key) {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return nil
+	}
+
 	selectedBucket := source.SelectedBucket()
 	if selectedBucket == nil {
+		if sourceNode.HasFeature(mock.ClusterNodeFeatureConfigOnly) {
+			// Bucketless connections can only be used to fetch the global config.
+			x.writeStatusReply(source, pak, statusConfigOnly, start)
+			return nil
+		}
+
 		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
 		return nil
 	}
 
-	sourceNode := source.Source().Node()
 	vbOwnership := selectedBucket.VbucketOwnership(sourceNode)
 
 	if !source.CheckAuthenticated(permission, pak.CollectionID) {
-		// TODO(chvck): CheckAuthenticated needs to change, this could be actually be auth or access error depending on the user
-		// access levels.
-		x.writeStatusReply(source, pak, memd.StatusAuthError, start)
+		// Permissions can be limited to specific scopes and collections, so
+		// the same user may be denied access on one collection but not another.
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return nil
+	}
+
+	// Connections beyond a soft connection limit stay open, but are told to
+	// retry their operations until other connections have closed.
+	if kvConnLimitExceeded(source, selectedBucket) {
+		x.writeStatusReply(source, pak, memd.StatusBusy, start)
+		return nil
+	}
+
+	// A paused bucket has been moved out to cloud storage, so it cannot serve
+	// any operations until it has been fully resumed.
+	if pauseState := selectedBucket.PauseState(); pauseState == mock.BucketPauseStatePaused ||
+		pauseState == mock.BucketPauseStateResuming {
+		x.writeStatusReply(source, pak, statusBucketPaused, start)
+		return nil
+	}
+
+	// Clients which asked not to be blocked by throttling are instead warned
+	// that their request would have been throttled, without it being run.
+	// Other clients would only see their requests delayed.
+	if source.HasFeature(featureNonBlockingThrottlingMode) &&
+		selectedBucket.ThrottleWarning(source.AuthenticatedUserName()) {
+		x.writeStatusReply(source, pak, statusWouldThrottle, start)
+		return nil
+	}
+
+	// Once a data limit is exceeded, all writes to the bucket are rejected.
+	if permission == mockauth.PermissionDataWrite {
+		if status := selectedBucket.DataLimitStatus(); status != memd.StatusSuccess {
+			x.writeStatusReply(source, pak, status, start)
+			return nil
+		}
+
+		// The max_size engine parameter acts as the memory quota of the bucket.
+		if maxSize := engineMaxSize(selectedBucket); maxSize > 0 && selectedBucket.Store().MemUsed() >= maxSize {
+			x.writeStatusReply(source, pak, memd.StatusOutOfMemory, start)
+			return nil
+		}
+
+		// Nothing can be evicted from a bucket which does not evict, so it
+		// runs out of memory rather than temporarily failing under pressure.
+		canEvict := selectedBucket.EvictionPolicy() != mock.EvictionPolicyNoEviction
+		if status := sourceNode.MemoryPressureStatus(canEvict); status != memd.StatusSuccess {
+			x.writeStatusReply(source, pak, status, start)
+			return nil
+		}
+	}
+
+	if pak.DurabilityLevelFrame != nil {
+		// A node which is being gracefully failed over is draining, and will
+		// not accept any new durable writes.
+		if drainingID, _, draining := sourceNode.Cluster().GracefulFailoverProgress(); draining && drainingID == sourceNode.ID() {
+			x.writeStatusReply(source, pak, memd.StatusDurabilityImpossible, start)
+			return nil
+		}
+
+		if status := x.checkDurability(selectedBucket, vbOwnership, pak); status != memd.StatusSuccess {
+			x.writeStatusReply(source, pak, status, start)
+			return nil
+		}
+	} else if permission == mockauth.PermissionDataWrite && isActiveVbucket(vbOwnership, pak.Vbucket) &&
+		selectedBucket.SyncWrites().IsPending(pak.Vbucket, pak.CollectionID, pak.Key) {
+		// Other writes to a key must wait until its pending durable write has
+		// completed.  Durable writes never get here while another is pending,
+		// as syncWrite has already rejected them.
+		x.writeStatusReply(source, pak, syncWriteInProgressStatus(source), start)
 		return nil
 	}
 
-	return kvproc.New(selectedBucket.Store(), vbOwnership)
+	fullEviction := selectedBucket.EvictionPolicy() == mock.EvictionPolicyFullEviction
+	proc := kvproc.New(selectedBucket.Store(), vbOwnership, fullEviction, sourceNode.ClockSkew())
+
+	docLimits := selectedBucket.DocumentLimits()
+	proc.SetDocumentLimits(docLimits.MaxDepth, docLimits.StrictJSON)
+	return proc
+}
+
+// checkDurability validates the durability level requested by a packet,
+// returning durability impossible if too few nodes currently hold a copy of
+// the vbucket for a majority to be reached.
+func (x *kvImplCrud) checkDurability(bucket mock.Bucket, vbOwnership []int, pak *memd.Packet) memd.StatusCode {
+	switch pak.DurabilityLevelFrame.DurabilityLevel {
+	case memd.DurabilityLevelMajority:
+	case memd.DurabilityLevelMajorityAndPersistOnMaster, memd.DurabilityLevelPersistToMajority:
+		// Ephemeral buckets have nothing to persist to.
+		if bucket.BucketType() == mock.BucketTypeEphemeral {
+			return memd.StatusDurabilityInvalidLevel
+		}
+	default:
+		return memd.StatusDurabilityInvalidLevel
+	}
+
+	if bucket.BucketType() == mock.BucketTypeMemcached {
+		return memd.StatusNotSupported
+	}
+
+	// Leave vbuckets we do not own to the normal not-my-vbucket handling.
+	if !isActiveVbucket(vbOwnership, pak.Vbucket) {
+		return memd.StatusSuccess
+	}
+
+	_, vbMap, _ := bucket.GetVbServerInfo(nil)
+
+	numAvailableCopies := 0
+	for _, nodeIdx := range vbMap[pak.Vbucket] {
+		if nodeIdx >= 0 {
+			numAvailableCopies++
+		}
+	}
+
+	majority := int(bucket.NumReplicas()+1)/2 + 1
+	if numAvailableCopies < majority {
+		return memd.StatusDurabilityImpossible
+	}
+
+	return memd.StatusSuccess
+}
+
+// isSyncWriteCommand returns whether a command is a mutation which accepts
+// durability requirements.  Each of these is registered through syncWrite.
+func isSyncWriteCommand(cmd memd.CmdCode) bool {
+	switch cmd {
+	case memd.CmdAdd, memd.CmdSet, memd.CmdReplace, memd.CmdDelete, memd.CmdIncrement,
+		memd.CmdDecrement, memd.CmdAppend, memd.CmdPrepend, memd.CmdSubDocMultiMutation:
+		return true
+	}
+	return false
+}
+
+// syncWriteInProgressStatus returns the status which tells a client that a key
+// has a durable write pending.  Older clients which did not negotiate extended
+// errors are told that this is a temporary failure instead.
+func syncWriteInProgressStatus(source mock.KvClient) memd.StatusCode {
+	if !source.HasFeature(memd.FeatureXerror) {
+		return memd.StatusTmpFail
+	}
+	return memd.StatusSyncWriteInProgress
+}
+
+// syncWrite wraps the handler of a mutation, so that a durable write holds its
+// key pending for the commit duration of the bucket, and other writes to the
+// key are rejected until it has committed or timed out.  The mutation is only
+// applied once the write commits, so it is never visible before then.
+func (x *kvImplCrud) syncWrite(handler func(source mock.KvClient, pak *memd.Packet, start time.Time)) func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	return func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+		// Buckets which cannot be written durably leave the handler to reject
+		// the write.
+		bucket := source.SelectedBucket()
+		if pak.DurabilityLevelFrame == nil || bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			handler(source, pak, start)
+			return
+		}
+
+		// Leave vbuckets we do not own to the normal not-my-vbucket handling.
+		if !isActiveVbucket(bucket.VbucketOwnership(source.Source().Node()), pak.Vbucket) {
+			handler(source, pak, start)
+			return
+		}
+
+		syncWrites := bucket.SyncWrites()
+		if !syncWrites.Begin(pak.Vbucket, pak.CollectionID, pak.Key, pak) {
+			x.writeStatusReply(source, pak, syncWriteInProgressStatus(source), start)
+			return
+		}
+
+		commitDuration := syncWrites.CommitDuration()
+		if commitDuration == 0 {
+			handler(source, pak, start)
+			syncWrites.End(pak.Vbucket, pak.CollectionID, pak.Key, pak)
+			return
+		}
+
+		// The write commits in the background, so that the connection goes on
+		// processing other requests meanwhile, as a server does for clients
+		// which let their requests complete out of order.
+		go func() {
+			defer syncWrites.End(pak.Vbucket, pak.CollectionID, pak.Key, pak)
+
+			if timeoutFrame := pak.DurabilityTimeoutFrame; timeoutFrame != nil &&
+				timeoutFrame.DurabilityTimeout > 0 && timeoutFrame.DurabilityTimeout < commitDuration {
+				time.Sleep(timeoutFrame.DurabilityTimeout)
+				x.writeStatusReply(source, pak, memd.StatusSyncWriteAmbiguous, start)
+				return
+			}
+
+			time.Sleep(commitDuration)
+			handler(source, pak, start)
+		}()
+	}
 }
 
 func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
@@ -114,6 +373,10 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 		return memd.StatusSubDocCantInsert
 	case kvproc.ErrSdBadCombo:
 		return memd.StatusSubDocBadCombo
+	case kvproc.ErrSdDocTooDeep:
+		return memd.StatusSubDocDocTooDeep
+	case kvproc.ErrSdValueTooDeep:
+		return memd.StatusSubDocValueTooDeep
 	case kvproc.ErrInvalidArgument:
 		return memd.StatusInvalidArgs
 	case kvproc.ErrSdInvalidXattr:
@@ -130,6 +393,18 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 		return memd.StatusCollectionUnknown
 	case kvproc.ErrSdXattrInvalidKeyCombo:
 		return memd.StatusSubDocXattrInvalidKeyCombo
+	case kvproc.ErrSdXattrInvalidOrder:
+		return statusSubDocXattrInvalidOrder
+	case kvproc.ErrSdXattrUnknownVattr:
+		return memd.StatusSubDocXattrUnknownVAttr
+	case mock.ErrRangeScanNotFound:
+		return memd.StatusKeyNotFound
+	case mock.ErrRangeScanCancelled:
+		return statusRangeScanCancelled
+	case kvproc.ErrVbUUIDNotEqual:
+		return statusVbUUIDNotEqual
+	case kvproc.ErrSeqNoNotReached:
+		return memd.StatusTmpFail
 	}
 
 	log.Printf("Recieved unexpected crud proc error: %s", err)
@@ -137,7 +412,21 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 }
 
 func (x *kvImplCrud) writeProcErr(source mock.KvClient, pak *memd.Packet, err error, start time.Time) {
-	x.writeStatusReply(source, pak, x.translateProcErr(err), start)
+	status := x.translateProcErr(err)
+
+	// StatusLocked is an extended error, so older clients which did not
+	// negotiate them are told that GET_AND_LOCK of a locked document is a
+	// temporary failure, and that any other operation on it had a cas
+	// mismatch, as they were before the status was introduced.
+	if status == memd.StatusLocked && !source.HasFeature(memd.FeatureXerror) {
+		if pak.Command == memd.CmdGetLocked {
+			status = memd.StatusTmpFail
+		} else {
+			status = memd.StatusKeyExists
+		}
+	}
+
+	x.writeStatusReply(source, pak, status, start)
 }
 
 func (x *kvImplCrud) handleGetRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
@@ -220,6 +509,53 @@ func (x *kvImplCrud) handleGetMetaRequest(source mock.KvClient, pak *memd.Packet
 	}
 }
 
+func (x *kvImplCrud) handleEvictKeyRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionBucketManage, start); proc != nil {
+		if len(pak.Extras) != 0 || len(pak.Value) != 0 {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		// Ephemeral buckets have nowhere to evict documents to.
+		if source.SelectedBucket().BucketType() == mock.BucketTypeEphemeral {
+			x.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+			return
+		}
+
+		resp, err := proc.Evict(kvproc.EvictOptions{
+			Vbucket:      uint(pak.Vbucket),
+			CollectionID: uint(pak.CollectionID),
+			Key:          pak.Key,
+		})
+		if err == kvproc.ErrDocExists {
+			writePacketToSource(source, &memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+				Status:  memd.StatusKeyExists,
+				Value:   []byte("Can't eject: Dirty object."),
+			}, start)
+			return
+		} else if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		message := "Ejected."
+		if resp.AlreadyEvicted {
+			message = "Already ejected."
+		}
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusSuccess,
+			Value:   []byte(message),
+		}, start)
+	}
+}
+
 func (x *kvImplCrud) handleGetRandomRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
 		var collectionID uint32
@@ -268,9 +604,11 @@ func (x *kvImplCrud) handleGetReplicaRequest(source mock.KvClient, pak *memd.Pac
 			Key:          pak.Key,
 		})
 		if err != nil {
+			x.recordReplicaRead(source, pak, x.translateProcErr(err))
 			x.writeProcErr(source, pak, err, start)
 			return
 		}
+		x.recordReplicaRead(source, pak, memd.StatusSuccess)
 
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
@@ -288,6 +626,27 @@ func (x *kvImplCrud) handleGetReplicaRequest(source mock.KvClient, pak *memd.Pac
 	}
 }
 
+// recordReplicaRead records which copy of a vbucket served a replica read, so
+// that tests can verify how the read was routed.
+func (x *kvImplCrud) recordReplicaRead(source mock.KvClient, pak *memd.Packet, status memd.StatusCode) {
+	node := source.Source().Node()
+	bucket := source.SelectedBucket()
+
+	replicaIdx := -1
+	if vbOwnership := bucket.VbucketOwnership(node); int(pak.Vbucket) < len(vbOwnership) {
+		replicaIdx = vbOwnership[pak.Vbucket]
+	}
+
+	node.Cluster().ReplicaReads().Record(mock.ReplicaRead{
+		NodeID:     node.ID(),
+		BucketName: bucket.Name(),
+		Vbucket:    pak.Vbucket,
+		Key:        pak.Key,
+		ReplicaIdx: replicaIdx,
+		Status:     status,
+	})
+}
+
 func (x *kvImplCrud) handleAddRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
 		if len(pak.Extras) != 8 {
@@ -465,6 +824,53 @@ func (x *kvImplCrud) handleDeleteRequest(source mock.KvClient, pak *memd.Packet,
 	}
 }
 
+func (x *kvImplCrud) handleReturnMetaRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		if len(pak.Extras) != 12 {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		// Only deletions are currently supported, sets and adds should use the
+		// regular commands instead.
+		switch binary.BigEndian.Uint32(pak.Extras[0:]) {
+		case returnMetaTypeDel:
+		case returnMetaTypeSet, returnMetaTypeAdd:
+			x.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+			return
+		default:
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		resp, err := proc.Delete(kvproc.DeleteOptions{
+			Vbucket:      uint(pak.Vbucket),
+			CollectionID: uint(pak.CollectionID),
+			Key:          pak.Key,
+			Cas:          pak.Cas,
+		})
+		if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		// The metadata of the tombstone is reported as GET_META would.
+		extrasBuf := make([]byte, 16)
+		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
+		binary.BigEndian.PutUint32(extrasBuf[4:], uint32(resp.ExpTime.Unix()))
+		binary.BigEndian.PutUint64(extrasBuf[8:], resp.SeqNo)
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusSuccess,
+			Cas:     resp.Cas,
+			Extras:  extrasBuf,
+		}, start)
+	}
+}
+
 func (x *kvImplCrud) handleIncrementRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
 		if len(pak.Extras) != 20 {
@@ -886,6 +1292,9 @@ func (x *kvImplCrud) handleMultiLookupRequest(source mock.KvClient, pak *memd.Pa
 				status = memd.StatusSubDocBadMulti
 			}
 		}
+		if !anOperationFailed {
+			source.Source().Node().Cluster().Warnings().RecordSubDocSuccess(pak.Key)
+		}
 
 		writePacketToSource(source, &memd.Packet{
 			Magic:   memd.CmdMagicRes,
@@ -898,6 +1307,69 @@ func (x *kvImplCrud) handleMultiLookupRequest(source mock.KvClient, pak *memd.Pa
 	}
 }
 
+// handleGetCountRequest handles the single path form of GET_COUNT, which
+// reports the number of elements in an array or keys in an object.
+func (x *kvImplCrud) handleGetCountRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		// The extras contain the path length and the path flags, optionally
+		// followed by the document flags.  The value is the path itself.
+		if len(pak.Extras) != 3 && len(pak.Extras) != 4 {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		pathLen := int(binary.BigEndian.Uint16(pak.Extras[0:]))
+		opFlags := memd.SubdocFlag(pak.Extras[2])
+		var docFlags memd.SubdocDocFlag
+		if len(pak.Extras) == 4 {
+			docFlags = memd.SubdocDocFlag(pak.Extras[3])
+		}
+
+		if pathLen != len(pak.Value) {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		resp, err := proc.MultiLookup(kvproc.MultiLookupOptions{
+			Vbucket:       uint(pak.Vbucket),
+			CollectionID:  uint(pak.CollectionID),
+			Key:           pak.Key,
+			AccessDeleted: docFlags&memd.SubdocDocFlagAccessDeleted != 0,
+			Ops: []*kvproc.SubDocOp{
+				{
+					Op:          memd.SubDocOpGetCount,
+					Path:        string(pak.Value),
+					IsXattrPath: opFlags&memd.SubdocFlagXattrPath != 0,
+				},
+			},
+		})
+		if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		// Single path operations report the status of the path directly,
+		// rather than wrapping it in a multi-path failure.
+		opRes := resp.Ops[0]
+		status := x.translateProcErr(opRes.Err)
+		if status == memd.StatusSuccess && resp.IsDeleted {
+			status = memd.StatusSubDocSuccessDeleted
+		}
+		if opRes.Err == nil {
+			source.Source().Node().Cluster().Warnings().RecordSubDocSuccess(pak.Key)
+		}
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  status,
+			Cas:     resp.Cas,
+			Value:   opRes.Value,
+		}, start)
+	}
+}
+
 func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
 		var docFlags memd.SubdocDocFlag
@@ -1074,6 +1546,10 @@ func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Pa
 			return
 		}
 
+		// The kv protocol has no way to return a warning alongside a success, so
+		// any injected warning is only recorded for the test to inspect.
+		source.Source().Node().Cluster().Warnings().RecordSubDocSuccess(pak.Key)
+
 		valueBytes := make([]byte, 0)
 		for opIdx, opRes := range resp.Ops {
 			if opRes.Err == nil && len(opRes.Value) > 0 {
@@ -1127,6 +1603,74 @@ func (x *kvImplCrud) writeSubdocMutateErr(source mock.KvClient, pak *memd.Packet
 	}, start)
 }
 
+func (x *kvImplCrud) handleObserve(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		// The value is a list of keys, each prefixed with its vbucket and the
+		// length of the key.  The reply echoes these with the state of each key.
+		var valueBuf []byte
+		keysBuf := pak.Value
+		for len(keysBuf) > 0 {
+			if len(keysBuf) < 4 {
+				x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+				return
+			}
+
+			vbID := binary.BigEndian.Uint16(keysBuf[0:])
+			keyLen := int(binary.BigEndian.Uint16(keysBuf[2:]))
+			if len(keysBuf) < 4+keyLen {
+				x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+				return
+			}
+			encodedKey := keysBuf[4 : 4+keyLen]
+			keysBuf = keysBuf[4+keyLen:]
+
+			key := encodedKey
+			var collectionID uint32
+			if source.HasFeature(memd.FeatureCollections) {
+				var idLen int
+				var err error
+				collectionID, idLen, err = memd.DecodeULEB128_32(encodedKey)
+				if err != nil {
+					x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+					return
+				}
+				key = encodedKey[idLen:]
+			}
+
+			if x.keyIsTooLong(source, collectionID, key) {
+				x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+				return
+			}
+
+			resp, err := proc.Observe(kvproc.ObserveOptions{
+				Vbucket:      uint(vbID),
+				CollectionID: uint(collectionID),
+				Key:          key,
+			})
+			if err != nil {
+				x.writeProcErr(source, pak, err, start)
+				return
+			}
+
+			entryBuf := make([]byte, 4+keyLen+9)
+			binary.BigEndian.PutUint16(entryBuf[0:], vbID)
+			binary.BigEndian.PutUint16(entryBuf[2:], uint16(keyLen))
+			copy(entryBuf[4:], encodedKey)
+			entryBuf[4+keyLen] = uint8(resp.KeyState)
+			binary.BigEndian.PutUint64(entryBuf[5+keyLen:], resp.Cas)
+			valueBuf = append(valueBuf, entryBuf...)
+		}
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusSuccess,
+			Value:   valueBuf,
+		}, start)
+	}
+}
+
 func (x *kvImplCrud) handleObserveSeqNo(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
 		if len(pak.Value) != 8 {
@@ -1145,9 +1689,18 @@ func (x *kvImplCrud) handleObserveSeqNo(source mock.KvClient, pak *memd.Packet,
 			return
 		}
 
-		// No support for failover variant yet...
-		valueBuf := make([]byte, 27)
-		valueBuf[0] = 0
+		var valueBuf []byte
+		if !resp.DidFailover {
+			valueBuf = make([]byte, 27)
+			valueBuf[0] = 0
+		} else {
+			// The failover format additionally includes the old vbuuid and the
+			// last seqno received before the failover occurred.
+			valueBuf = make([]byte, 43)
+			valueBuf[0] = 1
+			binary.BigEndian.PutUint64(valueBuf[27:], resp.OldVbUUID)
+			binary.BigEndian.PutUint64(valueBuf[35:], resp.LastSeqNo)
+		}
 		binary.BigEndian.PutUint16(valueBuf[1:], pak.Vbucket)
 		binary.BigEndian.PutUint64(valueBuf[3:], resp.VbUUID)
 		binary.BigEndian.PutUint64(valueBuf[11:], resp.PersistSeqNo)
@@ -1177,7 +1730,7 @@ func (x *kvImplCrud) handleStatsRequest(source mock.KvClient, pak *memd.Packet,
 				Value:   []byte(source.SelectedBucket().ID()),
 			}, start)
 		} else {
-			stats, err := x.getStats(string(pak.Key))
+			stats, err := x.getStats(source, string(pak.Key))
 			if err != nil {
 				x.writeProcErr(source, pak, err, start)
 				return
@@ -1205,9 +1758,17 @@ func (x *kvImplCrud) handleStatsRequest(source mock.KvClient, pak *memd.Packet,
 	}
 }
 
-func (x *kvImplCrud) getStats(key string) (map[string]string, error) {
-	if key == "" {
-		return x.defaultStats(), nil
+func (x *kvImplCrud) getStats(source mock.KvClient, key string) (map[string]string, error) {
+	if key == "checkpoint" || strings.HasPrefix(key, "checkpoint ") {
+		return genCheckpointStats(source, strings.Fields(key)[1:])
+	} else if key == "vbucket-seqno" || strings.HasPrefix(key, "vbucket-seqno ") {
+		return genVbucketSeqnoStats(source, strings.Fields(key)[1:])
+	} else if key == "" {
+		stats := x.defaultStats()
+		for k, v := range x.bgFetchStats(source) {
+			stats[k] = v
+		}
+		return stats, nil
 	} else if key == "memory" {
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
@@ -1220,14 +1781,45 @@ func (x *kvImplCrud) getStats(key string) (map[string]string, error) {
 			"ep_tap_count": "0",
 		}, nil
 	} else if key == "config" {
+		evictionPolicy := "value_only"
+		if source.SelectedBucket().EvictionPolicy() == mock.EvictionPolicyFullEviction {
+			evictionPolicy = "full_eviction"
+		}
+
 		return map[string]string{
 			"ep_dcp_conn_buffer_size": "10485760",
+			"ep_item_eviction_policy": evictionPolicy,
+			"ep_flushall_enabled":     strconv.FormatBool(engineFlushEnabled(source.SelectedBucket())),
+			"ep_max_size":             strconv.FormatUint(engineMaxSize(source.SelectedBucket()), 10),
 		}, nil
 	}
 
 	return nil, kvproc.ErrDocNotFound
 }
 
+// bgFetchStats generates the counts of the background fetches which have been
+// needed to bring evicted documents back into memory on this node.
+func (x *kvImplCrud) bgFetchStats(source mock.KvClient) map[string]string {
+	selectedBucket := source.SelectedBucket()
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+
+	var bgFetched, bgMetaFetched uint64
+	for vbIdx, repIdx := range vbOwnership {
+		if repIdx != 0 {
+			continue
+		}
+
+		fetches, metaFetches := selectedBucket.Store().GetVbucket(uint(vbIdx)).BgFetchStats()
+		bgFetched += fetches
+		bgMetaFetched += metaFetches
+	}
+
+	return map[string]string{
+		"ep_bg_fetched":      strconv.FormatUint(bgFetched, 10),
+		"ep_bg_meta_fetched": strconv.FormatUint(bgMetaFetched, 10),
+	}
+}
+
 func (x *kvImplCrud) defaultStats() map[string]string {
 	return map[string]string{
 		"pid":                 strconv.Itoa(os.Getpid()),
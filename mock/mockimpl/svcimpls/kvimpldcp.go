@@ -0,0 +1,344 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// These commands and statuses are not yet exposed by memd.
+const (
+	cmdDcpSeqnoAcknowledged = memd.CmdCode(0x69)
+
+	statusDcpStreamNotFound  = memd.StatusCode(0x0a)
+	statusDcpStreamIDInvalid = memd.StatusCode(0x8d)
+
+	statusCollectionsManifestAhead = memd.StatusCode(0x8b)
+
+	dcpStreamEndRollback = memd.StreamEndStatus(0x06)
+
+	dcpStreamAddFlagIgnorePurgedTombstones = memd.DcpStreamAddFlag(0x80)
+
+	dcpOpenFlagIncludeDeletedUserXattrs = memd.DcpOpenFlag(0x100)
+)
+
+// dcpDefaultNoopInterval is how often noops are sent to a consumer which has
+// enabled them without setting an interval.
+const dcpDefaultNoopInterval = 120 * time.Second
+
+// dcpOpenFlagsKnown is the set of DCP_OPEN flags which we understand.
+const dcpOpenFlagsKnown = memd.DcpOpenFlagProducer | memd.DcpOpenFlagNotifier |
+	memd.DcpOpenFlagIncludeXattrs | memd.DcpOpenFlagNoValue | memd.DcpOpenFlagIncludeDeleteTimes |
+	dcpOpenFlagIncludeDeletedUserXattrs
+
+// dcpConnState holds the DCP specific state of a single kv client.
+type dcpConnState struct {
+	lock   sync.Mutex
+	isOpen bool
+	name   string
+	flags  uint32
+
+	// These are configured by the consumer through DCP_CONTROL.
+	noopEnabled          bool
+	noopInterval         time.Duration
+	bufferSize           uint32
+	expiryOpcode         bool
+	streamIDsEnabled     bool
+	syncWritesEnabled    bool
+	streamEndOnClose     bool
+	oosoSnapshots        bool
+	deletedUserXattrs    bool
+	v7StatusCodesEnabled bool
+	consumerName         string
+	priority             string
+
+	streams map[dcpStreamKey]*dcpStream
+
+	// noopsRunning is set while noops are being sent to the consumer, and
+	// noopOpaque is the opaque of the last noop which was sent.
+	noopsRunning bool
+	noopOpaque   uint32
+
+	// These count the bytes sent on the connection's streams, and those
+	// acknowledged by the consumer.  They are accessed atomically.
+	sentBytes  uint64
+	ackedBytes uint64
+}
+
+// isProducer returns whether the connection streams data to the client.
+// NOTE: This must be called with the lock of the connection state held.
+func (s *dcpConnState) isProducer() bool {
+	return memd.DcpOpenFlag(s.flags)&memd.DcpOpenFlagProducer != 0
+}
+
+// isNotifier returns whether the connection only notifies the client that
+// data is available, without sending the data itself.
+// NOTE: This must be called with the lock of the connection state held.
+func (s *dcpConnState) isNotifier() bool {
+	return memd.DcpOpenFlag(s.flags)&memd.DcpOpenFlagNotifier != 0
+}
+
+func getDcpConnState(source mock.KvClient) *dcpConnState {
+	var state *dcpConnState
+	source.GetContext(&state)
+	return state
+}
+
+type kvImplDcp struct {
+}
+
+func (x *kvImplDcp) Register(h *hookHelper) {
+	h.RegisterKvHandler(memd.CmdDcpOpenConnection, x.handleOpenConnectionRequest)
+	h.RegisterKvHandler(memd.CmdDcpControl, x.handleControlRequest)
+	h.RegisterKvHandler(memd.CmdDcpStreamReq, x.handleStreamRequest)
+	h.RegisterKvHandler(memd.CmdDcpCloseStream, x.handleCloseStreamRequest)
+	h.RegisterKvHandler(memd.CmdDcpBufferAck, x.handleBufferAckRequest)
+	h.RegisterKvHandler(cmdDcpSeqnoAcknowledged, x.handleSeqnoAcknowledgedRequest)
+
+	// Consumers respond to the noops which we send them, and those responses
+	// need no further handling.
+	h.KvInHooks.Add(func(source mock.KvClient, pak *memd.Packet, start time.Time, next func()) {
+		if pak.Magic == memd.CmdMagicRes && pak.Command == memd.CmdDcpNoop {
+			return
+		}
+		next()
+	})
+}
+
+func (x *kvImplDcp) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  status,
+	}, start)
+}
+
+func (x *kvImplDcp) handleOpenConnectionRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if source.SelectedBucket() == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionDCPRead, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return
+	}
+
+	if len(pak.Extras) != 8 || len(pak.Key) == 0 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	flags := memd.DcpOpenFlag(binary.BigEndian.Uint32(pak.Extras[4:]))
+	if !x.validateOpenFlags(flags) {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	state := getDcpConnState(source)
+	state.lock.Lock()
+	state.isOpen = true
+	state.name = string(pak.Key)
+	state.flags = uint32(flags)
+	state.lock.Unlock()
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+// validateOpenFlags checks that a combination of DCP_OPEN flags is valid.  A
+// connection is either a producer, a notifier or a consumer, and the flags
+// which control the contents of the messages only apply to producers.
+func (x *kvImplDcp) validateOpenFlags(flags memd.DcpOpenFlag) bool {
+	if flags&^dcpOpenFlagsKnown != 0 {
+		return false
+	}
+
+	if flags&memd.DcpOpenFlagProducer != 0 && flags&memd.DcpOpenFlagNotifier != 0 {
+		return false
+	}
+
+	producerOnlyFlags := memd.DcpOpenFlagIncludeXattrs | memd.DcpOpenFlagNoValue | memd.DcpOpenFlagIncludeDeleteTimes |
+		dcpOpenFlagIncludeDeletedUserXattrs
+	if flags&memd.DcpOpenFlagProducer == 0 && flags&producerOnlyFlags != 0 {
+		return false
+	}
+
+	return true
+}
+
+// applyControl applies a single DCP_CONTROL option to the connection state,
+// returning false if the option or its value is not recognized.
+func (x *kvImplDcp) applyControl(state *dcpConnState, key, value string) bool {
+	parseBool := func(dst *bool) bool {
+		switch value {
+		case "true":
+			*dst = true
+		case "false":
+			*dst = false
+		default:
+			return false
+		}
+		return true
+	}
+
+	switch key {
+	case "enable_noop":
+		return parseBool(&state.noopEnabled)
+	case "set_noop_interval":
+		interval, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || interval == 0 {
+			return false
+		}
+		state.noopInterval = time.Duration(interval) * time.Second
+	case "connection_buffer_size":
+		size, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return false
+		}
+		state.bufferSize = uint32(size)
+	case "enable_expiry_opcode":
+		return parseBool(&state.expiryOpcode)
+	case "enable_stream_id":
+		return parseBool(&state.streamIDsEnabled)
+	case "supports_cursor_dropping", "supports_cursor_dropping_vulcan":
+		// We never drop cursors, so whether the consumer could cope with it
+		// makes no difference.
+		var cursorDropping bool
+		return parseBool(&cursorDropping)
+	case "enable_sync_writes":
+		return parseBool(&state.syncWritesEnabled)
+	case "send_stream_end_on_client_close_stream":
+		return parseBool(&state.streamEndOnClose)
+	case "enable_out_of_order_snapshots":
+		return parseBool(&state.oosoSnapshots)
+	case "include_deleted_user_xattrs":
+		return parseBool(&state.deletedUserXattrs)
+	case "v7_dcp_status_codes":
+		return parseBool(&state.v7StatusCodesEnabled)
+	case "consumer_name":
+		state.consumerName = value
+	case "set_priority":
+		switch value {
+		case "low", "medium", "high":
+			state.priority = value
+		default:
+			return false
+		}
+	case "force_value_compression", "supports_hifi_MFU":
+		// We accept these, but they do not change our behaviour.
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (x *kvImplDcp) handleControlRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	state := getDcpConnState(source)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if !state.isOpen {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	if !x.applyControl(state, string(pak.Key), string(pak.Value)) {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	if state.noopEnabled && !state.noopsRunning {
+		state.noopsRunning = true
+		go x.runNoops(source, state)
+	}
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+// runNoops sends a DCP_NOOP to the consumer once every noop interval, until
+// the consumer disables noops or the connection closes.
+func (x *kvImplDcp) runNoops(source mock.KvClient, state *dcpConnState) {
+	for {
+		state.lock.Lock()
+		interval := state.noopInterval
+		state.lock.Unlock()
+		if interval == 0 {
+			interval = dcpDefaultNoopInterval
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-source.CloseNotify():
+			return
+		}
+
+		state.lock.Lock()
+		if !state.noopEnabled {
+			state.noopsRunning = false
+			state.lock.Unlock()
+			return
+		}
+		state.noopOpaque++
+		opaque := state.noopOpaque
+		state.lock.Unlock()
+
+		err := source.WritePacket(&memd.Packet{
+			Magic:   memd.CmdMagicReq,
+			Command: memd.CmdDcpNoop,
+			Opaque:  opaque,
+		})
+		if err != nil {
+			log.Printf("failed to write dcp noop: %s", err)
+			return
+		}
+	}
+}
+
+func (x *kvImplDcp) handleSeqnoAcknowledgedRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	state := getDcpConnState(source)
+	state.lock.Lock()
+	isOpen := state.isOpen
+	state.lock.Unlock()
+
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !isOpen || len(pak.Extras) != 8 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+	if int(pak.Vbucket) >= len(vbOwnership) || vbOwnership[pak.Vbucket] != 0 {
+		x.writeStatusReply(source, pak, memd.StatusNotMyVBucket, start)
+		return
+	}
+
+	state.lock.Lock()
+	_, status := x.findStream(state, pak)
+	state.lock.Unlock()
+	if status != memd.StatusSuccess {
+		x.writeStatusReply(source, pak, status, start)
+		return
+	}
+
+	seqNo := binary.BigEndian.Uint64(pak.Extras[0:])
+	err := selectedBucket.Store().GetVbucket(uint(pak.Vbucket)).AcknowledgeSeqNo(seqNo)
+	if err != nil {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	// Successful acknowledgements are not replied to, matching the server.
+}
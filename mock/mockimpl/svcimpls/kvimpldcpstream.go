@@ -0,0 +1,906 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// These are the snapshot types and marker versions used by snapshot markers.
+const (
+	dcpSnapshotTypeMemory = uint32(0x01)
+	dcpSnapshotTypeDisk   = uint32(0x02)
+
+	dcpSnapshotMarkerVersion2 = byte(0x00)
+)
+
+// dcpStreamKey uniquely identifies a stream on a single DCP connection.
+type dcpStreamKey struct {
+	vbID     uint16
+	streamID uint16
+}
+
+// dcpStream represents a single open DCP stream.
+type dcpStream struct {
+	key         dcpStreamKey
+	connName    string
+	hasStreamID bool
+	opaque      uint32
+	flags       uint32
+	startSeqNo  uint64
+	endSeqNo    uint64
+
+	// collections is the list of collections which this stream includes, or
+	// nil if the stream is not filtered.
+	collections map[uint32]bool
+
+	// These track the progress of the stream, and are protected by the lock
+	// of the connection state which owns the stream.
+	sentSeqNo      uint64
+	snapStartSeqNo uint64
+	snapEndSeqNo   uint64
+
+	registry *mock.DcpStreamRegistry
+	closeCh  chan struct{}
+}
+
+type dcpStreamFilter struct {
+	collections map[uint32]bool
+	streamID    uint16
+	hasStreamID bool
+
+	// manifestUID is the uid of the manifest which the filter was built
+	// from, or zero if the consumer did not specify one.
+	manifestUID uint64
+}
+
+func (x *kvImplDcp) parseStreamFilter(source mock.KvClient, bucket mock.Bucket, value []byte) (*dcpStreamFilter, error) {
+	filter := &dcpStreamFilter{}
+
+	if !source.HasFeature(memd.FeatureCollections) {
+		// Without collections, only the default collection is streamed.
+		if len(value) > 0 {
+			return nil, errors.New("cannot use a stream filter without collections")
+		}
+
+		filter.collections = map[uint32]bool{0: true}
+		return filter, nil
+	}
+
+	if len(value) == 0 {
+		return filter, nil
+	}
+
+	var filterJSON struct {
+		Collections []string `json:"collections"`
+		Scope       string   `json:"scope"`
+		StreamID    *uint16  `json:"sid"`
+		ManifestUID string   `json:"uid"`
+	}
+	if err := json.Unmarshal(value, &filterJSON); err != nil {
+		return nil, err
+	}
+
+	if filterJSON.ManifestUID != "" {
+		manifestUID, err := strconv.ParseUint(filterJSON.ManifestUID, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		filter.manifestUID = manifestUID
+	}
+
+	if filterJSON.StreamID != nil {
+		filter.streamID = *filterJSON.StreamID
+		filter.hasStreamID = true
+	}
+
+	if len(filterJSON.Collections) > 0 && filterJSON.Scope != "" {
+		return nil, errors.New("cannot filter by both scope and collections")
+	}
+
+	if len(filterJSON.Collections) > 0 {
+		filter.collections = make(map[uint32]bool)
+		for _, collIDStr := range filterJSON.Collections {
+			collID, err := strconv.ParseUint(collIDStr, 16, 32)
+			if err != nil {
+				return nil, err
+			}
+
+			filter.collections[uint32(collID)] = true
+		}
+	} else if filterJSON.Scope != "" {
+		scopeID, err := strconv.ParseUint(filterJSON.Scope, 16, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		_, scopes := bucket.CollectionManifest().GetManifest()
+		for _, scope := range scopes {
+			if scope.UID != uint32(scopeID) {
+				continue
+			}
+
+			filter.collections = make(map[uint32]bool)
+			for _, coll := range scope.Collections {
+				filter.collections[coll.UID] = true
+			}
+		}
+		if filter.collections == nil {
+			return nil, mock.ErrScopeNotFound
+		}
+	}
+
+	return filter, nil
+}
+
+// checkRollback decides whether a consumer needs to rollback before it can
+// stream from a particular point, and if so, what seqno it must rollback to.
+func (x *kvImplDcp) checkRollback(failoverLog []mockdb.VbRevData, vbUUID, startSeqNo, maxSeqNo uint64) (uint64, bool) {
+	for histIdx := len(failoverLog) - 1; histIdx >= 0; histIdx-- {
+		if failoverLog[histIdx].VbUUID != vbUUID {
+			continue
+		}
+
+		branchEndSeqNo := maxSeqNo
+		if histIdx+1 < len(failoverLog) {
+			branchEndSeqNo = failoverLog[histIdx+1].SeqNo
+		}
+
+		if startSeqNo > branchEndSeqNo {
+			return branchEndSeqNo, true
+		}
+		return 0, false
+	}
+
+	return 0, true
+}
+
+func (x *kvImplDcp) handleStreamRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	state := getDcpConnState(source)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	// The bucket which the connection was opened against may have since been
+	// deleted, in which case there is no longer a bucket selected.
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !state.isOpen || len(pak.Extras) != 48 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	// Consumer connections receive data rather than sending it, so they
+	// cannot open streams of their own.
+	if !state.isProducer() && !state.isNotifier() {
+		x.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+		return
+	}
+
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+	if int(pak.Vbucket) >= len(vbOwnership) || vbOwnership[pak.Vbucket] != 0 {
+		x.writeStatusReply(source, pak, memd.StatusNotMyVBucket, start)
+		return
+	}
+
+	flags := binary.BigEndian.Uint32(pak.Extras[0:])
+	startSeqNo := binary.BigEndian.Uint64(pak.Extras[8:])
+	endSeqNo := binary.BigEndian.Uint64(pak.Extras[16:])
+	vbUUID := binary.BigEndian.Uint64(pak.Extras[24:])
+
+	filter, err := x.parseStreamFilter(source, selectedBucket, pak.Value)
+	if err == mock.ErrScopeNotFound {
+		x.writeStatusReply(source, pak, memd.StatusScopeUnknown, start)
+		return
+	} else if err != nil {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	// A consumer which has seen a newer manifest than this node has adopted,
+	// such as from another node while manifest changes are staggered, must
+	// wait for this node to catch up.
+	nodeManifestUID, _ := selectedBucket.CollectionManifest().GetManifestAt(
+		selectedBucket.NodeManifestTime(source.Source().Node()))
+	if filter.manifestUID > nodeManifestUID {
+		x.writeStatusReply(source, pak, statusCollectionsManifestAhead, start)
+		return
+	}
+
+	if filter.hasStreamID != state.streamIDsEnabled {
+		x.writeStatusReply(source, pak, statusDcpStreamIDInvalid, start)
+		return
+	}
+
+	streamKey := dcpStreamKey{
+		vbID:     pak.Vbucket,
+		streamID: filter.streamID,
+	}
+	if _, ok := state.streams[streamKey]; ok {
+		x.writeStatusReply(source, pak, memd.StatusKeyExists, start)
+		return
+	}
+
+	vbucket := selectedBucket.Store().GetVbucket(uint(pak.Vbucket))
+	maxSeqNo := vbucket.MaxSeqNo()
+	if memd.DcpStreamAddFlag(flags)&memd.DcpStreamAddFlagLatest != 0 {
+		endSeqNo = maxSeqNo
+	}
+
+	if startSeqNo > endSeqNo {
+		x.writeStatusReply(source, pak, memd.StatusRangeError, start)
+		return
+	}
+
+	failoverLog := vbucket.FailoverLog()
+	if startSeqNo > 0 {
+		rollbackSeqNo, needsRollback := x.checkRollback(failoverLog, vbUUID, startSeqNo, maxSeqNo)
+
+		ignorePurged := memd.DcpStreamAddFlag(flags)&dcpStreamAddFlagIgnorePurgedTombstones != 0
+		if startSeqNo < vbucket.PurgeSeqNo() && !ignorePurged {
+			// The consumer may have missed deletions which have since been
+			// purged, so it must start again from the beginning.  Consumers
+			// which do not care about those deletions can instead stream on
+			// from where they are, and the purged tombstones are not sent.
+			rollbackSeqNo, needsRollback = 0, true
+		}
+
+		if needsRollback {
+			rollbackBuf := make([]byte, 8)
+			binary.BigEndian.PutUint64(rollbackBuf, rollbackSeqNo)
+
+			writePacketToSource(source, &memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+				Status:  memd.StatusRollback,
+				Value:   rollbackBuf,
+			}, start)
+			return
+		}
+	}
+
+	stream := &dcpStream{
+		key:         streamKey,
+		connName:    state.name,
+		hasStreamID: filter.hasStreamID,
+		opaque:      pak.Opaque,
+		flags:       flags,
+		startSeqNo:  startSeqNo,
+		endSeqNo:    endSeqNo,
+		collections: filter.collections,
+		registry:    selectedBucket.DcpStreams(),
+		closeCh:     make(chan struct{}),
+	}
+	if state.streams == nil {
+		state.streams = make(map[dcpStreamKey]*dcpStream)
+	}
+	state.streams[streamKey] = stream
+
+	stream.registry.Add(stream, func() mock.DcpStreamState {
+		state.lock.Lock()
+		defer state.lock.Unlock()
+
+		return mock.DcpStreamState{
+			Client:         source,
+			ConnectionName: state.name,
+			VbID:           stream.key.vbID,
+			StreamID:       stream.key.streamID,
+			StartSeqNo:     stream.startSeqNo,
+			EndSeqNo:       stream.endSeqNo,
+			SentSeqNo:      stream.sentSeqNo,
+			SnapStartSeqNo: stream.snapStartSeqNo,
+			SnapEndSeqNo:   stream.snapEndSeqNo,
+			SentBytes:      atomic.LoadUint64(&state.sentBytes),
+			AckedBytes:     atomic.LoadUint64(&state.ackedBytes),
+		}
+	})
+
+	// The failover log is returned with the most recent entry first.
+	failoverBuf := make([]byte, 0, len(failoverLog)*16)
+	for histIdx := len(failoverLog) - 1; histIdx >= 0; histIdx-- {
+		entryBuf := make([]byte, 16)
+		binary.BigEndian.PutUint64(entryBuf[0:], failoverLog[histIdx].VbUUID)
+		binary.BigEndian.PutUint64(entryBuf[8:], failoverLog[histIdx].SeqNo)
+		failoverBuf = append(failoverBuf, entryBuf...)
+	}
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  memd.StatusSuccess,
+		Value:   failoverBuf,
+	}, start)
+
+	go x.runStream(source, vbucket, state, stream)
+}
+
+// findStream finds the stream which a per-stream command refers to.  Commands
+// must carry a stream id exactly when stream ids are enabled on the connection.
+// NOTE: This must be called with the lock of the connection state held.
+func (x *kvImplDcp) findStream(state *dcpConnState, pak *memd.Packet) (*dcpStream, memd.StatusCode) {
+	if (pak.StreamIDFrame != nil) != state.streamIDsEnabled {
+		return nil, statusDcpStreamIDInvalid
+	}
+
+	streamKey := dcpStreamKey{
+		vbID: pak.Vbucket,
+	}
+	if pak.StreamIDFrame != nil {
+		streamKey.streamID = pak.StreamIDFrame.StreamID
+	}
+
+	stream, ok := state.streams[streamKey]
+	if !ok {
+		return nil, statusDcpStreamNotFound
+	}
+
+	return stream, memd.StatusSuccess
+}
+
+func (x *kvImplDcp) handleCloseStreamRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	state := getDcpConnState(source)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if !state.isOpen {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	stream, status := x.findStream(state, pak)
+	if status != memd.StatusSuccess {
+		x.writeStatusReply(source, pak, status, start)
+		return
+	}
+
+	// The stream is ended by the goroutine which runs it, once it reaches the
+	// end of the snapshot which it is sending, so that the consumer is never
+	// sent anything for the stream after its stream end.
+	delete(state.streams, stream.key)
+	stream.registry.Remove(stream)
+	close(stream.closeCh)
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+func (x *kvImplDcp) handleBufferAckRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	// We do not implement flow control, but we do track how many bytes have
+	// been acknowledged.  Buffer acknowledgements are never replied to.
+	if len(pak.Extras) != 4 {
+		return
+	}
+
+	state := getDcpConnState(source)
+	atomic.AddUint64(&state.ackedBytes, uint64(binary.BigEndian.Uint32(pak.Extras[0:])))
+}
+
+func (x *kvImplDcp) writeStreamPacket(source mock.KvClient, state *dcpConnState, stream *dcpStream, pak *memd.Packet) error {
+	pak.Magic = memd.CmdMagicReq
+	pak.Opaque = stream.opaque
+	pak.Vbucket = stream.key.vbID
+	if stream.hasStreamID {
+		pak.StreamIDFrame = &memd.StreamIDFrame{
+			StreamID: stream.key.streamID,
+		}
+	}
+
+	err := source.WritePacket(pak)
+	if err != nil {
+		return err
+	}
+
+	// This is the size of the packet on the wire, less any framing extras.
+	pakLen := 24 + len(pak.Extras) + len(pak.Key) + len(pak.Value)
+	atomic.AddUint64(&state.sentBytes, uint64(pakLen))
+
+	stream.registry.Record(x.decodeStreamPacket(stream, pak))
+
+	return nil
+}
+
+// decodeStreamPacket describes a packet sent on a stream for recorders.
+func (x *kvImplDcp) decodeStreamPacket(stream *dcpStream, pak *memd.Packet) mock.DcpMessage {
+	msg := mock.DcpMessage{
+		ConnectionName: stream.connName,
+		VbID:           stream.key.vbID,
+		StreamID:       stream.key.streamID,
+		CollectionID:   pak.CollectionID,
+		Key:            pak.Key,
+	}
+
+	switch pak.Command {
+	case memd.CmdDcpSnapshotMarker:
+		msg.Type = mock.DcpMessageSnapshotMarker
+		markerBuf := pak.Extras
+		if len(pak.Extras) == 1 {
+			// v2.0 markers carry the marker in the value instead.
+			markerBuf = pak.Value
+		}
+		msg.SnapStartSeqNo = binary.BigEndian.Uint64(markerBuf[0:])
+		msg.SnapEndSeqNo = binary.BigEndian.Uint64(markerBuf[8:])
+		msg.SnapshotType = binary.BigEndian.Uint32(markerBuf[16:])
+	case memd.CmdDcpMutation:
+		msg.Type = mock.DcpMessageMutation
+		msg.SeqNo = binary.BigEndian.Uint64(pak.Extras[0:])
+	case memd.CmdDcpDeletion:
+		msg.Type = mock.DcpMessageDeletion
+		msg.SeqNo = binary.BigEndian.Uint64(pak.Extras[0:])
+	case memd.CmdDcpExpiration:
+		msg.Type = mock.DcpMessageExpiration
+		msg.SeqNo = binary.BigEndian.Uint64(pak.Extras[0:])
+	case memd.CmdDcpEvent:
+		msg.Type = mock.DcpMessageSystemEvent
+		msg.SeqNo = binary.BigEndian.Uint64(pak.Extras[0:])
+		msg.EventID = binary.BigEndian.Uint32(pak.Extras[8:])
+		if len(pak.Value) >= 16 {
+			// Collection events carry the id of their collection in the value.
+			msg.CollectionID = binary.BigEndian.Uint32(pak.Value[12:])
+		}
+	case memd.CmdDcpStreamEnd:
+		msg.Type = mock.DcpMessageStreamEnd
+		msg.StreamEndStatus = binary.BigEndian.Uint32(pak.Extras[0:])
+	}
+
+	return msg
+}
+
+func (x *kvImplDcp) writeStreamEnd(source mock.KvClient, state *dcpConnState, stream *dcpStream, status memd.StreamEndStatus) {
+	extrasBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(extrasBuf[0:], uint32(status))
+
+	err := x.writeStreamPacket(source, state, stream, &memd.Packet{
+		Command: memd.CmdDcpStreamEnd,
+		Extras:  extrasBuf,
+	})
+	if err != nil {
+		log.Printf("failed to write dcp stream end: %s", err)
+	}
+}
+
+// removeStream removes a stream from the connection state if it is still
+// present, returning whether it was.
+func (x *kvImplDcp) removeStream(state *dcpConnState, stream *dcpStream) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.streams[stream.key] != stream {
+		return false
+	}
+
+	delete(state.streams, stream.key)
+	stream.registry.Remove(stream)
+	return true
+}
+
+// endStream removes a stream which has come to an end, and tells the consumer
+// why.  A stream which the consumer has closed in the meantime is instead
+// only ended if the consumer asked to be sent a stream end on close.
+func (x *kvImplDcp) endStream(source mock.KvClient, state *dcpConnState, stream *dcpStream, status memd.StreamEndStatus) {
+	if x.removeStream(state, stream) {
+		x.writeStreamEnd(source, state, stream, status)
+		return
+	}
+
+	x.endClosedStream(source, state, stream)
+}
+
+// endClosedStream sends the stream end for a stream which the consumer has
+// closed, if the consumer asked to be sent one.
+func (x *kvImplDcp) endClosedStream(source mock.KvClient, state *dcpConnState, stream *dcpStream) {
+	state.lock.Lock()
+	streamEndOnClose := state.streamEndOnClose
+	state.lock.Unlock()
+
+	if streamEndOnClose {
+		x.writeStreamEnd(source, state, stream, memd.StreamEndClosed)
+	}
+}
+
+// encodeXattrs encodes a set of xattrs into the blob which prefixes the value
+// of a document whose datatype includes xattrs.
+func (x *kvImplDcp) encodeXattrs(xattrs map[string][]byte) []byte {
+	keys := make([]string, 0, len(xattrs))
+	for key := range xattrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	blob := make([]byte, 4)
+	for _, key := range keys {
+		pairLen := len(key) + 1 + len(xattrs[key]) + 1
+		pairBuf := make([]byte, 4, 4+pairLen)
+		binary.BigEndian.PutUint32(pairBuf[0:], uint32(pairLen))
+		pairBuf = append(pairBuf, key...)
+		pairBuf = append(pairBuf, 0)
+		pairBuf = append(pairBuf, xattrs[key]...)
+		pairBuf = append(pairBuf, 0)
+		blob = append(blob, pairBuf...)
+	}
+	binary.BigEndian.PutUint32(blob[0:], uint32(len(blob)-4))
+
+	return blob
+}
+
+// systemXattrs returns only the system xattrs, i.e. those which start with an _.
+func systemXattrs(xattrs map[string][]byte) map[string][]byte {
+	sysXattrs := make(map[string][]byte)
+	for key, value := range xattrs {
+		if strings.HasPrefix(key, "_") {
+			sysXattrs[key] = value
+		}
+	}
+	return sysXattrs
+}
+
+// encodeDocValue builds the value and datatype which a document is sent with,
+// according to whether the connection includes xattrs and document bodies.
+// Tombstones only carry their user xattrs when the connection asked for them.
+func (x *kvImplDcp) encodeDocValue(doc *mockdb.Document, includeXattrs, includeDeletedUserXattrs, noValue bool) ([]byte, uint8) {
+	var value []byte
+	datatype := doc.Datatype
+	if noValue {
+		datatype &^= uint8(memd.DatatypeFlagJSON)
+	} else {
+		value = doc.Value
+	}
+
+	xattrs := doc.Xattrs
+	if doc.IsDeleted && !includeDeletedUserXattrs {
+		xattrs = systemXattrs(xattrs)
+	}
+
+	if includeXattrs && len(xattrs) > 0 {
+		value = append(x.encodeXattrs(xattrs), value...)
+		datatype |= uint8(memd.DatatypeFlagXattrs)
+	}
+
+	return value, datatype
+}
+
+// sendSnapshot sends all the mutations which occurred after lastSeqNo, up to
+// and including endSeqNo, as a single snapshot.
+func (x *kvImplDcp) sendSnapshot(source mock.KvClient, vbucket *mockdb.Vbucket, state *dcpConnState, stream *dcpStream,
+	lastSeqNo, endSeqNo uint64, snapshotType uint32) error {
+	docs, _, err := vbucket.GetAllWithin(0, lastSeqNo, endSeqNo)
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent revision of each document within the snapshot
+	// is sent, the same way the server deduplicates its checkpoints.
+	type docKey struct {
+		collectionID uint
+		key          string
+	}
+	latestDocs := make(map[docKey]*mockdb.Document)
+	for _, doc := range docs {
+		latestDocs[docKey{doc.CollectionID, string(doc.Key)}] = doc
+	}
+
+	var snapDocs []*mockdb.Document
+	for _, doc := range docs {
+		if latestDocs[docKey{doc.CollectionID, string(doc.Key)}] != doc {
+			continue
+		}
+		if stream.collections != nil && !stream.collections[uint32(doc.CollectionID)] {
+			continue
+		}
+
+		snapDocs = append(snapDocs, doc)
+	}
+
+	// System events are never deduplicated, but are filtered the same way.
+	var snapEvents []*mockdb.SystemEvent
+	for _, event := range vbucket.GetSystemEventsWithin(lastSeqNo, endSeqNo) {
+		if stream.collections != nil && !stream.collections[event.CollectionID] {
+			continue
+		}
+
+		snapEvents = append(snapEvents, event)
+	}
+
+	if len(snapDocs) == 0 && len(snapEvents) == 0 {
+		return nil
+	}
+
+	state.lock.Lock()
+	syncWritesEnabled := state.syncWritesEnabled
+	includeDeleteTimes := memd.DcpOpenFlag(state.flags)&memd.DcpOpenFlagIncludeDeleteTimes != 0
+	includeXattrs := memd.DcpOpenFlag(state.flags)&memd.DcpOpenFlagIncludeXattrs != 0
+	noValue := memd.DcpOpenFlag(state.flags)&memd.DcpOpenFlagNoValue != 0
+	includeDeletedUserXattrs := state.deletedUserXattrs ||
+		memd.DcpOpenFlag(state.flags)&dcpOpenFlagIncludeDeletedUserXattrs != 0
+	expiryOpcode := state.expiryOpcode
+	state.lock.Unlock()
+
+	now := source.Source().Node().Cluster().Chrono().Now()
+
+	var markerPak *memd.Packet
+	if !syncWritesEnabled {
+		markerBuf := make([]byte, 20)
+		binary.BigEndian.PutUint64(markerBuf[0:], lastSeqNo+1)
+		binary.BigEndian.PutUint64(markerBuf[8:], endSeqNo)
+		binary.BigEndian.PutUint32(markerBuf[16:], snapshotType)
+
+		markerPak = &memd.Packet{
+			Command: memd.CmdDcpSnapshotMarker,
+			Extras:  markerBuf,
+		}
+	} else {
+		// Consumers which support sync writes receive v2.0 markers, which carry
+		// the max visible seqno and the high completed seqno in the value.  We
+		// commit durable writes immediately, so there are never any prepares
+		// which need to be hidden and every write is visible and completed.
+		markerBuf := make([]byte, 36)
+		binary.BigEndian.PutUint64(markerBuf[0:], lastSeqNo+1)
+		binary.BigEndian.PutUint64(markerBuf[8:], endSeqNo)
+		binary.BigEndian.PutUint32(markerBuf[16:], snapshotType)
+		binary.BigEndian.PutUint64(markerBuf[20:], endSeqNo)
+		if snapshotType&dcpSnapshotTypeDisk != 0 {
+			// The high completed seqno is only meaningful for disk snapshots.
+			binary.BigEndian.PutUint64(markerBuf[28:], endSeqNo)
+		}
+
+		markerPak = &memd.Packet{
+			Command: memd.CmdDcpSnapshotMarker,
+			Extras:  []byte{dcpSnapshotMarkerVersion2},
+			Value:   markerBuf,
+		}
+	}
+
+	err = x.writeStreamPacket(source, state, stream, markerPak)
+	if err != nil {
+		return err
+	}
+
+	state.lock.Lock()
+	stream.snapStartSeqNo = lastSeqNo + 1
+	stream.snapEndSeqNo = endSeqNo
+	state.lock.Unlock()
+
+	for _, doc := range snapDocs {
+		for len(snapEvents) > 0 && snapEvents[0].SeqNo < doc.SeqNo {
+			err = x.writeSystemEvent(source, state, stream, snapEvents[0])
+			if err != nil {
+				return err
+			}
+			snapEvents = snapEvents[1:]
+		}
+
+		// The same way reads do, we treat a document which has expired as
+		// though it had been deleted, dropping its body.
+		isExpired := !doc.IsDeleted && !doc.Expiry.IsZero() && !now.Before(doc.Expiry)
+		if isExpired {
+			expiredDoc := *doc
+			expiredDoc.IsDeleted = true
+			expiredDoc.Value = nil
+			expiredDoc.Datatype &^= uint8(memd.DatatypeFlagJSON)
+			expiredDoc.ModifiedTime = doc.Expiry
+			doc = &expiredDoc
+		}
+
+		value, datatype := x.encodeDocValue(doc, includeXattrs, includeDeletedUserXattrs, noValue)
+
+		if isExpired && expiryOpcode {
+			// Expirations always carry the time that the document expired.
+			extrasBuf := make([]byte, 20)
+			binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+			binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+			binary.BigEndian.PutUint32(extrasBuf[16:], uint32(doc.Expiry.Unix()))
+
+			err = x.writeStreamPacket(source, state, stream, &memd.Packet{
+				Command:      memd.CmdDcpExpiration,
+				Datatype:     datatype,
+				Cas:          doc.Cas,
+				CollectionID: uint32(doc.CollectionID),
+				Key:          doc.Key,
+				Value:        value,
+				Extras:       extrasBuf,
+			})
+		} else if doc.IsDeleted {
+			var extrasBuf []byte
+			if includeDeleteTimes {
+				// The tombstone is the revision which deleted the document, so
+				// its modification time is the time of the deletion.
+				extrasBuf = make([]byte, 21)
+				binary.BigEndian.PutUint32(extrasBuf[16:], uint32(doc.ModifiedTime.Unix()))
+			} else {
+				extrasBuf = make([]byte, 18)
+			}
+			binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+			binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+
+			err = x.writeStreamPacket(source, state, stream, &memd.Packet{
+				Command:      memd.CmdDcpDeletion,
+				Datatype:     datatype,
+				Cas:          doc.Cas,
+				CollectionID: uint32(doc.CollectionID),
+				Key:          doc.Key,
+				Value:        value,
+				Extras:       extrasBuf,
+			})
+		} else {
+			var expiry uint32
+			if !doc.Expiry.IsZero() {
+				expiry = uint32(doc.Expiry.Unix())
+			}
+
+			extrasBuf := make([]byte, 31)
+			binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+			binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+			binary.BigEndian.PutUint32(extrasBuf[16:], doc.Flags)
+			binary.BigEndian.PutUint32(extrasBuf[20:], expiry)
+
+			err = x.writeStreamPacket(source, state, stream, &memd.Packet{
+				Command:      memd.CmdDcpMutation,
+				Datatype:     datatype,
+				Cas:          doc.Cas,
+				CollectionID: uint32(doc.CollectionID),
+				Key:          doc.Key,
+				Value:        value,
+				Extras:       extrasBuf,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		state.lock.Lock()
+		stream.sentSeqNo = doc.SeqNo
+		state.lock.Unlock()
+	}
+
+	for _, event := range snapEvents {
+		err = x.writeSystemEvent(source, state, stream, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSystemEvent sends a system event on a stream.
+func (x *kvImplDcp) writeSystemEvent(source mock.KvClient, state *dcpConnState, stream *dcpStream, event *mockdb.SystemEvent) error {
+	var key, value []byte
+	var eventID memd.StreamEventCode
+	var version byte
+	switch event.Type {
+	case mockdb.SystemEventCollectionCreate:
+		eventID = memd.StreamEventCollectionCreate
+		key = []byte(event.Name)
+		if event.MaxTTL == 0 {
+			value = make([]byte, 16)
+		} else {
+			// Collections with a max TTL are described by the second version
+			// of the event, which carries the TTL after the ids.
+			version = 1
+			value = make([]byte, 20)
+			binary.BigEndian.PutUint32(value[16:], event.MaxTTL)
+		}
+	case mockdb.SystemEventCollectionDrop:
+		eventID = memd.StreamEventCollectionDelete
+		value = make([]byte, 16)
+	default:
+		return errors.New("unknown system event type")
+	}
+	binary.BigEndian.PutUint64(value[0:], event.ManifestUID)
+	binary.BigEndian.PutUint32(value[8:], event.ScopeID)
+	binary.BigEndian.PutUint32(value[12:], event.CollectionID)
+
+	extrasBuf := make([]byte, 13)
+	binary.BigEndian.PutUint64(extrasBuf[0:], event.SeqNo)
+	binary.BigEndian.PutUint32(extrasBuf[8:], uint32(eventID))
+	extrasBuf[12] = version
+
+	err := x.writeStreamPacket(source, state, stream, &memd.Packet{
+		Command: memd.CmdDcpEvent,
+		Key:     key,
+		Value:   value,
+		Extras:  extrasBuf,
+	})
+	if err != nil {
+		return err
+	}
+
+	state.lock.Lock()
+	stream.sentSeqNo = event.SeqNo
+	state.lock.Unlock()
+
+	return nil
+}
+
+// runStream sends the contents of a vbucket to a consumer, first as a disk
+// snapshot of everything already stored, and then as in-memory snapshots of
+// mutations as they occur, until the end seqno of the stream is reached.
+func (x *kvImplDcp) runStream(source mock.KvClient, vbucket *mockdb.Vbucket, state *dcpConnState, stream *dcpStream) {
+	lastSeqNo := stream.startSeqNo
+	snapshotType := dcpSnapshotTypeDisk
+
+	state.lock.Lock()
+	isNotifier := state.isNotifier()
+	state.lock.Unlock()
+
+	ignorePurged := memd.DcpStreamAddFlag(stream.flags)&dcpStreamAddFlagIgnorePurgedTombstones != 0
+
+	for {
+		// A stream which the consumer has closed ends between snapshots.
+		select {
+		case <-stream.closeCh:
+			x.endClosedStream(source, state, stream)
+			return
+		default:
+		}
+
+		mutationCh := vbucket.MutationNotify()
+
+		maxSeqNo := vbucket.MaxSeqNo()
+		if maxSeqNo < lastSeqNo {
+			// The vbucket has gone backwards underneath us (flushed or rolled back).
+			x.endStream(source, state, stream, memd.StreamEndStateChanged)
+			return
+		}
+
+		if lastSeqNo > 0 && lastSeqNo < vbucket.PurgeSeqNo() && !ignorePurged {
+			// A forced purge has dropped deletions which the consumer has not
+			// yet been sent, so it must rollback before it can continue.  A
+			// consumer starting from nothing cannot have missed anything.
+			x.endStream(source, state, stream, dcpStreamEndRollback)
+			return
+		}
+
+		if isNotifier && maxSeqNo > lastSeqNo {
+			// Notifier streams carry no data, they simply end as soon as the
+			// vbucket has moved on from where the consumer started.
+			x.endStream(source, state, stream, memd.StreamEndOK)
+			return
+		}
+
+		snapEndSeqNo := maxSeqNo
+		if snapEndSeqNo > stream.endSeqNo {
+			snapEndSeqNo = stream.endSeqNo
+		}
+
+		if snapEndSeqNo > lastSeqNo {
+			err := x.sendSnapshot(source, vbucket, state, stream, lastSeqNo, snapEndSeqNo, snapshotType)
+			if err != nil {
+				log.Printf("failed to write dcp snapshot: %s", err)
+				x.removeStream(state, stream)
+				return
+			}
+
+			lastSeqNo = snapEndSeqNo
+			snapshotType = dcpSnapshotTypeMemory
+		}
+
+		if lastSeqNo >= stream.endSeqNo {
+			x.endStream(source, state, stream, memd.StreamEndOK)
+			return
+		}
+
+		select {
+		case <-mutationCh:
+		case <-stream.closeCh:
+			x.endClosedStream(source, state, stream)
+			return
+		case <-source.CloseNotify():
+			x.removeStream(state, stream)
+			return
+		}
+	}
+}
@@ -14,7 +14,7 @@ func (x *kvImplErrMap) Register(h *hookHelper) {
 }
 
 func (x *kvImplErrMap) handleErrorMapReq(source mock.KvClient, pak *memd.Packet, start time.Time) {
-	errMap := source.Source().Node().ErrorMap()
+	errMap := genErrorMap(source)
 
 	b, err := errMap.Marshal()
 	if err != nil {
@@ -0,0 +1,78 @@
+package svcimpls
+
+import (
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// This status is not yet exposed by memd.
+const (
+	statusUnknownFrameInfo = memd.StatusCode(0x80)
+)
+
+// Request frames with a lower id than this (barrier, durability, stream id and
+// open tracing) are decoded by memd itself.  Every one of them predates the
+// oldest version of the server which we emulate.
+const frameReqFirstUndecoded = 4
+
+// kvImplFrames validates the frame extras which are sent with requests before
+// any of the command handlers see them.
+type kvImplFrames struct {
+}
+
+func (x *kvImplFrames) Register(h *hookHelper) {
+	h.KvInHooks.Add(x.handleRequest)
+}
+
+func (x *kvImplFrames) handleRequest(source mock.KvClient, pak *memd.Packet, start time.Time, next func()) {
+	if pak.Magic != memd.CmdMagicReq {
+		next()
+		return
+	}
+
+	if status := x.checkFrames(source, pak); status != memd.StatusSuccess {
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  status,
+		}, start)
+		return
+	}
+
+	next()
+}
+
+// checkFrames returns the status which a request must be failed with because
+// of its frame extras, or success if they are all understood.
+func (x *kvImplFrames) checkFrames(source mock.KvClient, pak *memd.Packet) memd.StatusCode {
+	for _, frame := range pak.UnsupportedFrames {
+		// memd leaves frames which it knows but could not decode, such as
+		// those with the wrong length, alongside the ones it does not know.
+		if uint8(frame.Type) < frameReqFirstUndecoded {
+			return memd.StatusInvalidArgs
+		}
+	}
+
+	// None of the newer frames, such as impersonation or preserving the
+	// expiry, are emulated, so they are rejected the same way a server which
+	// predates them would.
+	if len(pak.UnsupportedFrames) > 0 {
+		return statusUnknownFrameInfo
+	}
+
+	// Durability requirements are only understood on connections which have
+	// negotiated synchronous replication.
+	if pak.DurabilityLevelFrame != nil && !source.HasFeature(memd.FeatureSyncReplication) {
+		return statusUnknownFrameInfo
+	}
+
+	// Only mutations can be made durable.
+	if pak.DurabilityLevelFrame != nil && !isSyncWriteCommand(pak.Command) {
+		return memd.StatusInvalidArgs
+	}
+
+	return memd.StatusSuccess
+}
@@ -8,6 +8,106 @@ import (
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// These features are not yet exposed by memd.
+const (
+	featurePiTR                         = memd.HelloFeature(0x16)
+	featureNonBlockingThrottlingMode    = memd.HelloFeature(0x1b)
+	featureDedupeNotMyVbucketClustermap = memd.HelloFeature(0x1e)
+)
+
+// helloFeaturesByVersion lists the HELLO features which were added in each
+// version of the server.  A cluster supports the features of its own version
+// and of every version before it.  Features which we do not emulate, such as
+// cluster map notifications and open tracing, are never negotiated, and nor
+// are features which were only developer previews in a version.
+var helloFeaturesByVersion = []struct {
+	version  mock.ClusterVersion
+	features []memd.HelloFeature
+}{
+	{mock.ClusterVersion50, []memd.HelloFeature{
+		memd.FeatureDatatype,
+		memd.FeatureTCPNoDelay,
+		memd.FeatureSeqNo,
+		memd.FeatureTCPDelay,
+		memd.FeatureXattr,
+		memd.FeatureXerror,
+		memd.FeatureSelectBucket,
+	}},
+	{mock.ClusterVersion65, []memd.HelloFeature{
+		memd.FeatureSnappy,
+		memd.FeatureJSON,
+		memd.FeatureDuplex,
+		memd.FeatureUnorderedExec,
+		memd.FeatureDurations,
+		memd.FeatureAltRequests,
+		memd.FeatureSyncReplication,
+	}},
+	{mock.ClusterVersion66, []memd.HelloFeature{
+		memd.FeatureCreateAsDeleted,
+	}},
+	{mock.ClusterVersion70, []memd.HelloFeature{
+		// Collections were only a developer preview before 7.0.
+		memd.FeatureCollections,
+		featurePiTR,
+	}},
+	{mock.ClusterVersion72, []memd.HelloFeature{
+		featureNonBlockingThrottlingMode,
+	}},
+	{mock.ClusterVersion76, []memd.HelloFeature{
+		featureDedupeNotMyVbucketClustermap,
+	}},
+}
+
+// helloBucketCapabilities maps the HELLO features which depend on the bucket
+// to the bucket capability which they require.  Connections which select a
+// bucket before sending HELLO are not offered features which it lacks.
+var helloBucketCapabilities = map[memd.HelloFeature]string{
+	memd.FeatureXattr:           "xattr",
+	memd.FeatureSyncReplication: "durableWrite",
+	memd.FeatureCollections:     "collections",
+}
+
+// enterpriseHelloFeatures lists the HELLO features which are only supported by
+// the enterprise edition of the server.
+var enterpriseHelloFeatures = []memd.HelloFeature{
+	memd.FeatureSyncReplication,
+}
+
+// HelloFeaturesForVersion returns the HELLO features which a cluster emulating
+// a specific version of the server supports.
+func HelloFeaturesForVersion(version mock.ClusterVersion) []memd.HelloFeature {
+	var features []memd.HelloFeature
+	for _, added := range helloFeaturesByVersion {
+		if version.AtLeast(added.version) {
+			features = append(features, added.features...)
+		}
+	}
+	return features
+}
+
+// HelloFeaturesForCluster returns the HELLO features which a cluster supports,
+// given both the version and the edition of the server which it emulates.
+func HelloFeaturesForCluster(c mock.Cluster) []memd.HelloFeature {
+	features := HelloFeaturesForVersion(c.Version())
+	if c.Edition() == mock.ClusterEditionEnterprise {
+		return features
+	}
+
+	filteredFeatures := make([]memd.HelloFeature, 0, len(features))
+	for _, feature := range features {
+		isEnterprise := false
+		for _, enterpriseFeature := range enterpriseHelloFeatures {
+			if feature == enterpriseFeature {
+				isEnterprise = true
+			}
+		}
+		if !isEnterprise {
+			filteredFeatures = append(filteredFeatures, feature)
+		}
+	}
+	return filteredFeatures
+}
+
 type kvImplHello struct {
 }
 
@@ -25,28 +125,24 @@ func (x *kvImplHello) handleHelloRequest(source mock.KvClient, pak *memd.Packet,
 		return false
 	}
 
-	// TODO(brett19): This list should actually be moderated by the server
-	// version/features configured at the top level for the cluster.
-	availableFeatures := []memd.HelloFeature{
-		memd.FeatureDatatype,
-		memd.FeatureTCPNoDelay,
-		memd.FeatureSeqNo,
-		memd.FeatureTCPDelay,
-		memd.FeatureXattr,
-		memd.FeatureXerror,
-		memd.FeatureSelectBucket,
-		memd.FeatureSnappy,
-		memd.FeatureJSON,
-		memd.FeatureDuplex,
-		//memd.FeatureClusterMapNotif,
-		memd.FeatureUnorderedExec,
-		memd.FeatureDurations,
-		memd.FeatureAltRequests,
-		memd.FeatureSyncReplication,
-		memd.FeatureCollections,
-		//memd.FeatureOpenTracing,
-		memd.FeatureCreateAsDeleted,
+	availableFeatures := HelloFeaturesForCluster(source.Source().Node().Cluster())
+
+	if bucket := source.SelectedBucket(); bucket != nil {
+		bucketCaps := make(map[string]bool)
+		for _, capability := range genBucketCapabilities(bucket) {
+			bucketCaps[capability] = true
+		}
+
+		filteredFeatures := make([]memd.HelloFeature, 0, len(availableFeatures))
+		for _, feature := range availableFeatures {
+			if capability, ok := helloBucketCapabilities[feature]; ok && !bucketCaps[capability] {
+				continue
+			}
+			filteredFeatures = append(filteredFeatures, feature)
+		}
+		availableFeatures = filteredFeatures
 	}
+
 	enabledFeatures := make([]memd.HelloFeature, 0)
 
 	numFeatures := len(pak.Value) / 2
@@ -0,0 +1,65 @@
+package svcimpls
+
+import (
+	"sync"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// nmvbConfigState tracks the last configuration which was embedded within a
+// NOT_MY_VBUCKET error sent to a single kv client.
+type nmvbConfigState struct {
+	lock       sync.Mutex
+	bucketName string
+	configRev  uint
+	hasSent    bool
+}
+
+func getNmvbConfigState(source mock.KvClient) *nmvbConfigState {
+	var state *nmvbConfigState
+	source.GetContext(&state)
+	return state
+}
+
+// attachNotMyVbucketConfig embeds the current configuration of the selected
+// bucket into a NOT_MY_VBUCKET error.  Clients which have negotiated config
+// deduplication, or every client if the cluster is configured to embed the
+// config once per revision, only receive each revision of the configuration
+// once, and any further errors for the same revision are sent without a body.
+func attachNotMyVbucketConfig(source mock.KvClient, pak *memd.Packet) {
+	if pak.Magic != memd.CmdMagicRes || pak.Status != memd.StatusNotMyVBucket {
+		return
+	}
+
+	tracker := source.Source().Node().Cluster().NotMyVbuckets()
+	if pak.Value != nil {
+		tracker.RecordNotMyVbucket(source.RemoteAddr().String(), true)
+		return
+	}
+
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil || selectedBucket.BucketType() == mock.BucketTypeMemcached {
+		tracker.RecordNotMyVbucket(source.RemoteAddr().String(), false)
+		return
+	}
+
+	configRev := selectedBucket.ConfigRev()
+
+	state := getNmvbConfigState(source)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	dedupe := source.HasFeature(featureDedupeNotMyVbucketClustermap) || tracker.ConfigOncePerRev()
+	if dedupe && state.hasSent && state.bucketName == selectedBucket.Name() && state.configRev >= configRev {
+		tracker.RecordNotMyVbucket(source.RemoteAddr().String(), false)
+		return
+	}
+
+	pak.Value = genTerseBucketConfig(selectedBucket, source.Source().Node(), genClientNetwork(source))
+	tracker.RecordNotMyVbucket(source.RemoteAddr().String(), true)
+
+	state.bucketName = selectedBucket.Name()
+	state.configRev = configRev
+	state.hasSent = true
+}
@@ -0,0 +1,143 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// These commands are not yet exposed by memd.
+const (
+	cmdFlush    = memd.CmdCode(0x08)
+	cmdSetParam = memd.CmdCode(0x82)
+)
+
+// These are the parameter types which can be passed to SET_PARAM.
+const (
+	engineParamTypeFlush       = 1
+	engineParamTypeReplication = 2
+	engineParamTypeCheckpoint  = 3
+	engineParamTypeDcp         = 4
+	engineParamTypeVbucket     = 5
+)
+
+// kvImplParams implements the engine parameter admin commands.  Parameters are
+// only tracked against the bucket, the few which affect our behaviour are then
+// read back where they are needed.
+type kvImplParams struct {
+}
+
+func (x *kvImplParams) Register(h *hookHelper) {
+	h.RegisterKvHandler(cmdSetParam, x.handleSetParamRequest)
+	h.RegisterKvHandler(cmdFlush, x.handleFlushRequest)
+}
+
+func (x *kvImplParams) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  status,
+	}, start)
+}
+
+// validateParam checks the value of the parameters whose values we understand,
+// any others are accepted as-is.
+func (x *kvImplParams) validateParam(name, value string) bool {
+	switch name {
+	case "flushall_enabled":
+		_, err := strconv.ParseBool(value)
+		return err == nil
+	case "max_size", "mem_low_wat", "mem_high_wat":
+		_, err := strconv.ParseUint(value, 10, 64)
+		return err == nil
+	}
+
+	return true
+}
+
+func (x *kvImplParams) handleSetParamRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return
+	}
+
+	if len(pak.Extras) != 4 || len(pak.Key) == 0 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	switch binary.BigEndian.Uint32(pak.Extras) {
+	case engineParamTypeFlush, engineParamTypeReplication, engineParamTypeCheckpoint,
+		engineParamTypeDcp, engineParamTypeVbucket:
+	default:
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	name := string(pak.Key)
+	value := string(pak.Value)
+	if !x.validateParam(name, value) {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	selectedBucket.SetEngineParam(name, value)
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+func (x *kvImplParams) handleFlushRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return
+	}
+
+	if !engineFlushEnabled(selectedBucket) {
+		x.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+		return
+	}
+
+	selectedBucket.Flush()
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+// engineFlushEnabled returns whether flush is enabled for a bucket, which can be
+// overridden using the flushall_enabled engine parameter.
+func engineFlushEnabled(bucket mock.Bucket) bool {
+	if value, ok := bucket.EngineParam("flushall_enabled"); ok {
+		enabled, _ := strconv.ParseBool(value)
+		return enabled
+	}
+
+	return bucket.FlushEnabled()
+}
+
+// engineMaxSize returns the memory quota of a bucket as set by the max_size
+// engine parameter, or 0 if no quota has been set.
+func engineMaxSize(bucket mock.Bucket) uint64 {
+	value, ok := bucket.EngineParam("max_size")
+	if !ok {
+		return 0
+	}
+
+	maxSize, _ := strconv.ParseUint(value, 10, 64)
+	return maxSize
+}
@@ -0,0 +1,257 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+	"github.com/google/uuid"
+)
+
+// These range scan commands and statuses are not yet exposed by memd.
+const (
+	cmdRangeScanCreate   = memd.CmdCode(0xda)
+	cmdRangeScanContinue = memd.CmdCode(0xdb)
+	cmdRangeScanCancel   = memd.CmdCode(0xdc)
+
+	statusRangeScanCancelled = memd.StatusCode(0xa5)
+	statusRangeScanMore      = memd.StatusCode(0xa6)
+	statusRangeScanComplete  = memd.StatusCode(0xa7)
+	statusVbUUIDNotEqual     = memd.StatusCode(0xa8)
+)
+
+// The extras of a RANGE_SCAN_CONTINUE response indicate which form the items
+// in its value are encoded in.
+const (
+	rangeScanItemsKeys      = 0
+	rangeScanItemsDocuments = 1
+)
+
+type rangeScanCreateJSON struct {
+	Collection string `json:"collection"`
+	KeyOnly    bool   `json:"key_only"`
+	Range      *struct {
+		Start     []byte `json:"start"`
+		End       []byte `json:"end"`
+		ExclStart []byte `json:"excl_start"`
+		ExclEnd   []byte `json:"excl_end"`
+	} `json:"range"`
+	Sampling             *json.RawMessage `json:"sampling"`
+	SnapshotRequirements *struct {
+		VbUUID string `json:"vb_uuid"`
+		SeqNo  uint64 `json:"seqno"`
+	} `json:"snapshot_requirements"`
+}
+
+func (x *kvImplCrud) handleRangeScanCreateRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		var createJSON rangeScanCreateJSON
+		if err := json.Unmarshal(pak.Value, &createJSON); err != nil {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		// Only range scans are emulated, random sampling scans are not.
+		if createJSON.Sampling != nil {
+			x.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+			return
+		}
+		if createJSON.Range == nil {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		var collectionID uint64
+		if createJSON.Collection != "" {
+			var err error
+			collectionID, err = strconv.ParseUint(createJSON.Collection, 16, 32)
+			if err != nil {
+				x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+				return
+			}
+		}
+
+		opts := kvproc.RangeScanOptions{
+			Vbucket:      uint(pak.Vbucket),
+			CollectionID: uint(collectionID),
+			StartKey:     createJSON.Range.Start,
+			EndKey:       createJSON.Range.End,
+		}
+		if createJSON.Range.ExclStart != nil {
+			opts.StartKey = createJSON.Range.ExclStart
+			opts.ExclusiveStart = true
+		}
+		if createJSON.Range.ExclEnd != nil {
+			opts.EndKey = createJSON.Range.ExclEnd
+			opts.ExclusiveEnd = true
+		}
+
+		// The seqno is never waited for, a scan of a vbucket which has not yet
+		// reached it fails immediately as though the wait had timed out.
+		if reqs := createJSON.SnapshotRequirements; reqs != nil {
+			vbUUID, err := strconv.ParseUint(reqs.VbUUID, 10, 64)
+			if err != nil {
+				x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+				return
+			}
+
+			opts.SnapshotRequirements = &kvproc.RangeScanSnapshotRequirements{
+				VbUUID: vbUUID,
+				SeqNo:  reqs.SeqNo,
+			}
+		}
+
+		resp, err := proc.RangeScan(opts)
+		if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		scan := &mock.RangeScan{
+			UUID:    uuid.New(),
+			VbID:    pak.Vbucket,
+			KeyOnly: createJSON.KeyOnly,
+		}
+		for _, item := range resp.Items {
+			var expiry uint32
+			if !item.ExpTime.IsZero() {
+				expiry = uint32(item.ExpTime.Unix())
+			}
+
+			scan.Items = append(scan.Items, mock.RangeScanItem{
+				Key:      item.Key,
+				Value:    item.Value,
+				Flags:    item.Flags,
+				Expiry:   expiry,
+				SeqNo:    item.SeqNo,
+				Cas:      item.Cas,
+				Datatype: item.Datatype,
+			})
+		}
+		source.SelectedBucket().RangeScans().Add(scan)
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusSuccess,
+			Value:   scan.UUID[:],
+		}, start)
+	}
+}
+
+// encodeRangeScanItem appends an item to the value of a RANGE_SCAN_CONTINUE
+// response.  Keys are always prefixed with their leb128 encoded length, and
+// documents are additionally preceded by their fixed size metadata and
+// followed by their length prefixed value.
+func encodeRangeScanItem(buf []byte, item mock.RangeScanItem, keyOnly bool) []byte {
+	if !keyOnly {
+		metaBuf := make([]byte, 25)
+		binary.BigEndian.PutUint32(metaBuf[0:], item.Flags)
+		binary.BigEndian.PutUint32(metaBuf[4:], item.Expiry)
+		binary.BigEndian.PutUint64(metaBuf[8:], item.SeqNo)
+		binary.BigEndian.PutUint64(metaBuf[16:], item.Cas)
+		metaBuf[24] = item.Datatype
+		buf = append(buf, metaBuf...)
+	}
+
+	buf = memd.AppendULEB128_32(buf, uint32(len(item.Key)))
+	buf = append(buf, item.Key...)
+
+	if !keyOnly {
+		buf = memd.AppendULEB128_32(buf, uint32(len(item.Value)))
+		buf = append(buf, item.Value...)
+	}
+
+	return buf
+}
+
+func (x *kvImplCrud) handleRangeScanContinueRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		// The extras contain the scan uuid, followed by the item, time and byte
+		// limits of this continue.  The time limit is not emulated.
+		if len(pak.Extras) != 28 {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		var scanUUID [16]byte
+		copy(scanUUID[:], pak.Extras[0:])
+		itemLimit := binary.BigEndian.Uint32(pak.Extras[16:])
+		byteLimit := binary.BigEndian.Uint32(pak.Extras[24:])
+
+		var valueBuf []byte
+		var keyOnly, complete bool
+		err := source.SelectedBucket().RangeScans().Continue(scanUUID, func(scan *mock.RangeScan) bool {
+			keyOnly = scan.KeyOnly
+
+			numItems := 0
+			for len(scan.Items) > 0 {
+				if itemLimit > 0 && uint32(numItems) >= itemLimit {
+					break
+				}
+				if byteLimit > 0 && uint32(len(valueBuf)) >= byteLimit {
+					break
+				}
+
+				valueBuf = encodeRangeScanItem(valueBuf, scan.Items[0], keyOnly)
+				scan.Items = scan.Items[1:]
+				numItems++
+			}
+
+			complete = len(scan.Items) == 0
+			return complete
+		})
+		if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		status := statusRangeScanMore
+		if complete {
+			status = statusRangeScanComplete
+		}
+
+		itemsFormat := uint32(rangeScanItemsDocuments)
+		if keyOnly {
+			itemsFormat = rangeScanItemsKeys
+		}
+
+		extrasBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(extrasBuf[0:], itemsFormat)
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  status,
+			Extras:  extrasBuf,
+			Value:   valueBuf,
+		}, start)
+	}
+}
+
+func (x *kvImplCrud) handleRangeScanCancelRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		if len(pak.Extras) != 16 {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		var scanUUID [16]byte
+		copy(scanUUID[:], pak.Extras[0:])
+
+		err := source.SelectedBucket().RangeScans().Cancel(scanUUID)
+		if err != nil {
+			x.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+	}
+}
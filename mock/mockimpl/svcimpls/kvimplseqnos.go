@@ -0,0 +1,159 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+// These are the vbucket states which GET_ALL_VB_SEQNOS can filter by.
+const (
+	vbStateActive  = 1
+	vbStateReplica = 2
+)
+
+// kvImplSeqnos implements the commands which report the seqnos of all the
+// vbuckets on a node.  As failovers change the vbucket uuids and may roll back
+// their seqnos, these always reflect the current branch of each vbucket.
+type kvImplSeqnos struct {
+}
+
+func (x *kvImplSeqnos) Register(h *hookHelper) {
+	h.RegisterKvHandler(memd.CmdGetAllVBSeqnos, x.handleGetAllVbSeqnosRequest)
+}
+
+func (x *kvImplSeqnos) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  status,
+	}, start)
+}
+
+func (x *kvImplSeqnos) handleGetAllVbSeqnosRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionDataRead, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return
+	}
+
+	// The extras optionally contain a vbucket state to filter by, which may be
+	// followed by a collection to report the high seqno of.
+	var vbState uint32
+	var collectionID uint32
+	hasCollection := false
+	switch len(pak.Extras) {
+	case 0:
+	case 4:
+		vbState = binary.BigEndian.Uint32(pak.Extras[0:])
+	case 8:
+		vbState = binary.BigEndian.Uint32(pak.Extras[0:])
+		collectionID = binary.BigEndian.Uint32(pak.Extras[4:])
+		hasCollection = true
+	default:
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	if vbState != 0 && vbState != vbStateActive && vbState != vbStateReplica {
+		// We never have pending or dead vbuckets, so there is nothing to report.
+		x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+		return
+	}
+
+	var valueBuf []byte
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+	for vbIdx, repIdx := range vbOwnership {
+		if repIdx < 0 {
+			continue
+		}
+		if vbState == vbStateActive && repIdx != 0 || vbState == vbStateReplica && repIdx == 0 {
+			continue
+		}
+
+		vbucket := selectedBucket.Store().GetVbucket(uint(vbIdx))
+
+		var seqNo uint64
+		if hasCollection {
+			docs, err := vbucket.GetAll(uint(repIdx), uint(collectionID))
+			if err != nil {
+				x.writeStatusReply(source, pak, memd.StatusInternalError, start)
+				return
+			}
+
+			for _, doc := range docs {
+				if doc.SeqNo > seqNo {
+					seqNo = doc.SeqNo
+				}
+			}
+		} else {
+			seqNo = vbucket.CurrentMetaState(uint(repIdx)).CurrentSeqNo
+		}
+
+		entryBuf := make([]byte, 10)
+		binary.BigEndian.PutUint16(entryBuf[0:], uint16(vbIdx))
+		binary.BigEndian.PutUint64(entryBuf[2:], seqNo)
+		valueBuf = append(valueBuf, entryBuf...)
+	}
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  memd.StatusSuccess,
+		Value:   valueBuf,
+	}, start)
+}
+
+// genVbucketSeqnoStats generates the stats for the vbucket-seqno stat group,
+// which can optionally be limited to a single vbucket.  This is how tooling
+// discovers the current uuid of each vbucket alongside its seqnos.
+func genVbucketSeqnoStats(source mock.KvClient, args []string) (map[string]string, error) {
+	selectedBucket := source.SelectedBucket()
+	vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+
+	var vbIdxs []int
+	if len(args) > 0 {
+		vbIdx, err := strconv.Atoi(args[0])
+		if err != nil || vbIdx < 0 || vbIdx >= len(vbOwnership) {
+			return nil, kvproc.ErrInvalidArgument
+		}
+		if vbOwnership[vbIdx] == -1 {
+			return nil, kvproc.ErrNotMyVbucket
+		}
+
+		vbIdxs = append(vbIdxs, vbIdx)
+	} else {
+		for vbIdx, repIdx := range vbOwnership {
+			if repIdx != -1 {
+				vbIdxs = append(vbIdxs, vbIdx)
+			}
+		}
+	}
+
+	stats := make(map[string]string)
+	for _, vbIdx := range vbIdxs {
+		vbucket := selectedBucket.Store().GetVbucket(uint(vbIdx))
+		metaState := vbucket.CurrentMetaState(uint(vbOwnership[vbIdx]))
+
+		stats[fmt.Sprintf("vb_%d:uuid", vbIdx)] = strconv.FormatUint(metaState.VbUUID, 10)
+		stats[fmt.Sprintf("vb_%d:high_seqno", vbIdx)] = strconv.FormatUint(metaState.CurrentSeqNo, 10)
+		stats[fmt.Sprintf("vb_%d:abs_high_seqno", vbIdx)] = strconv.FormatUint(metaState.CurrentSeqNo, 10)
+		stats[fmt.Sprintf("vb_%d:last_persisted_seqno", vbIdx)] = strconv.FormatUint(metaState.PersistSeqNo, 10)
+		stats[fmt.Sprintf("vb_%d:purge_seqno", vbIdx)] = strconv.FormatUint(vbucket.PurgeSeqNo(), 10)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,143 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// These commands and statuses are not yet exposed by memd.
+const (
+	cmdSetBucketThrottleProperties = memd.CmdCode(0x2a)
+	cmdSetBucketDataLimitExceeded  = memd.CmdCode(0x2b)
+
+	statusBucketSizeLimitExceeded   = memd.StatusCode(0x35)
+	statusBucketResidentRatioTooLow = memd.StatusCode(0x36)
+	statusBucketDataSizeTooBig      = memd.StatusCode(0x37)
+	statusBucketDiskSpaceTooLow     = memd.StatusCode(0x38)
+)
+
+// kvImplThrottle implements the admin commands used by the cluster manager to
+// configure the throttling and data limits of buckets.
+type kvImplThrottle struct {
+}
+
+func (x *kvImplThrottle) Register(h *hookHelper) {
+	h.RegisterKvHandler(cmdSetBucketThrottleProperties, x.handleSetBucketThrottlePropertiesRequest)
+	h.RegisterKvHandler(cmdSetBucketDataLimitExceeded, x.handleSetBucketDataLimitExceededRequest)
+}
+
+func (x *kvImplThrottle) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	writePacketToSource(source, &memd.Packet{
+		Magic:   memd.CmdMagicRes,
+		Command: pak.Command,
+		Opaque:  pak.Opaque,
+		Status:  status,
+	}, start)
+}
+
+// getTargetBucket returns the bucket named by the key of an admin command,
+// writing an error reply and returning nil if it cannot be used.
+func (x *kvImplThrottle) getTargetBucket(source mock.KvClient, pak *memd.Packet, start time.Time) mock.Bucket {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, 0) {
+		x.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return nil
+	}
+
+	bucket := source.Source().Node().Cluster().GetBucket(string(pak.Key))
+	if bucket == nil {
+		x.writeStatusReply(source, pak, memd.StatusKeyNotFound, start)
+		return nil
+	}
+
+	return bucket
+}
+
+// parseThrottleLimit parses a single limit, which is either a number of units
+// or the string "unlimited".
+func parseThrottleLimit(data json.RawMessage) (uint64, bool) {
+	var limitStr string
+	if err := json.Unmarshal(data, &limitStr); err == nil {
+		return mock.ThrottleLimitUnlimited, limitStr == "unlimited"
+	}
+
+	var limit uint64
+	if err := json.Unmarshal(data, &limit); err != nil {
+		return 0, false
+	}
+	return limit, true
+}
+
+func (x *kvImplThrottle) handleSetBucketThrottlePropertiesRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	bucket := x.getTargetBucket(source, pak, start)
+	if bucket == nil {
+		return
+	}
+
+	var propsJSON map[string]json.RawMessage
+	if err := json.Unmarshal(pak.Value, &propsJSON); err != nil {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	props := mock.ThrottleProperties{
+		Reserved:  mock.ThrottleLimitUnlimited,
+		HardLimit: mock.ThrottleLimitUnlimited,
+	}
+	for key, value := range propsJSON {
+		var dst *uint64
+		switch key {
+		case "reserved":
+			dst = &props.Reserved
+		case "hard_limit":
+			dst = &props.HardLimit
+		default:
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		limit, ok := parseThrottleLimit(value)
+		if !ok {
+			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+		*dst = limit
+	}
+
+	bucket.SetThrottleProperties(props)
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+func (x *kvImplThrottle) handleSetBucketDataLimitExceededRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	bucket := x.getTargetBucket(source, pak, start)
+	if bucket == nil {
+		return
+	}
+
+	if len(pak.Extras) != 2 {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	// A success status clears a previously exceeded limit.
+	status := memd.StatusCode(binary.BigEndian.Uint16(pak.Extras))
+	switch status {
+	case memd.StatusSuccess,
+		statusBucketSizeLimitExceeded,
+		statusBucketResidentRatioTooLow,
+		statusBucketDataSizeTooBig,
+		statusBucketDiskSpaceTooLow:
+	default:
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	bucket.SetDataLimitStatus(status)
+
+	x.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
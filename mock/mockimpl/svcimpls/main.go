@@ -29,19 +29,30 @@ func Register(opts RegisterOptions) {
 		ViewHooks:      opts.ViewHooks,
 	}
 
+	(&analyticsImplConfig{}).Register(h)
 	(&analyticsImplPing{}).Register(h)
 	(&kvImplAuth{}).Register(h)
 	(&kvImplCccp{}).Register(h)
+	(&kvImplCheckpoint{}).Register(h)
 	(&kvImplCrud{}).Register(h)
+	(&kvImplDcp{}).Register(h)
 	(&kvImplErrMap{}).Register(h)
 	(&kvImplHello{}).Register(h)
+	(&kvImplParams{}).Register(h)
 	(&kvImplPing{}).Register(h)
+	(&kvImplSeqnos{}).Register(h)
+	(&kvImplThrottle{}).Register(h)
 	(&queryImplPing{}).Register(h)
+	(&queryImplQuery{}).Register(h)
 	(&searchImplPing{}).Register(h)
 	(&viewImplPing{}).Register(h)
 	(&viewImplMgmt{}).Register(h)
 	(&viewImplQuery{}).Register(h)
 	(&mgmtImpl{}).Register(h)
+
+	// Hooks run from the most recently registered, so this must come last
+	// for frame extras to be validated before any command is handled.
+	(&kvImplFrames{}).Register(h)
 }
 
 func replyWithError(source mock.KvClient, pak *memd.Packet, start time.Time, err error) {
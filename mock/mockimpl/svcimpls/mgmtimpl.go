@@ -8,12 +8,23 @@ func (x *mgmtImpl) Register(h *hookHelper) {
 	h.RegisterMgmtHandler("GET", "/ui/index.html", x.handleIndex)
 	h.RegisterMgmtHandler("GET", "/pools", x.handleGetAllPoolsConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default", x.handleGetPoolConfig)
+	h.RegisterMgmtHandler("POST", "/pools/default", x.handleUpdatePoolSettings)
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets", x.handleGetAllBucketConfigs)
 	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/doFlush", x.handleBucketFlush)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/compactBucket", x.handleBucketCompact)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/cancelBucketCompaction", x.handleBucketCancelCompaction)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/unsafePurgeBucket", x.handleBucketUnsafePurge)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/pause", x.handleBucketPause)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/resume", x.handleBucketResume)
+	h.RegisterMgmtHandler("GET", "/pools/default/tasks", x.handleGetTasks)
+	h.RegisterMgmtHandler("POST", "/controller/startGracefulFailover", x.handleGracefulFailover)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/controller/startGracefulFailover", x.handleBucketGracefulFailover)
+	h.RegisterMgmtHandler("POST", "/diag/eval", x.handleDiagEval)
 	h.RegisterMgmtHandler("POST", "/pools/default/buckets", x.handleAddBucketConfig)
 	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*", x.handleUpdateBucketConfig)
 	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*", x.handleDropBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/nodeServices", x.handleGetNodeServices)
+	h.RegisterMgmtHandler("GET", "/pools/default/serverGroups", x.handleGetServerGroups)
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*", x.handleGetBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/b/*", x.handleGetTerseBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/bs/*", x.handleGetTerseBucketStreamingConfig)
@@ -22,10 +33,24 @@ func (x *mgmtImpl) Register(h *hookHelper) {
 	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*", x.handleDropScope)
 	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*/collections/*", x.handleDropCollection)
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/scopes", x.handleGetAllScopes)
+	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/scopes/*/collections/*", x.handleGetCollection)
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/ddocs", x.handleGetAllDesignDocuments)
+	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/localRandomKey", x.handleGetLocalRandomKey)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/docs", x.handleImportDocuments)
+	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/nodes/*/stats", x.handleGetBucketNodeStats)
 	h.RegisterMgmtHandler("PUT", "/settings/rbac/users/*/*", x.handleUpsertUser)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/users/*", x.handleGetAllUsers)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/users/*/*", x.handleGetUser)
 	h.RegisterMgmtHandler("DELETE", "/settings/rbac/users/*/*", x.handleDropUser)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/roles", x.handleGetRoles)
+	h.RegisterMgmtHandler("GET", "/settings/analytics", x.handleGetAnalyticsSettings)
+	h.RegisterMgmtHandler("POST", "/settings/analytics", x.handleUpdateAnalyticsSettings)
+	h.RegisterMgmtHandler("GET", "/settings/security", x.handleGetSecuritySettings)
+	h.RegisterMgmtHandler("POST", "/settings/security", x.handleUpdateSecuritySettings)
+	h.RegisterMgmtHandler("GET", "/settings/clientCertAuth", x.handleGetClientCertAuthSettings)
+	h.RegisterMgmtHandler("POST", "/settings/clientCertAuth", x.handleUpdateClientCertAuthSettings)
+	h.RegisterMgmtHandler("GET", "/settings/audit", x.handleGetAuditSettings)
+	h.RegisterMgmtHandler("POST", "/settings/audit", x.handleUpdateAuditSettings)
+	h.RegisterMgmtHandler("GET", "/pools/default/certificate", x.handleGetClusterCertificate)
+	h.RegisterMgmtHandler("GET", "/pools/default/certificate/node/*", x.handleGetNodeCertificate)
 }
@@ -0,0 +1,81 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+const analyticsMaxReplicas = 3
+
+type jsonAnalyticsSettings struct {
+	NumReplicas int `json:"numReplicas"`
+}
+
+type jsonAnalyticsError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// analyticsErrorResponse builds a response in the error format used by the
+// analytics service.
+func analyticsErrorResponse(statusCode, code int, msg string) *mock.HTTPResponse {
+	errBytes, _ := json.Marshal(map[string]interface{}{
+		"errors": []jsonAnalyticsError{{Code: code, Msg: msg}},
+		"status": "fatal",
+	})
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(errBytes),
+	}
+}
+
+func analyticsSettingsResponse(settings mock.AnalyticsSettings) *mock.HTTPResponse {
+	settingsBytes, _ := json.Marshal(jsonAnalyticsSettings{
+		NumReplicas: settings.NumReplicas,
+	})
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(settingsBytes),
+	}
+}
+
+func (x *mgmtImpl) handleGetAnalyticsSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	return analyticsSettingsResponse(source.Node().Cluster().AnalyticsSettings())
+}
+
+func (x *mgmtImpl) handleUpdateAnalyticsSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	settings := cluster.AnalyticsSettings()
+
+	if numReplicasStr := req.Form.Get("numReplicas"); numReplicasStr != "" {
+		numReplicas, err := strconv.Atoi(numReplicasStr)
+		if err != nil || numReplicas < 0 || numReplicas > analyticsMaxReplicas {
+			return analyticsErrorResponse(400, 21002,
+				"numReplicas - The value must be an integer between 0 and "+strconv.Itoa(analyticsMaxReplicas)+".")
+		}
+
+		settings.NumReplicas = numReplicas
+	}
+
+	cluster.SetAnalyticsSettings(settings)
+
+	return analyticsSettingsResponse(settings)
+}
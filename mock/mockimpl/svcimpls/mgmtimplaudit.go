@@ -0,0 +1,178 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// The limits which the server applies to the rotation of the audit log.
+const (
+	minAuditRotateInterval = 15 * time.Minute
+	maxAuditRotateInterval = 7 * 24 * time.Hour
+	maxAuditRotateSize     = 500 * 1024 * 1024
+)
+
+type jsonAuditUser struct {
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+}
+
+type jsonAuditSettings struct {
+	AuditdEnabled  bool            `json:"auditdEnabled"`
+	LogPath        string          `json:"logPath"`
+	RotateInterval int             `json:"rotateInterval"`
+	RotateSize     uint64          `json:"rotateSize"`
+	Disabled       []int           `json:"disabled"`
+	DisabledUsers  []jsonAuditUser `json:"disabledUsers"`
+}
+
+// auditMgmtAction records an administrative action which was performed through
+// the management service in the audit log of the cluster.
+func auditMgmtAction(source mock.MgmtService, req *mock.HTTPRequest, eventID mock.AuditEventID,
+	fields map[string]string) {
+	// Requests without credentials are recorded without a user.
+	username, _, _ := req.BasicAuth()
+
+	cluster := source.Node().Cluster()
+	cluster.AuditLog().Record(mock.AuditEvent{
+		ID:        eventID,
+		Timestamp: cluster.Chrono().Now(),
+		User:      username,
+		Fields:    fields,
+	})
+}
+
+func (x *mgmtImpl) handleGetAuditSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	settings := source.Node().Cluster().AuditLog().Settings()
+
+	jsonSettings := jsonAuditSettings{
+		AuditdEnabled:  settings.Enabled,
+		LogPath:        settings.LogPath,
+		RotateInterval: int(settings.RotateInterval / time.Second),
+		RotateSize:     settings.RotateSize,
+		Disabled:       []int{},
+		DisabledUsers:  []jsonAuditUser{},
+	}
+	for _, eventID := range settings.DisabledEvents {
+		jsonSettings.Disabled = append(jsonSettings.Disabled, int(eventID))
+	}
+	for _, user := range settings.DisabledUsers {
+		jsonSettings.DisabledUsers = append(jsonSettings.DisabledUsers, jsonAuditUser{
+			Name:   user,
+			Domain: "local",
+		})
+	}
+
+	settingsBytes, _ := json.Marshal(jsonSettings)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(settingsBytes),
+	}
+}
+
+func (x *mgmtImpl) handleUpdateAuditSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	auditLog := source.Node().Cluster().AuditLog()
+	settings := auditLog.Settings()
+
+	if enabledStr := req.Form.Get("auditdEnabled"); enabledStr != "" {
+		if enabledStr != "true" && enabledStr != "false" {
+			return securityErrorResponse("auditdEnabled", "The value must be one of the following: [true,false]")
+		}
+
+		settings.Enabled = enabledStr == "true"
+	}
+
+	if logPath := req.Form.Get("logPath"); logPath != "" {
+		settings.LogPath = logPath
+	}
+
+	if intervalStr := req.Form.Get("rotateInterval"); intervalStr != "" {
+		intervalSecs, err := strconv.Atoi(intervalStr)
+		interval := time.Duration(intervalSecs) * time.Second
+		if err != nil || interval < minAuditRotateInterval || interval > maxAuditRotateInterval {
+			return securityErrorResponse("rotateInterval", fmt.Sprintf("The value must be in range from %d to %d",
+				int(minAuditRotateInterval/time.Second), int(maxAuditRotateInterval/time.Second)))
+		}
+		if intervalSecs%60 != 0 {
+			return securityErrorResponse("rotateInterval", "Value must not be a fraction of minute")
+		}
+
+		settings.RotateInterval = interval
+	}
+
+	if sizeStr := req.Form.Get("rotateSize"); sizeStr != "" {
+		size, err := strconv.ParseUint(sizeStr, 10, 64)
+		if err != nil || size > maxAuditRotateSize {
+			return securityErrorResponse("rotateSize",
+				fmt.Sprintf("The value must be in range from 0 to %d", maxAuditRotateSize))
+		}
+
+		settings.RotateSize = size
+	}
+
+	if _, ok := req.Form["disabled"]; ok {
+		settings.DisabledEvents = nil
+		for _, idStr := range strings.Split(req.Form.Get("disabled"), ",") {
+			if idStr == "" {
+				continue
+			}
+
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return securityErrorResponse("disabled", "All event id's must be integers")
+			}
+
+			settings.DisabledEvents = append(settings.DisabledEvents, mock.AuditEventID(id))
+		}
+	}
+
+	if _, ok := req.Form["disabledUsers"]; ok {
+		settings.DisabledUsers = nil
+		for _, userStr := range strings.Split(req.Form.Get("disabledUsers"), ",") {
+			if userStr == "" {
+				continue
+			}
+
+			// Users are identified along with their domain, but only local
+			// users exist within the mock.
+			userParts := strings.SplitN(userStr, "/", 2)
+			if len(userParts) != 2 || userParts[0] == "" {
+				return securityErrorResponse("disabledUsers",
+					fmt.Sprintf("Invalid format. Expecting a list of <user>/<domain>: %s", userStr))
+			}
+
+			settings.DisabledUsers = append(settings.DisabledUsers, userParts[0])
+		}
+	}
+
+	auditLog.SetSettings(settings)
+	auditMgmtAction(source, req, mock.AuditEventModifyAuditSettings, map[string]string{
+		"auditd_enabled": strconv.FormatBool(settings.Enabled),
+	})
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/couchbaselabs/gocaves/contrib/pathparse"
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
 )
 
 func (x *mgmtImpl) handleCreateCollection(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
@@ -68,10 +69,41 @@ func (x *mgmtImpl) handleCreateCollection(source mock.MgmtService, req *mock.HTT
 				Body:       bytes.NewReader([]byte(`{"errors":{"maxTTL":"The value must be an integer"}`)),
 			}
 		}
+		if maxTTL < 0 || maxTTL > maxBucketMaxTTL {
+			return &mock.HTTPResponse{
+				StatusCode: 400,
+				Body:       bytes.NewReader([]byte(`{"errors":{"maxTTL":"The value must be in range from 0 to 2147483647"}}`)),
+			}
+		}
+	}
+
+	historyStr := req.Form.Get("history")
+	var history bool
+	if historyStr != "" {
+		var err error
+		history, err = strconv.ParseBool(historyStr)
+		if err != nil {
+			return &mock.HTTPResponse{
+				StatusCode: 400,
+				Body:       bytes.NewReader([]byte(`{"errors":{"history":"history must be true or false"}}`)),
+			}
+		}
+		if history && bucket.StorageBackend() != mock.StorageBackendMagma {
+			return &mock.HTTPResponse{
+				StatusCode: 400,
+				Body:       bytes.NewReader([]byte(`{"errors":{"history":"History can only be enabled for collections in magma buckets"}}`)),
+			}
+		}
 	}
 	manifest := bucket.CollectionManifest()
 
-	uid, err := manifest.AddCollection(scope, name, uint32(maxTTL))
+	uid, err := manifest.AddCollection(scope, name, uint32(maxTTL), history)
+	if err == nil {
+		_, scopes := manifest.GetManifest()
+		if manifestScope, manifestColl, ok := findManifestCollection(scopes, scope, name); ok {
+			pushCollectionEvent(bucket, mockdb.SystemEventCollectionCreate, uid, manifestScope, manifestColl)
+		}
+	}
 	switch err {
 	case mock.ErrCollectionExists:
 		return &mock.HTTPResponse{
@@ -190,7 +222,13 @@ func (x *mgmtImpl) handleDropCollection(source mock.MgmtService, req *mock.HTTPR
 	}
 
 	manifest := bucket.CollectionManifest()
+	_, scopes := manifest.GetManifest()
+	manifestScope, manifestColl, _ := findManifestCollection(scopes, scope, collection)
+
 	uid, err := manifest.DropCollection(scope, collection)
+	if err == nil {
+		pushCollectionEvent(bucket, mockdb.SystemEventCollectionDrop, uid, manifestScope, manifestColl)
+	}
 	switch err {
 	case mock.ErrCollectionNotFound:
 		return &mock.HTTPResponse{
@@ -246,7 +284,20 @@ func (x *mgmtImpl) handleDropScope(source mock.MgmtService, req *mock.HTTPReques
 	}
 
 	manifest := bucket.CollectionManifest()
+	_, scopes := manifest.GetManifest()
+
 	uid, err := manifest.DropScope(scope)
+	if err == nil {
+		// Dropping a scope drops every collection within it.
+		for _, manifestScope := range scopes {
+			if manifestScope.Name != scope {
+				continue
+			}
+			for _, manifestColl := range manifestScope.Collections {
+				pushCollectionEvent(bucket, mockdb.SystemEventCollectionDrop, uid, manifestScope, manifestColl)
+			}
+		}
+	}
 	switch err {
 	case mock.ErrScopeNotFound:
 		return &mock.HTTPResponse{
@@ -262,6 +313,38 @@ func (x *mgmtImpl) handleDropScope(source mock.MgmtService, req *mock.HTTPReques
 	}
 }
 
+// findManifestCollection finds a collection, and the scope containing it, by
+// name within a list of manifest scopes.
+func findManifestCollection(scopes []mock.CollectionManifestScope, scopeName, collectionName string) (
+	mock.CollectionManifestScope, mock.CollectionManifestCollection, bool) {
+	for _, scope := range scopes {
+		if scope.Name != scopeName {
+			continue
+		}
+		for _, collection := range scope.Collections {
+			if collection.Name == collectionName {
+				return scope, collection, true
+			}
+		}
+	}
+
+	return mock.CollectionManifestScope{}, mock.CollectionManifestCollection{}, false
+}
+
+// pushCollectionEvent records the creation or dropping of a collection in the
+// vbuckets of a bucket, so that it is sent to DCP consumers.
+func pushCollectionEvent(bucket mock.Bucket, eventType mockdb.SystemEventType, manifestUID uint64,
+	scope mock.CollectionManifestScope, collection mock.CollectionManifestCollection) {
+	bucket.Store().PushSystemEvent(&mockdb.SystemEvent{
+		Type:         eventType,
+		ManifestUID:  manifestUID,
+		ScopeID:      scope.UID,
+		CollectionID: collection.UID,
+		Name:         collection.Name,
+		MaxTTL:       collection.MaxTTL,
+	})
+}
+
 func (x *mgmtImpl) handleGetAllScopes(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
 	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
 		return &mock.HTTPResponse{
@@ -292,7 +375,7 @@ func (x *mgmtImpl) handleGetAllScopes(source mock.MgmtService, req *mock.HTTPReq
 	}
 
 	manifest := bucket.CollectionManifest()
-	uid, scopes := manifest.GetManifest()
+	uid, scopes := manifest.GetManifestAt(bucket.NodeManifestTime(source.Node()))
 
 	jsonMani := buildJSONManifest(uid, scopes)
 
@@ -310,6 +393,88 @@ func (x *mgmtImpl) handleGetAllScopes(source mock.MgmtService, req *mock.HTTPReq
 	}
 }
 
+func (x *mgmtImpl) handleGetCollection(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/scopes/*/collections/*")
+	if len(pathParts) != 3 {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("invalid path")),
+		}
+	}
+	bucketName := pathParts[0]
+	scopeName := pathParts[1]
+	collectionName := pathParts[2]
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, bucketName, scopeName, collectionName, req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found.")),
+		}
+	}
+
+	// This is served from the same version of the manifest as the full
+	// listing, so the two always agree with each other.
+	manifest := bucket.CollectionManifest()
+	_, scopes := manifest.GetManifestAt(bucket.NodeManifestTime(source.Node()))
+
+	for _, scope := range scopes {
+		if scope.Name != scopeName {
+			continue
+		}
+
+		for _, collection := range scope.Collections {
+			if collection.Name != collectionName {
+				continue
+			}
+
+			b, err := json.Marshal(map[string]interface{}{
+				"uid":     strconv.Itoa(int(collection.UID)),
+				"name":    collection.Name,
+				"maxTTL":  collection.MaxTTL,
+				"history": collection.History,
+			})
+			if err != nil {
+				return &mock.HTTPResponse{
+					StatusCode: 500,
+					Body:       bytes.NewReader([]byte(err.Error())),
+				}
+			}
+
+			return &mock.HTTPResponse{
+				StatusCode: 200,
+				Body:       bytes.NewReader(b),
+			}
+		}
+
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body: bytes.NewReader([]byte(
+				fmt.Sprintf(`{"errors":{"_":"Collection with name \"%s\" in scope \"%s\" is not found"}}`,
+					collectionName,
+					scopeName,
+				))),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 404,
+		Body: bytes.NewReader([]byte(
+			fmt.Sprintf(`{"errors":{"_":"Scope with name \"%s\" is not found"}}`, scopeName))),
+	}
+}
+
 func buildJSONManifest(uid uint64, scopes []mock.CollectionManifestScope) jsonManifest {
 	jsonMani := jsonManifest{
 		UID:    strconv.Itoa(int(uid)),
@@ -325,9 +490,10 @@ func buildJSONManifest(uid uint64, scopes []mock.CollectionManifestScope) jsonMa
 
 		for j, col := range scop.Collections {
 			jsonScop.Collections[j] = jsonCollection{
-				UID:    strconv.Itoa(int(col.UID)),
-				Name:   col.Name,
-				MaxTTL: col.MaxTTL,
+				UID:     strconv.Itoa(int(col.UID)),
+				Name:    col.Name,
+				MaxTTL:  col.MaxTTL,
+				History: col.History,
 			}
 		}
 		jsonMani.Scopes[i] = jsonScop
@@ -348,7 +514,8 @@ type jsonScope struct {
 }
 
 type jsonCollection struct {
-	UID    string `json:"uid"`
-	Name   string `json:"name"`
-	MaxTTL uint32 `json:"maxTTL,omitempty"`
+	UID     string `json:"uid"`
+	Name    string `json:"name"`
+	MaxTTL  uint32 `json:"maxTTL,omitempty"`
+	History bool   `json:"history,omitempty"`
 }
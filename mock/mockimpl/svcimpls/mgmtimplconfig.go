@@ -2,7 +2,9 @@ package svcimpls
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strconv"
@@ -67,11 +69,13 @@ func (x *mgmtImpl) handleGetTerseBucketConfig(source mock.MgmtService, req *mock
 	if bucket == nil {
 		return &mock.HTTPResponse{
 			StatusCode: 404,
-			Body:       bytes.NewReader([]byte{}),
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
 		}
 	}
 
-	bucketConfig := GenTerseBucketConfig(bucket, source.Node())
+	// This is generated on every request, so it always reflects the current
+	// revision and topology, addressed as the client sees them.
+	bucketConfig := genTerseBucketConfig(bucket, source.Node(), genHTTPClientNetwork(source.Node(), req))
 	return &mock.HTTPResponse{
 		StatusCode: 200,
 		Body:       bytes.NewReader(bucketConfig),
@@ -100,11 +104,11 @@ func (x *mgmtImpl) handleGetTerseBucketStreamingConfig(source mock.MgmtService,
 	if bucket == nil {
 		return &mock.HTTPResponse{
 			StatusCode: 404,
-			Body:       bytes.NewReader([]byte{}),
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
 		}
 	}
 
-	bucketConfig := GenTerseBucketConfig(bucket, source.Node())
+	network := genHTTPClientNetwork(source.Node(), req)
 
 	reader, writer := io.Pipe()
 	watcher := &configHandler{}
@@ -113,6 +117,8 @@ func (x *mgmtImpl) handleGetTerseBucketStreamingConfig(source mock.MgmtService,
 
 	go func() {
 		for {
+			// Streaming responses are flushed by the server as they are written.
+			bucketConfig := genTerseBucketConfig(bucket, source.Node(), network)
 			_, err := writer.Write(bucketConfig)
 			if err != nil {
 				return
@@ -121,7 +127,6 @@ func (x *mgmtImpl) handleGetTerseBucketStreamingConfig(source mock.MgmtService,
 			if err != nil {
 				return
 			}
-			req.Flusher.Flush()
 
 			select {
 			case <-req.Context.Done():
@@ -194,6 +199,9 @@ func (x *mgmtImpl) handleBucketFlush(source mock.MgmtService, req *mock.HTTPRequ
 		}
 	}
 	bucket.Flush()
+	auditMgmtAction(source, req, mock.AuditEventFlushBucket, map[string]string{
+		"bucket_name": bucketName,
+	})
 
 	return &mock.HTTPResponse{
 		StatusCode: 200,
@@ -201,20 +209,45 @@ func (x *mgmtImpl) handleBucketFlush(source mock.MgmtService, req *mock.HTTPRequ
 	}
 }
 
-func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions, error) {
+// Various limits which the server applies when validating bucket settings.
+const (
+	minBucketRamQuotaMB = 100
+	maxBucketReplicas   = 3
+	maxBucketMaxTTL     = 2147483647
+)
 
+func (x *mgmtImpl) parseBucketSettings(values url.Values, cluster mock.Cluster) (mock.NewBucketOptions, error) {
 	flushEnabledStr := values.Get("flushEnabled")
 	ramQuotaMBStr := values.Get("ramQuotaMB")
 	replicaIndexStr := values.Get("replicaIndex")
 	replicaNumberStr := values.Get("replicaNumber")
 	compressionModeStr := values.Get("compressionMode")
+	maxTTLStr := values.Get("maxTTL")
+	evictionPolicyStr := values.Get("evictionPolicy")
+	storageBackendStr := values.Get("storageBackend")
+
+	// The server validates every field before responding, and reports all of
+	// the failures at once, keyed by the field which was invalid.
+	fieldErrors := make(map[string]string)
 
 	var replicaNumber int
 	if replicaNumberStr != "" {
 		var err error
 		replicaNumber, err = strconv.Atoi(replicaNumberStr)
-		if err != nil {
-			return mock.NewBucketOptions{}, errors.New(`{"errors":{"replicaNumber":"The value must be an integer"}`)
+		if err != nil || replicaNumber < 0 {
+			fieldErrors["replicaNumber"] = "The replica number must be specified and must be a non-negative integer."
+		} else if replicaNumber > maxBucketReplicas {
+			fieldErrors["replicaNumber"] = "Replica number larger than 3 is not supported."
+		} else {
+			numDataNodes := 0
+			for _, node := range cluster.Nodes() {
+				if node.KvService() != nil {
+					numDataNodes++
+				}
+			}
+			if replicaNumber >= numDataNodes && replicaNumber > 0 {
+				fieldErrors["replicaNumber"] = "Warning: you do not have enough data servers to support this number of replicas."
+			}
 		}
 	}
 
@@ -223,16 +256,15 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 		var err error
 		flushEnabled, err = strconv.ParseBool(flushEnabledStr)
 		if err != nil {
-			return mock.NewBucketOptions{}, errors.New(`{"errors":{"flushEnabled":"flushenabled can only be 1 or 0"}`)
+			fieldErrors["flushEnabled"] = "flushenabled can only be 1 or 0"
 		}
 	}
 
-	if ramQuotaMBStr == "" {
-		return mock.NewBucketOptions{}, errors.New(`{"errors":{"ramQuota":"The RAM Quota must be specified and must be a positive integer."}`)
-	}
 	ramQuotaMB, err := strconv.ParseUint(ramQuotaMBStr, 10, 0)
 	if err != nil {
-		return mock.NewBucketOptions{}, errors.New(`{"errors":{"ramQuota":"The RAM Quota must be specified and must be a positive integer."}`)
+		fieldErrors["ramQuota"] = "The RAM Quota must be specified and must be a positive integer."
+	} else if ramQuotaMB < minBucketRamQuotaMB {
+		fieldErrors["ramQuota"] = "RAM quota cannot be less than 100 MiB"
 	}
 
 	var replicaIndexEnabled bool
@@ -240,7 +272,36 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 		var err error
 		replicaIndexEnabled, err = strconv.ParseBool(replicaIndexStr)
 		if err != nil {
-			return mock.NewBucketOptions{}, errors.New(`{"errors":{"replicaIndex":"replicaIndex can only be 1 or 0"}`)
+			fieldErrors["replicaIndex"] = "replicaIndex can only be 1 or 0"
+		}
+	}
+
+	if maxTTLStr != "" {
+		maxTTL, err := strconv.Atoi(maxTTLStr)
+		if err != nil || maxTTL < 0 || maxTTL > maxBucketMaxTTL {
+			fieldErrors["maxTTL"] = "Max TTL must be an integer between 0 and 2147483647"
+		}
+	}
+
+	if evictionPolicyStr != "" {
+		evictionPolicy := mock.EvictionPolicy(evictionPolicyStr)
+		if mock.BucketTypeFromString(values.Get("bucketType")) == mock.BucketTypeEphemeral {
+			if evictionPolicy != mock.EvictionPolicyNoEviction && evictionPolicy != mock.EvictionPolicyNruEviction {
+				fieldErrors["evictionPolicy"] = "Eviction policy must be either 'noEviction' or 'nruEviction' for ephemeral buckets"
+			}
+		} else {
+			if evictionPolicy != mock.EvictionPolicyValueOnly && evictionPolicy != mock.EvictionPolicyFullEviction {
+				fieldErrors["evictionPolicy"] = "Eviction policy must be either 'valueOnly' or 'fullEviction' for couchbase buckets"
+			}
+		}
+	}
+
+	if storageBackendStr != "" {
+		storageBackend := mock.StorageBackend(storageBackendStr)
+		if mock.BucketTypeFromString(values.Get("bucketType")) != mock.BucketTypeCouchbase {
+			fieldErrors["storageBackend"] = "Storage backend can only be specified for couchbase buckets"
+		} else if storageBackend != mock.StorageBackendCouchstore && storageBackend != mock.StorageBackendMagma {
+			fieldErrors["storageBackend"] = "Storage backend must be either 'couchstore' or 'magma'"
 		}
 	}
 
@@ -249,12 +310,21 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 		compressionModeStr = "passive"
 	}
 
+	if len(fieldErrors) > 0 {
+		errorsBytes, _ := json.Marshal(map[string]interface{}{
+			"errors": fieldErrors,
+		})
+		return mock.NewBucketOptions{}, errors.New(string(errorsBytes))
+	}
+
 	return mock.NewBucketOptions{
 		NumReplicas:         uint(replicaNumber),
 		FlushEnabled:        flushEnabled,
 		RamQuota:            ramQuotaMB * 1024 * 1024,
 		ReplicaIndexEnabled: replicaIndexEnabled,
 		CompressionMode:     mock.CompressionMode(compressionModeStr),
+		EvictionPolicy:      mock.EvictionPolicy(evictionPolicyStr),
+		StorageBackend:      mock.StorageBackend(storageBackendStr),
 	}, nil
 }
 
@@ -266,9 +336,10 @@ func (x *mgmtImpl) handleAddBucketConfig(source mock.MgmtService, req *mock.HTTP
 		}
 	}
 
+	cluster := source.Node().Cluster()
 	bucketType := req.Form.Get("bucketType")
 	name := req.Form.Get("name")
-	settings, err := x.parseBucketSettings(req.Form)
+	settings, err := x.parseBucketSettings(req.Form, cluster)
 	if err != nil {
 		return &mock.HTTPResponse{
 			StatusCode: 400,
@@ -279,13 +350,27 @@ func (x *mgmtImpl) handleAddBucketConfig(source mock.MgmtService, req *mock.HTTP
 	settings.Name = name
 	settings.Type = mock.BucketTypeFromString(bucketType)
 
-	_, err = source.Node().Cluster().AddBucket(settings)
-	if err != nil {
+	_, err = cluster.AddBucket(settings)
+	if err == mock.ErrTooManyBuckets {
+		errorsBytes, _ := json.Marshal(map[string]interface{}{
+			"errors": map[string]string{
+				"_": fmt.Sprintf("Cannot create more than %d buckets", cluster.MaxBucketCount()),
+			},
+		})
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader(errorsBytes),
+		}
+	} else if err != nil {
 		return &mock.HTTPResponse{
 			StatusCode: 400,
 			Body:       bytes.NewReader([]byte(`{"errors":{"": ""}`)),
 		}
 	}
+	auditMgmtAction(source, req, mock.AuditEventCreateBucket, map[string]string{
+		"bucket_name": name,
+		"type":        bucketType,
+	})
 
 	return &mock.HTTPResponse{
 		StatusCode: 202,
@@ -313,7 +398,7 @@ func (x *mgmtImpl) handleUpdateBucketConfig(source mock.MgmtService, req *mock.H
 	}
 
 	// The server just ignores bucket type if it's set.
-	settings, err := x.parseBucketSettings(req.Form)
+	settings, err := x.parseBucketSettings(req.Form, source.Node().Cluster())
 	if err != nil {
 		return &mock.HTTPResponse{
 			StatusCode: 400,
@@ -327,12 +412,16 @@ func (x *mgmtImpl) handleUpdateBucketConfig(source mock.MgmtService, req *mock.H
 		RamQuota:            settings.RamQuota,
 		ReplicaIndexEnabled: settings.ReplicaIndexEnabled,
 		CompressionMode:     settings.CompressionMode,
+		EvictionPolicy:      settings.EvictionPolicy,
 	}); err != nil {
 		return &mock.HTTPResponse{
 			StatusCode: 400,
 			Body:       bytes.NewReader([]byte(`{"errors":{"": ""}`)),
 		}
 	}
+	auditMgmtAction(source, req, mock.AuditEventModifyBucket, map[string]string{
+		"bucket_name": bucketName,
+	})
 
 	return &mock.HTTPResponse{
 		StatusCode: 200,
@@ -365,6 +454,9 @@ func (x *mgmtImpl) handleDropBucketConfig(source mock.MgmtService, req *mock.HTT
 			Body:       bytes.NewReader([]byte(`{"errors":{"": ""}`)),
 		}
 	}
+	auditMgmtAction(source, req, mock.AuditEventDeleteBucket, map[string]string{
+		"bucket_name": bucketName,
+	})
 
 	return &mock.HTTPResponse{
 		StatusCode: 200,
@@ -0,0 +1,205 @@
+package svcimpls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// diagDirective implements a single test directive of /diag/eval, returning
+// a human readable result.
+type diagDirective struct {
+	numArgs int
+	handler func(cluster mock.Cluster, args []string) (string, error)
+}
+
+// diagDirectives are the test directives which can be evaluated through
+// /diag/eval.  These mirror the harness commands, so that SDKs which can only
+// reach the mock over HTTP can still manipulate the cluster.
+var diagDirectives = map[string]diagDirective{
+	"failover": {1, func(cluster mock.Cluster, args []string) (string, error) {
+		node, err := diagParseNode(cluster, args[0])
+		if err != nil {
+			return "", err
+		}
+		return "ok", cluster.FailoverNode(node.ID())
+	}},
+	"rebalance": {0, func(cluster mock.Cluster, args []string) (string, error) {
+		return "ok", cluster.Rebalance()
+	}},
+	"setservergroup": {2, func(cluster mock.Cluster, args []string) (string, error) {
+		node, err := diagParseNode(cluster, args[0])
+		if err != nil {
+			return "", err
+		}
+		return "ok", cluster.SetNodeServerGroup(node.ID(), args[1])
+	}},
+	"timetravel": {1, func(cluster mock.Cluster, args []string) (string, error) {
+		amountMs, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return "", errors.New("invalid time travel amount")
+		}
+		cluster.Chrono().TimeTravel(time.Duration(amountMs) * time.Millisecond)
+		return "ok", nil
+	}},
+	"bumpconfigrev": {0, func(cluster mock.Cluster, args []string) (string, error) {
+		cluster.BumpConfigRev()
+		return strconv.FormatUint(uint64(cluster.ConfigRev()), 10), nil
+	}},
+	"setconfigscenario": {1, func(cluster mock.Cluster, args []string) (string, error) {
+		scenario := args[0]
+		if scenario == "none" {
+			scenario = ""
+		}
+
+		parsedScenario, err := mock.ParseConfigScenario(scenario)
+		if err != nil {
+			return "", err
+		}
+		cluster.SetConfigScenario(parsedScenario)
+		return "ok", nil
+	}},
+	"discardmutations": {3, func(cluster mock.Cluster, args []string) (string, error) {
+		bucket := cluster.GetBucket(args[0])
+		if bucket == nil {
+			return "", errors.New("invalid bucket name")
+		}
+		vbIdx, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			return "", errors.New("invalid vbucket")
+		}
+		seqNo, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return "", errors.New("invalid seqno")
+		}
+		return "ok", bucket.Store().DiscardMutationsAfter(uint(vbIdx), seqNo)
+	}},
+	"setdatalimitstatus": {2, func(cluster mock.Cluster, args []string) (string, error) {
+		bucket := cluster.GetBucket(args[0])
+		if bucket == nil {
+			return "", errors.New("invalid bucket name")
+		}
+		status, err := strconv.ParseUint(args[1], 0, 16)
+		if err != nil {
+			return "", errors.New("invalid status code")
+		}
+		bucket.SetDataLimitStatus(memd.StatusCode(status))
+		return "ok", nil
+	}},
+	"setpersistedseqno": {3, func(cluster mock.Cluster, args []string) (string, error) {
+		bucket := cluster.GetBucket(args[0])
+		if bucket == nil {
+			return "", errors.New("invalid bucket name")
+		}
+		vbIdx, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			return "", errors.New("invalid vbucket")
+		}
+		vbucket := bucket.Store().GetVbucket(uint(vbIdx))
+		if vbucket == nil {
+			return "", errors.New("invalid vbucket")
+		}
+		seqNo, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return "", errors.New("invalid seqno")
+		}
+		return "ok", vbucket.AcknowledgeSeqNo(seqNo)
+	}},
+	"injecterror": {2, func(cluster mock.Cluster, args []string) (string, error) {
+		// Every kv request for the command fails with the status, replacing any
+		// errors which were previously injected.  A success status stops it.
+		cmd, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return "", errors.New("invalid command")
+		}
+		status, err := strconv.ParseUint(args[1], 0, 16)
+		if err != nil {
+			return "", errors.New("invalid status code")
+		}
+
+		if memd.StatusCode(status) == memd.StatusSuccess {
+			cluster.SetKvFaultInjector(nil)
+			return "ok", nil
+		}
+
+		cluster.SetKvFaultInjector(func(source mock.KvClient, pak *memd.Packet) mock.KvRequestFaults {
+			if pak.Command != memd.CmdCode(cmd) {
+				return mock.KvRequestFaults{}
+			}
+			return mock.KvRequestFaults{Status: memd.StatusCode(status)}
+		})
+		return "ok", nil
+	}},
+}
+
+// diagParseNode finds a node of the cluster by its index.
+func diagParseNode(cluster mock.Cluster, arg string) (mock.ClusterNode, error) {
+	nodes := cluster.Nodes()
+	nodeIdx, err := strconv.Atoi(arg)
+	if err != nil || nodeIdx < 0 || nodeIdx >= len(nodes) {
+		return nil, errors.New("invalid node index")
+	}
+	return nodes[nodeIdx], nil
+}
+
+// handleDiagEval evaluates a single test directive, such as "failover 1",
+// which is provided as the body of the request.
+func (x *mgmtImpl) handleDiagEval(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.Node().HasFeature(mock.ClusterNodeFeatureDiagEval) {
+		return &mock.HTTPResponse{
+			StatusCode: 403,
+			Body:       bytes.NewReader([]byte("API is disabled")),
+		}
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	fields := strings.Fields(string(req.PeekBody()))
+	if len(fields) == 0 {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("no directive specified")),
+		}
+	}
+
+	directive, ok := diagDirectives[fields[0]]
+	if !ok {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(fmt.Sprintf("unknown directive: %s", fields[0]))),
+		}
+	}
+
+	args := fields[1:]
+	if len(args) != directive.numArgs {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(fmt.Sprintf("%s expects %d arguments", fields[0], directive.numArgs))),
+		}
+	}
+
+	result, err := directive.handler(source.Node().Cluster(), args)
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(result)),
+	}
+}
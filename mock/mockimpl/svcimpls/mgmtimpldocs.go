@@ -0,0 +1,139 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+type jsonImportDoc struct {
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+	Flags  uint32          `json:"flags"`
+	Expiry uint32          `json:"expiry"`
+}
+
+type jsonImportDocError struct {
+	Index int    `json:"index"`
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+type jsonImportDocsResponse struct {
+	Imported int                  `json:"imported"`
+	Errors   []jsonImportDocError `json:"errors"`
+}
+
+// handleImportDocuments loads a list of documents directly into the store of
+// a bucket, so that test runners can seed fixtures without any kv traffic.
+// Documents which are invalid are reported individually, the rest are still
+// imported.
+func (x *mgmtImpl) handleImportDocuments(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/docs")
+	bucketName := pathParts[0]
+
+	scopeName := req.URL.Query().Get("scope")
+	if scopeName == "" {
+		scopeName = "_default"
+	}
+	collectionName := req.URL.Query().Get("collection")
+	if collectionName == "" {
+		collectionName = "_default"
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionDataWrite, bucketName, scopeName, collectionName, req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	_, collectionID, err := bucket.CollectionManifest().GetByName(scopeName, collectionName)
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	var jsonDocs []jsonImportDoc
+	if err := json.Unmarshal(body, &jsonDocs); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("Request body must be a JSON array of documents")),
+		}
+	}
+
+	chrono := bucket.Store().Chrono()
+	resp := jsonImportDocsResponse{
+		Errors: []jsonImportDocError{},
+	}
+	docs := make([]*mockdb.Document, 0, len(jsonDocs))
+	for docIdx, jsonDoc := range jsonDocs {
+		docErr := ""
+		if jsonDoc.Key == "" {
+			docErr = "key must not be empty"
+		} else if len(jsonDoc.Key) > maxKeyLength {
+			docErr = "key is too long"
+		} else if len(jsonDoc.Value) == 0 {
+			docErr = "value must be specified"
+		} else if len(jsonDoc.Value) > mockdb.MaxValueSize {
+			docErr = "value is too large"
+		}
+		if docErr != "" {
+			resp.Errors = append(resp.Errors, jsonImportDocError{
+				Index: docIdx,
+				Key:   jsonDoc.Key,
+				Error: docErr,
+			})
+			continue
+		}
+
+		docs = append(docs, &mockdb.Document{
+			CollectionID: uint(collectionID),
+			Key:          []byte(jsonDoc.Key),
+			Value:        jsonDoc.Value,
+			Flags:        jsonDoc.Flags,
+			Datatype:     uint8(memd.DatatypeFlagJSON),
+			Expiry:       kvproc.ParseExpiry(chrono, jsonDoc.Expiry),
+		})
+	}
+
+	_, err = bucket.Store().BulkLoad(docs, mockdb.BulkLoadOptions{HashKeys: true})
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+	resp.Imported = len(docs)
+
+	respBytes, _ := json.Marshal(resp)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(respBytes),
+	}
+}
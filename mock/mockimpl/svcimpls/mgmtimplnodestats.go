@@ -0,0 +1,99 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// findNodeByHostname finds a node by the hostname and port of its management
+// service, as used by ns_server to identify nodes, or by its uuid.
+func findNodeByHostname(cluster mock.Cluster, hostname string) mock.ClusterNode {
+	for _, node := range cluster.Nodes() {
+		if node.ID() == hostname {
+			return node
+		}
+
+		mgmtSvc := node.MgmtService()
+		if mgmtSvc != nil && net.JoinHostPort(mgmtSvc.Hostname(), strconv.Itoa(mgmtSvc.ListenPort())) == hostname {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func (x *mgmtImpl) handleGetBucketNodeStats(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/nodes/*/stats")
+	bucketName := pathParts[0]
+	nodeHostname := pathParts[1]
+
+	if !source.CheckAuthenticated(mockauth.PermissionStatsRead, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	bucket := cluster.GetBucket(bucketName)
+	node := findNodeByHostname(cluster, nodeHostname)
+	if bucket == nil || node == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// The stats are synthesized from the vbuckets which this node holds.
+	var numActiveVbs, numReplicaVbs, activeItems, replicaItems uint64
+	for vbIdx, repIdx := range bucket.VbucketOwnership(node) {
+		if repIdx < 0 {
+			continue
+		}
+
+		numItems := bucket.Store().GetVbucket(uint(vbIdx)).ItemCount(uint(repIdx))
+		if repIdx == 0 {
+			numActiveVbs++
+			activeItems += numItems
+		} else {
+			numReplicaVbs++
+			replicaItems += numItems
+		}
+	}
+
+	timestamp := cluster.Chrono().Now().UnixNano() / 1e6
+
+	stats := map[string]interface{}{
+		"hostname": nodeHostname,
+		"op": map[string]interface{}{
+			"samples": map[string]interface{}{
+				"curr_items":            []uint64{activeItems},
+				"curr_items_tot":        []uint64{activeItems + replicaItems},
+				"vb_active_curr_items":  []uint64{activeItems},
+				"vb_replica_curr_items": []uint64{replicaItems},
+				"vb_active_num":         []uint64{numActiveVbs},
+				"vb_replica_num":        []uint64{numReplicaVbs},
+				"ops":                   []uint64{0},
+				"cmd_get":               []uint64{0},
+				"cmd_set":               []uint64{0},
+				"timestamp":             []int64{timestamp},
+			},
+			"samplesCount": 1,
+			"isPersistent": bucket.BucketType() == mock.BucketTypeCouchbase,
+			"lastTStamp":   timestamp,
+			"interval":     1000,
+		},
+	}
+
+	statsBytes, _ := json.Marshal(stats)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(statsBytes),
+	}
+}
@@ -2,7 +2,6 @@ package svcimpls
 
 import (
 	"bytes"
-	"fmt"
 	"github.com/couchbaselabs/gocaves/mock"
 	"net/http"
 )
@@ -11,7 +10,7 @@ func (x *mgmtImpl) handlePing(source mock.MgmtService, req *mock.HTTPRequest) *m
 	// TODO(chvck): double check that http ping handlers don't need auth
 
 	headers := http.Header{}
-	headers.Add("Location", fmt.Sprintf("http://%s:%d/ui/index.html", source.Hostname(), source.ListenPort()))
+	headers.Add("Location", genHTTPBaseURL(source.Hostname(), source.ListenPort())+"/ui/index.html")
 	return &mock.HTTPResponse{
 		Header:     headers,
 		StatusCode: 301,
@@ -0,0 +1,121 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+// The smallest memory quotas, in megabytes, which the server allows each of
+// the services to be given.
+const (
+	minDataMemoryQuotaMB      = 256
+	minIndexMemoryQuotaMB     = 256
+	minSearchMemoryQuotaMB    = 256
+	minAnalyticsMemoryQuotaMB = 1024
+	minEventingMemoryQuotaMB  = 256
+)
+
+// maxNodeMemoryQuotaMB returns the largest total quota which the server allows
+// on a node with the specified amount of RAM, which is whichever is larger of
+// 80% of the RAM or all but 1GB of it.
+func maxNodeMemoryQuotaMB(memoryTotal uint64) uint64 {
+	memoryTotalMB := memoryTotal / 1024 / 1024
+
+	var minusMegs uint64
+	if memoryTotalMB > 1024 {
+		minusMegs = memoryTotalMB - 1024
+	}
+	maxPercent := memoryTotalMB * 80 / 100
+
+	if minusMegs > maxPercent {
+		return minusMegs
+	}
+	return maxPercent
+}
+
+func (x *mgmtImpl) handleUpdatePoolSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	quotas := cluster.MemoryQuotas()
+
+	// The server validates every field before responding, and reports all of
+	// the failures at once, keyed by the field which was invalid.
+	fieldErrors := make(map[string]string)
+
+	parseQuota := func(field, serviceName string, minQuotaMB uint64, quota *uint64) {
+		quotaStr := req.Form.Get(field)
+		if quotaStr == "" {
+			return
+		}
+
+		quotaMB, err := strconv.ParseUint(quotaStr, 10, 0)
+		if err != nil {
+			fieldErrors[field] = "The value must be an integer"
+			return
+		}
+		if quotaMB < minQuotaMB {
+			fieldErrors[field] = fmt.Sprintf("The %s service quota (%dMB) cannot be less than %dMB.",
+				serviceName, quotaMB, minQuotaMB)
+			return
+		}
+
+		*quota = quotaMB
+	}
+	parseQuota("memoryQuota", "data", minDataMemoryQuotaMB, &quotas.Data)
+	parseQuota("indexMemoryQuota", "index", minIndexMemoryQuotaMB, &quotas.Index)
+	parseQuota("ftsMemoryQuota", "search", minSearchMemoryQuotaMB, &quotas.Search)
+	parseQuota("cbasMemoryQuota", "analytics", minAnalyticsMemoryQuotaMB, &quotas.Analytics)
+	parseQuota("eventingMemoryQuota", "eventing", minEventingMemoryQuotaMB, &quotas.Eventing)
+
+	if _, ok := fieldErrors["memoryQuota"]; !ok {
+		var bucketsQuota uint64
+		for _, bucket := range cluster.GetAllBuckets() {
+			bucketsQuota += bucket.RamQuota()
+		}
+		bucketsQuotaMB := bucketsQuota / 1024 / 1024
+
+		if quotas.Data < bucketsQuotaMB {
+			fieldErrors["memoryQuota"] = fmt.Sprintf(
+				"The data service quota (%dMB) cannot be less than the current total buckets quota (%dMB).",
+				quotas.Data, bucketsQuotaMB)
+		}
+	}
+
+	// Every node reports the same amount of RAM, so the total quota is only
+	// validated once, against the node which received the request.
+	if len(fieldErrors) == 0 {
+		maxQuotaMB := maxNodeMemoryQuotaMB(cluster.NodeMemoryTotal())
+		if quotas.Total() > maxQuotaMB {
+			fieldErrors["_"] = fmt.Sprintf("Total quota (%dMB) exceeds the maximum allowed quota (%dMB) on node '%s'",
+				quotas.Total(), maxQuotaMB, genOtpNode(source.Node()))
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		errorsBytes, _ := json.Marshal(map[string]interface{}{
+			"errors": fieldErrors,
+		})
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader(errorsBytes),
+		}
+	}
+
+	cluster.SetMemoryQuotas(quotas)
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
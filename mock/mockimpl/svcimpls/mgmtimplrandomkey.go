@@ -0,0 +1,58 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+func (x *mgmtImpl) handleGetLocalRandomKey(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/localRandomKey")
+	bucketName := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionDataRead, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// This uses the same logic as GET_RANDOM_KEY, selecting only from the
+	// vbuckets which are active on this node.
+	proc := kvproc.New(bucket.Store(), bucket.VbucketOwnership(source.Node()),
+		bucket.EvictionPolicy() == mock.EvictionPolicyFullEviction, source.Node().ClockSkew())
+	resp, err := proc.GetRandom(kvproc.GetRandomOptions{
+		CollectionID: 0,
+	})
+	if err != nil {
+		errBytes, _ := json.Marshal(map[string]interface{}{
+			"ok":    false,
+			"error": "fallback_to_all_nodes",
+		})
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader(errBytes),
+		}
+	}
+
+	respBytes, _ := json.Marshal(map[string]interface{}{
+		"ok":  true,
+		"key": string(resp.Key),
+	})
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(respBytes),
+	}
+}
@@ -0,0 +1,334 @@
+package svcimpls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+var tlsVersionNames = map[string]uint16{
+	"tlsv1":   tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteNames maps the IANA names of the cipher suites which we support
+// to their ids.
+var cipherSuiteNames = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                      tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":              tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":                tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_AES_128_GCM_SHA256":                        tls.TLS_AES_128_GCM_SHA256,
+	"TLS_AES_256_GCM_SHA384":                        tls.TLS_AES_256_GCM_SHA384,
+	"TLS_CHACHA20_POLY1305_SHA256":                  tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+type jsonSecuritySettings struct {
+	TLSMinVersion          string   `json:"tlsMinVersion"`
+	CipherSuites           []string `json:"cipherSuites"`
+	ClusterEncryptionLevel string   `json:"clusterEncryptionLevel"`
+}
+
+// maxClientCertAuthPrefixes is the most prefixes which can be configured for
+// mapping client certificates to users.
+const maxClientCertAuthPrefixes = 10
+
+type jsonClientCertAuthPrefix struct {
+	Path      string `json:"path"`
+	Prefix    string `json:"prefix"`
+	Delimiter string `json:"delimiter"`
+}
+
+type jsonClientCertAuthSettings struct {
+	State    string                     `json:"state"`
+	Prefixes []jsonClientCertAuthPrefix `json:"prefixes"`
+}
+
+type jsonNodeCertificate struct {
+	Subject  string   `json:"subject"`
+	Expires  string   `json:"expires"`
+	Type     string   `json:"type"`
+	Pem      string   `json:"pem"`
+	Warnings []string `json:"warnings"`
+}
+
+func securityErrorResponse(field, msg string) *mock.HTTPResponse {
+	errBytes, _ := json.Marshal(map[string]interface{}{
+		"errors": map[string]string{field: msg},
+	})
+	return &mock.HTTPResponse{
+		StatusCode: 400,
+		Body:       bytes.NewReader(errBytes),
+	}
+}
+
+func (x *mgmtImpl) handleGetSecuritySettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	settings := source.Node().Cluster().SecuritySettings()
+
+	jsonSettings := jsonSecuritySettings{
+		CipherSuites:           []string{},
+		ClusterEncryptionLevel: settings.ClusterEncryptionLevel,
+	}
+	for name, version := range tlsVersionNames {
+		if version == settings.TLSMinVersion {
+			jsonSettings.TLSMinVersion = name
+		}
+	}
+	for _, suiteID := range settings.CipherSuites {
+		for name, id := range cipherSuiteNames {
+			if id == suiteID {
+				jsonSettings.CipherSuites = append(jsonSettings.CipherSuites, name)
+			}
+		}
+	}
+
+	settingsBytes, _ := json.Marshal(jsonSettings)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(settingsBytes),
+	}
+}
+
+func (x *mgmtImpl) handleUpdateSecuritySettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	settings := cluster.SecuritySettings()
+
+	if versionStr := req.Form.Get("tlsMinVersion"); versionStr != "" {
+		version, ok := tlsVersionNames[versionStr]
+		if !ok {
+			return securityErrorResponse("tlsMinVersion",
+				"Supported TLS versions are tlsv1, tlsv1.1, tlsv1.2 and tlsv1.3")
+		}
+
+		settings.TLSMinVersion = version
+	}
+
+	if _, ok := req.Form["cipherSuites"]; ok {
+		var suiteNames []string
+		if err := json.Unmarshal([]byte(req.Form.Get("cipherSuites")), &suiteNames); err != nil {
+			return securityErrorResponse("cipherSuites", "Invalid format. Expecting a list of ciphers.")
+		}
+
+		var suiteIDs []uint16
+		for _, name := range suiteNames {
+			suiteID, ok := cipherSuiteNames[name]
+			if !ok {
+				return securityErrorResponse("cipherSuites", fmt.Sprintf("Unknown cipher suite: %s", name))
+			}
+
+			suiteIDs = append(suiteIDs, suiteID)
+		}
+
+		settings.CipherSuites = suiteIDs
+	}
+
+	if level := req.Form.Get("clusterEncryptionLevel"); level != "" {
+		if level != "control" && level != "all" && level != "strict" {
+			return securityErrorResponse("clusterEncryptionLevel",
+				"Cluster encryption level must be one of control, all or strict")
+		}
+
+		settings.ClusterEncryptionLevel = level
+	}
+
+	cluster.SetSecuritySettings(settings)
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
+
+func (x *mgmtImpl) handleGetClientCertAuthSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	settings := source.Node().Cluster().ClientCertAuthSettings()
+
+	jsonSettings := jsonClientCertAuthSettings{
+		State:    string(settings.State),
+		Prefixes: []jsonClientCertAuthPrefix{},
+	}
+	for _, prefix := range settings.Prefixes {
+		jsonSettings.Prefixes = append(jsonSettings.Prefixes, jsonClientCertAuthPrefix{
+			Path:      prefix.Path,
+			Prefix:    prefix.Prefix,
+			Delimiter: prefix.Delimiter,
+		})
+	}
+
+	settingsBytes, _ := json.Marshal(jsonSettings)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(settingsBytes),
+	}
+}
+
+func (x *mgmtImpl) handleUpdateClientCertAuthSettings(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	// Unlike most settings, these are sent as a JSON body.
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	var jsonSettings jsonClientCertAuthSettings
+	if err := json.Unmarshal(body, &jsonSettings); err != nil {
+		return securityErrorResponse("_", "Invalid JSON")
+	}
+
+	state := mock.ClientCertAuthState(jsonSettings.State)
+	switch state {
+	case mock.ClientCertAuthStateDisable, mock.ClientCertAuthStateEnable, mock.ClientCertAuthStateMandatory:
+	default:
+		return securityErrorResponse("state", "State must be one of disable, enable or mandatory")
+	}
+
+	if len(jsonSettings.Prefixes) > maxClientCertAuthPrefixes {
+		return securityErrorResponse("prefixes",
+			fmt.Sprintf("Maximum number of prefixes supported is %d", maxClientCertAuthPrefixes))
+	}
+
+	settings := mock.ClientCertAuthSettings{
+		State: state,
+	}
+	for _, jsonPrefix := range jsonSettings.Prefixes {
+		switch jsonPrefix.Path {
+		case mock.ClientCertAuthPathSubjectCN, mock.ClientCertAuthPathSANURI,
+			mock.ClientCertAuthPathSANDNSName, mock.ClientCertAuthPathSANEmail:
+		default:
+			return securityErrorResponse("prefixes",
+				fmt.Sprintf("Unknown path %s, must be one of subject.cn, san.uri, san.dnsname or san.email",
+					jsonPrefix.Path))
+		}
+
+		for _, prefix := range settings.Prefixes {
+			if prefix.Path == jsonPrefix.Path && prefix.Prefix == jsonPrefix.Prefix {
+				return securityErrorResponse("prefixes", "Multiple entries with the same path and prefix")
+			}
+		}
+
+		settings.Prefixes = append(settings.Prefixes, mock.ClientCertAuthPrefix{
+			Path:      jsonPrefix.Path,
+			Prefix:    jsonPrefix.Prefix,
+			Delimiter: jsonPrefix.Delimiter,
+		})
+	}
+
+	source.Node().Cluster().SetClientCertAuthSettings(settings)
+
+	return &mock.HTTPResponse{
+		StatusCode: 202,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
+
+func (x *mgmtImpl) handleGetClusterCertificate(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(source.Node().Cluster().CertificatePEM()),
+	}
+}
+
+func (x *mgmtImpl) handleGetNodeCertificate(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/certificate/node/*")
+	nodeHostname := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	if findNodeByHostname(cluster, nodeHostname) == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// Every node presents the same certificate.
+	certPem := cluster.CertificatePEM()
+	jsonCert := jsonNodeCertificate{
+		Type:     "generated",
+		Pem:      string(certPem),
+		Warnings: []string{},
+	}
+	if block, _ := pem.Decode(certPem); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			jsonCert.Subject = cert.Subject.String()
+			jsonCert.Expires = cert.NotAfter.UTC().Format(time.RFC3339)
+		}
+	}
+
+	certBytes, _ := json.Marshal(jsonCert)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(certBytes),
+	}
+}
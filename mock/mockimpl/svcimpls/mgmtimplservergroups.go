@@ -0,0 +1,58 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+func (x *mgmtImpl) handleGetServerGroups(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+
+	// Groups are listed in the order that we first see them in the node list,
+	// which keeps the output stable between calls.
+	var groupNames []string
+	groupNodes := make(map[string][]interface{})
+	for _, node := range cluster.Nodes() {
+		groupName := node.ServerGroup()
+		if _, ok := groupNodes[groupName]; !ok {
+			groupNames = append(groupNames, groupName)
+			groupNodes[groupName] = make([]interface{}, 0)
+		}
+
+		nodeConfig := GenClusterNodeConfig(node, source.Node(), nil)
+		groupNodes[groupName] = append(groupNodes[groupName], json.RawMessage(nodeConfig))
+	}
+
+	groupsConfig := make([]interface{}, 0)
+	for groupIdx, groupName := range groupNames {
+		groupURI := fmt.Sprintf("/pools/default/serverGroups/%d", groupIdx)
+		groupsConfig = append(groupsConfig, map[string]interface{}{
+			"name":       groupName,
+			"uri":        groupURI,
+			"addNodeURI": groupURI + "/addNode",
+			"nodes":      groupNodes[groupName],
+		})
+	}
+
+	config := map[string]interface{}{
+		"groups": groupsConfig,
+		"uri":    fmt.Sprintf("/pools/default/serverGroups?rev=%d", cluster.ConfigRev()),
+	}
+
+	configBytes, _ := json.Marshal(config)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(configBytes),
+	}
+}
@@ -0,0 +1,336 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+type jsonRebalanceTask struct {
+	Type          string `json:"type"`
+	Subtype       string `json:"subtype,omitempty"`
+	Status        string `json:"status"`
+	StatusIsStale bool   `json:"statusIsStale"`
+	Progress      int    `json:"progress,omitempty"`
+}
+
+type jsonCompactionTask struct {
+	Type                     string `json:"type"`
+	Status                   string `json:"status"`
+	Bucket                   string `json:"bucket"`
+	Progress                 int    `json:"progress"`
+	ChangesDone              int    `json:"changesDone"`
+	TotalChanges             int    `json:"totalChanges"`
+	RecommendedRefreshPeriod int    `json:"recommendedRefreshPeriod"`
+	CancelURI                string `json:"cancelURI"`
+}
+
+type jsonHibernationTask struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	Status   string `json:"status"`
+	Bucket   string `json:"bucket"`
+	Progress int    `json:"progress"`
+}
+
+// jsonRegisteredTask is the form in which tasks registered by tests are
+// reported, which covers the common fields of every type of task.
+type jsonRegisteredTask struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype,omitempty"`
+	Status   string `json:"status"`
+	Bucket   string `json:"bucket,omitempty"`
+	Progress int    `json:"progress"`
+}
+
+func (x *mgmtImpl) handleBucketCompact(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/compactBucket")
+	bucketName := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// Requesting a compaction while one is running is not an error, the
+	// running compaction simply continues.
+	_ = bucket.StartCompaction()
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(``)),
+	}
+}
+
+func (x *mgmtImpl) handleBucketCancelCompaction(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/cancelBucketCompaction")
+	bucketName := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// As with starting one, cancelling a compaction which is not running is
+	// not an error.
+	_ = bucket.CancelCompaction()
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(``)),
+	}
+}
+
+func (x *mgmtImpl) handleBucketUnsafePurge(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/unsafePurgeBucket")
+	bucketName := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	// Unlike a compaction, the purge happens immediately and moves the purge
+	// seqno of every vbucket up to its high seqno, so any DCP consumer which
+	// is behind must rollback.
+	bucket.Store().ForcePurgeTombstones()
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(``)),
+	}
+}
+
+func (x *mgmtImpl) handleBucketPause(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/pause")
+	return x.startBucketPauseTransition(source, req, pathParts[0], mock.Bucket.StartPause)
+}
+
+func (x *mgmtImpl) handleBucketResume(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/resume")
+	return x.startBucketPauseTransition(source, req, pathParts[0], mock.Bucket.StartResume)
+}
+
+// startBucketPauseTransition begins pausing or resuming a bucket, using the
+// specified method of the bucket.
+func (x *mgmtImpl) startBucketPauseTransition(source mock.MgmtService, req *mock.HTTPRequest, bucketName string,
+	startFn func(mock.Bucket) error) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionBucketManage, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	if err := startFn(bucket); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(``)),
+	}
+}
+
+func (x *mgmtImpl) handleBucketGracefulFailover(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/controller/startGracefulFailover")
+	bucketName := pathParts[0]
+
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	if source.Node().Cluster().GetBucket(bucketName) == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte("Requested resource not found")),
+		}
+	}
+
+	return x.startGracefulFailover(source, req)
+}
+
+func (x *mgmtImpl) handleGracefulFailover(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	return x.startGracefulFailover(source, req)
+}
+
+// startGracefulFailover begins the graceful failover of the node named by the
+// otpNode parameter of a request.
+func (x *mgmtImpl) startGracefulFailover(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	cluster := source.Node().Cluster()
+
+	otpNode := req.Form.Get("otpNode")
+	var failoverNode mock.ClusterNode
+	for _, node := range cluster.Nodes() {
+		if genOtpNode(node) == otpNode {
+			failoverNode = node
+		}
+	}
+	if failoverNode == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("Unknown server given: " + otpNode)),
+		}
+	}
+
+	if _, _, running := cluster.GracefulFailoverProgress(); running {
+		return &mock.HTTPResponse{
+			StatusCode: 503,
+			Body:       bytes.NewReader([]byte("Rebalance running.")),
+		}
+	}
+
+	if err := cluster.StartGracefulFailover(failoverNode.ID()); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(``)),
+	}
+}
+
+func (x *mgmtImpl) handleGetTasks(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	// The rebalance task is always reported, even when nothing is running.
+	// Graceful failovers are reported as a kind of rebalance, and otherwise a
+	// registered rebalance task takes its place.
+	var rebalanceTask interface{} = jsonRebalanceTask{
+		Type:   "rebalance",
+		Status: "notRunning",
+	}
+	_, failoverProgress, failoverRunning := source.Node().Cluster().GracefulFailoverProgress()
+	if failoverRunning {
+		rebalanceTask = jsonRebalanceTask{
+			Type:     "rebalance",
+			Subtype:  "gracefulFailover",
+			Status:   "running",
+			Progress: failoverProgress,
+		}
+	}
+
+	var registeredTasks []interface{}
+	for _, task := range source.Node().Cluster().Tasks().Tasks() {
+		jsonTask := jsonRegisteredTask(task)
+		if task.Type == "rebalance" {
+			if !failoverRunning {
+				rebalanceTask = jsonTask
+			}
+			continue
+		}
+		registeredTasks = append(registeredTasks, jsonTask)
+	}
+	tasks := []interface{}{rebalanceTask}
+
+	for _, bucket := range source.Node().Cluster().GetAllBuckets() {
+		progress, running := bucket.CompactionProgress()
+		if !running {
+			continue
+		}
+
+		tasks = append(tasks, jsonCompactionTask{
+			Type:                     "bucket_compaction",
+			Status:                   "running",
+			Bucket:                   bucket.Name(),
+			Progress:                 progress,
+			ChangesDone:              progress,
+			TotalChanges:             100,
+			RecommendedRefreshPeriod: 2,
+			CancelURI:                fmt.Sprintf("/pools/default/buckets/%s/controller/cancelBucketCompaction", bucket.Name()),
+		})
+	}
+
+	// Pausing and resuming buckets are reported as hibernation tasks.
+	for _, bucket := range source.Node().Cluster().GetAllBuckets() {
+		progress, running := bucket.PauseProgress()
+		if !running {
+			continue
+		}
+
+		subtype := "pause"
+		if bucket.PauseState() == mock.BucketPauseStateResuming {
+			subtype = "resume"
+		}
+
+		tasks = append(tasks, jsonHibernationTask{
+			Type:     "hibernation",
+			Subtype:  subtype,
+			Status:   "running",
+			Bucket:   bucket.Name(),
+			Progress: progress,
+		})
+	}
+
+	tasks = append(tasks, registeredTasks...)
+
+	tasksBytes, _ := json.Marshal(tasks)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(tasksBytes),
+	}
+}
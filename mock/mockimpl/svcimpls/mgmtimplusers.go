@@ -49,6 +49,10 @@ func (x *mgmtImpl) handleUpsertUser(source mock.MgmtService, req *mock.HTTPReque
 			Body:       bytes.NewReader([]byte(err.Error())),
 		}
 	}
+	auditMgmtAction(source, req, mock.AuditEventSetUser, map[string]string{
+		"identity": username,
+		"roles":    strings.Join(roles, ","),
+	})
 	return &mock.HTTPResponse{
 		StatusCode: 200,
 		Body:       bytes.NewReader([]byte("")),
@@ -156,6 +160,9 @@ func (x *mgmtImpl) handleDropUser(source mock.MgmtService, req *mock.HTTPRequest
 			Body:       bytes.NewReader([]byte(err.Error())),
 		}
 	}
+	auditMgmtAction(source, req, mock.AuditEventDeleteUser, map[string]string{
+		"identity": username,
+	})
 
 	return &mock.HTTPResponse{
 		StatusCode: 200,
@@ -30,12 +30,12 @@ func (x *mgmtImpl) handleGetAllDesignDocuments(source mock.MgmtService, req *moc
 	}
 
 	ddocs := bucket.ViewIndexManager().GetAllDesignDocuments()
-	var jsonsRows []jsonGetAllDesignDocsRow
+	jsonsRows := make([]jsonGetAllDesignDocsRow, 0, len(ddocs))
 	for _, ddoc := range ddocs {
 		doc := jsonGetAllDesignDocsDoc{
 			Meta: jsonGetAllDesignDocsMeta{
 				ID:  "_design/" + ddoc.Name,
-				Rev: "", // TODO (chvck): design docs need rev ids
+				Rev: ddoc.Rev,
 			},
 			JSON: ddocToJsonDesignDocument(ddoc),
 		}
@@ -0,0 +1,844 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/google/uuid"
+)
+
+// These are the error codes used by the query service for request errors.
+const (
+	queryErrCodeReadOnly     = 1000
+	queryErrCodeBadValue     = 1040
+	queryErrCodeMissingValue = 1050
+	queryErrCodeInternal     = 5000
+
+	queryErrCodeMemoryQuotaExceeded = 5500
+
+	queryErrCodeKeyspaceNotFound = 12003
+	queryErrCodeIndexScanTimeout = 12015
+	queryErrCodeIndexNotReady    = 12016
+
+	queryErrCodeTransactionNotFound = 17004
+	queryErrCodeTransactionExpired  = 17010
+)
+
+// queryDefaultTxTimeout is the transaction timeout used when a BEGIN
+// TRANSACTION does not specify txtimeout.
+const queryDefaultTxTimeout = 15 * time.Second
+
+// queryDefaultScanWait is how long an at_plus request waits for its scan
+// vectors when it does not specify scan_wait.
+const queryDefaultScanWait = 75 * time.Second
+
+type queryImplQuery struct {
+	txnsLock sync.Mutex
+	txns     map[string]time.Time
+}
+
+func (x *queryImplQuery) Register(h *hookHelper) {
+	h.RegisterQueryHandler("POST", "/query/service", x.handleQuery)
+}
+
+type jsonQueryError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+type jsonQueryMetrics struct {
+	ElapsedTime   string `json:"elapsedTime"`
+	ExecutionTime string `json:"executionTime"`
+	ResultCount   int    `json:"resultCount"`
+	ResultSize    int    `json:"resultSize"`
+	ErrorCount    int    `json:"errorCount,omitempty"`
+	WarningCount  int    `json:"warningCount,omitempty"`
+}
+
+type jsonQueryResponse struct {
+	RequestID       string            `json:"requestID"`
+	ClientContextID string            `json:"clientContextID,omitempty"`
+	Signature       interface{}       `json:"signature,omitempty"`
+	Results         []json.RawMessage `json:"results"`
+	Errors          []jsonQueryError  `json:"errors,omitempty"`
+	Warnings        []jsonQueryError  `json:"warnings,omitempty"`
+	Status          string            `json:"status"`
+	Metrics         jsonQueryMetrics  `json:"metrics"`
+}
+
+// queryTuningParams are the options of a request which tune how it is run.
+// They are only captured so that tests can assert on them, other than the
+// memory quota which limits the size of the results.
+var queryTuningParams = []string{
+	"controls", "memory_quota", "max_parallelism", "scan_cap",
+	"pipeline_batch", "pipeline_cap", "timeout", "profile",
+}
+
+// queryParamString returns a JSON parameter as a string.  SDKs send some of
+// the numeric options, such as max_parallelism, as strings so both are
+// accepted.
+func queryParamString(raw json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+	return string(raw)
+}
+
+// genQueryWarnings returns the warnings which have been injected into the
+// responses of successful queries.
+func genQueryWarnings(cluster mock.Cluster) []jsonQueryError {
+	var warnings []jsonQueryError
+	for _, warning := range cluster.Warnings().QueryWarnings() {
+		warnings = append(warnings, jsonQueryError{
+			Code: warning.Code,
+			Msg:  warning.Msg,
+		})
+	}
+	return warnings
+}
+
+// queryErrorResponse builds a response in the error format used by the query
+// service.
+func queryErrorResponse(statusCode, code int, msg string, clientContextID string, start time.Time) *mock.HTTPResponse {
+	elapsed := time.Since(start).String()
+	respBytes, _ := json.Marshal(jsonQueryResponse{
+		RequestID:       uuid.New().String(),
+		ClientContextID: clientContextID,
+		Results:         []json.RawMessage{},
+		Errors:          []jsonQueryError{{Code: code, Msg: msg}},
+		Status:          "fatal",
+		Metrics: jsonQueryMetrics{
+			ElapsedTime:   elapsed,
+			ExecutionTime: elapsed,
+			ErrorCount:    1,
+		},
+	})
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(respBytes),
+	}
+}
+
+// parseQueryRequest reads the parameters of a query request, which may either
+// be a JSON object or form encoded.  Any error returned is suitable for being
+// returned to the client.
+func (x *queryImplQuery) parseQueryRequest(req *mock.HTTPRequest) (*mock.QueryRequest, error) {
+	params := make(map[string]json.RawMessage)
+	var statement, clientContextID, txID, txTimeout string
+	var scanConsistency, scanWait string
+	var scanVectors json.RawMessage
+	var readOnly, txImplicit bool
+	tuning := make(map[string]string)
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing request body: %v", err)
+		}
+		if err := json.Unmarshal(body, &params); err != nil {
+			return nil, fmt.Errorf("Error processing JSON request body: %v", err)
+		}
+
+		if rawStatement, ok := params["statement"]; ok {
+			if err := json.Unmarshal(rawStatement, &statement); err != nil {
+				return nil, fmt.Errorf("Error processing statement: %v", err)
+			}
+		}
+		if rawContextID, ok := params["client_context_id"]; ok {
+			if err := json.Unmarshal(rawContextID, &clientContextID); err != nil {
+				return nil, fmt.Errorf("Error processing client_context_id: %v", err)
+			}
+		}
+		if rawReadOnly, ok := params["readonly"]; ok {
+			if err := json.Unmarshal(rawReadOnly, &readOnly); err != nil {
+				return nil, fmt.Errorf("Error processing readonly: %v", err)
+			}
+		}
+		if rawTxID, ok := params["txid"]; ok {
+			if err := json.Unmarshal(rawTxID, &txID); err != nil {
+				return nil, fmt.Errorf("Error processing txid: %v", err)
+			}
+		}
+		if rawTxImplicit, ok := params["tximplicit"]; ok {
+			if err := json.Unmarshal(rawTxImplicit, &txImplicit); err != nil {
+				return nil, fmt.Errorf("Error processing tximplicit: %v", err)
+			}
+		}
+		if rawTxTimeout, ok := params["txtimeout"]; ok {
+			if err := json.Unmarshal(rawTxTimeout, &txTimeout); err != nil {
+				return nil, fmt.Errorf("Error processing txtimeout: %v", err)
+			}
+		}
+		if rawScanConsistency, ok := params["scan_consistency"]; ok {
+			if err := json.Unmarshal(rawScanConsistency, &scanConsistency); err != nil {
+				return nil, fmt.Errorf("Error processing scan_consistency: %v", err)
+			}
+		}
+		if rawScanWait, ok := params["scan_wait"]; ok {
+			if err := json.Unmarshal(rawScanWait, &scanWait); err != nil {
+				return nil, fmt.Errorf("Error processing scan_wait: %v", err)
+			}
+		}
+		scanVectors = params["scan_vectors"]
+		for _, name := range queryTuningParams {
+			if rawValue, ok := params[name]; ok {
+				tuning[name] = queryParamString(rawValue)
+			}
+		}
+	} else {
+		// Form encoded parameters are plain strings, except for the arguments
+		// which must themselves be JSON.
+		statement = req.Form.Get("statement")
+		clientContextID = req.Form.Get("client_context_id")
+		if formReadOnly := req.Form.Get("readonly"); formReadOnly != "" {
+			var err error
+			readOnly, err = strconv.ParseBool(formReadOnly)
+			if err != nil {
+				return nil, fmt.Errorf("Error processing readonly: %v", err)
+			}
+		}
+		txID = req.Form.Get("txid")
+		if formTxImplicit := req.Form.Get("tximplicit"); formTxImplicit != "" {
+			var err error
+			txImplicit, err = strconv.ParseBool(formTxImplicit)
+			if err != nil {
+				return nil, fmt.Errorf("Error processing tximplicit: %v", err)
+			}
+		}
+		txTimeout = req.Form.Get("txtimeout")
+		scanConsistency = req.Form.Get("scan_consistency")
+		scanWait = req.Form.Get("scan_wait")
+		if formScanVectors := req.Form.Get("scan_vectors"); formScanVectors != "" {
+			scanVectors = json.RawMessage(formScanVectors)
+		}
+		for _, name := range queryTuningParams {
+			if formValue := req.Form.Get(name); formValue != "" {
+				tuning[name] = formValue
+			}
+		}
+		for key := range req.Form {
+			if key == "args" || strings.HasPrefix(key, "$") {
+				params[key] = json.RawMessage(req.Form.Get(key))
+			}
+		}
+	}
+
+	queryReq := &mock.QueryRequest{
+		Statement:       statement,
+		ClientContextID: clientContextID,
+		ReadOnly:        readOnly,
+		TxID:            txID,
+		TxImplicit:      txImplicit,
+		TxTimeout:       queryDefaultTxTimeout,
+		NamedArgs:       make(map[string]json.RawMessage),
+		ScanConsistency: scanConsistency,
+		ScanWait:        queryDefaultScanWait,
+	}
+
+	if txTimeout != "" {
+		timeout, err := time.ParseDuration(txTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("Error processing txtimeout: invalid duration %s", txTimeout)
+		}
+		queryReq.TxTimeout = timeout
+	}
+
+	if queryReq.TxID != "" && queryReq.TxImplicit {
+		return nil, fmt.Errorf("tximplicit cannot be used with txid")
+	}
+
+	switch scanConsistency {
+	case "", "not_bounded", "request_plus", "statement_plus", "at_plus":
+	default:
+		return nil, fmt.Errorf("Error processing scan_consistency: unknown value %s", scanConsistency)
+	}
+
+	if scanWait != "" {
+		wait, err := time.ParseDuration(scanWait)
+		if err != nil || wait <= 0 {
+			return nil, fmt.Errorf("Error processing scan_wait: invalid duration %s", scanWait)
+		}
+		queryReq.ScanWait = wait
+	}
+
+	if len(scanVectors) > 0 {
+		if scanConsistency != "at_plus" {
+			return nil, fmt.Errorf("scan_vectors parameter should not be present with scan_consistency of %s",
+				scanConsistency)
+		}
+
+		vectors, err := parseQueryScanVectors(scanVectors)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing scan_vectors: %v", err)
+		}
+		queryReq.ScanVectors = vectors
+	} else if scanConsistency == "at_plus" {
+		return nil, fmt.Errorf("scan_vectors parameter is required for scan_consistency of at_plus")
+	}
+
+	if err := parseQueryTuning(queryReq, tuning); err != nil {
+		return nil, err
+	}
+
+	if rawArgs, ok := params["args"]; ok {
+		if err := json.Unmarshal(rawArgs, &queryReq.PositionalArgs); err != nil {
+			return nil, fmt.Errorf("Error processing args: %v", err)
+		}
+	}
+
+	for key, value := range params {
+		if !strings.HasPrefix(key, "$") {
+			continue
+		}
+		if !json.Valid(value) {
+			return nil, fmt.Errorf("Error processing named parameter %s", key)
+		}
+		queryReq.NamedArgs[key[1:]] = value
+	}
+
+	return queryReq, nil
+}
+
+// parseQueryTuning applies the tuning options of a request, which have all been
+// read as strings regardless of how they were sent.
+func parseQueryTuning(queryReq *mock.QueryRequest, tuning map[string]string) error {
+	if controls, ok := tuning["controls"]; ok {
+		var err error
+		queryReq.Controls, err = strconv.ParseBool(controls)
+		if err != nil {
+			return fmt.Errorf("Error processing controls: %v", err)
+		}
+	}
+
+	if memoryQuota, ok := tuning["memory_quota"]; ok {
+		var err error
+		queryReq.MemoryQuota, err = strconv.ParseUint(memoryQuota, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Error processing memory_quota: %v", err)
+		}
+	}
+
+	intParams := map[string]*int{
+		"max_parallelism": &queryReq.MaxParallelism,
+		"scan_cap":        &queryReq.ScanCap,
+		"pipeline_batch":  &queryReq.PipelineBatch,
+		"pipeline_cap":    &queryReq.PipelineCap,
+	}
+	for name, dest := range intParams {
+		if value, ok := tuning[name]; ok {
+			intValue, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Error processing %s: %v", name, err)
+			}
+			*dest = intValue
+		}
+	}
+
+	if timeout, ok := tuning["timeout"]; ok {
+		duration, err := time.ParseDuration(timeout)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("Error processing timeout: invalid duration %s", timeout)
+		}
+		queryReq.Timeout = duration
+	}
+
+	if profile, ok := tuning["profile"]; ok {
+		switch profile {
+		case "off", "phases", "timings":
+			queryReq.Profile = profile
+		default:
+			return fmt.Errorf("Error processing profile: unknown value %s", profile)
+		}
+	}
+
+	return nil
+}
+
+// parseQueryScanVectorEntry parses a single [seqno, vbuuid] pair of a scan
+// vector.  SDKs send the vbuuid as a string, as it does not fit in a double.
+func parseQueryScanVectorEntry(data json.RawMessage) (mock.QueryScanVectorEntry, error) {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return mock.QueryScanVectorEntry{}, err
+	}
+	if len(pair) != 2 {
+		return mock.QueryScanVectorEntry{}, errors.New("entries must be [seqno, vbuuid] pairs")
+	}
+
+	var entry mock.QueryScanVectorEntry
+	if err := json.Unmarshal(pair[0], &entry.SeqNo); err != nil {
+		return mock.QueryScanVectorEntry{}, err
+	}
+
+	var vbUUIDStr string
+	if err := json.Unmarshal(pair[1], &vbUUIDStr); err == nil {
+		vbUUID, err := strconv.ParseUint(vbUUIDStr, 10, 64)
+		if err != nil {
+			return mock.QueryScanVectorEntry{}, err
+		}
+		entry.VbUUID = vbUUID
+	} else if err := json.Unmarshal(pair[1], &entry.VbUUID); err != nil {
+		return mock.QueryScanVectorEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// parseQueryScanVectors parses the scan vectors of an at_plus request.  Each
+// bucket has either a full vector, listing every vbucket in order, or a sparse
+// vector of only some vbuckets keyed by their id.
+func parseQueryScanVectors(data json.RawMessage) (map[string]map[uint16]mock.QueryScanVectorEntry, error) {
+	var bucketVectors map[string]json.RawMessage
+	if err := json.Unmarshal(data, &bucketVectors); err != nil {
+		return nil, err
+	}
+
+	vectors := make(map[string]map[uint16]mock.QueryScanVectorEntry)
+	for bucketName, rawVector := range bucketVectors {
+		vector := make(map[uint16]mock.QueryScanVectorEntry)
+
+		var fullVector []json.RawMessage
+		var sparseVector map[string]json.RawMessage
+		if err := json.Unmarshal(rawVector, &fullVector); err == nil {
+			for vbID, rawEntry := range fullVector {
+				entry, err := parseQueryScanVectorEntry(rawEntry)
+				if err != nil {
+					return nil, err
+				}
+				vector[uint16(vbID)] = entry
+			}
+		} else if err := json.Unmarshal(rawVector, &sparseVector); err == nil {
+			for vbIDStr, rawEntry := range sparseVector {
+				vbID, err := strconv.ParseUint(vbIDStr, 10, 16)
+				if err != nil {
+					return nil, err
+				}
+				entry, err := parseQueryScanVectorEntry(rawEntry)
+				if err != nil {
+					return nil, err
+				}
+				vector[uint16(vbID)] = entry
+			}
+		} else {
+			return nil, err
+		}
+
+		vectors[bucketName] = vector
+	}
+
+	return vectors, nil
+}
+
+// waitForScanVectors waits for every vbucket referenced by the scan vectors of
+// an at_plus request to reach the requested seqno.  Our indexes are always up
+// to date, so this only waits on mutations which the vbuckets do not yet have.
+// The returned response is non-nil if the request could not be satisfied.
+func (x *queryImplQuery) waitForScanVectors(source mock.QueryService, queryReq *mock.QueryRequest,
+	start time.Time) *mock.HTTPResponse {
+	var waitVbuckets []*mockdb.Vbucket
+	var waitSeqNos []uint64
+	for bucketName, vector := range queryReq.ScanVectors {
+		bucket := source.Node().Cluster().GetBucket(bucketName)
+		if bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			return queryErrorResponse(500, queryErrCodeKeyspaceNotFound,
+				fmt.Sprintf("Keyspace not found in CB datastore: default:%s", bucketName),
+				queryReq.ClientContextID, start)
+		}
+
+		for vbID, entry := range vector {
+			vbucket := bucket.Store().GetVbucket(uint(vbID))
+			if vbucket == nil {
+				return queryErrorResponse(400, queryErrCodeBadValue,
+					fmt.Sprintf("Error processing scan_vectors: invalid vbucket %d", vbID),
+					queryReq.ClientContextID, start)
+			}
+
+			waitVbuckets = append(waitVbuckets, vbucket)
+			waitSeqNos = append(waitSeqNos, entry.SeqNo)
+		}
+	}
+
+	timeoutCh := time.After(queryReq.ScanWait)
+	for vbIdx, vbucket := range waitVbuckets {
+		for {
+			mutationCh := vbucket.MutationNotify()
+			if vbucket.MaxSeqNo() >= waitSeqNos[vbIdx] {
+				break
+			}
+
+			select {
+			case <-mutationCh:
+			case <-timeoutCh:
+				return queryErrorResponse(500, queryErrCodeIndexScanTimeout,
+					"Index scan timed out - cause: scan_wait exceeded", queryReq.ClientContextID, start)
+			}
+		}
+	}
+
+	return nil
+}
+
+// queryStatementIdentifiers returns the identifiers which appear within a
+// statement, with any escaping backticks removed.  String literals are
+// skipped so that their contents are not mistaken for identifiers.
+func queryStatementIdentifiers(statement string) map[string]bool {
+	identifiers := make(map[string]bool)
+	isIdentChar := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	for i := 0; i < len(statement); {
+		switch c := statement[i]; {
+		case c == '`', c == '"', c == '\'':
+			end := strings.IndexByte(statement[i+1:], c)
+			if end < 0 {
+				end = len(statement) - i - 1
+			}
+			if c == '`' {
+				identifiers[statement[i+1:i+1+end]] = true
+			}
+			i += end + 2
+		case isIdentChar(c):
+			end := i
+			for end < len(statement) && isIdentChar(statement[end]) {
+				end++
+			}
+			identifiers[statement[i:end]] = true
+			i = end
+		default:
+			i++
+		}
+	}
+	return identifiers
+}
+
+// checkIndexesReady fails a request whose statement references both a bucket
+// and one of its indexes which is still building.  We do not plan statements,
+// so an index is only considered to be used when it is named explicitly, such
+// as by a USE INDEX hint.
+func checkIndexesReady(source mock.QueryService, queryReq *mock.QueryRequest, start time.Time) *mock.HTTPResponse {
+	identifiers := queryStatementIdentifiers(queryReq.Statement)
+	for _, index := range source.Node().Cluster().QueryIndexes().Indexes() {
+		if index.State != mock.QueryIndexStateBuilding {
+			continue
+		}
+		if !identifiers[index.Bucket] || !identifiers[index.Name] {
+			continue
+		}
+
+		return queryErrorResponse(503, queryErrCodeIndexNotReady,
+			fmt.Sprintf("Index %s is not ready - it is still being built on keyspace default:%s",
+				index.Name, index.Bucket),
+			queryReq.ClientContextID, start)
+	}
+	return nil
+}
+
+// queryIsMutation checks whether a statement modifies data, based on the
+// keyword it begins with.
+func queryIsMutation(statement string) bool {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "INSERT", "UPSERT", "UPDATE", "DELETE", "MERGE":
+		return true
+	}
+	return false
+}
+
+// queryTxStatementKind identifies the transaction control statements which are
+// handled by the query service itself rather than the result provider.
+type queryTxStatementKind int
+
+const (
+	queryTxStatementNone = queryTxStatementKind(iota)
+	queryTxStatementBegin
+	queryTxStatementCommit
+	queryTxStatementRollback
+)
+
+// queryTxStatement checks whether a statement begins, commits or rolls back a
+// transaction.  Rolling back to a savepoint leaves the transaction open, so it
+// is not treated as a control statement.
+func queryTxStatement(statement string) queryTxStatementKind {
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+	if len(fields) == 0 || len(fields) > 3 {
+		return queryTxStatementNone
+	}
+	for i := range fields {
+		fields[i] = strings.ToUpper(fields[i])
+	}
+
+	switch fields[0] {
+	case "BEGIN", "START":
+		if len(fields) >= 2 && (fields[1] == "WORK" || fields[1] == "TRANSACTION" || fields[1] == "TRAN") {
+			return queryTxStatementBegin
+		}
+		if len(fields) == 1 && fields[0] == "BEGIN" {
+			return queryTxStatementBegin
+		}
+	case "COMMIT":
+		if len(fields) <= 2 {
+			return queryTxStatementCommit
+		}
+	case "ROLLBACK":
+		if len(fields) <= 2 {
+			return queryTxStatementRollback
+		}
+	}
+	return queryTxStatementNone
+}
+
+// beginTransaction records a new transaction and returns its id.
+func (x *queryImplQuery) beginTransaction(timeout time.Duration) string {
+	x.txnsLock.Lock()
+	defer x.txnsLock.Unlock()
+
+	if x.txns == nil {
+		x.txns = make(map[string]time.Time)
+	}
+
+	// Transactions which were never completed are dropped once they expire.
+	now := time.Now()
+	for txID, expiry := range x.txns {
+		if now.After(expiry) {
+			delete(x.txns, txID)
+		}
+	}
+
+	txID := uuid.New().String()
+	x.txns[txID] = now.Add(timeout)
+	return txID
+}
+
+// checkTransaction validates that a transaction is still open, removing it if
+// the statement ends it.  The returned response is nil if the statement may
+// proceed.
+func (x *queryImplQuery) checkTransaction(queryReq *mock.QueryRequest, end bool, start time.Time) *mock.HTTPResponse {
+	x.txnsLock.Lock()
+	defer x.txnsLock.Unlock()
+
+	expiry, ok := x.txns[queryReq.TxID]
+	if !ok {
+		return queryErrorResponse(404, queryErrCodeTransactionNotFound,
+			fmt.Sprintf("Transaction context error: transaction (%s) not found", queryReq.TxID),
+			queryReq.ClientContextID, start)
+	}
+	if time.Now().After(expiry) {
+		delete(x.txns, queryReq.TxID)
+		return queryErrorResponse(500, queryErrCodeTransactionExpired, "Transaction timeout",
+			queryReq.ClientContextID, start)
+	}
+	if end {
+		delete(x.txns, queryReq.TxID)
+	}
+	return nil
+}
+
+// streamQueryResponse streams a successful response to the client, invoking a
+// callback once some of the rows have been received and before the rest are
+// sent.
+func (x *queryImplQuery) streamQueryResponse(queryReq *mock.QueryRequest, rows []json.RawMessage,
+	warnings []jsonQueryError, afterRows int, callback func(), start time.Time) *mock.HTTPResponse {
+	requestID := uuid.New().String()
+
+	// The response is generated without its rows, then split around them so
+	// that they can be written separately.
+	genResponseParts := func() ([]byte, []byte) {
+		resultSize := 0
+		for _, row := range rows {
+			resultSize += len(row)
+		}
+
+		elapsed := time.Since(start).String()
+		respBytes, _ := json.Marshal(jsonQueryResponse{
+			RequestID:       requestID,
+			ClientContextID: queryReq.ClientContextID,
+			Signature:       map[string]string{"*": "*"},
+			Results:         []json.RawMessage{},
+			Warnings:        warnings,
+			Status:          "success",
+			Metrics: jsonQueryMetrics{
+				ElapsedTime:   elapsed,
+				ExecutionTime: elapsed,
+				ResultCount:   len(rows),
+				ResultSize:    resultSize,
+				WarningCount:  len(warnings),
+			},
+		})
+
+		resultsIdx := bytes.Index(respBytes, []byte(`"results":[]`)) + len(`"results":[`)
+		return respBytes[:resultsIdx], respBytes[resultsIdx:]
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer writer.Close()
+
+		prefix, _ := genResponseParts()
+		if _, err := writer.Write(prefix); err != nil {
+			return
+		}
+
+		for rowIdx, row := range rows {
+			if rowIdx == afterRows {
+				// The empty write only completes once everything before it has
+				// been flushed to the client.
+				if _, err := writer.Write([]byte{}); err != nil {
+					return
+				}
+				callback()
+			}
+
+			if rowIdx > 0 {
+				row = append([]byte{','}, row...)
+			}
+			if _, err := writer.Write(row); err != nil {
+				return
+			}
+		}
+
+		_, suffix := genResponseParts()
+		_, _ = writer.Write(suffix)
+	}()
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       reader,
+		Streaming:  true,
+	}
+}
+
+func (x *queryImplQuery) handleQuery(source mock.QueryService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	start := time.Now()
+
+	// We do not parse statements, so the keyspaces being accessed are unknown.
+	if !source.CheckAuthenticated(mockauth.PermissionQueryRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	queryReq, err := x.parseQueryRequest(req)
+	if err != nil {
+		return queryErrorResponse(400, queryErrCodeBadValue, err.Error(), "", start)
+	}
+
+	source.Node().Cluster().QueryRequests().Record(*queryReq)
+
+	if queryReq.Statement == "" {
+		return queryErrorResponse(400, queryErrCodeMissingValue, "No statement or prepared value",
+			queryReq.ClientContextID, start)
+	}
+
+	// Read-only requests are rejected before the provider ever sees them.
+	if queryReq.ReadOnly && queryIsMutation(queryReq.Statement) {
+		return queryErrorResponse(403, queryErrCodeReadOnly,
+			"The server or request is read-only and cannot accept this write statement.",
+			queryReq.ClientContextID, start)
+	}
+
+	txKind := queryTxStatement(queryReq.Statement)
+	if txKind != queryTxStatementNone && queryReq.TxImplicit {
+		return queryErrorResponse(400, queryErrCodeBadValue,
+			"Transaction statements cannot be used with tximplicit", queryReq.ClientContextID, start)
+	}
+
+	var rows []json.RawMessage
+	switch {
+	case txKind == queryTxStatementBegin:
+		if queryReq.TxID != "" {
+			return queryErrorResponse(400, queryErrCodeBadValue,
+				"Transaction is already started", queryReq.ClientContextID, start)
+		}
+		txResult, _ := json.Marshal(map[string]string{"txid": x.beginTransaction(queryReq.TxTimeout)})
+		rows = []json.RawMessage{txResult}
+	case txKind != queryTxStatementNone && queryReq.TxID == "":
+		return queryErrorResponse(400, queryErrCodeTransactionNotFound,
+			"Transaction context error: no transaction is active", queryReq.ClientContextID, start)
+	case queryReq.TxID != "":
+		if errResp := x.checkTransaction(queryReq, txKind != queryTxStatementNone, start); errResp != nil {
+			return errResp
+		}
+	}
+
+	if queryReq.ScanConsistency == "at_plus" {
+		if errResp := x.waitForScanVectors(source, queryReq, start); errResp != nil {
+			return errResp
+		}
+	}
+
+	if txKind == queryTxStatementNone {
+		if errResp := checkIndexesReady(source, queryReq, start); errResp != nil {
+			return errResp
+		}
+	}
+
+	provider := source.Node().Cluster().QueryResultProvider()
+	if provider != nil && txKind == queryTxStatementNone {
+		rows, err = provider.ExecuteQuery(queryReq)
+		if err != nil {
+			return queryErrorResponse(500, queryErrCodeInternal, err.Error(), queryReq.ClientContextID, start)
+		}
+	}
+	if rows == nil {
+		rows = []json.RawMessage{}
+	}
+
+	resultSize := 0
+	for _, row := range rows {
+		resultSize += len(row)
+	}
+
+	// The results are the only memory which we account for against the quota.
+	if queryReq.MemoryQuota > 0 && uint64(resultSize) > queryReq.MemoryQuota*1024*1024 {
+		return queryErrorResponse(500, queryErrCodeMemoryQuotaExceeded, "Request has exceeded memory quota",
+			queryReq.ClientContextID, start)
+	}
+
+	warnings := genQueryWarnings(source.Node().Cluster())
+
+	if hookProvider, ok := provider.(mock.QueryRowHookProvider); ok && txKind == queryTxStatementNone {
+		afterRows, callback := hookProvider.RowHook(queryReq)
+		if callback != nil && afterRows >= 0 && afterRows < len(rows) {
+			return x.streamQueryResponse(queryReq, rows, warnings, afterRows, callback, start)
+		}
+	}
+
+	elapsed := time.Since(start).String()
+	respBytes, _ := json.Marshal(jsonQueryResponse{
+		RequestID:       uuid.New().String(),
+		ClientContextID: queryReq.ClientContextID,
+		Signature:       map[string]string{"*": "*"},
+		Results:         rows,
+		Warnings:        warnings,
+		Status:          "success",
+		Metrics: jsonQueryMetrics{
+			ElapsedTime:   elapsed,
+			ExecutionTime: elapsed,
+			ResultCount:   len(rows),
+			ResultSize:    resultSize,
+			WarningCount:  len(warnings),
+		},
+	})
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(respBytes),
+	}
+}
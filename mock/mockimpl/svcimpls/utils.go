@@ -10,6 +10,8 @@ import (
 )
 
 func writePacketToSource(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	attachNotMyVbucketConfig(source, pak)
+
 	if source.HasFeature(memd.FeatureDurations) {
 		// TODO (chvck): revisit this, for some reason Windows reports a server duration of 0.
 		// Golang time accuracy in Windows is good enough that this shouldn't be the case and it seems pretty unlikely
@@ -27,3 +29,9 @@ func writePacketToSource(source mock.KvClient, pak *memd.Packet, start time.Time
 		log.Printf("failed to write packet %+v to %+v", pak, source)
 	}
 }
+
+// isActiveVbucket returns whether a node with the given vbucket ownership
+// holds the active copy of a vbucket.
+func isActiveVbucket(vbOwnership []int, vbID uint16) bool {
+	return int(vbID) < len(vbOwnership) && vbOwnership[vbID] == 0
+}
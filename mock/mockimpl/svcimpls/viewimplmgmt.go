@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"net/http"
 
 	"github.com/couchbaselabs/gocaves/contrib/pathparse"
 	"github.com/couchbaselabs/gocaves/mock"
@@ -140,9 +141,19 @@ func (x *viewImplMgmt) handleGetDesignDocument(source mock.ViewService, req *moc
 		}
 	}
 
+	// The server reports the revision of the design document in a header, the
+	// same revision as is reported by the ddocs listing.
+	metaBytes, _ := json.Marshal(jsonGetAllDesignDocsMeta{
+		ID:  "_design/" + ddoc.Name,
+		Rev: ddoc.Rev,
+	})
+
 	return &mock.HTTPResponse{
 		StatusCode: 200,
-		Body:       bytes.NewReader(b),
+		Header: http.Header{
+			"X-Couchbase-Meta": []string{string(metaBytes)},
+		},
+		Body: bytes.NewReader(b),
 	}
 }
 
@@ -0,0 +1,142 @@
+package mockimpl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServiceURL returns the base url of an http service of a node.
+func testServiceURL(hostname string, port int) string {
+	return "http://" + net.JoinHostPort(hostname, strconv.Itoa(port))
+}
+
+// doTestHTTP sends a request as the Administrator, with any form values as its
+// body, and returns the status code and body of the response.
+func doTestHTTP(t *testing.T, method, reqURL string, form url.Values) (int, []byte) {
+	req, err := http.NewRequest(method, reqURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.SetBasicAuth("Administrator", "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp.StatusCode, respBytes
+}
+
+func TestAnalyticsSettings(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+	analyticsURL := testServiceURL(node.AnalyticsService().Hostname(), node.AnalyticsService().ListenPort())
+
+	var settings struct {
+		NumReplicas int `json:"numReplicas"`
+	}
+
+	status, _ := doTestHTTP(t, "POST", mgmtURL+"/settings/analytics", url.Values{"numReplicas": {"2"}})
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 2, cluster.AnalyticsSettings().NumReplicas)
+
+	status, body := doTestHTTP(t, "GET", mgmtURL+"/settings/analytics", nil)
+	assert.Equal(t, 200, status)
+	if err := json.Unmarshal(body, &settings); err != nil {
+		t.Fatalf("failed to parse settings %s: %v", body, err)
+	}
+	assert.Equal(t, 2, settings.NumReplicas)
+
+	// The analytics service reports the same settings.
+	status, body = doTestHTTP(t, "GET", analyticsURL+"/analytics/config/service", nil)
+	assert.Equal(t, 200, status)
+	if err := json.Unmarshal(body, &settings); err != nil {
+		t.Fatalf("failed to parse settings %s: %v", body, err)
+	}
+	assert.Equal(t, 2, settings.NumReplicas)
+
+	// Invalid settings are rejected in the analytics error format, and leave
+	// the existing settings in place.
+	var errResp struct {
+		Errors []struct {
+			Code int `json:"code"`
+		} `json:"errors"`
+		Status string `json:"status"`
+	}
+	status, body = doTestHTTP(t, "POST", mgmtURL+"/settings/analytics", url.Values{"numReplicas": {"4"}})
+	assert.Equal(t, 400, status)
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		t.Fatalf("failed to parse error %s: %v", body, err)
+	}
+	assert.Equal(t, "fatal", errResp.Status)
+	if assert.Len(t, errResp.Errors, 1) {
+		assert.Equal(t, 21002, errResp.Errors[0].Code)
+	}
+	assert.Equal(t, 2, cluster.AnalyticsSettings().NumReplicas)
+}
+
+func TestAnalyticsClusterState(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	_, err = cluster.AddNode(mock.NewNodeOptions{
+		Services: []mock.ServiceType{mock.ServiceTypeMgmt, mock.ServiceTypeKeyValue},
+	})
+	if err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	analyticsURL := testServiceURL(node.AnalyticsService().Hostname(), node.AnalyticsService().ListenPort())
+
+	status, body := doTestHTTP(t, "GET", analyticsURL+"/analytics/cluster", nil)
+	assert.Equal(t, 200, status)
+
+	var state struct {
+		State    string `json:"state"`
+		CcNodeID string `json:"ccNodeId"`
+		Nodes    []struct {
+			NodeID string `json:"nodeId"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatalf("failed to parse cluster state %s: %v", body, err)
+	}
+
+	// Only the nodes which run the analytics service are listed.
+	var analyticsNodeIDs []string
+	for _, clusterNode := range cluster.Nodes() {
+		if clusterNode.AnalyticsService() != nil {
+			analyticsNodeIDs = append(analyticsNodeIDs, clusterNode.ID())
+		}
+	}
+	var stateNodeIDs []string
+	for _, stateNode := range state.Nodes {
+		stateNodeIDs = append(stateNodeIDs, stateNode.NodeID)
+	}
+	assert.Equal(t, "ACTIVE", state.State)
+	assert.Equal(t, analyticsNodeIDs, stateNodeIDs)
+	assert.Len(t, stateNodeIDs, len(cluster.Nodes())-1)
+	assert.Equal(t, analyticsNodeIDs[0], state.CcNodeID)
+}
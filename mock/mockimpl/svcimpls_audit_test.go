@@ -0,0 +1,135 @@
+package mockimpl
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// testAuditEventIDs returns the ids of the events in the audit log of a cluster.
+func testAuditEventIDs(cluster mock.Cluster) []mock.AuditEventID {
+	var ids []mock.AuditEventID
+	for _, event := range cluster.AuditLog().Events() {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestAuditSettings(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+
+	// Nothing is recorded until auditing is enabled.
+	status, _ := doTestHTTP(t, "DELETE", mgmtURL+"/pools/default/buckets/memd", nil)
+	assert.Equal(t, 200, status)
+	assert.Empty(t, cluster.AuditLog().Events())
+
+	status, _ = doTestHTTP(t, "POST", mgmtURL+"/settings/audit", url.Values{
+		"auditdEnabled":  {"true"},
+		"rotateInterval": {"3600"},
+		"disabled":       {"8204,20490"},
+	})
+	assert.Equal(t, 200, status)
+
+	var settings struct {
+		AuditdEnabled  bool  `json:"auditdEnabled"`
+		RotateInterval int   `json:"rotateInterval"`
+		Disabled       []int `json:"disabled"`
+	}
+	status, body := doTestHTTP(t, "GET", mgmtURL+"/settings/audit", nil)
+	assert.Equal(t, 200, status)
+	if err := json.Unmarshal(body, &settings); err != nil {
+		t.Fatalf("failed to parse settings %s: %v", body, err)
+	}
+	assert.True(t, settings.AuditdEnabled)
+	assert.Equal(t, 3600, settings.RotateInterval)
+	assert.Equal(t, []int{8204, 20490}, settings.Disabled)
+
+	// Enabling auditing is itself audited, as the user who sent the request.
+	events := cluster.AuditLog().Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, mock.AuditEventModifyAuditSettings, events[0].ID)
+		assert.Equal(t, "Administrator", events[0].User)
+		assert.Equal(t, "true", events[0].Fields["auditd_enabled"])
+	}
+
+	// Invalid settings are rejected, leaving the existing settings in place.
+	for _, form := range []url.Values{
+		{"auditdEnabled": {"yes"}},
+		{"rotateInterval": {"60"}},
+		{"rotateInterval": {"3630"}},
+		{"rotateSize": {"-1"}},
+		{"disabled": {"8201,bucket"}},
+		{"disabledUsers": {"Administrator"}},
+	} {
+		status, _ = doTestHTTP(t, "POST", mgmtURL+"/settings/audit", form)
+		assert.Equal(t, 400, status, form.Encode())
+	}
+	assert.Equal(t, time.Hour, cluster.AuditLog().Settings().RotateInterval)
+	assert.Len(t, cluster.AuditLog().Events(), 1)
+}
+
+func TestAuditEvents(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+
+	status, _ := doTestHTTP(t, "POST", mgmtURL+"/settings/audit", url.Values{"auditdEnabled": {"true"}})
+	assert.Equal(t, 200, status)
+	cluster.AuditLog().Reset()
+
+	status, _ = doTestHTTP(t, "DELETE", mgmtURL+"/pools/default/buckets/memd", nil)
+	assert.Equal(t, 200, status)
+
+	saslAuth := func(password string) {
+		conn := dialTestKv(t, node)
+		defer conn.Close()
+
+		conn.roundTrip(&memd.Packet{
+			Command: memd.CmdSASLAuth,
+			Key:     []byte("PLAIN"),
+			Value:   []byte("\x00Administrator\x00" + password),
+		})
+	}
+	saslAuth("password")
+	saslAuth("wrong")
+
+	events := cluster.AuditLog().Events()
+	assert.Equal(t, []mock.AuditEventID{
+		mock.AuditEventDeleteBucket,
+		mock.AuditEventKvAuthSucceeded,
+		mock.AuditEventKvAuthFailed,
+	}, testAuditEventIDs(cluster))
+	for _, event := range events {
+		assert.Equal(t, "Administrator", event.User)
+	}
+	if len(events) == 3 {
+		assert.Equal(t, "memd", events[0].Fields["bucket_name"])
+		assert.Equal(t, "PLAIN", events[2].Fields["mechanism"])
+		assert.NotEmpty(t, events[2].Remote)
+	}
+
+	// Events from users which have been filtered out are not recorded.
+	status, _ = doTestHTTP(t, "POST", mgmtURL+"/settings/audit", url.Values{
+		"disabledUsers": {"Administrator/local"},
+	})
+	assert.Equal(t, 200, status)
+	cluster.AuditLog().Reset()
+
+	saslAuth("password")
+	status, _ = doTestHTTP(t, "DELETE", mgmtURL+"/pools/default/buckets/default", nil)
+	assert.Equal(t, 200, status)
+	assert.Empty(t, cluster.AuditLog().Events())
+}
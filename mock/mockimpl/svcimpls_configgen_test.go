@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/couchbaselabs/gocaves/mock"
@@ -189,3 +190,491 @@ func TestBucketTerseConfig70(t *testing.T) {
 
 	testCompareLayout(t, actualConfig, testConfig)
 }
+
+func TestBucketTerseConfigScenarios(t *testing.T) {
+	nodeOpts := mock.NewNodeOptions{
+		Services: []mock.ServiceType{
+			mock.ServiceTypeMgmt,
+			mock.ServiceTypeKeyValue,
+		},
+	}
+
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+		InitialNode: nodeOpts,
+	})
+	_, _ = cluster.AddNode(nodeOpts)
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	type vbServerMap struct {
+		NumReplicas int      `json:"numReplicas"`
+		ServerList  []string `json:"serverList"`
+		VBucketMap  [][]int  `json:"vBucketMap"`
+	}
+	type terseConfig struct {
+		NodesExt []struct {
+			Hostname string         `json:"hostname"`
+			Services map[string]int `json:"services"`
+		} `json:"nodesExt"`
+		VBucketServerMap vbServerMap `json:"vBucketServerMap"`
+	}
+
+	genConfig := func(scenario mock.ConfigScenario) terseConfig {
+		cluster.SetConfigScenario(scenario)
+
+		var config terseConfig
+		if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, nil), &config); err != nil {
+			t.Fatalf("failed to unmarshal configuration: %s", err)
+		}
+		return config
+	}
+
+	normalConfig := genConfig(mock.ConfigScenarioNone)
+
+	reorderedConfig := genConfig(mock.ConfigScenarioReorderedServerList)
+	serverMap := reorderedConfig.VBucketServerMap
+	normalServerMap := normalConfig.VBucketServerMap
+	if len(serverMap.ServerList) != 2 || serverMap.ServerList[0] != normalServerMap.ServerList[1] {
+		t.Fatalf("server list was not reordered: %v", serverMap.ServerList)
+	}
+	for vbIdx, repMap := range serverMap.VBucketMap {
+		for repIdx, nodeIdx := range repMap {
+			normalNodeIdx := normalServerMap.VBucketMap[vbIdx][repIdx]
+			if serverMap.ServerList[nodeIdx] != normalServerMap.ServerList[normalNodeIdx] {
+				t.Fatalf("vbucket %d replica %d was not remapped", vbIdx, repIdx)
+			}
+		}
+	}
+
+	zeroReplicasConfig := genConfig(mock.ConfigScenarioZeroReplicas)
+	if zeroReplicasConfig.VBucketServerMap.NumReplicas != 0 {
+		t.Fatalf("expected zero replicas")
+	}
+	for vbIdx, repMap := range zeroReplicasConfig.VBucketServerMap.VBucketMap {
+		if len(repMap) != 1 {
+			t.Fatalf("vbucket %d had replicas in its map", vbIdx)
+		}
+	}
+
+	missingKvConfig := genConfig(mock.ConfigScenarioMissingKvPort)
+	lastNode := missingKvConfig.NodesExt[len(missingKvConfig.NodesExt)-1]
+	if _, ok := lastNode.Services["kv"]; ok || lastNode.Hostname == "" {
+		t.Fatalf("last node should have a hostname but no kv port: %+v", lastNode)
+	}
+	if _, ok := missingKvConfig.NodesExt[0].Services["kv"]; !ok {
+		t.Fatalf("first node should still have a kv port")
+	}
+
+	ipv6Config := genConfig(mock.ConfigScenarioIPv6Hostnames)
+	for _, node := range ipv6Config.NodesExt {
+		if node.Hostname != "::1" {
+			t.Fatalf("expected ipv6 hostname, got %s", node.Hostname)
+		}
+	}
+}
+
+func TestBucketTerseConfigKvlessNode(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+		InitialNode: mock.NewNodeOptions{
+			Services: []mock.ServiceType{
+				mock.ServiceTypeMgmt,
+				mock.ServiceTypeKeyValue,
+			},
+		},
+	})
+	queryNode, err := cluster.AddNode(mock.NewNodeOptions{
+		Services: []mock.ServiceType{
+			mock.ServiceTypeMgmt,
+			mock.ServiceTypeQuery,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to add query node: %s", err)
+	}
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	var config struct {
+		Nodes    []interface{} `json:"nodes"`
+		NodesExt []struct {
+			ThisNode bool           `json:"thisNode"`
+			Services map[string]int `json:"services"`
+		} `json:"nodesExt"`
+		VBucketServerMap struct {
+			ServerList []string `json:"serverList"`
+			VBucketMap [][]int  `json:"vBucketMap"`
+		} `json:"vBucketServerMap"`
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, queryNode), &config); err != nil {
+		t.Fatalf("failed to unmarshal configuration: %s", err)
+	}
+
+	if len(config.Nodes) != 1 || len(config.NodesExt) != 2 {
+		t.Fatalf("expected only the kv node in nodes but both in nodesExt")
+	}
+	if len(config.VBucketServerMap.ServerList) != 1 {
+		t.Fatalf("expected only the kv node in the server list: %v", config.VBucketServerMap.ServerList)
+	}
+	for vbIdx, repMap := range config.VBucketServerMap.VBucketMap {
+		if repMap[0] != 0 || repMap[1] != -1 {
+			t.Fatalf("vbucket %d was mapped to a node without kv: %v", vbIdx, repMap)
+		}
+	}
+
+	queryNodeExt := config.NodesExt[1]
+	if _, ok := queryNodeExt.Services["kv"]; ok || !queryNodeExt.ThisNode {
+		t.Fatalf("query node should be this node and have no kv port: %+v", queryNodeExt)
+	}
+	if _, ok := queryNodeExt.Services["mgmt"]; !ok {
+		t.Fatalf("query node should have a mgmt port")
+	}
+
+	_, err = cluster.AddNode(mock.NewNodeOptions{
+		Services: []mock.ServiceType{
+			mock.ServiceTypeQuery,
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected a node without mgmt to be rejected")
+	}
+}
+
+func TestBucketTerseConfigEvacuatedNode(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+	})
+	evacNode, _ := cluster.AddNode(mock.NewNodeOptions{})
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	if err := bucket.EvacuateNode(evacNode.ID()); err != nil {
+		t.Fatalf("failed to evacuate node: %s", err)
+	}
+
+	for vbIdx, repIdx := range bucket.VbucketOwnership(evacNode) {
+		if repIdx != -1 {
+			t.Fatalf("evacuated node still holds copy %d of vbucket %d", repIdx, vbIdx)
+		}
+	}
+
+	var config struct {
+		VBucketServerMap struct {
+			ServerList []string `json:"serverList"`
+			VBucketMap [][]int  `json:"vBucketMap"`
+		} `json:"vBucketServerMap"`
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, evacNode), &config); err != nil {
+		t.Fatalf("failed to unmarshal configuration: %s", err)
+	}
+
+	// The bucket is still open on the node, so it must remain in the server
+	// list even though no vbucket references it.
+	if len(config.VBucketServerMap.ServerList) != 2 {
+		t.Fatalf("expected both nodes in the server list: %v", config.VBucketServerMap.ServerList)
+	}
+	for vbIdx, repMap := range config.VBucketServerMap.VBucketMap {
+		if repMap[0] != 0 || repMap[1] != -1 {
+			t.Fatalf("vbucket %d was not moved to the remaining node: %v", vbIdx, repMap)
+		}
+	}
+
+	if err := cluster.Rebalance(); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+	if !cluster.IsBalanced() {
+		t.Fatalf("expected the cluster to be balanced after rebalancing")
+	}
+}
+
+func TestClusterConfigBalance(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+	})
+	failNode, _ := cluster.AddNode(mock.NewNodeOptions{})
+	_, _ = cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	type jsonConfig struct {
+		Balanced        bool   `json:"balanced"`
+		RebalanceStatus string `json:"rebalanceStatus"`
+		Nodes           []struct {
+			NodeUUID          string `json:"nodeUUID"`
+			ClusterMembership string `json:"clusterMembership"`
+		} `json:"nodes"`
+	}
+	genConfig := func() jsonConfig {
+		var config jsonConfig
+		if err := json.Unmarshal(svcimpls.GenClusterConfig(cluster, nil), &config); err != nil {
+			t.Fatalf("failed to unmarshal configuration: %s", err)
+		}
+		return config
+	}
+	checkConfig := func(balanced bool, rebalanceStatus string, failedNodeID string) {
+		config := genConfig()
+		if config.Balanced != balanced || config.RebalanceStatus != rebalanceStatus {
+			t.Fatalf("expected balanced %t with status %s, got %t with %s",
+				balanced, rebalanceStatus, config.Balanced, config.RebalanceStatus)
+		}
+		for _, node := range config.Nodes {
+			expectedMembership := "active"
+			if node.NodeUUID == failedNodeID {
+				expectedMembership = "inactiveFailed"
+			}
+			if node.ClusterMembership != expectedMembership {
+				t.Fatalf("expected node %s to be %s, got %s", node.NodeUUID, expectedMembership, node.ClusterMembership)
+			}
+		}
+	}
+
+	checkConfig(true, "none", "")
+
+	if err := cluster.FailoverNode(failNode.ID()); err != nil {
+		t.Fatalf("failed to fail over node: %s", err)
+	}
+	checkConfig(false, "none", failNode.ID())
+
+	if err := cluster.Rebalance(); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+	checkConfig(true, "none", "")
+
+	// Nodes which are added do not own any vbuckets until a rebalance.
+	_, _ = cluster.AddNode(mock.NewNodeOptions{})
+	checkConfig(false, "none", "")
+
+	if err := cluster.Rebalance(); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+	checkConfig(true, "none", "")
+
+	cluster.SetGracefulFailoverSteps(2)
+	if err := cluster.StartGracefulFailover(failNode.ID()); err != nil {
+		t.Fatalf("failed to start graceful failover: %s", err)
+	}
+	checkConfig(false, "running", "")
+
+	_ = cluster.StepGracefulFailover()
+	_ = cluster.StepGracefulFailover()
+	checkConfig(false, "none", failNode.ID())
+}
+
+func TestBucketTerseConfigIPv6Hostnames(t *testing.T) {
+	cluster, err := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+		InitialNode: mock.NewNodeOptions{
+			Hostname: "[::1]",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+	zonedNode, err := cluster.AddNode(mock.NewNodeOptions{
+		Hostname: "fe80::1%eth0",
+	})
+	if err != nil {
+		t.Fatalf("failed to add node: %s", err)
+	}
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	var config struct {
+		Nodes []struct {
+			CouchAPIBase string `json:"couchApiBase"`
+			Hostname     string `json:"hostname"`
+		} `json:"nodes"`
+		VBucketServerMap struct {
+			ServerList []string `json:"serverList"`
+		} `json:"vBucketServerMap"`
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, zonedNode), &config); err != nil {
+		t.Fatalf("failed to unmarshal configuration: %s", err)
+	}
+
+	nodes := cluster.Nodes()
+	if len(config.Nodes) != 2 {
+		t.Fatalf("expected both nodes in the config, got %d", len(config.Nodes))
+	}
+	for nodeIdx, node := range config.Nodes {
+		expectedHost := []string{"[::1]", "[fe80::1%eth0]"}[nodeIdx]
+		expectedURLHost := []string{"[::1]", "[fe80::1%25eth0]"}[nodeIdx]
+
+		expectedHostname := fmt.Sprintf("%s:%d", expectedHost, nodes[nodeIdx].MgmtService().ListenPort())
+		if node.Hostname != expectedHostname {
+			t.Fatalf("expected hostname %s, got %s", expectedHostname, node.Hostname)
+		}
+
+		expectedServer := fmt.Sprintf("%s:%d", expectedHost, nodes[nodeIdx].KvService().ListenPort())
+		if config.VBucketServerMap.ServerList[nodeIdx] != expectedServer {
+			t.Fatalf("expected server %s, got %s", expectedServer, config.VBucketServerMap.ServerList[nodeIdx])
+		}
+
+		expectedCouchAPIBase := fmt.Sprintf("http://%s:%d/default%%2B%s",
+			expectedURLHost, nodes[nodeIdx].ViewService().ListenPort(), bucket.ID())
+		if node.CouchAPIBase != expectedCouchAPIBase {
+			t.Fatalf("expected couchApiBase %s, got %s", expectedCouchAPIBase, node.CouchAPIBase)
+		}
+	}
+
+	for _, hostname := range []string{"[::1]:8091", "::1]", "example.com:8091", "fe80::1%"} {
+		if err := zonedNode.ChangeAddress(hostname); err == nil {
+			t.Fatalf("expected hostname %s to be rejected", hostname)
+		}
+	}
+}
+
+func TestClusterConfigMemoryQuotas(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 64,
+	})
+
+	cluster.SetMemoryQuotas(mock.MemoryQuotas{
+		Data:      2048,
+		Index:     512,
+		Search:    256,
+		Analytics: 1024,
+		Eventing:  256,
+	})
+	cluster.SetNodeMemoryTotal(8 * 1024 * 1024 * 1024)
+
+	var config struct {
+		MemoryQuota         uint64 `json:"memoryQuota"`
+		IndexMemoryQuota    uint64 `json:"indexMemoryQuota"`
+		FtsMemoryQuota      uint64 `json:"ftsMemoryQuota"`
+		CbasMemoryQuota     uint64 `json:"cbasMemoryQuota"`
+		EventingMemoryQuota uint64 `json:"eventingMemoryQuota"`
+		Nodes               []struct {
+			MemoryTotal uint64 `json:"memoryTotal"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(svcimpls.GenClusterConfig(cluster, nil), &config); err != nil {
+		t.Fatalf("failed to unmarshal configuration: %s", err)
+	}
+
+	if config.MemoryQuota != 2048 || config.IndexMemoryQuota != 512 || config.FtsMemoryQuota != 256 ||
+		config.CbasMemoryQuota != 1024 || config.EventingMemoryQuota != 256 {
+		t.Fatalf("memory quotas were not reflected in the config: %+v", config)
+	}
+	for _, node := range config.Nodes {
+		if node.MemoryTotal != 8*1024*1024*1024 {
+			t.Fatalf("expected node memory total of 8GB, got %d", node.MemoryTotal)
+		}
+	}
+}
+
+func TestConfigUUIDs(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{})
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name: "default",
+		Type: mock.BucketTypeCouchbase,
+	})
+
+	var poolsConfig struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(svcimpls.GenPoolsConfig(cluster), &poolsConfig); err != nil {
+		t.Fatalf("failed to unmarshal pools configuration: %s", err)
+	}
+
+	var bucketConfig struct {
+		UUID  string `json:"uuid"`
+		Nodes []struct {
+			NodeUUID string `json:"nodeUUID"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(svcimpls.GenBucketConfig(bucket, nil), &bucketConfig); err != nil {
+		t.Fatalf("failed to unmarshal bucket configuration: %s", err)
+	}
+	var terseConfig struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, nil), &terseConfig); err != nil {
+		t.Fatalf("failed to unmarshal terse bucket configuration: %s", err)
+	}
+
+	// Every uuid is rendered as 32 hex digits, without any dashes.
+	uuids := []string{poolsConfig.UUID, bucketConfig.UUID, terseConfig.UUID}
+	for _, node := range bucketConfig.Nodes {
+		uuids = append(uuids, node.NodeUUID)
+	}
+	uuidRegexp := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	for _, uuid := range uuids {
+		if !uuidRegexp.MatchString(uuid) {
+			t.Fatalf("expected uuid to be 32 hex digits, got %q", uuid)
+		}
+	}
+}
+
+func TestServerVersionForVersion(t *testing.T) {
+	testCases := []struct {
+		version       mock.ClusterVersion
+		serverVersion string
+		compatibility int
+	}{
+		{mock.ClusterVersion65, "6.5.1-6299-enterprise", 0x60005},
+		{mock.ClusterVersion70, "7.0.0-5302-enterprise", 0x70000},
+		{mock.ClusterVersion76, "7.6.0-2176-enterprise", 0x70006},
+	}
+
+	for _, tc := range testCases {
+		cluster, err := NewCluster(mock.NewClusterOptions{Version: tc.version})
+		if err != nil {
+			t.Fatalf("failed to create cluster: %s", err)
+		}
+
+		var poolsConfig struct {
+			ImplementationVersion string `json:"implementationVersion"`
+		}
+		if err := json.Unmarshal(svcimpls.GenPoolsConfig(cluster), &poolsConfig); err != nil {
+			t.Fatalf("failed to unmarshal pools configuration: %s", err)
+		}
+
+		var terseConfig struct {
+			ProdVersion string `json:"prodVersion"`
+		}
+		if err := json.Unmarshal(svcimpls.GenTerseClusterConfig(cluster, nil), &terseConfig); err != nil {
+			t.Fatalf("failed to unmarshal terse cluster configuration: %s", err)
+		}
+
+		var clusterConfig struct {
+			Nodes []struct {
+				Version              string `json:"version"`
+				ClusterCompatibility int    `json:"clusterCompatibility"`
+			} `json:"nodes"`
+		}
+		if err := json.Unmarshal(svcimpls.GenClusterConfig(cluster, nil), &clusterConfig); err != nil {
+			t.Fatalf("failed to unmarshal cluster configuration: %s", err)
+		}
+
+		if poolsConfig.ImplementationVersion != tc.serverVersion || terseConfig.ProdVersion != tc.serverVersion {
+			t.Fatalf("expected version %s for %s, got %s and %s", tc.serverVersion, tc.version,
+				poolsConfig.ImplementationVersion, terseConfig.ProdVersion)
+		}
+		if len(clusterConfig.Nodes) == 0 {
+			t.Fatalf("expected nodes in the cluster configuration")
+		}
+		for _, node := range clusterConfig.Nodes {
+			if node.Version != tc.serverVersion || node.ClusterCompatibility != tc.compatibility {
+				t.Fatalf("unexpected node version for %s: %+v", tc.version, node)
+			}
+		}
+	}
+}
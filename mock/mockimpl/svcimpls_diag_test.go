@@ -0,0 +1,129 @@
+package mockimpl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/stretchr/testify/assert"
+)
+
+// doTestDiagEval sends a directive to the /diag/eval endpoint of a node,
+// optionally as the Administrator, and returns the status code and body of
+// the response.
+func doTestDiagEval(t *testing.T, node mock.ClusterNode, directive string, withCreds bool) (int, string) {
+	mgmtURL := testServiceURL(node.MgmtService().Hostname(), node.MgmtService().ListenPort())
+	req, err := http.NewRequest("POST", mgmtURL+"/diag/eval", strings.NewReader(directive))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if withCreds {
+		req.SetBasicAuth("Administrator", "password")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp.StatusCode, string(respBytes)
+}
+
+func TestDiagEval(t *testing.T) {
+	cluster, err := NewCluster(mock.NewClusterOptions{
+		InitialNode: mock.NewNodeOptions{
+			Features: []mock.ClusterNodeFeature{mock.ClusterNodeFeatureDiagEval},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	disabledNode, err := cluster.AddNode(mock.NewNodeOptions{
+		Features: []mock.ClusterNodeFeature{mock.ClusterNodeFeatureTLS},
+	})
+	if err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	bucket, err := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to add bucket: %v", err)
+	}
+	err = cluster.Users().UpsertUser(mockauth.UpsertUserOptions{
+		Username: "Administrator",
+		Password: "password",
+		Roles:    []string{"admin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	// Directives are refused entirely by nodes which have not enabled them.
+	status, _ := doTestDiagEval(t, disabledNode, "rebalance", true)
+	assert.Equal(t, 403, status)
+
+	status, _ = doTestDiagEval(t, node, "rebalance", false)
+	assert.Equal(t, 401, status)
+
+	status, body := doTestDiagEval(t, node, "explode", true)
+	assert.Equal(t, 400, status)
+	assert.Contains(t, body, "unknown directive")
+
+	for _, directive := range []string{"failover", "setpersistedseqno default 0", "injecterror 0x00"} {
+		status, body = doTestDiagEval(t, node, directive, true)
+		assert.Equal(t, 400, status, directive)
+		assert.Contains(t, body, "arguments", directive)
+	}
+
+	t.Run("setpersistedseqno", func(t *testing.T) {
+		doc, err := bucket.Store().Insert(&mockdb.Document{
+			VbID:  0,
+			Key:   []byte("persisted"),
+			Value: []byte(`{"x":1}`),
+			Cas:   mockdb.GenerateNewCas(bucket.Store().Chrono().Now()),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+		vbucket := bucket.Store().GetVbucket(0)
+		assert.Less(t, vbucket.CurrentMetaState(1).PersistSeqNo, doc.SeqNo)
+
+		status, _ := doTestDiagEval(t, node, "setpersistedseqno default 0 "+strconv.FormatUint(doc.SeqNo, 10), true)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, doc.SeqNo, vbucket.CurrentMetaState(1).PersistSeqNo)
+
+		// Seqnos which have not been reached yet cannot be persisted.
+		status, _ = doTestDiagEval(t, node, "setpersistedseqno default 0 "+strconv.FormatUint(doc.SeqNo+1, 10), true)
+		assert.Equal(t, 400, status)
+	})
+
+	t.Run("injecterror", func(t *testing.T) {
+		conn := dialTestKv(t, node)
+		defer conn.Close()
+
+		status, _ := doTestDiagEval(t, node, "injecterror 0x0a 0x86", true)
+		assert.Equal(t, 200, status)
+		resp := conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+		assert.Equal(t, memd.StatusTmpFail, resp.Status)
+
+		status, _ = doTestDiagEval(t, node, "injecterror 0x0a 0", true)
+		assert.Equal(t, 200, status)
+		resp = conn.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+		assert.Equal(t, memd.StatusSuccess, resp.Status)
+	})
+}
@@ -0,0 +1,77 @@
+package mockimpl
+
+import (
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/svcimpls"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelloFeaturesForVersion(t *testing.T) {
+	features50 := []memd.HelloFeature{
+		memd.FeatureDatatype,
+		memd.FeatureTCPNoDelay,
+		memd.FeatureSeqNo,
+		memd.FeatureTCPDelay,
+		memd.FeatureXattr,
+		memd.FeatureXerror,
+		memd.FeatureSelectBucket,
+	}
+	features65 := append(features50[:len(features50):len(features50)],
+		memd.FeatureSnappy,
+		memd.FeatureJSON,
+		memd.FeatureDuplex,
+		memd.FeatureUnorderedExec,
+		memd.FeatureDurations,
+		memd.FeatureAltRequests,
+		memd.FeatureSyncReplication,
+	)
+	features66 := append(features65[:len(features65):len(features65)],
+		memd.FeatureCreateAsDeleted,
+	)
+	features70 := append(features66[:len(features66):len(features66)],
+		memd.FeatureCollections,
+		memd.HelloFeature(0x16),
+	)
+	features72 := append(features70[:len(features70):len(features70)],
+		memd.HelloFeature(0x1b),
+	)
+	features76 := append(features72[:len(features72):len(features72)],
+		memd.HelloFeature(0x1e),
+	)
+
+	expectedFeatures := map[mock.ClusterVersion][]memd.HelloFeature{
+		mock.ClusterVersion50: features50,
+		mock.ClusterVersion65: features65,
+		mock.ClusterVersion66: features66,
+		mock.ClusterVersion70: features70,
+		mock.ClusterVersion71: features70,
+		mock.ClusterVersion72: features72,
+		mock.ClusterVersion76: features76,
+	}
+	for _, version := range mock.ClusterVersions {
+		assert.ElementsMatch(t, expectedFeatures[version], svcimpls.HelloFeaturesForVersion(version), "version %s", version)
+	}
+}
+
+func TestClusterVersion(t *testing.T) {
+	cluster, err := NewCluster(mock.NewClusterOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, mock.LatestClusterVersion, cluster.Version())
+
+	cluster, err = NewCluster(mock.NewClusterOptions{Version: mock.ClusterVersion72})
+	assert.NoError(t, err)
+	assert.Equal(t, mock.ClusterVersion72, cluster.Version())
+
+	_, err = NewCluster(mock.NewClusterOptions{Version: "4.0"})
+	assert.Error(t, err)
+}
+
+func TestSASLMechanismsForVersion(t *testing.T) {
+	assert.Equal(t, []string{"PLAIN", "SCRAM-SHA1"},
+		svcimpls.SASLMechanismsForVersion(mock.ClusterVersion50))
+	assert.Equal(t, []string{"PLAIN", "SCRAM-SHA1", "SCRAM-SHA256", "SCRAM-SHA512"},
+		svcimpls.SASLMechanismsForVersion(mock.LatestClusterVersion))
+}
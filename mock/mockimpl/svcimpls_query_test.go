@@ -0,0 +1,321 @@
+package mockimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// testQueryProvider returns a fixed set of rows, or an error, for every query.
+type testQueryProvider struct {
+	rows []json.RawMessage
+	err  error
+
+	// afterRows and callback are returned as the row hook of every request.
+	afterRows int
+	callback  func()
+}
+
+func (p *testQueryProvider) ExecuteQuery(req *mock.QueryRequest) ([]json.RawMessage, error) {
+	return p.rows, p.err
+}
+
+func (p *testQueryProvider) RowHook(req *mock.QueryRequest) (int, func()) {
+	return p.afterRows, p.callback
+}
+
+type testQueryResponse struct {
+	ClientContextID string            `json:"clientContextID"`
+	Results         []json.RawMessage `json:"results"`
+	Errors          []jsonTestError   `json:"errors"`
+	Warnings        []jsonTestError   `json:"warnings"`
+	Status          string            `json:"status"`
+	Metrics         struct {
+		ResultCount  int `json:"resultCount"`
+		ErrorCount   int `json:"errorCount"`
+		WarningCount int `json:"warningCount"`
+	} `json:"metrics"`
+}
+
+type jsonTestError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// doTestQuery sends a query request to the query service of a cluster, with
+// its parameters either JSON or form encoded.
+func doTestQuery(t *testing.T, cluster mock.Cluster, body, contentType string) (int, *testQueryResponse) {
+	querySvc := cluster.Nodes()[0].QueryService()
+	reqURL := "http://" + net.JoinHostPort(querySvc.Hostname(), strconv.Itoa(querySvc.ListenPort())) + "/query/service"
+
+	req, err := http.NewRequest("POST", reqURL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.SetBasicAuth("Administrator", "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read query response: %v", err)
+	}
+
+	var queryResp testQueryResponse
+	if err := json.Unmarshal(respBytes, &queryResp); err != nil {
+		t.Fatalf("failed to parse query response %s: %v", respBytes, err)
+	}
+	return resp.StatusCode, &queryResp
+}
+
+// doTestJSONQuery sends a query request whose parameters are JSON encoded.
+func doTestJSONQuery(t *testing.T, cluster mock.Cluster, params map[string]interface{}) (int, *testQueryResponse) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("failed to encode query: %v", err)
+	}
+	return doTestQuery(t, cluster, string(body), "application/json")
+}
+
+func TestQueryStatement(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	cluster.SetQueryResultProvider(&testQueryProvider{
+		rows: []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`{"a":2}`)},
+	})
+	cluster.Warnings().SetQueryWarnings([]mock.QueryWarning{{Code: 1080, Msg: "slow"}})
+
+	status, resp := doTestJSONQuery(t, cluster, map[string]interface{}{
+		"statement":         "SELECT * FROM default WHERE a = $1 OR b = $b",
+		"client_context_id": "ctx",
+		"args":              []interface{}{1, "x"},
+		"$b":                true,
+		"max_parallelism":   "4",
+		"controls":          true,
+	})
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "success", resp.Status)
+	assert.Equal(t, "ctx", resp.ClientContextID)
+	assert.Len(t, resp.Results, 2)
+	assert.Equal(t, 2, resp.Metrics.ResultCount)
+	assert.Equal(t, []jsonTestError{{Code: 1080, Msg: "slow"}}, resp.Warnings)
+	assert.Equal(t, 1, resp.Metrics.WarningCount)
+
+	// Form encoded requests bind the same parameters.
+	form := url.Values{}
+	form.Set("statement", "SELECT $1, $c")
+	form.Set("args", `[2]`)
+	form.Set("$c", `"y"`)
+	form.Set("readonly", "true")
+	status, _ = doTestQuery(t, cluster, form.Encode(), "application/x-www-form-urlencoded")
+	assert.Equal(t, 200, status)
+
+	requests := cluster.QueryRequests().Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", len(requests))
+	}
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`"x"`)}, requests[0].PositionalArgs)
+	assert.Equal(t, map[string]json.RawMessage{"b": json.RawMessage(`true`)}, requests[0].NamedArgs)
+	assert.Equal(t, 4, requests[0].MaxParallelism)
+	assert.True(t, requests[0].Controls)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`2`)}, requests[1].PositionalArgs)
+	assert.Equal(t, map[string]json.RawMessage{"c": json.RawMessage(`"y"`)}, requests[1].NamedArgs)
+	assert.True(t, requests[1].ReadOnly)
+}
+
+func TestQueryErrors(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	err = cluster.QueryIndexes().SetIndexState("default", "idx", mock.QueryIndexStateBuilding)
+	if err != nil {
+		t.Fatalf("failed to register index: %v", err)
+	}
+
+	bigRow, _ := json.Marshal(strings.Repeat("x", 1024*1024))
+	provider := &testQueryProvider{}
+	cluster.SetQueryResultProvider(provider)
+
+	testCases := []struct {
+		name       string
+		params     map[string]interface{}
+		rows       []json.RawMessage
+		err        error
+		statusCode int
+		errCode    int
+	}{
+		{
+			name:       "MissingStatement",
+			params:     map[string]interface{}{},
+			statusCode: 400,
+			errCode:    1050,
+		},
+		{
+			name:       "ReadOnlyMutation",
+			params:     map[string]interface{}{"statement": "INSERT INTO default VALUES ('a', {})", "readonly": true},
+			statusCode: 403,
+			errCode:    1000,
+		},
+		{
+			name:       "ProviderError",
+			params:     map[string]interface{}{"statement": "SELECT 1"},
+			err:        errors.New("failed"),
+			statusCode: 500,
+			errCode:    5000,
+		},
+		{
+			name:       "InvalidControls",
+			params:     map[string]interface{}{"statement": "SELECT 1", "controls": "maybe"},
+			statusCode: 400,
+			errCode:    1040,
+		},
+		{
+			name:       "ScanVectorsWithoutAtPlus",
+			params:     map[string]interface{}{"statement": "SELECT 1", "scan_vectors": map[string]interface{}{}},
+			statusCode: 400,
+			errCode:    1040,
+		},
+		{
+			name: "ScanWaitExceeded",
+			params: map[string]interface{}{
+				"statement":        "SELECT 1",
+				"scan_consistency": "at_plus",
+				"scan_vectors":     map[string]interface{}{"default": map[string]interface{}{"0": []interface{}{100, "1"}}},
+				"scan_wait":        "10ms",
+			},
+			statusCode: 500,
+			errCode:    12015,
+		},
+		{
+			name:       "IndexBuilding",
+			params:     map[string]interface{}{"statement": "SELECT * FROM default USE INDEX (idx)"},
+			statusCode: 503,
+			errCode:    12016,
+		},
+		{
+			name:       "MemoryQuotaExceeded",
+			params:     map[string]interface{}{"statement": "SELECT 1", "memory_quota": 1},
+			rows:       []json.RawMessage{bigRow},
+			statusCode: 500,
+			errCode:    5500,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			provider.rows = testCase.rows
+			provider.err = testCase.err
+
+			status, resp := doTestJSONQuery(t, cluster, testCase.params)
+			assert.Equal(t, testCase.statusCode, status)
+			assert.Equal(t, "fatal", resp.Status)
+			if assert.Len(t, resp.Errors, 1) {
+				assert.Equal(t, testCase.errCode, resp.Errors[0].Code)
+			}
+			assert.Equal(t, 1, resp.Metrics.ErrorCount)
+		})
+	}
+}
+
+func TestQueryTransactions(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+
+	status, resp := doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "BEGIN TRANSACTION"})
+	assert.Equal(t, 200, status)
+	if len(resp.Results) != 1 {
+		t.Fatalf("expected the transaction id, got %d rows", len(resp.Results))
+	}
+	var txResult struct {
+		TxID string `json:"txid"`
+	}
+	if err := json.Unmarshal(resp.Results[0], &txResult); err != nil || txResult.TxID == "" {
+		t.Fatalf("failed to read the transaction id: %v", err)
+	}
+
+	status, _ = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "SELECT 1", "txid": txResult.TxID})
+	assert.Equal(t, 200, status)
+	status, _ = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "COMMIT", "txid": txResult.TxID})
+	assert.Equal(t, 200, status)
+
+	// The transaction no longer exists once it has been committed.
+	status, resp = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "SELECT 1", "txid": txResult.TxID})
+	assert.Equal(t, 404, status)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, 17004, resp.Errors[0].Code)
+	}
+
+	status, resp = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "ROLLBACK"})
+	assert.Equal(t, 400, status)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, 17004, resp.Errors[0].Code)
+	}
+
+	status, _ = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "BEGIN WORK", "tximplicit": true})
+	assert.Equal(t, 400, status)
+	status, _ = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "SELECT 1", "tximplicit": true})
+	assert.Equal(t, 200, status)
+}
+
+func TestQueryStreamedRows(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+
+	var rows []json.RawMessage
+	for rowIdx := 0; rowIdx < 5; rowIdx++ {
+		rows = append(rows, json.RawMessage(strconv.Itoa(rowIdx)))
+	}
+
+	// The hook is invoked by the goroutine which streams the response.
+	var hookCalls int32
+	cluster.SetQueryResultProvider(&testQueryProvider{
+		rows:      rows,
+		afterRows: 2,
+		callback: func() {
+			atomic.AddInt32(&hookCalls, 1)
+		},
+	})
+
+	// The rows are split around the hook, but arrive as a single response.
+	status, resp := doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "SELECT 1"})
+	assert.Equal(t, 200, status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hookCalls))
+	assert.Equal(t, "success", resp.Status)
+	assert.Equal(t, rows, resp.Results)
+	assert.Equal(t, 5, resp.Metrics.ResultCount)
+
+	// A hook which would come after the last row is never invoked.
+	cluster.SetQueryResultProvider(&testQueryProvider{
+		rows:      rows,
+		afterRows: 5,
+		callback: func() {
+			atomic.AddInt32(&hookCalls, 1)
+		},
+	})
+	status, resp = doTestJSONQuery(t, cluster, map[string]interface{}{"statement": "SELECT 1"})
+	assert.Equal(t, 200, status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hookCalls))
+	assert.Equal(t, rows, resp.Results)
+}
@@ -0,0 +1,67 @@
+package mockimpl
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/svcimpls"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerGroups(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	nodes := cluster.Nodes()
+	mgmtURL := testServiceURL(nodes[0].MgmtService().Hostname(), nodes[0].MgmtService().ListenPort())
+
+	assert.Error(t, cluster.SetNodeServerGroup(nodes[1].ID(), ""))
+	assert.Error(t, cluster.SetNodeServerGroup("missing", "Group 2"))
+	if err := cluster.SetNodeServerGroup(nodes[1].ID(), "Group 2"); err != nil {
+		t.Fatalf("failed to set server group: %v", err)
+	}
+	assert.Equal(t, "Group 2", nodes[1].ServerGroup())
+
+	var groups struct {
+		Groups []struct {
+			Name  string `json:"name"`
+			Nodes []struct {
+				NodeUUID string `json:"nodeUUID"`
+			} `json:"nodes"`
+		} `json:"groups"`
+		URI string `json:"uri"`
+	}
+	status, body := doTestHTTP(t, "GET", mgmtURL+"/pools/default/serverGroups", nil)
+	assert.Equal(t, 200, status)
+	if err := json.Unmarshal(body, &groups); err != nil {
+		t.Fatalf("failed to parse server groups %s: %v", body, err)
+	}
+
+	// Each node is listed within its own group.
+	if assert.Len(t, groups.Groups, 2) {
+		for nodeIdx, group := range groups.Groups {
+			assert.Equal(t, nodes[nodeIdx].ServerGroup(), group.Name)
+			if assert.Len(t, group.Nodes, 1) {
+				assert.Equal(t, nodes[nodeIdx].ID(), group.Nodes[0].NodeUUID)
+			}
+		}
+	}
+
+	// The cluster config advertises the same uri as the endpoint returns.
+	var config struct {
+		ServerGroupsURI string `json:"serverGroupsUri"`
+	}
+	if err := json.Unmarshal(svcimpls.GenClusterConfig(cluster, nil), &config); err != nil {
+		t.Fatalf("failed to unmarshal configuration: %s", err)
+	}
+	assert.Equal(t, groups.URI, config.ServerGroupsURI)
+
+	resp, err := http.Get(mgmtURL + "/pools/default/serverGroups")
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, 401, resp.StatusCode)
+}
@@ -0,0 +1,132 @@
+package mockimpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSetPacket(vbID uint16, key string, durable bool) *memd.Packet {
+	pak := &memd.Packet{
+		Command: memd.CmdSet,
+		Vbucket: vbID,
+		Key:     []byte(key),
+		Value:   []byte(`{"x":1}`),
+		Extras:  make([]byte, 8),
+	}
+	if durable {
+		pak.DurabilityLevelFrame = &memd.DurabilityLevelFrame{
+			DurabilityLevel: memd.DurabilityLevelMajority,
+		}
+	}
+	return pak
+}
+
+func TestSyncWriteInProgress(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	bucket.SyncWrites().SetCommitDuration(200 * time.Millisecond)
+
+	writer := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication, memd.FeatureXerror)
+	defer writer.Close()
+	other := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication, memd.FeatureXerror)
+	defer other.Close()
+	legacy := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication)
+	defer legacy.Close()
+
+	durableOpaque := writer.send(testSetPacket(vbID, "key", true))
+
+	// The pending write does not hold up the rest of its connection.
+	resp := writer.roundTrip(&memd.Packet{Command: memd.CmdNoop})
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	assert.True(t, bucket.SyncWrites().IsPending(vbID, 0, []byte("key")))
+
+	resp = other.roundTrip(testSetPacket(vbID, "key", true))
+	assert.Equal(t, memd.StatusSyncWriteInProgress, resp.Status)
+	resp = other.roundTrip(testSetPacket(vbID, "key", false))
+	assert.Equal(t, memd.StatusSyncWriteInProgress, resp.Status)
+	resp = legacy.roundTrip(testSetPacket(vbID, "key", true))
+	assert.Equal(t, memd.StatusTmpFail, resp.Status)
+	resp = legacy.roundTrip(testSetPacket(vbID, "key", false))
+	assert.Equal(t, memd.StatusTmpFail, resp.Status)
+
+	// Other keys are unaffected, and the pending write is not visible yet.
+	resp = other.roundTrip(testSetPacket(vbID, "other", false))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+	resp = other.roundTrip(&memd.Packet{Command: memd.CmdGet, Vbucket: vbID, Key: []byte("key")})
+	assert.Equal(t, memd.StatusKeyNotFound, resp.Status)
+
+	resp = writer.read()
+	assert.Equal(t, durableOpaque, resp.Opaque)
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+
+	// Once committed, the key is released.
+	assert.False(t, bucket.SyncWrites().IsPending(vbID, 0, []byte("key")))
+	resp = other.roundTrip(testSetPacket(vbID, "key", false))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
+
+func TestSyncWriteTimeout(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+	bucket.SyncWrites().SetCommitDuration(time.Minute)
+
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication, memd.FeatureXerror)
+	defer conn.Close()
+
+	pak := testSetPacket(vbID, "key", true)
+	pak.DurabilityTimeoutFrame = &memd.DurabilityTimeoutFrame{DurabilityTimeout: 50 * time.Millisecond}
+	resp := conn.roundTrip(pak)
+	assert.Equal(t, memd.StatusSyncWriteAmbiguous, resp.Status)
+
+	// A write which timed out releases its key without having been applied.
+	assert.False(t, bucket.SyncWrites().IsPending(vbID, 0, []byte("key")))
+	resp = conn.roundTrip(&memd.Packet{Command: memd.CmdGet, Vbucket: vbID, Key: []byte("key")})
+	assert.Equal(t, memd.StatusKeyNotFound, resp.Status)
+}
+
+func TestSyncWriteNonMutation(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+	bucket := cluster.GetBucket("default")
+	vbID := testActiveVbucket(t, bucket, node)
+
+	conn := dialTestKvBucket(t, node, "default", memd.FeatureAltRequests, memd.FeatureSyncReplication, memd.FeatureXerror)
+	defer conn.Close()
+
+	resp := conn.roundTrip(testSetPacket(vbID, "key", false))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+
+	// Touch cannot be made durable, so it is rejected without leaving the key
+	// pending.
+	touchExtras := make([]byte, 4)
+	resp = conn.roundTrip(&memd.Packet{
+		Command: memd.CmdTouch,
+		Vbucket: vbID,
+		Key:     []byte("key"),
+		Extras:  touchExtras,
+		DurabilityLevelFrame: &memd.DurabilityLevelFrame{
+			DurabilityLevel: memd.DurabilityLevelMajority,
+		},
+	})
+	assert.Equal(t, memd.StatusInvalidArgs, resp.Status)
+	assert.False(t, bucket.SyncWrites().IsPending(vbID, 0, []byte("key")))
+
+	resp = conn.roundTrip(testSetPacket(vbID, "key", false))
+	assert.Equal(t, memd.StatusSuccess, resp.Status)
+}
@@ -0,0 +1,72 @@
+package mockimpl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConnectionsRecorded(t *testing.T) {
+	cluster, err := NewDefaultCluster()
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	node := cluster.Nodes()[0]
+
+	tlsConfig := &tls.Config{
+		ServerName:         "cb.example.com",
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", node.KvService().ListenPortTLS()), tlsConfig)
+	if err != nil {
+		t.Fatalf("failed to dial kv: %v", err)
+	}
+	defer conn.Close()
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := httpClient.Get(fmt.Sprintf("https://127.0.0.1:%d/pools", node.MgmtService().ListenPortTLS()))
+	if err != nil {
+		t.Fatalf("failed to request mgmt: %v", err)
+	}
+	resp.Body.Close()
+
+	// The kv handshake is recorded asynchronously to the client completing it.
+	var conns []mock.TLSConnection
+	for i := 0; i < 100; i++ {
+		conns = cluster.TLSConnections().Connections()
+		if len(conns) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 recorded connections but got %d", len(conns))
+	}
+
+	services := make(map[mock.ServiceType]mock.TLSConnection)
+	for _, conn := range conns {
+		services[conn.Service] = conn
+	}
+
+	kvConn := services[mock.ServiceTypeKeyValue]
+	assert.Equal(t, uint16(tls.VersionTLS13), kvConn.Version)
+	assert.Equal(t, conn.ConnectionState().CipherSuite, kvConn.CipherSuite)
+	assert.Equal(t, "cb.example.com", kvConn.ServerName)
+	assert.Equal(t, conn.LocalAddr().String(), kvConn.RemoteAddr)
+
+	mgmtConn := services[mock.ServiceTypeMgmt]
+	assert.Equal(t, uint16(tls.VersionTLS13), mgmtConn.Version)
+	assert.Equal(t, "cb.example.com", mgmtConn.ServerName)
+
+	cluster.TLSConnections().Reset()
+	assert.Empty(t, cluster.TLSConnections().Connections())
+}
@@ -1,13 +1,19 @@
 package mockimpl
 
 import (
-	"encoding/base64"
 	"strings"
 
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/google/uuid"
 )
 
+// newUUID generates a random UUID for a cluster, node or bucket.  These are
+// rendered as 32 hex digits without any dashes, the same way the server does.
+func newUUID() string {
+	return strings.Replace(uuid.New().String(), "-", "", -1)
+}
+
 func clusterFeatureListContains(list []mock.ClusterNodeFeature, feature mock.ClusterNodeFeature) bool {
 	// An empty list acts like a completely full list
 	if len(list) == 0 {
@@ -39,35 +45,31 @@ func serviceTypeListContains(list []mock.ServiceType, service mock.ServiceType)
 }
 
 func checkHTTPAuthenticated(permission mockauth.Permission, bucket, scope, collection string,
-	req *mock.HTTPRequest, users mock.UserManager) bool {
-	authHeader := req.Header.Get("Authorization")
-	if authHeader == "" {
-		return false
-	}
-
-	split := strings.SplitN(authHeader, " ", 2)
-	if len(split) != 2 || split[0] != "Basic" {
-		return false
-	}
-
-	p, err := base64.StdEncoding.DecodeString(split[1])
-	if err != nil {
-		return false
-	}
+	req *mock.HTTPRequest, cluster mock.Cluster) bool {
+	auth := cluster.Authenticator()
 
-	userpassword := strings.SplitN(string(p), ":", 2)
-	if len(userpassword) != 2 {
-		return false
+	// Clients which presented a certificate are authenticated as the user it
+	// maps to, instead of by their credentials.
+	if len(req.PeerCertificates) > 0 {
+		settings := cluster.ClientCertAuthSettings()
+		if settings.State != mock.ClientCertAuthStateDisable {
+			if userName := settings.UserName(req.PeerCertificates[0]); userName != "" {
+				return auth.Authorize(userName, permission, bucket, scope, collection)
+			}
+			if settings.State == mock.ClientCertAuthStateMandatory {
+				return false
+			}
+		}
 	}
 
-	user := users.GetUser(userpassword[0])
-	if user == nil {
+	username, password, ok := req.BasicAuth()
+	if !ok {
 		return false
 	}
 
-	if user.Password != userpassword[1] {
+	if !auth.Authenticate(username, password) {
 		return false
 	}
 
-	return user.HasPermission(permission, bucket, scope, collection)
+	return auth.Authorize(username, permission, bucket, scope, collection)
 }
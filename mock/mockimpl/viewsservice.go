@@ -9,8 +9,7 @@ import (
 // viewService represents a views service running somewhere in the cluster.
 type viewService struct {
 	clusterNode *clusterNodeInst
-	server      *servers.HTTPServer
-	tlsServer   *servers.HTTPServer
+	serviceServers
 }
 
 type newViewServiceOptions struct {
@@ -26,6 +25,7 @@ func newViewService(parent *clusterNodeInst, opts newViewServiceOptions) (*viewS
 		Handlers: servers.HTTPServerHandlers{
 			NewRequestHandler: svc.handleNewRequest,
 		},
+		EnableCORS: parent.HasFeature(mock.ClusterNodeFeatureCORS),
 	})
 	if err != nil {
 		return nil, err
@@ -36,9 +36,11 @@ func newViewService(parent *clusterNodeInst, opts newViewServiceOptions) (*viewS
 		tlsSrv, err := servers.NewHTTPServer(servers.NewHTTPServiceOptions{
 			Name: "view",
 			Handlers: servers.HTTPServerHandlers{
-				NewRequestHandler: svc.handleNewRequest,
+				NewRequestHandler:   svc.handleNewRequest,
+				TLSHandshakeHandler: parent.cluster.tlsHandshakeHandler(mock.ServiceTypeViews),
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig:  parent.cluster.tlsConfig,
+			EnableCORS: parent.HasFeature(mock.ClusterNodeFeatureCORS),
 		})
 		if err != nil {
 			return nil, err
@@ -56,43 +58,16 @@ func (s *viewService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *viewService) Hostname() string {
-	return "127.0.0.1"
-}
-
-// ListenPort returns the port this service is listening on.
-func (s *viewService) ListenPort() int {
-	if s.server == nil {
-		return -1
-	}
-	return s.server.ListenPort()
-}
-
-// ListenPortTLS returns the TLS port this service is listening on.
-func (s *viewService) ListenPortTLS() int {
-	if s.tlsServer == nil {
-		return -1
-	}
-	return s.tlsServer.ListenPort()
+	return s.clusterNode.Hostname()
 }
 
 func (s *viewService) handleNewRequest(req *mock.HTTPRequest) *mock.HTTPResponse {
+	s.clusterNode.waitIfPaused()
 	return s.clusterNode.cluster.handleViewRequest(s, req)
 }
 
-// Close will shut down this service once it is no longer needed.
-func (s *viewService) Close() error {
-	var errOut error
-	if s.server != nil {
-		errOut = s.server.Close()
-	}
-	if s.tlsServer != nil {
-		errOut = s.tlsServer.Close()
-	}
-	return errOut
-}
-
 // CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 func (s *viewService) CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string,
 	req *mock.HTTPRequest) bool {
-	return checkHTTPAuthenticated(permission, bucket, scope, collection, req, s.Node().Cluster().Users())
+	return checkHTTPAuthenticated(permission, bucket, scope, collection, req, s.Node().Cluster())
 }
@@ -3,6 +3,8 @@ package mockmr
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/rand"
 	"sort"
 	"strings"
 	"sync"
@@ -20,6 +22,7 @@ type Index struct {
 
 type DesignDocument struct {
 	Name    string
+	Rev     string
 	Indexes []*Index
 }
 
@@ -385,13 +388,23 @@ type UpsertDesignDocumentOptions struct {
 
 // UpsertDesignDocument creates or updates a design document.
 func (e *Engine) UpsertDesignDocument(name string, opts UpsertDesignDocumentOptions) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	// Revisions follow the couchdb style of a generation number followed by
+	// an opaque identifier.
+	revNo := 1
+	if existingDdoc, ok := e.designDocuments[name]; ok {
+		fmt.Sscanf(existingDdoc.Rev, "%d-", &revNo)
+		revNo++
+	}
+
 	ddoc := &DesignDocument{
 		Name:    name,
+		Rev:     fmt.Sprintf("%d-%08x", revNo, rand.Uint32()),
 		Indexes: opts.Indexes,
 	}
-	e.lock.Lock()
 	e.designDocuments[ddoc.Name] = ddoc
-	e.lock.Unlock()
 
 	return nil
 }
@@ -421,12 +434,16 @@ func (e *Engine) GetDesignDocument(name string) (*DesignDocument, error) {
 // GetAllDesignDocuments retrieves all design documents.
 func (e *Engine) GetAllDesignDocuments() []*DesignDocument {
 	e.lock.Lock()
-	ddocMap := e.designDocuments
-	e.lock.Unlock()
-	var ddocs []*DesignDocument
-	for _, ddoc := range ddocMap {
+	defer e.lock.Unlock()
+
+	ddocs := make([]*DesignDocument, 0, len(e.designDocuments))
+	for _, ddoc := range e.designDocuments {
 		ddocs = append(ddocs, ddoc)
 	}
 
+	sort.Slice(ddocs, func(i, j int) bool {
+		return ddocs[i].Name < ddocs[j].Name
+	})
+
 	return ddocs
 }
@@ -0,0 +1,146 @@
+package mock
+
+import (
+	"sort"
+	"sync"
+)
+
+// NotMyVbucketStats describes how a single kv client has reacted to the
+// NOT_MY_VBUCKET errors which it has been sent.
+type NotMyVbucketStats struct {
+	// Client is the remote address of the kv client.
+	Client string
+
+	// NotMyVbuckets is the number of NOT_MY_VBUCKET errors sent to the client.
+	NotMyVbuckets uint64
+
+	// EmbeddedConfigs is the number of those errors which had a config
+	// embedded within them.
+	EmbeddedConfigs uint64
+
+	// ConfigFetches is the number of config fetches the client made after it
+	// had been sent at least one NOT_MY_VBUCKET error since its last fetch.
+	ConfigFetches uint64
+}
+
+// FetchRate returns the number of config fetches the client made for each
+// NOT_MY_VBUCKET error it was sent.  A client which refetches the config on
+// every error has a rate of 1, one which backs off has a much lower rate.
+func (s NotMyVbucketStats) FetchRate() float64 {
+	if s.NotMyVbuckets == 0 {
+		return 0
+	}
+	return float64(s.ConfigFetches) / float64(s.NotMyVbuckets)
+}
+
+type notMyVbucketClientState struct {
+	stats NotMyVbucketStats
+
+	// pendingFetch indicates the client has been sent an error since its
+	// last config fetch, so its next fetch was triggered by the error.
+	pendingFetch bool
+}
+
+// NotMyVbucketTracker tracks the NOT_MY_VBUCKET errors sent to the kv clients
+// of a cluster and the config fetches they trigger, so that tests can verify
+// that an SDK backs off its config fetching during a storm of errors.
+type NotMyVbucketTracker struct {
+	lock    sync.Mutex
+	clients map[string]*notMyVbucketClientState
+
+	// configOncePerRev causes the config to be embedded into the errors sent
+	// to each client only once per revision, even if the client has not
+	// negotiated deduplication.
+	configOncePerRev bool
+}
+
+// NewNotMyVbucketTracker creates a new tracker with nothing recorded.
+func NewNotMyVbucketTracker() *NotMyVbucketTracker {
+	return &NotMyVbucketTracker{
+		clients: make(map[string]*notMyVbucketClientState),
+	}
+}
+
+func (t *NotMyVbucketTracker) getClientLocked(client string) *notMyVbucketClientState {
+	state := t.clients[client]
+	if state == nil {
+		state = &notMyVbucketClientState{
+			stats: NotMyVbucketStats{Client: client},
+		}
+		t.clients[client] = state
+	}
+	return state
+}
+
+// RecordNotMyVbucket records that a NOT_MY_VBUCKET error has been sent to a
+// client, and whether a config was embedded within it.
+func (t *NotMyVbucketTracker) RecordNotMyVbucket(client string, embeddedConfig bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	state := t.getClientLocked(client)
+	state.stats.NotMyVbuckets++
+	if embeddedConfig {
+		state.stats.EmbeddedConfigs++
+	}
+	state.pendingFetch = true
+}
+
+// RecordConfigFetch records that a client has fetched the config.  Only the
+// fetches which follow a NOT_MY_VBUCKET error are counted.
+func (t *NotMyVbucketTracker) RecordConfigFetch(client string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	state := t.clients[client]
+	if state == nil || !state.pendingFetch {
+		return
+	}
+
+	state.stats.ConfigFetches++
+	state.pendingFetch = false
+}
+
+// Stats returns the statistics of every client which has been sent a
+// NOT_MY_VBUCKET error, ordered by client.
+func (t *NotMyVbucketTracker) Stats() []NotMyVbucketStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats := make([]NotMyVbucketStats, 0, len(t.clients))
+	for _, state := range t.clients {
+		stats = append(stats, state.stats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Client < stats[j].Client
+	})
+	return stats
+}
+
+// ConfigOncePerRev returns whether the config is embedded into the errors
+// sent to each client only once per revision, regardless of whether the client
+// has negotiated deduplication.
+func (t *NotMyVbucketTracker) ConfigOncePerRev() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.configOncePerRev
+}
+
+// SetConfigOncePerRev sets whether the config is embedded into the errors
+// sent to each client only once per revision, regardless of whether the client
+// has negotiated deduplication.
+func (t *NotMyVbucketTracker) SetConfigOncePerRev(enabled bool) {
+	t.lock.Lock()
+	t.configOncePerRev = enabled
+	t.lock.Unlock()
+}
+
+// Reset forgets all of the errors and config fetches which have been
+// recorded.  Whether configs are embedded once per revision is unchanged.
+func (t *NotMyVbucketTracker) Reset() {
+	t.lock.Lock()
+	t.clients = make(map[string]*notMyVbucketClientState)
+	t.lock.Unlock()
+}
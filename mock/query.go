@@ -0,0 +1,134 @@
+package mock
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// QueryRequest represents a single request made to the query service, with
+// any parameters which were bound to the statement.
+type QueryRequest struct {
+	Statement       string
+	ClientContextID string
+
+	// ReadOnly indicates that the request must not mutate any data.
+	ReadOnly bool
+
+	// TxID is the id of the transaction which the statement is part of, as
+	// returned by a previous BEGIN TRANSACTION.
+	TxID string
+
+	// TxImplicit indicates that the statement should be run in its own
+	// single statement transaction.
+	TxImplicit bool
+
+	// TxTimeout is how long a transaction started by the request may remain
+	// open before it expires.
+	TxTimeout time.Duration
+
+	// PositionalArgs are the values bound to $1, $2, etc.  in the order they
+	// were provided through args.
+	PositionalArgs []json.RawMessage
+
+	// NamedArgs are the values bound to named parameters, keyed by their name
+	// without the leading $.
+	NamedArgs map[string]json.RawMessage
+
+	// ScanConsistency is the consistency which index scans must provide, such
+	// as not_bounded, request_plus or at_plus.
+	ScanConsistency string
+
+	// ScanVectors are the mutations which at_plus requests must observe, keyed
+	// by bucket name and then by vbucket.
+	ScanVectors map[string]map[uint16]QueryScanVectorEntry
+
+	// ScanWait is how long an at_plus request may wait for the indexes to
+	// catch up with its scan vectors before it times out.
+	ScanWait time.Duration
+
+	// Controls indicates that the response should include the controls which
+	// were applied to the request.
+	Controls bool
+
+	// MemoryQuota is the most memory, in megabytes, which the request may use
+	// to hold its results.  Zero means the request has no quota.
+	MemoryQuota uint64
+
+	// MaxParallelism, ScanCap, PipelineBatch and PipelineCap are the tuning
+	// options of the request, which are zero when they were not specified.
+	MaxParallelism int
+	ScanCap        int
+	PipelineBatch  int
+	PipelineCap    int
+
+	// Timeout is how long the server may spend on the request, or zero if the
+	// request did not specify one.
+	Timeout time.Duration
+
+	// Profile is the profiling mode requested, such as off, phases or timings.
+	Profile string
+}
+
+// QueryScanVectorEntry identifies a mutation within a single vbucket.
+type QueryScanVectorEntry struct {
+	SeqNo  uint64
+	VbUUID uint64
+}
+
+// QueryResultProvider provides the results for requests made to the query
+// service.  Tests can provide their own to assert on the parameters which were
+// bound by the SDK, or to return results which depend on them.
+type QueryResultProvider interface {
+	// ExecuteQuery returns the rows which should be returned for a request.
+	ExecuteQuery(req *QueryRequest) ([]json.RawMessage, error)
+}
+
+// QueryRowHookProvider may be implemented by a QueryResultProvider which needs
+// to act part way through streaming the rows of a response, for instance to
+// change the topology of the cluster while a query is in flight.
+type QueryRowHookProvider interface {
+	// RowHook returns a callback which is invoked once afterRows rows of the
+	// response to a request have been received by the client.  The remaining
+	// rows are sent once the callback returns.  A nil callback, or one which
+	// would be invoked after the last row, disables the hook for the request.
+	RowHook(req *QueryRequest) (afterRows int, callback func())
+}
+
+// QueryRequestRecorder records the requests made to the query service of a
+// cluster, so that tests can verify the options which an SDK sent.
+type QueryRequestRecorder struct {
+	lock     sync.Mutex
+	requests []QueryRequest
+}
+
+// NewQueryRequestRecorder creates a new query request recorder with nothing
+// recorded.
+func NewQueryRequestRecorder() *QueryRequestRecorder {
+	return &QueryRequestRecorder{}
+}
+
+// Record records that a request has been received.
+func (r *QueryRequestRecorder) Record(req QueryRequest) {
+	r.lock.Lock()
+	r.requests = append(r.requests, req)
+	r.lock.Unlock()
+}
+
+// Requests returns the requests which have been received, in the order they
+// were parsed.
+func (r *QueryRequestRecorder) Requests() []QueryRequest {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	requests := make([]QueryRequest, len(r.requests))
+	copy(requests, r.requests)
+	return requests
+}
+
+// Reset forgets all of the requests which have been recorded.
+func (r *QueryRequestRecorder) Reset() {
+	r.lock.Lock()
+	r.requests = nil
+	r.lock.Unlock()
+}
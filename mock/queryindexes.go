@@ -0,0 +1,100 @@
+package mock
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// QueryIndexState is the state of a GSI index.
+type QueryIndexState string
+
+// The following lists the states which a GSI index can be in.
+const (
+	QueryIndexStateBuilding = QueryIndexState("building")
+	QueryIndexStateReady    = QueryIndexState("ready")
+)
+
+// QueryIndex represents a GSI index which tests have registered against a
+// cluster, along with the state of its build.
+type QueryIndex struct {
+	Bucket string
+	Name   string
+	State  QueryIndexState
+}
+
+type queryIndexKey struct {
+	bucket string
+	name   string
+}
+
+// QueryIndexRegistry holds the GSI indexes of a cluster.  Queries which
+// reference an index which is still building fail with a transient error
+// until the index is ready.
+type QueryIndexRegistry struct {
+	lock    sync.Mutex
+	indexes map[queryIndexKey]QueryIndexState
+}
+
+// NewQueryIndexRegistry creates a new index registry with no indexes.
+func NewQueryIndexRegistry() *QueryIndexRegistry {
+	return &QueryIndexRegistry{
+		indexes: make(map[queryIndexKey]QueryIndexState),
+	}
+}
+
+// SetIndexState registers an index in a particular state, replacing the state
+// of any existing index of the same bucket and name.
+func (r *QueryIndexRegistry) SetIndexState(bucket, name string, state QueryIndexState) error {
+	if bucket == "" || name == "" {
+		return errors.New("index must have a bucket and a name")
+	}
+	if state != QueryIndexStateBuilding && state != QueryIndexStateReady {
+		return errors.New("invalid index state")
+	}
+
+	r.lock.Lock()
+	r.indexes[queryIndexKey{bucket: bucket, name: name}] = state
+	r.lock.Unlock()
+	return nil
+}
+
+// IndexState returns the state of an index, and whether it exists.
+func (r *QueryIndexRegistry) IndexState(bucket, name string) (QueryIndexState, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	state, ok := r.indexes[queryIndexKey{bucket: bucket, name: name}]
+	return state, ok
+}
+
+// Indexes returns all of the registered indexes, ordered by bucket and then
+// by name.
+func (r *QueryIndexRegistry) Indexes() []QueryIndex {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	indexes := make([]QueryIndex, 0, len(r.indexes))
+	for key, state := range r.indexes {
+		indexes = append(indexes, QueryIndex{
+			Bucket: key.bucket,
+			Name:   key.name,
+			State:  state,
+		})
+	}
+
+	sort.Slice(indexes, func(i, j int) bool {
+		if indexes[i].Bucket != indexes[j].Bucket {
+			return indexes[i].Bucket < indexes[j].Bucket
+		}
+		return indexes[i].Name < indexes[j].Name
+	})
+	return indexes
+}
+
+// Reset removes all of the registered indexes.
+func (r *QueryIndexRegistry) Reset() {
+	r.lock.Lock()
+	r.indexes = make(map[queryIndexKey]QueryIndexState)
+	r.lock.Unlock()
+}
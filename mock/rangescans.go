@@ -0,0 +1,156 @@
+package mock
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
+
+// DefaultRangeScanIdleTimeout is how long a range scan may go without being
+// continued before the server cancels it, freeing its resources.
+const DefaultRangeScanIdleTimeout = 60 * time.Second
+
+// These are the errors which can be returned when accessing a range scan.
+var (
+	ErrRangeScanNotFound  = errors.New("range scan not found")
+	ErrRangeScanCancelled = errors.New("range scan cancelled")
+)
+
+// RangeScanItem represents a single document which is returned by a range
+// scan.  Only the key is populated for key only scans.
+type RangeScanItem struct {
+	Key      []byte
+	Value    []byte
+	Flags    uint32
+	Expiry   uint32
+	SeqNo    uint64
+	Cas      uint64
+	Datatype uint8
+}
+
+// RangeScan represents a single open range scan, along with the items which
+// it has yet to return.
+type RangeScan struct {
+	UUID    [16]byte
+	VbID    uint16
+	KeyOnly bool
+	Items   []RangeScanItem
+
+	lastUsed time.Time
+}
+
+// RangeScanRegistry tracks the open range scans against a bucket.  Scans which
+// are cancelled, or which are left idle for too long, are freed but leave
+// behind a record so that continuing them reports that they were cancelled.
+type RangeScanRegistry struct {
+	lock        sync.Mutex
+	chrono      *mocktime.Chrono
+	idleTimeout time.Duration
+	scans       map[[16]byte]*RangeScan
+	cancelled   map[[16]byte]struct{}
+}
+
+// NewRangeScanRegistry creates a new, empty, range scan registry which uses
+// the provided chrono to determine when scans have become idle.
+func NewRangeScanRegistry(chrono *mocktime.Chrono) *RangeScanRegistry {
+	return &RangeScanRegistry{
+		chrono:      chrono,
+		idleTimeout: DefaultRangeScanIdleTimeout,
+		scans:       make(map[[16]byte]*RangeScan),
+		cancelled:   make(map[[16]byte]struct{}),
+	}
+}
+
+// IdleTimeout returns how long a scan may be idle before it is cancelled.
+func (r *RangeScanRegistry) IdleTimeout() time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.idleTimeout
+}
+
+// SetIdleTimeout sets how long a scan may be idle before it is cancelled.  A
+// timeout of zero restores the default.
+func (r *RangeScanRegistry) SetIdleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultRangeScanIdleTimeout
+	}
+
+	r.lock.Lock()
+	r.idleTimeout = timeout
+	r.lock.Unlock()
+}
+
+// reapIdleLocked cancels any scans which have not been used within the idle
+// timeout.  Scans are reaped whenever the registry is accessed, rather than
+// by a timer, so that time travel is taken into account.
+func (r *RangeScanRegistry) reapIdleLocked() {
+	now := r.chrono.Now()
+	for scanUUID, scan := range r.scans {
+		if now.Sub(scan.lastUsed) >= r.idleTimeout {
+			delete(r.scans, scanUUID)
+			r.cancelled[scanUUID] = struct{}{}
+		}
+	}
+}
+
+// Add registers a newly created scan.
+func (r *RangeScanRegistry) Add(scan *RangeScan) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.reapIdleLocked()
+	scan.lastUsed = r.chrono.Now()
+	r.scans[scan.UUID] = scan
+}
+
+// Continue invokes fn with an open scan so that it can take items from it.
+// The scan is freed if fn reports that the scan has completed.
+func (r *RangeScanRegistry) Continue(scanUUID [16]byte, fn func(scan *RangeScan) bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.reapIdleLocked()
+	scan, ok := r.scans[scanUUID]
+	if !ok {
+		if _, ok := r.cancelled[scanUUID]; ok {
+			return ErrRangeScanCancelled
+		}
+		return ErrRangeScanNotFound
+	}
+
+	scan.lastUsed = r.chrono.Now()
+	if fn(scan) {
+		delete(r.scans, scanUUID)
+	}
+	return nil
+}
+
+// Cancel cancels an open scan, freeing its resources.
+func (r *RangeScanRegistry) Cancel(scanUUID [16]byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.reapIdleLocked()
+	if _, ok := r.scans[scanUUID]; !ok {
+		if _, ok := r.cancelled[scanUUID]; ok {
+			return ErrRangeScanCancelled
+		}
+		return ErrRangeScanNotFound
+	}
+
+	delete(r.scans, scanUUID)
+	r.cancelled[scanUUID] = struct{}{}
+	return nil
+}
+
+// ActiveCount returns the number of scans which are still open, allowing tests
+// to confirm that no scans have been leaked.
+func (r *RangeScanRegistry) ActiveCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.reapIdleLocked()
+	return len(r.scans)
+}
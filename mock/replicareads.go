@@ -0,0 +1,61 @@
+package mock
+
+import (
+	"sync"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+)
+
+// ReplicaRead describes a single replica read which was served by a node.
+type ReplicaRead struct {
+	NodeID     string
+	BucketName string
+	Vbucket    uint16
+	Key        []byte
+
+	// ReplicaIdx is the copy of the vbucket which the node holds, where 0 is
+	// the master and -1 means it holds no copy at all.  Replica reads do not
+	// name the replica they want, so this is the one they were routed to.
+	ReplicaIdx int
+
+	// Status is the status which the read was responded to with.
+	Status memd.StatusCode
+}
+
+// ReplicaReadRecorder records the replica reads served by the nodes of a
+// cluster, so that tests can verify how an SDK routed them.
+type ReplicaReadRecorder struct {
+	lock  sync.Mutex
+	reads []ReplicaRead
+}
+
+// NewReplicaReadRecorder creates a new replica read recorder with nothing
+// recorded.
+func NewReplicaReadRecorder() *ReplicaReadRecorder {
+	return &ReplicaReadRecorder{}
+}
+
+// Record records that a replica read has been served.
+func (r *ReplicaReadRecorder) Record(read ReplicaRead) {
+	r.lock.Lock()
+	r.reads = append(r.reads, read)
+	r.lock.Unlock()
+}
+
+// Reads returns the replica reads which have been served, in the order they
+// were responded to.
+func (r *ReplicaReadRecorder) Reads() []ReplicaRead {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reads := make([]ReplicaRead, len(r.reads))
+	copy(reads, r.reads)
+	return reads
+}
+
+// Reset forgets all of the replica reads which have been recorded.
+func (r *ReplicaReadRecorder) Reset() {
+	r.lock.Lock()
+	r.reads = nil
+	r.lock.Unlock()
+}
@@ -0,0 +1,167 @@
+package mock
+
+import (
+	"sync"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+)
+
+type httpEndpoint struct {
+	service ServiceType
+	method  string
+	path    string
+}
+
+// RequestCounters counts the requests which have been handled by each of the
+// services of a cluster.  This is much lighter than recording the requests
+// themselves, which makes it suitable for high-volume tests.
+type RequestCounters struct {
+	lock         sync.Mutex
+	kvOps        map[memd.CmdCode]uint64
+	httpRequests map[httpEndpoint]uint64
+
+	// orphanedKvResponses counts the kv responses which were sent after the
+	// client would already have given up on the request.
+	orphanedKvResponses map[memd.CmdCode]uint64
+
+	// droppedKvResponses counts the kv responses which could not be sent as
+	// the connection their request arrived on had closed.
+	droppedKvResponses uint64
+
+	// leakedKvResponses counts the kv responses which were written to a
+	// connection that never sent their request.
+	leakedKvResponses uint64
+}
+
+// NewRequestCounters creates a new set of request counters, all at zero.
+func NewRequestCounters() *RequestCounters {
+	c := &RequestCounters{}
+	c.Reset()
+	return c
+}
+
+// CountKvOp records that a kv operation has been received.
+func (c *RequestCounters) CountKvOp(cmd memd.CmdCode) {
+	c.lock.Lock()
+	c.kvOps[cmd]++
+	c.lock.Unlock()
+}
+
+// CountOrphanedKvResponse records that a kv response has been sent for a
+// request which the client is expected to have already abandoned.
+func (c *RequestCounters) CountOrphanedKvResponse(cmd memd.CmdCode) {
+	c.lock.Lock()
+	c.orphanedKvResponses[cmd]++
+	c.lock.Unlock()
+}
+
+// CountDroppedKvResponse records that a kv response could not be sent as the
+// connection its request arrived on has since closed.
+func (c *RequestCounters) CountDroppedKvResponse() {
+	c.lock.Lock()
+	c.droppedKvResponses++
+	c.lock.Unlock()
+}
+
+// CountLeakedKvResponse records that a kv response was written to a
+// connection which never sent its request.
+func (c *RequestCounters) CountLeakedKvResponse() {
+	c.lock.Lock()
+	c.leakedKvResponses++
+	c.lock.Unlock()
+}
+
+// CountHTTPRequest records that an HTTP request has been received by a service.
+func (c *RequestCounters) CountHTTPRequest(service ServiceType, method, path string) {
+	c.lock.Lock()
+	c.httpRequests[httpEndpoint{service, method, path}]++
+	c.lock.Unlock()
+}
+
+// KvOps returns the number of kv operations received with a specific opcode.
+func (c *RequestCounters) KvOps(cmd memd.CmdCode) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.kvOps[cmd]
+}
+
+// OrphanedKvResponses returns the number of kv responses which were sent for
+// requests that the client is expected to have already abandoned.
+func (c *RequestCounters) OrphanedKvResponses() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var count uint64
+	for _, cmdCount := range c.orphanedKvResponses {
+		count += cmdCount
+	}
+	return count
+}
+
+// DroppedKvResponses returns the number of kv responses which could not be
+// sent as their connection had closed.  Responses are never delivered to a
+// connection other than the one their request arrived on.
+func (c *RequestCounters) DroppedKvResponses() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.droppedKvResponses
+}
+
+// LeakedKvResponses returns the number of kv responses which were written to
+// a connection that never sent their request, such as the response to a
+// request made before a reconnect.  This is always expected to be zero.
+func (c *RequestCounters) LeakedKvResponses() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.leakedKvResponses
+}
+
+// HTTPRequests returns the number of requests received by a specific endpoint
+// of a service.  An empty method counts requests made with any method.
+func (c *RequestCounters) HTTPRequests(service ServiceType, method, path string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var count uint64
+	for endpoint, endpointCount := range c.httpRequests {
+		if endpoint.service == service && endpoint.path == path && (method == "" || endpoint.method == method) {
+			count += endpointCount
+		}
+	}
+	return count
+}
+
+// ServiceRequests returns the total number of requests received by a service.
+func (c *RequestCounters) ServiceRequests(service ServiceType) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var count uint64
+	if service == ServiceTypeKeyValue {
+		for _, opCount := range c.kvOps {
+			count += opCount
+		}
+		return count
+	}
+
+	for endpoint, endpointCount := range c.httpRequests {
+		if endpoint.service == service {
+			count += endpointCount
+		}
+	}
+	return count
+}
+
+// Reset sets all of the counters back to zero.
+func (c *RequestCounters) Reset() {
+	c.lock.Lock()
+	c.kvOps = make(map[memd.CmdCode]uint64)
+	c.httpRequests = make(map[httpEndpoint]uint64)
+	c.orphanedKvResponses = make(map[memd.CmdCode]uint64)
+	c.droppedKvResponses = 0
+	c.leakedKvResponses = 0
+	c.lock.Unlock()
+}
@@ -0,0 +1,86 @@
+package mock
+
+import (
+	"sync"
+	"time"
+)
+
+type syncWriteKey struct {
+	vbID         uint16
+	collectionID uint32
+	key          string
+}
+
+// SyncWriteRegistry tracks the durable writes which are still pending against
+// the keys of a bucket.  Durable writes take the commit duration to complete,
+// and while one is pending any other write to the same key is rejected.
+type SyncWriteRegistry struct {
+	lock           sync.Mutex
+	commitDuration time.Duration
+	pending        map[syncWriteKey]interface{}
+}
+
+// NewSyncWriteRegistry creates a new, empty, sync write registry whose durable
+// writes commit immediately.
+func NewSyncWriteRegistry() *SyncWriteRegistry {
+	return &SyncWriteRegistry{
+		pending: make(map[syncWriteKey]interface{}),
+	}
+}
+
+// CommitDuration returns how long a durable write stays pending before it is
+// committed.
+func (r *SyncWriteRegistry) CommitDuration() time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.commitDuration
+}
+
+// SetCommitDuration sets how long a durable write stays pending before it is
+// committed.  A duration of zero commits durable writes immediately.
+func (r *SyncWriteRegistry) SetCommitDuration(duration time.Duration) {
+	if duration < 0 {
+		duration = 0
+	}
+
+	r.lock.Lock()
+	r.commitDuration = duration
+	r.lock.Unlock()
+}
+
+// Begin marks a durable write as pending against a key on behalf of owner,
+// returning false if another durable write is already pending against it.
+func (r *SyncWriteRegistry) Begin(vbID uint16, collectionID uint32, key []byte, owner interface{}) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	syncKey := syncWriteKey{vbID, collectionID, string(key)}
+	if _, ok := r.pending[syncKey]; ok {
+		return false
+	}
+
+	r.pending[syncKey] = owner
+	return true
+}
+
+// End marks the durable write pending against a key as completed, either
+// because it committed or because it timed out.  Nothing happens unless the
+// pending write belongs to owner.
+func (r *SyncWriteRegistry) End(vbID uint16, collectionID uint32, key []byte, owner interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	syncKey := syncWriteKey{vbID, collectionID, string(key)}
+	if r.pending[syncKey] == owner {
+		delete(r.pending, syncKey)
+	}
+}
+
+// IsPending returns whether a durable write is pending against a key.
+func (r *SyncWriteRegistry) IsPending(vbID uint16, collectionID uint32, key []byte) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.pending[syncWriteKey{vbID, collectionID, string(key)}]
+	return ok
+}
@@ -0,0 +1,94 @@
+package mock
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Task represents a cluster task which is reported by /pools/default/tasks
+// without being simulated by the mock, such as an XDCR replication or a
+// rebalance which tests want to appear to be in progress.
+type Task struct {
+	// ID uniquely identifies the task, and is used to update or remove it.
+	ID string
+
+	// Type is the type of the task, such as "rebalance" or "xdcr".
+	Type string
+
+	Subtype  string
+	Status   string
+	Bucket   string
+	Progress int
+}
+
+// TaskRegistry holds the tasks which tests have registered against a
+// cluster, alongside the tasks which the mock simulates itself.
+type TaskRegistry struct {
+	lock  sync.Mutex
+	tasks map[string]Task
+}
+
+// NewTaskRegistry creates a new task registry with no tasks.
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{
+		tasks: make(map[string]Task),
+	}
+}
+
+// SetTask registers a task, replacing any existing task with the same id.
+// Tasks without a status are reported as running.
+func (r *TaskRegistry) SetTask(task Task) error {
+	if task.ID == "" {
+		return errors.New("task must have an id")
+	}
+	if task.Type == "" {
+		return errors.New("task must have a type")
+	}
+	if task.Progress < 0 || task.Progress > 100 {
+		return errors.New("task progress must be between 0 and 100")
+	}
+	if task.Status == "" {
+		task.Status = "running"
+	}
+
+	r.lock.Lock()
+	r.tasks[task.ID] = task
+	r.lock.Unlock()
+	return nil
+}
+
+// RemoveTask removes a registered task, returning whether it existed.
+func (r *TaskRegistry) RemoveTask(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.tasks[id]; !ok {
+		return false
+	}
+	delete(r.tasks, id)
+	return true
+}
+
+// Tasks returns all of the registered tasks, ordered by id.
+func (r *TaskRegistry) Tasks() []Task {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	tasks := make([]Task, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		tasks = append(tasks, task)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+// Reset removes all of the registered tasks.
+func (r *TaskRegistry) Reset() {
+	r.lock.Lock()
+	r.tasks = make(map[string]Task)
+	r.lock.Unlock()
+}
@@ -0,0 +1,69 @@
+package mock
+
+import (
+	"sync"
+	"time"
+)
+
+// TLSConnection describes the parameters which were negotiated during the
+// TLS handshake of a single connection to one of the services of a cluster.
+type TLSConnection struct {
+	Service    ServiceType
+	LocalAddr  string
+	RemoteAddr string
+	Timestamp  time.Time
+
+	// Version is the TLS version which was negotiated, as one of the
+	// tls.VersionTLS constants.
+	Version uint16
+
+	// CipherSuite is the cipher suite which was negotiated, as one of the
+	// tls.TLS_ constants.
+	CipherSuite uint16
+
+	// ServerName is the server name which the client sent using SNI, or an
+	// empty string if it did not send one.
+	ServerName string
+
+	// NegotiatedProtocol is the protocol which was negotiated using ALPN, or
+	// an empty string if none was.
+	NegotiatedProtocol string
+}
+
+// TLSConnectionRecorder records the TLS handshakes which have completed on
+// the TLS listeners of a cluster, so that tests can verify how an SDK has
+// configured TLS.
+type TLSConnectionRecorder struct {
+	lock        sync.Mutex
+	connections []TLSConnection
+}
+
+// NewTLSConnectionRecorder creates a new recorder with nothing recorded.
+func NewTLSConnectionRecorder() *TLSConnectionRecorder {
+	return &TLSConnectionRecorder{}
+}
+
+// Record records a connection which has completed its TLS handshake.
+func (r *TLSConnectionRecorder) Record(conn TLSConnection) {
+	r.lock.Lock()
+	r.connections = append(r.connections, conn)
+	r.lock.Unlock()
+}
+
+// Connections returns all of the recorded connections, in the order their
+// handshakes completed.
+func (r *TLSConnectionRecorder) Connections() []TLSConnection {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	connections := make([]TLSConnection, len(r.connections))
+	copy(connections, r.connections)
+	return connections
+}
+
+// Reset forgets all of the recorded connections.
+func (r *TLSConnectionRecorder) Reset() {
+	r.lock.Lock()
+	r.connections = nil
+	r.lock.Unlock()
+}
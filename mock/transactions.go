@@ -0,0 +1,161 @@
+package mock
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// ATRKeyPrefix is the prefix of the keys of the active transaction record
+// documents which the transactions libraries create.
+const ATRKeyPrefix = "_txn:atr-"
+
+// atrAttemptsXattr is the xattr of an active transaction record which holds
+// its attempt entries, keyed by attempt id.
+const atrAttemptsXattr = "attempts"
+
+// ATRDocRef identifies a document which was staged by a transaction attempt.
+type ATRDocRef struct {
+	Bucket     string
+	Scope      string
+	Collection string
+	Key        string
+}
+
+// ATREntry represents a single attempt entry of an active transaction record.
+// The timestamps are kept as they were stored, which is normally the expanded
+// CAS of the mutation which wrote them, and are empty if not yet reached.
+type ATREntry struct {
+	// These identify the active transaction record holding the entry.
+	Bucket     string
+	Scope      string
+	Collection string
+	ATRKey     string
+
+	AttemptID     string
+	TransactionID string
+	State         string
+	ExpiryMs      int64
+
+	StartTime            string
+	CommitTime           string
+	CompleteTime         string
+	RollbackTime         string
+	RollbackCompleteTime string
+
+	Inserts  []ATRDocRef
+	Replaces []ATRDocRef
+	Removes  []ATRDocRef
+}
+
+type jsonATRDocRef struct {
+	Bucket     string `json:"bkt"`
+	Scope      string `json:"scp"`
+	Collection string `json:"col"`
+	Key        string `json:"id"`
+}
+
+type jsonATREntry struct {
+	TransactionID        string          `json:"tid"`
+	State                string          `json:"st"`
+	ExpiryMs             int64           `json:"exp"`
+	StartTime            string          `json:"tst"`
+	CommitTime           string          `json:"tsc"`
+	CompleteTime         string          `json:"tsco"`
+	RollbackTime         string          `json:"tsrs"`
+	RollbackCompleteTime string          `json:"tsrc"`
+	Inserts              []jsonATRDocRef `json:"ins"`
+	Replaces             []jsonATRDocRef `json:"rep"`
+	Removes              []jsonATRDocRef `json:"rem"`
+}
+
+func parseATRDocRefs(refs []jsonATRDocRef) []ATRDocRef {
+	var docRefs []ATRDocRef
+	for _, ref := range refs {
+		docRefs = append(docRefs, ATRDocRef(ref))
+	}
+	return docRefs
+}
+
+// ListATREntries scans every collection of every bucket of a cluster for
+// active transaction records, and returns all of their attempt entries.  The
+// entries are ordered by the record which holds them, then by attempt id.
+func ListATREntries(cluster Cluster) ([]ATREntry, error) {
+	var entries []ATREntry
+	for _, bucket := range cluster.GetAllBuckets() {
+		if bucket.BucketType() == BucketTypeMemcached {
+			continue
+		}
+
+		_, scopes := bucket.CollectionManifest().GetManifest()
+		for _, scope := range scopes {
+			for _, collection := range scope.Collections {
+				docs, err := bucket.Store().GetAll(0, uint(collection.UID))
+				if err != nil {
+					return nil, err
+				}
+
+				// Every revision of each document is returned, in the order
+				// they were written, so the last one seen is the current one.
+				latestAttempts := make(map[string][]byte)
+				for _, doc := range docs {
+					if !strings.HasPrefix(string(doc.Key), ATRKeyPrefix) {
+						continue
+					}
+					latestAttempts[string(doc.Key)] = doc.Xattrs[atrAttemptsXattr]
+				}
+
+				for atrKey, attemptsBytes := range latestAttempts {
+					if len(attemptsBytes) == 0 {
+						continue
+					}
+
+					var attempts map[string]jsonATREntry
+					if err := json.Unmarshal(attemptsBytes, &attempts); err != nil {
+						return nil, err
+					}
+
+					for attemptID, attempt := range attempts {
+						entries = append(entries, ATREntry{
+							Bucket:               bucket.Name(),
+							Scope:                scope.Name,
+							Collection:           collection.Name,
+							ATRKey:               atrKey,
+							AttemptID:            attemptID,
+							TransactionID:        attempt.TransactionID,
+							State:                attempt.State,
+							ExpiryMs:             attempt.ExpiryMs,
+							StartTime:            attempt.StartTime,
+							CommitTime:           attempt.CommitTime,
+							CompleteTime:         attempt.CompleteTime,
+							RollbackTime:         attempt.RollbackTime,
+							RollbackCompleteTime: attempt.RollbackCompleteTime,
+							Inserts:              parseATRDocRefs(attempt.Inserts),
+							Replaces:             parseATRDocRefs(attempt.Replaces),
+							Removes:              parseATRDocRefs(attempt.Removes),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+		if a.ATRKey != b.ATRKey {
+			return a.ATRKey < b.ATRKey
+		}
+		return a.AttemptID < b.AttemptID
+	})
+
+	return entries, nil
+}
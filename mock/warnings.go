@@ -0,0 +1,104 @@
+package mock
+
+import (
+	"sync"
+)
+
+// QueryWarning represents a warning which is returned alongside the results
+// of a query which otherwise succeeded.
+type QueryWarning struct {
+	Code int
+	Msg  string
+}
+
+// SubDocWarning represents a note which was recorded against a subdoc
+// operation which succeeded.  The kv protocol has no way to return warnings,
+// so these are only visible to tests.
+type SubDocWarning struct {
+	Key string
+	Msg string
+}
+
+// WarningInjector holds the warnings which tests have injected into the
+// responses of operations which otherwise succeed.
+type WarningInjector struct {
+	lock           sync.Mutex
+	queryWarnings  []QueryWarning
+	subDocNotes    map[string]string
+	subDocWarnings []SubDocWarning
+}
+
+// NewWarningInjector creates a new warning injector with no warnings.
+func NewWarningInjector() *WarningInjector {
+	return &WarningInjector{
+		subDocNotes: make(map[string]string),
+	}
+}
+
+// QueryWarnings returns the warnings which are returned by every successful
+// query.
+func (w *WarningInjector) QueryWarnings() []QueryWarning {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	warnings := make([]QueryWarning, len(w.queryWarnings))
+	copy(warnings, w.queryWarnings)
+	return warnings
+}
+
+// SetQueryWarnings sets the warnings which are returned by every successful
+// query.  Passing nil stops any warnings being returned.
+func (w *WarningInjector) SetQueryWarnings(warnings []QueryWarning) {
+	w.lock.Lock()
+	w.queryWarnings = append([]QueryWarning{}, warnings...)
+	w.lock.Unlock()
+}
+
+// SetSubDocWarning sets the note which is recorded whenever a subdoc operation
+// against a document with the specified key succeeds.  An empty note stops
+// any being recorded for the key.
+func (w *WarningInjector) SetSubDocWarning(key, msg string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if msg == "" {
+		delete(w.subDocNotes, key)
+		return
+	}
+	w.subDocNotes[key] = msg
+}
+
+// RecordSubDocSuccess records the note for a key, if there is one, following
+// the success of a subdoc operation against it.
+func (w *WarningInjector) RecordSubDocSuccess(key []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if msg, ok := w.subDocNotes[string(key)]; ok {
+		w.subDocWarnings = append(w.subDocWarnings, SubDocWarning{
+			Key: string(key),
+			Msg: msg,
+		})
+	}
+}
+
+// SubDocWarnings returns the notes which have been recorded against successful
+// subdoc operations, in the order the operations completed.
+func (w *WarningInjector) SubDocWarnings() []SubDocWarning {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	warnings := make([]SubDocWarning, len(w.subDocWarnings))
+	copy(warnings, w.subDocWarnings)
+	return warnings
+}
+
+// Reset removes all of the injected warnings, and forgets any notes which
+// have been recorded.
+func (w *WarningInjector) Reset() {
+	w.lock.Lock()
+	w.queryWarnings = nil
+	w.subDocNotes = make(map[string]string)
+	w.subDocWarnings = nil
+	w.lock.Unlock()
+}